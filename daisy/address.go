@@ -0,0 +1,41 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	addresses       = map[*Workflow]*addressMap{}
+	addressURLRegex = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?regions/(?P<region>%[1]s)/addresses/(?P<address>%[1]s)$`, rfc1035))
+)
+
+type addressMap struct {
+	baseResourceMap
+}
+
+func initAddressMap(w *Workflow) {
+	am := &addressMap{baseResourceMap: baseResourceMap{w: w, typeName: "address", urlRgx: addressURLRegex}}
+	am.baseResourceMap.deleteFn = am.deleteFn
+	am.init()
+	addresses[w] = am
+}
+
+func (am *addressMap) deleteFn(r *resource) error {
+	m := namedSubexp(addressURLRegex, r.link)
+	return am.w.ComputeClient.DeleteAddress(m["project"], m["region"], m["address"])
+}
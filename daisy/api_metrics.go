@@ -0,0 +1,225 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// APICallStats summarizes the calls made to one API endpoint that ended
+// with the same result code.
+type APICallStats struct {
+	// Endpoint is the HTTP method and the request URL with resource names
+	// replaced by "*", e.g.
+	// "GET www.googleapis.com/compute/v1/projects/*/zones/*/instances/*/serialPort".
+	Endpoint string
+	// Code is the HTTP status code of the responses, 0 if no response was
+	// received.
+	Code int
+	// Count is the number of calls.
+	Count int
+	// TotalLatency and MaxLatency are the total and longest time the calls
+	// took.
+	TotalLatency, MaxLatency time.Duration
+}
+
+type apiCallKey struct {
+	endpoint string
+	code     int
+}
+
+// apiMetrics records the API calls made by a workflow. The zero value is
+// ready to use.
+type apiMetrics struct {
+	m  map[apiCallKey]*APICallStats
+	mx sync.Mutex
+}
+
+func (am *apiMetrics) record(endpoint string, code int, latency time.Duration) {
+	am.mx.Lock()
+	defer am.mx.Unlock()
+	if am.m == nil {
+		am.m = map[apiCallKey]*APICallStats{}
+	}
+	k := apiCallKey{endpoint, code}
+	s, ok := am.m[k]
+	if !ok {
+		s = &APICallStats{Endpoint: endpoint, Code: code}
+		am.m[k] = s
+	}
+	s.Count++
+	s.TotalLatency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// merge adds stats, e.g. recorded by a worker, to the recorded stats.
+func (am *apiMetrics) merge(stats []*APICallStats) {
+	am.mx.Lock()
+	defer am.mx.Unlock()
+	for _, st := range stats {
+		if am.m == nil {
+			am.m = map[apiCallKey]*APICallStats{}
+		}
+		k := apiCallKey{st.Endpoint, st.Code}
+		s, ok := am.m[k]
+		if !ok {
+			s = &APICallStats{Endpoint: st.Endpoint, Code: st.Code}
+			am.m[k] = s
+		}
+		s.Count += st.Count
+		s.TotalLatency += st.TotalLatency
+		if st.MaxLatency > s.MaxLatency {
+			s.MaxLatency = st.MaxLatency
+		}
+	}
+}
+
+// stats returns copies of the recorded stats, sorted by endpoint and code.
+func (am *apiMetrics) stats() []*APICallStats {
+	am.mx.Lock()
+	defer am.mx.Unlock()
+	var result []*APICallStats
+	for _, s := range am.m {
+		c := *s
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Endpoint != result[j].Endpoint {
+			return result[i].Endpoint < result[j].Endpoint
+		}
+		return result[i].Code < result[j].Code
+	})
+	return result
+}
+
+// apiMetricsTransport records the calls made through it in m, counts the
+// failed ones in the Metrics of w, audits the mutating ones, and logs them
+// at VerbosityDebug. Writes of the logs are neither audited nor logged.
+type apiMetricsTransport struct {
+	base http.RoundTripper
+	m    *apiMetrics
+	w    *Workflow
+}
+
+func (t *apiMetricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	latency := time.Since(start)
+	var code int
+	result := fmt.Sprintf("error: %v", err)
+	if err == nil {
+		code = resp.StatusCode
+		result = resp.Status
+	}
+	t.m.record(apiEndpoint(r), code, latency)
+	if t.w != nil && (err != nil || code >= 400) {
+		if m := t.w.metrics(); m != nil {
+			m.countAPIError(apiErrorReason(resp, err))
+		}
+	}
+	if t.w == nil || r.Context().Value(logWritesKey{}) != nil {
+		return resp, err
+	}
+	if rec := newAuditRecord(r, resp, err, start, latency); rec != nil {
+		t.w.audit.add(rec)
+	}
+	if t.w.logger != nil {
+		t.w.logger.Debugf("API call %s %s%s: %s in %s", r.Method, r.URL.Host, r.URL.Path, result, latency)
+	}
+	return resp, err
+}
+
+var apiVersionRgx = regexp.MustCompile(`^v\d|^(alpha|beta)$`)
+
+// apiEndpoint returns the endpoint of r for metrics. Paths of the form
+// [/upload]/<api>/<version>/<collection>/<name>/... have their names
+// replaced by "*", custom methods such as "name:testIamPermissions" keep
+// the method. Other paths, e.g. GCS media downloads, are replaced by "/*".
+func apiEndpoint(r *http.Request) string {
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	var result []string
+	if len(parts) > 0 && parts[0] == "upload" {
+		result, parts = append(result, parts[0]), parts[1:]
+	}
+	switch {
+	case len(parts) > 0 && apiVersionRgx.MatchString(parts[0]):
+		result, parts = append(result, parts[0]), parts[1:]
+	case len(parts) > 1 && apiVersionRgx.MatchString(parts[1]):
+		result, parts = append(result, parts[:2]...), parts[2:]
+	default:
+		return fmt.Sprintf("%s %s/*", r.Method, r.URL.Host)
+	}
+	for i := 0; i < len(parts); i++ {
+		result = append(result, parts[i])
+		switch parts[i] {
+		case "global", "aggregated":
+			// Scopes without a name.
+			continue
+		}
+		if i++; i < len(parts) {
+			name := "*"
+			if j := strings.LastIndex(parts[i], ":"); j != -1 {
+				name += parts[i][j:]
+			}
+			result = append(result, name)
+		}
+	}
+	return fmt.Sprintf("%s %s/%s", r.Method, r.URL.Host, strings.Join(result, "/"))
+}
+
+// APIClientOptions dials an HTTP client using opts and returns the client
+// options to create an API client with it. The calls made by the API
+// client are recorded in w's API metrics, see RunResult.APICalls.
+func (w *Workflow) APIClientOptions(ctx context.Context, opts ...option.ClientOption) ([]option.ClientOption, error) {
+	o := []option.ClientOption{option.WithScopes(compute.CloudPlatformScope)}
+	hc, ep, err := transport.NewHTTPClient(ctx, append(o, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	mhc := *hc
+	mhc.Transport = w.apiMetricsTransport(hc.Transport)
+	result := []option.ClientOption{option.WithHTTPClient(&mhc)}
+	if ep != "" {
+		result = append(result, option.WithEndpoint(ep))
+	}
+	return result, nil
+}
+
+// apiMetricsTransport wraps rt to record calls in w's API metrics. OAuth2
+// transports are kept outermost, the compute client inspects them to decide
+// whether to retry failed calls.
+func (w *Workflow) apiMetricsTransport(rt http.RoundTripper) http.RoundTripper {
+	if t, ok := rt.(*oauth2.Transport); ok {
+		return &oauth2.Transport{Source: t.Source, Base: w.apiMetricsTransport(t.Base)}
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &apiMetricsTransport{base: rt, m: &w.root().apiCalls, w: w.root()}
+}
@@ -0,0 +1,97 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"golang.org/x/oauth2"
+)
+
+func TestAPIEndpoint(t *testing.T) {
+	tests := []struct {
+		method, url, want string
+	}{
+		{"GET", "https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/i/serialPort?port=1", "GET www.googleapis.com/compute/v1/projects/*/zones/*/instances/*/serialPort"},
+		{"POST", "https://www.googleapis.com/compute/v1/projects/p/global/images", "POST www.googleapis.com/compute/v1/projects/*/global/images"},
+		{"DELETE", "https://www.googleapis.com/compute/v1/projects/p/global/images/i", "DELETE www.googleapis.com/compute/v1/projects/*/global/images/*"},
+		{"GET", "https://www.googleapis.com/compute/beta/projects/p/aggregated/disks", "GET www.googleapis.com/compute/beta/projects/*/aggregated/disks"},
+		{"POST", "https://cloudresourcemanager.googleapis.com/v1/projects/p:testIamPermissions", "POST cloudresourcemanager.googleapis.com/v1/projects/*:testIamPermissions"},
+		{"GET", "https://www.googleapis.com/storage/v1/b/bkt/o/dir%2Fobj", "GET www.googleapis.com/storage/v1/b/*/o/*"},
+		{"POST", "https://www.googleapis.com/upload/storage/v1/b/bkt/o?uploadType=multipart", "POST www.googleapis.com/upload/storage/v1/b/*/o"},
+		{"GET", "https://storage.googleapis.com/bkt/dir/obj", "GET storage.googleapis.com/*"},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest(tt.method, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := apiEndpoint(r); got != tt.want {
+			t.Errorf("unexpected endpoint for %s %s, got: %q, want: %q", tt.method, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestAPIMetricsTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/serialPort") {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	hc := &http.Client{Transport: &apiMetricsTransport{base: http.DefaultTransport, m: &sw.root().apiCalls}}
+	for _, p := range []string{"serialPort", "serialPort", "serialPort", ""} {
+		resp, err := hc.Get(ts.URL + "/compute/v1/projects/p/zones/z/instances/i/" + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	got := w.Result().APICalls
+	for _, s := range got {
+		if s.TotalLatency < s.MaxLatency || s.MaxLatency <= 0 {
+			t.Errorf("unexpected latencies for %q: total %s, max %s", s.Endpoint, s.TotalLatency, s.MaxLatency)
+		}
+		s.TotalLatency, s.MaxLatency = 0, 0
+	}
+	want := []*APICallStats{
+		{Endpoint: "GET " + host + "/compute/v1/projects/*/zones/*/instances/*", Code: http.StatusOK, Count: 1},
+		{Endpoint: "GET " + host + "/compute/v1/projects/*/zones/*/instances/*/serialPort", Code: http.StatusTooManyRequests, Count: 3},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("API calls do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestWorkflowAPIMetricsTransport(t *testing.T) {
+	w := testWorkflow()
+	got, ok := w.apiMetricsTransport(&oauth2.Transport{}).(*oauth2.Transport)
+	if !ok {
+		t.Fatalf("OAuth2 transport not kept outermost, got: %T", got)
+	}
+	if mt, ok := got.Base.(*apiMetricsTransport); !ok || mt.base != http.DefaultTransport || mt.m != &w.apiCalls {
+		t.Errorf("unexpected OAuth2 base transport: %#v", got.Base)
+	}
+}
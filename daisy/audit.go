@@ -0,0 +1,189 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditFile is the object in the logs path of the top level workflow that
+// the audit of a run is written to.
+const auditFile = "audit.json"
+
+// auditRecord is a mutating compute or storage API call made by a
+// workflow.
+type auditRecord struct {
+	// Time is when the call was made.
+	Time time.Time
+	// Method is the HTTP method of the call, e.g. "POST".
+	Method string
+	// API is "compute" or "storage".
+	API string
+	// Resource is the path of the resource the call is about, e.g.
+	// "projects/p/zones/z/instances/i", or "b/bucket/o/object" for
+	// storage.
+	Resource string
+	// Project is the project of the resource, if known.
+	Project string `json:",omitempty"`
+	// Operation is the name of the compute operation the call started.
+	Operation string `json:",omitempty"`
+	// Duration is how long the call took.
+	Duration time.Duration
+	// Code is the HTTP status code of the response, 0 if no response was
+	// received. Outcome is its status, e.g. "200 OK", or the error of the
+	// call.
+	Code    int
+	Outcome string
+}
+
+// auditLog records the mutating API calls made by a workflow. The zero
+// value is ready to use.
+type auditLog struct {
+	records []*auditRecord
+	mx      sync.Mutex
+}
+
+func (al *auditLog) add(rec *auditRecord) {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+	al.records = append(al.records, rec)
+}
+
+func (al *auditLog) list() []*auditRecord {
+	al.mx.Lock()
+	defer al.mx.Unlock()
+	return append([]*auditRecord{}, al.records...)
+}
+
+// newAuditRecord returns the audit record of the call r, made at start,
+// that took d and returned resp and err, or nil if r isn't a mutating
+// compute or storage call. The request body of compute inserts is read to
+// get the name of the resource, and the response body to get the name of
+// the operation. The response body is replaced.
+func newAuditRecord(r *http.Request, resp *http.Response, err error, start time.Time, d time.Duration) *auditRecord {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	if len(parts) > 0 && parts[0] == "upload" {
+		parts = parts[1:]
+	}
+	if len(parts) < 2 || (parts[0] != "compute" && parts[0] != "storage") || !apiVersionRgx.MatchString(parts[1]) {
+		return nil
+	}
+	rec := &auditRecord{Time: start, Method: r.Method, API: parts[0], Resource: strings.Join(parts[2:], "/"), Duration: d}
+	for i := 2; i+1 < len(parts); i++ {
+		if parts[i] == "projects" {
+			rec.Project = parts[i+1]
+			break
+		}
+	}
+	if rec.Project == "" {
+		rec.Project = r.URL.Query().Get("project")
+	}
+	if name := insertedName(r); name != "" {
+		rec.Resource += "/" + name
+	}
+
+	if err != nil {
+		rec.Outcome = fmt.Sprintf("error: %v", err)
+		return rec
+	}
+	rec.Code, rec.Outcome = resp.StatusCode, resp.Status
+	if rec.API == "compute" && resp.StatusCode < 300 {
+		if body, err := readBody(resp); err == nil {
+			var op struct {
+				Kind string `json:"kind"`
+				Name string `json:"name"`
+			}
+			if json.Unmarshal(body, &op) == nil && op.Kind == "compute#operation" {
+				rec.Operation = op.Name
+			}
+		}
+	}
+	return rec
+}
+
+// insertedName returns the name of the resource r inserts, from the "name"
+// parameter of storage uploads or the JSON body of compute inserts, if any.
+func insertedName(r *http.Request) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return name
+	}
+	if r.Method != http.MethodPost || r.GetBody == nil {
+		return ""
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	var res struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		return ""
+	}
+	return res.Name
+}
+
+// writeAudit writes the mutating API calls made by the workflow's clients
+// to auditFile in its logs path, for review of what a run did. Writes of
+// the logs aren't recorded. Errors are logged, the run is over.
+func (w *Workflow) writeAudit() {
+	if !w.gcsLogging || !w.scratchClaimed || w.bucket == "" || w.StorageClient == nil {
+		return
+	}
+	records := w.audit.list()
+	if records == nil {
+		records = []*auditRecord{}
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		w.logger.Errorf("Error marshalling audit: %v", err)
+		return
+	}
+	obj := path.Join(w.logsPath, auditFile)
+	wc := w.StorageClient.Bucket(w.bucket).Object(obj).NewWriter(logWritesContext(context.Background()))
+	wc.ContentType = "application/json"
+	if _, err := wc.Write(b); err != nil {
+		wc.Close()
+		w.logger.Errorf("Error writing audit to gs://%s/%s: %v", w.bucket, obj, err)
+		return
+	}
+	if err := wc.Close(); err != nil {
+		w.logger.Errorf("Error writing audit to gs://%s/%s: %v", w.bucket, obj, err)
+		return
+	}
+	w.logger.Printf("Wrote audit of %d API calls to gs://%s/%s", len(records), w.bucket, obj)
+}
+
+// readBody reads the body of resp and replaces it, so it can be read again.
+func readBody(resp *http.Response) ([]byte, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, err
+}
@@ -0,0 +1,101 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestAudit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasPrefix(r.URL.Path, "/compute/"):
+			w.Write([]byte(`{"kind":"compute#operation","name":"operation-1"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	hc := &http.Client{Transport: sw.apiMetricsTransport(http.DefaultTransport)}
+	calls := []struct {
+		ctx          context.Context
+		method, path string
+		body         string
+	}{
+		{context.Background(), http.MethodPost, "/compute/v1/projects/p/zones/z/instances", `{"name":"i"}`},
+		{context.Background(), http.MethodGet, "/compute/v1/projects/p/zones/z/instances/i", ""},
+		{context.Background(), http.MethodDelete, "/compute/v1/projects/p/zones/z/disks/d", ""},
+		{context.Background(), http.MethodPost, "/storage/v1/b?project=p", `{"name":"bkt"}`},
+		{context.Background(), http.MethodPost, "/upload/storage/v1/b/bkt/o?uploadType=multipart&name=obj", "data"},
+		{context.Background(), http.MethodPost, "/v1/projects/p:setIamPolicy", "{}"},
+		// Writes of the logs aren't audited.
+		{logWritesContext(context.Background()), http.MethodPost, "/upload/storage/v1/b/bkt/o?uploadType=multipart&name=daisy.log", "log"},
+	}
+	for _, c := range calls {
+		req, err := http.NewRequest(c.method, ts.URL+c.path, bytes.NewBufferString(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := hc.Do(req.WithContext(c.ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	got := w.audit.list()
+	for _, rec := range got {
+		if rec.Time.IsZero() || rec.Duration <= 0 {
+			t.Errorf("audit record of %s %q has no time or duration", rec.Method, rec.Resource)
+		}
+		rec.Time, rec.Duration = time.Time{}, 0
+	}
+	want := []*auditRecord{
+		{Method: "POST", API: "compute", Resource: "projects/p/zones/z/instances/i", Project: "p", Operation: "operation-1", Code: 200, Outcome: "200 OK"},
+		{Method: "DELETE", API: "compute", Resource: "projects/p/zones/z/disks/d", Project: "p", Code: 404, Outcome: "404 Not Found"},
+		{Method: "POST", API: "storage", Resource: "b/bkt", Project: "p", Code: 200, Outcome: "200 OK"},
+		{Method: "POST", API: "storage", Resource: "b/bkt/o/obj", Code: 200, Outcome: "200 OK"},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("audit does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestWriteAudit(t *testing.T) {
+	w := testWorkflow()
+	w.gcsLogging = true
+	w.scratchClaimed = true
+	w.bucket = "bucket"
+	w.logsPath = "logs"
+	w.audit.add(&auditRecord{Method: "DELETE", API: "compute", Resource: "projects/p/zones/z/disks/d", Project: "p", Code: 200, Outcome: "200 OK"})
+
+	w.writeAudit()
+	if !strIn("logs/audit.json", testGCSObjs) {
+		t.Errorf("audit not written to GCS, objects: %q", testGCSObjs)
+	}
+}
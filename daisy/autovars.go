@@ -0,0 +1,62 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+)
+
+// AddAutovar adds an autovar, substituted for ${key} in w and in its
+// subworkflows and included workflows like the built in autovars, e.g. a
+// build number or a git commit. Built in autovars can't be replaced.
+func (w *Workflow) AddAutovar(key, value string) {
+	w.AddAutovarFunc(key, func(*Workflow) (string, error) { return value, nil })
+}
+
+// AddAutovarFunc adds an autovar like AddAutovar, whose value is returned by
+// f when w, or one of its subworkflows, is populated. f is called with the
+// workflow being populated, after its Vars and built in autovars are
+// resolved. An error from f fails populating the workflow.
+func (w *Workflow) AddAutovarFunc(key string, f func(*Workflow) (string, error)) {
+	if w.customAutovars == nil {
+		w.customAutovars = map[string]func(*Workflow) (string, error){}
+	}
+	w.customAutovars[key] = f
+}
+
+// populateCustomAutovars adds the autovars added to w and its parents to the
+// autovars of w. Autovars added to a workflow take precedence over those
+// added to its parents.
+func (w *Workflow) populateCustomAutovars() error {
+	custom := map[string]func(*Workflow) (string, error){}
+	for wf := w; wf != nil; wf = wf.parent {
+		for k, f := range wf.customAutovars {
+			if _, ok := custom[k]; !ok {
+				custom[k] = f
+			}
+		}
+	}
+	for k, f := range custom {
+		if _, ok := w.autovars[k]; ok || k == "PROJECTNUMBER" {
+			return fmt.Errorf("can't add autovar %q, it is built in", k)
+		}
+		v, err := f(w)
+		if err != nil {
+			return fmt.Errorf("error getting the value of autovar %q: %v", k, err)
+		}
+		w.autovars[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestPopulateCustomAutovars(t *testing.T) {
+	w := testWorkflow()
+	w.AddAutovar("BUILD", "123")
+	w.AddAutovar("SHA", "abc")
+	w.AddAutovarFunc("WFNAME", func(w *Workflow) (string, error) { return w.Name, nil })
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	sw.AddAutovar("SHA", "def")
+
+	sw.autovars = map[string]string{"NAME": "sub"}
+	if err := sw.populateCustomAutovars(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"NAME": "sub", "BUILD": "123", "SHA": "def", "WFNAME": "sub"}
+	if diff := pretty.Compare(sw.autovars, want); diff != "" {
+		t.Errorf("autovars do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	tests := []struct {
+		desc string
+		key  string
+		f    func(*Workflow) (string, error)
+	}{
+		{"built in case", "NAME", func(*Workflow) (string, error) { return "", nil }},
+		{"lazy built in case", "PROJECTNUMBER", func(*Workflow) (string, error) { return "", nil }},
+		{"func error case", "FOO", func(*Workflow) (string, error) { return "", errors.New("fail") }},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.autovars = map[string]string{"NAME": "wf"}
+		w.AddAutovarFunc(tt.key, tt.f)
+		if err := w.populateCustomAutovars(); err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+	}
+}
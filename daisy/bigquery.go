@@ -0,0 +1,185 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// Step statuses recorded in BigQuery.
+const (
+	stepStatusSucceeded = "SUCCEEDED"
+	stepStatusFailed    = "FAILED"
+	stepStatusCanceled  = "CANCELED"
+)
+
+var bigQueryTableRgx = regexp.MustCompile(`^((?P<project>[^:.]+)[:.])?(?P<dataset>\w+)\.(?P<table>[\w-]+)$`)
+
+// stepResultsSchema is the schema of Workflow.BigQueryTable, with one row
+// per step run. Fields are only ever added, so tables created by older
+// versions keep working.
+var stepResultsSchema = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+	{Name: "run_id", Type: "STRING", Mode: "REQUIRED", Description: "ID of the workflow run, shared by its subworkflows."},
+	{Name: "workflow", Type: "STRING", Mode: "REQUIRED", Description: "Name of the workflow running the step, prefixed by the names of its parents."},
+	{Name: "project", Type: "STRING", Mode: "NULLABLE", Description: "Project of the workflow."},
+	{Name: "username", Type: "STRING", Mode: "NULLABLE", Description: "User running the workflow."},
+	{Name: "step", Type: "STRING", Mode: "REQUIRED", Description: "Name of the step."},
+	{Name: "step_type", Type: "STRING", Mode: "NULLABLE", Description: "Type of the step, e.g. CreateInstances."},
+	{Name: "status", Type: "STRING", Mode: "REQUIRED", Description: "SUCCEEDED, FAILED or CANCELED."},
+	{Name: "error", Type: "STRING", Mode: "NULLABLE", Description: "Error of a FAILED step."},
+	{Name: "start_time", Type: "TIMESTAMP", Mode: "REQUIRED", Description: "Time the step started."},
+	{Name: "end_time", Type: "TIMESTAMP", Mode: "REQUIRED", Description: "Time the step returned."},
+	{Name: "duration_seconds", Type: "FLOAT", Mode: "REQUIRED", Description: "Run time of the step."},
+}}
+
+func newBigQueryClient(ctx context.Context, oauthPath string) (*bigquery.Service, error) {
+	hc, _, err := transport.NewHTTPClient(ctx, option.WithScopes(bigquery.BigqueryScope), option.WithCredentialsFile(oauthPath))
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	return bigquery.New(hc)
+}
+
+// parseBigQueryTable parses a "project.dataset.table", "project:dataset.table"
+// or "dataset.table" reference, tables without project are in project.
+func parseBigQueryTable(s, project string) (*bigquery.TableReference, error) {
+	m := namedSubexp(bigQueryTableRgx, s)
+	if m == nil {
+		return nil, fmt.Errorf("bad BigQueryTable %q, want \"[project.]dataset.table\"", s)
+	}
+	return &bigquery.TableReference{ProjectId: strOr(m["project"], project), DatasetId: m["dataset"], TableId: m["table"]}, nil
+}
+
+// ensureStepResultsTable creates the table step results are written to, or
+// adds the fields of stepResultsSchema it misses.
+func ensureStepResultsTable(bq *bigquery.Service, ref *bigquery.TableReference) error {
+	t, err := bq.Tables.Get(ref.ProjectId, ref.DatasetId, ref.TableId).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		t = &bigquery.Table{
+			TableReference:   ref,
+			Description:      "Daisy workflow step results.",
+			Schema:           stepResultsSchema,
+			TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "start_time"},
+		}
+		if _, err := bq.Tables.Insert(ref.ProjectId, ref.DatasetId, t).Do(); err != nil {
+			return fmt.Errorf("error creating BigQuery table %s.%s.%s: %v", ref.ProjectId, ref.DatasetId, ref.TableId, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting BigQuery table %s.%s.%s: %v", ref.ProjectId, ref.DatasetId, ref.TableId, err)
+	}
+
+	schema := &bigquery.TableSchema{}
+	if t.Schema != nil {
+		schema.Fields = append(schema.Fields, t.Schema.Fields...)
+	}
+	have := map[string]*bigquery.TableFieldSchema{}
+	for _, f := range schema.Fields {
+		have[f.Name] = f
+	}
+	var missing bool
+	for _, f := range stepResultsSchema.Fields {
+		hf, ok := have[f.Name]
+		if !ok {
+			// Only NULLABLE fields can be added to existing tables.
+			schema.Fields = append(schema.Fields, &bigquery.TableFieldSchema{Name: f.Name, Type: f.Type, Mode: "NULLABLE", Description: f.Description})
+			missing = true
+			continue
+		}
+		if hf.Type != f.Type {
+			return fmt.Errorf("BigQuery table %s.%s.%s field %q has type %s, want %s", ref.ProjectId, ref.DatasetId, ref.TableId, f.Name, hf.Type, f.Type)
+		}
+	}
+	if !missing {
+		return nil
+	}
+	if _, err := bq.Tables.Patch(ref.ProjectId, ref.DatasetId, ref.TableId, &bigquery.Table{Schema: schema}).Do(); err != nil {
+		return fmt.Errorf("error updating schema of BigQuery table %s.%s.%s: %v", ref.ProjectId, ref.DatasetId, ref.TableId, err)
+	}
+	return nil
+}
+
+// recordStepResult records the result of a run of s, which was queued at
+// queued, started at start and returned err, for the RunResult and streams
+// it to the BigQuery table of the root workflow, if set. Rows are inserted
+// in the background, see waitStepResults.
+func (w *Workflow) recordStepResult(s *Step, queued, start time.Time, err error) {
+	end := time.Now()
+	w.addStepResult(s, queued, start, end, err)
+	root := w.root()
+	if root.bigQueryClient == nil {
+		return
+	}
+	status := stepStatusSucceeded
+	var errMsg interface{}
+	if err != nil {
+		status = stepStatusFailed
+		errMsg = root.redactor.redact(err.Error())
+	} else {
+		select {
+		case <-w.Cancel:
+			status = stepStatusCanceled
+		default:
+		}
+	}
+	name := w.qualifiedName()
+	row := &bigquery.TableDataInsertAllRequestRows{
+		// Retried inserts with the same ID are deduplicated.
+		InsertId: fmt.Sprintf("%s/%s/%s", root.id, name, s.name),
+		Json: map[string]bigquery.JsonValue{
+			"run_id":           root.id,
+			"workflow":         name,
+			"project":          w.Project,
+			"username":         w.username,
+			"step":             s.name,
+			"step_type":        s.typeName(),
+			"status":           status,
+			"error":            errMsg,
+			"start_time":       start.UTC().Format(time.RFC3339Nano),
+			"end_time":         end.UTC().Format(time.RFC3339Nano),
+			"duration_seconds": end.Sub(start).Seconds(),
+		},
+	}
+
+	root.bigQueryWG.Add(1)
+	go func() {
+		defer root.bigQueryWG.Done()
+		ref := root.bigQueryTable
+		req := &bigquery.TableDataInsertAllRequest{Rows: []*bigquery.TableDataInsertAllRequestRows{row}}
+		resp, err := root.bigQueryClient.Tabledata.InsertAll(ref.ProjectId, ref.DatasetId, ref.TableId, req).Do()
+		if err == nil && len(resp.InsertErrors) != 0 && len(resp.InsertErrors[0].Errors) != 0 {
+			err = fmt.Errorf("%s", resp.InsertErrors[0].Errors[0].Message)
+		}
+		if err != nil {
+			w.logger.Errorf("Error writing result of step %q to BigQuery: %v", s.name, err)
+		}
+	}()
+}
+
+// waitStepResults waits for the step results of w to be written to
+// BigQuery.
+func (w *Workflow) waitStepResults() {
+	w.root().bigQueryWG.Wait()
+}
@@ -0,0 +1,203 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/bigquery/v2"
+)
+
+func TestParseBigQueryTable(t *testing.T) {
+	tests := []struct {
+		desc, input string
+		want        *bigquery.TableReference
+		shouldErr   bool
+	}{
+		{"dot case", "p.d.t", &bigquery.TableReference{ProjectId: "p", DatasetId: "d", TableId: "t"}, false},
+		{"colon case", "p-1:d_1.t-1", &bigquery.TableReference{ProjectId: "p-1", DatasetId: "d_1", TableId: "t-1"}, false},
+		{"no project case", "d.t", &bigquery.TableReference{ProjectId: "default", DatasetId: "d", TableId: "t"}, false},
+		{"no dataset case", "t", nil, true},
+		{"too many parts case", "a.b.c.d", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBigQueryTable(tt.input, "default")
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: table reference does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func newTestBigQueryClient(t *testing.T, h http.HandlerFunc) (*bigquery.Service, func()) {
+	ts := httptest.NewServer(h)
+	bq, err := bigquery.New(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bq.BasePath = ts.URL + "/"
+	return bq, ts.Close
+}
+
+func TestEnsureStepResultsTable(t *testing.T) {
+	ref := &bigquery.TableReference{ProjectId: "p", DatasetId: "d", TableId: "t"}
+	tablePath := "/projects/p/datasets/d/tables/t"
+	var stringFields []*bigquery.TableFieldSchema
+	for _, f := range stepResultsSchema.Fields {
+		stringFields = append(stringFields, &bigquery.TableFieldSchema{Name: f.Name, Type: "STRING"})
+	}
+	old := &bigquery.Table{Schema: &bigquery.TableSchema{Fields: stepResultsSchema.Fields[:2]}}
+	var added []*bigquery.TableFieldSchema
+	for _, f := range stepResultsSchema.Fields[2:] {
+		added = append(added, &bigquery.TableFieldSchema{Name: f.Name, Type: f.Type, Mode: "NULLABLE", Description: f.Description})
+	}
+
+	tests := []struct {
+		desc      string
+		getCode   int
+		get       *bigquery.Table
+		want      []string
+		wantTable *bigquery.Table
+		shouldErr bool
+	}{
+		{"create case", http.StatusNotFound, nil, []string{"GET " + tablePath, "POST /projects/p/datasets/d/tables"}, &bigquery.Table{
+			TableReference:   ref,
+			Description:      "Daisy workflow step results.",
+			Schema:           stepResultsSchema,
+			TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "start_time"},
+		}, false},
+		{"up to date case", http.StatusOK, &bigquery.Table{Schema: stepResultsSchema}, []string{"GET " + tablePath}, nil, false},
+		{"missing fields case", http.StatusOK, old, []string{"GET " + tablePath, "PATCH " + tablePath}, &bigquery.Table{
+			Schema: &bigquery.TableSchema{Fields: append(append([]*bigquery.TableFieldSchema{}, stepResultsSchema.Fields[:2]...), added...)},
+		}, false},
+		{"field type mismatch case", http.StatusOK, &bigquery.Table{Schema: &bigquery.TableSchema{Fields: stringFields}}, []string{"GET " + tablePath}, nil, true},
+		{"get error case", http.StatusForbidden, nil, []string{"GET " + tablePath}, nil, true},
+	}
+	for _, tt := range tests {
+		var got []string
+		var gotTable *bigquery.Table
+		bq, closeFn := newTestBigQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, r.Method+" "+r.URL.Path)
+			if r.Method == "GET" {
+				w.WriteHeader(tt.getCode)
+				if tt.get != nil {
+					json.NewEncoder(w).Encode(tt.get)
+				}
+				return
+			}
+			gotTable = &bigquery.Table{}
+			if err := json.NewDecoder(r.Body).Decode(gotTable); err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(`{}`))
+		})
+
+		err := ensureStepResultsTable(bq, ref)
+		closeFn()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: API calls do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if diff := pretty.Compare(gotTable, tt.wantTable); diff != "" {
+			t.Errorf("%s: written table does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestRecordStepResult(t *testing.T) {
+	var mx sync.Mutex
+	var gotPaths []string
+	var got []map[string]interface{}
+	bq, closeFn := newTestBigQueryClient(t, func(w http.ResponseWriter, r *http.Request) {
+		req := &bigquery.TableDataInsertAllRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		gotPaths = append(gotPaths, r.URL.Path)
+		for _, row := range req.Rows {
+			m := map[string]interface{}{"insertId": row.InsertId}
+			for k, v := range row.Json {
+				m[k] = v
+			}
+			got = append(got, m)
+		}
+		w.Write([]byte(`{}`))
+	})
+	defer closeFn()
+
+	w := testWorkflow()
+	w.username = "user"
+	w.bigQueryClient = bq
+	w.bigQueryTable = &bigquery.TableReference{ProjectId: "p", DatasetId: "d", TableId: "t"}
+	w.Steps = map[string]*Step{
+		"ok": {name: "ok", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"fail": {name: "fail", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			return errors.New("fail s3cret")
+		}}},
+	}
+	w.Dependencies = map[string][]string{"fail": {"ok"}}
+	w.redactor.add("s3cret")
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("should have returned an error")
+	}
+	w.waitStepResults()
+
+	sort.Slice(got, func(i, j int) bool { return got[i]["step"].(string) > got[j]["step"].(string) })
+	for _, row := range got {
+		for _, k := range []string{"start_time", "end_time"} {
+			if _, err := time.Parse(time.RFC3339Nano, row[k].(string)); err != nil {
+				t.Errorf("step %q: bad %s: %v", row["step"], k, err)
+			}
+			delete(row, k)
+		}
+		if d, ok := row["duration_seconds"].(float64); !ok || d < 0 {
+			t.Errorf("step %q: bad duration_seconds: %v", row["step"], row["duration_seconds"])
+		}
+		delete(row, "duration_seconds")
+	}
+	want := []map[string]interface{}{
+		{"insertId": "abcdef/test-wf/ok", "run_id": "abcdef", "workflow": testWf, "project": testProject, "username": "user", "step": "ok", "step_type": "mockStep", "status": "SUCCEEDED", "error": nil},
+		{"insertId": "abcdef/test-wf/fail", "run_id": "abcdef", "workflow": testWf, "project": testProject, "username": "user", "step": "fail", "step_type": "mockStep", "status": "FAILED", "error": `step "fail" run error: fail ` + redactedText},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("step results do not match expectation: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(gotPaths, []string{"/projects/p/datasets/d/tables/t/insertAll", "/projects/p/datasets/d/tables/t/insertAll"}); diff != "" {
+		t.Errorf("API calls do not match expectation: (-got +want)\n%s", diff)
+	}
+}
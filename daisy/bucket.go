@@ -0,0 +1,61 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	buckets = map[*Workflow]*bucketMap{}
+	// bucketNameRgx matches GCS bucket names without dots.
+	bucketNameRgx = regexp.MustCompile(`^[a-z0-9][-_a-z0-9]{1,61}[a-z0-9]$`)
+)
+
+type bucketMap struct {
+	baseResourceMap
+}
+
+func initBucketMap(w *Workflow) {
+	bm := &bucketMap{baseResourceMap: baseResourceMap{w: w, typeName: "bucket"}}
+	bm.baseResourceMap.deleteFn = bm.deleteFn
+	bm.init()
+	buckets[w] = bm
+}
+
+func (bm *bucketMap) deleteFn(r *resource) error {
+	return deleteBucket(context.Background(), bm.w.StorageClient, r.real)
+}
+
+// deleteBucket deletes the objects in bucket name, then the bucket, as GCS
+// only deletes empty buckets.
+func deleteBucket(ctx context.Context, client *storage.Client, name string) error {
+	bkt := client.Bucket(name)
+	it := bkt.Objects(ctx, nil)
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return fmt.Errorf("error listing gs://%s: %v", name, err)
+		}
+		if err := bkt.Object(objAttr.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return fmt.Errorf("error deleting gs://%s/%s: %v", name, objAttr.Name, err)
+		}
+	}
+	return bkt.Delete(ctx)
+}
@@ -0,0 +1,258 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepBuilder wires up a step added by one of the Workflow New*Step
+// methods, for programs that build workflows in Go rather than JSON:
+//
+//	w.NewCreateDisksStep("create-disks", &CreateDisk{Disk: disk})
+//	w.NewCreateInstancesStep("create-instance", &CreateInstance{Instance: inst}).
+//		DependsOn("create-disks").
+//		Timeout("30m")
+//
+// StepBuilder methods do nothing once one of them failed. The first error,
+// e.g. of a duplicate step name, is returned by Err and by the Validate and
+// Run of the workflow.
+type StepBuilder struct {
+	w   *Workflow
+	s   *Step
+	err error
+}
+
+// newStepBuilder adds a step named name to w, set up by set.
+func (w *Workflow) newStepBuilder(name string, set func(*Step)) *StepBuilder {
+	b := &StepBuilder{w: w}
+	if b.s, b.err = w.NewStep(name); b.err != nil {
+		w.addBuildError(b.err)
+		return b
+	}
+	set(b.s)
+	return b
+}
+
+// addBuildError keeps the first StepBuilder error of w.
+func (w *Workflow) addBuildError(err error) {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	if w.buildErr == nil {
+		w.buildErr = err
+	}
+}
+
+// buildError returns the first StepBuilder error of w.
+func (w *Workflow) buildError() error {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	return w.buildErr
+}
+
+// fail keeps err as the error of b and of its workflow.
+func (b *StepBuilder) fail(err error) *StepBuilder {
+	b.err = err
+	b.w.addBuildError(err)
+	return b
+}
+
+// DependsOn makes the step depend on the steps named steps, which must
+// already be in the workflow.
+func (b *StepBuilder) DependsOn(steps ...string) *StepBuilder {
+	if b.err != nil {
+		return b
+	}
+	if err := b.w.AddDependency(b.s.name, steps...); err != nil {
+		return b.fail(err)
+	}
+	return b
+}
+
+// Timeout sets the time to wait for the step to complete, e.g. "30m".
+func (b *StepBuilder) Timeout(timeout string) *StepBuilder {
+	if b.err != nil {
+		return b
+	}
+	if _, err := time.ParseDuration(timeout); err != nil {
+		return b.fail(fmt.Errorf("step %q: bad timeout %q: %v", b.s.name, timeout, err))
+	}
+	b.s.Timeout = timeout
+	return b
+}
+
+// RunAlways runs the step even if the workflow fails or is canceled before
+// it would run.
+func (b *StepBuilder) RunAlways() *StepBuilder {
+	if b.err == nil {
+		b.s.RunAlways = true
+	}
+	return b
+}
+
+// ContinueOnError keeps the workflow running if the step fails.
+func (b *StepBuilder) ContinueOnError() *StepBuilder {
+	if b.err == nil {
+		b.s.ContinueOnError = true
+	}
+	return b
+}
+
+// Project sets the project of the step, overriding the workflow's.
+func (b *StepBuilder) Project(project string) *StepBuilder {
+	if b.err == nil {
+		b.s.Project = project
+	}
+	return b
+}
+
+// Zone sets the zone of the step, overriding the workflow's.
+func (b *StepBuilder) Zone(zone string) *StepBuilder {
+	if b.err == nil {
+		b.s.Zone = zone
+	}
+	return b
+}
+
+// Name returns the name of the step, for use in DependsOn.
+func (b *StepBuilder) Name() string {
+	if b.s == nil {
+		return ""
+	}
+	return b.s.name
+}
+
+// Step returns the built step, or the first error building it.
+func (b *StepBuilder) Step() (*Step, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.s, nil
+}
+
+// Err returns the first error building the step.
+func (b *StepBuilder) Err() error {
+	return b.err
+}
+
+// NewCreateAddressesStep adds a CreateAddresses step to w.
+func (w *Workflow) NewCreateAddressesStep(name string, addresses ...*CreateAddress) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateAddresses = (*CreateAddresses)(&addresses) })
+}
+
+// NewCreateBucketsStep adds a CreateBuckets step to w.
+func (w *Workflow) NewCreateBucketsStep(name string, buckets ...*CreateBucket) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateBuckets = (*CreateBuckets)(&buckets) })
+}
+
+// NewCreateDisksStep adds a CreateDisks step to w.
+func (w *Workflow) NewCreateDisksStep(name string, disks ...*CreateDisk) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateDisks = (*CreateDisks)(&disks) })
+}
+
+// NewCreateImagesStep adds a CreateImages step to w.
+func (w *Workflow) NewCreateImagesStep(name string, images ...*CreateImage) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateImages = (*CreateImages)(&images) })
+}
+
+// NewCreateInstancesStep adds a CreateInstances step to w.
+func (w *Workflow) NewCreateInstancesStep(name string, instances ...*CreateInstance) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateInstances = (*CreateInstances)(&instances) })
+}
+
+// NewCreateNetworksStep adds a CreateNetworks step to w.
+func (w *Workflow) NewCreateNetworksStep(name string, networks ...*CreateNetwork) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateNetworks = (*CreateNetworks)(&networks) })
+}
+
+// NewCreateResourcePoliciesStep adds a CreateResourcePolicies step to w.
+func (w *Workflow) NewCreateResourcePoliciesStep(name string, policies ...*CreateResourcePolicy) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateResourcePolicies = (*CreateResourcePolicies)(&policies) })
+}
+
+// NewCreateSnapshotsStep adds a CreateSnapshots step to w.
+func (w *Workflow) NewCreateSnapshotsStep(name string, snapshots ...*CreateSnapshot) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CreateSnapshots = (*CreateSnapshots)(&snapshots) })
+}
+
+// NewCopyGCSObjectsStep adds a CopyGCSObjects step to w.
+func (w *Workflow) NewCopyGCSObjectsStep(name string, objects ...CopyGCSObject) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.CopyGCSObjects = (*CopyGCSObjects)(&objects) })
+}
+
+// NewDeleteResourcesStep adds a DeleteResources step to w.
+func (w *Workflow) NewDeleteResourcesStep(name string, dr *DeleteResources) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.DeleteResources = dr })
+}
+
+// NewGrantRolesStep adds a GrantRoles step to w.
+func (w *Workflow) NewGrantRolesStep(name string, grants ...*GrantRole) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.GrantRoles = (*GrantRoles)(&grants) })
+}
+
+// NewPruneImagesStep adds a PruneImages step to w.
+func (w *Workflow) NewPruneImagesStep(name string, prunes ...*PruneImage) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.PruneImages = (*PruneImages)(&prunes) })
+}
+
+// NewPublishImagesStep adds a PublishImages step to w.
+func (w *Workflow) NewPublishImagesStep(name string, publishes ...*PublishImage) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.PublishImages = (*PublishImages)(&publishes) })
+}
+
+// NewVerifyContentHashesStep adds a VerifyContentHashes step to w.
+func (w *Workflow) NewVerifyContentHashesStep(name string, hashes ...*ContentHash) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.VerifyContentHashes = (*VerifyContentHashes)(&hashes) })
+}
+
+// NewWaitForGCSObjectStep adds a WaitForGCSObject step to w.
+func (w *Workflow) NewWaitForGCSObjectStep(name string, wo *WaitForGCSObject) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.WaitForGCSObject = wo })
+}
+
+// NewWaitForInstancesSignalStep adds a WaitForInstancesSignal step to w.
+func (w *Workflow) NewWaitForInstancesSignalStep(name string, signals ...*InstanceSignal) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.WaitForInstancesSignal = (*WaitForInstancesSignal)(&signals) })
+}
+
+// NewWriteTemplatedFilesStep adds a WriteTemplatedFiles step to w.
+func (w *Workflow) NewWriteTemplatedFilesStep(name string, files ...*TemplatedFile) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.WriteTemplatedFiles = (*WriteTemplatedFiles)(&files) })
+}
+
+// NewForEachStep adds a ForEach step to w, running step for each of items.
+// step is only a template for its copies, create it with &Step{} rather
+// than NewStep.
+func (w *Workflow) NewForEachStep(name string, items []string, step *Step) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.ForEach = &ForEach{Items: items, Step: step} })
+}
+
+// NewSubWorkflowStep adds a SubWorkflow step to w running sw, which should
+// come from w.NewSubWorkflow. vars are set on sw before it is populated.
+func (w *Workflow) NewSubWorkflowStep(name string, sw *Workflow, vars map[string]string) *StepBuilder {
+	if sw.parent == nil {
+		sw.parent = w
+		sw.Cancel = w.Cancel
+	}
+	return w.newStepBuilder(name, func(s *Step) { s.SubWorkflow = &SubWorkflow{Vars: vars, w: sw} })
+}
+
+// NewIncludeWorkflowStep adds an IncludeWorkflow step to w including iw,
+// which must come from w.NewIncludedWorkflow so that it shares the
+// resources of w. vars are set on iw before it is populated.
+func (w *Workflow) NewIncludeWorkflowStep(name string, iw *Workflow, vars map[string]string) *StepBuilder {
+	return w.newStepBuilder(name, func(s *Step) { s.IncludeWorkflow = &IncludeWorkflow{Vars: vars, w: iw} })
+}
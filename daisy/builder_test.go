@@ -0,0 +1,98 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestStepBuilder(t *testing.T) {
+	w := testWorkflow()
+	disk := &CreateDisk{Disk: compute.Disk{Name: "d", SourceImage: "i"}}
+	inst := &CreateInstance{Instance: compute.Instance{Name: "vm", Disks: []*compute.AttachedDisk{{Source: "d"}}}}
+	disks := w.NewCreateDisksStep("create-disks", disk)
+	b := w.NewCreateInstancesStep("create-instance", inst).DependsOn(disks.Name()).Timeout("30m").ContinueOnError()
+	s, err := b.Step()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.CreateInstances == nil || (*s.CreateInstances)[0] != inst {
+		t.Errorf("CreateInstances not set to the instance: %v", s.CreateInstances)
+	}
+	if s.Timeout != "30m" || !s.ContinueOnError || s.w != w || s.name != "create-instance" {
+		t.Errorf("step not wired up: %+v", s)
+	}
+	if w.Steps["create-disks"].CreateDisks == nil || (*w.Steps["create-disks"].CreateDisks)[0] != disk {
+		t.Errorf("CreateDisks not set to the disk: %v", w.Steps["create-disks"])
+	}
+	want := map[string][]string{"create-instance": {"create-disks"}}
+	if diff := pretty.Compare(w.Dependencies, want); diff != "" {
+		t.Errorf("Dependencies not as expected: (-got +want)\n%s", diff)
+	}
+	if err := w.buildError(); err != nil {
+		t.Errorf("unexpected build error: %v", err)
+	}
+}
+
+func TestStepBuilderErrors(t *testing.T) {
+	tests := []struct {
+		desc  string
+		build func(w *Workflow) *StepBuilder
+	}{
+		{"duplicate name case", func(w *Workflow) *StepBuilder {
+			w.NewCreateDisksStep("s")
+			return w.NewCreateImagesStep("s").RunAlways()
+		}},
+		{"unknown dependency case", func(w *Workflow) *StepBuilder {
+			return w.NewCreateDisksStep("s").DependsOn("foo")
+		}},
+		{"bad timeout case", func(w *Workflow) *StepBuilder {
+			return w.NewCreateDisksStep("s").Timeout("1x").DependsOn()
+		}},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		b := tt.build(w)
+		if b.Err() == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+		if s, err := b.Step(); s != nil || err != b.Err() {
+			t.Errorf("%s: Step() = %v, %v, want nil, %v", tt.desc, s, err, b.Err())
+		}
+		if err := w.Validate(context.Background()); err == nil || !strings.Contains(err.Error(), b.Err().Error()) {
+			t.Errorf("%s: Validate should have returned the build error %q, got: %v", tt.desc, b.Err(), err)
+		}
+	}
+}
+
+func TestNewSubWorkflowStep(t *testing.T) {
+	w := testWorkflow()
+	sw := New()
+	s, err := w.NewSubWorkflowStep("sub", sw, map[string]string{"k": "v"}).Step()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.SubWorkflow.w != sw || sw.parent != w || s.SubWorkflow.Vars["k"] != "v" {
+		t.Errorf("subworkflow not wired up: %+v", s.SubWorkflow)
+	}
+}
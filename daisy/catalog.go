@@ -0,0 +1,148 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+// DefaultCatalogTTL is how long catalog lookups are cached unless changed
+// with SetCatalogTTL.
+const DefaultCatalogTTL = time.Hour
+
+var catalogTTL = struct {
+	d  time.Duration
+	mu sync.Mutex
+}{d: DefaultCatalogTTL}
+
+// catalogCaches are all the caches cleared by ClearCatalogCache.
+var catalogCaches = []*catalogCache{zones, machineTypes, cpuPlatforms, diskTypes, imageFamilies, projects, osLoginProjects}
+
+// SetCatalogTTL sets how long the compute catalog lookups done during
+// validation, such as whether a project, zone, machine type, disk type or
+// image family exists, are cached. Lookups are cached per ComputeClient, as
+// clients with other credentials or endpoints may see other entries, and
+// are shared by every workflow in the process using the same client. So
+// callers running many workflows with one client, like an orchestrator or
+// server, look up each entry once per TTL instead of once per workflow. A
+// TTL of zero or less caches lookups until ClearCatalogCache is called.
+func SetCatalogTTL(d time.Duration) {
+	catalogTTL.mu.Lock()
+	defer catalogTTL.mu.Unlock()
+	catalogTTL.d = d
+}
+
+func getCatalogTTL() time.Duration {
+	catalogTTL.mu.Lock()
+	defer catalogTTL.mu.Unlock()
+	return catalogTTL.d
+}
+
+// ClearCatalogCache forgets all cached catalog lookups, e.g. after a
+// project's quota or permissions changed.
+func ClearCatalogCache() {
+	for _, c := range catalogCaches {
+		c.clear()
+	}
+}
+
+// catalogCache caches successful lookups of compute catalog entries by
+// client and key. Concurrent lookups of the same entry share one call.
+type catalogCache struct {
+	checked  map[catalogKey]time.Time
+	inflight map[catalogKey]*catalogCall
+	mu       sync.Mutex
+}
+
+// catalogKey identifies an entry by the client's pointer, so keys can be
+// compared even if the client's type can't.
+type catalogKey struct {
+	client compute.Client
+	key    string
+}
+
+type catalogCall struct {
+	done chan struct{}
+	err  error
+}
+
+// check calls f to look up key with client unless a previous lookup of key
+// with client succeeded within the catalog TTL. Failed lookups are not
+// cached. Lookups with clients that aren't pointers aren't cached either,
+// as they have no stable identity.
+func (c *catalogCache) check(client compute.Client, key string, f func() error) error {
+	if v := reflect.ValueOf(client); !v.IsValid() || v.Kind() != reflect.Ptr {
+		return f()
+	}
+	k := catalogKey{client, key}
+	ttl := getCatalogTTL()
+
+	c.mu.Lock()
+	if t, ok := c.checked[k]; ok {
+		if ttl <= 0 || time.Since(t) < ttl {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	if call, ok := c.inflight[k]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &catalogCall{done: make(chan struct{})}
+	if c.inflight == nil {
+		c.inflight = map[catalogKey]*catalogCall{}
+	}
+	c.inflight[k] = call
+	c.mu.Unlock()
+
+	call.err = f()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(call.done)
+	// A clear during the lookup drops it from inflight, its result is then
+	// not cached.
+	if c.inflight[k] != call {
+		return call.err
+	}
+	delete(c.inflight, k)
+	if call.err != nil {
+		return call.err
+	}
+	if c.checked == nil {
+		c.checked = map[catalogKey]time.Time{}
+	}
+	// Forget expired lookups, so clients that are no longer used don't
+	// stay referenced.
+	for k, t := range c.checked {
+		if ttl > 0 && time.Since(t) >= ttl {
+			delete(c.checked, k)
+		}
+	}
+	c.checked[k] = time.Now()
+	return nil
+}
+
+func (c *catalogCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = nil
+	c.inflight = nil
+}
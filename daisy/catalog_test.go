@@ -0,0 +1,189 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCatalogCacheCheck(t *testing.T) {
+	defer SetCatalogTTL(DefaultCatalogTTL)
+	c := &catalogCache{}
+	client := &daisyCompute.TestClient{}
+	k := catalogKey{client, "k"}
+	var calls int
+	lookup := func() error { calls++; return nil }
+
+	if err := c.check(client, "k", lookup); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.check(client, "k", lookup)
+	if calls != 1 {
+		t.Errorf("cached lookup was repeated, got %d calls, want 1", calls)
+	}
+
+	// An expired entry is looked up again.
+	c.checked[k] = time.Now().Add(-2 * DefaultCatalogTTL)
+	c.check(client, "k", lookup)
+	if calls != 2 {
+		t.Errorf("expired lookup was not repeated, got %d calls, want 2", calls)
+	}
+
+	// A TTL of zero never expires entries.
+	SetCatalogTTL(0)
+	c.checked[k] = time.Now().Add(-2 * DefaultCatalogTTL)
+	c.check(client, "k", lookup)
+	if calls != 2 {
+		t.Errorf("lookup was repeated with no TTL, got %d calls, want 2", calls)
+	}
+
+	c.clear()
+	c.check(client, "k", lookup)
+	if calls != 3 {
+		t.Errorf("cleared lookup was not repeated, got %d calls, want 3", calls)
+	}
+
+	// Another client, e.g. with other credentials, looks up for itself.
+	c.check(&daisyCompute.TestClient{}, "k", lookup)
+	if calls != 4 {
+		t.Errorf("lookup of another client was not repeated, got %d calls, want 4", calls)
+	}
+}
+
+func TestCatalogCacheCheckError(t *testing.T) {
+	c := &catalogCache{}
+	client := &daisyCompute.TestClient{}
+	want := errors.New("not found")
+	for i := 0; i < 2; i++ {
+		if err := c.check(client, "k", func() error { return want }); err != want {
+			t.Errorf("unexpected error, got: %v, want: %v", err, want)
+		}
+	}
+	if _, ok := c.checked[catalogKey{client, "k"}]; ok {
+		t.Error("failed lookup was cached")
+	}
+}
+
+func TestCatalogCacheCheckConcurrent(t *testing.T) {
+	c := &catalogCache{}
+	client := &daisyCompute.TestClient{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var mx sync.Mutex
+	var calls int
+	lookup := func() error {
+		mx.Lock()
+		calls++
+		mx.Unlock()
+		close(started)
+		<-release
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.check(client, "k", lookup)
+	}()
+	<-started
+
+	// Other entries are looked up while the first lookup runs.
+	if err := c.check(client, "other", func() error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Lookups of the same entry wait for the one in flight.
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.check(client, "k", lookup); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("concurrent lookups were not shared, got %d calls, want 1", calls)
+	}
+}
+
+// uncomparableClient can't be used as a map key.
+type uncomparableClient struct {
+	*daisyCompute.TestClient
+	m map[string]string
+}
+
+func TestCatalogCacheCheckUncomparableClient(t *testing.T) {
+	c := &catalogCache{}
+	var calls int
+	for i := 0; i < 2; i++ {
+		if err := c.check(uncomparableClient{}, "k", func() error { calls++; return nil }); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("lookup with a client that isn't a pointer was cached, got %d calls, want 2", calls)
+	}
+}
+
+func TestCheckDiskTypeAndImageFamily(t *testing.T) {
+	var got []string
+	c := &daisyCompute.TestClient{
+		GetDiskTypeFn: func(p, z, dt string) (*compute.DiskType, error) {
+			got = append(got, p+"/"+z+"/"+dt)
+			if dt == "bad" {
+				return nil, errors.New("bad disk type")
+			}
+			return nil, nil
+		},
+		GetRegionDiskTypeFn: func(p, r, dt string) (*compute.DiskType, error) {
+			got = append(got, p+"/"+r+"/"+dt)
+			return nil, nil
+		},
+		GetImageFromFamilyFn: func(p, f string) (*compute.Image, error) {
+			got = append(got, p+"/"+f)
+			return nil, nil
+		},
+	}
+
+	for _, url := range []string{"projects/p/zones/z/diskTypes/pd-ssd", "projects/p/zones/z/diskTypes/pd-ssd", "projects/p/regions/r/diskTypes/pd-ssd"} {
+		if err := checkDiskType(c, url); err != nil {
+			t.Errorf("%q: unexpected error: %v", url, err)
+		}
+	}
+	if err := checkDiskType(c, "projects/p/zones/z/diskTypes/bad"); err == nil {
+		t.Error("bad disk type should have returned an error")
+	}
+	for i := 0; i < 2; i++ {
+		if err := checkImageFamily(c, "p", "f"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	want := []string{"p/z/pd-ssd", "p/r/pd-ssd", "p/z/bad", "p/f"}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("lookups do not match expectation: (-got +want)\n%s", diff)
+	}
+}
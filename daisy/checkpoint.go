@@ -0,0 +1,325 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// checkpointFile is the object in the scratch path of the top level
+// workflow that the progress of a run with Checkpoint set is written to.
+const checkpointFile = "checkpoint.json"
+
+// checkpoint is the progress of a workflow run, see Workflow.Resume.
+type checkpoint struct {
+	// Runs holds the ID, start time and username of the workflow and its
+	// subworkflows, by qualified name, so a resumed run generates the same
+	// names and paths.
+	Runs map[string]*checkpointRun
+	// CompletedSteps lists the completed steps, as in
+	// RunResult.CompletedSteps.
+	CompletedSteps []string
+	// Resources lists the GCE resources the run created.
+	Resources []*checkpointResource
+
+	completed map[string]bool
+	// dir is the scratch path the checkpoint was found in.
+	dir string
+}
+
+type checkpointRun struct {
+	ID      string
+	Started time.Time
+	// ScratchPath of the run, relative to its bucket. Not set in
+	// checkpoints of older versions.
+	ScratchPath string `json:",omitempty"`
+	// Username and Project the run used, Project being the sandbox project
+	// of sandboxed subworkflows. Not set in checkpoints of older versions.
+	Username string `json:",omitempty"`
+	Project  string `json:",omitempty"`
+}
+
+type checkpointResource struct {
+	// Workflow is the qualified name of the workflow owning the resource.
+	Workflow string
+	Type     string
+	Name     string
+	Link     string
+	Deleted  bool `json:",omitempty"`
+}
+
+// nestedName returns name prefixed by the names of the steps running w,
+// e.g. "sub.step", as in RunResult.CompletedSteps.
+func (w *Workflow) nestedName(name string) string {
+	for wf := w; wf.parent != nil; wf = wf.parent {
+		name = wf.Name + "." + name
+	}
+	return name
+}
+
+// runWorkflows returns the workflow and its subworkflows by qualified name.
+// These are the workflows with their own ID and resources, included
+// workflows share those of their parent.
+func (w *Workflow) runWorkflows() map[string]*Workflow {
+	wfs := map[string]*Workflow{w.qualifiedName(): w}
+	w.addRunWorkflows(wfs)
+	return wfs
+}
+
+func (w *Workflow) addRunWorkflows(wfs map[string]*Workflow) {
+	for _, s := range w.Steps {
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil {
+			s.IncludeWorkflow.w.addRunWorkflows(wfs)
+		}
+		if s.ForEach != nil && s.ForEach.w != nil {
+			s.ForEach.w.addRunWorkflows(wfs)
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.w != nil {
+			wfs[s.SubWorkflow.w.qualifiedName()] = s.SubWorkflow.w
+			s.SubWorkflow.w.addRunWorkflows(wfs)
+		}
+	}
+}
+
+func (w *Workflow) newCheckpoint() *checkpoint {
+	cp := &checkpoint{Runs: map[string]*checkpointRun{}, CompletedSteps: w.completedSteps("")}
+	for name, wf := range w.runWorkflows() {
+		if wf.id != "" {
+			cp.Runs[name] = &checkpointRun{ID: wf.id, Started: wf.started, ScratchPath: wf.scratchPath, Username: wf.username, Project: wf.Project}
+		}
+	}
+	cp.Resources = w.checkpointResources()
+	return cp
+}
+
+// checkpointResources returns the resources w and its subworkflows created.
+func (w *Workflow) checkpointResources() []*checkpointResource {
+	var crs []*checkpointResource
+	for _, rm := range w.resourceMaps() {
+		for _, r := range rm.createdResources() {
+			crs = append(crs, &checkpointResource{Workflow: rm.w.qualifiedName(), Type: r.Type, Name: r.Name, Link: r.Link, Deleted: r.Deleted})
+		}
+	}
+	return crs
+}
+
+// saveCheckpoint writes the progress of the run to the scratch path, if
+// Checkpoint is set. Errors are logged, a run doesn't fail as it can't be
+// resumed.
+func (w *Workflow) saveCheckpoint() {
+	root := w.root()
+	if !root.Checkpoint || root.bucket == "" || root.StorageClient == nil {
+		return
+	}
+	root.checkpointMx.Lock()
+	defer root.checkpointMx.Unlock()
+	b, err := json.MarshalIndent(root.newCheckpoint(), "", "  ")
+	if err != nil {
+		root.logger.Errorf("Error marshalling checkpoint: %v", err)
+		return
+	}
+	obj := path.Join(root.scratchPath, checkpointFile)
+	wc := root.StorageClient.Bucket(root.bucket).Object(obj).NewWriter(context.Background())
+	wc.ContentType = "application/json"
+	if _, err := wc.Write(b); err != nil {
+		root.logger.Errorf("Error writing checkpoint to gs://%s/%s: %v", root.bucket, obj, err)
+		return
+	}
+	if err := wc.Close(); err != nil {
+		root.logger.Errorf("Error writing checkpoint to gs://%s/%s: %v", root.bucket, obj, err)
+	}
+}
+
+// loadCheckpoint reads the checkpoint of run resumeID. Scratch paths end
+// with the ID of the run, so it is looked up among the scratch paths of
+// the workflow's GCS path.
+func (w *Workflow) loadCheckpoint(ctx context.Context) (*checkpoint, error) {
+	gcsPath := w.GCSPath
+	for k, v := range w.Vars {
+		gcsPath = strings.Replace(gcsPath, fmt.Sprintf("${%s}", k), v.Value, -1)
+	}
+	bkt, p, err := splitGCSPath(gcsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj string
+	suffix := fmt.Sprintf("-%s/%s", w.resumeID, checkpointFile)
+	it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: path.Join(p, "daisy-")})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error looking up checkpoint of run %q: %v", w.resumeID, err)
+		}
+		if strings.HasSuffix(attrs.Name, suffix) {
+			obj = attrs.Name
+			break
+		}
+	}
+	if obj == "" {
+		return nil, fmt.Errorf("no checkpoint of run %q found in %q", w.resumeID, gcsPath)
+	}
+
+	r, err := w.StorageClient.Bucket(bkt).Object(obj).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint gs://%s/%s: %v", bkt, obj, err)
+	}
+	defer r.Close()
+	cp := &checkpoint{}
+	if err := json.NewDecoder(r).Decode(cp); err != nil {
+		return nil, fmt.Errorf("error reading checkpoint gs://%s/%s: %v", bkt, obj, err)
+	}
+	if _, ok := cp.Runs[w.Name]; !ok {
+		return nil, fmt.Errorf("checkpoint gs://%s/%s is not of workflow %q", bkt, obj, w.Name)
+	}
+	cp.init()
+	cp.dir = path.Dir(obj)
+	return cp, nil
+}
+
+func (cp *checkpoint) init() {
+	cp.completed = map[string]bool{}
+	for _, name := range cp.CompletedSteps {
+		cp.completed[name] = true
+	}
+}
+
+// resumedRun returns the run w resumes, or nil if it doesn't resume one.
+func (w *Workflow) resumedRun() *checkpointRun {
+	cp := w.root().resumed
+	if cp == nil {
+		return nil
+	}
+	return cp.Runs[w.qualifiedName()]
+}
+
+// completedBefore returns true if s completed in the run being resumed.
+func (w *Workflow) completedBefore(s *Step) bool {
+	cp := w.root().resumed
+	return cp != nil && cp.completed[w.nestedName(s.name)]
+}
+
+// keepForResume returns true if cleanup keeps the resources of w, as the
+// run failed with Checkpoint set and may be resumed.
+func (w *Workflow) keepForResume() bool {
+	root := w.root()
+	if !root.Checkpoint {
+		return false
+	}
+	select {
+	case <-root.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkpointResourceMap returns the resource map of cr among wfs, the
+// workflows of a run by qualified name, or nil if there is none.
+func checkpointResourceMap(wfs map[string]*Workflow, cr *checkpointResource) *baseResourceMap {
+	wf, ok := wfs[cr.Workflow]
+	if !ok {
+		return nil
+	}
+	for _, rm := range wf.ownResourceMaps() {
+		if rm.typeName == cr.Type {
+			return rm
+		}
+	}
+	return nil
+}
+
+// adoptResources marks the resources created by the resumed run as created.
+// Resources created by steps that didn't complete are deleted, so that the
+// steps can create them again.
+func (w *Workflow) adoptResources() {
+	wfs := w.runWorkflows()
+	leftovers := map[*baseResourceMap][]string{}
+	for _, cr := range w.resumed.Resources {
+		rm := checkpointResourceMap(wfs, cr)
+		if rm == nil {
+			continue
+		}
+		rm.mx.Lock()
+		r, ok := rm.m[cr.Name]
+		if ok {
+			r.created = true
+			r.deleted = cr.Deleted
+			if !r.deleted && r.creator != nil && !w.completedBefore(r.creator) {
+				leftovers[rm] = append(leftovers[rm], cr.Name)
+			}
+		}
+		rm.mx.Unlock()
+		if !ok {
+			w.logger.Printf("Resume: %s %q of run %q is not part of the workflow, ignoring it.", cr.Type, cr.Link, w.id)
+		}
+	}
+
+	var names []string
+	for name := range wfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, phase := range cleanupPhases(wfs[name]) {
+			for _, rm := range phase {
+				for _, n := range leftovers[rm] {
+					rm.deleteLeftover(n)
+				}
+			}
+		}
+	}
+}
+
+// deleteLeftover deletes resource name, left behind by a step that didn't
+// complete, and forgets it was created.
+func (rm *baseResourceMap) deleteLeftover(name string) {
+	r, _ := rm.get(name)
+	rm.w.logger.Printf("Resume: deleting %s %q left by step %q.", rm.typeName, r.link, r.creator.name)
+	if err := rm.delete(name); err != nil {
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
+			rm.w.logger.Printf("Resume: error deleting %s %q: %v", rm.typeName, r.link, err)
+			return
+		}
+	}
+	rm.mx.Lock()
+	r.created = false
+	r.deleted = false
+	rm.mx.Unlock()
+}
+
+// Resume runs a workflow that failed, or was canceled, with Checkpoint set.
+// runID is the ID of the failed run. The resumed run reuses the scratch
+// path and generated resource names of runID. Steps that completed are
+// skipped and the resources they created are used as is. Resources created
+// by steps that didn't complete are deleted before the steps run again.
+func (w *Workflow) Resume(ctx context.Context, runID string) error {
+	w.resumeID = runID
+	w.Checkpoint = true
+	return w.Run(ctx)
+}
@@ -0,0 +1,215 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestNewCheckpoint(t *testing.T) {
+	started := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	w := testWorkflow()
+	w.started = started
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	sw.id = "ghijk"
+	sw.started = started.Add(time.Minute)
+	sw.Steps = map[string]*Step{"inner": {name: "inner", w: sw, testType: &mockStep{}}}
+	sw.completed = []string{"inner"}
+	w.Steps = map[string]*Step{
+		"s0":  {name: "s0", w: w, testType: &mockStep{}},
+		"sub": {name: "sub", w: w, SubWorkflow: &SubWorkflow{w: sw}},
+	}
+	w.completed = []string{"s0"}
+	disks[w].m = map[string]*resource{
+		"d0": {link: "projects/p/zones/z/disks/d0", created: true},
+		"d1": {link: "projects/p/zones/z/disks/d1", created: true, deleted: true},
+		"d2": {link: "projects/p/zones/z/disks/d2"},
+	}
+	disks[sw].m = map[string]*resource{"sd": {link: "projects/p/zones/z/disks/sd", created: true}}
+
+	want := &checkpoint{
+		Runs: map[string]*checkpointRun{
+			testWf:          {ID: "abcdef", Started: started, Project: testProject},
+			testWf + ".sub": {ID: "ghijk", Started: started.Add(time.Minute)},
+		},
+		CompletedSteps: []string{"s0"},
+		Resources: []*checkpointResource{
+			{Workflow: testWf, Type: "disk", Name: "d0", Link: "projects/p/zones/z/disks/d0"},
+			{Workflow: testWf, Type: "disk", Name: "d1", Link: "projects/p/zones/z/disks/d1", Deleted: true},
+			{Workflow: testWf + ".sub", Type: "disk", Name: "sd", Link: "projects/p/zones/z/disks/sd"},
+		},
+	}
+	if diff := pretty.Compare(w.newCheckpoint(), want); diff != "" {
+		t.Errorf("checkpoint does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestPopulateResumed(t *testing.T) {
+	started := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	w := testWorkflow()
+	w.resumed = &checkpoint{Runs: map[string]*checkpointRun{testWf: {ID: "xyz12", Started: started, Username: "jdoe"}}}
+
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if w.id != "xyz12" {
+		t.Errorf("unexpected id, got: %q, want: %q", w.id, "xyz12")
+	}
+	if want := "daisy-test-wf-20171001-12:00:00-xyz12"; w.scratchPath != want {
+		t.Errorf("unexpected scratch path, got: %q, want: %q", w.scratchPath, want)
+	}
+	if got := w.autovars["DATE"]; got != "20171001" {
+		t.Errorf("unexpected DATE autovar, got: %q, want: %q", got, "20171001")
+	}
+	if w.username != "jdoe" {
+		t.Errorf("unexpected username, got: %q, want: %q", w.username, "jdoe")
+	}
+}
+
+func TestResumeSkipsCompletedSteps(t *testing.T) {
+	w := testWorkflow()
+	var ran []string
+	runImpl := func(ctx context.Context, s *Step) error {
+		ran = append(ran, s.name)
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}}
+	w.resumed = &checkpoint{CompletedSteps: []string{"s0"}}
+	w.resumed.init()
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(ran, []string{"s1"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.completedSteps(""), []string{"s0", "s1"}); diff != "" {
+		t.Errorf("completed steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	if got := w.Steps["s0"].State(); got != StepFinished {
+		t.Errorf("unexpected state of skipped step, got: %s, want: %s", got, StepFinished)
+	}
+}
+
+func TestAdoptResources(t *testing.T) {
+	w := testWorkflow()
+	var deleted []string
+	w.ComputeClient = &daisyCompute.TestClient{
+		DeleteDiskFn: func(_, _, n string) error { deleted = append(deleted, n); return nil },
+	}
+	done := &Step{name: "done", w: w}
+	failed := &Step{name: "failed", w: w}
+	w.Steps = map[string]*Step{"done": done, "failed": failed}
+	disks[w].m = map[string]*resource{
+		"d0": {real: "d0-real", link: "projects/p/zones/z/disks/d0-real", creator: done},
+		"d1": {real: "d1-real", link: "projects/p/zones/z/disks/d1-real", creator: done},
+		"d2": {real: "d2-real", link: "projects/p/zones/z/disks/d2-real", creator: failed},
+		"d3": {real: "d3-real", link: "projects/p/zones/z/disks/d3-real", creator: failed},
+	}
+	w.resumed = &checkpoint{
+		CompletedSteps: []string{"done"},
+		Resources: []*checkpointResource{
+			{Workflow: testWf, Type: "disk", Name: "d0", Link: "projects/p/zones/z/disks/d0-real"},
+			{Workflow: testWf, Type: "disk", Name: "d1", Link: "projects/p/zones/z/disks/d1-real", Deleted: true},
+			{Workflow: testWf, Type: "disk", Name: "d2", Link: "projects/p/zones/z/disks/d2-real"},
+			{Workflow: testWf, Type: "disk", Name: "gone", Link: "projects/p/zones/z/disks/gone"},
+		},
+	}
+	w.resumed.init()
+
+	w.adoptResources()
+
+	if diff := pretty.Compare(deleted, []string{"d2-real"}); diff != "" {
+		t.Errorf("deleted disks do not match expectation: (-got +want)\n%s", diff)
+	}
+	tests := []struct {
+		name             string
+		created, deleted bool
+	}{
+		{"d0", true, false},
+		{"d1", true, true},
+		{"d2", false, false},
+		{"d3", false, false},
+	}
+	for _, tt := range tests {
+		r := disks[w].m[tt.name]
+		if r.created != tt.created || r.deleted != tt.deleted {
+			t.Errorf("%s: unexpected state, got: created=%t deleted=%t, want: created=%t deleted=%t", tt.name, r.created, r.deleted, tt.created, tt.deleted)
+		}
+	}
+}
+
+func TestKeepForResume(t *testing.T) {
+	tests := []struct {
+		desc               string
+		checkpoint, cancel bool
+		want               []string
+	}{
+		{"normal case", false, false, []string{"d0"}},
+		{"failed run case", false, true, []string{"d0"}},
+		{"checkpoint case", true, false, []string{"d0"}},
+		{"failed run with checkpoint case", true, true, nil},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Checkpoint = tt.checkpoint
+		if tt.cancel {
+			w.CancelWithReason("failed")
+		}
+		var got []string
+		w.ComputeClient = &daisyCompute.TestClient{
+			DeleteDiskFn: func(_, _, n string) error { got = append(got, n); return nil },
+		}
+		disks[w].m = map[string]*resource{"d0": {real: "d0", link: "projects/p/zones/z/disks/d0", created: true}}
+
+		w.cleanup()
+
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: deleted disks do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestNestedName(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	iw := sw.NewIncludedWorkflow()
+	iw.parent = sw
+	iw.Name = "inc"
+
+	for _, tt := range []struct {
+		w    *Workflow
+		want string
+	}{
+		{w, "step"},
+		{sw, "sub.step"},
+		{iw, "sub.inc.step"},
+	} {
+		if got := tt.w.nestedName("step"); got != tt.want {
+			t.Errorf("unexpected nested name, got: %q, want: %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// OrphanedResource is a GCE resource left behind by a workflow run that is
+// no longer running.
+type OrphanedResource struct {
+	// Type is the resource type, e.g. "disk".
+	Type string
+	// Link is the partial URL of the resource, or the gs:// URL of a bucket.
+	Link string
+	// WorkflowName and WorkflowID are the labels identifying the run that
+	// created the resource.
+	WorkflowName, WorkflowID string
+	// Created is when the resource was created.
+	Created time.Time
+	// Deleted is true if the resource was deleted.
+	Deleted bool
+}
+
+// labeledResource is a resource carrying the labels of a workflow run.
+type labeledResource struct {
+	*OrphanedResource
+	labels map[string]string
+	// users are the partial URLs of the instances a disk is attached to.
+	users []string
+}
+
+// CleanupOrphans deletes the buckets, disks, images, instances and snapshots
+// in project that were created by workflow runs which are no longer running,
+// e.g. because the machine running them crashed. Resources are found by
+// the labels Daisy sets on them. A run is considered to be no longer
+// running once the first resource it created is older than olderThan,
+// which should be longer than any workflow takes to run. Resources of
+// NoCleanup steps, and disks attached to instances that are kept, are left
+// alone.
+//
+// The orphans found are returned, Deleted tells which of them were deleted.
+// Errors deleting some of them don't stop the others from being deleted.
+func CleanupOrphans(ctx context.Context, project string, olderThan time.Duration, computeClient daisyCompute.Client, storageClient *storage.Client) ([]*OrphanedResource, error) {
+	return cleanupOrphans(ctx, computeClient, storageClient, project, olderThan, time.Now())
+}
+
+func cleanupOrphans(ctx context.Context, client daisyCompute.Client, storageClient *storage.Client, project string, olderThan time.Duration, now time.Time) ([]*OrphanedResource, error) {
+	rs, err := listLabeledResources(ctx, client, storageClient, project)
+	if err != nil {
+		return nil, err
+	}
+
+	// A run started before the first resource it created.
+	started := map[string]time.Time{}
+	for _, r := range rs {
+		if t, ok := started[r.WorkflowID]; !ok || r.Created.Before(t) {
+			started[r.WorkflowID] = r.Created
+		}
+	}
+	var orphans []*labeledResource
+	for _, r := range rs {
+		if now.Sub(started[r.WorkflowID]) > olderThan && r.labels[labelNoCleanup] == "" {
+			orphans = append(orphans, r)
+		}
+	}
+
+	instanceOrphans, otherOrphans := splitOrphans(orphans)
+	var errs Errors
+	errs.add(deleteOrphans(ctx, client, storageClient, instanceOrphans)...)
+	errs.add(deleteOrphans(ctx, client, storageClient, otherOrphans)...)
+	return sortOrphans(append(instanceOrphans, otherOrphans...)), errs.cast()
+}
+
+// splitOrphans returns the instances of orphans, and the other orphans,
+// which can only be deleted once the instances are gone. Disks attached to
+// instances that aren't orphans are left out.
+func splitOrphans(orphans []*labeledResource) (instanceOrphans, otherOrphans []*OrphanedResource) {
+	deleted := map[string]bool{}
+	for _, r := range orphans {
+		if r.Type == "instance" {
+			deleted[r.Link] = true
+		}
+	}
+	for _, r := range orphans {
+		switch {
+		case r.Type == "instance":
+			instanceOrphans = append(instanceOrphans, r.OrphanedResource)
+		case r.Type == "disk" && !allDeleted(r.users, deleted):
+			// Attached to an instance that is kept.
+			continue
+		default:
+			otherOrphans = append(otherOrphans, r.OrphanedResource)
+		}
+	}
+	return instanceOrphans, otherOrphans
+}
+
+// sortOrphans sorts rs by type and link.
+func sortOrphans(rs []*OrphanedResource) []*OrphanedResource {
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Type != rs[j].Type {
+			return rs[i].Type < rs[j].Type
+		}
+		return rs[i].Link < rs[j].Link
+	})
+	return rs
+}
+
+func allDeleted(links []string, deleted map[string]bool) bool {
+	for _, l := range links {
+		if !deleted[l] {
+			return false
+		}
+	}
+	return true
+}
+
+// listLabeledResources lists the buckets, disks, images, instances and
+// snapshots in project carrying the labels of a workflow run.
+func listLabeledResources(ctx context.Context, client daisyCompute.Client, storageClient *storage.Client, project string) ([]*labeledResource, error) {
+	filter := "labels." + labelWorkflowID + ":*"
+	var rs []*labeledResource
+	addAt := func(typeName, link string, t time.Time, labels map[string]string, users []string) {
+		// Only delete what is known to be old enough.
+		if labels[labelWorkflowID] == "" || t.IsZero() {
+			return
+		}
+		r := &labeledResource{
+			OrphanedResource: &OrphanedResource{
+				Type:         typeName,
+				Link:         link,
+				WorkflowName: labels[labelWorkflowName],
+				WorkflowID:   labels[labelWorkflowID],
+				Created:      t,
+			},
+			labels: labels,
+		}
+		for _, u := range users {
+			r.users = append(r.users, gceAPIURLRgx.ReplaceAllString(u, ""))
+		}
+		rs = append(rs, r)
+	}
+	add := func(typeName, selfLink, created string, labels map[string]string, users []string) {
+		t, _ := time.Parse(time.RFC3339, created)
+		addAt(typeName, gceAPIURLRgx.ReplaceAllString(selfLink, ""), t, labels, users)
+	}
+
+	is, err := client.AggregatedListInstances(project, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range is {
+		add("instance", i.SelfLink, i.CreationTimestamp, i.Labels, nil)
+	}
+	ds, err := client.AggregatedListDisks(project, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range ds {
+		add("disk", d.SelfLink, d.CreationTimestamp, d.Labels, d.Users)
+	}
+	ims, err := client.ListImages(project, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range ims {
+		add("image", i.SelfLink, i.CreationTimestamp, i.Labels, nil)
+	}
+	ss, err := client.ListSnapshots(project, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range ss {
+		add("snapshot", s.SelfLink, s.CreationTimestamp, s.Labels, nil)
+	}
+	// GCS can't filter buckets by label.
+	it := storageClient.Buckets(ctx, project)
+	for attrs, err := it.Next(); err != iterator.Done; attrs, err = it.Next() {
+		if err != nil {
+			return nil, err
+		}
+		addAt("bucket", "gs://"+attrs.Name, attrs.Created, attrs.Labels, nil)
+	}
+	return rs, nil
+}
+
+// deleteOrphans deletes rs in parallel.
+func deleteOrphans(ctx context.Context, client daisyCompute.Client, storageClient *storage.Client, rs []*OrphanedResource) Errors {
+	var wg sync.WaitGroup
+	var mx sync.Mutex
+	var errs Errors
+	for _, r := range rs {
+		wg.Add(1)
+		go func(r *OrphanedResource) {
+			defer wg.Done()
+			err := deleteOrphan(ctx, client, storageClient, r)
+			// Disks may be gone with the instance they were auto-deleted with.
+			if apiErr, ok := err.(*googleapi.Error); (ok && apiErr.Code == 404) || err == storage.ErrBucketNotExist {
+				err = nil
+			}
+			mx.Lock()
+			defer mx.Unlock()
+			if err != nil {
+				errs.add(Errorf("cannot delete %s %q: %v", r.Type, r.Link, err))
+				return
+			}
+			r.Deleted = true
+		}(r)
+	}
+	wg.Wait()
+	return errs
+}
+
+func deleteOrphan(ctx context.Context, client daisyCompute.Client, storageClient *storage.Client, r *OrphanedResource) error {
+	if r.Type == "bucket" {
+		return deleteBucket(ctx, storageClient, strings.TrimPrefix(r.Link, "gs://"))
+	}
+	rgx := map[string]*regexp.Regexp{"instance": instanceURLRgx, "disk": diskURLRgx, "image": imageURLRgx, "snapshot": snapshotURLRgx}[r.Type]
+	m := namedSubexp(rgx, r.Link)
+	if m == nil {
+		return fmt.Errorf("unexpected resource URL")
+	}
+	switch r.Type {
+	case "instance":
+		return client.DeleteInstance(m["project"], m["zone"], m["instance"])
+	case "disk":
+		if m["region"] != "" {
+			return client.DeleteRegionDisk(m["project"], m["region"], m["disk"])
+		}
+		return client.DeleteDisk(m["project"], m["zone"], m["disk"])
+	case "image":
+		return client.DeleteImage(m["project"], m["image"])
+	default:
+		return client.DeleteSnapshot(m["project"], m["snapshot"])
+	}
+}
@@ -0,0 +1,166 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestCleanupOrphans(t *testing.T) {
+	now := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	ts := func(ago time.Duration) string { return now.Add(-ago).Format(time.RFC3339) }
+	labels := func(id string, kv ...string) map[string]string {
+		l := map[string]string{labelWorkflowName: "wf", labelWorkflowID: id}
+		for i := 0; i < len(kv); i += 2 {
+			l[kv[i]] = kv[i+1]
+		}
+		return l
+	}
+	url := func(link string) string { return "https://www.googleapis.com/compute/v1/" + link }
+
+	var mx sync.Mutex
+	var order []string
+	del := func(link string, err error) error {
+		mx.Lock()
+		defer mx.Unlock()
+		order = append(order, link)
+		return err
+	}
+	c := &daisyCompute.TestClient{
+		AggregatedListInstancesFn: func(p, f string) ([]*compute.Instance, error) {
+			if p != "p" || f != "labels.daisy-workflow-id:*" {
+				t.Errorf("unexpected list call: %q, %q", p, f)
+			}
+			return []*compute.Instance{
+				// A crashed run.
+				{SelfLink: url("projects/p/zones/z/instances/i1"), CreationTimestamp: ts(3 * time.Hour), Labels: labels("old")},
+				{SelfLink: url("projects/p/zones/z/instances/kept"), CreationTimestamp: ts(3 * time.Hour), Labels: labels("old", labelNoCleanup, "true")},
+				// A run that is still running.
+				{SelfLink: url("projects/p/zones/z/instances/i2"), CreationTimestamp: ts(30 * time.Minute), Labels: labels("new")},
+				// A run that started long ago, its last resource is recent.
+				{SelfLink: url("projects/p/zones/z/instances/i3"), CreationTimestamp: ts(10 * time.Minute), Labels: labels("long")},
+				// Resources Daisy can't tell the age of are kept.
+				{SelfLink: url("projects/p/zones/z/instances/i4"), CreationTimestamp: "bad", Labels: labels("bad")},
+			}, nil
+		},
+		AggregatedListDisksFn: func(_, _ string) ([]*compute.Disk, error) {
+			return []*compute.Disk{
+				{SelfLink: url("projects/p/zones/z/disks/d1"), CreationTimestamp: ts(3 * time.Hour), Labels: labels("old"), Users: []string{url("projects/p/zones/z/instances/i1")}},
+				{SelfLink: url("projects/p/zones/z/disks/d2"), CreationTimestamp: ts(3 * time.Hour), Labels: labels("old"), Users: []string{url("projects/p/zones/z/instances/kept")}},
+				{SelfLink: url("projects/p/regions/r/disks/d3"), CreationTimestamp: ts(3 * time.Hour), Labels: labels("long")},
+			}, nil
+		},
+		ListImagesFn: func(_, _ string) ([]*compute.Image, error) {
+			return []*compute.Image{
+				{SelfLink: url("projects/p/global/images/im1"), CreationTimestamp: ts(2 * time.Hour), Labels: labels("old")},
+				{SelfLink: url("projects/p/global/images/im2"), CreationTimestamp: ts(2 * time.Hour), Labels: labels("old", labelNoCleanup, "true")},
+			}, nil
+		},
+		ListSnapshotsFn: func(_, _ string) ([]*compute.Snapshot, error) {
+			return []*compute.Snapshot{
+				{SelfLink: url("projects/p/global/snapshots/s1"), CreationTimestamp: ts(2 * time.Hour), Labels: labels("old")},
+			}, nil
+		},
+		DeleteInstanceFn: func(p, z, n string) error { return del("projects/"+p+"/zones/"+z+"/instances/"+n, nil) },
+		DeleteDiskFn: func(p, z, n string) error {
+			return del("projects/"+p+"/zones/"+z+"/disks/"+n, &googleapi.Error{Code: 404})
+		},
+		DeleteRegionDiskFn: func(p, r, n string) error { return del("projects/"+p+"/regions/"+r+"/disks/"+n, nil) },
+		DeleteImageFn:      func(p, n string) error { return del("projects/"+p+"/global/images/"+n, nil) },
+		DeleteSnapshotFn: func(p, n string) error {
+			return del("projects/"+p+"/global/snapshots/"+n, errors.New("snapshot error"))
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/b":
+			if r.URL.Query().Get("project") != "p" {
+				t.Errorf("unexpected bucket list call: %q", r.URL)
+			}
+			fmt.Fprintf(w, `{"items": [
+				{"name": "b1", "timeCreated": %q, "labels": {"daisy-workflow-name": "wf", "daisy-workflow-id": "old"}},
+				{"name": "b2", "timeCreated": %q, "labels": {"daisy-workflow-name": "wf", "daisy-workflow-id": "old", "daisy-no-cleanup": "true"}},
+				{"name": "b3", "timeCreated": %q, "labels": {"daisy-workflow-name": "wf", "daisy-workflow-id": "new"}},
+				{"name": "b4", "timeCreated": %q}
+			]}`, ts(3*time.Hour), ts(3*time.Hour), ts(30*time.Minute), ts(3*time.Hour))
+		case r.Method == "GET" && r.URL.Path == "/b/b1/o":
+			fmt.Fprint(w, `{"items": [{"name": "obj"}]}`)
+		case r.Method == "DELETE":
+			del(r.URL.Path, nil)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected storage request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	sc, err := storage.NewClient(context.Background(), option.WithEndpoint(srv.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cleanupOrphans(context.Background(), c, sc, "p", time.Hour, now)
+	if err == nil {
+		t.Error("should have returned the snapshot error")
+	}
+	created := func(ago time.Duration) time.Time { return now.Add(-ago) }
+	want := []*OrphanedResource{
+		{Type: "bucket", Link: "gs://b1", WorkflowName: "wf", WorkflowID: "old", Created: created(3 * time.Hour), Deleted: true},
+		{Type: "disk", Link: "projects/p/regions/r/disks/d3", WorkflowName: "wf", WorkflowID: "long", Created: created(3 * time.Hour), Deleted: true},
+		{Type: "disk", Link: "projects/p/zones/z/disks/d1", WorkflowName: "wf", WorkflowID: "old", Created: created(3 * time.Hour), Deleted: true},
+		{Type: "image", Link: "projects/p/global/images/im1", WorkflowName: "wf", WorkflowID: "old", Created: created(2 * time.Hour), Deleted: true},
+		{Type: "instance", Link: "projects/p/zones/z/instances/i1", WorkflowName: "wf", WorkflowID: "old", Created: created(3 * time.Hour), Deleted: true},
+		{Type: "instance", Link: "projects/p/zones/z/instances/i3", WorkflowName: "wf", WorkflowID: "long", Created: created(10 * time.Minute), Deleted: true},
+		{Type: "snapshot", Link: "projects/p/global/snapshots/s1", WorkflowName: "wf", WorkflowID: "old", Created: created(2 * time.Hour)},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("orphans do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Instances are deleted before the disks attached to them.
+	if len(order) != 8 {
+		t.Fatalf("unexpected deletions: %q", order)
+	}
+	for _, l := range order[2:] {
+		if instanceURLRgx.MatchString(l) {
+			t.Errorf("instance %q deleted after other resources: %q", l, order)
+		}
+	}
+}
+
+func TestCleanupOrphansListError(t *testing.T) {
+	e := errors.New("list error")
+	c := &daisyCompute.TestClient{
+		AggregatedListInstancesFn: func(_, _ string) ([]*compute.Instance, error) { return nil, e },
+	}
+	if _, err := cleanupOrphans(context.Background(), c, nil, "p", time.Hour, time.Now()); err != e {
+		t.Errorf("unexpected error, got: %v, want: %v", err, e)
+	}
+}
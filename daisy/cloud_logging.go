@@ -0,0 +1,72 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/logging/v2"
+)
+
+// cloudLogWriter writes logs of LogFormatJSON to Cloud Logging, an entry per
+// LogEntry, as the "daisy" log of the global resource of project.
+type cloudLogWriter struct {
+	client  *logging.Service
+	project string
+	runID   string
+}
+
+func (c *cloudLogWriter) Write(b []byte) (int, error) {
+	var entries []*logging.LogEntry
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e LogEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return 0, fmt.Errorf("error parsing log %q: %v", line, err)
+		}
+		labels := map[string]string{"daisy_workflow": e.Workflow}
+		if e.Step != "" {
+			labels["daisy_step"] = e.Step
+			labels["daisy_step_type"] = e.StepType
+		}
+		entries = append(entries, &logging.LogEntry{
+			TextPayload: e.Message,
+			Severity:    e.Severity,
+			Timestamp:   e.Timestamp.Format(time.RFC3339Nano),
+			Labels:      labels,
+		})
+	}
+	if len(entries) == 0 {
+		return len(b), nil
+	}
+	req := &logging.WriteLogEntriesRequest{
+		LogName: fmt.Sprintf("projects/%s/logs/daisy", c.project),
+		Resource: &logging.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": c.project},
+		},
+		Labels:  map[string]string{"daisy_run_id": c.runID},
+		Entries: entries,
+	}
+	if _, err := c.client.Entries.Write(req).Do(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,101 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/logging/v2"
+)
+
+func TestCloudLogging(t *testing.T) {
+	var got []*logging.WriteLogEntriesRequest
+	var mx sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &logging.WriteLogEntriesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		mx.Lock()
+		got = append(got, req)
+		mx.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	w.logger = nil
+	w.CloudLogging = true
+	w.gcsLogging = true
+	w.gcsLogWriter = &syncedWriter{out: ioutil.Discard, size: defaultLogBufferSize}
+	w.loggingClient, _ = logging.New(http.DefaultClient)
+	w.loggingClient.BasePath = ts.URL + "/"
+	w.root().redactor.add("hunter2")
+	if err := w.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.closeLogs()
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	sw.cloudLogWriter = w.cloudLogWriter
+	sw.gcsLogWriter = w.gcsLogWriter
+	if err := sw.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &Step{name: "s", w: sw, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+
+	w.logger.Print("password hunter2")
+	sw.logger.StepInfo(s, "waiting for instance %q.", "i")
+	if err := w.FlushLogs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("unexpected number of writes, got: %d, want: 1", len(got))
+	}
+	req := got[0]
+	if req.LogName != "projects/test-project/logs/daisy" {
+		t.Errorf("unexpected log name: %q", req.LogName)
+	}
+	wantRes := &logging.MonitoredResource{Type: "global", Labels: map[string]string{"project_id": "test-project"}}
+	if diff := pretty.Compare(req.Resource, wantRes); diff != "" {
+		t.Errorf("resource not as expected: (-got +want)\n%s", diff)
+	}
+	if req.Labels["daisy_run_id"] != w.id {
+		t.Errorf("unexpected labels: %v", req.Labels)
+	}
+	for _, e := range req.Entries {
+		if e.Timestamp == "" {
+			t.Errorf("entry %q has no timestamp", e.TextPayload)
+		}
+		e.Timestamp = ""
+	}
+	want := []*logging.LogEntry{
+		{TextPayload: "password [REDACTED]", Severity: SeverityInfo, Labels: map[string]string{"daisy_workflow": "test-wf"}},
+		{TextPayload: `waiting for instance "i".`, Severity: SeverityInfo, Labels: map[string]string{"daisy_workflow": "test-wf.sub", "daisy_step": "s", "daisy_step_type": "WaitForInstancesSignal"}},
+	}
+	if diff := pretty.Compare(req.Entries, want); diff != "" {
+		t.Errorf("entries not as expected: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,138 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultCloudBuildImage is the daisy container image Cloud Build configs
+// run workflows with.
+const DefaultCloudBuildImage = "gcr.io/compute-image-tools/daisy:latest"
+
+// cloudBuildOutputsFile is the file in the Cloud Build workspace the outputs
+// of a workflow are written to, and stored as an artifact from.
+const cloudBuildOutputsFile = "outputs.json"
+
+var cloudBuildSubstitutionRgx = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// cloudBuildSubstitution returns the name of the Cloud Build user-defined
+// substitution of var k, e.g. "_IMAGE_NAME" for "image-name".
+func cloudBuildSubstitution(k string) string {
+	return "_" + cloudBuildSubstitutionRgx.ReplaceAllString(strings.ToUpper(k), "_")
+}
+
+// yamlQuote quotes s as a single quoted YAML string.
+func yamlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// cloudBuildTimeout returns the Timeout of w if it is set, or else the sum
+// of the timeouts of w's steps, the longest w can take to run. Timeouts
+// that aren't durations yet, e.g. because they use vars, count as the
+// default timeout.
+func (w *Workflow) cloudBuildTimeout() time.Duration {
+	if d, err := time.ParseDuration(w.Timeout); err == nil {
+		return d
+	}
+	def, _ := time.ParseDuration(defaultTimeout)
+	var total time.Duration
+	for _, s := range w.Steps {
+		d, err := time.ParseDuration(s.Timeout)
+		if err != nil {
+			d = def
+		}
+		total += d
+	}
+	return total
+}
+
+// WriteCloudBuild writes a Cloud Build config that runs w, read from path in
+// the build's source, with image, DefaultCloudBuildImage if it is "". Each
+// of w's Vars is set by a user-defined substitution, e.g. _IMAGE_NAME for
+// the var image-name, with the var's value as the default. So is the zone,
+// by _ZONE. If w has Outputs, they are stored as the build artifact
+// outputs.json in the default daisy bucket of the build's project. It
+// should be called before w is populated, w's fields are used as they are.
+func (w *Workflow) WriteCloudBuild(out io.Writer, path, image string) error {
+	if image == "" {
+		image = DefaultCloudBuildImage
+	}
+	subs := map[string]string{"_ZONE": w.Zone}
+	var required []string
+	args := []string{"-zone=${_ZONE}"}
+	if w.Project == "" {
+		args = append(args, "-project=$PROJECT_ID")
+	}
+
+	var keys []string
+	for k := range w.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sub := cloudBuildSubstitution(k)
+		if _, ok := subs[sub]; ok {
+			return fmt.Errorf("vars map to the same Cloud Build substitution %q", sub)
+		}
+		v := w.Vars[k]
+		subs[sub] = v.Value
+		if v.Required && v.Value == "" {
+			required = append(required, sub)
+		}
+		args = append(args, fmt.Sprintf("-var:%s=${%s}", k, sub))
+	}
+	if w.Zone == "" {
+		required = append(required, "_ZONE")
+	}
+	if len(w.Outputs) != 0 {
+		args = append(args, "-outputs_file="+cloudBuildOutputsFile)
+	}
+	args = append(args, path)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Runs the daisy workflow %q, generated by daisy cloudbuild.\n", w.Name)
+	fmt.Fprintf(&b, "steps:\n- name: %s\n  args:\n", yamlQuote(image))
+	for _, a := range args {
+		fmt.Fprintf(&b, "  - %s\n", yamlQuote(a))
+	}
+	fmt.Fprintf(&b, "timeout: '%ds'\n", int64(w.cloudBuildTimeout().Seconds()))
+
+	var subKeys []string
+	for k := range subs {
+		subKeys = append(subKeys, k)
+	}
+	sort.Strings(subKeys)
+	b.WriteString("substitutions:\n")
+	for _, k := range subKeys {
+		fmt.Fprintf(&b, "  %s: %s", k, yamlQuote(subs[k]))
+		if strIn(k, required) {
+			b.WriteString(" # Required.")
+		}
+		b.WriteString("\n")
+	}
+
+	if len(w.Outputs) != 0 {
+		fmt.Fprintf(&b, "artifacts:\n  objects:\n    location: 'gs://$PROJECT_ID-daisy-bkt/cloudbuild/$BUILD_ID/'\n    paths: [%s]\n", yamlQuote(cloudBuildOutputsFile))
+	}
+	_, err := b.WriteTo(out)
+	return err
+}
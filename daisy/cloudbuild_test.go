@@ -0,0 +1,93 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestWriteCloudBuild(t *testing.T) {
+	w := New()
+	w.Name = "build"
+	w.Vars = map[string]vars{
+		"image-name": {Value: "it's-${DATE}"},
+		"source":     {Required: true},
+	}
+	w.Steps = map[string]*Step{
+		"a": {Timeout: "1h"},
+		"b": {Timeout: "${timeout}"},
+	}
+	w.Outputs = map[string]string{"image": "${image-name}"}
+
+	var buf bytes.Buffer
+	if err := w.WriteCloudBuild(&buf, "wfs/build.wf.json", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `# Runs the daisy workflow "build", generated by daisy cloudbuild.
+steps:
+- name: 'gcr.io/compute-image-tools/daisy:latest'
+  args:
+  - '-zone=${_ZONE}'
+  - '-project=$PROJECT_ID'
+  - '-var:image-name=${_IMAGE_NAME}'
+  - '-var:source=${_SOURCE}'
+  - '-outputs_file=outputs.json'
+  - 'wfs/build.wf.json'
+timeout: '4200s'
+substitutions:
+  _IMAGE_NAME: 'it''s-${DATE}'
+  _SOURCE: '' # Required.
+  _ZONE: '' # Required.
+artifacts:
+  objects:
+    location: 'gs://$PROJECT_ID-daisy-bkt/cloudbuild/$BUILD_ID/'
+    paths: ['outputs.json']
+`
+	if diff := pretty.Compare(buf.String(), want); diff != "" {
+		t.Errorf("config does not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Project and zone set, no outputs.
+	w.Project = "p"
+	w.Zone = "z"
+	w.Outputs = nil
+	w.Vars = nil
+	buf.Reset()
+	if err := w.WriteCloudBuild(&buf, "build.wf.json", "gcr.io/p/daisy:v1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = `# Runs the daisy workflow "build", generated by daisy cloudbuild.
+steps:
+- name: 'gcr.io/p/daisy:v1'
+  args:
+  - '-zone=${_ZONE}'
+  - 'build.wf.json'
+timeout: '4200s'
+substitutions:
+  _ZONE: 'z'
+`
+	if diff := pretty.Compare(buf.String(), want); diff != "" {
+		t.Errorf("config does not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Vars mapping to the same substitution.
+	w.Vars = map[string]vars{"a-b": {}, "a_b": {}}
+	if err := w.WriteCloudBuild(&buf, "build.wf.json", ""); err == nil {
+		t.Error("should have returned an error")
+	}
+}
@@ -15,6 +15,9 @@
 package daisy
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -23,6 +26,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -51,6 +55,9 @@ var (
 	gsHTTPRegex3 = regexp.MustCompile(fmt.Sprintf(`^http[s]?://(?:commondata)?storage\.googleapis\.com/%s/%s$`, bucket, object))
 
 	gcsAPIBase = "https://storage.cloud.google.com"
+
+	// Hex encoded SHA256 checksums.
+	sha256Rgx = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
 )
 
 func getUser() string {
@@ -110,12 +117,28 @@ func minInt(x int, ys ...int) int {
 	return x
 }
 
+// randGen is seeded once, from crypto/rand, so strings generated at the
+// same time, or by processes started at the same time, differ.
+var randGen = struct {
+	*rand.Rand
+	mu sync.Mutex
+}{Rand: rand.New(rand.NewSource(randSeed()))}
+
+func randSeed() int64 {
+	var seed int64
+	if err := binary.Read(crand.Reader, binary.LittleEndian, &seed); err != nil {
+		return time.Now().UnixNano()
+	}
+	return seed
+}
+
 func randString(n int) string {
-	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	randGen.mu.Lock()
+	defer randGen.mu.Unlock()
 	letters := "bdghjlmnpqrstvwxyz0123456789"
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letters[gen.Int63()%int64(len(letters))]
+		b[i] = letters[randGen.Int63()%int64(len(letters))]
 	}
 	return string(b)
 }
@@ -169,6 +192,78 @@ func substitute(v reflect.Value, replacer *strings.Replacer) {
 	})
 }
 
+// substitutionReplacer returns a replacer of the old and new string pairs
+// in replacements that leaves escaped substitutions, "$${...}", as they
+// are. Once the workflow is validated, unescapeSubstitutions turns them
+// into "${...}".
+func substitutionReplacer(replacements ...string) *strings.Replacer {
+	return strings.NewReplacer(append([]string{"$${", "$${"}, replacements...)...)
+}
+
+// unescapeSubstitutions turns escaped substitutions, "$${...}", into
+// "${...}" in w and the workflows of its IncludeWorkflow, SubWorkflow and
+// ForEach steps.
+func (w *Workflow) unescapeSubstitutions() {
+	substitute(reflect.ValueOf(w).Elem(), strings.NewReplacer("$${", "${"))
+	for _, cw := range w.childWorkflows() {
+		cw.unescapeSubstitutions()
+	}
+}
+
+// walkWorkflows calls f with w, then with its child workflows, depth first.
+func (w *Workflow) walkWorkflows(f func(*Workflow)) {
+	f(w)
+	for _, cw := range w.childWorkflows() {
+		cw.walkWorkflows(f)
+	}
+}
+
+// childWorkflows returns the workflows of w's IncludeWorkflow, SubWorkflow
+// and ForEach steps, in the order of the names of their steps.
+func (w *Workflow) childWorkflows() []*Workflow {
+	var ws []*Workflow
+	for _, name := range sortedStepNames(w) {
+		s := w.Steps[name]
+		switch {
+		case s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil:
+			ws = append(ws, s.IncludeWorkflow.w)
+		case s.SubWorkflow != nil && s.SubWorkflow.w != nil:
+			ws = append(ws, s.SubWorkflow.w)
+		case s.ForEach != nil && s.ForEach.w != nil:
+			ws = append(ws, s.ForEach.w)
+		}
+	}
+	return ws
+}
+
+// traverseStepData runs traverseData on s, except the Step of a ForEach
+// step. It's a template, "${ITEM}" is only replaced in its copies.
+func traverseStepData(s *Step, f func(reflect.Value) error) error {
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fv := v.Field(i)
+		if v.Type().Field(i).Name == "ForEach" && s.ForEach != nil {
+			fv = reflect.ValueOf(s.ForEach).Elem().FieldByName("Items")
+		}
+		if err := traverseData(fv, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refersTo reports whether s occurs in a string element within a complex data
+// structure (except those contained in private data structure fields).
+func refersTo(v reflect.Value, s string) bool {
+	found := errors.New("found")
+	return traverseData(v, func(val reflect.Value) error {
+		if str, ok := val.Interface().(string); ok && strings.Contains(str, s) {
+			return found
+		}
+		return nil
+	}) == found
+}
+
 // traverseData traverses complex data structures and runs
 // a function, f, on its basic data types.
 // Traverses arrays, maps, slices, and public fields of structs.
@@ -15,6 +15,7 @@
 package daisy
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"testing"
@@ -323,6 +324,34 @@ func TestSubstitute(t *testing.T) {
 	}
 }
 
+func TestSubstitutionEscape(t *testing.T) {
+	w := testWorkflow()
+	w.AddVar("name", "foo")
+	w.NewCreateDisksStep("s", &CreateDisk{Disk: compute.Disk{Name: "d", Description: "${name}-$${name}-$$${name}"}, SizeGb: "10"})
+	iw := w.NewIncludedWorkflow()
+	iw.NewCreateDisksStep("s", &CreateDisk{Disk: compute.Disk{Name: "d2", Description: "echo $${HOME}"}, SizeGb: "10"})
+	w.NewIncludeWorkflowStep("include", iw, nil)
+
+	// Escaped substitutions pass validation, and are unescaped once it's
+	// done.
+	if err := w.Validate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := (*w.Steps["s"].CreateDisks)[0].Description, "foo-${name}-$${name}"; got != want {
+		t.Errorf("unexpected unescaped string, got: %q, want: %q", got, want)
+	}
+	if got, want := (*iw.Steps["s"].CreateDisks)[0].Description, "echo ${HOME}"; got != want {
+		t.Errorf("included workflow not unescaped, got: %q, want: %q", got, want)
+	}
+
+	// Unresolved substitutions still fail validation.
+	w = testWorkflow()
+	w.NewCreateDisksStep("s", &CreateDisk{Disk: compute.Disk{Name: "d", Description: "$${name}-${name}"}, SizeGb: "10"})
+	if err := w.Validate(context.Background()); err == nil || !strings.Contains(err.Error(), `Unresolved var "${name}"`) {
+		t.Errorf("expected error for unresolved var, got: %v", err)
+	}
+}
+
 func TestSplitGCSPath(t *testing.T) {
 	tests := []struct {
 		input     string
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"golang.org/x/oauth2"
+	"google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
@@ -31,12 +32,29 @@ import (
 
 // Client is a client for interacting with Google Cloud Compute.
 type Client interface {
+	AggregatedListDisks(project, filter string) ([]*compute.Disk, error)
+	AggregatedListInstances(project, filter string) ([]*compute.Instance, error)
+	CreateAddress(project, region string, a *compute.Address) error
 	CreateDisk(project, zone string, d *compute.Disk) error
 	CreateImage(project string, i *compute.Image) error
 	CreateInstance(project, zone string, i *compute.Instance) error
+	CreateNetwork(project string, n *compute.Network) error
+	CreateRegionDisk(project, region string, d *compute.Disk) error
+	CreateResourcePolicy(project, region string, rp *compute.ResourcePolicy) error
+	CreateSnapshot(project, zone, disk string, s *compute.Snapshot) error
+	DeleteAddress(project, region, name string) error
 	DeleteDisk(project, zone, name string) error
+	DeleteFirewallRule(project, name string) error
 	DeleteImage(project, name string) error
 	DeleteInstance(project, zone, name string) error
+	DeleteNetwork(project, name string) error
+	DeleteRegionDisk(project, region, name string) error
+	DeleteResourcePolicy(project, region, name string) error
+	DeleteSnapshot(project, name string) error
+	DeleteSubnetwork(project, region, name string) error
+	DeprecateImage(project, name string, ds *compute.DeprecationStatus) error
+	GetAddress(project, region, name string) (*compute.Address, error)
+	GetDiskType(project, zone, diskType string) (*compute.DiskType, error)
 	GetMachineType(project, zone, machineType string) (*compute.MachineType, error)
 	GetProject(project string) (*compute.Project, error)
 	GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
@@ -44,20 +62,36 @@ type Client interface {
 	GetInstance(project, zone, name string) (*compute.Instance, error)
 	GetDisk(project, zone, name string) (*compute.Disk, error)
 	GetImage(project, name string) (*compute.Image, error)
+	GetImageFromFamily(project, family string) (*compute.Image, error)
+	GetNetwork(project, name string) (*compute.Network, error)
+	GetRegionDisk(project, region, name string) (*compute.Disk, error)
+	GetRegionDiskType(project, region, diskType string) (*compute.DiskType, error)
+	GetResourcePolicy(project, region, name string) (*compute.ResourcePolicy, error)
+	GetSnapshot(project, name string) (*compute.Snapshot, error)
 	InstanceStatus(project, zone, name string) (string, error)
 	InstanceStopped(project, zone, name string) (bool, error)
+	ListImages(project, filter string) ([]*compute.Image, error)
+	ListSnapshots(project, filter string) ([]*compute.Snapshot, error)
+	SetDeletionProtection(project, zone, name string, protect bool) error
+	TestProjectPermissions(project string, permissions ...string) ([]string, error)
+	GetProjectIamPolicy(project string) (*cloudresourcemanager.Policy, error)
+	SetProjectIamPolicy(project string, p *cloudresourcemanager.Policy) error
+	ListProjects(filter string) ([]*cloudresourcemanager.Project, error)
 	Retry(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
 }
 
 type clientImpl interface {
 	Client
 	operationsWait(project, zone, name string) error
+	regionOperationsWait(project, region, name string) error
 }
 
 type client struct {
-	i   clientImpl
-	hc  *http.Client
-	raw *compute.Service
+	i     clientImpl
+	hc    *http.Client
+	crmHC *http.Client
+	raw   *compute.Service
+	crm   *cloudresourcemanager.Service
 }
 
 // shouldRetryWithWait returns sleeps and returns true if the HTTP
@@ -101,8 +135,7 @@ func shouldRetryWithWait(tripper http.RoundTripper, err error, multiplier int) b
 // NewClient creates a new Google Cloud Compute client.
 func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error) {
 	o := []option.ClientOption{option.WithScopes(compute.ComputeScope)}
-	opts = append(o, opts...)
-	hc, ep, err := transport.NewHTTPClient(ctx, opts...)
+	hc, ep, err := transport.NewHTTPClient(ctx, append(o, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %v", err)
 	}
@@ -110,29 +143,44 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error)
 	if err != nil {
 		return nil, fmt.Errorf("compute client: %v", err)
 	}
+	// Only the resource manager client gets the broader cloud-platform scope.
+	o = []option.ClientOption{option.WithScopes(cloudresourcemanager.CloudPlatformScope)}
+	crmHC, _, err := transport.NewHTTPClient(ctx, append(o, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	crmService, err := cloudresourcemanager.New(crmHC)
+	if err != nil {
+		return nil, fmt.Errorf("resource manager client: %v", err)
+	}
+	// The endpoint is the Compute Engine API's, the resource manager client
+	// keeps its default.
 	if ep != "" {
 		rawService.BasePath = ep
 	}
-	c := &client{hc: hc, raw: rawService}
+	c := &client{hc: hc, crmHC: crmHC, raw: rawService, crm: crmService}
 	c.i = c
 
 	return c, nil
 }
 
 func (c *client) operationsWait(project, zone, name string) error {
+	if zone != "" {
+		return c.waitForOperation(name, c.raw.ZoneOperations.Get(project, zone, name).Do)
+	}
+	return c.waitForOperation(name, c.raw.GlobalOperations.Get(project, name).Do)
+}
+
+func (c *client) regionOperationsWait(project, region, name string) error {
+	return c.waitForOperation(name, c.raw.RegionOperations.Get(project, region, name).Do)
+}
+
+// waitForOperation polls the operation name using get until it is done.
+func (c *client) waitForOperation(name string, get func(opts ...googleapi.CallOption) (*compute.Operation, error)) error {
 	for {
-		var err error
-		var op *compute.Operation
-		if zone != "" {
-			op, err = c.Retry(c.raw.ZoneOperations.Get(project, zone, name).Do)
-			if err != nil {
-				return fmt.Errorf("failed to get operation %s: %v", name, err)
-			}
-		} else {
-			op, err = c.Retry(c.raw.GlobalOperations.Get(project, name).Do)
-			if err != nil {
-				return fmt.Errorf("failed to get operation %s: %v", name, err)
-			}
+		op, err := c.Retry(get)
+		if err != nil {
+			return fmt.Errorf("failed to get operation %s: %v", name, err)
 		}
 		switch op.Status {
 		case "PENDING", "RUNNING":
@@ -169,6 +217,71 @@ func (c *client) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation,
 	return
 }
 
+// AggregatedListDisks lists the GCE disks, zonal and regional, in all
+// zones and regions of project that match filter.
+func (c *client) AggregatedListDisks(project, filter string) ([]*compute.Disk, error) {
+	var ds []*compute.Disk
+	var pt string
+	for {
+		dl, err := c.raw.Disks.AggregatedList(project).Filter(filter).PageToken(pt).Do()
+		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+			dl, err = c.raw.Disks.AggregatedList(project).Filter(filter).PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, dsl := range dl.Items {
+			ds = append(ds, dsl.Disks...)
+		}
+		if dl.NextPageToken == "" {
+			return ds, nil
+		}
+		pt = dl.NextPageToken
+	}
+}
+
+// AggregatedListInstances lists the GCE instances in all zones of project
+// that match filter.
+func (c *client) AggregatedListInstances(project, filter string) ([]*compute.Instance, error) {
+	var is []*compute.Instance
+	var pt string
+	for {
+		il, err := c.raw.Instances.AggregatedList(project).Filter(filter).PageToken(pt).Do()
+		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+			il, err = c.raw.Instances.AggregatedList(project).Filter(filter).PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, isl := range il.Items {
+			is = append(is, isl.Instances...)
+		}
+		if il.NextPageToken == "" {
+			return is, nil
+		}
+		pt = il.NextPageToken
+	}
+}
+
+// CreateAddress reserves a GCE static IP address.
+func (c *client) CreateAddress(project, region string, a *compute.Address) error {
+	op, err := c.Retry(c.raw.Addresses.Insert(project, region, a).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdAddress *compute.Address
+	if createdAddress, err = c.i.GetAddress(project, region, a.Name); err != nil {
+		return err
+	}
+	*a = *createdAddress
+	return nil
+}
+
 // CreateDisk creates a GCE persistent disk.
 func (c *client) CreateDisk(project, zone string, d *compute.Disk) error {
 	op, err := c.Retry(c.raw.Disks.Insert(project, zone, d).Do)
@@ -228,6 +341,82 @@ func (c *client) CreateInstance(project, zone string, i *compute.Instance) error
 	return nil
 }
 
+// CreateNetwork creates a GCE network.
+func (c *client) CreateNetwork(project string, n *compute.Network) error {
+	op, err := c.Retry(c.raw.Networks.Insert(project, n).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.operationsWait(project, "", op.Name); err != nil {
+		return err
+	}
+
+	var createdNetwork *compute.Network
+	if createdNetwork, err = c.i.GetNetwork(project, n.Name); err != nil {
+		return err
+	}
+	*n = *createdNetwork
+	return nil
+}
+
+// CreateRegionDisk creates a GCE regional persistent disk.
+func (c *client) CreateRegionDisk(project, region string, d *compute.Disk) error {
+	op, err := c.Retry(c.raw.RegionDisks.Insert(project, region, d).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdDisk *compute.Disk
+	if createdDisk, err = c.i.GetRegionDisk(project, region, d.Name); err != nil {
+		return err
+	}
+	*d = *createdDisk
+	return nil
+}
+
+// CreateResourcePolicy creates a GCE resource policy.
+func (c *client) CreateResourcePolicy(project, region string, rp *compute.ResourcePolicy) error {
+	op, err := c.Retry(c.raw.ResourcePolicies.Insert(project, region, rp).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdResourcePolicy *compute.ResourcePolicy
+	if createdResourcePolicy, err = c.i.GetResourcePolicy(project, region, rp.Name); err != nil {
+		return err
+	}
+	*rp = *createdResourcePolicy
+	return nil
+}
+
+// CreateSnapshot creates a GCE snapshot of a zonal persistent disk.
+func (c *client) CreateSnapshot(project, zone, disk string, s *compute.Snapshot) error {
+	op, err := c.Retry(c.raw.Disks.CreateSnapshot(project, zone, disk, s).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.operationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdSnapshot *compute.Snapshot
+	if createdSnapshot, err = c.i.GetSnapshot(project, s.Name); err != nil {
+		return err
+	}
+	*s = *createdSnapshot
+	return nil
+}
+
 // DeleteImage deletes a GCE image.
 func (c *client) DeleteImage(project, name string) error {
 	op, err := c.Retry(c.raw.Images.Delete(project, name).Do)
@@ -238,6 +427,26 @@ func (c *client) DeleteImage(project, name string) error {
 	return c.i.operationsWait(project, "", op.Name)
 }
 
+// DeprecateImage sets the deprecation status of a GCE image.
+func (c *client) DeprecateImage(project, name string, ds *compute.DeprecationStatus) error {
+	op, err := c.Retry(c.raw.Images.Deprecate(project, name, ds).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, "", op.Name)
+}
+
+// DeleteAddress releases a GCE static IP address.
+func (c *client) DeleteAddress(project, region, name string) error {
+	op, err := c.Retry(c.raw.Addresses.Delete(project, region, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.regionOperationsWait(project, region, op.Name)
+}
+
 // DeleteDisk deletes a GCE persistent disk.
 func (c *client) DeleteDisk(project, zone, name string) error {
 	op, err := c.Retry(c.raw.Disks.Delete(project, zone, name).Do)
@@ -258,6 +467,93 @@ func (c *client) DeleteInstance(project, zone, name string) error {
 	return c.i.operationsWait(project, zone, op.Name)
 }
 
+// DeleteNetwork deletes a GCE network.
+func (c *client) DeleteNetwork(project, name string) error {
+	op, err := c.Retry(c.raw.Networks.Delete(project, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, "", op.Name)
+}
+
+// DeleteRegionDisk deletes a GCE regional persistent disk.
+func (c *client) DeleteRegionDisk(project, region, name string) error {
+	op, err := c.Retry(c.raw.RegionDisks.Delete(project, region, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.regionOperationsWait(project, region, op.Name)
+}
+
+// DeleteSubnetwork deletes a GCE subnetwork.
+func (c *client) DeleteSubnetwork(project, region, name string) error {
+	op, err := c.Retry(c.raw.Subnetworks.Delete(project, region, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.regionOperationsWait(project, region, op.Name)
+}
+
+// DeleteFirewallRule deletes a GCE firewall rule.
+func (c *client) DeleteFirewallRule(project, name string) error {
+	op, err := c.Retry(c.raw.Firewalls.Delete(project, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, "", op.Name)
+}
+
+// DeleteResourcePolicy deletes a GCE resource policy.
+func (c *client) DeleteResourcePolicy(project, region, name string) error {
+	op, err := c.Retry(c.raw.ResourcePolicies.Delete(project, region, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.regionOperationsWait(project, region, op.Name)
+}
+
+// DeleteSnapshot deletes a GCE snapshot.
+func (c *client) DeleteSnapshot(project, name string) error {
+	op, err := c.Retry(c.raw.Snapshots.Delete(project, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, "", op.Name)
+}
+
+// GetAddress gets a GCE static IP address.
+func (c *client) GetAddress(project, region, name string) (*compute.Address, error) {
+	a, err := c.raw.Addresses.Get(project, region, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Addresses.Get(project, region, name).Do()
+	}
+	return a, err
+}
+
+// GetDiskType gets a GCE DiskType.
+func (c *client) GetDiskType(project, zone, diskType string) (*compute.DiskType, error) {
+	dt, err := c.raw.DiskTypes.Get(project, zone, diskType).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.DiskTypes.Get(project, zone, diskType).Do()
+	}
+	return dt, err
+}
+
+// GetRegionDiskType gets a regional GCE DiskType.
+func (c *client) GetRegionDiskType(project, region, diskType string) (*compute.DiskType, error) {
+	dt, err := c.raw.RegionDiskTypes.Get(project, region, diskType).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.RegionDiskTypes.Get(project, region, diskType).Do()
+	}
+	return dt, err
+}
+
 // GetMachineType gets a GCE MachineType.
 func (c *client) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
 	mt, err := c.raw.MachineTypes.Get(project, zone, machineType).Do()
@@ -321,6 +617,51 @@ func (c *client) GetImage(project, name string) (*compute.Image, error) {
 	return i, err
 }
 
+// GetImageFromFamily gets the latest non-deprecated GCE Image in an image family.
+func (c *client) GetImageFromFamily(project, family string) (*compute.Image, error) {
+	i, err := c.raw.Images.GetFromFamily(project, family).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Images.GetFromFamily(project, family).Do()
+	}
+	return i, err
+}
+
+// GetNetwork gets a GCE Network.
+func (c *client) GetNetwork(project, name string) (*compute.Network, error) {
+	n, err := c.raw.Networks.Get(project, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Networks.Get(project, name).Do()
+	}
+	return n, err
+}
+
+// GetRegionDisk gets a GCE regional Disk.
+func (c *client) GetRegionDisk(project, region, name string) (*compute.Disk, error) {
+	d, err := c.raw.RegionDisks.Get(project, region, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.RegionDisks.Get(project, region, name).Do()
+	}
+	return d, err
+}
+
+// GetResourcePolicy gets a GCE resource policy.
+func (c *client) GetResourcePolicy(project, region, name string) (*compute.ResourcePolicy, error) {
+	rp, err := c.raw.ResourcePolicies.Get(project, region, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.ResourcePolicies.Get(project, region, name).Do()
+	}
+	return rp, err
+}
+
+// GetSnapshot gets a GCE Snapshot.
+func (c *client) GetSnapshot(project, name string) (*compute.Snapshot, error) {
+	s, err := c.raw.Snapshots.Get(project, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		return c.raw.Snapshots.Get(project, name).Do()
+	}
+	return s, err
+}
+
 // InstanceStatus returns an instances Status.
 func (c *client) InstanceStatus(project, zone, name string) (string, error) {
 	is, err := c.raw.Instances.Get(project, zone, name).Do()
@@ -349,3 +690,100 @@ func (c *client) InstanceStopped(project, zone, name string) (bool, error) {
 		return false, fmt.Errorf("unexpected instance status %q", status)
 	}
 }
+
+// ListImages lists the GCE images in project that match filter.
+func (c *client) ListImages(project, filter string) ([]*compute.Image, error) {
+	var is []*compute.Image
+	var pt string
+	for {
+		il, err := c.raw.Images.List(project).Filter(filter).PageToken(pt).Do()
+		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+			il, err = c.raw.Images.List(project).Filter(filter).PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		is = append(is, il.Items...)
+		if il.NextPageToken == "" {
+			return is, nil
+		}
+		pt = il.NextPageToken
+	}
+}
+
+// ListSnapshots lists the GCE snapshots in project that match filter.
+func (c *client) ListSnapshots(project, filter string) ([]*compute.Snapshot, error) {
+	var ss []*compute.Snapshot
+	var pt string
+	for {
+		sl, err := c.raw.Snapshots.List(project).Filter(filter).PageToken(pt).Do()
+		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+			sl, err = c.raw.Snapshots.List(project).Filter(filter).PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, sl.Items...)
+		if sl.NextPageToken == "" {
+			return ss, nil
+		}
+		pt = sl.NextPageToken
+	}
+}
+
+// SetDeletionProtection sets the deletion protection of a GCE instance.
+func (c *client) SetDeletionProtection(project, zone, name string, protect bool) error {
+	op, err := c.Retry(c.raw.Instances.SetDeletionProtection(project, zone, name).DeletionProtection(protect).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.operationsWait(project, zone, op.Name)
+}
+
+// TestProjectPermissions returns the subset of permissions that the caller
+// holds on a GCE project.
+func (c *client) TestProjectPermissions(project string, permissions ...string) ([]string, error) {
+	req := &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions}
+	resp, err := c.crm.Projects.TestIamPermissions(project, req).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
+
+// GetProjectIamPolicy gets the IAM policy of a GCE project. It asks for
+// version 3 so conditional bindings are returned with their conditions.
+func (c *client) GetProjectIamPolicy(project string) (*cloudresourcemanager.Policy, error) {
+	req := &cloudresourcemanager.GetIamPolicyRequest{Options: &cloudresourcemanager.GetPolicyOptions{RequestedPolicyVersion: 3}}
+	return c.crm.Projects.GetIamPolicy(project, req).Do()
+}
+
+// SetProjectIamPolicy sets the IAM policy of a GCE project. The Etag of p
+// must be that of the policy p was read from, it fails with a 409 status if
+// the policy changed since. The Version of p is written as read, so that
+// conditional bindings are kept.
+func (c *client) SetProjectIamPolicy(project string, p *cloudresourcemanager.Policy) error {
+	_, err := c.crm.Projects.SetIamPolicy(project, &cloudresourcemanager.SetIamPolicyRequest{Policy: p}).Do()
+	return err
+}
+
+// ListProjects lists the projects that match filter.
+func (c *client) ListProjects(filter string) ([]*cloudresourcemanager.Project, error) {
+	var ps []*cloudresourcemanager.Project
+	var pt string
+	for {
+		pl, err := c.crm.Projects.List().Filter(filter).PageToken(pt).Do()
+		if shouldRetryWithWait(c.crmHC.Transport, err, 2) {
+			pl, err = c.crm.Projects.List().Filter(filter).PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, pl.Projects...)
+		if pl.NextPageToken == "" {
+			return ps, nil
+		}
+		pt = pl.NextPageToken
+	}
+}
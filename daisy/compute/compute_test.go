@@ -19,20 +19,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
 
 var (
-	testProject  = "test-project"
-	testZone     = "test-zone"
-	testDisk     = "test-disk"
-	testImage    = "test-image"
-	testInstance = "test-instance"
+	testProject        = "test-project"
+	testZone           = "test-zone"
+	testDisk           = "test-disk"
+	testImage          = "test-image"
+	testInstance       = "test-instance"
+	testNetwork        = "test-network"
+	testSnapshot       = "test-snapshot"
+	testRegion         = "test-region"
+	testFirewall       = "test-firewall"
+	testSubnet         = "test-subnetwork"
+	testAddress        = "test-address"
+	testResourcePolicy = "test-resource-policy"
 )
 
 func TestShouldRetryWithWait(t *testing.T) {
@@ -55,6 +64,65 @@ func TestShouldRetryWithWait(t *testing.T) {
 	}
 }
 
+func TestCreateAddress(t *testing.T) {
+	var getErr, insertErr, waitErr error
+	var getResp *compute.Address
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/addresses?alt=json", testProject, testRegion) {
+			if insertErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, insertErr)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/addresses/%s?alt=json", testProject, testRegion, testAddress) {
+			if getErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, getErr)
+				return
+			}
+			body, _ := json.Marshal(getResp)
+			fmt.Fprintln(w, string(body))
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.regionOperationsWaitFn = func(project, region, name string) error { return waitErr }
+
+	tests := []struct {
+		desc                       string
+		getErr, insertErr, waitErr error
+		shouldErr                  bool
+	}{
+		{"normal case", nil, nil, nil, false},
+		{"get err case", errors.New("get err"), nil, nil, true},
+		{"insert err case", nil, errors.New("insert err"), nil, true},
+		{"wait err case", nil, nil, errors.New("wait err"), true},
+	}
+
+	for _, tt := range tests {
+		getErr, insertErr, waitErr = tt.getErr, tt.insertErr, tt.waitErr
+		a := &compute.Address{Name: testAddress}
+		getResp = &compute.Address{Name: testAddress, Address: "10.0.0.1", SelfLink: "foo"}
+		err := c.CreateAddress(testProject, testRegion, a)
+		getResp.ServerResponse = a.ServerResponse // We have to fudge this part in order to check that a == getResp
+		if err != nil && !tt.shouldErr {
+			t.Errorf("%s: got unexpected error: %s", tt.desc, err)
+		} else if diff := pretty.Compare(a, getResp); err == nil && diff != "" {
+			t.Errorf("%s: Address does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
 func TestCreateDisk(t *testing.T) {
 	var getErr, insertErr, waitErr error
 	var getResp *compute.Disk
@@ -231,6 +299,263 @@ func TestCreateInstance(t *testing.T) {
 	}
 }
 
+func TestCreateNetwork(t *testing.T) {
+	var getErr, insertErr, waitErr error
+	var getResp *compute.Network
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/global/networks?alt=json", testProject) {
+			if insertErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, insertErr)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/networks/%s?alt=json", testProject, testNetwork) {
+			if getErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, getErr)
+				return
+			}
+			body, _ := json.Marshal(getResp)
+			fmt.Fprintln(w, string(body))
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.operationsWaitFn = func(project, zone, name string) error { return waitErr }
+
+	tests := []struct {
+		desc                       string
+		getErr, insertErr, waitErr error
+		shouldErr                  bool
+	}{
+		{"normal case", nil, nil, nil, false},
+		{"get err case", errors.New("get err"), nil, nil, true},
+		{"insert err case", nil, errors.New("insert err"), nil, true},
+		{"wait err case", nil, nil, errors.New("wait err"), true},
+	}
+
+	for _, tt := range tests {
+		getErr, insertErr, waitErr = tt.getErr, tt.insertErr, tt.waitErr
+		n := &compute.Network{Name: testNetwork}
+		getResp = &compute.Network{Name: testNetwork, SelfLink: "foo"}
+		err := c.CreateNetwork(testProject, n)
+		getResp.ServerResponse = n.ServerResponse // We have to fudge this part in order to check that n == getResp
+		if err != nil && !tt.shouldErr {
+			t.Errorf("%s: got unexpected error: %s", tt.desc, err)
+		} else if diff := pretty.Compare(n, getResp); err == nil && diff != "" {
+			t.Errorf("%s: Network does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateRegionDisk(t *testing.T) {
+	var getErr, insertErr, waitErr error
+	var getResp *compute.Disk
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/disks?alt=json", testProject, testRegion) {
+			if insertErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, insertErr)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/disks/%s?alt=json", testProject, testRegion, testDisk) {
+			if getErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, getErr)
+				return
+			}
+			body, _ := json.Marshal(getResp)
+			fmt.Fprintln(w, string(body))
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.regionOperationsWaitFn = func(project, region, name string) error { return waitErr }
+
+	tests := []struct {
+		desc                       string
+		getErr, insertErr, waitErr error
+		shouldErr                  bool
+	}{
+		{"normal case", nil, nil, nil, false},
+		{"get err case", errors.New("get err"), nil, nil, true},
+		{"insert err case", nil, errors.New("insert err"), nil, true},
+		{"wait err case", nil, nil, errors.New("wait err"), true},
+	}
+
+	for _, tt := range tests {
+		getErr, insertErr, waitErr = tt.getErr, tt.insertErr, tt.waitErr
+		d := &compute.Disk{Name: testDisk}
+		getResp = &compute.Disk{Name: testDisk, SelfLink: "foo"}
+		err := c.CreateRegionDisk(testProject, testRegion, d)
+		getResp.ServerResponse = d.ServerResponse // We have to fudge this part in order to check that d == getResp
+		if err != nil && !tt.shouldErr {
+			t.Errorf("%s: got unexpected error: %s", tt.desc, err)
+		} else if diff := pretty.Compare(d, getResp); err == nil && diff != "" {
+			t.Errorf("%s: Disk does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateResourcePolicy(t *testing.T) {
+	var getErr, insertErr, waitErr error
+	var getResp *compute.ResourcePolicy
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/resourcePolicies?alt=json", testProject, testRegion) {
+			if insertErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, insertErr)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/resourcePolicies/%s?alt=json", testProject, testRegion, testResourcePolicy) {
+			if getErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, getErr)
+				return
+			}
+			body, _ := json.Marshal(getResp)
+			fmt.Fprintln(w, string(body))
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.regionOperationsWaitFn = func(project, region, name string) error { return waitErr }
+
+	tests := []struct {
+		desc                       string
+		getErr, insertErr, waitErr error
+		shouldErr                  bool
+	}{
+		{"normal case", nil, nil, nil, false},
+		{"get err case", errors.New("get err"), nil, nil, true},
+		{"insert err case", nil, errors.New("insert err"), nil, true},
+		{"wait err case", nil, nil, errors.New("wait err"), true},
+	}
+
+	for _, tt := range tests {
+		getErr, insertErr, waitErr = tt.getErr, tt.insertErr, tt.waitErr
+		rp := &compute.ResourcePolicy{Name: testResourcePolicy}
+		getResp = &compute.ResourcePolicy{Name: testResourcePolicy, SelfLink: "foo"}
+		err := c.CreateResourcePolicy(testProject, testRegion, rp)
+		getResp.ServerResponse = rp.ServerResponse // We have to fudge this part in order to check that rp == getResp
+		if err != nil && !tt.shouldErr {
+			t.Errorf("%s: got unexpected error: %s", tt.desc, err)
+		} else if diff := pretty.Compare(rp, getResp); err == nil && diff != "" {
+			t.Errorf("%s: ResourcePolicy does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	var getErr, insertErr, waitErr error
+	var getResp *compute.Snapshot
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/disks/%s/createSnapshot?alt=json", testProject, testZone, testDisk) {
+			if insertErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, insertErr)
+				return
+			}
+			buf := new(bytes.Buffer)
+			if _, err := buf.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/snapshots/%s?alt=json", testProject, testSnapshot) {
+			if getErr != nil {
+				w.WriteHeader(400)
+				fmt.Fprintln(w, getErr)
+				return
+			}
+			body, _ := json.Marshal(getResp)
+			fmt.Fprintln(w, string(body))
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.operationsWaitFn = func(project, zone, name string) error { return waitErr }
+
+	tests := []struct {
+		desc                       string
+		getErr, insertErr, waitErr error
+		shouldErr                  bool
+	}{
+		{"normal case", nil, nil, nil, false},
+		{"get err case", errors.New("get err"), nil, nil, true},
+		{"insert err case", nil, errors.New("insert err"), nil, true},
+		{"wait err case", nil, nil, errors.New("wait err"), true},
+	}
+
+	for _, tt := range tests {
+		getErr, insertErr, waitErr = tt.getErr, tt.insertErr, tt.waitErr
+		s := &compute.Snapshot{Name: testSnapshot}
+		getResp = &compute.Snapshot{Name: testSnapshot, SelfLink: "foo"}
+		err := c.CreateSnapshot(testProject, testZone, testDisk, s)
+		getResp.ServerResponse = s.ServerResponse // We have to fudge this part in order to check that s == getResp
+		if err != nil && !tt.shouldErr {
+			t.Errorf("%s: got unexpected error: %s", tt.desc, err)
+		} else if diff := pretty.Compare(s, getResp); err == nil && diff != "" {
+			t.Errorf("%s: Snapshot does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestDeleteAddress(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/addresses/%s?alt=json", testProject, testRegion, testAddress) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/operations/?alt=json", testProject, testRegion) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteAddress(testProject, testRegion, testAddress); err != nil {
+		t.Fatalf("error running DeleteAddress: %v", err)
+	}
+}
+
 func TestDeleteDisk(t *testing.T) {
 	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/disks/%s?alt=json", testProject, testZone, testDisk) {
@@ -273,6 +598,27 @@ func TestDeleteImage(t *testing.T) {
 	}
 }
 
+func TestDeprecateImage(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/global/images/%s/deprecate?alt=json", testProject, testImage) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/operations/?alt=json", testProject) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeprecateImage(testProject, testImage, &compute.DeprecationStatus{State: "DEPRECATED"}); err != nil {
+		t.Fatalf("error running DeprecateImage: %v", err)
+	}
+}
+
 func TestDeleteInstance(t *testing.T) {
 	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json", testProject, testZone, testInstance) {
@@ -293,3 +639,274 @@ func TestDeleteInstance(t *testing.T) {
 		t.Fatalf("error running DeleteInstance: %v", err)
 	}
 }
+
+func TestDeleteNetwork(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/global/networks/%s?alt=json", testProject, testNetwork) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/operations/?alt=json", testProject) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteNetwork(testProject, testNetwork); err != nil {
+		t.Fatalf("error running DeleteNetwork: %v", err)
+	}
+}
+
+func TestDeleteRegionDisk(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/disks/%s?alt=json", testProject, testRegion, testDisk) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/operations/?alt=json", testProject, testRegion) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteRegionDisk(testProject, testRegion, testDisk); err != nil {
+		t.Fatalf("error running DeleteRegionDisk: %v", err)
+	}
+}
+
+func TestDeleteFirewallRule(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/global/firewalls/%s?alt=json", testProject, testFirewall) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/operations/?alt=json", testProject) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteFirewallRule(testProject, testFirewall); err != nil {
+		t.Fatalf("error running DeleteFirewallRule: %v", err)
+	}
+}
+
+func TestDeleteSubnetwork(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/subnetworks/%s?alt=json", testProject, testRegion, testSubnet) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/operations/?alt=json", testProject, testRegion) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteSubnetwork(testProject, testRegion, testSubnet); err != nil {
+		t.Fatalf("error running DeleteSubnetwork: %v", err)
+	}
+}
+
+func TestDeleteResourcePolicy(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/resourcePolicies/%s?alt=json", testProject, testRegion, testResourcePolicy) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/regions/%s/operations/?alt=json", testProject, testRegion) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteResourcePolicy(testProject, testRegion, testResourcePolicy); err != nil {
+		t.Fatalf("error running DeleteResourcePolicy: %v", err)
+	}
+}
+
+func TestDeleteSnapshot(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.String() == fmt.Sprintf("/%s/global/snapshots/%s?alt=json", testProject, testSnapshot) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/global/operations/?alt=json", testProject) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.DeleteSnapshot(testProject, testSnapshot); err != nil {
+		t.Fatalf("error running DeleteSnapshot: %v", err)
+	}
+}
+
+// listHandler serves pages, keyed by page token, of the list at path.
+func listHandler(t *testing.T, path, filter string, pages map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != path {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+			return
+		}
+		if got := r.URL.Query().Get("filter"); got != filter {
+			t.Errorf("unexpected filter, got: %q, want: %q", got, filter)
+		}
+		fmt.Fprint(w, pages[r.URL.Query().Get("pageToken")])
+	}
+}
+
+func TestAggregatedListDisks(t *testing.T) {
+	svr, c, err := NewTestClient(listHandler(t, fmt.Sprintf("/%s/aggregated/disks", testProject), "f", map[string]string{
+		"":   `{"items": {"zones/z1": {"disks": [{"name": "d1"}]}, "zones/z2": {}}, "nextPageToken": "p2"}`,
+		"p2": `{"items": {"regions/r1": {"disks": [{"name": "d2"}]}}}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	ds, err := c.AggregatedListDisks(testProject, "f")
+	if err != nil {
+		t.Fatalf("error running AggregatedListDisks: %v", err)
+	}
+	want := []*compute.Disk{{Name: "d1"}, {Name: "d2"}}
+	if diff := pretty.Compare(ds, want); diff != "" {
+		t.Errorf("Disks do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestAggregatedListInstances(t *testing.T) {
+	svr, c, err := NewTestClient(listHandler(t, fmt.Sprintf("/%s/aggregated/instances", testProject), "f", map[string]string{
+		"":   `{"items": {"zones/z1": {"instances": [{"name": "i1"}]}}, "nextPageToken": "p2"}`,
+		"p2": `{"items": {"zones/z2": {"instances": [{"name": "i2"}]}}}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	is, err := c.AggregatedListInstances(testProject, "f")
+	if err != nil {
+		t.Fatalf("error running AggregatedListInstances: %v", err)
+	}
+	want := []*compute.Instance{{Name: "i1"}, {Name: "i2"}}
+	if diff := pretty.Compare(is, want); diff != "" {
+		t.Errorf("Instances do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestListImages(t *testing.T) {
+	svr, c, err := NewTestClient(listHandler(t, fmt.Sprintf("/%s/global/images", testProject), "f", map[string]string{
+		"":   `{"items": [{"name": "i1"}], "nextPageToken": "p2"}`,
+		"p2": `{"items": [{"name": "i2"}]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	is, err := c.ListImages(testProject, "f")
+	if err != nil {
+		t.Fatalf("error running ListImages: %v", err)
+	}
+	want := []*compute.Image{{Name: "i1"}, {Name: "i2"}}
+	if diff := pretty.Compare(is, want); diff != "" {
+		t.Errorf("Images do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	svr, c, err := NewTestClient(listHandler(t, fmt.Sprintf("/%s/global/snapshots", testProject), "f", map[string]string{
+		"":   `{"items": [{"name": "s1"}], "nextPageToken": "p2"}`,
+		"p2": `{"items": [{"name": "s2"}]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	ss, err := c.ListSnapshots(testProject, "f")
+	if err != nil {
+		t.Fatalf("error running ListSnapshots: %v", err)
+	}
+	want := []*compute.Snapshot{{Name: "s1"}, {Name: "s2"}}
+	if diff := pretty.Compare(ss, want); diff != "" {
+		t.Errorf("Snapshots do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestListProjects(t *testing.T) {
+	svr, c, err := NewTestClient(listHandler(t, "/v1/projects", "f", map[string]string{
+		"":   `{"projects": [{"projectId": "p1"}], "nextPageToken": "p2"}`,
+		"p2": `{"projects": [{"projectId": "p2"}]}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	ps, err := c.ListProjects("f")
+	if err != nil {
+		t.Fatalf("error running ListProjects: %v", err)
+	}
+	want := []*cloudresourcemanager.Project{{ProjectId: "p1"}, {ProjectId: "p2"}}
+	if diff := pretty.Compare(ps, want); diff != "" {
+		t.Errorf("Projects do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestProjectIamPolicy(t *testing.T) {
+	var gotBodies []string
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBodies = append(gotBodies, fmt.Sprintf("%s %s", r.URL.Path, bytes.TrimSpace(b)))
+		fmt.Fprint(w, `{"version": 3, "etag": "e", "bindings": [{"role": "r", "members": ["m"], "condition": {"expression": "x"}}]}`)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	p, err := c.GetProjectIamPolicy("p")
+	if err != nil {
+		t.Fatalf("error running GetProjectIamPolicy: %v", err)
+	}
+	if err := c.SetProjectIamPolicy("p", p); err != nil {
+		t.Fatalf("error running SetProjectIamPolicy: %v", err)
+	}
+	// The policy is read as version 3 and written back with its version and
+	// conditions.
+	want := []string{
+		`/v1/projects/p:getIamPolicy {"options":{"requestedPolicyVersion":3}}`,
+		`/v1/projects/p:setIamPolicy {"policy":{"bindings":[{"condition":{"expression":"x"},"members":["m"],"role":"r"}],"etag":"e","version":3}}`,
+	}
+	if diff := pretty.Compare(gotBodies, want); diff != "" {
+		t.Errorf("requests do not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	"google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
@@ -40,30 +41,63 @@ func NewTestClient(handleFunc http.HandlerFunc) (*httptest.Server, *TestClient,
 	tc := &TestClient{}
 	tc.client = *c.(*client)
 	tc.client.i = tc
+	// Serve the resource manager API from ts as well.
+	tc.client.crm.BasePath = ts.URL
 	return ts, tc, nil
 }
 
 // TestClient is a Client with overrideable methods.
 type TestClient struct {
 	client
-	CreateDiskFn          func(project, zone string, d *compute.Disk) error
-	CreateImageFn         func(project string, i *compute.Image) error
-	CreateInstanceFn      func(project, zone string, i *compute.Instance) error
-	DeleteDiskFn          func(project, zone, name string) error
-	DeleteImageFn         func(project, name string) error
-	DeleteInstanceFn      func(project, zone, name string) error
-	GetMachineTypeFn      func(project, zone, machineType string) (*compute.MachineType, error)
-	GetProjectFn          func(project string) (*compute.Project, error)
-	GetSerialPortOutputFn func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
-	GetZoneFn             func(project, zone string) (*compute.Zone, error)
-	GetInstanceFn         func(project, zone, name string) (*compute.Instance, error)
-	GetDiskFn             func(project, zone, name string) (*compute.Disk, error)
-	GetImageFn            func(project, name string) (*compute.Image, error)
-	InstanceStatusFn      func(project, zone, name string) (string, error)
-	InstanceStoppedFn     func(project, zone, name string) (bool, error)
-	RetryFn               func(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
-
-	operationsWaitFn func(project, zone, name string) error
+	AggregatedListDisksFn     func(project, filter string) ([]*compute.Disk, error)
+	AggregatedListInstancesFn func(project, filter string) ([]*compute.Instance, error)
+	CreateAddressFn           func(project, region string, a *compute.Address) error
+	CreateDiskFn              func(project, zone string, d *compute.Disk) error
+	CreateImageFn             func(project string, i *compute.Image) error
+	CreateNetworkFn           func(project string, n *compute.Network) error
+	CreateInstanceFn          func(project, zone string, i *compute.Instance) error
+	CreateRegionDiskFn        func(project, region string, d *compute.Disk) error
+	CreateResourcePolicyFn    func(project, region string, rp *compute.ResourcePolicy) error
+	CreateSnapshotFn          func(project, zone, disk string, s *compute.Snapshot) error
+	DeleteAddressFn           func(project, region, name string) error
+	DeleteDiskFn              func(project, zone, name string) error
+	DeleteFirewallRuleFn      func(project, name string) error
+	DeleteImageFn             func(project, name string) error
+	DeleteNetworkFn           func(project, name string) error
+	DeleteInstanceFn          func(project, zone, name string) error
+	DeleteRegionDiskFn        func(project, region, name string) error
+	DeleteResourcePolicyFn    func(project, region, name string) error
+	DeleteSnapshotFn          func(project, name string) error
+	DeleteSubnetworkFn        func(project, region, name string) error
+	DeprecateImageFn          func(project, name string, ds *compute.DeprecationStatus) error
+	GetAddressFn              func(project, region, name string) (*compute.Address, error)
+	GetDiskTypeFn             func(project, zone, diskType string) (*compute.DiskType, error)
+	GetMachineTypeFn          func(project, zone, machineType string) (*compute.MachineType, error)
+	GetProjectFn              func(project string) (*compute.Project, error)
+	GetSerialPortOutputFn     func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
+	GetZoneFn                 func(project, zone string) (*compute.Zone, error)
+	GetInstanceFn             func(project, zone, name string) (*compute.Instance, error)
+	GetDiskFn                 func(project, zone, name string) (*compute.Disk, error)
+	GetNetworkFn              func(project, name string) (*compute.Network, error)
+	GetImageFn                func(project, name string) (*compute.Image, error)
+	GetImageFromFamilyFn      func(project, family string) (*compute.Image, error)
+	GetRegionDiskFn           func(project, region, name string) (*compute.Disk, error)
+	GetRegionDiskTypeFn       func(project, region, diskType string) (*compute.DiskType, error)
+	GetResourcePolicyFn       func(project, region, name string) (*compute.ResourcePolicy, error)
+	GetSnapshotFn             func(project, name string) (*compute.Snapshot, error)
+	InstanceStatusFn          func(project, zone, name string) (string, error)
+	InstanceStoppedFn         func(project, zone, name string) (bool, error)
+	ListImagesFn              func(project, filter string) ([]*compute.Image, error)
+	ListSnapshotsFn           func(project, filter string) ([]*compute.Snapshot, error)
+	SetDeletionProtectionFn   func(project, zone, name string, protect bool) error
+	TestProjectPermissionsFn  func(project string, permissions ...string) ([]string, error)
+	GetProjectIamPolicyFn     func(project string) (*cloudresourcemanager.Policy, error)
+	SetProjectIamPolicyFn     func(project string, p *cloudresourcemanager.Policy) error
+	ListProjectsFn            func(filter string) ([]*cloudresourcemanager.Project, error)
+	RetryFn                   func(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
+
+	operationsWaitFn       func(project, zone, name string) error
+	regionOperationsWaitFn func(project, region, name string) error
 }
 
 // CreateDisk uses the override method CreateDiskFn or the real implementation.
@@ -74,6 +108,30 @@ func (c *TestClient) Retry(f func(opts ...googleapi.CallOption) (*compute.Operat
 	return c.client.Retry(f, opts...)
 }
 
+// AggregatedListDisks uses the override method AggregatedListDisksFn or the real implementation.
+func (c *TestClient) AggregatedListDisks(project, filter string) ([]*compute.Disk, error) {
+	if c.AggregatedListDisksFn != nil {
+		return c.AggregatedListDisksFn(project, filter)
+	}
+	return c.client.AggregatedListDisks(project, filter)
+}
+
+// AggregatedListInstances uses the override method AggregatedListInstancesFn or the real implementation.
+func (c *TestClient) AggregatedListInstances(project, filter string) ([]*compute.Instance, error) {
+	if c.AggregatedListInstancesFn != nil {
+		return c.AggregatedListInstancesFn(project, filter)
+	}
+	return c.client.AggregatedListInstances(project, filter)
+}
+
+// CreateAddress uses the override method CreateAddressFn or the real implementation.
+func (c *TestClient) CreateAddress(project, region string, a *compute.Address) error {
+	if c.CreateAddressFn != nil {
+		return c.CreateAddressFn(project, region, a)
+	}
+	return c.client.CreateAddress(project, region, a)
+}
+
 // CreateDisk uses the override method CreateDiskFn or the real implementation.
 func (c *TestClient) CreateDisk(project, zone string, d *compute.Disk) error {
 	if c.CreateDiskFn != nil {
@@ -98,6 +156,46 @@ func (c *TestClient) CreateInstance(project, zone string, i *compute.Instance) e
 	return c.client.CreateInstance(project, zone, i)
 }
 
+// CreateNetwork uses the override method CreateNetworkFn or the real implementation.
+func (c *TestClient) CreateNetwork(project string, n *compute.Network) error {
+	if c.CreateNetworkFn != nil {
+		return c.CreateNetworkFn(project, n)
+	}
+	return c.client.CreateNetwork(project, n)
+}
+
+// CreateRegionDisk uses the override method CreateRegionDiskFn or the real implementation.
+func (c *TestClient) CreateRegionDisk(project, region string, d *compute.Disk) error {
+	if c.CreateRegionDiskFn != nil {
+		return c.CreateRegionDiskFn(project, region, d)
+	}
+	return c.client.CreateRegionDisk(project, region, d)
+}
+
+// CreateResourcePolicy uses the override method CreateResourcePolicyFn or the real implementation.
+func (c *TestClient) CreateResourcePolicy(project, region string, rp *compute.ResourcePolicy) error {
+	if c.CreateResourcePolicyFn != nil {
+		return c.CreateResourcePolicyFn(project, region, rp)
+	}
+	return c.client.CreateResourcePolicy(project, region, rp)
+}
+
+// CreateSnapshot uses the override method CreateSnapshotFn or the real implementation.
+func (c *TestClient) CreateSnapshot(project, zone, disk string, s *compute.Snapshot) error {
+	if c.CreateSnapshotFn != nil {
+		return c.CreateSnapshotFn(project, zone, disk, s)
+	}
+	return c.client.CreateSnapshot(project, zone, disk, s)
+}
+
+// DeleteAddress uses the override method DeleteAddressFn or the real implementation.
+func (c *TestClient) DeleteAddress(project, region, name string) error {
+	if c.DeleteAddressFn != nil {
+		return c.DeleteAddressFn(project, region, name)
+	}
+	return c.client.DeleteAddress(project, region, name)
+}
+
 // DeleteDisk uses the override method DeleteDiskFn or the real implementation.
 func (c *TestClient) DeleteDisk(project, zone, name string) error {
 	if c.DeleteDiskFn != nil {
@@ -106,6 +204,14 @@ func (c *TestClient) DeleteDisk(project, zone, name string) error {
 	return c.client.DeleteDisk(project, zone, name)
 }
 
+// DeleteFirewallRule uses the override method DeleteFirewallRuleFn or the real implementation.
+func (c *TestClient) DeleteFirewallRule(project, name string) error {
+	if c.DeleteFirewallRuleFn != nil {
+		return c.DeleteFirewallRuleFn(project, name)
+	}
+	return c.client.DeleteFirewallRule(project, name)
+}
+
 // DeleteImage uses the override method DeleteImageFn or the real implementation.
 func (c *TestClient) DeleteImage(project, name string) error {
 	if c.DeleteImageFn != nil {
@@ -114,6 +220,22 @@ func (c *TestClient) DeleteImage(project, name string) error {
 	return c.client.DeleteImage(project, name)
 }
 
+// DeleteSubnetwork uses the override method DeleteSubnetworkFn or the real implementation.
+func (c *TestClient) DeleteSubnetwork(project, region, name string) error {
+	if c.DeleteSubnetworkFn != nil {
+		return c.DeleteSubnetworkFn(project, region, name)
+	}
+	return c.client.DeleteSubnetwork(project, region, name)
+}
+
+// DeprecateImage uses the override method DeprecateImageFn or the real implementation.
+func (c *TestClient) DeprecateImage(project, name string, ds *compute.DeprecationStatus) error {
+	if c.DeprecateImageFn != nil {
+		return c.DeprecateImageFn(project, name, ds)
+	}
+	return c.client.DeprecateImage(project, name, ds)
+}
+
 // DeleteInstance uses the override method DeleteInstanceFn or the real implementation.
 func (c *TestClient) DeleteInstance(project, zone, name string) error {
 	if c.DeleteInstanceFn != nil {
@@ -122,6 +244,38 @@ func (c *TestClient) DeleteInstance(project, zone, name string) error {
 	return c.client.DeleteInstance(project, zone, name)
 }
 
+// DeleteNetwork uses the override method DeleteNetworkFn or the real implementation.
+func (c *TestClient) DeleteNetwork(project, name string) error {
+	if c.DeleteNetworkFn != nil {
+		return c.DeleteNetworkFn(project, name)
+	}
+	return c.client.DeleteNetwork(project, name)
+}
+
+// DeleteRegionDisk uses the override method DeleteRegionDiskFn or the real implementation.
+func (c *TestClient) DeleteRegionDisk(project, region, name string) error {
+	if c.DeleteRegionDiskFn != nil {
+		return c.DeleteRegionDiskFn(project, region, name)
+	}
+	return c.client.DeleteRegionDisk(project, region, name)
+}
+
+// DeleteResourcePolicy uses the override method DeleteResourcePolicyFn or the real implementation.
+func (c *TestClient) DeleteResourcePolicy(project, region, name string) error {
+	if c.DeleteResourcePolicyFn != nil {
+		return c.DeleteResourcePolicyFn(project, region, name)
+	}
+	return c.client.DeleteResourcePolicy(project, region, name)
+}
+
+// DeleteSnapshot uses the override method DeleteSnapshotFn or the real implementation.
+func (c *TestClient) DeleteSnapshot(project, name string) error {
+	if c.DeleteSnapshotFn != nil {
+		return c.DeleteSnapshotFn(project, name)
+	}
+	return c.client.DeleteSnapshot(project, name)
+}
+
 // GetProject uses the override method GetProjectFn or the real implementation.
 func (c *TestClient) GetProject(project string) (*compute.Project, error) {
 	if c.GetProjectFn != nil {
@@ -130,6 +284,30 @@ func (c *TestClient) GetProject(project string) (*compute.Project, error) {
 	return c.client.GetProject(project)
 }
 
+// GetAddress uses the override method GetAddressFn or the real implementation.
+func (c *TestClient) GetAddress(project, region, name string) (*compute.Address, error) {
+	if c.GetAddressFn != nil {
+		return c.GetAddressFn(project, region, name)
+	}
+	return c.client.GetAddress(project, region, name)
+}
+
+// GetDiskType uses the override method GetDiskTypeFn or the real implementation.
+func (c *TestClient) GetDiskType(project, zone, diskType string) (*compute.DiskType, error) {
+	if c.GetDiskTypeFn != nil {
+		return c.GetDiskTypeFn(project, zone, diskType)
+	}
+	return c.client.GetDiskType(project, zone, diskType)
+}
+
+// GetRegionDiskType uses the override method GetRegionDiskTypeFn or the real implementation.
+func (c *TestClient) GetRegionDiskType(project, region, diskType string) (*compute.DiskType, error) {
+	if c.GetRegionDiskTypeFn != nil {
+		return c.GetRegionDiskTypeFn(project, region, diskType)
+	}
+	return c.client.GetRegionDiskType(project, region, diskType)
+}
+
 // GetMachineType uses the override method GetMachineTypeFn or the real implementation.
 func (c *TestClient) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
 	if c.GetZoneFn != nil {
@@ -170,6 +348,46 @@ func (c *TestClient) GetImage(project, name string) (*compute.Image, error) {
 	return c.client.GetImage(project, name)
 }
 
+// GetImageFromFamily uses the override method GetImageFromFamilyFn or the real implementation.
+func (c *TestClient) GetImageFromFamily(project, family string) (*compute.Image, error) {
+	if c.GetImageFromFamilyFn != nil {
+		return c.GetImageFromFamilyFn(project, family)
+	}
+	return c.client.GetImageFromFamily(project, family)
+}
+
+// GetNetwork uses the override method GetNetworkFn or the real implementation.
+func (c *TestClient) GetNetwork(project, name string) (*compute.Network, error) {
+	if c.GetNetworkFn != nil {
+		return c.GetNetworkFn(project, name)
+	}
+	return c.client.GetNetwork(project, name)
+}
+
+// GetRegionDisk uses the override method GetRegionDiskFn or the real implementation.
+func (c *TestClient) GetRegionDisk(project, region, name string) (*compute.Disk, error) {
+	if c.GetRegionDiskFn != nil {
+		return c.GetRegionDiskFn(project, region, name)
+	}
+	return c.client.GetRegionDisk(project, region, name)
+}
+
+// GetResourcePolicy uses the override method GetResourcePolicyFn or the real implementation.
+func (c *TestClient) GetResourcePolicy(project, region, name string) (*compute.ResourcePolicy, error) {
+	if c.GetResourcePolicyFn != nil {
+		return c.GetResourcePolicyFn(project, region, name)
+	}
+	return c.client.GetResourcePolicy(project, region, name)
+}
+
+// GetSnapshot uses the override method GetSnapshotFn or the real implementation.
+func (c *TestClient) GetSnapshot(project, name string) (*compute.Snapshot, error) {
+	if c.GetSnapshotFn != nil {
+		return c.GetSnapshotFn(project, name)
+	}
+	return c.client.GetSnapshot(project, name)
+}
+
 // GetSerialPortOutput uses the override method GetSerialPortOutputFn or the real implementation.
 func (c *TestClient) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
 	if c.GetSerialPortOutputFn != nil {
@@ -194,6 +412,62 @@ func (c *TestClient) InstanceStopped(project, zone, name string) (bool, error) {
 	return c.client.InstanceStopped(project, zone, name)
 }
 
+// ListImages uses the override method ListImagesFn or the real implementation.
+func (c *TestClient) ListImages(project, filter string) ([]*compute.Image, error) {
+	if c.ListImagesFn != nil {
+		return c.ListImagesFn(project, filter)
+	}
+	return c.client.ListImages(project, filter)
+}
+
+// ListSnapshots uses the override method ListSnapshotsFn or the real implementation.
+func (c *TestClient) ListSnapshots(project, filter string) ([]*compute.Snapshot, error) {
+	if c.ListSnapshotsFn != nil {
+		return c.ListSnapshotsFn(project, filter)
+	}
+	return c.client.ListSnapshots(project, filter)
+}
+
+// SetDeletionProtection uses the override method SetDeletionProtectionFn or the real implementation.
+func (c *TestClient) SetDeletionProtection(project, zone, name string, protect bool) error {
+	if c.SetDeletionProtectionFn != nil {
+		return c.SetDeletionProtectionFn(project, zone, name, protect)
+	}
+	return c.client.SetDeletionProtection(project, zone, name, protect)
+}
+
+// TestProjectPermissions uses the override method TestProjectPermissionsFn or the real implementation.
+func (c *TestClient) TestProjectPermissions(project string, permissions ...string) ([]string, error) {
+	if c.TestProjectPermissionsFn != nil {
+		return c.TestProjectPermissionsFn(project, permissions...)
+	}
+	return c.client.TestProjectPermissions(project, permissions...)
+}
+
+// GetProjectIamPolicy uses the override method GetProjectIamPolicyFn or the real implementation.
+func (c *TestClient) GetProjectIamPolicy(project string) (*cloudresourcemanager.Policy, error) {
+	if c.GetProjectIamPolicyFn != nil {
+		return c.GetProjectIamPolicyFn(project)
+	}
+	return c.client.GetProjectIamPolicy(project)
+}
+
+// SetProjectIamPolicy uses the override method SetProjectIamPolicyFn or the real implementation.
+func (c *TestClient) SetProjectIamPolicy(project string, p *cloudresourcemanager.Policy) error {
+	if c.SetProjectIamPolicyFn != nil {
+		return c.SetProjectIamPolicyFn(project, p)
+	}
+	return c.client.SetProjectIamPolicy(project, p)
+}
+
+// ListProjects uses the override method ListProjectsFn or the real implementation.
+func (c *TestClient) ListProjects(filter string) ([]*cloudresourcemanager.Project, error) {
+	if c.ListProjectsFn != nil {
+		return c.ListProjectsFn(filter)
+	}
+	return c.client.ListProjects(filter)
+}
+
 // operationsWait uses the override method operationsWaitFn or the real implementation.
 func (c *TestClient) operationsWait(project, zone, name string) error {
 	if c.operationsWaitFn != nil {
@@ -201,3 +475,11 @@ func (c *TestClient) operationsWait(project, zone, name string) error {
 	}
 	return c.client.operationsWait(project, zone, name)
 }
+
+// regionOperationsWait uses the override method regionOperationsWaitFn or the real implementation.
+func (c *TestClient) regionOperationsWait(project, region, name string) error {
+	if c.regionOperationsWaitFn != nil {
+		return c.regionOperationsWaitFn(project, region, name)
+	}
+	return c.client.regionOperationsWait(project, region, name)
+}
@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"testing"
 
+	"google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -39,22 +40,52 @@ func TestTestClient(t *testing.T) {
 		{"retry", func() {
 			c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) { realCalled = true; return nil, nil })
 		}},
+		{"aggregated list disks", func() { c.AggregatedListDisks("a", "b") }},
+		{"aggregated list instances", func() { c.AggregatedListInstances("a", "b") }},
+		{"create address", func() { c.CreateAddress("a", "b", &compute.Address{}) }},
 		{"create disk", func() { c.CreateDisk("a", "b", &compute.Disk{}) }},
 		{"create image", func() { c.CreateImage("a", &compute.Image{}) }},
 		{"create instance", func() { c.CreateInstance("a", "b", &compute.Instance{}) }},
+		{"create network", func() { c.CreateNetwork("a", &compute.Network{}) }},
+		{"create region disk", func() { c.CreateRegionDisk("a", "b", &compute.Disk{}) }},
+		{"create resource policy", func() { c.CreateResourcePolicy("a", "b", &compute.ResourcePolicy{}) }},
+		{"create snapshot", func() { c.CreateSnapshot("a", "b", "c", &compute.Snapshot{}) }},
+		{"delete address", func() { c.DeleteAddress("a", "b", "c") }},
 		{"delete disk", func() { c.DeleteDisk("a", "b", "c") }},
+		{"delete firewall rule", func() { c.DeleteFirewallRule("a", "b") }},
 		{"delete image", func() { c.DeleteImage("a", "b") }},
 		{"delete instance", func() { c.DeleteInstance("a", "b", "c") }},
+		{"delete network", func() { c.DeleteNetwork("a", "b") }},
+		{"delete region disk", func() { c.DeleteRegionDisk("a", "b", "c") }},
+		{"delete resource policy", func() { c.DeleteResourcePolicy("a", "b", "c") }},
+		{"delete snapshot", func() { c.DeleteSnapshot("a", "b") }},
+		{"delete subnetwork", func() { c.DeleteSubnetwork("a", "b", "c") }},
+		{"deprecate image", func() { c.DeprecateImage("a", "b", &compute.DeprecationStatus{}) }},
+		{"get address", func() { c.GetAddress("a", "b", "c") }},
 		{"get serial port", func() { c.GetSerialPortOutput("a", "b", "c", 1, 2) }},
 		{"get project", func() { c.GetProject("a") }},
+		{"get disk type", func() { c.GetDiskType("a", "b", "c") }},
 		{"get machine type", func() { c.GetMachineType("a", "b", "c") }},
 		{"get zone", func() { c.GetZone("a", "b") }},
 		{"get instance", func() { c.GetInstance("a", "b", "c") }},
 		{"get image", func() { c.GetImage("a", "b") }},
+		{"get image from family", func() { c.GetImageFromFamily("a", "b") }},
 		{"get disk", func() { c.GetDisk("a", "b", "c") }},
+		{"get network", func() { c.GetNetwork("a", "b") }},
+		{"get region disk", func() { c.GetRegionDisk("a", "b", "c") }},
+		{"get region disk type", func() { c.GetRegionDiskType("a", "b", "c") }},
+		{"get resource policy", func() { c.GetResourcePolicy("a", "b", "c") }},
+		{"list images", func() { c.ListImages("a", "b") }},
+		{"list snapshots", func() { c.ListSnapshots("a", "b") }},
+		{"get snapshot", func() { c.GetSnapshot("a", "b") }},
 		{"instance status", func() { c.InstanceStatus("a", "b", "c") }},
 		{"instance stopped", func() { c.InstanceStopped("a", "b", "c") }},
+		{"test project permissions", func() { c.TestProjectPermissions("a", "b") }},
+		{"get project iam policy", func() { c.GetProjectIamPolicy("a") }},
+		{"set project iam policy", func() { c.SetProjectIamPolicy("a", &cloudresourcemanager.Policy{}) }},
+		{"list projects", func() { c.ListProjects("a") }},
 		{"operation wait", func() { c.operationsWait("a", "b", "c") }},
+		{"region operation wait", func() { c.regionOperationsWait("a", "b", "c") }},
 	}
 
 	runTests := func() {
@@ -78,25 +109,55 @@ func TestTestClient(t *testing.T) {
 		fakeCalled = true
 		return nil, nil
 	}
+	c.AggregatedListDisksFn = func(_, _ string) ([]*compute.Disk, error) { fakeCalled = true; return nil, nil }
+	c.AggregatedListInstancesFn = func(_, _ string) ([]*compute.Instance, error) { fakeCalled = true; return nil, nil }
+	c.CreateAddressFn = func(_, _ string, _ *compute.Address) error { fakeCalled = true; return nil }
 	c.CreateDiskFn = func(_, _ string, _ *compute.Disk) error { fakeCalled = true; return nil }
 	c.CreateImageFn = func(_ string, _ *compute.Image) error { fakeCalled = true; return nil }
 	c.CreateInstanceFn = func(_, _ string, _ *compute.Instance) error { fakeCalled = true; return nil }
+	c.CreateNetworkFn = func(_ string, _ *compute.Network) error { fakeCalled = true; return nil }
+	c.CreateRegionDiskFn = func(_, _ string, _ *compute.Disk) error { fakeCalled = true; return nil }
+	c.CreateResourcePolicyFn = func(_, _ string, _ *compute.ResourcePolicy) error { fakeCalled = true; return nil }
+	c.CreateSnapshotFn = func(_, _, _ string, _ *compute.Snapshot) error { fakeCalled = true; return nil }
+	c.DeleteAddressFn = func(_, _, _ string) error { fakeCalled = true; return nil }
 	c.DeleteDiskFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.DeleteFirewallRuleFn = func(_, _ string) error { fakeCalled = true; return nil }
 	c.DeleteImageFn = func(_, _ string) error { fakeCalled = true; return nil }
 	c.DeleteInstanceFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.DeleteNetworkFn = func(_, _ string) error { fakeCalled = true; return nil }
+	c.DeleteRegionDiskFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.DeleteResourcePolicyFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.DeleteSnapshotFn = func(_, _ string) error { fakeCalled = true; return nil }
+	c.DeleteSubnetworkFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.DeprecateImageFn = func(_, _ string, _ *compute.DeprecationStatus) error { fakeCalled = true; return nil }
 	c.GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
 		fakeCalled = true
 		return nil, nil
 	}
+	c.GetAddressFn = func(_, _, _ string) (*compute.Address, error) { fakeCalled = true; return nil, nil }
 	c.GetProjectFn = func(_ string) (*compute.Project, error) { fakeCalled = true; return nil, nil }
 	c.GetZoneFn = func(_, _ string) (*compute.Zone, error) { fakeCalled = true; return nil, nil }
 	c.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) { fakeCalled = true; return nil, nil }
 	c.GetDiskFn = func(_, _, _ string) (*compute.Disk, error) { fakeCalled = true; return nil, nil }
 	c.GetImageFn = func(_, _ string) (*compute.Image, error) { fakeCalled = true; return nil, nil }
+	c.GetImageFromFamilyFn = func(_, _ string) (*compute.Image, error) { fakeCalled = true; return nil, nil }
+	c.GetNetworkFn = func(_, _ string) (*compute.Network, error) { fakeCalled = true; return nil, nil }
+	c.GetRegionDiskFn = func(_, _, _ string) (*compute.Disk, error) { fakeCalled = true; return nil, nil }
+	c.GetDiskTypeFn = func(_, _, _ string) (*compute.DiskType, error) { fakeCalled = true; return nil, nil }
+	c.GetRegionDiskTypeFn = func(_, _, _ string) (*compute.DiskType, error) { fakeCalled = true; return nil, nil }
+	c.GetResourcePolicyFn = func(_, _, _ string) (*compute.ResourcePolicy, error) { fakeCalled = true; return nil, nil }
+	c.ListImagesFn = func(_, _ string) ([]*compute.Image, error) { fakeCalled = true; return nil, nil }
+	c.ListSnapshotsFn = func(_, _ string) ([]*compute.Snapshot, error) { fakeCalled = true; return nil, nil }
+	c.GetSnapshotFn = func(_, _ string) (*compute.Snapshot, error) { fakeCalled = true; return nil, nil }
 	c.GetMachineTypeFn = func(_, _, _ string) (*compute.MachineType, error) { fakeCalled = true; return nil, nil }
 	c.InstanceStatusFn = func(_, _, _ string) (string, error) { fakeCalled = true; return "", nil }
 	c.InstanceStoppedFn = func(_, _, _ string) (bool, error) { fakeCalled = true; return false, nil }
+	c.TestProjectPermissionsFn = func(_ string, _ ...string) ([]string, error) { fakeCalled = true; return nil, nil }
+	c.GetProjectIamPolicyFn = func(_ string) (*cloudresourcemanager.Policy, error) { fakeCalled = true; return nil, nil }
+	c.SetProjectIamPolicyFn = func(_ string, _ *cloudresourcemanager.Policy) error { fakeCalled = true; return nil }
+	c.ListProjectsFn = func(_ string) ([]*cloudresourcemanager.Project, error) { fakeCalled = true; return nil, nil }
 	c.operationsWaitFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.regionOperationsWaitFn = func(_, _, _ string) error { fakeCalled = true; return nil }
 	wantFakeCalled = true
 	wantRealCalled = false
 	runTests()
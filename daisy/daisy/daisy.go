@@ -17,100 +17,107 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
-	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/daisyflags"
 	"google.golang.org/api/option"
 )
 
 var (
-	oauth     = flag.String("oauth", "", "path to oauth json file, overrides what is set in workflow")
-	project   = flag.String("project", "", "project to run in, overrides what is set in workflow")
-	gcsPath   = flag.String("gcs_path", "", "GCS bucket to use, overrides what is set in workflow")
-	zone      = flag.String("zone", "", "zone to run in, overrides what is set in workflow")
-	variables = flag.String("variables", "", "comma separated list of variables, in the form 'key=value'")
+	common    = daisyflags.Register(flag.CommandLine)
 	print     = flag.Bool("print", false, "print out the parsed workflow for debugging")
 	validate  = flag.Bool("validate", false, "validate the workflow and exit")
+	dryRun    = flag.Bool("dry_run", false, "validate the workflow, print the API calls running it would make and exit")
+	dot       = flag.Bool("dot", false, "validate the workflow, print its step graph in Graphviz DOT format and exit")
 	ce        = flag.String("compute_endpoint_override", "", "API endpoint to override default")
 	se        = flag.String("storage_endpoint_override", "", "API endpoint to override default")
+	errRep    = flag.Bool("error_reporting", false, "report step failures to Cloud Error Reporting, overrides what is set in workflow")
+	clearDP   = flag.Bool("clear_deletion_protection", false, "clear deletion protection of instances the workflow deletes, overrides what is set in workflow")
+	serialLog = flag.Bool("serial_cloud_logging", false, "also write instance serial port output to Cloud Logging, overrides what is set in workflow")
+	cloudLog  = flag.Bool("cloud_logging", false, "also write the workflow logs and instance serial port output to Cloud Logging, overrides what is set in workflow")
+	cloudOnly = flag.Bool("cloud_logging_only", false, "write the workflow logs to Cloud Logging instead of GCS, implies -cloud_logging, overrides what is set in workflow")
+	stepLogs  = flag.Bool("step_logs", false, "also write the logs of each step, with the serial port output of its instances, to its own log in the logs path")
+	verbosity = flag.String("verbosity", "", "which logs to write, 'info', 'debug' to also log API calls and substitutions, or 'error', overrides what is set in workflow")
+	pubsubTop = flag.String("pubsub_topic", "", "Pub/Sub topic to publish the state changes of the run and its steps to, 'topic' in the workflow project or 'projects/PROJECT/topics/TOPIC', overrides what is set in workflow")
+	skipVal   = flag.String("skip_validations", "", "comma separated list of validation checks to skip, e.g. 'zones,machinetypes', added to what is set in workflow")
+	skipSteps = flag.String("skip_steps", "", "comma separated list of steps not to run, treated as if they succeeded, added to what is set in workflow")
+	cleanupDR = flag.Bool("cleanup_dry_run", false, "log the resources cleanup would delete without deleting them")
+	verifyCln = flag.Bool("verify_cleanup", false, "after cleanup, report the resources of the run that are still there")
+	retryCln  = flag.Bool("retry_cleanup", false, "after cleanup, delete the resources of the run that are still there again")
+	bqTable   = flag.String("bigquery_table", "", "BigQuery table to stream step results to, '[project.]dataset.table', overrides what is set in workflow")
+	maxSteps  = flag.Int("max_parallel_steps", 0, "maximum number of steps to run at once, overrides what is set in workflow")
+	hashMan   = flag.Bool("hash_manifest", false, "hash the inputs of the workflow and label the images it creates with the hash")
+	ckpt      = flag.Bool("checkpoint", false, "write the progress of the run to the scratch path and keep the resources of a failed run, so it can be resumed")
+	entry     = flag.String("entrypoint", "", "entrypoint of the workflow to run, overrides what is set in workflow")
+	targets   = flag.String("targets", "", "comma separated list of steps to run, with the steps they depend on, the other steps are skipped")
+	resume    = flag.String("resume", "", "ID of a failed run of the workflow to resume, it must have been run with -checkpoint")
+	logFlush  = flag.String("log_flush_interval", "", "how often logs are flushed to GCS, e.g. '1s', overrides what is set in workflow")
+	gcsLogPol = flag.String("gcs_logging_policy", "", "what to do if the GCS log can't be written when the workflow starts, 'fallback' to stdout or 'fail', overrides what is set in workflow")
+	logFormat = flag.String("log_format", "", "format of the logs, 'text' or 'json' for a JSON object per log, overrides what is set in workflow")
+	outsFile  = flag.String("outputs_file", "", "file to write the Outputs of the workflow to as JSON once it succeeded")
+	traceFile = flag.String("trace_file", "", "file to write a timeline of the steps of the workflow to in the Chrome trace event format once it returned")
+	workers   = flag.String("workers", "", "comma separated list of daisy workers, 'host:port', to run the steps of the workflow on, see the worker subcommand")
+	wkToken   = flag.String("worker_token_file", "", "file with the token shared with the workers, read from $"+workerTokenEnv+" if not set")
+	wkTLSCert = flag.String("worker_tls_cert", "", "certificate to connect to the workers with over TLS")
+	wkTLSKey  = flag.String("worker_tls_key", "", "key of -worker_tls_cert")
+	wkTLSCA   = flag.String("worker_tls_ca", "", "CA certificate the certificates of the workers are verified with, the system roots if not set")
 )
 
-const (
-	flgDefValue   = "flag generated for workflow variable"
-	varFlagPrefix = "var:"
-)
+// workerTokenEnv is the environment variable the token shared by a
+// coordinator and its workers is read from if no file is given.
+const workerTokenEnv = "DAISY_WORKER_TOKEN"
 
 func populateVars(input string) map[string]string {
-	varMap := map[string]string{}
-	if input != "" {
-		for _, v := range strings.Split(input, ",") {
-			i := strings.Index(v, "=")
-			if i == -1 {
-				continue
-			}
-			varMap[v[:i]] = v[i+1:]
-		}
-	}
-
-	flag.Visit(func(flg *flag.Flag) {
-		if strings.HasPrefix(flg.Name, varFlagPrefix) {
-			varMap[strings.TrimPrefix(flg.Name, varFlagPrefix)] = flg.Value.String()
-		}
-	})
-
-	return varMap
+	return daisyflags.ParseVars(flag.CommandLine, input)
 }
 
-func parseWorkflow(ctx context.Context, path string, varMap map[string]string, project, zone, gcsPath, oauth, cEndpoint, sEndpoint string) (*daisy.Workflow, error) {
+func parseWorkflow(ctx context.Context, path, varFile string, varMap map[string]string, project, zone, gcsPath, oauth, cEndpoint, sEndpoint string) (*daisy.Workflow, error) {
 	w, err := daisy.NewFromFile(path)
 	if err != nil {
 		return nil, err
 	}
+	f := &daisyflags.Flags{VarFile: varFile, Project: project, Zone: zone, GCSPath: gcsPath, OAuth: oauth}
+	if err := f.Apply(w); err != nil {
+		return nil, err
+	}
 	for k, v := range varMap {
 		w.AddVar(k, v)
 	}
 
-	if project != "" {
-		w.Project = project
-	} else if w.Project == "" && metadata.OnGCE() {
-		w.Project, err = metadata.ProjectID()
+	if cEndpoint != "" {
+		opts, err := w.APIClientOptions(ctx, option.WithEndpoint(cEndpoint), option.WithCredentialsFile(w.OAuthPath))
 		if err != nil {
 			return nil, err
 		}
-	}
-	if zone != "" {
-		w.Zone = zone
-	} else if w.Zone == "" && metadata.OnGCE() {
-		w.Zone, err = metadata.Zone()
+		w.ComputeClient, err = compute.NewClient(ctx, opts...)
 		if err != nil {
 			return nil, err
 		}
 	}
-	if gcsPath != "" {
-		w.GCSPath = gcsPath
-	}
-	if oauth != "" {
-		w.OAuthPath = oauth
-	}
 
-	if cEndpoint != "" {
-		w.ComputeClient, err = compute.NewClient(ctx, option.WithEndpoint(cEndpoint), option.WithCredentialsFile(w.OAuthPath))
+	if sEndpoint != "" {
+		opts, err := w.APIClientOptions(ctx, option.WithEndpoint(sEndpoint), option.WithCredentialsFile(w.OAuthPath))
 		if err != nil {
 			return nil, err
 		}
-	}
-
-	if sEndpoint != "" {
-		w.StorageClient, err = storage.NewClient(ctx, option.WithEndpoint(sEndpoint), option.WithCredentialsFile(w.OAuthPath))
+		w.StorageClient, err = storage.NewClient(ctx, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -120,69 +127,489 @@ func parseWorkflow(ctx context.Context, path string, varMap map[string]string, p
 }
 
 func addFlags(args []string) {
-	for _, arg := range args {
-		if len(arg) <= 1 || arg[0] != '-' {
-			continue
+	daisyflags.AddVarFlags(flag.CommandLine, args)
+}
+
+// cleanupOrphans runs the cleanup-orphans subcommand, which deletes the
+// resources left behind by workflow runs that are no longer running.
+func cleanupOrphans(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cleanup-orphans", flag.ExitOnError)
+	proj := fs.String("project", "", "project to clean up, defaults to the project of the GCE instance running Daisy")
+	olderThan := fs.Duration("older_than", 24*time.Hour, "age at which a workflow run is considered to be no longer running")
+	oauthPath := fs.String("oauth", "", "path to oauth json file")
+	cEndpoint := fs.String("compute_endpoint_override", "", "API endpoint to override default")
+	sEndpoint := fs.String("storage_endpoint_override", "", "API endpoint to override default")
+	fs.Parse(args)
+
+	if *proj == "" && metadata.OnGCE() {
+		var err error
+		if *proj, err = metadata.ProjectID(); err != nil {
+			return err
+		}
+	}
+	if *proj == "" {
+		return fmt.Errorf("-project is required")
+	}
+	opts := func(endpoint string) []option.ClientOption {
+		var opts []option.ClientOption
+		if *oauthPath != "" {
+			opts = append(opts, option.WithCredentialsFile(*oauthPath))
+		}
+		if endpoint != "" {
+			opts = append(opts, option.WithEndpoint(endpoint))
 		}
+		return opts
+	}
+	computeClient, err := compute.NewClient(ctx, opts(*cEndpoint)...)
+	if err != nil {
+		return err
+	}
+	storageClient, err := storage.NewClient(ctx, opts(*sEndpoint)...)
+	if err != nil {
+		return err
+	}
 
-		name := arg[1:]
-		if name[0] == '-' {
-			name = name[1:]
+	fmt.Printf("[Daisy] Cleaning up resources of workflow runs older than %s in project %q\n", *olderThan, *proj)
+	rs, err := daisy.CleanupOrphans(ctx, *proj, *olderThan, computeClient, storageClient)
+	for _, r := range rs {
+		if r.Deleted {
+			fmt.Printf("[Daisy] Deleted %s %q of workflow %q (run %s)\n", r.Type, r.Link, r.WorkflowName, r.WorkflowID)
 		}
+	}
+	return err
+}
 
-		if !strings.HasPrefix(name, varFlagPrefix) {
-			continue
+// worker runs the worker subcommand, which runs the steps of workflows run
+// with -workers. Workflows are read from the path given to the coordinator,
+// relative to -workflow_dir. Workers listening on other hosts than
+// localhost must use mutual TLS.
+func worker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	addr := fs.String("listen", "localhost:7947", "address to listen for steps to run on")
+	dir := fs.String("workflow_dir", "", "directory of the workflows the worker runs steps of")
+	tokenFile := fs.String("token_file", "", "file with the token shared with the coordinators, read from $"+workerTokenEnv+" if not set")
+	tlsCert := fs.String("tls_cert", "", "certificate to serve TLS with")
+	tlsKey := fs.String("tls_key", "", "key of -tls_cert")
+	tlsCA := fs.String("tls_client_ca", "", "CA certificate the certificates of coordinators are verified with")
+	oauthPath := fs.String("oauth", "", "path to oauth json file")
+	cEndpoint := fs.String("compute_endpoint_override", "", "API endpoint to override default")
+	sEndpoint := fs.String("storage_endpoint_override", "", "API endpoint to override default")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-workflow_dir is required")
+	}
+	auth, err := workerAuth(*tokenFile, *tlsCert, *tlsKey, *tlsCA, true)
+	if err != nil {
+		return err
+	}
+	if auth.TLS == nil && !isLoopback(*addr) {
+		return fmt.Errorf("listening on %q requires -tls_cert, -tls_key and -tls_client_ca", *addr)
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[Daisy] Worker listening on %s\n", l.Addr())
+	return daisy.ServeWorker(l, auth, func(name string) (*daisy.Workflow, error) {
+		path, err := workflowInDir(*dir, name)
+		if err != nil {
+			return nil, err
 		}
+		return parseWorkflow(context.Background(), path, "", nil, "", "", "", *oauthPath, *cEndpoint, *sEndpoint)
+	})
+}
 
-		name = strings.SplitN(name, "=", 2)[0]
+// workerAuth returns the WorkerAuth of a worker, if server, or of a
+// coordinator. The token is read from tokenFile, or $DAISY_WORKER_TOKEN.
+// Workers verify the certificates of coordinators with ca, coordinators
+// those of workers, with the system roots if ca isn't set.
+func workerAuth(tokenFile, cert, key, ca string, server bool) (daisy.WorkerAuth, error) {
+	var auth daisy.WorkerAuth
+	if tokenFile != "" {
+		b, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return auth, fmt.Errorf("error reading worker token: %v", err)
+		}
+		auth.Token = strings.TrimSpace(string(b))
+	} else {
+		auth.Token = os.Getenv(workerTokenEnv)
+	}
+	if auth.Token == "" {
+		return auth, fmt.Errorf("no worker token, set it in a file or $%s", workerTokenEnv)
+	}
+	if cert == "" && key == "" && ca == "" {
+		return auth, nil
+	}
+	if cert == "" || key == "" || (server && ca == "") {
+		return auth, fmt.Errorf("TLS needs a certificate, its key and, for workers, the CA of the client certificates")
+	}
+	c, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return auth, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+	auth.TLS = &tls.Config{Certificates: []tls.Certificate{c}, MinVersion: tls.VersionTLS12}
+	if ca == "" {
+		return auth, nil
+	}
+	b, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return auth, fmt.Errorf("error reading TLS CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return auth, fmt.Errorf("no certificates in %s", ca)
+	}
+	if server {
+		auth.TLS.ClientCAs = pool
+		auth.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		auth.TLS.RootCAs = pool
+	}
+	return auth, nil
+}
 
-		if flag.Lookup(name) != nil {
-			continue
+// isLoopback returns true if addr, "host:port", only listens on the
+// loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// workflowInDir returns the path of the workflow name in dir. name must be
+// a relative path that stays in dir, symlinks included.
+func workflowInDir(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("workflow %q is not a relative path in %s", name, dir)
+	}
+	path := filepath.Join(dir, name)
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(realDir, realPath)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("workflow %q is not in %s", name, dir)
+	}
+	return path, nil
+}
+
+// cloudBuild runs the cloudbuild subcommand, which prints a Cloud Build
+// config running a workflow.
+func cloudBuild(args []string) error {
+	fs := flag.NewFlagSet("cloudbuild", flag.ExitOnError)
+	image := fs.String("image", daisy.DefaultCloudBuildImage, "daisy container image to run the workflow with")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: daisy cloudbuild [-image IMAGE] WORKFLOW")
+	}
+
+	w, err := daisy.NewFromFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return w.WriteCloudBuild(os.Stdout, filepath.ToSlash(fs.Arg(0)), *image)
+}
+
+// writeOutputs writes the Outputs of w to path as JSON.
+func writeOutputs(w *daisy.Workflow, path string) error {
+	outs := w.Result().Outputs
+	if outs == nil {
+		outs = map[string]string{}
+	}
+	b, err := json.MarshalIndent(outs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// writeTrace writes the step timeline of w to path.
+func writeTrace(w *daisy.Workflow, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := w.Result().WriteTrace(f, w.Name); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// importPacker runs the import-packer subcommand, which converts a Packer
+// template to a workflow, written with its startup script to -out_dir.
+func importPacker(args []string) error {
+	fs := flag.NewFlagSet("import-packer", flag.ExitOnError)
+	outDir := fs.String("out_dir", ".", "directory to write the workflow and its startup script to")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: daisy import-packer [-out_dir DIR] TEMPLATE")
+	}
+
+	tmpl, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	w, script, err := daisy.ImportPacker(tmpl, filepath.Dir(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	wfPath := filepath.Join(*outDir, w.Name+".wf.json")
+	if err := ioutil.WriteFile(wfPath, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(*outDir, daisy.PackerStartupScript), script, 0755); err != nil {
+		return err
+	}
+	fmt.Printf("[Daisy] Wrote workflow %s\n", wfPath)
+	return nil
+}
+
+func readMachineRequest(path string) (*daisy.MachineRequest, error) {
+	in := io.Reader(os.Stdin)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
 		}
+		defer f.Close()
+		in = f
+	}
+	req := &daisy.MachineRequest{}
+	if err := json.NewDecoder(in).Decode(req); err != nil {
+		return nil, fmt.Errorf("error reading request: %v", err)
+	}
+	return req, nil
+}
+
+// machine runs the machine subcommand, for other tools to run workflows:
+// it reads a daisy.MachineRequest as JSON from stdin, or the -request
+// file, runs it and writes the daisy.MachineResult as JSON to stdout. Logs
+// go to stderr. It returns the exit code of the result.
+func machine(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("machine", flag.ExitOnError)
+	reqPath := fs.String("request", "", "path of the request file, read from stdin if not set")
+	validateOnly := fs.Bool("validate-only", false, "validate the workflow without running it")
+	fs.Parse(args)
+
+	// Workflows log to stdout, keep it for the result.
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	os.Stdout = os.Stderr
 
-		flag.String(name, "", flgDefValue)
+	var res *daisy.MachineResult
+	if req, err := readMachineRequest(*reqPath); err != nil {
+		res = daisy.InvalidMachineResult(err)
+	} else {
+		req.ValidateOnly = req.ValidateOnly || *validateOnly
+		res = daisy.RunMachine(ctx, req)
 	}
+	if err := out.Encode(res); err != nil {
+		fmt.Fprintln(os.Stderr, "[Daisy] Error writing result:", err)
+		return 2
+	}
+	return res.ExitCode()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "machine" {
+		ctx, stop := daisyflags.CancelOnSignal(context.Background())
+		code := machine(ctx, os.Args[2:])
+		stop()
+		os.Exit(code)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cloudbuild" {
+		if err := cloudBuild(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "[Daisy] Error writing Cloud Build config:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-packer" {
+		if err := importPacker(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "[Daisy] Error importing Packer template:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		b, err := daisy.WorkflowSchema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[Daisy] Error generating workflow schema:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", b)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		if err := worker(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "[Daisy] Error running worker:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cleanup-orphans" {
+		if err := cleanupOrphans(context.Background(), os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "[Daisy] Error cleaning up orphaned resources:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	addFlags(os.Args[1:])
 	flag.Parse()
 
 	if len(flag.Args()) == 0 {
 		log.Fatal("Not enough args, first arg needs to be the path to a workflow.")
 	}
-	ctx := context.Background()
+	if *resume != "" && len(flag.Args()) > 1 {
+		log.Fatal("-resume can only be used with a single workflow.")
+	}
+	if *resume != "" && *targets != "" {
+		log.Fatal("-targets can't be used with -resume.")
+	}
+	if *outsFile != "" && len(flag.Args()) > 1 {
+		log.Fatal("-outputs_file can only be used with a single workflow.")
+	}
+	if *traceFile != "" && len(flag.Args()) > 1 {
+		log.Fatal("-trace_file can only be used with a single workflow.")
+	}
+	ctx, stop := daisyflags.CancelOnSignal(context.Background())
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		if sig := daisyflags.Signaled(ctx); sig != nil {
+			fmt.Printf("\n%s caught, canceling workflows and cleaning up, send it again to exit without cleanup...\n", sig)
+		}
+	}()
 
 	var ws []*daisy.Workflow
-	varMap := populateVars(*variables)
+	varMap := populateVars(common.Variables)
 
 	for _, path := range flag.Args() {
-		w, err := parseWorkflow(ctx, path, varMap, *project, *zone, *gcsPath, *oauth, *ce, *se)
+		w, err := parseWorkflow(ctx, path, common.VarFile, varMap, common.Project, common.Zone, common.GCSPath, common.OAuth, *ce, *se)
 		if err != nil {
 			log.Fatalf("error parsing workflow %q: %v", path, err)
 		}
+		if *errRep {
+			w.ErrorReporting = true
+		}
+		if *clearDP {
+			w.ClearDeletionProtection = true
+		}
+		if *serialLog {
+			w.SerialCloudLogging = true
+		}
+		if *stepLogs {
+			w.StepLogs = true
+		}
+		if *cloudLog {
+			w.CloudLogging = true
+		}
+		if *cloudOnly {
+			w.CloudLoggingOnly = true
+		}
+		if *cleanupDR {
+			w.CleanupDryRun = true
+		}
+		if *skipVal != "" {
+			w.SkipValidations = append(w.SkipValidations, strings.Split(*skipVal, ",")...)
+		}
+		if *verifyCln {
+			w.VerifyCleanup = true
+		}
+		if *retryCln {
+			w.RetryCleanup = true
+		}
+		if *skipSteps != "" {
+			w.SkipSteps = append(w.SkipSteps, strings.Split(*skipSteps, ",")...)
+		}
+		if *pubsubTop != "" {
+			w.PubSubTopic = *pubsubTop
+		}
+		if *bqTable != "" {
+			w.BigQueryTable = *bqTable
+		}
+		if *maxSteps != 0 {
+			w.MaxParallelSteps = *maxSteps
+		}
+		if *hashMan {
+			w.HashManifest = true
+		}
+		if *ckpt {
+			w.Checkpoint = true
+		}
+		if *entry != "" {
+			w.Entrypoint = *entry
+		}
+		if *logFlush != "" {
+			w.LogFlushInterval = *logFlush
+		}
+		if *gcsLogPol != "" {
+			w.GCSLoggingPolicy = *gcsLogPol
+		}
+		if *logFormat != "" {
+			w.LogFormat = *logFormat
+		}
+		if *verbosity != "" {
+			w.Verbosity = *verbosity
+		}
+		if *workers != "" {
+			if !filepath.IsLocal(path) {
+				log.Fatalf("workflow %q must be a relative path to run it on workers, they read it from their -workflow_dir", path)
+			}
+			auth, err := workerAuth(*wkToken, *wkTLSCert, *wkTLSKey, *wkTLSCA, false)
+			if err != nil {
+				log.Fatalf("error setting up worker authentication: %v", err)
+			}
+			if w.Executor, err = daisy.NewRemoteExecutor(path, auth, strings.Split(*workers, ",")...); err != nil {
+				log.Fatalf("error connecting to workers of workflow %q: %v", path, err)
+			}
+		}
 		ws = append(ws, w)
 	}
 
 	errors := make(chan error, len(ws))
 	var wg sync.WaitGroup
 	for _, w := range ws {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		go func() {
-			select {
-			case <-c:
-				fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
-				close(w.Cancel)
-				errors <- fmt.Errorf("workflow %q was canceled", w.Name)
-			case <-w.Cancel:
-			}
-		}()
 		if *print {
 			fmt.Printf("[Daisy] Printing workflow %q\n", w.Name)
 			w.Print(ctx)
 			continue
 		}
+		if *dryRun {
+			fmt.Printf("[Daisy] Dry run of workflow %q\n", w.Name)
+			if err := w.DryRun(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "[Daisy] Error validating workflow:", err)
+			}
+			continue
+		}
+		if *dot {
+			if err := w.Validate(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "[Daisy] Error validating workflow %q: %v\n", w.Name, err)
+				continue
+			}
+			if err := w.WriteDOT(os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "[Daisy] Error writing step graph of workflow %q: %v\n", w.Name, err)
+			}
+			continue
+		}
 		if *validate {
 			fmt.Printf("[Daisy] Validating workflow %q\n", w.Name)
 			if err := w.Validate(ctx); err != nil {
@@ -193,11 +620,37 @@ func main() {
 		wg.Add(1)
 		go func(wf *daisy.Workflow) {
 			defer wg.Done()
-			fmt.Printf("[Daisy] Running workflow %q\n", wf.Name)
-			if err := wf.Run(ctx); err != nil {
+			run := wf.Run
+			if *resume != "" {
+				fmt.Printf("[Daisy] Resuming run %q of workflow %q\n", *resume, wf.Name)
+				run = func(ctx context.Context) error { return wf.Resume(ctx, *resume) }
+			} else if *targets != "" {
+				fmt.Printf("[Daisy] Running steps %s of workflow %q\n", *targets, wf.Name)
+				run = func(ctx context.Context) error { return wf.RunTargets(ctx, strings.Split(*targets, ",")...) }
+			} else {
+				fmt.Printf("[Daisy] Running workflow %q\n", wf.Name)
+			}
+			stop := common.CancelOnTimeout(wf)
+			err := run(ctx)
+			stop()
+			if fb := wf.Result().LogsFallback; fb != "" {
+				fmt.Printf("[Daisy] Workflow %q logs were not written to GCS: %s\n", wf.Name, fb)
+			}
+			if *traceFile != "" {
+				if tErr := writeTrace(wf, *traceFile); tErr != nil {
+					fmt.Fprintf(os.Stderr, "[Daisy] %s: error writing trace: %v\n", wf.Name, tErr)
+				}
+			}
+			if err != nil {
 				errors <- fmt.Errorf("%s: %v", wf.Name, err)
 				return
 			}
+			if *outsFile != "" {
+				if err := writeOutputs(wf, *outsFile); err != nil {
+					errors <- fmt.Errorf("%s: error writing outputs: %v", wf.Name, err)
+					return
+				}
+			}
 			fmt.Printf("[Daisy] Workflow %q finished\n", wf.Name)
 		}(w)
 	}
@@ -213,11 +666,14 @@ func main() {
 				fmt.Fprintln(os.Stderr, " ", err)
 				continue
 			default:
+				if sig := daisyflags.Signaled(ctx); sig != nil {
+					os.Exit(daisyflags.SignalExitCode(sig))
+				}
 				os.Exit(1)
 			}
 		}
 	default:
-		if !*print && !*validate {
+		if !*print && !*validate && !*dryRun && !*dot {
 			fmt.Println("[Daisy] All workflows completed successfully.")
 		}
 	}
@@ -18,6 +18,9 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
@@ -80,7 +83,7 @@ func TestParseWorkflows(t *testing.T) {
 	zone := "zone"
 	gcsPath := "gcspath"
 	oauth := "oauthpath"
-	w, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "", "")
+	w, err := parseWorkflow(context.Background(), path, "", varMap, project, zone, gcsPath, oauth, "", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,11 +112,67 @@ func TestParseWorkflows(t *testing.T) {
 		want = "dialing: cannot read credentials file: open oauthpath: The system cannot find the file specified."
 	}
 
-	if _, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "noplace", ""); err.Error() != want {
+	if _, err := parseWorkflow(context.Background(), path, "", varMap, project, zone, gcsPath, oauth, "noplace", ""); err.Error() != want {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 
-	if _, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, "", "noplace"); err.Error() != want {
+	if _, err := parseWorkflow(context.Background(), path, "", varMap, project, zone, gcsPath, oauth, "", "noplace"); err.Error() != want {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 }
+
+func TestWorkflowInDir(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	for _, p := range []string{filepath.Join(dir, "wf.json"), filepath.Join(outside, "wf.json")} {
+		if err := ioutil.WriteFile(p, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "wf.json"), filepath.Join(dir, "link.json")); err != nil {
+		t.Skipf("can't create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		shouldErr bool
+	}{
+		{"wf.json", false},
+		{"../wf.json", true},
+		{filepath.Join(outside, "wf.json"), true},
+		{"link.json", true},
+		{"missing.json", true},
+	}
+	for _, tt := range tests {
+		got, err := workflowInDir(dir, tt.name)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%q: expected error, got path %q", tt.name, got)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.name, err)
+		} else if want := filepath.Join(dir, tt.name); got != want {
+			t.Errorf("%q: got path %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"localhost:7947", true},
+		{"127.0.0.1:7947", true},
+		{"[::1]:7947", true},
+		{":7947", false},
+		{"0.0.0.0:7947", false},
+		{"10.0.0.1:7947", false},
+		{"host:7947", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.addr); got != tt.want {
+			t.Errorf("isLoopback(%q) = %t, want %t", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package daisyflags maps the flags common to CLIs running daisy workflows
+// onto a Workflow, so that wrappers around daisy handle them the same way.
+package daisyflags
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
+)
+
+// VarFlagPrefix is the prefix of the flags setting a single workflow
+// variable, e.g. -var:KEY=VALUE.
+const VarFlagPrefix = "var:"
+
+const varFlagUsage = "flag generated for workflow variable"
+
+// Flags are the common flags of a CLI running daisy workflows.
+type Flags struct {
+	Project   string
+	Zone      string
+	GCSPath   string
+	OAuth     string
+	Variables string
+	VarFile   string
+	// How long a workflow may run before it is canceled, 0 means no limit.
+	Timeout time.Duration
+
+	fs *flag.FlagSet
+}
+
+// Register defines the common flags in fs and returns the Flags they
+// are parsed into.
+func Register(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.Project, "project", "", "project to run in, overrides what is set in workflow")
+	fs.StringVar(&f.Zone, "zone", "", "zone to run in, overrides what is set in workflow")
+	fs.StringVar(&f.GCSPath, "gcs_path", "", "GCS bucket to use, overrides what is set in workflow")
+	fs.StringVar(&f.OAuth, "oauth", "", "path to oauth json file, overrides what is set in workflow")
+	fs.StringVar(&f.Variables, "variables", "", "comma separated list of variables, in the form 'key=value'")
+	fs.StringVar(&f.VarFile, "var_file", "", "JSON or YAML file of variables, overridden by -variables and -var:KEY flags")
+	fs.DurationVar(&f.Timeout, "timeout", 0, "how long the workflow may run before it is canceled, e.g. '2h', 0 means no limit")
+	return f
+}
+
+// AddVarFlags defines a string flag in fs for each -var:KEY flag in args,
+// it must be called before fs is parsed.
+func AddVarFlags(fs *flag.FlagSet, args []string) {
+	for _, arg := range args {
+		if len(arg) <= 1 || arg[0] != '-' {
+			continue
+		}
+
+		name := arg[1:]
+		if name[0] == '-' {
+			name = name[1:]
+		}
+
+		if !strings.HasPrefix(name, VarFlagPrefix) {
+			continue
+		}
+
+		name = strings.SplitN(name, "=", 2)[0]
+
+		if fs.Lookup(name) != nil {
+			continue
+		}
+
+		fs.String(name, "", varFlagUsage)
+	}
+}
+
+// ParseVars returns the variables of a comma separated list of 'key=value'
+// pairs, overridden by the -var:KEY flags set in fs. fs may be nil.
+func ParseVars(fs *flag.FlagSet, variables string) map[string]string {
+	varMap := map[string]string{}
+	if variables != "" {
+		for _, v := range strings.Split(variables, ",") {
+			i := strings.Index(v, "=")
+			if i == -1 {
+				continue
+			}
+			varMap[v[:i]] = v[i+1:]
+		}
+	}
+
+	if fs != nil {
+		fs.Visit(func(flg *flag.Flag) {
+			if strings.HasPrefix(flg.Name, VarFlagPrefix) {
+				varMap[strings.TrimPrefix(flg.Name, VarFlagPrefix)] = flg.Value.String()
+			}
+		})
+	}
+
+	return varMap
+}
+
+// Vars returns the variables set by -variables and -var:KEY flags.
+func (f *Flags) Vars() map[string]string {
+	return ParseVars(f.fs, f.Variables)
+}
+
+// Apply sets the flags on w: the variables of -var_file and then those of
+// -variables and -var:KEY, and the flags overriding workflow fields. If
+// neither the flags nor w set Project or Zone, they are those of the GCE
+// instance this runs on, if any.
+func (f *Flags) Apply(w *daisy.Workflow) error {
+	if f.VarFile != "" {
+		if err := w.AddVarsFromFile(f.VarFile); err != nil {
+			return err
+		}
+	}
+	for k, v := range f.Vars() {
+		w.AddVar(k, v)
+	}
+
+	var err error
+	if f.Project != "" {
+		w.Project = f.Project
+	} else if w.Project == "" && metadata.OnGCE() {
+		if w.Project, err = metadata.ProjectID(); err != nil {
+			return err
+		}
+	}
+	if f.Zone != "" {
+		w.Zone = f.Zone
+	} else if w.Zone == "" && metadata.OnGCE() {
+		if w.Zone, err = metadata.Zone(); err != nil {
+			return err
+		}
+	}
+	if f.GCSPath != "" {
+		w.GCSPath = f.GCSPath
+	}
+	if f.OAuth != "" {
+		w.OAuthPath = f.OAuth
+	}
+	return nil
+}
+
+// CancelOnTimeout cancels w once Timeout has passed, until the returned
+// stop func is called. It does nothing if Timeout isn't set.
+func (f *Flags) CancelOnTimeout(w *daisy.Workflow) (stop func()) {
+	if f.Timeout <= 0 {
+		return func() {}
+	}
+	t := time.AfterFunc(f.Timeout, func() {
+		w.CancelWithReason(fmt.Sprintf("timed out after %s", f.Timeout))
+	})
+	return func() { t.Stop() }
+}
+
+// Run runs w, canceling it once Timeout has passed.
+func (f *Flags) Run(ctx context.Context, w *daisy.Workflow) error {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, f.Timeout, fmt.Errorf("timed out after %s", f.Timeout))
+		defer cancel()
+	}
+	return w.Run(ctx)
+}
@@ -0,0 +1,102 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisyflags
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
+)
+
+func TestParseVars(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddVarFlags(fs, []string{"-var:key2=flag"})
+	if err := fs.Parse([]string{"-var:key2=flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input string
+		fs    *flag.FlagSet
+		want  map[string]string
+	}{
+		{"", nil, map[string]string{}},
+		{"key1=var1,bad", nil, map[string]string{"key1": "var1"}},
+		{"key1=a=b", nil, map[string]string{"key1": "a=b"}},
+		{"key1=var1,key2=var2", fs, map[string]string{"key1": "var1", "key2": "flag"}},
+	}
+	for _, tt := range tests {
+		if got := ParseVars(tt.fs, tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVars(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := Register(fs)
+	args := []string{"-project", "p", "-zone", "z", "-gcs_path", "gs://bkt", "-oauth", "oauth.json", "-variables", "a=1,b=2", "-var:b=3", "-timeout", "1h"}
+	AddVarFlags(fs, args)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	w := daisy.New()
+	w.Project = "other"
+	w.AddVar("c", "4")
+	if err := f.Apply(w); err != nil {
+		t.Fatalf("error applying flags: %v", err)
+	}
+
+	if w.Project != "p" || w.Zone != "z" || w.GCSPath != "gs://bkt" || w.OAuthPath != "oauth.json" {
+		t.Errorf("flags not applied: Project %q, Zone %q, GCSPath %q, OAuthPath %q", w.Project, w.Zone, w.GCSPath, w.OAuthPath)
+	}
+	got := map[string]string{}
+	for k, v := range w.Vars {
+		got[k] = v.Value
+	}
+	if want := map[string]string{"a": "1", "b": "3", "c": "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected vars, got: %v, want: %v", got, want)
+	}
+	if f.Timeout != time.Hour {
+		t.Errorf("unexpected Timeout, got: %s, want: %s", f.Timeout, time.Hour)
+	}
+}
+
+func TestCancelOnTimeout(t *testing.T) {
+	w := daisy.New()
+	stop := (&Flags{}).CancelOnTimeout(w)
+	stop()
+
+	stop = (&Flags{Timeout: time.Millisecond}).CancelOnTimeout(w)
+	defer stop()
+	select {
+	case <-w.Cancel:
+	case <-time.After(5 * time.Second):
+		t.Error("workflow was not canceled after Timeout")
+	}
+
+	w = daisy.New()
+	(&Flags{Timeout: time.Millisecond}).CancelOnTimeout(w)()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-w.Cancel:
+		t.Error("workflow was canceled after stop")
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisyflags
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// signalError is the cause of the cancellation of a context by a signal.
+type signalError struct {
+	sig os.Signal
+}
+
+func (e *signalError) Error() string {
+	return e.sig.String() + " caught"
+}
+
+// CancelOnSignal returns a copy of ctx which is canceled on the first
+// SIGINT or SIGTERM, e.g. Ctrl-C or a CI runner stopping the job. A
+// workflow run with it then stops its running steps, cleans up and returns.
+// A second signal terminates the process as usual, without cleanup. stop
+// stops listening for signals and cancels the context.
+func CancelOnSignal(ctx context.Context) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-c:
+			signal.Stop(c)
+			cancel(&signalError{sig})
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+			cancel(nil)
+		})
+	}
+}
+
+// Signaled returns the signal ctx, from CancelOnSignal, was canceled on,
+// nil if it wasn't.
+func Signaled(ctx context.Context) os.Signal {
+	if e, ok := context.Cause(ctx).(*signalError); ok {
+		return e.sig
+	}
+	return nil
+}
+
+// SignalExitCode returns the exit code of a CLI stopped by sig: 128 plus the
+// signal number, as for processes killed by it, e.g. 130 for SIGINT.
+func SignalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,55 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisyflags
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCancelOnSignal(t *testing.T) {
+	ctx, stop := CancelOnSignal(context.Background())
+	defer stop()
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context was not canceled on SIGTERM")
+	}
+	if sig := Signaled(ctx); sig != syscall.SIGTERM {
+		t.Errorf("unexpected signal, got: %v, want: %v", sig, syscall.SIGTERM)
+	}
+	if want := "terminated caught"; context.Cause(ctx).Error() != want {
+		t.Errorf("unexpected cause, got: %q, want: %q", context.Cause(ctx), want)
+	}
+	if got := SignalExitCode(syscall.SIGTERM); got != 143 {
+		t.Errorf("unexpected exit code, got: %d, want: 143", got)
+	}
+
+	ctx, stop = CancelOnSignal(context.Background())
+	stop()
+	if sig := Signaled(ctx); sig != nil {
+		t.Errorf("stopped context should not be signaled, got: %v", sig)
+	}
+}
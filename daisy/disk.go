@@ -21,7 +21,7 @@ import (
 
 var (
 	disks      = map[*Workflow]*diskMap{}
-	diskURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[1]s)/disks/(?P<disk>%[1]s)$`, rfc1035))
+	diskURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?(zones/(?P<zone>%[1]s)|regions/(?P<region>%[1]s))/disks/(?P<disk>%[1]s)$`, rfc1035))
 )
 
 type diskMap struct {
@@ -33,6 +33,8 @@ type diskMap struct {
 type diskAttachment struct {
 	mode               string
 	attacher, detacher *Step
+	// autoDelete is set if the disk is deleted along with the instance.
+	autoDelete bool
 }
 
 func initDiskMap(w *Workflow) {
@@ -49,14 +51,19 @@ func (dm *diskMap) init() {
 
 func (dm *diskMap) deleteFn(r *resource) error {
 	m := namedSubexp(diskURLRgx, r.link)
-	if err := dm.w.ComputeClient.DeleteDisk(m["project"], m["zone"], m["disk"]); err != nil {
-		return err
+	var err error
+	if m["region"] != "" {
+		err = dm.w.ComputeClient.DeleteRegionDisk(m["project"], m["region"], m["disk"])
+	} else {
+		err = dm.w.ComputeClient.DeleteDisk(m["project"], m["zone"], m["disk"])
 	}
-	r.deleted = true
-	return nil
+	return err
 }
 
-func (dm *diskMap) registerAttachment(dName, iName, mode string, s *Step) error {
+// registerAttachment records that s attaches the dName disk to the iName
+// instance in mode. A disk can be attached to several instances at once if
+// all of them attach it READ_ONLY, none of them may auto-delete it then.
+func (dm *diskMap) registerAttachment(dName, iName, mode string, autoDelete bool, s *Step) error {
 	dm.mx.Lock()
 	defer dm.mx.Unlock()
 	var d, i *resource
@@ -81,6 +88,11 @@ func (dm *diskMap) registerAttachment(dName, iName, mode string, s *Step) error
 				return Errorf(
 					"disk attachment conflict for disk %q: attached to instances %q (%s) and %q (%s)",
 					dName, i.real, mode, attI.real, att.mode)
+			} else if autoDelete || att.autoDelete {
+				// The first instance deleted would take the disk with it.
+				return Errorf(
+					"disk %q is attached read-only to instances %q and %q, it can't be auto-deleted with either of them",
+					dName, i.real, attI.real)
 			}
 		}
 	}
@@ -90,7 +102,7 @@ func (dm *diskMap) registerAttachment(dName, iName, mode string, s *Step) error
 		im = map[*resource]*diskAttachment{}
 		dm.attachments[d] = im
 	}
-	im[i] = &diskAttachment{mode: mode, attacher: s}
+	im[i] = &diskAttachment{mode: mode, attacher: s, autoDelete: autoDelete}
 	return nil
 }
 
@@ -3,9 +3,41 @@ package daisy
 import (
 	"testing"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"github.com/kylelemons/godebug/pretty"
 )
 
+func TestDiskDeleteFn(t *testing.T) {
+	w := testWorkflow()
+	var got []string
+	w.ComputeClient = &daisyCompute.TestClient{
+		DeleteDiskFn:       func(p, z, n string) error { got = append(got, "zonal", p, z, n); return nil },
+		DeleteRegionDiskFn: func(p, r, n string) error { got = append(got, "regional", p, r, n); return nil },
+	}
+
+	tests := []struct {
+		desc, link string
+		want       []string
+	}{
+		{"zonal case", "projects/p/zones/z/disks/d", []string{"zonal", "p", "z", "d"}},
+		{"regional case", "projects/p/regions/r/disks/d", []string{"regional", "p", "r", "d"}},
+	}
+	for _, tt := range tests {
+		got = nil
+		r := &resource{link: tt.link}
+		disks[w].m = map[string]*resource{"d": r}
+		if err := disks[w].delete("d"); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: wrong delete call: (-got +want)\n%s", tt.desc, diff)
+		}
+		if !r.deleted {
+			t.Errorf("%s: disk not marked deleted", tt.desc)
+		}
+	}
+}
+
 func TestDiskRegisterAttachment(t *testing.T) {
 	// Test:
 	// - normal attachment
@@ -36,20 +68,22 @@ func TestDiskRegisterAttachment(t *testing.T) {
 
 	tests := []struct {
 		desc, d, i, mode string
+		autoDelete       bool
 		s                *Step
 		shouldErr        bool
 	}{
-		{"normal case", "d", "i", diskModeRO, s, false},
-		{"repeat attachment case", "d", "i", diskModeRW, s2, false},
-		{"concurrent RO case", "d", "i2", diskModeRO, s, false},
-		{"concurrent conflict case", "d", "i3", diskModeRW, s, true},
-		{"instance DNE case", "d", "dne", diskModeRO, s, true},
-		{"disk DNE case", "dne", "i", diskModeRO, s, true},
-		{"attach detached case", "dPrevAtt", "i", diskModeRW, s, false},
+		{"normal case", "d", "i", diskModeRO, false, s, false},
+		{"repeat attachment case", "d", "i", diskModeRW, false, s2, false},
+		{"concurrent RO case", "d", "i2", diskModeRO, false, s, false},
+		{"concurrent RO AutoDelete case", "d", "i3", diskModeRO, true, s, true},
+		{"concurrent conflict case", "d", "i3", diskModeRW, false, s, true},
+		{"instance DNE case", "d", "dne", diskModeRO, false, s, true},
+		{"disk DNE case", "dne", "i", diskModeRO, false, s, true},
+		{"attach detached case", "dPrevAtt", "i", diskModeRW, false, s, false},
 	}
 
 	for _, tt := range tests {
-		err := disks[w].registerAttachment(tt.d, tt.i, tt.mode, tt.s)
+		err := disks[w].registerAttachment(tt.d, tt.i, tt.mode, tt.autoDelete, tt.s)
 		if tt.shouldErr && err == nil {
 			t.Errorf("%s: should have err'ed but didn't", tt.desc)
 		} else if !tt.shouldErr && err != nil {
@@ -65,11 +99,11 @@ func TestDiskRegisterAttachment(t *testing.T) {
 	want := map[*resource]map[*resource]*diskAttachment{
 		dPrevAtt: {
 			iPrevAtt: disks[w].attachments[dPrevAtt][iPrevAtt],
-			i:        {diskModeRW, s, nil},
+			i:        {diskModeRW, s, nil, false},
 		},
 		d: {
-			i:  {diskModeRO, s, nil},
-			i2: {diskModeRO, s, nil},
+			i:  {diskModeRO, s, nil, false},
+			i2: {diskModeRO, s, nil, false},
 		},
 	}
 	if diff := pretty.Compare(disks[w].attachments, want); diff != "" {
@@ -159,8 +193,8 @@ func TestDiskRegisterDetachment(t *testing.T) {
 	instances[w].m = map[string]*resource{"i": i, "i2": i2}
 	disks[w].attachments = map[*resource]map[*resource]*diskAttachment{
 		disks[w].m["d"]: {
-			instances[w].m["i"]:  {diskModeRW, att, nil},
-			instances[w].m["i2"]: {diskModeRW, att, nil},
+			instances[w].m["i"]:  {diskModeRW, att, nil, false},
+			instances[w].m["i2"]: {diskModeRW, att, nil, false},
 		},
 	}
 
@@ -197,7 +231,7 @@ func TestDiskRegisterDetachment(t *testing.T) {
 	// Check state.
 	want := map[*resource]map[*resource]*diskAttachment{
 		d: {
-			i:  &diskAttachment{diskModeRW, att, s},
+			i:  &diskAttachment{diskModeRW, att, s, false},
 			i2: disks[w].attachments[d][i2], // Not modified.
 		},
 	}
@@ -17,6 +17,24 @@ package daisy
 import (
 	"fmt"
 	"regexp"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
-var diskTypeURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[1]s)/diskTypes/(?P<disktype>%[1]s)$`, rfc1035))
+var diskTypeURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?(zones/(?P<zone>%[1]s)|regions/(?P<region>%[1]s))/diskTypes/(?P<disktype>%[1]s)$`, rfc1035))
+
+var diskTypes = &catalogCache{}
+
+// checkDiskType checks that the disk type of url, which matches
+// diskTypeURLRgx with a project, exists.
+func checkDiskType(client compute.Client, url string) error {
+	m := namedSubexp(diskTypeURLRgx, url)
+	return diskTypes.check(client, url, func() error {
+		if m["region"] != "" {
+			_, err := client.GetRegionDiskType(m["project"], m["region"], m["disktype"])
+			return err
+		}
+		_, err := client.GetDiskType(m["project"], m["zone"], m["disktype"])
+		return err
+	})
+}
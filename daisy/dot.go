@@ -0,0 +1,141 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteDOT writes the step graph of w to out in Graphviz DOT format, e.g.
+// to render it with `dot -Tsvg`. The steps of IncludeWorkflow, ForEach and
+// SubWorkflow steps are drawn in a box labeled with the name of the step,
+// with its dependencies drawn to the steps in the box that start and end
+// it. Nested workflows are only expanded once w is populated, e.g. by
+// Validate.
+func (w *Workflow) WriteDOT(out io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(w.Name))
+	w.writeDOTNodes(&b, "", "  ")
+	w.writeDOTEdges(&b, "")
+	fmt.Fprintln(&b, "}")
+	_, err := b.WriteTo(out)
+	return err
+}
+
+// expandedWorkflow returns the workflow s runs, if it runs one with steps.
+func (s *Step) expandedWorkflow() *Workflow {
+	var w *Workflow
+	switch {
+	case s.IncludeWorkflow != nil:
+		w = s.IncludeWorkflow.w
+	case s.ForEach != nil:
+		w = s.ForEach.w
+	case s.SubWorkflow != nil:
+		w = s.SubWorkflow.w
+	}
+	if w == nil || len(w.Steps) == 0 {
+		return nil
+	}
+	return w
+}
+
+func (w *Workflow) writeDOTNodes(b *bytes.Buffer, prefix, indent string) {
+	for _, name := range sortedStepNames(w) {
+		s := w.Steps[name]
+		id := prefix + name
+		if ew := s.expandedWorkflow(); ew != nil {
+			fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+id))
+			fmt.Fprintf(b, "%s  label=%s;\n", indent, dotQuote(fmt.Sprintf("%s (%s)", name, s.typeName())))
+			ew.writeDOTNodes(b, id+".", indent+"  ")
+			fmt.Fprintf(b, "%s}\n", indent)
+			continue
+		}
+		fmt.Fprintf(b, "%s%s [label=%s];\n", indent, dotQuote(id), dotQuote(name+"\n"+s.typeName()))
+	}
+}
+
+func (w *Workflow) writeDOTEdges(b *bytes.Buffer, prefix string) {
+	for _, name := range sortedStepNames(w) {
+		deps := append([]string{}, w.Dependencies[name]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			for _, from := range w.dotExits(prefix, dep) {
+				for _, to := range w.dotEntries(prefix, name) {
+					fmt.Fprintf(b, "  %s -> %s;\n", dotQuote(from), dotQuote(to))
+				}
+			}
+		}
+		if ew := w.Steps[name].expandedWorkflow(); ew != nil {
+			ew.writeDOTEdges(b, prefix+name+".")
+		}
+	}
+}
+
+// dotEntries returns the IDs of the nodes step name of w starts with: the
+// step itself, or the steps without dependencies of the workflow it runs.
+func (w *Workflow) dotEntries(prefix, name string) []string {
+	s, ok := w.Steps[name]
+	if !ok {
+		return nil
+	}
+	ew := s.expandedWorkflow()
+	if ew == nil {
+		return []string{prefix + name}
+	}
+	var ids []string
+	for _, n := range sortedStepNames(ew) {
+		if len(ew.Dependencies[n]) == 0 {
+			ids = append(ids, ew.dotEntries(prefix+name+".", n)...)
+		}
+	}
+	return ids
+}
+
+// dotExits returns the IDs of the nodes step name of w ends with: the step
+// itself, or the steps no other step depends on of the workflow it runs.
+func (w *Workflow) dotExits(prefix, name string) []string {
+	s, ok := w.Steps[name]
+	if !ok {
+		return nil
+	}
+	ew := s.expandedWorkflow()
+	if ew == nil {
+		return []string{prefix + name}
+	}
+	dependedOn := map[string]bool{}
+	for _, deps := range ew.Dependencies {
+		for _, dep := range deps {
+			dependedOn[dep] = true
+		}
+	}
+	var ids []string
+	for _, n := range sortedStepNames(ew) {
+		if !dependedOn[n] {
+			ids = append(ids, ew.dotExits(prefix+name+".", n)...)
+		}
+	}
+	return ids
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a quoted DOT ID.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,63 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func TestWriteDOT(t *testing.T) {
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	iw.Steps = map[string]*Step{
+		"a": {name: "a", w: iw, CreateDisks: &CreateDisks{}},
+		"b": {name: "b", w: iw, CreateDisks: &CreateDisks{}},
+		"c": {name: "c", w: iw, CreateImages: &CreateImages{}},
+	}
+	iw.Dependencies = map[string][]string{"c": {"a", "b"}}
+	w.Steps = map[string]*Step{
+		"create":         {name: "create", w: w, CreateDisks: &CreateDisks{}},
+		"include":        {name: "include", w: w, IncludeWorkflow: &IncludeWorkflow{w: iw}},
+		"delete \"all\"": {name: "delete \"all\"", w: w, DeleteResources: &DeleteResources{}},
+	}
+	w.Dependencies = map[string][]string{"include": {"create"}, "delete \"all\"": {"include"}}
+
+	var b bytes.Buffer
+	if err := w.WriteDOT(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `digraph "test-wf" {
+  "create" [label="create\nCreateDisks"];
+  "delete \"all\"" [label="delete \"all\"\nDeleteResources"];
+  subgraph "cluster_include" {
+    label="include (IncludeWorkflow)";
+    "include.a" [label="a\nCreateDisks"];
+    "include.b" [label="b\nCreateDisks"];
+    "include.c" [label="c\nCreateImages"];
+  }
+  "include.c" -> "delete \"all\"";
+  "create" -> "include.a";
+  "create" -> "include.b";
+  "include.a" -> "include.c";
+  "include.b" -> "include.c";
+}
+`
+	if d := diff.Diff(b.String(), want); d != "" {
+		t.Errorf("DOT output does not match expectation: (-got +want)\n%s", d)
+	}
+}
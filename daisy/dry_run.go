@@ -0,0 +1,343 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+// apiCollections maps resource types to their compute API collections.
+var apiCollections = map[string]string{
+	"address":         "addresses",
+	"disk":            "disks",
+	"firewall rule":   "firewalls",
+	"image":           "images",
+	"instance":        "instances",
+	"network":         "networks",
+	"resource policy": "resourcePolicies",
+	"snapshot":        "snapshots",
+	"subnetwork":      "subnetworks",
+}
+
+// DryRun populates and validates the workflow like Validate, then prints the
+// compute and storage API calls running it would make, without making them.
+// Steps are printed in an order their dependencies allow, with names,
+// projects and zones resolved as in a real run. Calls whose targets are
+// only known at run time, like the images PruneImages deletes, are
+// described instead.
+func (w *Workflow) DryRun(ctx context.Context) error {
+	if err := w.Validate(ctx); err != nil {
+		return err
+	}
+	w.dryRun(os.Stdout)
+	return nil
+}
+
+// dryRun writes the API calls running w would make to out.
+func (w *Workflow) dryRun(out io.Writer) {
+	fmt.Fprintf(out, "Workflow %q:\n", w.Name)
+	if calls := w.planSources(); len(calls) != 0 {
+		fmt.Fprintln(out, "  Upload sources:")
+		printCalls(out, "    ", calls)
+	}
+	w.planSteps(out, "  ")
+	if calls := w.planCleanup(); len(calls) != 0 {
+		fmt.Fprintln(out, "  Cleanup:")
+		printCalls(out, "    ", calls)
+	}
+}
+
+func printCalls(out io.Writer, indent string, calls []string) {
+	for _, c := range calls {
+		fmt.Fprintf(out, "%s%s\n", indent, c)
+	}
+}
+
+// planSources returns the calls uploading the sources of w and its
+// subworkflows.
+func (w *Workflow) planSources() []string {
+	var dsts []string
+	for dst, src := range w.Sources {
+		if src != "" {
+			dsts = append(dsts, dst)
+		}
+	}
+	sort.Strings(dsts)
+	var calls []string
+	for _, dst := range dsts {
+		method := "storage.objects.insert"
+		if _, _, err := splitGCSPath(w.Sources[dst]); err == nil {
+			method = "storage.objects.copy"
+		}
+		calls = append(calls, fmt.Sprintf("%s gs://%s from %s", method, path.Join(w.bucket, w.sourcesPath, dst), w.Sources[dst]))
+	}
+	for _, name := range sortedStepNames(w) {
+		if sw := w.Steps[name].SubWorkflow; sw != nil && sw.w != nil {
+			calls = append(calls, sw.w.planSources()...)
+		}
+	}
+	return calls
+}
+
+// planCleanup returns the calls cleanup of w would make if all steps
+// succeeded.
+func (w *Workflow) planCleanup() []string {
+	var calls []string
+	for _, rm := range w.ownResourceMaps() {
+		rm.mx.Lock()
+		var names []string
+		for name, r := range rm.m {
+			if r.creator != nil && r.deleter == nil && !r.noCleanup {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			calls = append(calls, deleteCall(rm.typeName, rm.m[name].link))
+		}
+		rm.mx.Unlock()
+	}
+	return calls
+}
+
+// planSteps writes the steps of w in dependency order, each with the calls
+// it would make, to out.
+func (w *Workflow) planSteps(out io.Writer, indent string) {
+	for _, name := range w.stepOrder() {
+		s := w.Steps[name]
+		fmt.Fprintf(out, "%sStep %q (%s):\n", indent, name, s.typeName())
+		switch {
+		case s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil:
+			s.IncludeWorkflow.w.planSteps(out, indent+"  ")
+		case s.ForEach != nil && s.ForEach.w != nil:
+			s.ForEach.w.planSteps(out, indent+"  ")
+		case s.SubWorkflow != nil && s.SubWorkflow.w != nil:
+			sw := s.SubWorkflow.w
+			sw.planSteps(out, indent+"  ")
+			if calls := sw.planCleanup(); len(calls) != 0 {
+				fmt.Fprintf(out, "%s  Cleanup:\n", indent)
+				printCalls(out, indent+"    ", calls)
+			}
+		default:
+			printCalls(out, indent+"  ", s.plan())
+		}
+	}
+}
+
+// stepOrder returns the names of the steps of w in an order their
+// dependencies allow, ties broken by name.
+func (w *Workflow) stepOrder() []string {
+	done := map[string]bool{}
+	var order []string
+	for len(order) < len(w.Steps) {
+		var next string
+		for _, name := range sortedStepNames(w) {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range w.Dependencies[name] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = name
+				break
+			}
+		}
+		if next == "" {
+			// Cyclic dependencies, which validation rejects.
+			break
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+// deleteCall describes the API call deleting the resource at link.
+func deleteCall(typeName, link string) string {
+	if typeName == "bucket" {
+		return "storage.buckets.delete " + link
+	}
+	return fmt.Sprintf("compute.%s.delete %s", apiCollections[typeName], link)
+}
+
+func sortedStepNames(w *Workflow) []string {
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// plan returns the API calls s would make, for steps that don't run a
+// workflow.
+func (s *Step) plan() []string {
+	w := s.w
+	var calls []string
+	add := func(format string, a ...interface{}) {
+		calls = append(calls, fmt.Sprintf(format, a...))
+	}
+	link := func(rm *baseResourceMap, name string) string {
+		if r, ok := rm.get(name); ok {
+			return r.link
+		}
+		return name
+	}
+
+	switch {
+	case s.CreateAddresses != nil:
+		for _, ca := range *s.CreateAddresses {
+			add("compute.addresses.insert projects/%s/regions/%s/addresses/%s", ca.Project, ca.Region, ca.Name)
+		}
+	case s.CreateBuckets != nil:
+		for _, cb := range *s.CreateBuckets {
+			add("storage.buckets.insert gs://%s in projects/%s", cb.Name, cb.Project)
+		}
+	case s.CreateDisks != nil:
+		for _, cd := range *s.CreateDisks {
+			if cd.region != "" {
+				add("compute.regionDisks.insert projects/%s/regions/%s/disks/%s", cd.Project, cd.region, cd.Name)
+			} else {
+				add("compute.disks.insert projects/%s/zones/%s/disks/%s", cd.Project, cd.Zone, cd.Name)
+			}
+		}
+	case s.CreateImages != nil:
+		for _, ci := range *s.CreateImages {
+			if ci.RawDiskSHA256 != "" {
+				add("storage.objects.get %s", ci.RawDisk.Source)
+			}
+			add("compute.images.insert projects/%s/global/images/%s", strOr(ci.Project, w.Project), ci.Name)
+		}
+	case s.CreateInstances != nil:
+		for _, ci := range *s.CreateInstances {
+			add("compute.instances.insert projects/%s/zones/%s/instances/%s", ci.Project, ci.Zone, ci.Name)
+		}
+	case s.CreateNetworks != nil:
+		for _, cn := range *s.CreateNetworks {
+			add("compute.networks.insert projects/%s/global/networks/%s", cn.Project, cn.Name)
+		}
+	case s.CreateResourcePolicies != nil:
+		for _, crp := range *s.CreateResourcePolicies {
+			add("compute.resourcePolicies.insert projects/%s/regions/%s/resourcePolicies/%s", crp.Project, crp.Region, crp.Name)
+		}
+	case s.CreateSnapshots != nil:
+		for _, cs := range *s.CreateSnapshots {
+			add("compute.disks.createSnapshot projects/%s/zones/%s/disks/%s to projects/%s/global/snapshots/%s", cs.project, cs.zone, cs.disk, cs.project, cs.Name)
+		}
+	case s.CopyGCSObjects != nil:
+		for _, co := range *s.CopyGCSObjects {
+			add("storage.objects.copy %s to %s", co.Source, co.Destination)
+		}
+	case s.DeleteResources != nil:
+		d := s.DeleteResources
+		for _, l := range []struct {
+			rm    *baseResourceMap
+			names []string
+		}{
+			{&addresses[w].baseResourceMap, d.Addresses},
+			{&buckets[w].baseResourceMap, d.Buckets},
+			{&disks[w].baseResourceMap, d.Disks},
+			{&firewallRules[w].baseResourceMap, d.FirewallRules},
+			{&images[w].baseResourceMap, d.Images},
+			{&instances[w].baseResourceMap, d.Instances},
+			{&networks[w].baseResourceMap, d.Networks},
+			{&resourcePolicies[w].baseResourceMap, d.ResourcePolicies},
+			{&snapshots[w].baseResourceMap, d.Snapshots},
+			{&subnetworks[w].baseResourceMap, d.Subnetworks},
+		} {
+			for _, name := range l.names {
+				calls = append(calls, deleteCall(l.rm.typeName, link(l.rm, name)))
+			}
+		}
+		for _, p := range d.GCSPaths {
+			add("storage.objects.delete %s", p)
+		}
+	case s.GrantRoles != nil:
+		for _, gr := range *s.GrantRoles {
+			add("cloudresourcemanager.projects.setIamPolicy projects/%s, grant %s to %s until cleanup", gr.Project, gr.Role, gr.Member)
+		}
+	case s.PruneImages != nil:
+		for _, pi := range *s.PruneImages {
+			what := "family " + pi.Family
+			if pi.Family == "" {
+				what = "prefix " + pi.Prefix
+			}
+			add("compute.images.list projects/%s, images of %s", pi.Project, what)
+			if !pi.DryRun {
+				add("compute.images.delete projects/%s/global/images/..., all but the newest %d images of %s", pi.Project, pi.Keep, what)
+			}
+		}
+	case s.PublishImages != nil:
+		for _, pi := range *s.PublishImages {
+			add("compute.images.getFromFamily projects/%s/global/images/family/%s", pi.Project, pi.Family)
+			add("compute.images.insert projects/%s/global/images/%s", pi.Project, pi.Name)
+			add("compute.images.deprecate projects/%s/global/images/..., the previous image of family %s, %s", pi.Project, pi.Family, pi.DeprecationState)
+			if pi.ReleaseNotes != "" {
+				add("storage.objects.insert %s", pi.ReleaseNotesPath)
+			}
+		}
+	case s.VerifyContentHashes != nil:
+		for _, ch := range *s.VerifyContentHashes {
+			name := w.genName("hash-" + ch.Name)
+			project, zone := ch.Project, ch.Zone
+			if ch.Disk != "" {
+				m := namedSubexp(diskURLRgx, link(&disks[w].baseResourceMap, ch.Disk))
+				project = m["project"]
+				if m["zone"] != "" {
+					zone = m["zone"]
+				}
+			}
+			add("compute.instances.insert projects/%s/zones/%s/instances/%s", project, zone, name)
+			add("compute.instances.getSerialPortOutput projects/%s/zones/%s/instances/%s, until the hash is printed", project, zone, name)
+			add("compute.instances.delete projects/%s/zones/%s/instances/%s", project, zone, name)
+		}
+	case s.WaitForGCSObject != nil:
+		o := s.WaitForGCSObject
+		if o.ContentMatch != "" {
+			add("storage.objects.get %s, every %s until it contains %q", o.Path, o.interval, o.ContentMatch)
+		} else {
+			add("storage.objects.get %s, every %s until it exists", o.Path, o.interval)
+		}
+	case s.WaitForInstancesSignal != nil:
+		for _, is := range *s.WaitForInstancesSignal {
+			l := link(&instances[w].baseResourceMap, is.Name)
+			if is.Stopped {
+				add("compute.instances.get %s, every %s until stopped", l, is.interval)
+			}
+			if is.SerialOutput != nil {
+				add("compute.instances.getSerialPortOutput %s, port %d every %s until signaled", l, is.SerialOutput.Port, is.interval)
+			}
+			if is.Reboots != nil {
+				add("compute.instances.get %s, every %s until rebooted %d times", l, is.interval, is.Reboots.Count)
+				add("compute.instances.getSerialPortOutput %s, port %d every %s until rebooted %d times", l, is.Reboots.Port, is.interval, is.Reboots.Count)
+			}
+		}
+	case s.WriteTemplatedFiles != nil:
+		for _, tf := range *s.WriteTemplatedFiles {
+			add("storage.objects.insert gs://%s", path.Join(w.bucket, w.sourcesPath, tf.Destination))
+		}
+	}
+	return calls
+}
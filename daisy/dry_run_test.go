@@ -0,0 +1,89 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.Sources = map[string]string{"script": "gs://bkt/script.sh"}
+	w.Steps = map[string]*Step{
+		"create-disk":  {CreateDisks: &CreateDisks{{Disk: compute.Disk{Name: "d", SizeGb: 10}}}},
+		"create-image": {CreateImages: &CreateImages{{Image: compute.Image{Name: "i", SourceDisk: "d"}, ExactName: true, NoCleanup: true}}},
+		"copy":         {CopyGCSObjects: &CopyGCSObjects{{Source: "gs://bkt/a", Destination: "gs://bkt/b"}}},
+		"delete-disk":  {DeleteResources: &DeleteResources{Disks: []string{"d"}}},
+	}
+	w.Dependencies = map[string][]string{
+		"create-image": {"create-disk"},
+		"delete-disk":  {"create-image"},
+	}
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if err := w.validate(ctx); err != nil {
+		t.Fatalf("error validating workflow: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w.dryRun(&buf)
+
+	want := fmt.Sprintf(`Workflow %[1]q:
+  Upload sources:
+    storage.objects.copy gs://%[2]s/%[3]s/script from gs://bkt/script.sh
+  Step "copy" (CopyGCSObjects):
+    storage.objects.copy gs://bkt/a to gs://bkt/b
+  Step "create-disk" (CreateDisks):
+    compute.disks.insert projects/%[4]s/zones/%[5]s/disks/%[6]s
+  Step "create-image" (CreateImages):
+    compute.images.insert projects/%[4]s/global/images/%[7]s
+  Step "delete-disk" (DeleteResources):
+    compute.disks.delete projects/%[4]s/zones/%[5]s/disks/%[6]s
+`, w.Name, w.bucket, w.sourcesPath, testProject, testZone, w.genName("d"), "i")
+	if d := diff.Diff(buf.String(), want); d != "" {
+		t.Errorf("dry run output does not match expectation: (-got +want)\n%s", d)
+	}
+}
+
+func TestDryRunCleanup(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w}
+	disks[w].m = map[string]*resource{
+		"d0": {link: "projects/p/zones/z/disks/d0", creator: s},
+		"d1": {link: "projects/p/zones/z/disks/d1", creator: s, noCleanup: true},
+		"d2": {link: "projects/p/zones/z/disks/d2", creator: s, deleter: s},
+		"d3": {link: "projects/p/zones/z/disks/d3"},
+	}
+	images[w].m = map[string]*resource{"i0": {link: "projects/p/global/images/i0", creator: s}}
+
+	var buf bytes.Buffer
+	w.dryRun(&buf)
+	want := fmt.Sprintf(`Workflow %q:
+  Cleanup:
+    compute.disks.delete projects/p/zones/z/disks/d0
+    compute.images.delete projects/p/global/images/i0
+`, w.Name)
+	if d := diff.Diff(buf.String(), want); d != "" {
+		t.Errorf("dry run output does not match expectation: (-got +want)\n%s", d)
+	}
+}
@@ -0,0 +1,96 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// selectEntrypoint removes the steps the selected Entrypoint doesn't run
+// from w: those it doesn't list, unless a listed step depends on them.
+func (w *Workflow) selectEntrypoint() error {
+	var names []string
+	for name, steps := range w.Entrypoints {
+		names = append(names, name)
+		for _, s := range steps {
+			if _, ok := w.Steps[s]; !ok {
+				return fmt.Errorf("entrypoint %q references non existent step %q", name, s)
+			}
+		}
+	}
+	if w.Entrypoint == "" {
+		return nil
+	}
+	steps, ok := w.Entrypoints[w.Entrypoint]
+	if !ok {
+		sort.Strings(names)
+		return fmt.Errorf("unknown entrypoint %q, the workflow has entrypoints: [%s]", w.Entrypoint, strings.Join(names, ", "))
+	}
+
+	w.keepSteps(steps)
+	return nil
+}
+
+// RunTargets runs only the steps named by targets and the steps these
+// depend on, e.g. to iterate on the last steps of a long workflow. The
+// other steps are dropped before the workflow is populated, as for
+// Entrypoint.
+func (w *Workflow) RunTargets(ctx context.Context, targets ...string) error {
+	w.targets = targets
+	return w.Run(ctx)
+}
+
+// selectTargets removes the steps the targets of RunTargets don't need
+// from w.
+func (w *Workflow) selectTargets() error {
+	if len(w.targets) == 0 {
+		return nil
+	}
+	for _, s := range w.targets {
+		if _, ok := w.Steps[s]; !ok {
+			return fmt.Errorf("unknown target step %q", s)
+		}
+	}
+	w.keepSteps(w.targets)
+	return nil
+}
+
+// keepSteps removes all steps but steps, and the steps these depend on,
+// from w.
+func (w *Workflow) keepSteps(steps []string) {
+	keep := map[string]bool{}
+	var add func(name string)
+	add = func(name string) {
+		if keep[name] {
+			return
+		}
+		keep[name] = true
+		for _, dep := range w.Dependencies[name] {
+			add(dep)
+		}
+	}
+	for _, s := range steps {
+		add(s)
+	}
+	for name := range w.Steps {
+		if !keep[name] {
+			delete(w.Steps, name)
+			delete(w.Dependencies, name)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSelectEntrypoint(t *testing.T) {
+	tests := []struct {
+		desc        string
+		entrypoint  string
+		entrypoints map[string][]string
+		wantSteps   []string
+		wantDeps    map[string][]string
+		shouldErr   bool
+	}{
+		{"no entrypoint case", "", map[string][]string{"test": {"s1"}}, []string{"s0", "s1", "s2", "s3"}, map[string][]string{"s1": {"s0"}, "s2": {"s1"}}, false},
+		{"entrypoint case", "test", map[string][]string{"test": {"s1"}}, []string{"s0", "s1"}, map[string][]string{"s1": {"s0"}}, false},
+		{"transitive dependencies case", "publish", map[string][]string{"publish": {"s2", "s3"}}, []string{"s0", "s1", "s2", "s3"}, map[string][]string{"s1": {"s0"}, "s2": {"s1"}}, false},
+		{"unknown entrypoint case", "dne", map[string][]string{"test": {"s1"}}, nil, nil, true},
+		{"bad step case", "", map[string][]string{"test": {"dne"}}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Steps = map[string]*Step{"s0": {}, "s1": {}, "s2": {}, "s3": {}}
+		w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}}
+		w.Entrypoint = tt.entrypoint
+		w.Entrypoints = tt.entrypoints
+
+		err := w.selectEntrypoint()
+		if err != nil {
+			if !tt.shouldErr {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if tt.shouldErr {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+
+		var steps []string
+		for name := range w.Steps {
+			steps = append(steps, name)
+		}
+		sort.Strings(steps)
+		if diff := pretty.Compare(steps, tt.wantSteps); diff != "" {
+			t.Errorf("%s: steps do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if diff := pretty.Compare(w.Dependencies, tt.wantDeps); diff != "" {
+			t.Errorf("%s: dependencies do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	tests := []struct {
+		desc      string
+		targets   []string
+		wantSteps []string
+		wantDeps  map[string][]string
+		shouldErr bool
+	}{
+		{"no targets case", nil, []string{"s0", "s1", "s2", "s3"}, map[string][]string{"s1": {"s0"}, "s2": {"s1"}}, false},
+		{"target case", []string{"s1"}, []string{"s0", "s1"}, map[string][]string{"s1": {"s0"}}, false},
+		{"multiple targets case", []string{"s2", "s3"}, []string{"s0", "s1", "s2", "s3"}, map[string][]string{"s1": {"s0"}, "s2": {"s1"}}, false},
+		{"no dependencies case", []string{"s3"}, []string{"s3"}, map[string][]string{}, false},
+		{"bad step case", []string{"s1", "dne"}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Steps = map[string]*Step{"s0": {}, "s1": {}, "s2": {}, "s3": {}}
+		w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}}
+		w.targets = tt.targets
+
+		err := w.selectTargets()
+		if err != nil {
+			if !tt.shouldErr {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if tt.shouldErr {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+
+		var steps []string
+		for name := range w.Steps {
+			steps = append(steps, name)
+		}
+		sort.Strings(steps)
+		if diff := pretty.Compare(steps, tt.wantSteps); diff != "" {
+			t.Errorf("%s: steps do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if diff := pretty.Compare(w.Dependencies, tt.wantDeps); diff != "" {
+			t.Errorf("%s: dependencies do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
@@ -75,3 +75,46 @@ func (e *Errors) Error() string {
 	}
 	return fmt.Sprintf("Errors:\n%s", strings.Join(errStrs, "\n"))
 }
+
+// Step error phases, see StepError.
+const (
+	PhasePopulate = "populate"
+	PhaseValidate = "validation"
+	PhaseRun      = "run"
+)
+
+// StepError is returned for errors in the populate, validation, or run
+// phase of a step. Err is the underlying error from the step, such as a
+// *googleapi.Error from a failed API call.
+type StepError struct {
+	// Step is the name of the step.
+	Step string
+	// Chain is the names of the IncludeWorkflow, SubWorkflow, and ForEach
+	// steps leading to the step, outermost first, ending with Step.
+	Chain []string
+	// Phase is one of PhasePopulate, PhaseValidate, or PhaseRun.
+	Phase string
+	Err   error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %q %s error: %s", e.Step, e.Phase, e.Err)
+}
+
+// Unwrap returns the underlying step error.
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the innermost non StepError error, unwrapping the
+// StepErrors of nested IncludeWorkflow and SubWorkflow steps.
+func (e *StepError) Cause() error {
+	err := e.Err
+	for {
+		se, ok := err.(*StepError)
+		if !ok {
+			return err
+		}
+		err = se.Err
+	}
+}
@@ -0,0 +1,86 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/clouderrorreporting/v1beta1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+// Step failure categories reported to Cloud Error Reporting.
+const (
+	errCategoryAPI        = "api"
+	errCategoryStep       = "step"
+	errCategoryTimeout    = "timeout"
+	errCategoryValidation = "validation"
+)
+
+func newErrorReportingClient(ctx context.Context, oauthPath string) (*clouderrorreporting.Service, error) {
+	hc, _, err := transport.NewHTTPClient(ctx, option.WithScopes(clouderrorreporting.CloudPlatformScope), option.WithCredentialsFile(oauthPath))
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	return clouderrorreporting.New(hc)
+}
+
+// errorCategory classifies a step run error.
+func errorCategory(err error) string {
+	if _, ok := err.(*googleapi.Error); ok {
+		return errCategoryAPI
+	}
+	return errCategoryStep
+}
+
+// reportStepError reports a step failure to Cloud Error Reporting, if
+// enabled. Failures of IncludeWorkflow, ForEach and SubWorkflow steps are
+// not reported as the failing step within them already was.
+func (w *Workflow) reportStepError(s *Step, category string, err error) {
+	if w.errorReportingClient == nil {
+		return
+	}
+	impl, implErr := s.stepImpl()
+	if implErr != nil {
+		return
+	}
+	switch impl.(type) {
+	case *IncludeWorkflow, *ForEach, *SubWorkflow:
+		if category != errCategoryTimeout {
+			return
+		}
+	}
+	st := s.typeName()
+	name := w.qualifiedName()
+	e := &clouderrorreporting.ReportedErrorEvent{
+		EventTime: time.Now().UTC().Format(time.RFC3339Nano),
+		Message:   w.root().redactor.redact(fmt.Sprintf("workflow %q step %q (%s) failed [%s]: %v", name, s.name, st, category, err)),
+		ServiceContext: &clouderrorreporting.ServiceContext{
+			Service: "daisy",
+			Version: name,
+		},
+		Context: &clouderrorreporting.ErrorContext{
+			ReportLocation: &clouderrorreporting.SourceLocation{FilePath: name, FunctionName: s.name},
+			User:           w.username,
+		},
+	}
+	if _, rErr := w.errorReportingClient.Projects.Events.Report("projects/"+w.Project, e).Do(); rErr != nil {
+		w.logger.Errorf("Error reporting failure of step %q to Cloud Error Reporting: %v", s.name, rErr)
+	}
+}
@@ -0,0 +1,95 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/clouderrorreporting/v1beta1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		desc string
+		err  error
+		want string
+	}{
+		{"API error case", &googleapi.Error{Code: 403}, errCategoryAPI},
+		{"step error case", errors.New("foo"), errCategoryStep},
+	}
+
+	for _, tt := range tests {
+		if got := errorCategory(tt.err); got != tt.want {
+			t.Errorf("%s: unexpected category, got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestReportStepError(t *testing.T) {
+	var gotPath string
+	var got []*clouderrorreporting.ReportedErrorEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		e := &clouderrorreporting.ReportedErrorEvent{}
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = append(got, e)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	w.errorReportingClient, _ = clouderrorreporting.New(http.DefaultClient)
+	w.errorReportingClient.BasePath = ts.URL + "/"
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	sw.Project = w.Project
+	sw.errorReportingClient = w.errorReportingClient
+	sw.logger = w.logger
+	sw.Steps = map[string]*Step{"inner": {name: "inner", w: sw, timeout: time.Minute, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+		return errors.New("fail s3cret")
+	}}}}
+	w.redactor.add("s3cret")
+	w.Steps = map[string]*Step{"sub": {name: "sub", w: w, timeout: time.Minute, SubWorkflow: &SubWorkflow{w: sw}}}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Only the inner step is reported, not the SubWorkflow step running it.
+	if len(got) != 1 {
+		t.Fatalf("unexpected number of reported errors, got: %d, want: 1", len(got))
+	}
+	if want := "/v1beta1/projects/" + testProject + "/events:report"; gotPath != want {
+		t.Errorf("unexpected request path, got: %q, want: %q", gotPath, want)
+	}
+	wantMsg := `workflow "` + testWf + `.sub" step "inner" (mockStep) failed [step]: fail ` + redactedText
+	if got[0].Message != wantMsg {
+		t.Errorf("unexpected message, got: %q, want: %q", got[0].Message, wantMsg)
+	}
+	if loc := got[0].Context.ReportLocation; loc.FilePath != testWf+".sub" || loc.FunctionName != "inner" {
+		t.Errorf("unexpected report location: %+v", loc)
+	}
+}
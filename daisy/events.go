@@ -0,0 +1,255 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+	"google.golang.org/api/transport"
+)
+
+// Types of the events published to Workflow.PubSubTopic.
+const (
+	EventWorkflowStarted   = "workflow.started"
+	EventWorkflowSucceeded = "workflow.succeeded"
+	EventWorkflowFailed    = "workflow.failed"
+	EventWorkflowCanceled  = "workflow.canceled"
+	EventStepStarted       = "step.started"
+	EventStepSucceeded     = "step.succeeded"
+	EventStepFailed        = "step.failed"
+	EventStepCanceled      = "step.canceled"
+	EventStepSkipped       = "step.skipped"
+	EventResourceCreated   = "resource.created"
+)
+
+// stepEvents are the event types of the step states, steps moving to
+// states without one aren't published.
+var stepEvents = map[StepState]string{
+	StepRunning:  EventStepStarted,
+	StepFinished: EventStepSucceeded,
+	StepFailed:   EventStepFailed,
+	StepCanceled: EventStepCanceled,
+	StepSkipped:  EventStepSkipped,
+}
+
+// Event is a state change of a workflow run, published as the JSON data of
+// a Pub/Sub message, see Workflow.PubSubTopic. The message attributes hold
+// its Workflow, WorkflowID and Type, to filter subscriptions on.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Workflow is the name of the top level workflow, WorkflowID the ID of
+	// the run.
+	Workflow   string `json:"workflow"`
+	WorkflowID string `json:"workflowId"`
+	// Type is the type of the event, e.g. EventStepFailed.
+	Type string `json:"type"`
+	// Step is the step of step events, and the step that created the
+	// resource of EventResourceCreated. Steps of IncludeWorkflow, ForEach
+	// and SubWorkflow steps are prefixed with the name of the step that ran
+	// them, e.g. "sub.step".
+	Step string `json:"step,omitempty"`
+	// ResourceType and Resource are the type, e.g. "disk", and the URL of
+	// the resource of EventResourceCreated.
+	ResourceType string `json:"resourceType,omitempty"`
+	Resource     string `json:"resource,omitempty"`
+	// Error is the error of failed and canceled workflows and failed steps.
+	Error string `json:"error,omitempty"`
+}
+
+var topicRgx = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+func newPubSubClient(ctx context.Context, oauthPath string) (*pubsub.Service, error) {
+	hc, _, err := transport.NewHTTPClient(ctx, option.WithScopes(pubsub.PubsubScope), option.WithCredentialsFile(oauthPath))
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	return pubsub.New(hc)
+}
+
+// populatePubSubTopic resolves PubSubTopic to the full name of the topic,
+// a topic name alone being in Project.
+func (w *Workflow) populatePubSubTopic() error {
+	topic := w.PubSubTopic
+	if !strings.Contains(topic, "/") {
+		topic = fmt.Sprintf("projects/%s/topics/%s", w.Project, topic)
+	}
+	if !topicRgx.MatchString(topic) {
+		return fmt.Errorf("invalid PubSubTopic %q, must be a topic name or \"projects/PROJECT/topics/TOPIC\"", w.PubSubTopic)
+	}
+	w.pubsubTopic = topic
+	return nil
+}
+
+// eventPublisher publishes the events of a workflow run from a goroutine,
+// so a slow Pub/Sub doesn't hold up the workflow. Events published at the
+// same time are batched.
+type eventPublisher struct {
+	w      *Workflow
+	events chan *Event
+	done   chan struct{}
+	mx     sync.Mutex
+	closed bool
+	// Events dropped as maxQueuedEvents were queued.
+	dropped int
+}
+
+const (
+	// maxEventBatch is the most events published in a request.
+	maxEventBatch = 100
+	// maxQueuedEvents is the most events waiting to be published, more
+	// are dropped rather than holding up the workflow.
+	maxQueuedEvents = 1000
+)
+
+// startEvents starts publishing the events of the run of w if PubSubTopic
+// is set, and publishes EventWorkflowStarted. The returned function
+// publishes the outcome of the run, see runOutcome, given the error it
+// returned, and waits for the events to be published.
+func (w *Workflow) startEvents() (finish func(outcome string, err error)) {
+	if w.pubsubClient == nil {
+		return func(string, error) {}
+	}
+	p := &eventPublisher{w: w, events: make(chan *Event, maxQueuedEvents), done: make(chan struct{})}
+	go p.run()
+	w.events = p
+	w.OnProgress(func(sp StepProgress) {
+		typ, ok := stepEvents[sp.State]
+		if !ok {
+			return
+		}
+		e := &Event{Time: sp.Time, Type: typ, Step: sp.Step}
+		if sp.Err != nil {
+			e.Error = sp.Err.Error()
+		}
+		p.publish(e)
+	})
+	p.publish(&Event{Time: time.Now(), Type: EventWorkflowStarted})
+	return func(outcome string, err error) {
+		e := &Event{Time: time.Now(), Type: "workflow." + outcome}
+		switch {
+		case err != nil:
+			e.Error = err.Error()
+		case outcome == runCanceled:
+			e.Error = w.getCancelReason()
+		}
+		// The outcome is never dropped, the run is over.
+		p.queue(e, true)
+		p.close()
+	}
+}
+
+// publishResourceCreated publishes EventResourceCreated for the resource
+// of type typ at link, created by step s.
+func (w *Workflow) publishResourceCreated(s *Step, typ, link string) {
+	root := w.root()
+	if root.events == nil {
+		return
+	}
+	e := &Event{Time: time.Now(), Type: EventResourceCreated, ResourceType: typ, Resource: link}
+	if s != nil {
+		e.Step = s.w.nestedName(s.name)
+	}
+	root.events.publish(e)
+}
+
+// publish queues e to be published, or drops it if maxQueuedEvents are
+// queued already.
+func (p *eventPublisher) publish(e *Event) {
+	p.queue(e, false)
+}
+
+// queue queues e, waiting for room if wait is set, dropping e otherwise.
+// Secrets are redacted from the Error of e.
+func (p *eventPublisher) queue(e *Event, wait bool) {
+	e.Workflow = p.w.Name
+	e.WorkflowID = p.w.id
+	e.Error = p.w.root().redactor.redact(e.Error)
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.closed {
+		return
+	}
+	if wait {
+		p.events <- e
+		return
+	}
+	select {
+	case p.events <- e:
+	default:
+		p.dropped++
+	}
+}
+
+// close publishes the remaining events and stops p.
+func (p *eventPublisher) close() {
+	p.mx.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	dropped := p.dropped
+	p.mx.Unlock()
+	<-p.done
+	if dropped > 0 {
+		p.w.logger.Errorf("Dropped %d events, more than %d were waiting to be published to Pub/Sub topic %q.", dropped, maxQueuedEvents, p.w.pubsubTopic)
+	}
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for e := range p.events {
+		batch := []*Event{e}
+	drain:
+		for len(batch) < maxEventBatch {
+			select {
+			case e, ok := <-p.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		if err := p.send(batch); err != nil {
+			p.w.logger.Errorf("Error publishing events to Pub/Sub topic %q: %v", p.w.pubsubTopic, err)
+		}
+	}
+}
+
+func (p *eventPublisher) send(events []*Event) error {
+	req := &pubsub.PublishRequest{}
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		req.Messages = append(req.Messages, &pubsub.PubsubMessage{
+			Data:       base64.StdEncoding.EncodeToString(b),
+			Attributes: map[string]string{"workflow": e.Workflow, "workflowId": e.WorkflowID, "type": e.Type},
+		})
+	}
+	_, err := p.w.pubsubClient.Projects.Topics.Publish(p.w.pubsubTopic, req).Do()
+	return err
+}
@@ -0,0 +1,171 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/pubsub/v1"
+)
+
+func TestPubSubEvents(t *testing.T) {
+	var got []Event
+	var paths []string
+	var mx sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &pubsub.PublishRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		paths = append(paths, r.URL.Path)
+		for _, m := range req.Messages {
+			b, err := base64.StdEncoding.DecodeString(m.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e Event
+			if err := json.Unmarshal(b, &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Time.IsZero() {
+				t.Errorf("event %q has no time", e.Type)
+			}
+			if m.Attributes["type"] != e.Type || m.Attributes["workflow"] != e.Workflow || m.Attributes["workflowId"] != e.WorkflowID {
+				t.Errorf("attributes %v don't match event %+v", m.Attributes, e)
+			}
+			e.Time = time.Time{}
+			got = append(got, e)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc   string
+		secret string
+		run    func(context.Context, *Step) error
+		want   []Event
+	}{
+		{
+			"success",
+			"",
+			func(_ context.Context, s *Step) error {
+				if err := disks[s.w].registerCreation("d", &resource{link: "disk-link", noCleanup: true}, s); err != nil {
+					return err
+				}
+				disks[s.w].markCreated("d")
+				return nil
+			},
+			[]Event{
+				{Type: EventWorkflowStarted},
+				{Type: EventStepStarted, Step: "s"},
+				{Type: EventResourceCreated, Step: "s", ResourceType: "disk", Resource: "disk-link"},
+				{Type: EventStepSucceeded, Step: "s"},
+				{Type: EventWorkflowSucceeded},
+			},
+		},
+		{
+			"failure",
+			"",
+			func(context.Context, *Step) error { return errors.New("fail") },
+			[]Event{
+				{Type: EventWorkflowStarted},
+				{Type: EventStepStarted, Step: "s"},
+				{Type: EventStepFailed, Step: "s", Error: "step \"s\" run error: fail"},
+				{Type: EventWorkflowFailed, Error: "step \"s\" run error: fail"},
+			},
+		},
+		{
+			"secret in error",
+			"s3cret",
+			func(context.Context, *Step) error { return errors.New("fail s3cret") },
+			[]Event{
+				{Type: EventWorkflowStarted},
+				{Type: EventStepStarted, Step: "s"},
+				{Type: EventStepFailed, Step: "s", Error: "step \"s\" run error: fail " + redactedText},
+				{Type: EventWorkflowFailed, Error: "step \"s\" run error: fail " + redactedText},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, paths = nil, nil
+		w := testWorkflow()
+		w.Steps = map[string]*Step{"s": {testType: &mockStep{runImpl: tt.run}}}
+		w.redactor.add(tt.secret)
+		w.PubSubTopic = "events"
+		w.pubsubClient, _ = pubsub.New(http.DefaultClient)
+		w.pubsubClient.BasePath = ts.URL + "/"
+		w.Run(context.Background())
+
+		mx.Lock()
+		for i := range tt.want {
+			tt.want[i].Workflow = w.Name
+			tt.want[i].WorkflowID = w.id
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: events not as expected: (-got +want)\n%s", tt.desc, diff)
+		}
+		for _, p := range paths {
+			if p != "/v1/projects/test-project/topics/events:publish" {
+				t.Errorf("%s: unexpected publish path: %q", tt.desc, p)
+			}
+		}
+		mx.Unlock()
+	}
+}
+
+func TestEventPublisherFull(t *testing.T) {
+	w := testWorkflow()
+	p := &eventPublisher{w: w, events: make(chan *Event, 1), done: make(chan struct{})}
+	// Nothing publishes the queued events, publish doesn't wait for room.
+	p.publish(&Event{Type: EventStepStarted})
+	p.publish(&Event{Type: EventStepSucceeded})
+	if p.dropped != 1 || len(p.events) != 1 {
+		t.Errorf("unexpected dropped: %d, queued: %d, want: 1, 1", p.dropped, len(p.events))
+	}
+}
+
+func TestPopulatePubSubTopic(t *testing.T) {
+	tests := []struct {
+		topic, want string
+		shouldErr   bool
+	}{
+		{"events", "projects/test-project/topics/events", false},
+		{"projects/p/topics/events", "projects/p/topics/events", false},
+		{"topics/events", "", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.PubSubTopic = tt.topic
+		err := w.populatePubSubTopic()
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%q: unexpected error: %v", tt.topic, err)
+		}
+		if w.pubsubTopic != tt.want {
+			t.Errorf("%q: got topic %q, want %q", tt.topic, w.pubsubTopic, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Executor runs the steps of a workflow in dependency order, e.g. to replace
+// daisy's scheduling with a priority queue, or to run steps on distributed
+// workers. Executors can use StepGraph to track which steps are ready.
+type Executor interface {
+	// Execute calls run for each of w's steps, after the run of each step
+	// it depends on returned. run may be called concurrently. Once a run
+	// returns an error, or w.Cancel is closed, Execute stops starting steps,
+	// waits for the running ones, and returns the first error.
+	Execute(w *Workflow, run func(*Step) error) error
+}
+
+// ExecutorFunc is an adapter to use an ordinary function as an Executor.
+type ExecutorFunc func(w *Workflow, run func(*Step) error) error
+
+// Execute calls f(w, run).
+func (f ExecutorFunc) Execute(w *Workflow, run func(*Step) error) error {
+	return f(w, run)
+}
+
+// executor returns the Executor of w or of its closest parent that has one,
+// the default executor if none has.
+func (w *Workflow) executor() Executor {
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.Executor != nil {
+			return wf.Executor
+		}
+	}
+	return localExecutor{}
+}
+
+// StepGraph tracks the steps of a workflow through a traversal: waiting for
+// dependencies, running, and done. It is not safe for concurrent use.
+type StepGraph struct {
+	waiting map[string][]string
+	running []string
+}
+
+// NewStepGraph returns a StepGraph with all of w's steps waiting.
+func NewStepGraph(w *Workflow) *StepGraph {
+	g := &StepGraph{waiting: map[string][]string{}}
+	for name := range w.Steps {
+		g.waiting[name] = w.Dependencies[name]
+	}
+	return g
+}
+
+// Ready returns the sorted names of the waiting steps whose dependencies are
+// done.
+func (g *StepGraph) Ready() []string {
+	var ready []string
+	for name, deps := range g.waiting {
+		if len(deps) == 0 {
+			ready = append(ready, name)
+		}
+	}
+	sort.Strings(ready)
+	return ready
+}
+
+// Running returns the sorted names of the started steps that aren't done.
+func (g *StepGraph) Running() []string {
+	running := append([]string{}, g.running...)
+	sort.Strings(running)
+	return running
+}
+
+// Start marks the ready step name as running.
+func (g *StepGraph) Start(name string) error {
+	deps, ok := g.waiting[name]
+	if !ok {
+		return fmt.Errorf("step %q is not waiting", name)
+	}
+	if len(deps) != 0 {
+		return fmt.Errorf("step %q is waiting for %q", name, deps)
+	}
+	delete(g.waiting, name)
+	g.running = append(g.running, name)
+	return nil
+}
+
+// Done marks the running step name as done, which readies the steps only
+// waiting for it.
+func (g *StepGraph) Done(name string) {
+	for n, deps := range g.waiting {
+		g.waiting[n] = filter(deps, name)
+	}
+	g.running = filter(g.running, name)
+}
+
+// Cancel drops the waiting steps, they won't become ready.
+func (g *StepGraph) Cancel() {
+	g.waiting = map[string][]string{}
+}
+
+// Finished reports whether no steps are waiting or running.
+func (g *StepGraph) Finished() bool {
+	return len(g.waiting) == 0 && len(g.running) == 0
+}
+
+// localExecutor is the default Executor, it runs each step in a goroutine of
+// its own, as the workflow's Scheduler allows.
+type localExecutor struct{}
+
+func (localExecutor) Execute(w *Workflow, run func(*Step) error) error {
+	g := NewStepGraph(w)
+	// start = map of steps' start channels/semaphores.
+	// done = map of steps' done channels for signaling step completion.
+	start := map[string]chan error{}
+	done := map[string]chan error{}
+	for name := range w.Steps {
+		start[name] = make(chan error)
+		done[name] = make(chan error)
+	}
+	// Setup: goroutine for each step. Each waits to be notified to start.
+	for name, s := range w.Steps {
+		go func(name string, s *Step) {
+			// Wait for signal, then run the function. Return any errs.
+			if err := <-start[name]; err != nil {
+				done[name] <- err
+			} else if err := run(s); err != nil {
+				done[name] <- err
+			}
+			close(done[name])
+		}(name, s)
+	}
+
+	// Main signaling logic.
+	var firstErr error
+	for !g.Finished() {
+		// If we got a Cancel signal, kill all waiting steps.
+		// Let running steps finish.
+		select {
+		case <-w.cancelChan():
+			g.Cancel()
+		default:
+		}
+
+		// Kick off the steps that aren't waiting for anything, as chosen
+		// by the scheduler.
+		ready := g.Ready()
+		if len(ready) != 0 {
+			for _, name := range w.schedule(ready, g.Running()) {
+				if err := g.Start(name); err != nil {
+					continue
+				}
+				close(start[name])
+			}
+		}
+
+		// Sanity check. There should be at least one running step,
+		// but loop back through if there isn't.
+		running := g.Running()
+		if len(running) == 0 {
+			select {
+			case <-w.cancelChan():
+				// The scheduler stopped waiting for a step to start.
+				continue
+			default:
+			}
+			if len(ready) != 0 {
+				return fmt.Errorf("scheduler started none of the ready steps %q", ready)
+			}
+			continue
+		}
+
+		// Get next finished step. Once a step erred, start no more steps
+		// and wait for the running ones, then return the error.
+		finished, err := stepsListen(running, done)
+		if err != nil && firstErr == nil {
+			firstErr = err
+			g.Cancel()
+		}
+		g.Done(finished)
+	}
+	return firstErr
+}
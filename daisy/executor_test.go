@@ -0,0 +1,145 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// serialExecutor runs the ready steps one at a time, the last ready one
+// first.
+var serialExecutor = ExecutorFunc(func(w *Workflow, run func(*Step) error) error {
+	g := NewStepGraph(w)
+	for !g.Finished() {
+		ready := g.Ready()
+		name := ready[len(ready)-1]
+		if err := g.Start(name); err != nil {
+			return err
+		}
+		if err := run(w.Steps[name]); err != nil {
+			return err
+		}
+		g.Done(name)
+	}
+	return nil
+})
+
+func TestExecutor(t *testing.T) {
+	ctx := context.Background()
+	var callOrder []int
+	var mx sync.Mutex
+	var runErr error
+	mockRun := func(i int) func(context.Context, *Step) error {
+		return func(_ context.Context, _ *Step) error {
+			mx.Lock()
+			defer mx.Unlock()
+			callOrder = append(callOrder, i)
+			return runErr
+		}
+	}
+
+	w := testTraverseWorkflow(mockRun)
+	w.Executor = serialExecutor
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(callOrder, []int{4, 0, 2, 1, 3}); diff != "" {
+		t.Errorf("steps not run in executor's order: (-got +want)\n%s", diff)
+	}
+
+	// Step errors are returned.
+	callOrder = nil
+	runErr = errors.New("fail")
+	w = testTraverseWorkflow(mockRun)
+	w.Executor = serialExecutor
+	if err := w.Run(ctx); err == nil {
+		t.Error("expected error from failing step")
+	}
+	if diff := pretty.Compare(callOrder, []int{4}); diff != "" {
+		t.Errorf("steps run after a failure: (-got +want)\n%s", diff)
+	}
+
+	// Subworkflows use their parent's executor.
+	sw := w.NewSubWorkflow()
+	if _, ok := sw.executor().(ExecutorFunc); !ok {
+		t.Errorf("subworkflow not run by parent's executor, got: %T", sw.executor())
+	}
+	if _, ok := testWorkflow().executor().(localExecutor); !ok {
+		t.Error("workflow without executor should use the default executor")
+	}
+}
+
+func TestLocalExecutorWaitsForRunningSteps(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{"fail": {name: "fail", w: w}, "slow": {name: "slow", w: w}}
+	var mx sync.Mutex
+	slowDone := false
+	err := localExecutor{}.Execute(w, func(s *Step) error {
+		if s.name == "fail" {
+			return errors.New("fail")
+		}
+		time.Sleep(20 * time.Millisecond)
+		mx.Lock()
+		defer mx.Unlock()
+		slowDone = true
+		return nil
+	})
+	if err == nil {
+		t.Error("expected error from failing step")
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if !slowDone {
+		t.Error("Execute returned before the running step finished")
+	}
+}
+
+func TestStepGraph(t *testing.T) {
+	w := testTraverseWorkflow(func(int) func(context.Context, *Step) error { return nil })
+	g := NewStepGraph(w)
+
+	if diff := pretty.Compare(g.Ready(), []string{"s0", "s4"}); diff != "" {
+		t.Errorf("unexpected ready steps: (-got +want)\n%s", diff)
+	}
+	if err := g.Start("s1"); err == nil {
+		t.Error("started s1 before its dependencies were done")
+	}
+	if err := g.Start("s0"); err != nil {
+		t.Fatalf("error starting s0: %v", err)
+	}
+	if err := g.Start("s0"); err == nil {
+		t.Error("started s0 twice")
+	}
+	if diff := pretty.Compare(g.Running(), []string{"s0"}); diff != "" {
+		t.Errorf("unexpected running steps: (-got +want)\n%s", diff)
+	}
+	g.Done("s0")
+	if diff := pretty.Compare(g.Ready(), []string{"s1", "s2", "s4"}); diff != "" {
+		t.Errorf("unexpected ready steps after s0: (-got +want)\n%s", diff)
+	}
+	if g.Finished() {
+		t.Error("graph finished with steps waiting")
+	}
+	g.Cancel()
+	if len(g.Ready()) != 0 || !g.Finished() {
+		t.Errorf("steps still waiting after Cancel: %q", g.Ready())
+	}
+}
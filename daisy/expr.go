@@ -0,0 +1,190 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// exprRgx matches a function call in a substitution, e.g. ${upper(name)}.
+var exprRgx = regexp.MustCompile(`\$\{([a-zA-Z]+)\(([^(){}]*)\)\}`)
+
+// exprFuncs are the functions that can be called in substitutions, by name.
+// Each is called with its evaluated arguments.
+var exprFuncs = map[string]func(args []string) (string, error){
+	"upper": func(args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("want 1 argument, got %d", len(args))
+		}
+		return strings.ToUpper(args[0]), nil
+	},
+	"lower": func(args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("want 1 argument, got %d", len(args))
+		}
+		return strings.ToLower(args[0]), nil
+	},
+	"substr": func(args []string) (string, error) {
+		if len(args) != 2 && len(args) != 3 {
+			return "", fmt.Errorf("want 2 or 3 arguments, got %d", len(args))
+		}
+		// start and length count characters, not bytes.
+		s := []rune(args[0])
+		start, err := strconv.Atoi(args[1])
+		if err != nil || start < 0 {
+			return "", fmt.Errorf("start must be a non negative integer, got %q", args[1])
+		}
+		start = minInt(start, len(s))
+		end := len(s)
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				return "", fmt.Errorf("length must be a non negative integer, got %q", args[2])
+			}
+			end = start + minInt(n, end-start)
+		}
+		return string(s[start:end]), nil
+	},
+	"rand": func(args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("want 1 argument, got %d", len(args))
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return "", fmt.Errorf("length must be a positive integer, got %q", args[0])
+		}
+		return randString(n), nil
+	},
+	"env": func(args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("want 1 argument, got %d", len(args))
+		}
+		return os.Getenv(args[0]), nil
+	},
+}
+
+// evalExprArg evaluates a function argument: a quoted string, an integer, or
+// the name of a var or autovar.
+func evalExprArg(arg string, values map[string]string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, `"`) {
+		return strconv.Unquote(arg)
+	}
+	if _, err := strconv.Atoi(arg); err == nil {
+		return arg, nil
+	}
+	v, ok := values[arg]
+	if !ok {
+		return "", fmt.Errorf("unknown var %q", arg)
+	}
+	return v, nil
+}
+
+// splitExprArgs splits function arguments on commas outside of quotes.
+func splitExprArgs(s string) []string {
+	var args []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			args = append(args, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(args, s[start:])
+}
+
+// evalExprs replaces the function calls in s with their results.
+func evalExprs(s string, values map[string]string) (string, error) {
+	var errs []string
+	var r []byte
+	last := 0
+	for _, loc := range exprRgx.FindAllStringSubmatchIndex(s, -1) {
+		// Leave escaped substitutions, "$${...}", as they are.
+		if loc[0] > 0 && s[loc[0]-1] == '$' {
+			continue
+		}
+		r = append(r, s[last:loc[0]]...)
+		last = loc[1]
+		r = append(r, evalExpr(s[loc[0]:loc[1]], s[loc[2]:loc[3]], s[loc[4]:loc[5]], values, &errs)...)
+	}
+	r = append(r, s[last:]...)
+	if errs != nil {
+		return "", fmt.Errorf("error evaluating substitution: %s", strings.Join(errs, "; "))
+	}
+	return string(r), nil
+}
+
+// evalExpr returns the result of the call m of function name with args, m
+// itself on errors, which are added to errs.
+func evalExpr(m, name, argList string, values map[string]string, errs *[]string) string {
+	f, ok := exprFuncs[name]
+	if !ok {
+		*errs = append(*errs, fmt.Sprintf("%s: unknown function %q", m, name))
+		return m
+	}
+	var args []string
+	if strings.TrimSpace(argList) != "" {
+		for _, a := range splitExprArgs(argList) {
+			v, err := evalExprArg(a, values)
+			if err != nil {
+				*errs = append(*errs, fmt.Sprintf("%s: %v", m, err))
+				return m
+			}
+			args = append(args, v)
+		}
+	}
+	v, err := f(args)
+	if err != nil {
+		*errs = append(*errs, fmt.Sprintf("%s: %v", m, err))
+		return m
+	}
+	return v
+}
+
+// substituteExprs evaluates the function calls in string elements within a
+// complex data structure (except those contained in private data structure
+// fields). Function arguments refer to the vars and autovars of the
+// replacements, old and new string pairs as given to strings.NewReplacer.
+func substituteExprs(v reflect.Value, replacements []string) error {
+	values := map[string]string{}
+	for i := 0; i+1 < len(replacements); i += 2 {
+		k := strings.TrimSuffix(strings.TrimPrefix(replacements[i], "${"), "}")
+		values[k] = replacements[i+1]
+	}
+	return traverseData(v, func(val reflect.Value) error {
+		switch val.Interface().(type) {
+		case string:
+			s, err := evalExprs(val.String(), values)
+			if err != nil {
+				return err
+			}
+			val.SetString(s)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestEvalExprs(t *testing.T) {
+	os.Setenv("DAISY_EXPR_TEST", "from-env")
+	defer os.Unsetenv("DAISY_EXPR_TEST")
+	values := map[string]string{"name": "Image-Name", "list[0]": "a", "ID": "abcdef", "utf8": "héllo-wörld"}
+
+	tests := []struct {
+		desc, s, want string
+		shouldErr     bool
+	}{
+		{"no function case", "${name} foo", "${name} foo", false},
+		{"escaped case", "$${upper(name)}-${upper(name)}", "$${upper(name)}-IMAGE-NAME", false},
+		{"escaped unknown function case", "$${foo(name)}", "$${foo(name)}", false},
+		{"upper case", "${upper(name)}", "IMAGE-NAME", false},
+		{"lower case", "img-${lower(name)}-${ID}", "img-image-name-${ID}", false},
+		{"substr case", "${substr(name, 0, 5)}", "Image", false},
+		{"substr no length case", "${substr(name,6)}", "Name", false},
+		{"substr out of range case", "${substr(name,6,100)}", "Name", false},
+		{"substr multibyte case", "${substr(utf8, 1, 4)}", "éllo", false},
+		{"substr multibyte no length case", "${substr(utf8, 7)}", "örld", false},
+		{"substr huge length case", "${substr(name, 6, 9223372036854775807)}", "Name", false},
+		{"list element case", "${upper(list[0])}", "A", false},
+		{"quoted case", `${upper("a,b")}`, "A,B", false},
+		{"env case", `${env("DAISY_EXPR_TEST")}`, "from-env", false},
+		{"unknown function case", "${foo(name)}", "", true},
+		{"unknown var case", "${upper(bar)}", "", true},
+		{"bad arg count case", "${upper(name, name)}", "", true},
+		{"bad substr start case", "${substr(name, -1)}", "", true},
+		{"bad rand length case", "${rand(0)}", "", true},
+		{"bad quote case", `${upper("a)}`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := evalExprs(tt.s, values)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+
+	got, err := evalExprs("${rand(6)}-${rand(6)}", values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !regexp.MustCompile(`^[a-z0-9]{6}-[a-z0-9]{6}$`).MatchString(got) {
+		t.Errorf("unexpected rand result: %q", got)
+	}
+}
+
+func TestSubstituteExprs(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"s": {WaitForInstancesSignal: &WaitForInstancesSignal{{Name: "${lower(NAME)}-${substr(ID,0,3)}"}}},
+	}
+	if err := substituteExprs(reflect.ValueOf(w).Elem(), []string{"${NAME}", "Foo", "${ID}", "abcdef"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare((*w.Steps["s"].WaitForInstancesSignal)[0].Name, "foo-abc"); diff != "" {
+		t.Errorf("instance name does not match expectation: (-got +want)\n%s", diff)
+	}
+
+	w.Name = "${nope()}"
+	if err := substituteExprs(reflect.ValueOf(w).Elem(), nil); err == nil {
+		t.Error("should have returned an error")
+	}
+}
@@ -0,0 +1,41 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	firewallRules        = map[*Workflow]*firewallRuleMap{}
+	firewallRuleURLRegex = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/firewalls/(?P<firewall>%[1]s)$`, rfc1035))
+)
+
+type firewallRuleMap struct {
+	baseResourceMap
+}
+
+func initFirewallRuleMap(w *Workflow) {
+	fm := &firewallRuleMap{baseResourceMap: baseResourceMap{w: w, typeName: "firewall rule", urlRgx: firewallRuleURLRegex}}
+	fm.baseResourceMap.deleteFn = fm.deleteFn
+	fm.init()
+	firewallRules[w] = fm
+}
+
+func (fm *firewallRuleMap) deleteFn(r *resource) error {
+	m := namedSubexp(firewallRuleURLRegex, r.link)
+	return fm.w.ComputeClient.DeleteFirewallRule(m["project"], m["firewall"])
+}
@@ -17,11 +17,17 @@ package daisy
 import (
 	"fmt"
 	"regexp"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
 var (
-	images      = map[*Workflow]*imageMap{}
-	imageURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/images/(?P<image>%[1]s)|family/(?P<family>%[1]s)$`, rfc1035))
+	images        = map[*Workflow]*imageMap{}
+	imageURLRgx   = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/images/(family/(?P<family>%[1]s)|(?P<image>%[1]s))$`, rfc1035))
+	licenseURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/licenses/(?P<license>%[1]s)$`, rfc1035))
+
+	// guestOSFeatures are the GuestOsFeatures types GCE supports on images.
+	guestOSFeatures = []string{"MULTI_IP_SUBNET", "SECURE_BOOT", "UEFI_COMPATIBLE", "VIRTIO_SCSI_MULTIQUEUE", "WINDOWS"}
 )
 
 type imageMap struct {
@@ -37,9 +43,17 @@ func initImageMap(w *Workflow) {
 
 func (im *imageMap) deleteFn(r *resource) error {
 	m := namedSubexp(imageURLRgx, r.link)
-	if err := im.w.ComputeClient.DeleteImage(m["project"], m["image"]); err != nil {
+	return im.w.ComputeClient.DeleteImage(m["project"], m["image"])
+}
+
+var imageFamilies = &catalogCache{}
+
+// checkImageFamily checks that family in project has an image that isn't
+// deprecated.
+func checkImageFamily(client compute.Client, project, family string) error {
+	url := fmt.Sprintf("/project/%s/family/%s", project, family)
+	return imageFamilies.check(client, url, func() error {
+		_, err := client.GetImageFromFamily(project, family)
 		return err
-	}
-	r.deleted = true
-	return nil
+	})
 }
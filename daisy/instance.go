@@ -16,8 +16,11 @@ package daisy
 
 import (
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
+
+	"google.golang.org/api/googleapi"
 )
 
 const (
@@ -26,12 +29,19 @@ const (
 	defaultDiskType         = "pd-standard"
 	diskModeRO              = "READ_ONLY"
 	diskModeRW              = "READ_WRITE"
+	reservationAny          = "ANY_RESERVATION"
+	reservationSpecific     = "SPECIFIC_RESERVATION"
+	reservationNone         = "NO_RESERVATION"
+	reservationNameKey      = "compute.googleapis.com/reservation-name"
 )
 
 var (
 	instances      = map[*Workflow]*instanceMap{}
 	instanceURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[1]s)/instances/(?P<instance>%[1]s)$`, rfc1035))
 	validDiskModes = []string{diskModeRO, diskModeRW}
+	// Reservations shared by other projects are given as partial URLs.
+	reservationURLRgx     = regexp.MustCompile(fmt.Sprintf(`^projects/(?P<project>%[1]s)/reservations/(?P<reservation>%[1]s)$`, rfc1035))
+	validReservationTypes = []string{reservationAny, reservationSpecific, reservationNone}
 )
 
 type instanceMap struct {
@@ -47,10 +57,37 @@ func initInstanceMap(w *Workflow) {
 
 func (im *instanceMap) deleteFn(r *resource) error {
 	m := namedSubexp(instanceURLRgx, r.link)
-	if err := im.w.ComputeClient.DeleteInstance(m["project"], m["zone"], m["instance"]); err != nil {
-		return err
+	err := im.w.ComputeClient.DeleteInstance(m["project"], m["zone"], m["instance"])
+	if err != nil && im.deletionProtected(err, m["project"], m["zone"], m["instance"]) {
+		if err = im.clearDeletionProtection(m["project"], m["zone"], m["instance"]); err == nil {
+			err = im.w.ComputeClient.DeleteInstance(m["project"], m["zone"], m["instance"])
+		}
+	}
+	return err
+}
+
+// deletionProtected reports whether err, the error deleting the instance,
+// is as the instance has deletion protection enabled. GCE refuses to
+// delete such instances with a 400 error.
+func (im *instanceMap) deletionProtected(err error, project, zone, name string) bool {
+	if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusBadRequest {
+		return false
+	}
+	i, err := im.w.ComputeClient.GetInstance(project, zone, name)
+	return err == nil && i.DeletionProtection
+}
+
+// clearDeletionProtection clears the deletion protection of the instance
+// if ClearDeletionProtection is set, and returns an error saying it is
+// enabled otherwise.
+func (im *instanceMap) clearDeletionProtection(project, zone, name string) error {
+	if !im.w.ClearDeletionProtection {
+		return fmt.Errorf("cannot delete instance %q in project %q zone %q: deletion protection is enabled, set ClearDeletionProtection to clear it", name, project, zone)
+	}
+	im.w.logger.Printf("Clearing deletion protection of instance %q.", name)
+	if err := im.w.ComputeClient.SetDeletionProtection(project, zone, name, false); err != nil {
+		return fmt.Errorf("error clearing deletion protection of instance %q: %v", name, err)
 	}
-	r.deleted = true
 	return nil
 }
 
@@ -73,7 +110,7 @@ func (im *instanceMap) registerCreation(name string, r *resource, s *Step) error
 		if d.InitializeParams != nil {
 			dName = d.InitializeParams.DiskName
 		}
-		if err := disks[im.w].registerAttachment(dName, ci.daisyName, d.Mode, s); err != nil {
+		if err := disks[im.w].registerAttachment(dName, ci.daisyName, d.Mode, d.AutoDelete, s); err != nil {
 			return err
 		}
 	}
@@ -1,6 +1,14 @@
 package daisy
 
-import "testing"
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
 
 func TestCheckDiskMode(t *testing.T) {
 	tests := []struct {
@@ -20,3 +28,58 @@ func TestCheckDiskMode(t *testing.T) {
 		}
 	}
 }
+
+func TestInstanceDeleteDeletionProtection(t *testing.T) {
+	protectedErr := &googleapi.Error{Code: http.StatusBadRequest}
+	tests := []struct {
+		desc                     string
+		deleteErr                error
+		protected, clear         bool
+		wantCleared, wantDeleted bool
+		shouldErr                bool
+	}{
+		{"unprotected case", nil, false, false, false, true, false},
+		{"protected case", nil, true, false, false, false, true},
+		{"protected clear case", nil, true, true, true, true, false},
+		{"other bad request case", protectedErr, false, false, false, false, true},
+		{"other error case", errors.New("error"), false, false, false, false, true},
+	}
+
+	for _, tt := range tests {
+		var cleared, deleted bool
+		w := testWorkflow()
+		w.ClearDeletionProtection = tt.clear
+		protected := tt.protected
+		w.ComputeClient = &daisyCompute.TestClient{
+			GetInstanceFn: func(_, _, _ string) (*compute.Instance, error) {
+				return &compute.Instance{DeletionProtection: protected}, nil
+			},
+			SetDeletionProtectionFn: func(_, _, _ string, protect bool) error {
+				protected = protect
+				cleared = !protect
+				return nil
+			},
+			DeleteInstanceFn: func(_, _, _ string) error {
+				if protected {
+					return protectedErr
+				}
+				if tt.deleteErr != nil {
+					return tt.deleteErr
+				}
+				deleted = true
+				return nil
+			},
+		}
+		instances[w].m = map[string]*resource{"i": {link: "projects/p/zones/z/instances/i"}}
+
+		err := instances[w].delete("i")
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have erred but didn't", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if cleared != tt.wantCleared || deleted != tt.wantDeleted {
+			t.Errorf("%s: got cleared %t deleted %t, want cleared %t deleted %t", tt.desc, cleared, deleted, tt.wantCleared, tt.wantDeleted)
+		}
+	}
+}
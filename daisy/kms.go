@@ -0,0 +1,46 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+var kmsKeyURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?locations/(?P<location>%[1]s)/keyRings/(?P<keyring>%[2]s)/cryptoKeys/(?P<cryptokey>%[2]s)(/cryptoKeyVersions/(?P<version>[0-9]+))?$`, rfc1035, `[a-zA-Z0-9_-]{1,63}`))
+
+// populateKMSKey extends a partial KMS key name, "locations/..." to include
+// "projects/<project>".
+func populateKMSKey(k *compute.CustomerEncryptionKey, project string) {
+	if k != nil && kmsKeyURLRgx.MatchString(k.KmsKeyName) {
+		k.KmsKeyName = extendPartialURL(k.KmsKeyName, project)
+	}
+}
+
+func checkKMSKey(k *compute.CustomerEncryptionKey) error {
+	if k == nil || k.KmsKeyName == "" {
+		return nil
+	}
+	if !kmsKeyURLRgx.MatchString(k.KmsKeyName) {
+		return fmt.Errorf("bad KmsKeyName: %q", k.KmsKeyName)
+	}
+	if k.RawKey != "" {
+		return errors.New("KmsKeyName and RawKey are mutually exclusive")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Labels Daisy sets on the buckets, disks, images, instances and snapshots
+// it creates, to find resources left behind by a run. labelNoCleanup marks
+// resources the workflow keeps, CleanupOrphans leaves them alone.
+const (
+	labelWorkflowName = "daisy-workflow-name"
+	labelWorkflowID   = "daisy-workflow-id"
+	labelUsername     = "daisy-username"
+	labelNoCleanup    = "daisy-no-cleanup"
+)
+
+var labelValueRgx = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// labelValue converts s into a valid GCE label value.
+func labelValue(s string) string {
+	s = labelValueRgx.ReplaceAllString(strings.ToLower(s), "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// addWorkflowLabels returns labels with the labels identifying the run of
+// the top level workflow w is part of added, and labelNoCleanup if
+// noCleanup is set. Labels set in labels are kept.
+func (w *Workflow) addWorkflowLabels(labels map[string]string, noCleanup bool) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	root := w.root()
+	for k, v := range map[string]string{
+		labelWorkflowName: root.Name,
+		labelWorkflowID:   root.id,
+		labelUsername:     root.username,
+	} {
+		if _, ok := labels[k]; !ok {
+			labels[k] = labelValue(v)
+		}
+	}
+	if _, ok := labels[labelNoCleanup]; !ok && noCleanup {
+		labels[labelNoCleanup] = "true"
+	}
+	return labels
+}
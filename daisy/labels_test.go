@@ -0,0 +1,67 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"my-wf_1", "my-wf_1"},
+		{"My.Workflow", "my_workflow"},
+		{`DOMAIN\user`, "domain_user"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+	}
+
+	for _, tt := range tests {
+		if got := labelValue(tt.input); got != tt.want {
+			t.Errorf("labelValue(%q) = %q, want: %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAddWorkflowLabels(t *testing.T) {
+	w := testWorkflow()
+	w.Name = "Parent"
+	w.username = "someone"
+	sw := w.NewSubWorkflow()
+	sw.Name = "child"
+	sw.id = "ghijk"
+
+	want := map[string]string{"daisy-workflow-name": "parent", "daisy-workflow-id": "abcdef", "daisy-username": "someone"}
+	if diff := pretty.Compare(sw.addWorkflowLabels(nil, false), want); diff != "" {
+		t.Errorf("labels do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// User set labels are kept.
+	got := sw.addWorkflowLabels(map[string]string{"daisy-username": "other", "foo": "bar"}, false)
+	want = map[string]string{"daisy-workflow-name": "parent", "daisy-workflow-id": "abcdef", "daisy-username": "other", "foo": "bar"}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("labels do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Resources the workflow keeps are marked.
+	got = sw.addWorkflowLabels(nil, true)
+	want = map[string]string{"daisy-workflow-name": "parent", "daisy-workflow-id": "abcdef", "daisy-username": "someone", "daisy-no-cleanup": "true"}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("labels do not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,401 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the logs of a workflow, see Workflow.LogFormat.
+const (
+	// LogFormatText writes a line of text for each log.
+	LogFormatText = "text"
+	// LogFormatJSON writes a JSON object for each log, see LogEntry.
+	LogFormatJSON = "json"
+)
+
+var logFormats = []string{LogFormatText, LogFormatJSON}
+
+// Severities of a LogEntry.
+const (
+	SeverityDebug = "DEBUG"
+	SeverityInfo  = "INFO"
+	SeverityError = "ERROR"
+)
+
+// Verbosities of the logs of a workflow, see Workflow.Verbosity.
+const (
+	// VerbosityDebug also logs the API calls of the workflow and the
+	// result of substituting its variables.
+	VerbosityDebug = "debug"
+	// VerbosityInfo logs the progress of the workflow and its errors.
+	VerbosityInfo = "info"
+	// VerbosityError only logs errors.
+	VerbosityError = "error"
+)
+
+// verbosities are in the order of the logs they let through, most first.
+var verbosities = []string{VerbosityDebug, VerbosityInfo, VerbosityError}
+
+// Logger receives the logs of a workflow, e.g. to route them into a logging
+// library with the step they are about. Set it with SetLogger. Logs more
+// verbose than the Verbosity of the workflow aren't sent.
+type Logger interface {
+	// WorkflowDebug, WorkflowInfo and WorkflowError log msg about the
+	// workflow w.
+	WorkflowDebug(w *Workflow, msg string)
+	WorkflowInfo(w *Workflow, msg string)
+	WorkflowError(w *Workflow, msg string)
+	// StepDebug and StepInfo log msg about the step of w of type stepType,
+	// e.g. "CreateInstances".
+	StepDebug(w *Workflow, step, stepType, msg string)
+	StepInfo(w *Workflow, step, stepType, msg string)
+	// StepError logs the error the step of w of type stepType failed with.
+	StepError(w *Workflow, step, stepType string, err error)
+}
+
+type logWritesKey struct{}
+
+// logWritesContext returns the context the logs are written to GCS with.
+// It isn't canceled with ctx, so the logs are still written once the
+// workflow is canceled, and its API calls aren't logged, as logging them
+// would need more writes.
+func logWritesContext(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), logWritesKey{}, true)
+}
+
+// SetLogger sends the logs of w, and of the workflows it includes or runs,
+// to l instead of stdout. They are still written to GCS and to the writers
+// added with AddLogWriter. Secrets are redacted from the logs l receives.
+// Call SetLogger before Validate or Run.
+func (w *Workflow) SetLogger(l Logger) {
+	w.customLogger = l
+}
+
+// customLoggerOf returns the Logger set on w, or on its nearest parent.
+func (w *Workflow) customLoggerOf() Logger {
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.customLogger != nil {
+			return wf.customLogger
+		}
+	}
+	return nil
+}
+
+// logger logs for the workflow w to out, redacting secrets.
+type logger struct {
+	w   *Workflow
+	out Logger
+}
+
+func newLogger(w *Workflow, out Logger) *logger {
+	return &logger{w: w, out: out}
+}
+
+func (l *logger) redact(msg string) string {
+	if l.w == nil {
+		return msg
+	}
+	return l.w.root().redactor.redact(msg)
+}
+
+// enabled tells if logs of verbosity v are sent, as the Verbosity of the
+// top level workflow allows.
+func (l *logger) enabled(v string) bool {
+	verbosity := VerbosityInfo
+	if l.w != nil && l.w.root().Verbosity != "" {
+		verbosity = l.w.root().Verbosity
+	}
+	return indexOf(verbosities, v) >= indexOf(verbosities, verbosity)
+}
+
+func indexOf(ss []string, s string) int {
+	for i, x := range ss {
+		if x == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Print logs a message about the workflow, as log.Print.
+func (l *logger) Print(v ...interface{}) {
+	if l.enabled(VerbosityInfo) {
+		l.out.WorkflowInfo(l.w, l.redact(fmt.Sprint(v...)))
+	}
+}
+
+// Printf logs a message about the workflow, as log.Printf.
+func (l *logger) Printf(format string, v ...interface{}) {
+	if l.enabled(VerbosityInfo) {
+		l.out.WorkflowInfo(l.w, l.redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// Println logs a message about the workflow, as log.Println.
+func (l *logger) Println(v ...interface{}) {
+	if l.enabled(VerbosityInfo) {
+		l.out.WorkflowInfo(l.w, l.redact(strings.TrimSuffix(fmt.Sprintln(v...), "\n")))
+	}
+}
+
+// Debugf logs a debug message about the workflow.
+func (l *logger) Debugf(format string, v ...interface{}) {
+	if l.enabled(VerbosityDebug) {
+		l.out.WorkflowDebug(l.w, l.redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// Errorf logs an error of the workflow.
+func (l *logger) Errorf(format string, v ...interface{}) {
+	l.out.WorkflowError(l.w, l.redact(fmt.Sprintf(format, v...)))
+}
+
+// StepDebug logs a debug message about s.
+func (l *logger) StepDebug(s *Step, format string, v ...interface{}) {
+	if l.enabled(VerbosityDebug) {
+		l.out.StepDebug(l.w, s.name, s.typeName(), l.redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// StepInfo logs a message about s.
+func (l *logger) StepInfo(s *Step, format string, v ...interface{}) {
+	if l.enabled(VerbosityInfo) {
+		l.out.StepInfo(l.w, s.name, s.typeName(), l.redact(fmt.Sprintf(format, v...)))
+	}
+}
+
+// StepError logs the error s failed with.
+func (l *logger) StepError(s *Step, err error) {
+	if msg := l.redact(err.Error()); msg != err.Error() {
+		err = errors.New(msg)
+	}
+	l.out.StepError(l.w, s.name, s.typeName(), err)
+}
+
+// logEntry logs e, a log written elsewhere, e.g. by a worker, as the logs
+// of the workflow are.
+func (l *logger) logEntry(e *LogEntry) {
+	msg := l.redact(e.Message)
+	switch e.Severity {
+	case SeverityDebug:
+		if !l.enabled(VerbosityDebug) {
+			return
+		}
+		if e.Step != "" {
+			l.out.StepDebug(l.w, e.Step, e.StepType, msg)
+		} else {
+			l.out.WorkflowDebug(l.w, msg)
+		}
+	case SeverityError:
+		if e.Step != "" {
+			l.out.StepError(l.w, e.Step, e.StepType, errors.New(msg))
+		} else {
+			l.out.WorkflowError(l.w, msg)
+		}
+	default:
+		if !l.enabled(VerbosityInfo) {
+			return
+		}
+		if e.Step != "" {
+			l.out.StepInfo(l.w, e.Step, e.StepType, msg)
+		} else {
+			l.out.WorkflowInfo(l.w, msg)
+		}
+	}
+}
+
+// logSubstitutions logs the variables and autovars of w, and its steps
+// with them substituted, at VerbosityDebug.
+func (w *Workflow) logSubstitutions() {
+	if !w.logger.enabled(VerbosityDebug) {
+		return
+	}
+	vs := map[string]string{}
+	for k, v := range w.Vars {
+		vs[k] = v.Value
+	}
+	for _, k := range sortedKeys(vs) {
+		w.logger.Debugf("Var %q: %q", k, vs[k])
+	}
+	for _, k := range sortedKeys(w.autovars) {
+		w.logger.Debugf("Autovar %q: %q", k, w.autovars[k])
+	}
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	var names []string
+	for name := range w.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := json.Marshal(w.Steps[name])
+		if err != nil {
+			w.logger.Debugf("Step %q after substitution: error marshalling step: %v", name, err)
+			continue
+		}
+		w.logger.Debugf("Step %q after substitution: %s", name, b)
+	}
+}
+
+// textLogger is the default Logger, writing a line of text for each log.
+// Step logs are prefixed by the type of the step.
+type textLogger struct {
+	l *log.Logger
+}
+
+func (t *textLogger) WorkflowDebug(_ *Workflow, msg string) {
+	t.l.Print("DEBUG: " + msg)
+}
+
+func (t *textLogger) WorkflowInfo(_ *Workflow, msg string) {
+	t.l.Print(msg)
+}
+
+func (t *textLogger) WorkflowError(_ *Workflow, msg string) {
+	t.l.Print(msg)
+}
+
+func (t *textLogger) StepDebug(_ *Workflow, _, stepType, msg string) {
+	t.l.Printf("DEBUG: %s: %s", stepType, msg)
+}
+
+func (t *textLogger) StepInfo(_ *Workflow, _, stepType, msg string) {
+	t.l.Printf("%s: %s", stepType, msg)
+}
+
+func (t *textLogger) StepError(_ *Workflow, step, stepType string, err error) {
+	t.l.Printf("Step %q (%s) failed: %v", step, stepType, err)
+}
+
+// multiLogger sends the logs to each of its Loggers.
+type multiLogger []Logger
+
+func (m multiLogger) WorkflowDebug(w *Workflow, msg string) {
+	for _, l := range m {
+		l.WorkflowDebug(w, msg)
+	}
+}
+
+func (m multiLogger) WorkflowInfo(w *Workflow, msg string) {
+	for _, l := range m {
+		l.WorkflowInfo(w, msg)
+	}
+}
+
+func (m multiLogger) WorkflowError(w *Workflow, msg string) {
+	for _, l := range m {
+		l.WorkflowError(w, msg)
+	}
+}
+
+func (m multiLogger) StepDebug(w *Workflow, step, stepType, msg string) {
+	for _, l := range m {
+		l.StepDebug(w, step, stepType, msg)
+	}
+}
+
+func (m multiLogger) StepInfo(w *Workflow, step, stepType, msg string) {
+	for _, l := range m {
+		l.StepInfo(w, step, stepType, msg)
+	}
+}
+
+func (m multiLogger) StepError(w *Workflow, step, stepType string, err error) {
+	for _, l := range m {
+		l.StepError(w, step, stepType, err)
+	}
+}
+
+// LogEntry is a log written with LogFormatJSON, one JSON object per line.
+type LogEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Severity  string    `json:"severity"`
+	// The qualified name of the workflow, e.g. "parent.sub", and the ID of
+	// the run.
+	Workflow   string `json:"workflow"`
+	WorkflowID string `json:"workflowId"`
+	// Set for the logs about a step.
+	Step     string `json:"step,omitempty"`
+	StepType string `json:"stepType,omitempty"`
+	Message  string `json:"message"`
+}
+
+// jsonLogger is the Logger for LogFormatJSON, writing a LogEntry for each
+// log.
+type jsonLogger struct {
+	out io.Writer
+	mx  sync.Mutex
+}
+
+func (j *jsonLogger) write(w *Workflow, severity, step, stepType, msg string) {
+	j.writeEntry(&LogEntry{
+		Timestamp:  time.Now().UTC(),
+		Severity:   severity,
+		Workflow:   w.qualifiedName(),
+		WorkflowID: w.root().id,
+		Step:       step,
+		StepType:   stepType,
+		Message:    msg,
+	})
+}
+
+// writeEntry writes e as a line of JSON, or of text if it can't be encoded.
+func (j *jsonLogger) writeEntry(e *LogEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		msg := e.Message
+		if e.StepType != "" {
+			msg = e.StepType + ": " + msg
+		}
+		b = []byte(fmt.Sprintf("[%s]: %s %s: %s (error encoding log entry as JSON: %v)", e.Workflow, e.Timestamp.Format("2006/01/02 15:04:05"), e.Severity, msg, err))
+	}
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	j.out.Write(append(b, '\n'))
+}
+
+func (j *jsonLogger) WorkflowDebug(w *Workflow, msg string) {
+	j.write(w, SeverityDebug, "", "", msg)
+}
+
+func (j *jsonLogger) WorkflowInfo(w *Workflow, msg string) {
+	j.write(w, SeverityInfo, "", "", msg)
+}
+
+func (j *jsonLogger) WorkflowError(w *Workflow, msg string) {
+	j.write(w, SeverityError, "", "", msg)
+}
+
+func (j *jsonLogger) StepDebug(w *Workflow, step, stepType, msg string) {
+	j.write(w, SeverityDebug, step, stepType, msg)
+}
+
+func (j *jsonLogger) StepInfo(w *Workflow, step, stepType, msg string) {
+	j.write(w, SeverityInfo, step, stepType, msg)
+}
+
+func (j *jsonLogger) StepError(w *Workflow, step, stepType string, err error) {
+	j.write(w, SeverityError, step, stepType, err.Error())
+}
@@ -0,0 +1,221 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type recordingLogger struct {
+	logs []string
+}
+
+func (r *recordingLogger) WorkflowDebug(w *Workflow, msg string) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: debug: %s", w.Name, msg))
+}
+
+func (r *recordingLogger) WorkflowInfo(w *Workflow, msg string) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: %s", w.Name, msg))
+}
+
+func (r *recordingLogger) WorkflowError(w *Workflow, msg string) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: error: %s", w.Name, msg))
+}
+
+func (r *recordingLogger) StepDebug(w *Workflow, step, stepType, msg string) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: %s (%s) debug: %s", w.Name, step, stepType, msg))
+}
+
+func (r *recordingLogger) StepInfo(w *Workflow, step, stepType, msg string) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: %s (%s): %s", w.Name, step, stepType, msg))
+}
+
+func (r *recordingLogger) StepError(w *Workflow, step, stepType string, err error) {
+	r.logs = append(r.logs, fmt.Sprintf("%s: %s (%s) error: %v", w.Name, step, stepType, err))
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	rec := &recordingLogger{}
+	w := testWorkflow()
+	w.logger = nil
+	w.SetLogger(rec)
+	w.AddLogWriter(&buf)
+	w.root().redactor.add("hunter2")
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	for _, wf := range []*Workflow{w, sw} {
+		if err := wf.populateLogger(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	s := &Step{name: "s", w: sw, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+
+	w.logger.Printf("password %s", "hunter2")
+	sw.logger.StepInfo(s, "waiting for instance %q.", "i")
+	sw.logger.StepError(s, errors.New("bad password hunter2"))
+
+	want := []string{
+		"test-wf: password [REDACTED]",
+		`sub: s (WaitForInstancesSignal): waiting for instance "i".`,
+		"sub: s (WaitForInstancesSignal) error: bad password [REDACTED]",
+	}
+	if diff := pretty.Compare(rec.logs, want); diff != "" {
+		t.Errorf("logs not as expected: (-got +want)\n%s", diff)
+	}
+	// The added log writers still get the text logs.
+	for _, line := range []string{`WaitForInstancesSignal: waiting for instance "i".`, `Step "s" (WaitForInstancesSignal) failed: bad password`} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("log writer output %q doesn't contain %q", buf.String(), line)
+		}
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("log writer output %q contains a secret", buf.String())
+	}
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	var buf bytes.Buffer
+	w := testWorkflow()
+	w.logger = nil
+	w.LogFormat = LogFormatJSON
+	w.AddLogWriter(&buf)
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	for _, wf := range []*Workflow{w, sw} {
+		if err := wf.populateLogger(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	s := &Step{name: "s", w: sw, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+
+	w.logger.Print("running")
+	sw.logger.StepInfo(s, "waiting for instance %q.", "i")
+	sw.logger.StepError(s, errors.New("failed"))
+
+	var got []LogEntry
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		var e LogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("error parsing log %q: %v", line, err)
+		}
+		if e.Timestamp.IsZero() {
+			t.Errorf("log %q has no timestamp", line)
+		}
+		e.Timestamp = time.Time{}
+		got = append(got, e)
+	}
+	want := []LogEntry{
+		{Severity: SeverityInfo, Workflow: "test-wf", WorkflowID: "abcdef", Message: "running"},
+		{Severity: SeverityInfo, Workflow: "test-wf.sub", WorkflowID: "abcdef", Step: "s", StepType: "WaitForInstancesSignal", Message: `waiting for instance "i".`},
+		{Severity: SeverityError, Workflow: "test-wf.sub", WorkflowID: "abcdef", Step: "s", StepType: "WaitForInstancesSignal", Message: "failed"},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("logs not as expected: (-got +want)\n%s", diff)
+	}
+
+	// Entries that can't be encoded, like those of times JSON can't hold,
+	// are written as text.
+	buf.Reset()
+	(&jsonLogger{out: &buf}).writeEntry(&LogEntry{Timestamp: time.Date(10000, 1, 2, 3, 4, 5, 0, time.UTC), Severity: SeverityInfo, Workflow: "test-wf", StepType: "CreateDisks", Message: "msg"})
+	if want := "[test-wf]: 10000/01/02 03:04:05 INFO: CreateDisks: msg (error encoding log entry as JSON: "; !strings.HasPrefix(buf.String(), want) {
+		t.Errorf("log %q does not start with %q", buf.String(), want)
+	}
+
+	w = testWorkflow()
+	w.logger = nil
+	w.LogFormat = "xml"
+	if err := w.populateLogger(context.Background()); err == nil {
+		t.Error("expected error for unknown LogFormat")
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		verbosity string
+		want      []string
+	}{
+		{"", []string{"test-wf: info", "test-wf: s (WaitForInstancesSignal): step info", "test-wf: error: error", "test-wf: s (WaitForInstancesSignal) error: failed"}},
+		{VerbosityError, []string{"test-wf: error: error", "test-wf: s (WaitForInstancesSignal) error: failed"}},
+		{VerbosityDebug, []string{
+			`test-wf: debug: Var "v": "val"`,
+			`test-wf: debug: Step "s" after substitution: {"Timeout":"","WaitForInstancesSignal":[{"Name":"i-val","Interval":"","Stopped":false,"SerialOutput":null}]}`,
+			"test-wf: debug: debug",
+			"test-wf: s (WaitForInstancesSignal) debug: step debug",
+			"test-wf: info",
+			"test-wf: s (WaitForInstancesSignal): step info",
+			"test-wf: error: error",
+			"test-wf: s (WaitForInstancesSignal) error: failed",
+			"test-wf: debug: API call GET " + strings.TrimPrefix(ts.URL, "http://") + "/compute/v1/projects/p: 404 Not Found",
+		}},
+	}
+	for _, tt := range tests {
+		rec := &recordingLogger{}
+		w := testWorkflow()
+		w.logger = nil
+		w.autovars = nil
+		w.Verbosity = tt.verbosity
+		w.Vars = map[string]vars{"v": {Value: "val"}}
+		s := &Step{name: "s", w: w, WaitForInstancesSignal: &WaitForInstancesSignal{{Name: "i-val"}}}
+		w.Steps = map[string]*Step{"s": s}
+		w.SetLogger(rec)
+		if err := w.populateLogger(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		w.logSubstitutions()
+		w.logger.Debugf("debug")
+		w.logger.StepDebug(s, "step debug")
+		w.logger.Print("info")
+		w.logger.StepInfo(s, "step info")
+		w.logger.Errorf("error")
+		w.logger.StepError(s, errors.New("failed"))
+		hc := &http.Client{Transport: w.apiMetricsTransport(http.DefaultTransport)}
+		if _, err := hc.Get(ts.URL + "/compute/v1/projects/p"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The latency of API calls varies.
+		for i, l := range rec.logs {
+			if j := strings.LastIndex(l, " in "); strings.Contains(l, "API call") && j != -1 {
+				rec.logs[i] = l[:j]
+			}
+		}
+		if diff := pretty.Compare(rec.logs, tt.want); diff != "" {
+			t.Errorf("Verbosity %q: logs not as expected: (-got +want)\n%s", tt.verbosity, diff)
+		}
+	}
+
+	w := testWorkflow()
+	w.logger = nil
+	w.Verbosity = "loud"
+	if err := w.populateLogger(context.Background()); err == nil {
+		t.Error("expected error for unknown Verbosity")
+	}
+}
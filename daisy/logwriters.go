@@ -0,0 +1,132 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Policies for GCS logging failing when a workflow starts, see
+// Workflow.GCSLoggingPolicy.
+const (
+	// GCSLoggingFallback logs a warning and runs the workflow, its logs
+	// are only written to stdout and the writers added with AddLogWriter.
+	// RunResult.LogsFallback tells why.
+	GCSLoggingFallback = "fallback"
+	// GCSLoggingFail fails the workflow before it runs.
+	GCSLoggingFail = "fail"
+)
+
+var gcsLoggingPolicies = []string{GCSLoggingFallback, GCSLoggingFail}
+
+// startGCSLogs starts writing the logs of w to GCS when w runs. If the GCS
+// log can't be written, w fails if GCSLoggingPolicy is GCSLoggingFail, its
+// logs are only written to the other writers otherwise.
+func (w *Workflow) startGCSLogs() error {
+	gl := w.gcsLog
+	if gl == nil {
+		return nil
+	}
+	w.gcsLog = nil
+	// Creating the log object tells if it can be written at all.
+	if _, err := gl.Write(nil); err != nil {
+		err = fmt.Errorf("error writing logs to gs://%s/%s: %v", gl.bucket, gl.object, err)
+		if w.GCSLoggingPolicy == GCSLoggingFail {
+			return err
+		}
+		w.setLogsFallback(err.Error())
+		w.gcsLogWriter.start(ioutil.Discard, 0)
+		w.logger.Printf("WARNING: %v. Logs are only written to stdout and the added log writers, as GCSLoggingPolicy is %q.", err, GCSLoggingFallback)
+		return nil
+	}
+	w.gcsLogWriter.start(gl, w.gcsLogInterval)
+	return nil
+}
+
+// setLogsFallback records why the logs of w aren't written to GCS.
+func (w *Workflow) setLogsFallback(reason string) {
+	root := w.root()
+	root.logsFallbackMx.Lock()
+	defer root.logsFallbackMx.Unlock()
+	root.logsFallback = reason
+}
+
+// AddLogWriter adds out to the writers the logs of w are written to, with
+// stdout and the GCS log. Subworkflows and included workflows log to the
+// writers of their parents too. Writes to out are synchronized, but out
+// should not block as logging waits for it. Call AddLogWriter before
+// Validate or Run.
+func (w *Workflow) AddLogWriter(out io.Writer) {
+	w.logWritersMx.Lock()
+	defer w.logWritersMx.Unlock()
+	w.logWriters = append(w.logWriters, &lockedWriter{out: out})
+}
+
+// lockedWriter synchronizes writes to out, as the loggers of a workflow and
+// its subworkflows write concurrently.
+type lockedWriter struct {
+	out io.Writer
+	mx  sync.Mutex
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.out.Write(b)
+}
+
+// logWriter is a named writer of logWriters.
+type logWriter struct {
+	name string
+	io.Writer
+	failing bool
+}
+
+// logWriters writes logs to each of its writers independently. Unlike
+// io.MultiWriter, a writer that fails doesn't keep the logs from the
+// writers after it, and logWriters itself never fails. When a writer
+// starts failing, the error is logged to the others.
+type logWriters struct {
+	writers []*logWriter
+	mx      sync.Mutex
+}
+
+func (l *logWriters) add(name string, w io.Writer) {
+	l.writers = append(l.writers, &logWriter{name: name, Writer: w})
+}
+
+func (l *logWriters) Write(b []byte) (int, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	var errs []string
+	for _, w := range l.writers {
+		_, err := w.Write(b)
+		if err != nil && !w.failing {
+			errs = append(errs, fmt.Sprintf("Error writing logs to %s, logs may be missing from it until it recovers: %v\n", w.name, err))
+		}
+		w.failing = err != nil
+	}
+	for _, e := range errs {
+		for _, w := range l.writers {
+			if !w.failing {
+				w.Write([]byte(e))
+			}
+		}
+	}
+	return len(b), nil
+}
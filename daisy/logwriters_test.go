@@ -0,0 +1,242 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+type failingWriter struct {
+	fail bool
+	n    int
+}
+
+func (f *failingWriter) Write(b []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("unavailable")
+	}
+	f.n++
+	return len(b), nil
+}
+
+func TestLogWritersIsolation(t *testing.T) {
+	var buf bytes.Buffer
+	bad := &failingWriter{fail: true}
+	lw := &logWriters{}
+	lw.add("bad", bad)
+	lw.add("buf", &buf)
+
+	for _, line := range []string{"one\n", "two\n"} {
+		if n, err := lw.Write([]byte(line)); err != nil || n != len(line) {
+			t.Errorf("unexpected result of Write, got: %d, %v", n, err)
+		}
+	}
+	want := "one\nError writing logs to bad, logs may be missing from it until it recovers: unavailable\ntwo\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected logs, got: %q, want: %q", got, want)
+	}
+
+	// A recovered writer gets logs again, and its next failure is logged.
+	bad.fail = false
+	lw.Write([]byte("three\n"))
+	bad.fail = true
+	lw.Write([]byte("four\n"))
+	if bad.n != 1 {
+		t.Errorf("recovered writer got %d writes, want 1", bad.n)
+	}
+	if got := strings.Count(buf.String(), "Error writing logs to bad"); got != 2 {
+		t.Errorf("writer failures were logged %d times, want 2", got)
+	}
+}
+
+func TestAddLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := testWorkflow()
+	w.logger = nil
+	w.AddLogWriter(&buf)
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	if err := w.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sw.gcsLogWriter = w.gcsLogWriter
+	if err := sw.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.logger.Print("parent")
+	sw.logger.Print("child")
+	if got := buf.String(); !strings.Contains(got, "parent") || !strings.Contains(got, "child") {
+		t.Errorf("logs of the workflow and its subworkflow were not written to the added writer, got: %q", got)
+	}
+}
+
+func TestSyncedWriterDoesNotBlock(t *testing.T) {
+	out := &blockingWriter{release: make(chan struct{})}
+	l := &syncedWriter{out: out, size: 1}
+	l.flushEvery(time.Hour)
+	defer func() {
+		close(out.release)
+		l.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		// The first write starts a flush which blocks, the others buffer.
+		for i := 0; i < 10; i++ {
+			l.Write([]byte("log\n"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes blocked on a blocked flush")
+	}
+}
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+func TestGCSLoggingPolicy(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+	failing, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	working, err := newTestGCSClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc         string
+		policy       string
+		client       *storage.Client
+		shouldErr    bool
+		wantFallback bool
+	}{
+		{"working case", "", working, false, false},
+		{"default policy case", "", failing, false, true},
+		{"fallback case", GCSLoggingFallback, failing, false, true},
+		{"fail case", GCSLoggingFail, failing, true, false},
+		{"bad policy case", "bad", working, true, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.logger = nil
+		w.gcsLogging = true
+		w.bucket = "bucket"
+		w.StorageClient = tt.client
+		w.GCSLoggingPolicy = tt.policy
+
+		atomic.StoreInt32(&requests, 0)
+		err := w.populateLogger(context.Background())
+		// The GCS log is only written to once the workflow runs.
+		if n := atomic.LoadInt32(&requests); n != 0 {
+			t.Errorf("%s: GCS requests before the workflow runs: %d", tt.desc, n)
+		}
+		if err == nil {
+			err = w.startGCSLogs()
+		}
+		if err != nil {
+			if !tt.shouldErr {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		w.closeLogs()
+		if tt.shouldErr {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+		if got := w.Result().LogsFallback; (got != "") != tt.wantFallback {
+			t.Errorf("%s: unexpected LogsFallback: %q", tt.desc, got)
+		}
+	}
+}
+
+func TestGCSLogsAfterScratchPathClaim(t *testing.T) {
+	var writes int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Query().Get("ifGenerationMatch") == "0":
+			// The scratch path is claimed by another run.
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case r.Method == "POST":
+			atomic.AddInt32(&writes, 1)
+			w.Write([]byte(`{"bucket":"bucket","name":"object"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := testWorkflow()
+	w.logger = nil
+	w.StorageClient = client
+	w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{}}
+	if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "in use by another run") {
+		t.Errorf("expected error claiming the scratch path, got: %v", err)
+	}
+	// Neither the logs nor the audit overwrite those of the other run.
+	if n := atomic.LoadInt32(&writes); n != 0 {
+		t.Errorf("objects written to the scratch path of another run: %d", n)
+	}
+}
+
+func TestSyncedWriterStartRace(t *testing.T) {
+	l := &syncedWriter{size: 1}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.Write([]byte("log\n"))
+		}
+	}()
+	l.start(ioutil.Discard, time.Hour)
+	wg.Wait()
+	if err := l.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// MachineSchemaVersion is the version of the MachineRequest and
+// MachineResult schemas. Fields are only ever added within a version.
+const MachineSchemaVersion = 1
+
+// The statuses of a MachineResult.
+const (
+	// MachineSucceeded is the status of workflows that ran, or validated,
+	// successfully.
+	MachineSucceeded = "succeeded"
+	// MachineFailed is the status of workflows that failed or were
+	// canceled while running.
+	MachineFailed = "failed"
+	// MachineInvalid is the status of requests that could not be read and
+	// of workflows that failed validation.
+	MachineInvalid = "invalid"
+)
+
+// MachineRequest describes a workflow run requested by another tool, e.g.
+// an infrastructure as code tool, see RunMachine.
+type MachineRequest struct {
+	// Workflow is the workflow config, as in a workflow file.
+	Workflow json.RawMessage `json:",omitempty"`
+	// WorkflowPath is the path of a workflow file, used if Workflow is not
+	// set.
+	WorkflowPath string `json:",omitempty"`
+	// WorkflowDir is the directory relative paths in Workflow are relative
+	// to, the working directory if not set.
+	WorkflowDir string `json:",omitempty"`
+	// Vars set workflow variables.
+	Vars map[string]string `json:",omitempty"`
+	// Project, Zone, GCSPath and OAuthPath override those of the workflow.
+	Project   string `json:",omitempty"`
+	Zone      string `json:",omitempty"`
+	GCSPath   string `json:",omitempty"`
+	OAuthPath string `json:",omitempty"`
+	// ValidateOnly only validates the workflow, it doesn't run.
+	ValidateOnly bool `json:",omitempty"`
+}
+
+// MachineResult is the outcome of a MachineRequest. Lists and Outputs are
+// empty, not null, if there is nothing to list.
+type MachineResult struct {
+	SchemaVersion int
+	// Status is one of MachineSucceeded, MachineFailed and MachineInvalid.
+	Status string
+	// Error describes why the request is invalid or the workflow failed.
+	Error string `json:",omitempty"`
+	// Workflow is the name of the workflow.
+	Workflow string `json:",omitempty"`
+	// ID is the ID of the run, as in the ${ID} autovar.
+	ID string `json:",omitempty"`
+	// As in RunResult.
+	CompletedSteps []string
+	FailedSteps    []*StepFailure
+	Resources      []*CreatedResource
+	Cleanup        *CleanupReport `json:",omitempty"`
+	Steps          []*StepResult
+	InstanceHours  float64 `json:",omitempty"`
+	LogsPath       string  `json:",omitempty"`
+	OutsPath       string  `json:",omitempty"`
+	Outputs        map[string]string
+}
+
+// ExitCode returns the exit code for a process reporting r: 0 if the
+// workflow succeeded, 1 if it failed and 2 if the request is invalid.
+func (r *MachineResult) ExitCode() int {
+	switch r.Status {
+	case MachineSucceeded:
+		return 0
+	case MachineFailed:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// InvalidMachineResult returns the result of a request that is invalid as
+// of err, e.g. as it could not be read.
+func InvalidMachineResult(err error) *MachineResult {
+	res := &MachineResult{SchemaVersion: MachineSchemaVersion, Status: MachineInvalid, Error: err.Error()}
+	res.fill(nil)
+	return res
+}
+
+func (req *MachineRequest) workflow() (*Workflow, error) {
+	var w *Workflow
+	var err error
+	switch {
+	case len(req.Workflow) != 0:
+		dir := req.WorkflowDir
+		if dir == "" {
+			if dir, err = os.Getwd(); err != nil {
+				return nil, err
+			}
+		}
+		w, err = NewFromReader(bytes.NewReader(req.Workflow), dir)
+	case req.WorkflowPath != "":
+		w, err = NewFromFile(req.WorkflowPath)
+	default:
+		return nil, errors.New("request has neither Workflow nor WorkflowPath")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Vars {
+		w.AddVar(k, v)
+	}
+	w.Project = strOr(req.Project, w.Project)
+	w.Zone = strOr(req.Zone, w.Zone)
+	w.GCSPath = strOr(req.GCSPath, w.GCSPath)
+	w.OAuthPath = strOr(req.OAuthPath, w.OAuthPath)
+	return w, nil
+}
+
+// RunMachine validates and runs the workflow of req, or only validates it
+// if req.ValidateOnly is set. Errors are reported in the result.
+func RunMachine(ctx context.Context, req *MachineRequest) *MachineResult {
+	res := &MachineResult{SchemaVersion: MachineSchemaVersion}
+	w, err := req.workflow()
+	if err == nil {
+		res.Workflow = w.Name
+		w.gcsLogging = !req.ValidateOnly
+		defer w.closeLogs()
+		err = w.Validate(ctx)
+	}
+	if err != nil {
+		invalid := InvalidMachineResult(err)
+		invalid.Workflow = res.Workflow
+		return invalid
+	}
+	res.ID = w.id
+	if req.ValidateOnly {
+		res.Status = MachineSucceeded
+		res.fill(nil)
+		return res
+	}
+
+	err = w.runValidated(ctx)
+	rr := w.Result()
+	switch {
+	case err != nil:
+		res.Status = MachineFailed
+		res.Error = err.Error()
+	case rr.Canceled:
+		res.Status = MachineFailed
+		res.Error = "workflow canceled: " + rr.CancelReason
+	default:
+		res.Status = MachineSucceeded
+	}
+	res.fill(rr)
+	return res
+}
+
+// fill copies the lists of rr, if any, to r, with empty lists as such.
+func (r *MachineResult) fill(rr *RunResult) {
+	if rr != nil {
+		r.CompletedSteps = rr.CompletedSteps
+		r.FailedSteps = rr.FailedSteps
+		r.Resources = rr.Resources
+		r.Cleanup = rr.Cleanup
+		r.Steps = rr.Steps
+		r.InstanceHours = rr.InstanceHours
+		r.LogsPath = rr.LogsPath
+		r.OutsPath = rr.OutsPath
+		r.Outputs = rr.Outputs
+	}
+	if r.CompletedSteps == nil {
+		r.CompletedSteps = []string{}
+	}
+	if r.FailedSteps == nil {
+		r.FailedSteps = []*StepFailure{}
+	}
+	if r.Resources == nil {
+		r.Resources = []*CreatedResource{}
+	}
+	if r.Steps == nil {
+		r.Steps = []*StepResult{}
+	}
+	if r.Outputs == nil {
+		r.Outputs = map[string]string{}
+	}
+}
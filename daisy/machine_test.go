@@ -0,0 +1,63 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunMachineInvalid(t *testing.T) {
+	tests := []struct {
+		desc    string
+		req     *MachineRequest
+		wantErr string
+	}{
+		{"no workflow case", &MachineRequest{}, "request has neither Workflow nor WorkflowPath"},
+		{"bad workflow case", &MachineRequest{Workflow: json.RawMessage(`{"Name": 1}`)}, "cannot unmarshal number"},
+		{"missing file case", &MachineRequest{WorkflowPath: "./test_data/dne.wf.json"}, "no such file or directory"},
+	}
+	for _, tt := range tests {
+		res := RunMachine(context.Background(), tt.req)
+		if res.Status != MachineInvalid || !strings.Contains(res.Error, tt.wantErr) {
+			t.Errorf("%s: unexpected result, got: %s %q, want: %s containing %q", tt.desc, res.Status, res.Error, MachineInvalid, tt.wantErr)
+		}
+		if res.ExitCode() != 2 {
+			t.Errorf("%s: unexpected exit code, got: %d, want: 2", tt.desc, res.ExitCode())
+		}
+	}
+}
+
+func TestMachineResultJSON(t *testing.T) {
+	b, err := json.Marshal(InvalidMachineResult(errors.New("bad")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"SchemaVersion":1,"Status":"invalid","Error":"bad","CompletedSteps":[],"FailedSteps":[],"Resources":[],"Steps":[],"Outputs":{}}`
+	if string(b) != want {
+		t.Errorf("unexpected result JSON, got: %s, want: %s", b, want)
+	}
+}
+
+func TestMachineResultExitCode(t *testing.T) {
+	for status, want := range map[string]int{MachineSucceeded: 0, MachineFailed: 1, MachineInvalid: 2} {
+		if got := (&MachineResult{Status: status}).ExitCode(); got != want {
+			t.Errorf("unexpected exit code for status %q, got: %d, want: %d", status, got, want)
+		}
+	}
+}
@@ -17,28 +17,48 @@ package daisy
 import (
 	"fmt"
 	"regexp"
-	"sync"
 
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
 var machineTypeURLRegex = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[1]s)/machineTypes/(?P<machinetype>%[1]s)$`, rfc1035))
 
-var machineTypes struct {
-	valid []string
-	mu    sync.Mutex
-}
+var machineTypes = &catalogCache{}
 
 func checkMachineType(client compute.Client, project, zone, machineType string) error {
-	machineTypes.mu.Lock()
-	defer machineTypes.mu.Unlock()
 	url := fmt.Sprintf("/project/%s/zone/%s/machinetype/%s", project, zone, machineType)
-	if strIn(url, machineTypes.valid) {
-		return nil
-	}
-	if _, err := client.GetMachineType(project, zone, machineType); err != nil {
+	return machineTypes.check(client, url, func() error {
+		_, err := client.GetMachineType(project, zone, machineType)
 		return err
-	}
-	machineTypes.valid = append(machineTypes.valid, url)
-	return nil
+	})
+}
+
+// cpuPlatformAutomatic lets GCE pick the CPU platform.
+const cpuPlatformAutomatic = "Automatic"
+
+var cpuPlatforms = &catalogCache{}
+
+// checkMinCPUPlatform checks that platform is available in the zone and
+// that machineType supports selecting a minimum CPU platform.
+func checkMinCPUPlatform(client compute.Client, project, zone, machineType, platform string) error {
+	url := fmt.Sprintf("/project/%s/zone/%s/machinetype/%s/cpuplatform/%s", project, zone, machineType, platform)
+	return cpuPlatforms.check(client, url, func() error {
+		mt, err := client.GetMachineType(project, zone, machineType)
+		if err != nil {
+			return err
+		}
+		if mt.IsSharedCpu {
+			return fmt.Errorf("shared-core machine type %q does not support a minimum CPU platform", machineType)
+		}
+		if platform != cpuPlatformAutomatic {
+			z, err := client.GetZone(project, zone)
+			if err != nil {
+				return err
+			}
+			if !strIn(platform, z.AvailableCpuPlatforms) {
+				return fmt.Errorf("CPU platform %q is not available in zone %q, available platforms: %q", platform, zone, z.AvailableCpuPlatforms)
+			}
+		}
+		return nil
+	})
 }
@@ -0,0 +1,245 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// labelManifestHash labels the images created by a workflow with
+// HashManifest set with its manifest hash.
+const labelManifestHash = "daisy-manifest-hash"
+
+// manifest is the canonical form of the inputs of a workflow, its manifest
+// hash is the SHA-224 of its JSON, which fits in a label value.
+type manifest struct {
+	Name string
+	// Vars are the values of the vars, secrets are identified by version.
+	Vars map[string]string `json:",omitempty"`
+	// Steps are the steps before substitution.
+	Steps        json.RawMessage     `json:",omitempty"`
+	Dependencies map[string][]string `json:",omitempty"`
+	// Workflows are the manifests of the included and sub workflows, by
+	// the name of their step.
+	Workflows map[string]*manifest `json:",omitempty"`
+	// Sources are the digests of the contents of the sources, those of sub
+	// workflows prefixed by the name of their step. Set on the top level
+	// manifest only.
+	Sources map[string]string `json:",omitempty"`
+	// Images are the IDs of the existing images the steps use, by URL. Set
+	// on the top level manifest only.
+	Images map[string]string `json:",omitempty"`
+}
+
+// newManifest returns the manifest of w and the workflows it includes or
+// runs, as they are before substitution.
+func (w *Workflow) newManifest() (*manifest, error) {
+	m := &manifest{Name: w.Name, Vars: map[string]string{}, Dependencies: w.Dependencies, Workflows: map[string]*manifest{}}
+	for k, v := range w.Vars {
+		m.Vars[k] = v.Value
+		if v.ValueFromSecret != "" {
+			m.Vars[k] = v.ValueFromSecret
+		} else if ev, ok := os.LookupEnv(v.ValueFromEnv); ok && v.ValueFromEnv != "" {
+			m.Vars[k] = ev
+		}
+	}
+	var err error
+	if m.Steps, err = json.Marshal(w.Steps); err != nil {
+		return nil, err
+	}
+	for name, s := range w.Steps {
+		var cw *Workflow
+		switch {
+		case s.IncludeWorkflow != nil:
+			cw = s.IncludeWorkflow.w
+		case s.SubWorkflow != nil:
+			cw = s.SubWorkflow.w
+		}
+		if cw == nil {
+			continue
+		}
+		if m.Workflows[name], err = cw.newManifest(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// populateManifestHash adds the digests of the sources and the IDs of the
+// existing images used to the manifest taken by populate, and sets the
+// manifest hash of w from it.
+func (w *Workflow) populateManifestHash(ctx context.Context) error {
+	m := w.manifest
+	m.Sources = map[string]string{}
+	if err := w.sourceDigests(ctx, "", m.Sources); err != nil {
+		return err
+	}
+	m.Images = map[string]string{}
+	if err := w.imageIDs(m.Images); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	w.manifestHash = fmt.Sprintf("%x", sha256.Sum224(b))
+	return nil
+}
+
+// sourceDigests adds the digests of the Sources of w, and of its sub
+// workflows, to digests with their names prefixed by prefix.
+func (w *Workflow) sourceDigests(ctx context.Context, prefix string, digests map[string]string) error {
+	for name, p := range w.Sources {
+		if p == "" {
+			continue
+		}
+		d, err := w.sourceDigest(ctx, p)
+		if err != nil {
+			return fmt.Errorf("error hashing source %q: %v", name, err)
+		}
+		digests[prefix+name] = d
+	}
+	for name, s := range w.Steps {
+		if s.SubWorkflow != nil && s.SubWorkflow.w != nil {
+			if err := s.SubWorkflow.w.sourceDigests(ctx, prefix+name+"/", digests); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sourceDigest returns a digest of the contents of the source at p, a GCS
+// object or directory, or a local file or directory. GCS objects are hashed
+// by the checksums GCS keeps.
+func (w *Workflow) sourceDigest(ctx context.Context, p string) (string, error) {
+	h := sha256.New()
+	if bkt, objPath, err := splitGCSPath(p); err == nil {
+		if objPath != "" && !strings.HasSuffix(objPath, "/") {
+			attrs, err := w.StorageClient.Bucket(bkt).Object(objPath).Attrs(ctx)
+			if err != nil {
+				return "", err
+			}
+			writeObjectDigest(h, "", attrs)
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+		it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: objPath})
+		for attrs, err := it.Next(); err != iterator.Done; attrs, err = it.Next() {
+			if err != nil {
+				return "", err
+			}
+			writeObjectDigest(h, strings.TrimPrefix(attrs.Name, objPath), attrs)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(w.workflowDir, p)
+	}
+	err := filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%s\n", filepath.ToSlash(strings.TrimPrefix(file, filepath.Clean(p))))
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeObjectDigest writes the name and checksum of a GCS object to h, its
+// MD5 if it has one, composite objects only have a CRC32C.
+func writeObjectDigest(h io.Writer, name string, attrs *storage.ObjectAttrs) {
+	if len(attrs.MD5) > 0 {
+		fmt.Fprintf(h, "%s md5:%x\n", name, attrs.MD5)
+		return
+	}
+	fmt.Fprintf(h, "%s crc32c:%08x\n", name, attrs.CRC32C)
+}
+
+// imageIDs adds the IDs of the existing images the steps of w, and of the
+// workflows it includes or runs, use to ids, by URL. Images a family URL
+// refers to are resolved to the current image of the family. Images which
+// don't exist yet are left out, they are created by the workflow.
+func (w *Workflow) imageIDs(ids map[string]string) error {
+	for _, s := range w.Steps {
+		var err error
+		traverseStepData(s, func(v reflect.Value) error {
+			str, ok := v.Interface().(string)
+			if !ok || err != nil || !strings.HasPrefix(str, "projects/") || !imageURLRgx.MatchString(str) {
+				return nil
+			}
+			if _, ok := ids[str]; ok {
+				return nil
+			}
+			m := namedSubexp(imageURLRgx, str)
+			get := func() (*compute.Image, error) { return w.ComputeClient.GetImage(m["project"], m["image"]) }
+			if m["family"] != "" {
+				get = func() (*compute.Image, error) { return w.ComputeClient.GetImageFromFamily(m["project"], m["family"]) }
+			}
+			img, getErr := get()
+			if apiErr, ok := getErr.(*googleapi.Error); ok && apiErr.Code == 404 {
+				return nil
+			}
+			if getErr != nil {
+				err = fmt.Errorf("error getting image %q: %v", str, getErr)
+				return nil
+			}
+			ids[str] = fmt.Sprint(img.Id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var cw *Workflow
+		switch {
+		case s.IncludeWorkflow != nil:
+			cw = s.IncludeWorkflow.w
+		case s.SubWorkflow != nil:
+			cw = s.SubWorkflow.w
+		case s.ForEach != nil:
+			cw = s.ForEach.w
+		}
+		if cw != nil {
+			if err := cw.imageIDs(ids); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestManifestHash(t *testing.T) {
+	ctx := context.Background()
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+
+	hash := func(v, src string, imageID uint64) string {
+		if err := ioutil.WriteFile(filepath.Join(td, "script.sh"), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+		w := testWorkflow()
+		w.HashManifest = true
+		w.workflowDir = td
+		w.Sources = map[string]string{"script": "script.sh"}
+		w.Vars = map[string]vars{"size": {Value: v}}
+		w.Steps = map[string]*Step{
+			"create-disk": {CreateDisks: &CreateDisks{{Disk: compute.Disk{Name: "d-${ID}", SourceImage: "projects/p/global/images/family/f"}, SizeGb: "${size}"}}},
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).GetImageFromFamilyFn = func(p, f string) (*compute.Image, error) {
+			return &compute.Image{Name: "i", Id: imageID}, nil
+		}
+		if err := w.populate(ctx); err != nil {
+			t.Fatalf("error populating workflow: %v", err)
+		}
+		if err := w.populateManifestHash(ctx); err != nil {
+			t.Fatalf("error hashing manifest: %v", err)
+		}
+		if len(w.manifestHash) != 56 {
+			t.Errorf("manifest hash %q is not a SHA-224", w.manifestHash)
+		}
+		return w.manifestHash
+	}
+
+	want := hash("10", "echo hello", 1)
+	if got := hash("10", "echo hello", 1); got != want {
+		t.Errorf("runs with the same inputs have different hashes: %q, %q", got, want)
+	}
+	for _, tt := range []struct {
+		desc    string
+		v, src  string
+		imageID uint64
+	}{
+		{"var changed", "20", "echo hello", 1},
+		{"source changed", "10", "echo bye", 1},
+		{"image changed", "10", "echo hello", 2},
+	} {
+		if got := hash(tt.v, tt.src, tt.imageID); got == want {
+			t.Errorf("%s: hash didn't change", tt.desc)
+		}
+	}
+}
+
+func TestCreateImagesManifestHashLabel(t *testing.T) {
+	w := testWorkflow()
+	w.manifestHash = "abc"
+	var got map[string]string
+	w.ComputeClient.(*daisyCompute.TestClient).CreateImageFn = func(_ string, i *compute.Image) error {
+		got = i.Labels
+		return nil
+	}
+	ci := &CreateImages{{daisyName: "i", Image: compute.Image{Name: "i", Labels: map[string]string{"foo": "bar"}}}}
+	if err := ci.run(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got[labelManifestHash] != "abc" || got["foo"] != "bar" {
+		t.Errorf("unexpected image labels: %v", got)
+	}
+}
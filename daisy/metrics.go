@@ -0,0 +1,293 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stepDurationBuckets are the upper bounds, in seconds, of the buckets of
+// the daisy_step_duration_seconds histogram.
+var stepDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+// Metrics collects metrics of the workflows it is set on, see
+// Workflow.Metrics, and serves them over HTTP in the Prometheus text
+// format. One Metrics can be shared by all the workflows a service runs.
+// The metrics are:
+//
+//	daisy_workflow_runs_total{outcome}       counter of runs, by "succeeded", "failed" or "canceled"
+//	daisy_steps_total{type,state}            counter of steps run, by type and StepState
+//	daisy_step_duration_seconds{type}        histogram of step durations, by type
+//	daisy_api_errors_total{reason}           counter of failed API calls, by error reason
+//	daisy_resources_created_total{type}      counter of resources created, by type, e.g. "disk"
+type Metrics struct {
+	mx           sync.Mutex
+	runs         *counterVec
+	steps        *counterVec
+	stepDuration *histogramVec
+	apiErrors    *counterVec
+	resources    *counterVec
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		runs:         newCounterVec("daisy_workflow_runs_total", "Workflow runs, by outcome.", "outcome"),
+		steps:        newCounterVec("daisy_steps_total", "Steps run, by type and final state.", "type", "state"),
+		stepDuration: newHistogramVec("daisy_step_duration_seconds", "How long steps ran, by type.", stepDurationBuckets, "type"),
+		apiErrors:    newCounterVec("daisy_api_errors_total", "Failed API calls, by error reason.", "reason"),
+		resources:    newCounterVec("daisy_resources_created_total", "Resources created, by type.", "type"),
+	}
+}
+
+// metrics returns the Metrics set on w, or on its nearest parent.
+func (w *Workflow) metrics() *Metrics {
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.Metrics != nil {
+			return wf.Metrics
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) countRun(outcome string) {
+	if m == nil {
+		return
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.runs.add(1, outcome)
+}
+
+func (m *Metrics) observeStep(sr *StepResult) {
+	if m == nil {
+		return
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.steps.add(1, sr.Type, sr.State.String())
+	m.stepDuration.observe(sr.Duration.Seconds(), sr.Type)
+}
+
+func (m *Metrics) countAPIError(reason string) {
+	if m == nil {
+		return
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.apiErrors.add(1, reason)
+}
+
+func (m *Metrics) countResourceCreated(typ string) {
+	if m == nil {
+		return
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.resources.add(1, typ)
+}
+
+// apiErrorCounts returns the failed API calls counted, by reason.
+func (m *Metrics) apiErrorCounts() map[string]int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	counts := map[string]int{}
+	for reason, v := range m.apiErrors.values {
+		counts[reason] = int(v)
+	}
+	return counts
+}
+
+// countAPIErrors counts the failed API calls of counts, by reason, e.g.
+// made by a worker.
+func (m *Metrics) countAPIErrors(counts map[string]int) {
+	if m == nil {
+		return
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for reason, n := range counts {
+		m.apiErrors.add(float64(n), reason)
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to out in the Prometheus text format.
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	var b bytes.Buffer
+	m.mx.Lock()
+	m.runs.write(&b)
+	m.steps.write(&b)
+	m.stepDuration.write(&b)
+	m.apiErrors.write(&b)
+	m.resources.write(&b)
+	m.mx.Unlock()
+	return b.WriteTo(out)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels formats names and the values joined in key as Prometheus
+// labels, with extra appended, e.g. `{type="disk",le="1"}`.
+func formatLabels(names []string, key string, extra ...string) string {
+	var values []string
+	if len(names) > 0 {
+		values = strings.Split(key, "\xff")
+	}
+	var ls []string
+	for i, n := range names {
+		ls = append(ls, fmt.Sprintf("%s=\"%s\"", n, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		ls = append(ls, fmt.Sprintf("%s=\"%s\"", extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	if len(ls) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(ls, ",") + "}"
+}
+
+func sortedLabelKeys(m map[string]float64) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type counterVec struct {
+	name, help string
+	labels     []string
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(v float64, labelValues ...string) {
+	c.values[labelKey(labelValues)] += v
+}
+
+func (c *counterVec) write(out io.Writer) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range sortedLabelKeys(c.values) {
+		fmt.Fprintf(out, "%s%s %s\n", c.name, formatLabels(c.labels, k), formatFloat(c.values[k]))
+	}
+}
+
+type histogram struct {
+	// counts[i] is the number of observations <= buckets[i].
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labels     []string
+	values     map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labels: labels, values: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	k := labelKey(labelValues)
+	hist, ok := h.values[k]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hist
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+func (h *histogramVec) write(out io.Writer) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var keys []string
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hist := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, "le", formatFloat(b)), hist.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, k, "le", "+Inf"), hist.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", h.name, formatLabels(h.labels, k), formatFloat(hist.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", h.name, formatLabels(h.labels, k), hist.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// apiErrorReason returns the reason of a failed API call for metrics: the
+// reason of the API error in resp, e.g. "notFound", or its HTTP status if
+// it has none, or "transport" if the call got no response. The body of
+// resp is read and replaced.
+func apiErrorReason(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "transport"
+	}
+	if body, err := readBody(resp); err == nil {
+		var e struct {
+			Error struct {
+				Errors []struct {
+					Reason string `json:"reason"`
+				} `json:"errors"`
+				Status string `json:"status"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil {
+			if len(e.Error.Errors) > 0 && e.Error.Errors[0].Reason != "" {
+				return e.Error.Errors[0].Reason
+			}
+			if e.Error.Status != "" {
+				return e.Error.Status
+			}
+		}
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	for _, run := range []func(context.Context, *Step) error{
+		func(context.Context, *Step) error { return nil },
+		func(context.Context, *Step) error { return errors.New("fail") },
+	} {
+		w := testWorkflow()
+		w.Metrics = m
+		w.Steps = map[string]*Step{"s": {testType: &mockStep{runImpl: run}}}
+		w.Run(context.Background())
+	}
+	m.countResourceCreated("disk")
+	m.countAPIError("notFound")
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+
+	for _, want := range []string{
+		"# TYPE daisy_workflow_runs_total counter\n",
+		"daisy_workflow_runs_total{outcome=\"failed\"} 1\n",
+		"daisy_workflow_runs_total{outcome=\"succeeded\"} 1\n",
+		"daisy_steps_total{type=\"mockStep\",state=\"failed\"} 1\n",
+		"daisy_steps_total{type=\"mockStep\",state=\"finished\"} 1\n",
+		"# TYPE daisy_step_duration_seconds histogram\n",
+		"daisy_step_duration_seconds_bucket{type=\"mockStep\",le=\"1\"} 2\n",
+		"daisy_step_duration_seconds_bucket{type=\"mockStep\",le=\"+Inf\"} 2\n",
+		"daisy_step_duration_seconds_count{type=\"mockStep\"} 2\n",
+		"daisy_api_errors_total{reason=\"notFound\"} 1\n",
+		"daisy_resources_created_total{type=\"disk\"} 1\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestAPIErrorReason(t *testing.T) {
+	tests := []struct {
+		desc string
+		resp *http.Response
+		err  error
+		want string
+	}{
+		{"transport error", nil, errors.New("fail"), "transport"},
+		{"error reason", &http.Response{StatusCode: 404, Body: ioutil.NopCloser(strings.NewReader(`{"error":{"errors":[{"reason":"notFound"}],"status":"NOT_FOUND"}}`))}, nil, "notFound"},
+		{"error status", &http.Response{StatusCode: 404, Body: ioutil.NopCloser(strings.NewReader(`{"error":{"status":"NOT_FOUND"}}`))}, nil, "NOT_FOUND"},
+		{"no error body", &http.Response{StatusCode: 503, Body: ioutil.NopCloser(strings.NewReader("unavailable"))}, nil, "503"},
+	}
+	for _, tt := range tests {
+		if got := apiErrorReason(tt.resp, tt.err); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+		if tt.resp != nil {
+			if b, _ := ioutil.ReadAll(tt.resp.Body); len(b) == 0 {
+				t.Errorf("%s: response body not restored", tt.desc)
+			}
+		}
+	}
+}
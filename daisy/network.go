@@ -19,4 +19,54 @@ import (
 	"regexp"
 )
 
-var networkURLRegex = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/networks/(?P<network>%[1]s)$`, rfc1035))
+var (
+	networks           = map[*Workflow]*networkMap{}
+	networkURLRegex    = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/networks/(?P<network>%[1]s)$`, rfc1035))
+	subnetworkURLRegex = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?regions/(?P<region>%[1]s)/subnetworks/(?P<subnetwork>%[1]s)$`, rfc1035))
+)
+
+type networkMap struct {
+	baseResourceMap
+}
+
+func initNetworkMap(w *Workflow) {
+	nm := &networkMap{baseResourceMap: baseResourceMap{w: w, typeName: "network", urlRgx: networkURLRegex}}
+	nm.baseResourceMap.deleteFn = nm.deleteFn
+	nm.init()
+	networks[w] = nm
+}
+
+// createsNetwork reports whether name is the name of a network created by a
+// CreateNetworks step of w, or of the workflows w shares its resources with.
+func (w *Workflow) createsNetwork(name string) bool {
+	for w.parent != nil && networks[w.parent] == networks[w] {
+		w = w.parent
+	}
+	return w.declaresNetwork(name)
+}
+
+// declaresNetwork reports whether a CreateNetworks step of w or of its
+// included workflows creates the network name.
+func (w *Workflow) declaresNetwork(name string) bool {
+	for _, s := range w.Steps {
+		if s.CreateNetworks != nil {
+			for _, cn := range *s.CreateNetworks {
+				if strOr(cn.daisyName, cn.Name) == name {
+					return true
+				}
+			}
+		}
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil && s.IncludeWorkflow.w.declaresNetwork(name) {
+			return true
+		}
+		if s.ForEach != nil && s.ForEach.w != nil && s.ForEach.w.declaresNetwork(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (nm *networkMap) deleteFn(r *resource) error {
+	m := namedSubexp(networkURLRegex, r.link)
+	return nm.w.ComputeClient.DeleteNetwork(m["project"], m["network"])
+}
@@ -0,0 +1,154 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// outputsFile is the name of the object in the outs path the Outputs of a
+// successful run are written to.
+const outputsFile = "outputs.json"
+
+// parseOutputs parses the Outputs templates of w. Templates can use the
+// functions of TemplatedFile templates.
+func (w *Workflow) parseOutputs() error {
+	var errs Errors
+	w.outputTmpls = map[string]*template.Template{}
+	for _, name := range sortedKeys(w.Outputs) {
+		tmpl, err := template.New(name).Funcs(templateFuncs(w)).Parse(w.Outputs[name])
+		if err != nil {
+			errs.add(Errorf("bad output %q: %v", name, err))
+			continue
+		}
+		w.outputTmpls[name] = tmpl
+	}
+	return errs.cast()
+}
+
+// Well-known outputs, set for the resources of these types that are kept
+// after a run, unless the workflow has Outputs of the same names.
+const (
+	ImageURIOutput          = "image_uri"
+	DiskURIOutput           = "disk_uri"
+	InstanceSelfLinksOutput = "instance_selflinks"
+)
+
+// computeURLPrefix turns the partial URL of a resource into its URL.
+const computeURLPrefix = "https://www.googleapis.com/compute/v1/"
+
+// wellKnownOutputs returns the well-known outputs of w: the sorted, comma
+// separated URLs of the images, disks and instances created by w and its
+// subworkflows that are kept after the run.
+func (w *Workflow) wellKnownOutputs() map[string]string {
+	links := map[string]map[string]bool{}
+	var walk func(*Workflow)
+	add := func(name string, ls []string) {
+		for _, l := range ls {
+			if links[name] == nil {
+				links[name] = map[string]bool{}
+			}
+			links[name][computeURLPrefix+l] = true
+		}
+	}
+	walk = func(wf *Workflow) {
+		if im := images[wf]; im != nil {
+			add(ImageURIOutput, im.keptLinks())
+		}
+		if dm := disks[wf]; dm != nil {
+			add(DiskURIOutput, dm.keptLinks())
+		}
+		if im := instances[wf]; im != nil {
+			add(InstanceSelfLinksOutput, im.keptLinks())
+		}
+		for _, cw := range wf.childWorkflows() {
+			walk(cw)
+		}
+	}
+	walk(w)
+
+	outs := map[string]string{}
+	for name, ls := range links {
+		var urls []string
+		for l := range ls {
+			urls = append(urls, l)
+		}
+		sort.Strings(urls)
+		outs[name] = strings.Join(urls, ",")
+	}
+	return outs
+}
+
+// renderOutputs renders the Outputs of w, once its steps succeeded, over
+// its well-known outputs.
+func (w *Workflow) renderOutputs() (map[string]string, error) {
+	outs := w.wellKnownOutputs()
+	for name, tmpl := range w.outputTmpls {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, fmt.Errorf("error rendering output %q: %v", name, err)
+		}
+		outs[name] = buf.String()
+	}
+	return outs, nil
+}
+
+// writeOutputs renders the Outputs of w, records them for its RunResult
+// and writes them as a JSON object to outputs.json in the outs path.
+func (w *Workflow) writeOutputs(ctx context.Context) error {
+	outs, err := w.renderOutputs()
+	if err != nil {
+		return err
+	}
+	if len(outs) == 0 {
+		return nil
+	}
+	w.outputsMx.Lock()
+	w.outputs = outs
+	w.outputsMx.Unlock()
+
+	b, err := json.MarshalIndent(outs, "", "  ")
+	if err != nil {
+		return err
+	}
+	obj := path.Join(w.outsPath, outputsFile)
+	wc := w.StorageClient.Bucket(w.bucket).Object(obj).NewWriter(ctx)
+	wc.ContentType = "application/json"
+	if _, err := wc.Write(b); err != nil {
+		wc.Close()
+		return fmt.Errorf("error writing outputs to gs://%s/%s: %v", w.bucket, obj, err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("error writing outputs to gs://%s/%s: %v", w.bucket, obj, err)
+	}
+	w.logger.Printf("Wrote %d outputs to gs://%s/%s", len(outs), w.bucket, obj)
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
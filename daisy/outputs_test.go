@@ -0,0 +1,111 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseOutputs(t *testing.T) {
+	tests := []struct {
+		desc      string
+		outputs   map[string]string
+		shouldErr bool
+	}{
+		{"no outputs case", nil, false},
+		{"normal case", map[string]string{"image": `{{image "i"}}`, "version": "v1"}, false},
+		{"bad template case", map[string]string{"image": `{{image "i"`}, true},
+		{"unknown function case", map[string]string{"image": `{{foo "i"}}`}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Outputs = tt.outputs
+		if err := w.parseOutputs(); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	w := testWorkflow()
+	w.bucket = "bucket"
+	w.outsPath = "outs"
+	w.Outputs = map[string]string{"image": `{{image "i"}}`, "version": "v1"}
+	images[w].m = map[string]*resource{"i": {link: "projects/p/global/images/i-abcdef", created: true}}
+	if err := w.parseOutputs(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.writeOutputs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]string{"image": "projects/p/global/images/i-abcdef", "version": "v1"}
+	if diff := pretty.Compare(w.Result().Outputs, want); diff != "" {
+		t.Errorf("outputs do not match expectation: (-got +want)\n%s", diff)
+	}
+	if !strIn("outs/outputs.json", testGCSObjs) {
+		t.Errorf("outputs not written to GCS, objects: %q", testGCSObjs)
+	}
+
+	// Resources deleted by the workflow have no URL to output.
+	images[w].m["i"].deleted = true
+	if err := w.writeOutputs(context.Background()); err == nil {
+		t.Error("expected error rendering output of a deleted image")
+	}
+}
+
+func TestWellKnownOutputs(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	w.Steps = map[string]*Step{"sub": {SubWorkflow: &SubWorkflow{w: sw}}}
+	images[w].m = map[string]*resource{
+		"i1": {link: "projects/p/global/images/i1", created: true, noCleanup: true},
+		"i2": {link: "projects/p/global/images/i2", created: true},
+		"i3": {link: "projects/p/global/images/i3", created: true, noCleanup: true, deleted: true},
+		"i4": {link: "projects/p/global/images/i4", noCleanup: true},
+	}
+	images[sw].m = map[string]*resource{
+		"i0": {link: "projects/p/global/images/i0", created: true, noCleanup: true},
+	}
+	instances[sw].m = map[string]*resource{
+		"inst": {link: "projects/p/zones/z/instances/inst", created: true, noCleanup: true},
+	}
+
+	want := map[string]string{
+		ImageURIOutput:          "https://www.googleapis.com/compute/v1/projects/p/global/images/i0,https://www.googleapis.com/compute/v1/projects/p/global/images/i1",
+		InstanceSelfLinksOutput: "https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/inst",
+	}
+	if diff := pretty.Compare(w.wellKnownOutputs(), want); diff != "" {
+		t.Errorf("outputs do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Outputs of the workflow take precedence.
+	w.Outputs = map[string]string{ImageURIOutput: "mine"}
+	if err := w.parseOutputs(); err != nil {
+		t.Fatal(err)
+	}
+	outs, err := w.renderOutputs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outs[ImageURIOutput] != "mine" || outs[InstanceSelfLinksOutput] == "" {
+		t.Errorf("unexpected outputs: %v", outs)
+	}
+}
@@ -0,0 +1,427 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// PackerStartupScript is the source name, and file name, of the startup
+// script of workflows imported from Packer templates.
+const PackerStartupScript = "packer-startup.sh"
+
+// Serial output of the startup script of workflows imported from Packer
+// templates.
+const (
+	packerSuccess = "PackerBuildSuccess:"
+	packerFailure = "PackerBuildFailed:"
+)
+
+type packerTemplate struct {
+	Variables      map[string]*string
+	Builders       []map[string]interface{}
+	Provisioners   []map[string]interface{}
+	PostProcessors []interface{} `json:"post-processors"`
+}
+
+// packerTemplateRgx matches Packer template functions, e.g. {{user `name`}}.
+var packerTemplateRgx = regexp.MustCompile("{{\\s*([^\\s}]*)\\s*(?:`([^`]*)`)?\\s*}}")
+
+// convertPackerString converts the Packer template functions in s to vars
+// and autovars.
+func convertPackerString(s string) (string, error) {
+	var errs []string
+	r := packerTemplateRgx.ReplaceAllStringFunc(s, func(m string) string {
+		sm := packerTemplateRgx.FindStringSubmatch(m)
+		switch sm[1] {
+		case "user":
+			return fmt.Sprintf("${%s}", sm[2])
+		case "env":
+			return fmt.Sprintf("${env(%q)}", sm[2])
+		case "timestamp":
+			return "${TIMESTAMP}"
+		case "uuid":
+			return "${ID}"
+		}
+		errs = append(errs, m)
+		return m
+	})
+	if errs != nil {
+		return "", fmt.Errorf("unsupported template functions: %s", strings.Join(errs, ", "))
+	}
+	return r, nil
+}
+
+// packerScriptVarRgx matches the characters not allowed in the names of
+// the environment variables of startup scripts.
+var packerScriptVarRgx = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// packerScriptVars converts the Packer template functions of shell
+// provisioners to environment variables of the startup script. Sources
+// aren't substituted, so the script reads the variables from instance
+// metadata, set to the vars and autovars of the functions.
+type packerScriptVars struct {
+	// metadata holds the instance metadata, by variable name.
+	metadata map[string]string
+}
+
+func (sv *packerScriptVars) convert(s string) (string, error) {
+	var errs []string
+	r := packerTemplateRgx.ReplaceAllStringFunc(s, func(m string) string {
+		v, err := convertPackerString(m)
+		if err != nil {
+			errs = append(errs, m)
+			return m
+		}
+		sm := packerTemplateRgx.FindStringSubmatch(m)
+		name := "PACKER_" + strings.ToUpper(packerScriptVarRgx.ReplaceAllString(strings.TrimSuffix(sm[1]+"_"+sm[2], "_"), "_"))
+		sv.metadata[name] = v
+		return fmt.Sprintf("${%s}", name)
+	})
+	if errs != nil {
+		return "", fmt.Errorf("unsupported template functions: %s", strings.Join(errs, ", "))
+	}
+	return r, nil
+}
+
+// packerFields reads the fields of a Packer builder or provisioner, each
+// must be one of the supported fields. Fields in ignored are dropped.
+type packerFields struct {
+	m         map[string]interface{}
+	supported map[string]bool
+	errs      []string
+	// convert converts the template functions of the fields.
+	convert func(string) (string, error)
+}
+
+func newPackerFields(m map[string]interface{}, supported []string, ignored ...string) *packerFields {
+	f := &packerFields{m: m, supported: map[string]bool{}, convert: convertPackerString}
+	for _, k := range supported {
+		f.supported[k] = true
+	}
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if f.supported[k] || k == "type" {
+			continue
+		}
+		if strIn(k, ignored) || strings.HasPrefix(k, "ssh_") {
+			continue
+		}
+		f.errs = append(f.errs, fmt.Sprintf("unsupported field %q", k))
+	}
+	return f
+}
+
+func (f *packerFields) string(k string) string {
+	v, ok := f.m[k]
+	if !ok {
+		return ""
+	}
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		f.errs = append(f.errs, fmt.Sprintf("field %q must be a string", k))
+		return ""
+	}
+	s, err := f.convert(s)
+	if err != nil {
+		f.errs = append(f.errs, fmt.Sprintf("field %q: %v", k, err))
+	}
+	return s
+}
+
+// strings reads a list of strings, or a single string as a list of one.
+func (f *packerFields) strings(k string) []string {
+	v, ok := f.m[k]
+	if !ok {
+		return nil
+	}
+	if _, ok := v.(string); ok {
+		return []string{f.string(k)}
+	}
+	l, ok := v.([]interface{})
+	if !ok {
+		f.errs = append(f.errs, fmt.Sprintf("field %q must be a list of strings", k))
+		return nil
+	}
+	var ss []string
+	for i, e := range l {
+		s, ok := e.(string)
+		if !ok {
+			f.errs = append(f.errs, fmt.Sprintf("field %q must be a list of strings", k))
+			return nil
+		}
+		s, err := f.convert(s)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Sprintf("field %q[%d]: %v", k, i, err))
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func (f *packerFields) stringMap(k string) map[string]string {
+	v, ok := f.m[k]
+	if !ok {
+		return nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		f.errs = append(f.errs, fmt.Sprintf("field %q must be a map of strings", k))
+		return nil
+	}
+	sm := map[string]string{}
+	for mk, mv := range m {
+		s, ok := mv.(string)
+		if !ok {
+			f.errs = append(f.errs, fmt.Sprintf("field %q must be a map of strings", k))
+			return nil
+		}
+		s, err := f.convert(s)
+		if err != nil {
+			f.errs = append(f.errs, fmt.Sprintf("field %q[%q]: %v", k, mk, err))
+		}
+		sm[mk] = s
+	}
+	return sm
+}
+
+func (f *packerFields) err(desc string) error {
+	if f.errs == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", desc, strings.Join(f.errs, "; "))
+}
+
+// packerScript converts the shell provisioners of a Packer template to a
+// startup script, and the instance metadata it reads its variables from.
+// Script files are read relative to dir.
+func packerScript(provisioners []map[string]interface{}, dir string) ([]byte, map[string]string, error) {
+	sv := &packerScriptVars{metadata: map[string]string{}}
+	var buf bytes.Buffer
+	for i, p := range provisioners {
+		desc := fmt.Sprintf("provisioner %d", i)
+		if t, _ := p["type"].(string); t != "shell" {
+			return nil, nil, fmt.Errorf("%s: unsupported type %q, only shell provisioners are supported", desc, p["type"])
+		}
+		f := newPackerFields(p, []string{"inline", "script", "scripts", "environment_vars"})
+		f.convert = sv.convert
+		inline := f.strings("inline")
+		scripts := append(f.strings("script"), f.strings("scripts")...)
+		env := f.strings("environment_vars")
+		if err := f.err(desc); err != nil {
+			return nil, nil, err
+		}
+
+		// Packer runs inline commands as one script.
+		var contents []string
+		if inline != nil {
+			contents = append(contents, "#!/bin/sh -e\n"+strings.Join(inline, "\n")+"\n")
+		}
+		for _, s := range scripts {
+			if !filepath.IsAbs(s) {
+				s = filepath.Join(dir, s)
+			}
+			b, err := ioutil.ReadFile(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", desc, err)
+			}
+			contents = append(contents, string(b))
+		}
+		for j, c := range contents {
+			name := fmt.Sprintf("/tmp/packer-provisioner-%d-%d", i, j)
+			if !strings.HasSuffix(c, "\n") {
+				c += "\n"
+			}
+			fmt.Fprintf(&buf, "\ncat > %s <<'PACKER_EOF'\n%sPACKER_EOF\nrun %s", name, c, name)
+			for _, e := range env {
+				fmt.Fprintf(&buf, " %q", e)
+			}
+			buf.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(&buf, "\necho %q\n", packerSuccess+" provisioning finished.")
+
+	var script bytes.Buffer
+	script.WriteString(`#!/bin/bash
+# Generated by daisy import-packer from the shell provisioners of a Packer
+# template.
+run() {
+  chmod +x "$1" && env "${@:2}" "$1" || { echo "` + packerFailure + ` $1 failed."; exit 1; }
+}
+`)
+	if len(sv.metadata) > 0 {
+		script.WriteString(`metadata() {
+  curl -sf -H "Metadata-Flavor: Google" "http://metadata.google.internal/computeMetadata/v1/instance/attributes/$1" || { echo "` + packerFailure + ` reading metadata $1 failed." >&2; exit 1; }
+}
+`)
+		var names []string
+		for name := range sv.metadata {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&script, "%[1]s=$(metadata %[1]s) || exit 1\nexport %[1]s\n", name)
+		}
+	}
+	script.Write(buf.Bytes())
+	return script.Bytes(), sv.metadata, nil
+}
+
+// ImportPacker converts a Packer JSON template with a googlecompute builder
+// into a workflow. The workflow creates a disk from the source image, boots
+// an instance from it running the shell provisioners as its startup script,
+// and creates the image from the disk once the script finished. Template
+// variables become Vars. Script files are read relative to dir.
+//
+// The startup script is returned, to be written next to the workflow as
+// PackerStartupScript. Only a subset of Packer is supported: other
+// builders, provisioners and post-processors, and most template functions,
+// are errors.
+func ImportPacker(template []byte, dir string) (*Workflow, []byte, error) {
+	var t packerTemplate
+	if err := json.Unmarshal(template, &t); err != nil {
+		return nil, nil, fmt.Errorf("error parsing Packer template: %v", err)
+	}
+	if len(t.Builders) != 1 {
+		return nil, nil, fmt.Errorf("template must have exactly one builder, got %d", len(t.Builders))
+	}
+	if typ, _ := t.Builders[0]["type"].(string); typ != "googlecompute" {
+		return nil, nil, fmt.Errorf("unsupported builder type %q, only googlecompute is supported", typ)
+	}
+	if len(t.PostProcessors) != 0 {
+		return nil, nil, fmt.Errorf("post-processors are not supported")
+	}
+
+	w := New()
+	for k, v := range t.Variables {
+		if v == nil {
+			w.Vars[k] = vars{Required: true}
+			continue
+		}
+		if m := packerTemplateRgx.FindStringSubmatch(*v); m != nil && m[0] == *v && m[1] == "env" {
+			w.Vars[k] = vars{ValueFromEnv: m[2]}
+			continue
+		}
+		s, err := convertPackerString(*v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("variable %q: %v", k, err)
+		}
+		w.Vars[k] = vars{Value: s}
+	}
+
+	b := newPackerFields(t.Builders[0], []string{
+		"name", "project_id", "zone", "source_image", "source_image_family", "source_image_project_id",
+		"image_name", "image_family", "image_description", "image_labels", "disk_size", "disk_type",
+		"machine_type", "network", "subnetwork", "tags", "metadata", "service_account_email", "scopes",
+	}, "communicator", "account_file")
+	w.Name = "packer-" + strOr(b.string("name"), "googlecompute")
+	w.Project = b.string("project_id")
+	w.Zone = b.string("zone")
+
+	sourceImage := b.string("source_image")
+	if f := b.string("source_image_family"); f != "" {
+		sourceImage = "family/" + f
+	}
+	if p := b.strings("source_image_project_id"); len(p) > 0 {
+		// daisy looks up images in one project.
+		sourceImage = fmt.Sprintf("projects/%s/global/images/%s", p[0], sourceImage)
+	}
+	disk := &CreateDisk{Disk: compute.Disk{Name: "packer-disk", SourceImage: sourceImage, Type: b.string("disk_type")}, SizeGb: b.string("disk_size")}
+
+	metadata := b.stringMap("metadata")
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	inst := &CreateInstance{
+		Instance: compute.Instance{
+			Name:        "packer-instance",
+			Disks:       []*compute.AttachedDisk{{Source: disk.Name}},
+			MachineType: strOr(b.string("machine_type"), "n1-standard-1"),
+		},
+		Metadata:      metadata,
+		StartupScript: PackerStartupScript,
+	}
+	if n, sn := b.string("network"), b.string("subnetwork"); n != "" || sn != "" {
+		inst.NetworkInterfaces = []*compute.NetworkInterface{{Network: n, Subnetwork: sn}}
+	}
+	if tags := b.strings("tags"); tags != nil {
+		inst.Tags = &compute.Tags{Items: tags}
+	}
+	if sa := b.string("service_account_email"); sa != "" {
+		inst.ServiceAccounts = []*compute.ServiceAccount{{Email: sa, Scopes: b.strings("scopes")}}
+	} else {
+		inst.Scopes = b.strings("scopes")
+	}
+
+	image := &CreateImage{
+		Image: compute.Image{
+			Name:        strOr(b.string("image_name"), "packer-${TIMESTAMP}"),
+			SourceDisk:  disk.Name,
+			Family:      b.string("image_family"),
+			Description: b.string("image_description"),
+			Labels:      b.stringMap("image_labels"),
+		},
+		ExactName: true,
+		NoCleanup: true,
+	}
+	if err := b.err("builder"); err != nil {
+		return nil, nil, err
+	}
+
+	script, scriptMetadata, err := packerScript(t.Provisioners, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range scriptMetadata {
+		metadata[k] = v
+	}
+	w.Sources[PackerStartupScript] = "./" + PackerStartupScript
+
+	w.Steps = map[string]*Step{
+		"create-disk":       {CreateDisks: &CreateDisks{disk}},
+		"create-instance":   {CreateInstances: &CreateInstances{inst}},
+		"wait-for-instance": {Timeout: "60m", WaitForInstancesSignal: &WaitForInstancesSignal{{Name: inst.Name, SerialOutput: &SerialOutput{Port: 1, SuccessMatch: packerSuccess, FailureMatch: packerFailure}}}},
+		"delete-instance":   {DeleteResources: &DeleteResources{Instances: []string{inst.Name}}},
+		"create-image":      {CreateImages: &CreateImages{image}},
+	}
+	w.Dependencies = map[string][]string{
+		"create-instance":   {"create-disk"},
+		"wait-for-instance": {"create-instance"},
+		"delete-instance":   {"wait-for-instance"},
+		"create-image":      {"delete-instance"},
+	}
+	return w, script, nil
+}
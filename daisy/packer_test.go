@@ -0,0 +1,131 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestImportPacker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daisy-packer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "setup.sh"), []byte("#!/bin/bash\necho setup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := "" +
+		`{
+  "variables": {"project": null, "version": "v1", "build": "{{env ` + "`BUILD`" + `}}"},
+  "builders": [{
+    "type": "googlecompute",
+    "project_id": "{{user ` + "`project`" + `}}",
+    "zone": "us-central1-a",
+    "source_image_family": "debian-9",
+    "source_image_project_id": "debian-cloud",
+    "image_name": "my-image-{{user ` + "`version`" + `}}-{{timestamp}}",
+    "image_family": "my-family",
+    "image_labels": {"build": "{{user ` + "`build`" + `}}"},
+    "disk_size": 20,
+    "machine_type": "n1-standard-2",
+    "tags": ["packer"],
+    "ssh_username": "packer"
+  }],
+  "provisioners": [
+    {"type": "shell", "inline": ["apt-get update", "apt-get -y upgrade", "echo {{user ` + "`version`" + `}}"]},
+    {"type": "shell", "script": "setup.sh", "environment_vars": ["FOO=bar", "BUILD={{env ` + "`BUILD`" + `}}"]}
+  ]
+}`
+	w, script, err := ImportPacker([]byte(tmpl), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(w.Vars, map[string]vars{
+		"project": {Required: true},
+		"version": {Value: "v1"},
+		"build":   {ValueFromEnv: "BUILD"},
+	}); diff != "" {
+		t.Errorf("vars do not match expectation: (-got +want)\n%s", diff)
+	}
+	if w.Name != "packer-googlecompute" || w.Project != "${project}" || w.Zone != "us-central1-a" {
+		t.Errorf("unexpected workflow fields, name: %q, project: %q, zone: %q", w.Name, w.Project, w.Zone)
+	}
+	disk := (*w.Steps["create-disk"].CreateDisks)[0]
+	if disk.SourceImage != "projects/debian-cloud/global/images/family/debian-9" || disk.SizeGb != "20" {
+		t.Errorf("unexpected disk: %+v", disk)
+	}
+	inst := (*w.Steps["create-instance"].CreateInstances)[0]
+	if inst.MachineType != "n1-standard-2" || inst.StartupScript != PackerStartupScript || inst.Tags == nil {
+		t.Errorf("unexpected instance: %+v", inst)
+	}
+	// Sources aren't substituted, the script reads the vars from metadata.
+	if diff := pretty.Compare(inst.Metadata, map[string]string{"PACKER_USER_VERSION": "${version}", "PACKER_ENV_BUILD": `${env("BUILD")}`}); diff != "" {
+		t.Errorf("instance metadata does not match expectation: (-got +want)\n%s", diff)
+	}
+	wantImage := compute.Image{
+		Name:       "my-image-${version}-${TIMESTAMP}",
+		SourceDisk: "packer-disk",
+		Family:     "my-family",
+		Labels:     map[string]string{"build": "${build}"},
+	}
+	if diff := pretty.Compare((*w.Steps["create-image"].CreateImages)[0].Image, wantImage); diff != "" {
+		t.Errorf("image does not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, err := json.Marshal(w); err != nil {
+		t.Errorf("error marshalling workflow: %v", err)
+	}
+
+	for _, want := range []string{
+		"PACKER_ENV_BUILD=$(metadata PACKER_ENV_BUILD) || exit 1\nexport PACKER_ENV_BUILD\nPACKER_USER_VERSION=$(metadata PACKER_USER_VERSION) || exit 1\nexport PACKER_USER_VERSION\n",
+		"#!/bin/sh -e\napt-get update\napt-get -y upgrade\necho ${PACKER_USER_VERSION}\n",
+		"#!/bin/bash\necho setup\nPACKER_EOF\nrun /tmp/packer-provisioner-1-0 \"FOO=bar\" \"BUILD=${PACKER_ENV_BUILD}\"\n",
+		packerSuccess,
+	} {
+		if !strings.Contains(string(script), want) {
+			t.Errorf("startup script does not contain %q:\n%s", want, script)
+		}
+	}
+}
+
+func TestImportPackerErrors(t *testing.T) {
+	tests := []struct {
+		desc, tmpl string
+	}{
+		{"bad JSON case", `{`},
+		{"no builder case", `{"builders": []}`},
+		{"other builder case", `{"builders": [{"type": "amazon-ebs"}]}`},
+		{"unsupported field case", `{"builders": [{"type": "googlecompute", "use_iap": true}]}`},
+		{"unsupported function case", `{"builders": [{"type": "googlecompute", "image_name": "{{isotime}}"}]}`},
+		{"post-processor case", `{"builders": [{"type": "googlecompute"}], "post-processors": ["manifest"]}`},
+		{"other provisioner case", `{"builders": [{"type": "googlecompute"}], "provisioners": [{"type": "ansible"}]}`},
+		{"missing script case", `{"builders": [{"type": "googlecompute"}], "provisioners": [{"type": "shell", "script": "nope.sh"}]}`},
+	}
+	for _, tt := range tests {
+		if _, _, err := ImportPacker([]byte(tt.tmpl), "."); err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "fmt"
+
+// Policy vets the GCE resources workflows create, e.g. to enforce
+// organization rules such as no external IPs or only approved source
+// images for all workflows run by a service.
+type Policy interface {
+	// Check is called with each resource w is about to create. If it
+	// returns an error, the violation, the resource is not created and the
+	// step creating it fails.
+	Check(w *Workflow, r *PlannedResource) error
+}
+
+// PolicyFunc is an adapter to use an ordinary function as a Policy.
+type PolicyFunc func(w *Workflow, r *PlannedResource) error
+
+// Check calls f(w, r).
+func (f PolicyFunc) Check(w *Workflow, r *PlannedResource) error {
+	return f(w, r)
+}
+
+// PlannedResource is a GCE resource a workflow is about to create.
+type PlannedResource struct {
+	// Type is the resource type, e.g. "disk".
+	Type string
+	// Name is the name the resource is created with.
+	Name string
+	// Project the resource is created in.
+	Project string
+	// Zone or Region the resource is created in, if any.
+	Zone, Region string
+	// Resource is the API request body, e.g. a *compute.Instance. It must
+	// not be modified.
+	Resource interface{}
+}
+
+// checkPolicy checks r against the Policy of w or of its closest parent
+// that has one. Without a Policy, all resources are allowed.
+func (w *Workflow) checkPolicy(r *PlannedResource) error {
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.Policy != nil {
+			if err := wf.Policy.Check(w, r); err != nil {
+				return fmt.Errorf("policy violation creating %s %q: %v", r.Type, r.Name, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
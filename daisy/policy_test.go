@@ -0,0 +1,96 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	r := &PlannedResource{Type: "instance", Name: "i", Project: testProject, Zone: testZone, Resource: &compute.Instance{Name: "i"}}
+
+	if err := sw.checkPolicy(r); err != nil {
+		t.Errorf("unexpected error without a policy: %v", err)
+	}
+
+	var gotW *Workflow
+	var gotR *PlannedResource
+	w.Policy = PolicyFunc(func(w *Workflow, r *PlannedResource) error {
+		gotW, gotR = w, r
+		return errors.New("no external IPs")
+	})
+	want := `policy violation creating instance "i": no external IPs`
+	if err := sw.checkPolicy(r); err == nil || err.Error() != want {
+		t.Errorf("unexpected error, got: %v, want: %q", err, want)
+	}
+	if gotW != sw || gotR != r {
+		t.Error("policy was not called with the subworkflow and the resource")
+	}
+
+	sw.Policy = PolicyFunc(func(*Workflow, *PlannedResource) error { return nil })
+	if err := sw.checkPolicy(r); err != nil {
+		t.Errorf("unexpected error with the subworkflow's policy: %v", err)
+	}
+}
+
+func TestPolicyVetoesCreation(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	var created []string
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateImageFn: func(_ string, i *compute.Image) error {
+			created = append(created, i.Name)
+			return nil
+		},
+	}
+	var checked []*PlannedResource
+	w.Policy = PolicyFunc(func(_ *Workflow, r *PlannedResource) error {
+		checked = append(checked, r)
+		if r.Resource.(*compute.Image).SourceImage != "" {
+			return errors.New("only disks may be imaged")
+		}
+		return nil
+	})
+
+	ok := &CreateImages{{Image: compute.Image{Name: "ok", SourceDisk: "projects/p/zones/z/disks/d"}, Project: "p"}}
+	if err := ok.run(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	bad := &CreateImages{{Image: compute.Image{Name: "bad", SourceImage: "projects/p/global/images/i"}, Project: "p"}}
+	if err := bad.run(ctx, s); err == nil {
+		t.Error("should have returned an error")
+	}
+
+	if diff := pretty.Compare(created, []string{"ok"}); diff != "" {
+		t.Errorf("created images do not match expectation: (-got +want)\n%s", diff)
+	}
+	wantChecked := []*PlannedResource{
+		{Type: "image", Name: "ok", Project: "p", Resource: &(*ok)[0].Image},
+		{Type: "image", Name: "bad", Project: "p", Resource: &(*bad)[0].Image},
+	}
+	if diff := pretty.Compare(checked, wantChecked); diff != "" {
+		t.Errorf("checked resources do not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,77 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "time"
+
+// StepProgress is a state transition of a step, see OnProgress.
+type StepProgress struct {
+	// Step is the name of the step. Steps of IncludeWorkflow, ForEach and
+	// SubWorkflow steps are prefixed with the name of the step that ran them,
+	// e.g. "sub.step".
+	Step string
+	// State is the state the step moved to.
+	State StepState
+	// Time is when the step moved to State.
+	Time time.Time
+	// Err is the error the step failed with if State is StepFailed.
+	Err error
+}
+
+// OnProgress adds a function called on each state transition of the steps
+// of w and its nested workflows: once with StepWaiting for each step as its
+// workflow starts running, then as steps start running, finish, fail, are
+// canceled or skipped. Calls are made one at a time, in order of the
+// transitions, from the goroutines running the steps, so f must not block;
+// to consume transitions from a channel, send to a buffered channel in f.
+// Functions of parent workflows are called before those of w.
+func (w *Workflow) OnProgress(f func(StepProgress)) {
+	w.progressFnsMx.Lock()
+	defer w.progressFnsMx.Unlock()
+	w.progressFns = append(w.progressFns, f)
+}
+
+// reportProgress calls the progress functions of w and its parents for the
+// transition of s to st.
+func (w *Workflow) reportProgress(s *Step, st StepState, err error) {
+	if w == nil {
+		return
+	}
+	var fns []func(StepProgress)
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.progressFnsMx.Lock()
+		fns = append(append([]func(StepProgress){}, wf.progressFns...), fns...)
+		wf.progressFnsMx.Unlock()
+	}
+	if len(fns) == 0 {
+		return
+	}
+	root := w.root()
+	root.progressMx.Lock()
+	defer root.progressMx.Unlock()
+	p := StepProgress{Step: w.nestedName(s.name), State: st, Time: time.Now(), Err: err}
+	for _, f := range fns {
+		f(p)
+	}
+}
+
+// reportWaiting reports the steps of w as waiting to run.
+func (w *Workflow) reportWaiting() {
+	for _, name := range sortedStepNames(w) {
+		if s := w.Steps[name]; s.State() == StepWaiting {
+			w.reportProgress(s, StepWaiting, nil)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestOnProgress(t *testing.T) {
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	iw.Name = "include"
+	iw.parent = w
+	iw.logger = w.logger
+	iw.Steps = map[string]*Step{"inner": {name: "inner", w: iw, timeout: time.Minute, testType: &mockStep{}}}
+	w.Steps = map[string]*Step{
+		"s0":      {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"include": {name: "include", w: w, timeout: time.Minute, IncludeWorkflow: &IncludeWorkflow{w: iw}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"s2": {name: "s2", w: w, timeout: time.Minute, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"include": {"s0"}, "s1": {"include"}, "s2": {"s1"}}
+
+	var got []string
+	w.OnProgress(func(p StepProgress) {
+		if p.Time.IsZero() {
+			t.Errorf("progress of step %q to %s has no time", p.Step, p.State)
+		}
+		e := fmt.Sprintf("%s %s", p.Step, p.State)
+		if p.Err != nil {
+			e += ": " + p.Err.Error()
+		}
+		got = append(got, e)
+	})
+	var inner []string
+	iw.OnProgress(func(p StepProgress) { inner = append(inner, fmt.Sprintf("%s %s", p.Step, p.State)) })
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("expected error running workflow")
+	}
+
+	want := []string{
+		"include waiting", "s0 waiting", "s1 waiting", "s2 waiting",
+		"s0 running", "s0 finished",
+		"include running", "include.inner waiting", "include.inner running", "include.inner finished", "include finished",
+		"s1 running", `s1 failed: step "s1" run error: fail`,
+		"s2 skipped",
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("progress does not match expectation: (-got +want)\n%s", diff)
+	}
+	wantInner := []string{"include.inner waiting", "include.inner running", "include.inner finished"}
+	if diff := pretty.Compare(inner, wantInner); diff != "" {
+		t.Errorf("included workflow progress does not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -15,25 +15,54 @@
 package daisy
 
 import (
-	"sync"
+	"fmt"
+	"reflect"
+	"strconv"
 
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
-var projects struct {
-	valid []string
-	mu    sync.Mutex
-}
+var projects = &catalogCache{}
 
 func checkProject(client compute.Client, project string) error {
-	projects.mu.Lock()
-	defer projects.mu.Unlock()
-	if strIn(project, projects.valid) {
+	return projects.check(client, project, func() error {
+		_, err := client.GetProject(project)
+		return err
+	})
+}
+
+// populateProjectNumber sets the PROJECTNUMBER autovar if w refers to it.
+// Looking the number up takes an API call, so it is skipped otherwise.
+func (w *Workflow) populateProjectNumber() error {
+	if _, ok := w.autovars["PROJECTNUMBER"]; ok {
 		return nil
 	}
-	if _, err := client.GetProject(project); err != nil {
-		return err
+	if !refersTo(reflect.ValueOf(w).Elem(), "${PROJECTNUMBER}") {
+		return nil
 	}
-	projects.valid = append(projects.valid, project)
+	p, err := w.ComputeClient.GetProject(w.Project)
+	if err != nil {
+		return fmt.Errorf("error getting the number of project %q: %v", w.Project, err)
+	}
+	w.autovars["PROJECTNUMBER"] = strconv.FormatUint(p.Id, 10)
 	return nil
 }
+
+// osLoginPermission is granted by roles/compute.osLogin and is required to
+// log in to instances with OS Login enabled.
+const osLoginPermission = "compute.instances.osLogin"
+
+var osLoginProjects = &catalogCache{}
+
+func checkOSLogin(client compute.Client, project string) error {
+	return osLoginProjects.check(client, project, func() error {
+		perms, err := client.TestProjectPermissions(project, osLoginPermission)
+		if err != nil {
+			return err
+		}
+		if !strIn(osLoginPermission, perms) {
+			return fmt.Errorf("credential is missing permission %q, grant it roles/compute.osLogin", osLoginPermission)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestPopulateProjectNumber(t *testing.T) {
+	w := testWorkflow()
+	var calls int
+	w.ComputeClient.(*daisyCompute.TestClient).GetProjectFn = func(project string) (*compute.Project, error) {
+		calls++
+		if project != testProject {
+			return nil, errors.New("bad project")
+		}
+		return &compute.Project{Id: 1234567890}, nil
+	}
+
+	// Not referred to, not looked up.
+	w.autovars = map[string]string{}
+	if err := w.populateProjectNumber(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := w.autovars["PROJECTNUMBER"]; ok || calls != 0 {
+		t.Errorf("project number looked up without being referred to, calls: %d", calls)
+	}
+
+	w.Steps = map[string]*Step{"s": {WaitForInstancesSignal: &WaitForInstancesSignal{{Name: "i-${PROJECTNUMBER}"}}}}
+	if err := w.populateProjectNumber(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := w.autovars["PROJECTNUMBER"]; got != "1234567890" {
+		t.Errorf("unexpected PROJECTNUMBER, got: %q, want: %q", got, "1234567890")
+	}
+	// Looked up once.
+	if err := w.populateProjectNumber(); err != nil || calls != 1 {
+		t.Errorf("project number looked up again, calls: %d, err: %v", calls, err)
+	}
+
+	w.autovars = map[string]string{}
+	w.Project = "bad"
+	if err := w.populateProjectNumber(); err == nil {
+		t.Error("expected error for bad project")
+	}
+}
@@ -0,0 +1,676 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerService is the name workers serve their RPC methods under.
+const workerService = "DaisyWorker"
+
+// RemoteExecutor is an Executor that runs the steps of a workflow on daisy
+// workers, see ServeWorker, so that wide workflows aren't limited by the
+// API quota and memory of a single machine. The process running the
+// workflow, the coordinator, keeps the step graph and the resources of the
+// run: it starts steps as their dependencies are done, sends each to a free
+// worker with the state of the run, and records the resources the step
+// created and deleted, and its outputs, once the worker returns. Cleanup
+// runs on the coordinator.
+//
+// Each worker runs one step at a time, steps wait for a free worker.
+// Workers whose connection breaks, e.g. as they crashed, are dropped: the
+// step they ran fails, as what it did is unknown, and the steps left run
+// on the other workers, or fail once no worker is left. The
+// steps of IncludeWorkflow, SubWorkflow and ForEach steps run on workers,
+// the steps themselves on the coordinator, as do RunAlways steps, so they
+// run even if the workers are gone, VerifyContentHashes and
+// WriteTemplatedFiles steps, which share the content hashes of the run, and
+// GrantRoles steps, whose cleanup hooks revoke the roles they granted. The
+// logs, audit records and API calls of the steps the workers run are
+// recorded as the coordinator's. Workflows with a Policy can't run on
+// workers, the Policy can't be sent to them.
+//
+// Workers run whatever steps they are sent with their credentials, so the
+// coordinator and its workers authenticate each other, see WorkerAuth.
+type RemoteExecutor struct {
+	workflow string
+	token    string
+	workers  []*remoteWorker
+	free     chan *remoteWorker
+	// gone is closed once all workers are dropped.
+	gone chan struct{}
+	live int
+	mx   sync.Mutex
+}
+
+// WorkerAuth authenticates a coordinator and its workers to each other.
+type WorkerAuth struct {
+	// Token is a secret shared by the coordinator and its workers. Every
+	// call to a worker carries it, workers refuse calls without it.
+	Token string
+	// TLS, if set, encrypts the connections to the workers, the token
+	// is sent in plain text otherwise. Workers should set ClientAuth to
+	// tls.RequireAndVerifyClientCert, so only coordinators with a
+	// certificate they trust can connect.
+	TLS *tls.Config
+}
+
+type remoteWorker struct {
+	addr    string
+	client  *rpc.Client
+	dropped bool
+}
+
+// NewRemoteExecutor returns a RemoteExecutor running steps on the workers
+// listening on addrs, "host:port", authenticated with auth. workflow names
+// the workflow for the workers to load, e.g. its path.
+func NewRemoteExecutor(workflow string, auth WorkerAuth, addrs ...string) (*RemoteExecutor, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no worker addresses")
+	}
+	if auth.Token == "" {
+		return nil, errors.New("no worker token")
+	}
+	e := &RemoteExecutor{workflow: workflow, token: auth.Token, free: make(chan *remoteWorker, len(addrs)), gone: make(chan struct{})}
+	for _, addr := range addrs {
+		c, err := dialWorker(addr, auth.TLS)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("error connecting to worker %s: %v", addr, err)
+		}
+		wk := &remoteWorker{addr: addr, client: c}
+		e.workers = append(e.workers, wk)
+		e.live++
+		e.free <- wk
+	}
+	return e, nil
+}
+
+func dialWorker(addr string, config *tls.Config) (*rpc.Client, error) {
+	if config == nil {
+		return rpc.Dial("tcp", addr)
+	}
+	conn, err := tls.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// Close closes the connections to the workers.
+func (e *RemoteExecutor) Close() error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	var firstErr error
+	for _, wk := range e.workers {
+		if wk.dropped {
+			continue
+		}
+		if err := wk.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Execute runs the steps of w in dependency order, as the default executor
+// does, the steps themselves run on the workers.
+func (e *RemoteExecutor) Execute(w *Workflow, run func(*Step) error) error {
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.Policy != nil {
+			return errors.New("workflows with a Policy can't run on workers")
+		}
+	}
+	return localExecutor{}.Execute(w, run)
+}
+
+// RemoteStepRequest is what a RemoteExecutor sends a worker to run a step.
+// Its contents are internal to daisy.
+type RemoteStepRequest struct {
+	// Token authenticates the coordinator, see WorkerAuth.
+	Token string
+	// ID of the request, the run ID and the nested name of the step.
+	ID       string
+	Workflow string
+	Project  string
+	Zone     string
+	GCSPath  string
+	// Verbosity of the logs of the run.
+	Verbosity string
+	// Vars of the run, except those read from secrets, which workers read
+	// themselves.
+	Vars map[string]string
+	// State of the run: its IDs and scratch paths, and the resources it
+	// created so far.
+	State *checkpoint
+	// Outputs of the steps of the run, by nested name.
+	Outputs map[string]map[string]string
+	// Chain names the step, see Step.getChain.
+	Chain []string
+}
+
+// RemoteCancelRequest is what a RemoteExecutor sends a worker to cancel a
+// step. Its contents are internal to daisy.
+type RemoteCancelRequest struct {
+	Token string
+	// ID of the RemoteStepRequest of the step.
+	ID string
+}
+
+// RemoteStepReply is what a worker returns once it ran a step. Its contents
+// are internal to daisy.
+type RemoteStepReply struct {
+	// Resources of the run, as the step left them.
+	Resources []*checkpointResource
+	Outputs   map[string]string
+	// Logs of the step, the audit records and stats of the API calls of
+	// the worker, and its failed API calls by reason, for the coordinator
+	// to record.
+	Logs      []*LogEntry
+	Audit     []*auditRecord
+	APICalls  []*APICallStats
+	APIErrors map[string]int
+	// Err is the error the step failed with, net/rpc drops the reply of
+	// calls that fail.
+	Err string
+}
+
+// runsRemotely returns true if s runs on a worker when its workflow uses a
+// RemoteExecutor.
+func (s *Step) runsRemotely() bool {
+	if s.RunAlways {
+		return false
+	}
+	switch {
+	case s.IncludeWorkflow != nil, s.SubWorkflow != nil, s.ForEach != nil, s.VerifyContentHashes != nil, s.WriteTemplatedFiles != nil:
+		return false
+	case s.GrantRoles != nil:
+		// Steps registering cleanup hooks run where cleanup runs.
+		return false
+	}
+	return true
+}
+
+// runImpl runs impl, the step type of s, on a worker if the workflow uses a
+// RemoteExecutor.
+func (s *Step) runImpl(ctx context.Context, impl stepImpl) error {
+	if e, ok := s.w.executor().(*RemoteExecutor); ok && s.runsRemotely() {
+		return e.runStep(s)
+	}
+	return impl.run(ctx, s)
+}
+
+// runStep runs s on a free worker and records the resources it created and
+// deleted, and its outputs.
+func (e *RemoteExecutor) runStep(s *Step) error {
+	var wk *remoteWorker
+	select {
+	case wk = <-e.free:
+	case <-e.gone:
+		return errors.New("no workers left to run the step on")
+	case <-s.w.cancelChan():
+		return errors.New(s.w.cancelCause())
+	}
+	broken := false
+	defer func() {
+		if broken {
+			e.drop(wk)
+		} else {
+			e.free <- wk
+		}
+	}()
+
+	req := s.remoteRequest(e.workflow)
+	req.Token = e.token
+	reply := &RemoteStepReply{}
+	call := wk.client.Go(workerService+".RunStep", req, reply, nil)
+	select {
+	case <-call.Done:
+	case <-s.w.cancelChan():
+		// The worker stops the step as the workflow would, what it did
+		// until then is still recorded.
+		var ok bool
+		if err := wk.client.Call(workerService+".Cancel", &RemoteCancelRequest{Token: e.token, ID: req.ID}, &ok); err != nil {
+			s.w.logger.Printf("Error canceling step %q on worker %s: %v", s.name, wk.addr, err)
+		}
+		<-call.Done
+	}
+	if call.Error != nil {
+		if broken = isBrokenConn(call.Error); broken {
+			s.w.logger.Printf("Lost connection to worker %s, no more steps are sent to it: %v", wk.addr, call.Error)
+		}
+		return fmt.Errorf("error running step on worker %s: %v", wk.addr, call.Error)
+	}
+	s.recordRemote(reply)
+
+	for rm, names := range s.w.root().syncResources(reply.Resources, s) {
+		for _, name := range names {
+			rm.markCreated(name)
+		}
+	}
+	for k, v := range reply.Outputs {
+		s.setOutput(k, v)
+	}
+	if reply.Err != "" {
+		return errors.New(reply.Err)
+	}
+	return nil
+}
+
+// isBrokenConn returns true if err, the error of a call to a worker, means
+// its connection broke.
+func isBrokenConn(err error) bool {
+	if err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// drop closes the connection to wk, which broke, and stops sending it
+// steps.
+func (e *RemoteExecutor) drop(wk *remoteWorker) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	wk.client.Close()
+	wk.dropped = true
+	if e.live--; e.live == 0 {
+		close(e.gone)
+	}
+}
+
+// recordRemote records the logs, audit records and API calls of reply, the
+// reply of the worker that ran s, as those of the run.
+func (s *Step) recordRemote(reply *RemoteStepReply) {
+	root := s.w.root()
+	for _, e := range reply.Logs {
+		s.w.logger.logEntry(e)
+	}
+	for _, rec := range reply.Audit {
+		root.audit.add(rec)
+	}
+	root.apiCalls.merge(reply.APICalls)
+	s.w.metrics().countAPIErrors(reply.APIErrors)
+}
+
+// remoteRequest returns the request to run s on a worker.
+func (s *Step) remoteRequest(workflow string) *RemoteStepRequest {
+	root := s.w.root()
+	var chain []string
+	for _, st := range s.getChain() {
+		chain = append(chain, st.name)
+	}
+	vs := map[string]string{}
+	for k, v := range root.Vars {
+		if v.ValueFromSecret == "" {
+			vs[k] = v.Value
+		}
+	}
+	outs := map[string]map[string]string{}
+	for wf := s.w; wf != nil; wf = wf.parent {
+		wf.stepOutputsMx.Lock()
+		for name, o := range wf.stepOutputs {
+			outs[wf.nestedName(name)] = copyStringMap(o)
+		}
+		wf.stepOutputsMx.Unlock()
+	}
+	return &RemoteStepRequest{
+		ID:        root.id + "/" + strings.Join(chain, "."),
+		Workflow:  workflow,
+		Project:   root.Project,
+		Zone:      root.Zone,
+		GCSPath:   root.GCSPath,
+		Verbosity: root.Verbosity,
+		Vars:      vs,
+		State:     root.newCheckpoint(),
+		Outputs:   outs,
+		Chain:     chain,
+	}
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// syncResources marks the resources of w and its subworkflows that crs, the
+// resources of a run, lists as created, or deleted, as such. Resources w
+// doesn't know of are added, as created by s. Resources are never marked as
+// not created or not deleted again, so stale lists are harmless. It returns
+// the names of the resources it marked as created, by resource map.
+func (w *Workflow) syncResources(crs []*checkpointResource, s *Step) map[*baseResourceMap][]string {
+	wfs := w.runWorkflows()
+	created := map[*baseResourceMap][]string{}
+	for _, cr := range crs {
+		rm := checkpointResourceMap(wfs, cr)
+		if rm == nil {
+			continue
+		}
+		rm.mx.Lock()
+		r, ok := rm.m[cr.Name]
+		if !ok {
+			r = &resource{real: path.Base(cr.Link), link: cr.Link, creator: s}
+			rm.m[cr.Name] = r
+		}
+		if !r.created {
+			r.created = true
+			created[rm] = append(created[rm], cr.Name)
+		}
+		if cr.Deleted && !r.deleted {
+			r.deleted = true
+			r.deletedAt = time.Now()
+		}
+		rm.mx.Unlock()
+	}
+	return created
+}
+
+// ServeWorker runs the steps RemoteExecutors connecting to l send, if they
+// are authenticated with auth. load returns a new instance of the workflow
+// a RemoteExecutor names, e.g. read with NewFromFile, with the clients and
+// settings of the worker. The name comes from the coordinator, load must
+// only load workflows the worker is meant to run, e.g. from an allowlisted
+// directory. The Project, Zone, GCSPath and Vars of the workflow are set to
+// those of the run. ServeWorker returns the error accepting a connection
+// failed with, e.g. once l is closed.
+func ServeWorker(l net.Listener, auth WorkerAuth, load func(workflow string) (*Workflow, error)) error {
+	if auth.Token == "" {
+		return errors.New("no worker token")
+	}
+	if auth.TLS != nil {
+		l = tls.NewListener(l, auth.TLS)
+	}
+	return newWorker(auth.Token, load).serve(l)
+}
+
+func newWorker(token string, load func(string) (*Workflow, error)) *worker {
+	return &worker{token: token, load: load, running: map[string]*Workflow{}, canceled: map[string]bool{}}
+}
+
+// serve serves the RPC methods of wk on the connections l accepts.
+func (wk *worker) serve(l net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(workerService, wk); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// worker serves the RPC methods of ServeWorker.
+type worker struct {
+	token string
+	load  func(string) (*Workflow, error)
+	// runMx makes the worker run one step at a time, setting up a workflow
+	// while another runs in the same process isn't safe.
+	runMx sync.Mutex
+
+	mx       sync.Mutex
+	running  map[string]*Workflow
+	canceled map[string]bool
+}
+
+// errBadToken is the error of calls to a worker with the wrong token.
+var errBadToken = errors.New("bad worker token")
+
+func (wk *worker) authenticate(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(wk.token)) != 1 {
+		return errBadToken
+	}
+	return nil
+}
+
+// RunStep runs the step of req. Errors of the step are returned in the
+// reply, the call fails if the workflow can't be loaded.
+func (wk *worker) RunStep(req *RemoteStepRequest, reply *RemoteStepReply) error {
+	if err := wk.authenticate(req.Token); err != nil {
+		return err
+	}
+	wk.runMx.Lock()
+	defer wk.runMx.Unlock()
+	w, err := wk.load(req.Workflow)
+	if err != nil {
+		return fmt.Errorf("error loading workflow %q: %v", req.Workflow, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wk.mx.Lock()
+	canceled := wk.canceled[req.ID]
+	wk.running[req.ID] = w
+	wk.mx.Unlock()
+	defer func() {
+		wk.mx.Lock()
+		delete(wk.running, req.ID)
+		delete(wk.canceled, req.ID)
+		wk.mx.Unlock()
+	}()
+	if canceled {
+		w.CancelWithReason("step canceled by coordinator")
+	}
+
+	s, err := w.setupRemoteStep(ctx, req)
+	if err == nil {
+		err = s.runOnWorker(ctx)
+	}
+	if err != nil {
+		reply.Err = err.Error()
+	}
+	reply.Resources = w.checkpointResources()
+	if s != nil {
+		reply.Outputs = s.Outputs()
+	}
+	reply.Logs = w.remoteLogs.list()
+	reply.Audit = w.audit.list()
+	reply.APICalls = w.apiCalls.stats()
+	reply.APIErrors = w.Metrics.apiErrorCounts()
+	return nil
+}
+
+// Cancel cancels the step of the request req.ID, now or once it starts.
+func (wk *worker) Cancel(req *RemoteCancelRequest, ok *bool) error {
+	if err := wk.authenticate(req.Token); err != nil {
+		return err
+	}
+	wk.mx.Lock()
+	w := wk.running[req.ID]
+	if w == nil {
+		wk.canceled[req.ID] = true
+	}
+	wk.mx.Unlock()
+	if w != nil {
+		w.CancelWithReason("step canceled by coordinator")
+	}
+	*ok = true
+	return nil
+}
+
+// setupRemoteStep sets w up as the workflow of the run req is from, with
+// its resources and step outputs so far, and returns the step to run.
+func (w *Workflow) setupRemoteStep(ctx context.Context, req *RemoteStepRequest) (*Step, error) {
+	w.Project = req.Project
+	w.Zone = req.Zone
+	w.GCSPath = req.GCSPath
+	w.Verbosity = req.Verbosity
+	for k, v := range req.Vars {
+		w.AddVar(k, v)
+	}
+	// The logs, audit records, API calls and metrics of the step are
+	// returned to the coordinator, which records them.
+	w.remoteLogs = &remoteLogs{}
+	w.Metrics = NewMetrics()
+	// The coordinator runs the workflow, the worker only the step: it
+	// doesn't dispatch steps itself, publish the state of the run, report
+	// errors or write checkpoints.
+	w.Executor = nil
+	w.ErrorReporting = false
+	w.PubSubTopic = ""
+	w.BigQueryTable = ""
+	w.Checkpoint = false
+	w.HashManifest = false
+	// Populating the workflow as a resumed run reuses the run's IDs and
+	// paths.
+	req.State.init()
+	w.resumed = req.State
+	if err := w.Validate(ctx); err != nil {
+		return nil, err
+	}
+	w.syncResources(req.State.Resources, nil)
+
+	s := w.chainStep(req.Chain)
+	if s == nil {
+		return nil, fmt.Errorf("step %q not found in workflow %q", strings.Join(req.Chain, "."), w.Name)
+	}
+	for wf := s.w; wf != nil; wf = wf.parent {
+		for name, st := range wf.Steps {
+			for k, v := range req.Outputs[wf.nestedName(name)] {
+				st.setOutput(k, v)
+			}
+		}
+	}
+	return s, nil
+}
+
+// chainStep returns the step chain names, see Step.getChain, or nil if
+// there is none.
+func (w *Workflow) chainStep(chain []string) *Step {
+	wf := w
+	for i, name := range chain {
+		s, ok := wf.Steps[name]
+		if !ok {
+			return nil
+		}
+		if i == len(chain)-1 {
+			return s
+		}
+		if wf = s.expandedWorkflow(); wf == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// runOnWorker runs s for a coordinator.
+func (s *Step) runOnWorker(ctx context.Context) error {
+	impl, err := s.stepImpl()
+	if err != nil {
+		return err
+	}
+	if err := s.substituteOutputs(); err != nil {
+		return err
+	}
+	root := s.w.root()
+	s.w.logger.Printf("Running step %q (%s) of run %q", s.name, s.typeName(), s.w.id)
+	root.remoteLogs.start()
+	hooks := root.countCleanupHooks()
+	err = impl.run(ctx, s)
+	// Cleanup runs on the coordinator, the hooks of the worker are lost.
+	if root.countCleanupHooks() != hooks && err == nil {
+		err = fmt.Errorf("step %q (%s) registered cleanup hooks, it must run on the coordinator", s.name, s.typeName())
+	}
+	return err
+}
+
+// countCleanupHooks returns the number of cleanup hooks of w and its child
+// workflows.
+func (w *Workflow) countCleanupHooks() int {
+	var n int
+	w.walkWorkflows(func(wf *Workflow) {
+		wf.cleanupHooksMx.Lock()
+		n += len(wf.cleanupHooks)
+		wf.cleanupHooksMx.Unlock()
+	})
+	return n
+}
+
+// remoteLogs records the logs of the step a worker runs, once started, for
+// the coordinator to log.
+type remoteLogs struct {
+	entries []*LogEntry
+	started bool
+	mx      sync.Mutex
+}
+
+func (r *remoteLogs) start() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.started = true
+}
+
+func (r *remoteLogs) list() []*LogEntry {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append([]*LogEntry{}, r.entries...)
+}
+
+func (r *remoteLogs) add(w *Workflow, severity, step, stepType, msg string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.started {
+		r.entries = append(r.entries, &LogEntry{
+			Timestamp:  time.Now().UTC(),
+			Severity:   severity,
+			Workflow:   w.qualifiedName(),
+			WorkflowID: w.root().id,
+			Step:       step,
+			StepType:   stepType,
+			Message:    msg,
+		})
+	}
+}
+
+func (r *remoteLogs) WorkflowDebug(w *Workflow, msg string) {
+	r.add(w, SeverityDebug, "", "", msg)
+}
+
+func (r *remoteLogs) WorkflowInfo(w *Workflow, msg string) {
+	r.add(w, SeverityInfo, "", "", msg)
+}
+
+func (r *remoteLogs) WorkflowError(w *Workflow, msg string) {
+	r.add(w, SeverityError, "", "", msg)
+}
+
+func (r *remoteLogs) StepDebug(w *Workflow, step, stepType, msg string) {
+	r.add(w, SeverityDebug, step, stepType, msg)
+}
+
+func (r *remoteLogs) StepInfo(w *Workflow, step, stepType, msg string) {
+	r.add(w, SeverityInfo, step, stepType, msg)
+}
+
+func (r *remoteLogs) StepError(w *Workflow, step, stepType string, err error) {
+	r.add(w, SeverityError, step, stepType, err.Error())
+}
@@ -0,0 +1,465 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestRemoteExecutor(t *testing.T) {
+	var mx sync.Mutex
+	var got []string
+	record := func(format string, a ...interface{}) {
+		mx.Lock()
+		defer mx.Unlock()
+		got = append(got, fmt.Sprintf(format, a...))
+	}
+	var checkErr error
+	// newWorkflow returns the workflow as the coordinator, or a worker,
+	// sees it.
+	newWorkflow := func(side string) *Workflow {
+		w := testWorkflow()
+		c, _ := newTestGCEClient()
+		c.CreateDiskFn = func(_, _ string, d *compute.Disk) error {
+			record("%s: create %s", side, d.Name)
+			return nil
+		}
+		c.DeleteDiskFn = func(_, _, n string) error {
+			record("%s: delete %s", side, n)
+			return nil
+		}
+		w.ComputeClient = c
+		w.AddVar("v", side)
+		w.NewCreateDisksStep("create", &CreateDisk{Disk: compute.Disk{Name: "d1"}, SizeGb: "10"})
+		w.Steps["check"] = &Step{name: "check", w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			record("%s: check %s %s", side, s.w.Vars["v"].Value, s.outputStep("create").Outputs()["d1"])
+			return checkErr
+		}}}
+		w.Dependencies["check"] = []string{"create"}
+		return w
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go ServeWorker(l, WorkerAuth{Token: "token"}, func(name string) (*Workflow, error) {
+		if name != "wf.json" {
+			return nil, fmt.Errorf("unknown workflow %q", name)
+		}
+		return newWorkflow("worker"), nil
+	})
+
+	e, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	w := newWorkflow("coordinator")
+	w.Executor = e
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d1 := w.genName("d1")
+	link := fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, d1)
+	// The steps run on the worker, with the vars and outputs of the run.
+	// Cleanup runs on the coordinator, which knows of the disk.
+	want := []string{
+		"worker: create " + d1,
+		"worker: check coordinator " + link,
+		"coordinator: delete " + d1,
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("calls do not match expectation: (-got +want)\n%s", diff)
+	}
+	if got := w.Steps["create"].Outputs()["d1"]; got != link {
+		t.Errorf("unexpected output of step create, got: %q, want: %q", got, link)
+	}
+
+	// Errors of steps fail the run.
+	got = nil
+	checkErr = errors.New("check failed")
+	w = newWorkflow("coordinator")
+	w.Executor = e
+	if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "check failed") {
+		t.Errorf("expected error of step check, got: %v", err)
+	}
+	if len(got) == 0 || got[len(got)-1] != "coordinator: delete "+w.genName("d1") {
+		t.Errorf("disk created on the worker not cleaned up, calls: %q", got)
+	}
+}
+
+func TestNewRemoteExecutorErrors(t *testing.T) {
+	if _, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}); err == nil {
+		t.Error("expected error without workers")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	if _, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}, addr); err == nil {
+		t.Error("expected error connecting to a worker that isn't listening")
+	}
+	if _, err := NewRemoteExecutor("wf.json", WorkerAuth{}, addr); err == nil {
+		t.Error("expected error without a token")
+	}
+	if err := ServeWorker(nil, WorkerAuth{}, nil); err == nil {
+		t.Error("expected error serving without a token")
+	}
+}
+
+// testWorkerCert returns a self-signed certificate for 127.0.0.1, and a
+// pool trusting it.
+func testWorkerCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "daisy-worker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestRemoteExecutorAuth(t *testing.T) {
+	cert, pool := testWorkerCert(t)
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	clientTLS := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}
+
+	tests := []struct {
+		desc           string
+		server, client WorkerAuth
+		refused        bool
+	}{
+		{"token case", WorkerAuth{Token: "token"}, WorkerAuth{Token: "token"}, false},
+		{"bad token case", WorkerAuth{Token: "token"}, WorkerAuth{Token: "bad"}, true},
+		{"mutual TLS case", WorkerAuth{Token: "token", TLS: serverTLS}, WorkerAuth{Token: "token", TLS: clientTLS}, false},
+		{"no TLS case", WorkerAuth{Token: "token", TLS: serverTLS}, WorkerAuth{Token: "token"}, true},
+		{"no client certificate case", WorkerAuth{Token: "token", TLS: serverTLS}, WorkerAuth{Token: "token", TLS: &tls.Config{RootCAs: pool}}, true},
+		{"untrusted worker case", WorkerAuth{Token: "token", TLS: serverTLS}, WorkerAuth{Token: "token", TLS: &tls.Config{Certificates: []tls.Certificate{cert}}}, true},
+	}
+
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ran int32
+		go ServeWorker(l, tt.server, func(string) (*Workflow, error) {
+			w := testWorkflow()
+			w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+				atomic.StoreInt32(&ran, 1)
+				return nil
+			}}}
+			return w, nil
+		})
+
+		e, err := NewRemoteExecutor("wf.json", tt.client, l.Addr().String())
+		if err == nil {
+			w := testWorkflow()
+			w.Executor = e
+			w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{}}
+			err = w.Run(context.Background())
+			e.Close()
+		}
+		l.Close()
+		if refused := err != nil || atomic.LoadInt32(&ran) == 0; refused != tt.refused {
+			t.Errorf("%s: worker refused the step: %t, want: %t, error: %v", tt.desc, refused, tt.refused, err)
+		}
+	}
+}
+
+// startTestWorker serves a worker loading workflows with load, with the
+// token "token", until the test ends.
+func startTestWorker(t *testing.T, load func(string) (*Workflow, error)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go ServeWorker(l, WorkerAuth{Token: "token"}, load)
+	return l.Addr().String()
+}
+
+func TestRemoteExecutorRecordsWorker(t *testing.T) {
+	addr := startTestWorker(t, func(string) (*Workflow, error) {
+		w := testWorkflow()
+		// As a workflow read from a file.
+		w.logger = nil
+		w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			s.w.logger.StepInfo(s, "hello from %s", "worker")
+			s.w.logger.StepDebug(s, "debug from worker")
+			s.w.root().audit.add(&auditRecord{Method: "POST", API: "compute", Resource: "projects/p/zones/z/disks/d"})
+			s.w.root().apiCalls.record("POST compute/v1/projects/*/zones/*/disks", 200, time.Second)
+			s.w.metrics().countAPIError("quotaExceeded")
+			return nil
+		}}}
+		return w, nil
+	})
+	e, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	w := testWorkflow()
+	w.logger = nil
+	rec := &recordingLogger{}
+	w.SetLogger(rec)
+	w.Metrics = NewMetrics()
+	w.Executor = e
+	w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{}}
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Info logs are recorded, debug logs aren't at the run's verbosity.
+	var logged, debug bool
+	for _, l := range rec.logs {
+		logged = logged || strings.Contains(l, "s (mockStep): hello from worker")
+		debug = debug || strings.Contains(l, "debug from worker")
+	}
+	if !logged || debug {
+		t.Errorf("logs of the worker not recorded as expected, logs: %q", rec.logs)
+	}
+	if diff := pretty.Compare(w.audit.list(), []*auditRecord{{Method: "POST", API: "compute", Resource: "projects/p/zones/z/disks/d"}}); diff != "" {
+		t.Errorf("audit records of the worker not recorded as expected: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.apiCalls.stats(), []*APICallStats{{Endpoint: "POST compute/v1/projects/*/zones/*/disks", Code: 200, Count: 1, TotalLatency: time.Second, MaxLatency: time.Second}}); diff != "" {
+		t.Errorf("API calls of the worker not recorded as expected: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.Metrics.apiErrorCounts(), map[string]int{"quotaExceeded": 1}); diff != "" {
+		t.Errorf("API errors of the worker not counted as expected: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRemoteExecutorCoordinatorOnly(t *testing.T) {
+	var mx sync.Mutex
+	var ran []string
+	addr := startTestWorker(t, func(string) (*Workflow, error) {
+		w := testWorkflow()
+		w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			mx.Lock()
+			ran = append(ran, s.name)
+			mx.Unlock()
+			return nil
+		}}}
+		w.Steps["hook"] = &Step{name: "hook", w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			s.w.root().addCleanupHook(func() error { return nil })
+			return nil
+		}}}
+		return w, nil
+	})
+	e, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	// Workflows with a Policy don't run on workers, they can't check it.
+	w := testWorkflow()
+	w.Executor = e
+	w.Policy = PolicyFunc(func(*Workflow, *PlannedResource) error { return nil })
+	w.Steps["s"] = &Step{name: "s", w: w, testType: &mockStep{}}
+	if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "Policy") {
+		t.Errorf("expected error running a workflow with a Policy, got: %v", err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("steps of a workflow with a Policy ran on the worker: %q", ran)
+	}
+
+	// Steps registering cleanup hooks on a worker fail, the hooks would
+	// never run.
+	w = testWorkflow()
+	w.Executor = e
+	w.Steps["hook"] = &Step{name: "hook", w: w, testType: &mockStep{}}
+	if err := w.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "registered cleanup hooks") {
+		t.Errorf("expected error of a step registering cleanup hooks on a worker, got: %v", err)
+	}
+
+	tests := []struct {
+		desc string
+		s    *Step
+		want bool
+	}{
+		{"normal case", &Step{CreateDisks: &CreateDisks{}}, true},
+		{"RunAlways case", &Step{CreateDisks: &CreateDisks{}, RunAlways: true}, false},
+		{"GrantRoles case", &Step{GrantRoles: &GrantRoles{}}, false},
+		{"SubWorkflow case", &Step{SubWorkflow: &SubWorkflow{}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.runsRemotely(); got != tt.want {
+			t.Errorf("%s: runsRemotely() = %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+// killableListener is a net.Listener whose connections can be closed, as
+// if its worker crashed.
+type killableListener struct {
+	net.Listener
+	mx    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *killableListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mx.Lock()
+		l.conns = append(l.conns, c)
+		l.mx.Unlock()
+	}
+	return c, err
+}
+
+func (l *killableListener) kill() {
+	l.Close()
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+}
+
+func TestRemoteExecutorWorkerDies(t *testing.T) {
+	started := make(chan string, 10)
+	done := make(chan struct{})
+	proceed := map[string]chan struct{}{"a": make(chan struct{}), "b": make(chan struct{})}
+	listeners := map[string]*killableListener{}
+	workers := map[string]*worker{}
+	var addrs []string
+	for _, name := range []string{"a", "b"} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		kl := &killableListener{Listener: l}
+		defer kl.kill()
+		listeners[name] = kl
+		addrs = append(addrs, l.Addr().String())
+		// The workflows the worker loads, one per step, are created here:
+		// workers in the same process creating workflows while another
+		// runs isn't safe.
+		wfs := make(chan *Workflow, 2)
+		for i := 0; i < cap(wfs); i++ {
+			w := testWorkflow()
+			for _, st := range []string{"s1", "s2"} {
+				name := name
+				w.Steps[st] = &Step{name: st, w: w, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+					started <- name
+					select {
+					case <-proceed[name]:
+					case <-done:
+					}
+					return nil
+				}}}
+			}
+			wfs <- w
+		}
+		wk := newWorker("token", func(string) (*Workflow, error) { return <-wfs, nil })
+		workers[name] = wk
+		go wk.serve(kl)
+	}
+	e, err := NewRemoteExecutor("wf.json", WorkerAuth{Token: "token"}, addrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	newWorkflow := func(steps ...string) *Workflow {
+		w := testWorkflow()
+		w.Executor = e
+		for _, st := range steps {
+			w.Steps[st] = &Step{name: st, w: w, testType: &mockStep{}}
+		}
+		return w
+	}
+
+	// Steps go to the first free worker, a, which dies while the step
+	// runs. That fails the step.
+	errc := make(chan error)
+	go func() { errc <- newWorkflow("s1").Run(context.Background()) }()
+	if got := <-started; got != "a" {
+		t.Fatalf("step sent to worker %q, want a", got)
+	}
+	listeners["a"].kill()
+	close(proceed["b"])
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), addrs[0]) {
+		t.Errorf("expected error of the step on the dead worker %s, got: %v", addrs[0], err)
+	}
+
+	// The steps left run on worker b.
+	if err := newWorkflow("s1", "s2").Run(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-started; got != "b" {
+			t.Errorf("step sent to worker %q, want b", got)
+		}
+	}
+
+	// Without workers, steps fail instead of waiting for one.
+	listeners["b"].kill()
+	if err := newWorkflow("s1").Run(context.Background()); err == nil {
+		t.Error("expected error running a step once all workers are dead")
+	}
+	if err := newWorkflow("s1").Run(context.Background()); err == nil || !strings.Contains(err.Error(), "no workers left") {
+		t.Errorf("expected error without workers left, got: %v", err)
+	}
+
+	// The step of worker a still runs, wait for it to return.
+	close(done)
+	workers["a"].runMx.Lock()
+	workers["a"].runMx.Unlock()
+}
@@ -19,13 +19,19 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/api/googleapi"
 )
 
 type resource struct {
-	real, link         string
-	noCleanup, deleted bool
+	real, link                  string
+	noCleanup, deleted, created bool
+	// deleting is set while a delete call for the resource is in flight.
+	deleting bool
+	// createdAt and deletedAt are when the workflow created and deleted
+	// the resource, zero if it didn't.
+	createdAt, deletedAt time.Time
 
 	creator, deleter *Step
 	users            []*Step
@@ -55,11 +61,17 @@ func (rm *baseResourceMap) init() {
 }
 
 func (rm *baseResourceMap) cleanup() {
-	var wg sync.WaitGroup
+	rm.mx.Lock()
+	var names []string
 	for name, r := range rm.m {
-		if r.noCleanup || r.deleted {
-			continue
+		if !r.noCleanup && !r.deleted {
+			names = append(names, name)
 		}
+	}
+	rm.mx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, name := range names {
 		wg.Add(1)
 		go func(name string) {
 			defer wg.Done()
@@ -75,19 +87,30 @@ func (rm *baseResourceMap) cleanup() {
 
 func (rm *baseResourceMap) delete(name string) error {
 	rm.mx.Lock()
-	defer rm.mx.Unlock()
-	if r, ok := rm.m[name]; ok {
-		if r.deleted {
-			return fmt.Errorf("cannot delete %q; already deleted", name)
-		}
-		if err := rm.deleteFn(r); err != nil {
-			return err
-		}
-		r.deleted = true
-		return nil
-	} else {
+	r, ok := rm.m[name]
+	if !ok {
+		rm.mx.Unlock()
 		return fmt.Errorf("cannot delete %q; does not exist in resource map", name)
 	}
+	if r.deleted || r.deleting {
+		rm.mx.Unlock()
+		return fmt.Errorf("cannot delete %q; already deleted", name)
+	}
+	r.deleting = true
+	rm.mx.Unlock()
+
+	// The map isn't locked during the API call, so that the resources of
+	// a map are deleted in parallel.
+	err := rm.deleteFn(r)
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+	r.deleting = false
+	if err != nil {
+		return err
+	}
+	r.deleted = true
+	r.deletedAt = time.Now()
+	return nil
 }
 
 func (rm *baseResourceMap) get(name string) (*resource, bool) {
@@ -97,6 +120,76 @@ func (rm *baseResourceMap) get(name string) (*resource, bool) {
 	return r, ok
 }
 
+// uptime returns how long the resources the workflow created in the map
+// existed, until now for those it didn't delete.
+func (rm *baseResourceMap) uptime(now time.Time) time.Duration {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+	var d time.Duration
+	for _, r := range rm.m {
+		if r.createdAt.IsZero() {
+			continue
+		}
+		end := now
+		if !r.deletedAt.IsZero() {
+			end = r.deletedAt
+		}
+		d += end.Sub(r.createdAt)
+	}
+	return d
+}
+
+// keptLinks returns the links of the resources created in the map that
+// are kept after the workflow finishes.
+func (rm *baseResourceMap) keptLinks() []string {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+	var links []string
+	for _, r := range rm.m {
+		if r.created && !r.deleted && r.noCleanup {
+			links = append(links, r.link)
+		}
+	}
+	return links
+}
+
+// markCreated records that the resource known by name now exists in GCE.
+func (rm *baseResourceMap) markCreated(name string) {
+	rm.mx.Lock()
+	r, ok := rm.m[name]
+	if ok {
+		r.created = true
+		r.createdAt = time.Now()
+	}
+	rm.mx.Unlock()
+	if ok && r.creator != nil {
+		r.creator.setOutput(name, r.link)
+	}
+	if rm.w != nil {
+		if ok {
+			rm.w.publishResourceCreated(r.creator, rm.typeName, r.link)
+			rm.w.metrics().countResourceCreated(rm.typeName)
+		}
+		rm.w.saveCheckpoint()
+	}
+}
+
+// addCreated records r, known by name, as created by s while it runs, so
+// that cleanup deletes it if s doesn't. It replaces a deleted resource
+// known by the same name.
+func (rm *baseResourceMap) addCreated(name string, r *resource, s *Step) error {
+	rm.mx.Lock()
+	if old, ok := rm.m[name]; ok && !old.deleted {
+		rm.mx.Unlock()
+		return fmt.Errorf("cannot create %s %q; already exists", rm.typeName, name)
+	}
+	r.creator = s
+	rm.m[name] = r
+	rm.mx.Unlock()
+	rm.markCreated(name)
+	return nil
+}
+
 func (rm *baseResourceMap) registerCreation(name string, r *resource, s *Step) error {
 	// Create a resource reference, known by name. Check:
 	// - no duplicates known by name
@@ -190,6 +283,13 @@ func initWorkflowResources(w *Workflow) {
 	initDiskMap(w)
 	initImageMap(w)
 	initInstanceMap(w)
+	initNetworkMap(w)
+	initSnapshotMap(w)
+	initFirewallRuleMap(w)
+	initSubnetworkMap(w)
+	initAddressMap(w)
+	initResourcePolicyMap(w)
+	initBucketMap(w)
 	w.addCleanupHook(resourceCleanupHook(w))
 }
 
@@ -197,17 +297,54 @@ func shareWorkflowResources(giver, taker *Workflow) {
 	disks[taker] = disks[giver]
 	images[taker] = images[giver]
 	instances[taker] = instances[giver]
+	networks[taker] = networks[giver]
+	snapshots[taker] = snapshots[giver]
+	firewallRules[taker] = firewallRules[giver]
+	subnetworks[taker] = subnetworks[giver]
+	addresses[taker] = addresses[giver]
+	resourcePolicies[taker] = resourcePolicies[giver]
+	buckets[taker] = buckets[giver]
 }
 
 func resourceCleanupHook(w *Workflow) func() error {
 	return func() error {
-		images[w].cleanup()
-		instances[w].cleanup()
-		disks[w].cleanup()
+		if w.cleanupDryRun() || w.keepForResume() {
+			return nil
+		}
+		for _, phase := range cleanupPhases(w) {
+			var wg sync.WaitGroup
+			for _, rm := range phase {
+				wg.Add(1)
+				go func(rm *baseResourceMap) {
+					defer wg.Done()
+					rm.cleanup()
+				}(rm)
+			}
+			wg.Wait()
+		}
 		return nil
 	}
 }
 
+// cleanupPhases returns the resource maps of w in the order cleanup deletes
+// them. The maps of a phase are cleaned up in parallel, a phase starts once
+// the previous phase is done, as its resources may be in use until then.
+func cleanupPhases(w *Workflow) [][]*baseResourceMap {
+	return [][]*baseResourceMap{
+		{&instances[w].baseResourceMap, &images[w].baseResourceMap, &snapshots[w].baseResourceMap, &firewallRules[w].baseResourceMap},
+		// Disks, resource policies and addresses can only be deleted once
+		// the instances using them are gone, buckets once the instances
+		// writing to them are.
+		{&disks[w].baseResourceMap, &resourcePolicies[w].baseResourceMap, &addresses[w].baseResourceMap, &buckets[w].baseResourceMap},
+		// Subnetworks can only be deleted once the instances and addresses
+		// in them are gone.
+		{&subnetworks[w].baseResourceMap},
+		// Networks can only be deleted once the instances, firewall rules
+		// and subnetworks using them are gone.
+		{&networks[w].baseResourceMap},
+	}
+}
+
 func extendPartialURL(url, project string) string {
 	if strings.HasPrefix(url, "projects") {
 		return url
@@ -215,6 +352,30 @@ func extendPartialURL(url, project string) string {
 	return fmt.Sprintf("projects/%s/%s", project, url)
 }
 
+// gceAPIURLRgx matches the API prefix of full GCE resource URLs.
+var gceAPIURLRgx = regexp.MustCompile(`^https?://(www|compute)\.googleapis\.com/compute/(v1|beta|alpha)/`)
+
+// normalizeURL resolves a GCE resource reference given in any of the forms
+// gcloud accepts:
+//   - full URLs, "https://www.googleapis.com/compute/v1/projects/p/zones/z/disks/d"
+//   - partial URLs, "projects/p/zones/z/disks/d" or "zones/z/disks/d"
+//   - names, "d", if scope is not empty, e.g. "zones/z/diskTypes"
+//
+// URLs matching rgx, and names, are returned as partial URLs with a leading
+// "projects/<project>/". Any other reference is returned unchanged, it may
+// be the name of a resource created by the workflow and is resolved during
+// validation.
+func normalizeURL(ref string, rgx *regexp.Regexp, project, scope string) string {
+	url := gceAPIURLRgx.ReplaceAllString(ref, "")
+	if rgx.MatchString(url) {
+		return extendPartialURL(url, project)
+	}
+	if scope != "" && checkName(url) {
+		return fmt.Sprintf("projects/%s/%s/%s", project, scope, url)
+	}
+	return ref
+}
+
 func resourceNameHelper(name string, w *Workflow, exactName bool) string {
 	if !exactName {
 		name = w.genName(name)
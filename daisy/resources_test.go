@@ -17,10 +17,12 @@ package daisy
 import (
 	"errors"
 	"reflect"
+	"regexp"
 	"sync"
 	"testing"
 	"time"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"github.com/kylelemons/godebug/pretty"
 )
 
@@ -35,6 +37,41 @@ func TestExtendPartialURL(t *testing.T) {
 	}
 }
 
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		desc, ref   string
+		rgx         *regexp.Regexp
+		scope, want string
+	}{
+		{"disk name", "d", diskURLRgx, "", "d"},
+		{"disk zonal path", "zones/z/disks/d", diskURLRgx, "", "projects/foo/zones/z/disks/d"},
+		{"disk project path", "projects/p/zones/z/disks/d", diskURLRgx, "", "projects/p/zones/z/disks/d"},
+		{"disk full URL", "https://www.googleapis.com/compute/v1/projects/p/zones/z/disks/d", diskURLRgx, "", "projects/p/zones/z/disks/d"},
+		{"disk beta URL", "https://compute.googleapis.com/compute/beta/projects/p/zones/z/disks/d", diskURLRgx, "", "projects/p/zones/z/disks/d"},
+		{"image global path", "global/images/i", imageURLRgx, "", "projects/foo/global/images/i"},
+		{"image family path", "projects/p/global/images/family/f", imageURLRgx, "", "projects/p/global/images/family/f"},
+		{"image family full URL", "https://www.googleapis.com/compute/v1/projects/p/global/images/family/f", imageURLRgx, "", "projects/p/global/images/family/f"},
+		{"instance full URL", "https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/i", instanceURLRgx, "", "projects/p/zones/z/instances/i"},
+		{"network name", "n", networkURLRegex, "", "n"},
+		{"network full URL", "https://www.googleapis.com/compute/v1/projects/p/global/networks/n", networkURLRegex, "", "projects/p/global/networks/n"},
+		{"subnetwork name", "s", subnetworkURLRegex, "regions/r/subnetworks", "projects/foo/regions/r/subnetworks/s"},
+		{"subnetwork regional path", "regions/r/subnetworks/s", subnetworkURLRegex, "regions/x/subnetworks", "projects/foo/regions/r/subnetworks/s"},
+		{"machine type name", "n1-standard-1", machineTypeURLRegex, "zones/z/machineTypes", "projects/foo/zones/z/machineTypes/n1-standard-1"},
+		{"machine type full URL", "https://www.googleapis.com/compute/v1/projects/p/zones/z/machineTypes/mt", machineTypeURLRegex, "zones/x/machineTypes", "projects/p/zones/z/machineTypes/mt"},
+		{"disk type name", "pd-ssd", diskTypeURLRgx, "zones/z/diskTypes", "projects/foo/zones/z/diskTypes/pd-ssd"},
+		{"wrong resource type", "zones/z/instances/i", diskURLRgx, "", "zones/z/instances/i"},
+		{"bad name with scope", "Bad_Name", diskTypeURLRgx, "zones/z/diskTypes", "Bad_Name"},
+		{"unknown API host", "https://example.com/compute/v1/projects/p/zones/z/disks/d", diskURLRgx, "", "https://example.com/compute/v1/projects/p/zones/z/disks/d"},
+		{"empty", "", subnetworkURLRegex, "regions/r/subnetworks", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeURL(tt.ref, tt.rgx, "foo", tt.scope); got != tt.want {
+			t.Errorf("%s: got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
 func TestResourceNameHelper(t *testing.T) {
 	w := testWorkflow()
 	want := w.genName("foo")
@@ -74,6 +111,51 @@ func TestResourceCleanup(t *testing.T) {
 	}
 }
 
+func TestResourceCleanupOrder(t *testing.T) {
+	w := testWorkflow()
+	instances[w].m = map[string]*resource{
+		"in1": {real: "in1", link: "projects/p/zones/z/instances/in1"},
+		"in2": {real: "in2", link: "projects/p/zones/z/instances/in2"},
+	}
+	disks[w].m = map[string]*resource{"d": {real: "d", link: "projects/p/zones/z/disks/d"}}
+	networks[w].m = map[string]*resource{"n": {real: "n", link: "projects/p/global/networks/n"}}
+
+	var mx sync.Mutex
+	var order []string
+	record := func(n string) {
+		mx.Lock()
+		defer mx.Unlock()
+		order = append(order, n)
+	}
+	// Each instance deletion waits for the other one to start, which only
+	// happens if instances are deleted in parallel.
+	started := make(chan struct{}, 2)
+	w.ComputeClient = &daisyCompute.TestClient{
+		DeleteInstanceFn: func(_, _, n string) error {
+			started <- struct{}{}
+			for len(started) < 2 {
+				select {
+				case <-time.After(5 * time.Second):
+					return errors.New("instances not deleted in parallel")
+				default:
+					time.Sleep(time.Millisecond)
+				}
+			}
+			record("instance")
+			return nil
+		},
+		DeleteDiskFn:    func(_, _, n string) error { record("disk"); return nil },
+		DeleteNetworkFn: func(_, n string) error { record("network"); return nil },
+	}
+
+	w.cleanup()
+
+	want := []string{"instance", "instance", "disk", "network"}
+	if diff := pretty.Compare(order, want); diff != "" {
+		t.Errorf("unexpected deletion order: (-got +want)\n%s", diff)
+	}
+}
+
 func TestResourceMapDelete(t *testing.T) {
 	var deleteFnErr error
 	rm := &baseResourceMap{m: map[string]*resource{}}
@@ -105,6 +187,10 @@ func TestResourceMapDelete(t *testing.T) {
 		}
 	}
 
+	if rm.m["foo"].deletedAt.IsZero() {
+		t.Error("deleted resource has no deletion time")
+	}
+	rm.m["foo"].deletedAt = time.Time{}
 	wantM := map[string]*resource{"foo": {deleted: true}, "baz": {deleted: false}}
 	if diff := pretty.Compare(rm.m, wantM); diff != "" {
 		t.Errorf("resourceMap not modified as expected: (-got,+want)\n%s", diff)
@@ -0,0 +1,392 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// RunResult describes what a workflow run has accomplished. It can be
+// retrieved at any time, including after the workflow was canceled, to
+// account for what was built and what needs cleanup verification.
+type RunResult struct {
+	// Canceled is true if the workflow was canceled.
+	Canceled bool
+	// CancelReason is the reason given when the workflow was canceled.
+	CancelReason string
+	// CompletedSteps lists the steps that finished successfully, in order
+	// of completion. Steps of IncludeWorkflow, ForEach and SubWorkflow steps
+	// are prefixed with the name of the step that ran them, e.g. "sub.step".
+	CompletedSteps []string
+	// Resources lists the GCE resources the workflow created.
+	Resources []*CreatedResource
+	// APICalls summarizes the compute and storage API calls made by the
+	// workflow's clients, by endpoint and result code. Calls are only
+	// recorded for clients created by the workflow or with
+	// Workflow.APIClientOptions.
+	APICalls []*APICallStats
+	// Cleanup lists the resources the workflow's cleanup deleted and kept.
+	// It is nil until cleanup ran.
+	Cleanup *CleanupReport
+	// FailedSteps lists the steps with ContinueOnError set that failed, in
+	// order of failure.
+	FailedSteps []*StepFailure
+	// Steps lists the steps that ran, in the order they returned, with
+	// their queue times, durations and outcomes.
+	Steps []*StepResult
+	// InstanceHours estimates the billable instance-hours of the run: how
+	// long the instances the workflow created existed, until now for those
+	// it didn't delete.
+	InstanceHours float64
+	// SkippedValidations lists the validation checks that were skipped as
+	// set in SkipValidations, e.g. "zones".
+	SkippedValidations []string
+	// LogsPath is the GCS path the workflow's logs are written to, as in
+	// the ${LOGSPATH} autovar. OutsPath is the GCS path for the
+	// workflow's outputs, as in the ${OUTSPATH} autovar. They are empty
+	// until the workflow is populated.
+	LogsPath, OutsPath string
+	// LogsFallback tells why the logs weren't written to LogsPath, as
+	// GCS logging failed when the workflow started and GCSLoggingPolicy
+	// fell back to stdout. It is empty if they were.
+	LogsFallback string
+	// Outputs are the rendered Outputs of the workflow, and its
+	// well-known outputs, e.g. ImageURIOutput. They are nil unless the
+	// workflow succeeded.
+	Outputs map[string]string
+	// ManifestHash is the hash of the inputs of the workflow the images it
+	// created are labeled with, set once the workflow is validated if
+	// HashManifest is set.
+	ManifestHash string
+}
+
+// Kept returns the resources the workflow created that it doesn't delete,
+// e.g. the images it built, as NoCleanup is set.
+func (r *RunResult) Kept() []*CreatedResource {
+	var kept []*CreatedResource
+	for _, cr := range r.Resources {
+		if cr.NoCleanup && !cr.Deleted {
+			kept = append(kept, cr)
+		}
+	}
+	return kept
+}
+
+// StepResult is the outcome of a step that ran.
+type StepResult struct {
+	// Step is the name of the step, prefixed as in CompletedSteps.
+	Step string
+	// Type is the step type, e.g. "CreateDisks".
+	Type string
+	// State is StepFinished, StepFailed or StepCanceled.
+	State StepState
+	// QueueTime is how long the step waited to start once its dependencies
+	// were done, e.g. for MaxParallelSteps or the uploads of its sources.
+	QueueTime time.Duration
+	// Start is when the step started, Duration how long it ran.
+	Start    time.Time
+	Duration time.Duration
+	// Error is the error the step failed with, if any.
+	Error string `json:",omitempty"`
+}
+
+// addStepResult records the result of a run of s, which was queued at
+// queued, started at start and returned err, for the top level workflow's
+// RunResult.
+func (w *Workflow) addStepResult(s *Step, queued, start, end time.Time, err error) {
+	sr := &StepResult{Step: w.nestedName(s.name), Type: s.typeName(), State: StepFinished, QueueTime: start.Sub(queued), Start: start, Duration: end.Sub(start)}
+	if err != nil {
+		sr.State = StepFailed
+		sr.Error = err.Error()
+	} else {
+		select {
+		case <-w.Cancel:
+			sr.State = StepCanceled
+		default:
+		}
+	}
+	w.metrics().observeStep(sr)
+	root := w.root()
+	root.stepResultsMx.Lock()
+	defer root.stepResultsMx.Unlock()
+	root.stepResults = append(root.stepResults, sr)
+}
+
+// RunWithResult runs the workflow like Run, and returns its RunResult,
+// e.g. to find the images it built without parsing its logs. The result is
+// returned whether the workflow failed or not.
+func (w *Workflow) RunWithResult(ctx context.Context) (*RunResult, error) {
+	err := w.Run(ctx)
+	return w.Result(), err
+}
+
+// StepFailure is the failure of a step with ContinueOnError set.
+type StepFailure struct {
+	// Step is the name of the step, prefixed as in CompletedSteps.
+	Step string
+	// Error is the error the step failed with.
+	Error string
+}
+
+// continuedFailure records the failure of s, a ContinueOnError step, for
+// the top level workflow's RunResult.
+func (w *Workflow) continuedFailure(s *Step, err error) {
+	root := w.root()
+	root.stepFailuresMx.Lock()
+	defer root.stepFailuresMx.Unlock()
+	root.stepFailures = append(root.stepFailures, &StepFailure{Step: w.nestedName(s.name), Error: err.Error()})
+}
+
+// CreatedResource is a GCE resource created by a workflow.
+type CreatedResource struct {
+	// Type is the resource type, e.g. "disk".
+	Type string
+	// Name is the name of the resource as known to the workflow.
+	Name string
+	// Link is the partial URL of the resource.
+	Link string
+	// NoCleanup is true if the resource will not be deleted on cleanup.
+	NoCleanup bool
+	// Deleted is true if the resource has since been deleted.
+	Deleted bool
+}
+
+// CleanupReport lists the resources created by a workflow that its cleanup
+// deletes, and those it keeps.
+type CleanupReport struct {
+	// DryRun is true if cleanup deleted nothing, see Workflow.CleanupDryRun.
+	DryRun bool
+	// Delete lists the resources cleanup deletes, or would delete if
+	// DryRun is set.
+	Delete []*CreatedResource
+	// Keep lists the resources cleanup doesn't delete, e.g. as NoCleanup is
+	// set or as they are auto-deleted with their instance.
+	Keep []*CreatedResource
+	// Leaked lists the resources of the run that were still there after
+	// cleanup, if VerifyCleanup or RetryCleanup is set. Deleted is true for
+	// those RetryCleanup deleted.
+	Leaked []*OrphanedResource
+}
+
+func newCleanupReport(rms []*baseResourceMap) *CleanupReport {
+	cr := &CleanupReport{}
+	for _, rm := range rms {
+		for _, r := range rm.createdResources() {
+			switch {
+			case r.Deleted:
+			case r.NoCleanup:
+				cr.Keep = append(cr.Keep, r)
+			default:
+				cr.Delete = append(cr.Delete, r)
+			}
+		}
+	}
+	return cr
+}
+
+// add adds the resources of o not in cr yet.
+func (cr *CleanupReport) add(o *CleanupReport) {
+	seen := map[string]bool{}
+	for _, r := range append(cr.Delete, cr.Keep...) {
+		seen[r.Link] = true
+	}
+	for _, r := range o.Delete {
+		if !seen[r.Link] {
+			cr.Delete = append(cr.Delete, r)
+		}
+	}
+	for _, r := range o.Keep {
+		if !seen[r.Link] {
+			cr.Keep = append(cr.Keep, r)
+		}
+	}
+}
+
+// CleanupReport returns the resources cleanup of w and its subworkflows
+// would delete and keep if it ran now.
+func (w *Workflow) CleanupReport() *CleanupReport {
+	cr := newCleanupReport(w.resourceMaps())
+	cr.DryRun = w.cleanupDryRun()
+	return cr
+}
+
+func (w *Workflow) cleanupDryRun() bool {
+	return w.root().CleanupDryRun
+}
+
+// reportCleanup logs the resources cleanup of w deletes and keeps, and
+// records them for the top level workflow's RunResult.
+func (w *Workflow) reportCleanup() {
+	cr := newCleanupReport(w.ownResourceMaps())
+	verb := "deleting"
+	if w.cleanupDryRun() {
+		verb = "dry run, not deleting"
+	} else if w.keepForResume() {
+		verb = "keeping for resume, not deleting"
+	}
+	for _, r := range cr.Delete {
+		w.logger.Printf("Cleanup: %s %s %q.", verb, r.Type, r.Link)
+	}
+	for _, r := range cr.Keep {
+		w.logger.Printf("Cleanup: keeping %s %q.", r.Type, r.Link)
+	}
+
+	root := w.root()
+	root.cleanupReportMx.Lock()
+	defer root.cleanupReportMx.Unlock()
+	if root.cleanupReport == nil {
+		root.cleanupReport = &CleanupReport{DryRun: w.cleanupDryRun()}
+	}
+	root.cleanupReport.add(cr)
+}
+
+// Result returns a snapshot of the workflow's progress.
+func (w *Workflow) Result() *RunResult {
+	res := &RunResult{}
+	select {
+	case <-w.Cancel:
+		res.Canceled = true
+		res.CancelReason = w.getCancelReason()
+	default:
+	}
+	res.CompletedSteps = w.completedSteps("")
+	for _, rm := range w.resourceMaps() {
+		res.Resources = append(res.Resources, rm.createdResources()...)
+	}
+	res.APICalls = w.root().apiCalls.stats()
+	w.cleanupReportMx.Lock()
+	res.Cleanup = w.cleanupReport
+	w.cleanupReportMx.Unlock()
+	w.stepFailuresMx.Lock()
+	res.FailedSteps = append(res.FailedSteps, w.stepFailures...)
+	w.stepFailuresMx.Unlock()
+	w.outputsMx.Lock()
+	res.Outputs = w.outputs
+	w.outputsMx.Unlock()
+	w.stepResultsMx.Lock()
+	res.Steps = append(res.Steps, w.stepResults...)
+	w.stepResultsMx.Unlock()
+	res.InstanceHours = w.instanceHours(time.Now())
+	w.skippedValidationsMx.Lock()
+	res.SkippedValidations = append(res.SkippedValidations, w.skippedValidations...)
+	w.skippedValidationsMx.Unlock()
+	sort.Strings(res.SkippedValidations)
+	res.LogsPath = w.LogsPath()
+	root := w.root()
+	root.logsFallbackMx.Lock()
+	res.LogsFallback = root.logsFallback
+	root.logsFallbackMx.Unlock()
+	res.OutsPath = w.OutsPath()
+	res.ManifestHash = root.manifestHash
+	return res
+}
+
+// completedSteps returns the completed steps of w and its nested workflows,
+// with names prefixed by prefix.
+func (w *Workflow) completedSteps(prefix string) []string {
+	w.completedMx.Lock()
+	completed := append([]string{}, w.completed...)
+	w.completedMx.Unlock()
+
+	var result []string
+	for _, name := range completed {
+		if s, ok := w.Steps[name]; ok {
+			if s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil {
+				result = append(result, s.IncludeWorkflow.w.completedSteps(prefix+name+".")...)
+			}
+			if s.ForEach != nil && s.ForEach.w != nil {
+				result = append(result, s.ForEach.w.completedSteps(prefix+name+".")...)
+			}
+			if s.SubWorkflow != nil && s.SubWorkflow.w != nil {
+				result = append(result, s.SubWorkflow.w.completedSteps(prefix+name+".")...)
+			}
+		}
+		result = append(result, prefix+name)
+	}
+	return result
+}
+
+// resourceMaps returns the resource maps of w and of the workflows it runs,
+// at any depth, e.g. a subworkflow of an included workflow. Included
+// workflows share their parent's maps, those are returned once.
+func (w *Workflow) resourceMaps() []*baseResourceMap {
+	var rms []*baseResourceMap
+	seen := map[*baseResourceMap]bool{}
+	w.walkWorkflows(func(cw *Workflow) {
+		for _, rm := range cw.ownResourceMaps() {
+			if !seen[rm] {
+				seen[rm] = true
+				rms = append(rms, rm)
+			}
+		}
+	})
+	return rms
+}
+
+// ownResourceMaps returns the resource maps of w.
+func (w *Workflow) ownResourceMaps() []*baseResourceMap {
+	var rms []*baseResourceMap
+	if dm, ok := disks[w]; ok {
+		rms = append(rms, &dm.baseResourceMap)
+	}
+	if im, ok := images[w]; ok {
+		rms = append(rms, &im.baseResourceMap)
+	}
+	if im, ok := instances[w]; ok {
+		rms = append(rms, &im.baseResourceMap)
+	}
+	if nm, ok := networks[w]; ok {
+		rms = append(rms, &nm.baseResourceMap)
+	}
+	if sm, ok := snapshots[w]; ok {
+		rms = append(rms, &sm.baseResourceMap)
+	}
+	if fm, ok := firewallRules[w]; ok {
+		rms = append(rms, &fm.baseResourceMap)
+	}
+	if sm, ok := subnetworks[w]; ok {
+		rms = append(rms, &sm.baseResourceMap)
+	}
+	if am, ok := addresses[w]; ok {
+		rms = append(rms, &am.baseResourceMap)
+	}
+	if rpm, ok := resourcePolicies[w]; ok {
+		rms = append(rms, &rpm.baseResourceMap)
+	}
+	if bm, ok := buckets[w]; ok {
+		rms = append(rms, &bm.baseResourceMap)
+	}
+	return rms
+}
+
+func (rm *baseResourceMap) createdResources() []*CreatedResource {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+	var names []string
+	for name, r := range rm.m {
+		if r.created {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var result []*CreatedResource
+	for _, name := range names {
+		r := rm.m[name]
+		result = append(result, &CreatedResource{Type: rm.typeName, Name: name, Link: r.link, NoCleanup: r.noCleanup, Deleted: r.deleted})
+	}
+	return result
+}
@@ -0,0 +1,309 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestCancelWithReason(t *testing.T) {
+	w := testWorkflow()
+	w.CancelWithReason("first")
+	w.CancelWithReason("second")
+
+	select {
+	case <-w.Cancel:
+	default:
+		t.Fatal("workflow was not canceled")
+	}
+	if got := w.getCancelReason(); got != "first" {
+		t.Errorf("unexpected cancel reason, got: %q, want: %q", got, "first")
+	}
+
+	sw := w.NewSubWorkflow()
+	if got := sw.getCancelReason(); got != "first" {
+		t.Errorf("unexpected subworkflow cancel reason, got: %q, want: %q", got, "first")
+	}
+}
+
+func TestCancelWithReasonSubworkflow(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+
+	var wg sync.WaitGroup
+	for _, wf := range []*Workflow{w, sw, w, sw} {
+		wg.Add(1)
+		go func(wf *Workflow) {
+			defer wg.Done()
+			wf.CancelWithReason(wf.Name)
+		}(wf)
+	}
+	wg.Wait()
+
+	select {
+	case <-sw.Cancel:
+	default:
+		t.Fatal("subworkflow was not canceled")
+	}
+	if got := sw.getCancelReason(); got != w.getCancelReason() {
+		t.Errorf("subworkflow cancel reason %q differs from the workflow's %q", got, w.getCancelReason())
+	}
+}
+
+func TestResult(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			s.w.CancelWithReason("operator abort")
+			return nil
+		}}},
+		"s2": {name: "s2", w: w, timeout: time.Minute, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}}
+	disks[w].m = map[string]*resource{
+		"d0": {real: "d0-real", link: "projects/p/zones/z/disks/d0-real", created: true},
+		"d1": {real: "d1-real", link: "projects/p/zones/z/disks/d1-real"},
+	}
+	images[w].m = map[string]*resource{
+		"i0": {real: "i0-real", link: "projects/p/global/images/i0-real", created: true, noCleanup: true},
+	}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &RunResult{
+		Canceled:       true,
+		CancelReason:   "operator abort",
+		CompletedSteps: []string{"s0"},
+		Resources: []*CreatedResource{
+			{Type: "disk", Name: "d0", Link: "projects/p/zones/z/disks/d0-real"},
+			{Type: "image", Name: "i0", Link: "projects/p/global/images/i0-real", NoCleanup: true},
+		},
+		Steps: []*StepResult{
+			{Step: "s0", Type: "mockStep", State: StepFinished},
+			{Step: "s1", Type: "mockStep", State: StepCanceled},
+		},
+	}
+	got := w.Result()
+	zeroStepTimes(t, got)
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("result does not match expectation: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(got.Kept(), want.Resources[1:]); diff != "" {
+		t.Errorf("kept resources do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+// zeroStepTimes zeroes the times of the Steps of res, after checking they
+// are set.
+func zeroStepTimes(t *testing.T, res *RunResult) {
+	for _, sr := range res.Steps {
+		if sr.Start.IsZero() {
+			t.Errorf("step %q has no start time", sr.Step)
+		}
+		sr.QueueTime = 0
+		sr.Start = time.Time{}
+		sr.Duration = 0
+	}
+}
+
+func TestResultPaths(t *testing.T) {
+	w := testWorkflow()
+	w.bucket = "bucket"
+	w.logsPath = "daisy-abcdef/logs"
+	w.outsPath = "daisy-abcdef/outs"
+	res := w.Result()
+	if res.LogsPath != "gs://bucket/daisy-abcdef/logs" || res.OutsPath != "gs://bucket/daisy-abcdef/outs" {
+		t.Errorf("unexpected paths, got: %q and %q", res.LogsPath, res.OutsPath)
+	}
+}
+
+func TestResultNested(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	sw.Steps = map[string]*Step{"inner": {name: "inner", w: sw, testType: &mockStep{}}}
+	sw.completed = []string{"inner"}
+	disks[sw].m = map[string]*resource{"sd": {link: "projects/p/zones/z/disks/sd", created: true}}
+	w.Steps = map[string]*Step{"sub": {name: "sub", w: w, SubWorkflow: &SubWorkflow{w: sw}}}
+	w.completed = []string{"sub"}
+
+	want := &RunResult{
+		CompletedSteps: []string{"sub.inner", "sub"},
+		Resources:      []*CreatedResource{{Type: "disk", Name: "sd", Link: "projects/p/zones/z/disks/sd"}},
+	}
+	if diff := pretty.Compare(w.Result(), want); diff != "" {
+		t.Errorf("result does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCleanupReport(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	w.Steps = map[string]*Step{"sub": {name: "sub", w: w, SubWorkflow: &SubWorkflow{w: sw}}}
+	disks[w].m = map[string]*resource{
+		"d0": {link: "projects/p/zones/z/disks/d0", created: true},
+		"d1": {link: "projects/p/zones/z/disks/d1", created: true, deleted: true},
+		"d2": {link: "projects/p/zones/z/disks/d2"},
+	}
+	images[w].m = map[string]*resource{"i0": {link: "projects/p/global/images/i0", created: true, noCleanup: true}}
+	disks[sw].m = map[string]*resource{"sd": {link: "projects/p/zones/z/disks/sd", created: true}}
+
+	want := &CleanupReport{
+		Delete: []*CreatedResource{
+			{Type: "disk", Name: "d0", Link: "projects/p/zones/z/disks/d0"},
+			{Type: "disk", Name: "sd", Link: "projects/p/zones/z/disks/sd"},
+		},
+		Keep: []*CreatedResource{{Type: "image", Name: "i0", Link: "projects/p/global/images/i0", NoCleanup: true}},
+	}
+	if diff := pretty.Compare(w.CleanupReport(), want); diff != "" {
+		t.Errorf("cleanup report does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCleanupDryRun(t *testing.T) {
+	tests := []struct {
+		desc   string
+		dryRun bool
+		want   []string
+	}{
+		{"normal case", false, []string{"d0"}},
+		{"dry run case", true, nil},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.CleanupDryRun = tt.dryRun
+		var got []string
+		w.ComputeClient = &daisyCompute.TestClient{
+			DeleteDiskFn: func(_, _, n string) error { got = append(got, n); return nil },
+		}
+		disks[w].m = map[string]*resource{
+			"d0": {real: "d0", link: "projects/p/zones/z/disks/d0", created: true},
+			"d1": {real: "d1", link: "projects/p/zones/z/disks/d1", created: true, noCleanup: true},
+		}
+
+		if res := w.Result(); res.Cleanup != nil {
+			t.Errorf("%s: cleanup report set before cleanup: %v", tt.desc, res.Cleanup)
+		}
+		w.cleanup()
+
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: deleted disks do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		want := &CleanupReport{
+			DryRun: tt.dryRun,
+			Delete: []*CreatedResource{{Type: "disk", Name: "d0", Link: "projects/p/zones/z/disks/d0"}},
+			Keep:   []*CreatedResource{{Type: "disk", Name: "d1", Link: "projects/p/zones/z/disks/d1", NoCleanup: true}},
+		}
+		if diff := pretty.Compare(w.Result().Cleanup, want); diff != "" {
+			t.Errorf("%s: cleanup report does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestResultContinueOnError(t *testing.T) {
+	w := testWorkflow()
+	var ran []string
+	runImpl := func(ctx context.Context, s *Step) error {
+		ran = append(ran, s.name)
+		if s.name == "optional" {
+			return errors.New("fail")
+		}
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		"optional": {name: "optional", w: w, timeout: time.Minute, ContinueOnError: true, testType: &mockStep{runImpl: runImpl}},
+		"next":     {name: "next", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+	}
+	w.Dependencies = map[string][]string{"next": {"optional"}}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := pretty.Compare(ran, []string{"optional", "next"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+	if got := w.Steps["optional"].State(); got != StepFailed {
+		t.Errorf("unexpected state of failed step, got: %s, want: %s", got, StepFailed)
+	}
+	want := &RunResult{
+		CompletedSteps: []string{"next"},
+		FailedSteps:    []*StepFailure{{Step: "optional", Error: `step "optional" run error: fail`}},
+		Steps: []*StepResult{
+			{Step: "optional", Type: "mockStep", State: StepFailed, Error: `step "optional" run error: fail`},
+			{Step: "next", Type: "mockStep", State: StepFinished},
+		},
+	}
+	got := w.Result()
+	zeroStepTimes(t, got)
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("result does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestContinueOnErrorSubWorkflow(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	sw.logger = w.logger
+	sw.ComputeClient = w.ComputeClient
+	sw.StorageClient = w.StorageClient
+	var innerCanceled bool
+	sw.Steps = map[string]*Step{
+		"fail": {name: "fail", w: sw, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"wait": {name: "wait", w: sw, timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			<-s.w.cancelChan()
+			innerCanceled = true
+			return nil
+		}}},
+	}
+	var ran bool
+	w.Steps = map[string]*Step{
+		"sub": {name: "sub", w: w, timeout: time.Minute, ContinueOnError: true, SubWorkflow: &SubWorkflow{w: sw}},
+		"after": {name: "after", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			ran = true
+			return nil
+		}}},
+	}
+	w.Dependencies = map[string][]string{"after": {"sub"}}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !innerCanceled {
+		t.Error("the failure did not cancel the steps of the subworkflow")
+	}
+	if !ran {
+		t.Error("step depending on the failed subworkflow did not run")
+	}
+	select {
+	case <-w.Cancel:
+		t.Errorf("the failure in the subworkflow canceled the workflow: %q", w.getCancelReason())
+	default:
+	}
+	if got := w.Result().FailedSteps; len(got) != 1 || got[0].Step != "sub" {
+		t.Errorf("unexpected failed steps: %+v", got)
+	}
+}
@@ -0,0 +1,55 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// instanceHours returns how long the instances created by w and its
+// subworkflows existed, in hours, until now for those not deleted.
+func (w *Workflow) instanceHours(now time.Time) float64 {
+	var d time.Duration
+	for _, rm := range w.resourceMaps() {
+		if rm.typeName == "instance" {
+			d += rm.uptime(now)
+		}
+	}
+	return d.Hours()
+}
+
+// logRunSummary logs a table of the steps that ran, with their queue times,
+// run times and outcomes, and the instance-hours of the run, to spot steps
+// that got slower.
+func (w *Workflow) logRunSummary() {
+	res := w.Result()
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tTYPE\tQUEUED\tRAN\tOUTCOME")
+	for _, sr := range res.Steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", sr.Step, sr.Type, sr.QueueTime.Round(time.Millisecond), sr.Duration.Round(time.Millisecond), sr.State)
+	}
+	tw.Flush()
+
+	w.logger.Print("Step summary:")
+	for _, l := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		w.logger.Print(l)
+	}
+	w.logger.Printf("Estimated billable instance-hours: %.2f", res.InstanceHours)
+}
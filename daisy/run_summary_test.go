@@ -0,0 +1,72 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestInstanceHours(t *testing.T) {
+	w := testWorkflow()
+	now := time.Now()
+	instances[w].m = map[string]*resource{
+		// Deleted after an hour.
+		"i0": {created: true, deleted: true, createdAt: now.Add(-3 * time.Hour), deletedAt: now.Add(-2 * time.Hour)},
+		// Still running for half an hour.
+		"i1": {created: true, createdAt: now.Add(-30 * time.Minute)},
+		// Not created by the workflow.
+		"i2": {link: "projects/p/zones/z/instances/i2"},
+	}
+	disks[w].m = map[string]*resource{
+		"d0": {created: true, createdAt: now.Add(-10 * time.Hour)},
+	}
+	if got := w.instanceHours(now); math.Abs(got-1.5) > 1e-9 {
+		t.Errorf("unexpected instance-hours, got: %v, want: 1.5", got)
+	}
+}
+
+func TestLogRunSummary(t *testing.T) {
+	w := testWorkflow()
+	var buf bytes.Buffer
+	w.logger = newLogger(w, &textLogger{log.New(&buf, "", 0)})
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"fail": {name: "fail", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+	}
+	w.Dependencies = map[string][]string{"fail": {"s0"}}
+	w.run(context.Background())
+	w.logRunSummary()
+
+	for _, want := range []string{
+		`Step summary:\n`,
+		`STEP +TYPE +QUEUED +RAN +OUTCOME\n`,
+		`s0 +mockStep +\S+ +\S+ +finished\n`,
+		`fail +mockStep +\S+ +\S+ +failed\n`,
+		`Estimated billable instance-hours: 0.00\n`,
+	} {
+		if !regexp.MustCompile(want).MatchString(buf.String()) {
+			t.Errorf("summary does not match %q, got:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Sandbox runs a SubWorkflow in a project leased from the SandboxProjects
+// of its parent workflows, isolating untrusted guest code from the parent
+// workflow's project. Resources the subworkflow creates in the sandbox are
+// cleaned up with the subworkflow, except for Images, which are copied to
+// the parent workflow's project first.
+type Sandbox struct {
+	// Images created by the subworkflow to copy to the parent workflow's
+	// project once the subworkflow completes. The parent workflow knows the
+	// copies by the same names.
+	Images []string `json:",omitempty"`
+
+	project string
+}
+
+// sandboxPool returns the SandboxProjects of w or its closest parent that
+// has any, or the projects in the SandboxFolder of the closest parent that
+// has one.
+func (w *Workflow) sandboxPool() ([]string, error) {
+	for wf := w; wf != nil; wf = wf.parent {
+		if len(wf.SandboxProjects) > 0 {
+			return wf.SandboxProjects, nil
+		}
+		if wf.SandboxFolder != "" {
+			return wf.folderProjects()
+		}
+	}
+	return nil, fmt.Errorf("no SandboxProjects or SandboxFolder defined")
+}
+
+// folderProjects lists the active projects in the SandboxFolder of w.
+func (w *Workflow) folderProjects() ([]string, error) {
+	id := strings.TrimPrefix(w.SandboxFolder, "folders/")
+	ps, err := w.ComputeClient.ListProjects(fmt.Sprintf("parent.type:folder parent.id:%s lifecycleState:ACTIVE", id))
+	if err != nil {
+		return nil, fmt.Errorf("error listing projects in SandboxFolder %q: %v", w.SandboxFolder, err)
+	}
+	var pool []string
+	for _, p := range ps {
+		pool = append(pool, p.ProjectId)
+	}
+	if len(pool) == 0 {
+		return nil, fmt.Errorf("no active projects in SandboxFolder %q", w.SandboxFolder)
+	}
+	return pool, nil
+}
+
+// leaseSandboxProject leases a project from the sandbox pool of w, see
+// sandboxPool. Leases are held until released by releaseSandboxProject.
+func (w *Workflow) leaseSandboxProject() (string, error) {
+	pool, err := w.sandboxPool()
+	if err != nil {
+		return "", err
+	}
+
+	root := w.root()
+	root.sandboxLeasesMx.Lock()
+	defer root.sandboxLeasesMx.Unlock()
+	if root.sandboxLeases == nil {
+		root.sandboxLeases = map[string]bool{}
+	}
+	for _, p := range pool {
+		if !root.sandboxLeases[p] {
+			root.sandboxLeases[p] = true
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("all sandbox projects are in use: %q", pool)
+}
+
+// claimSandboxProject leases project p, whether or not another sandbox of
+// the run leased it.
+func (w *Workflow) claimSandboxProject(p string) {
+	root := w.root()
+	root.sandboxLeasesMx.Lock()
+	defer root.sandboxLeasesMx.Unlock()
+	if root.sandboxLeases == nil {
+		root.sandboxLeases = map[string]bool{}
+	}
+	root.sandboxLeases[p] = true
+}
+
+// releaseSandboxProject releases the lease of project p, so that another
+// sandbox can use it.
+func (w *Workflow) releaseSandboxProject(p string) {
+	root := w.root()
+	root.sandboxLeasesMx.Lock()
+	delete(root.sandboxLeases, p)
+	root.sandboxLeasesMx.Unlock()
+}
+
+func (sb *Sandbox) populate(st *Step, sw *Workflow) error {
+	if sb.project == "" {
+		var p string
+		if run := sw.resumedRun(); run != nil && run.Project != "" {
+			// The resources of the run being resumed are in its sandbox.
+			p = run.Project
+			st.w.claimSandboxProject(p)
+		} else {
+			var err error
+			if p, err = st.w.leaseSandboxProject(); err != nil {
+				return err
+			}
+		}
+		sb.project = p
+		// Cleanup hooks run in order, the resources of the subworkflow are
+		// deleted by the time the project is released.
+		sw.addCleanupHook(func() error {
+			st.w.releaseSandboxProject(p)
+			return nil
+		})
+	}
+	sw.Project = sb.project
+	return nil
+}
+
+func (sb *Sandbox) validate(st *Step, sw *Workflow) error {
+	if sb.project == st.w.Project {
+		return fmt.Errorf("sandbox project %q is the workflow project", sb.project)
+	}
+	for _, name := range sb.Images {
+		if _, ok := images[sw].get(name); !ok {
+			return fmt.Errorf("can't copy image %q from sandbox: image not created by subworkflow", name)
+		}
+		real := st.w.genName(name)
+		link := fmt.Sprintf("projects/%s/global/images/%s", st.w.Project, real)
+		// The copies are the outputs of the sandbox, they are kept.
+		if err := images[st.w].registerCreation(name, &resource{real: real, link: link, noCleanup: true}, st); err != nil {
+			return fmt.Errorf("can't copy image %q from sandbox: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// copyImages copies the sandbox images listed in Images to the parent
+// workflow's project.
+func (sb *Sandbox) copyImages(st *Step, sw *Workflow) error {
+	var wg sync.WaitGroup
+	e := make(chan error, len(sb.Images))
+	for _, name := range sb.Images {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			src, _ := images[sw].get(name)
+			dst, _ := images[st.w].get(name)
+			img := &compute.Image{Name: dst.real, SourceImage: src.link, Labels: st.w.addWorkflowLabels(nil, true)}
+			if err := st.w.checkPolicy(&PlannedResource{Type: "image", Name: img.Name, Project: st.w.Project, Resource: img}); err != nil {
+				e <- err
+				return
+			}
+			st.w.logger.StepInfo(st, "copying image %q from sandbox project %q.", name, sb.project)
+			if err := st.w.ComputeClient.CreateImage(st.w.Project, img); err != nil {
+				e <- err
+				return
+			}
+			images[st.w].markCreated(name)
+		}(name)
+	}
+	wg.Wait()
+	close(e)
+	return <-e
+}
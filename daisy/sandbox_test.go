@@ -0,0 +1,188 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestLeaseSandboxProject(t *testing.T) {
+	w := testWorkflow()
+	if _, err := w.leaseSandboxProject(); err == nil {
+		t.Error("expected error without SandboxProjects")
+	}
+
+	w.SandboxProjects = []string{"sb1", "sb2"}
+	sw := w.NewSubWorkflow()
+	var got []string
+	for _, wf := range []*Workflow{w, sw} {
+		p, err := wf.leaseSandboxProject()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, p)
+	}
+	if diff := pretty.Compare(got, []string{"sb1", "sb2"}); diff != "" {
+		t.Errorf("leased projects do not match expectation: (-got +want)\n%s", diff)
+	}
+	if _, err := sw.leaseSandboxProject(); err == nil {
+		t.Error("expected error with all SandboxProjects in use")
+	}
+
+	sw.releaseSandboxProject("sb1")
+	if p, err := sw.leaseSandboxProject(); err != nil || p != "sb1" {
+		t.Errorf("released project not leased again, got: %q, %v", p, err)
+	}
+}
+
+func TestLeaseSandboxProjectFolder(t *testing.T) {
+	w := testWorkflow()
+	w.SandboxFolder = "folders/123"
+	var gotFilter string
+	w.ComputeClient = &daisyCompute.TestClient{ListProjectsFn: func(filter string) ([]*cloudresourcemanager.Project, error) {
+		gotFilter = filter
+		return []*cloudresourcemanager.Project{{ProjectId: "sb1"}}, nil
+	}}
+
+	sw := w.NewSubWorkflow()
+	if p, err := sw.leaseSandboxProject(); err != nil || p != "sb1" {
+		t.Errorf("unexpected lease, got: %q, %v", p, err)
+	}
+	if want := "parent.type:folder parent.id:123 lifecycleState:ACTIVE"; gotFilter != want {
+		t.Errorf("unexpected filter, got: %q, want: %q", gotFilter, want)
+	}
+	if _, err := sw.leaseSandboxProject(); err == nil {
+		t.Error("expected error with all folder projects in use")
+	}
+
+	// SandboxProjects take precedence.
+	sw.SandboxProjects = []string{"sb2"}
+	if p, err := sw.leaseSandboxProject(); err != nil || p != "sb2" {
+		t.Errorf("unexpected lease, got: %q, %v", p, err)
+	}
+}
+
+func TestSandboxReleaseOnCleanup(t *testing.T) {
+	w := testWorkflow()
+	w.SandboxProjects = []string{"sb1"}
+	st := &Step{name: "sub", w: w}
+	sw := w.NewSubWorkflow()
+	sw.logger = w.logger
+	sb := &Sandbox{}
+	if err := sb.populate(st, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := w.leaseSandboxProject(); err == nil {
+		t.Error("expected error with the sandbox project in use")
+	}
+
+	sw.cleanup()
+	if p, err := w.leaseSandboxProject(); err != nil || p != "sb1" {
+		t.Errorf("sandbox project not released on cleanup, got: %q, %v", p, err)
+	}
+}
+
+func TestSandboxResumed(t *testing.T) {
+	w := testWorkflow()
+	w.SandboxProjects = []string{"sb1", "sb2"}
+	st := &Step{name: "sub", w: w}
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	w.resumed = &checkpoint{Runs: map[string]*checkpointRun{testWf + ".sub": {ID: "abcde", Project: "sb2"}}}
+	sb := &Sandbox{}
+	if err := sb.populate(st, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sw.Project != "sb2" {
+		t.Errorf("sandbox project of the resumed run not used, got: %q, want: %q", sw.Project, "sb2")
+	}
+	if p, err := w.leaseSandboxProject(); err != nil || p != "sb1" {
+		t.Errorf("unexpected lease with the sandbox project of the resumed run in use, got: %q, %v", p, err)
+	}
+}
+
+func TestSandboxValidate(t *testing.T) {
+	w := testWorkflow()
+	st := &Step{name: "sub", w: w}
+	w.Steps["sub"] = st
+	sw := w.NewSubWorkflow()
+	iCreator := &Step{name: "iCreator", w: sw}
+	images[sw].registerCreation("i", &resource{link: "projects/sb/global/images/i-real"}, iCreator)
+
+	tests := []struct {
+		desc      string
+		sb        *Sandbox
+		shouldErr bool
+	}{
+		{"normal case", &Sandbox{Images: []string{"i"}, project: "sb"}, false},
+		{"image not created case", &Sandbox{Images: []string{"dne"}, project: "sb"}, true},
+		{"workflow project case", &Sandbox{project: w.Project}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.sb.validate(st, sw); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error result: %v", tt.desc, err)
+		}
+	}
+
+	wantLink := fmt.Sprintf("projects/%s/global/images/%s", w.Project, w.genName("i"))
+	if got, _ := images[w].get("i"); got == nil || got.link != wantLink || got.creator != st || !got.noCleanup {
+		t.Errorf("image copy not registered as expected, got: %+v, want link: %q", got, wantLink)
+	}
+}
+
+func TestSandboxCopyImages(t *testing.T) {
+	w := testWorkflow()
+	st := &Step{name: "sub", w: w}
+	sw := w.NewSubWorkflow()
+	images[sw].m = map[string]*resource{"i": {real: "i-real", link: "projects/sb/global/images/i-real"}}
+	images[w].m = map[string]*resource{"i": {real: "i-copy", link: "projects/p/global/images/i-copy"}}
+	sb := &Sandbox{Images: []string{"i"}, project: "sb"}
+
+	var got []*compute.Image
+	var clientErr error
+	w.ComputeClient = &daisyCompute.TestClient{CreateImageFn: func(p string, i *compute.Image) error {
+		if p != w.Project {
+			t.Errorf("image copied to wrong project: %q", p)
+		}
+		got = append(got, i)
+		return clientErr
+	}}
+	if err := sb.copyImages(st, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The copies are kept, so they must not look like orphans.
+	wantLabels := map[string]string{labelNoCleanup: "true"}
+	for k, v := range testLabels {
+		wantLabels[k] = v
+	}
+	if diff := pretty.Compare(got, []*compute.Image{{Name: "i-copy", SourceImage: "projects/sb/global/images/i-real", Labels: wantLabels}}); diff != "" {
+		t.Errorf("images not copied as expected: (-got +want)\n%s", diff)
+	}
+	if r, _ := images[w].get("i"); !r.created {
+		t.Error("copied image not marked created")
+	}
+
+	clientErr = errors.New("error")
+	if err := sb.copyImages(st, sw); err != clientErr {
+		t.Errorf("unexpected error, got: %v, want: %v", err, clientErr)
+	}
+}
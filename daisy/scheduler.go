@@ -0,0 +1,171 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "time"
+
+// Scheduler decides which of the steps that are ready to run a workflow
+// starts, e.g. to prioritize the critical path or to defer expensive steps
+// while quota is short.
+type Scheduler interface {
+	// Schedule is called with the names of w's steps whose dependencies
+	// are done, and the names of its running steps, both sorted. It returns
+	// the ready steps to start, in start order. Steps not returned are
+	// offered again once a running step finishes. If no steps are running,
+	// Schedule must start at least one step, it may block until then.
+	Schedule(w *Workflow, ready, running []string) []string
+}
+
+// SchedulerFunc is an adapter to use an ordinary function as a Scheduler.
+type SchedulerFunc func(w *Workflow, ready, running []string) []string
+
+// Schedule calls f(w, ready, running).
+func (f SchedulerFunc) Schedule(w *Workflow, ready, running []string) []string {
+	return f(w, ready, running)
+}
+
+// schedule returns the ready steps to start, using the Scheduler of w or of
+// its closest parent that has one. Without a Scheduler, all ready steps
+// start. Steps are only offered to the Scheduler once the uploads of the
+// sources they use are done, and only start if they get one of the
+// MaxParallelSteps slots. If none of w's steps are running, schedule waits
+// until a step can start, it returns nil if w is canceled first.
+func (w *Workflow) schedule(ready, running []string) []string {
+	now := time.Now()
+	for _, name := range ready {
+		if s := w.Steps[name]; s.readyAt.IsZero() {
+			s.readyAt = now
+		}
+	}
+	mustStart := len(running) == 0
+	ready = w.sourcesReady(ready, mustStart)
+	if len(ready) == 0 {
+		return nil
+	}
+	chosen := ready
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.Scheduler != nil {
+			chosen = wf.Scheduler.Schedule(w, ready, running)
+			break
+		}
+	}
+	return w.takeStepSlots(chosen, mustStart)
+}
+
+// sourcesReady returns the steps of ready whose source uploads are done. If
+// there are none and wait is set, it waits for the uploads of the first step
+// of ready, or until w is canceled.
+func (w *Workflow) sourcesReady(ready []string, wait bool) []string {
+	var result []string
+	for _, name := range ready {
+		if w.Steps[name].sourcesUploaded() {
+			result = append(result, name)
+		}
+	}
+	if len(result) != 0 || len(ready) == 0 || !wait {
+		return result
+	}
+	for _, u := range w.Steps[ready[0]].uploads {
+		select {
+		case <-u.done:
+		case <-w.cancelChan():
+			return nil
+		}
+	}
+	return ready[:1]
+}
+
+// runsWorkflow reports whether s runs a workflow of its own.
+func (s *Step) runsWorkflow() bool {
+	return s.IncludeWorkflow != nil || s.SubWorkflow != nil || s.ForEach != nil
+}
+
+// stepSlots returns the root workflow's semaphore of MaxParallelSteps, nil
+// if there is no limit.
+func (w *Workflow) stepSlots() chan struct{} {
+	root := w.root()
+	root.stepSlotsOnce.Do(func() {
+		if root.MaxParallelSteps > 0 {
+			root.stepSlotsSem = make(chan struct{}, root.MaxParallelSteps)
+		}
+	})
+	return root.stepSlotsSem
+}
+
+// takeStepSlots returns the steps of names that got one of the
+// MaxParallelSteps slots of the whole workflow, in order. Steps that run a
+// workflow don't need one, their steps do. If none got a slot and wait is
+// set, it waits for a slot for the first step of names.
+func (w *Workflow) takeStepSlots(names []string, wait bool) []string {
+	slots := w.stepSlots()
+	if slots == nil {
+		return names
+	}
+	var result []string
+	for _, name := range names {
+		s := w.Steps[name]
+		if s.runsWorkflow() || s.slot {
+			result = append(result, name)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			s.slot = true
+			result = append(result, name)
+		default:
+		}
+	}
+	if len(result) != 0 || len(names) == 0 || !wait {
+		return result
+	}
+	if w.acquireStepSlot(w.Steps[names[0]]) {
+		return names[:1]
+	}
+	return nil
+}
+
+// acquireStepSlot waits until fewer than MaxParallelSteps steps are running
+// in the whole workflow, and counts s as running. Steps that run a workflow
+// don't count, their steps do. It returns false if w was canceled before s
+// could start. Steps scheduled by schedule already hold a slot, others,
+// e.g. of Executors that don't use schedule, take one here.
+func (w *Workflow) acquireStepSlot(s *Step) bool {
+	slots := w.stepSlots()
+	if slots == nil || s.runsWorkflow() || s.slot {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		s.slot = true
+		return true
+	default:
+	}
+	w.logger.Printf("Step %q waiting to start, %d steps are running.", s.name, cap(slots))
+	select {
+	case slots <- struct{}{}:
+		s.slot = true
+		return true
+	case <-w.cancelChan():
+		return false
+	}
+}
+
+// releaseStepSlot stops counting s as running.
+func (w *Workflow) releaseStepSlot(s *Step) {
+	if s.slot {
+		s.slot = false
+		<-w.stepSlots()
+	}
+}
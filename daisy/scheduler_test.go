@@ -0,0 +1,175 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	var callOrder []int
+	var mx sync.Mutex
+	mockRun := func(i int) func(context.Context, *Step) error {
+		return func(_ context.Context, _ *Step) error {
+			mx.Lock()
+			defer mx.Unlock()
+			callOrder = append(callOrder, i)
+			return nil
+		}
+	}
+
+	// Run one step at a time, the last ready one first.
+	w := testTraverseWorkflow(mockRun)
+	w.Scheduler = SchedulerFunc(func(_ *Workflow, ready, running []string) []string {
+		if len(running) != 0 {
+			return nil
+		}
+		return ready[len(ready)-1:]
+	})
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(callOrder, []int{4, 0, 2, 1, 3}); diff != "" {
+		t.Errorf("steps not run in scheduled order: (-got +want)\n%s", diff)
+	}
+
+	// Scheduler starting nothing.
+	w = testTraverseWorkflow(mockRun)
+	w.Scheduler = SchedulerFunc(func(_ *Workflow, _, _ []string) []string { return nil })
+	if err := w.Run(ctx); err == nil {
+		t.Error("expected error when the scheduler starts no steps")
+	}
+
+	// Subworkflows use their parent's scheduler.
+	var scheduled *Workflow
+	w.Scheduler = SchedulerFunc(func(w *Workflow, ready, _ []string) []string { scheduled = w; return ready })
+	sw := w.NewSubWorkflow()
+	sw.Steps["a"] = &Step{name: "a", w: sw}
+	if got := sw.schedule([]string{"a"}, nil); scheduled != sw || len(got) != 1 {
+		t.Errorf("subworkflow not scheduled by parent's scheduler, got: %q", got)
+	}
+}
+
+func TestMaxParallelSteps(t *testing.T) {
+	var mx sync.Mutex
+	var running, maxRunning, ran int
+	run := func(_ context.Context, _ *Step) error {
+		mx.Lock()
+		running++
+		ran++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mx.Lock()
+		running--
+		mx.Unlock()
+		return nil
+	}
+
+	w := testWorkflow()
+	w.MaxParallelSteps = 2
+	iw := w.NewIncludedWorkflow()
+	iw.parent = w
+	iw.logger = w.logger
+	iw.Steps = map[string]*Step{}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("inner%d", i)
+		iw.Steps[name] = &Step{name: name, w: iw, timeout: time.Minute, testType: &mockStep{runImpl: run}}
+	}
+	// The include step doesn't count, or its steps could never start.
+	w.Steps = map[string]*Step{"include": {name: "include", w: w, timeout: time.Minute, IncludeWorkflow: &IncludeWorkflow{w: iw}}}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("s%d", i)
+		w.Steps[name] = &Step{name: name, w: w, timeout: time.Minute, testType: &mockStep{runImpl: run}}
+	}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ran != 8 {
+		t.Errorf("unexpected number of steps run, got: %d, want: 8", ran)
+	}
+	if maxRunning != 2 {
+		t.Errorf("unexpected maximum of steps running at once, got: %d, want: 2", maxRunning)
+	}
+}
+
+func TestMaxParallelStepsCanceled(t *testing.T) {
+	w := testWorkflow()
+	w.MaxParallelSteps = 1
+	ran := false
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, _ *Step) error {
+			ran = true
+			return nil
+		}}},
+	}
+	// Take the only slot, as a step running elsewhere in the workflow would.
+	w.stepSlots() <- struct{}{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.CancelWithReason("canceled")
+	}()
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ran {
+		t.Error("step waiting for a slot ran after cancellation")
+	}
+	if got := w.Result().CompletedSteps; len(got) != 0 {
+		t.Errorf("unexpected completed steps: %q", got)
+	}
+}
+
+func TestMaxParallelStepsWaitingForSources(t *testing.T) {
+	w := testWorkflow()
+	w.MaxParallelSteps = 1
+	u := &sourceUpload{dst: "src", done: make(chan struct{})}
+	var mx sync.Mutex
+	var order []string
+	record := func(_ context.Context, s *Step) error {
+		mx.Lock()
+		defer mx.Unlock()
+		order = append(order, s.name)
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		// The uploads of a step's sources finishing after other steps ran
+		// must not keep those steps from getting the only slot.
+		"a-uploading": {name: "a-uploading", w: w, timeout: time.Minute, uploads: []*sourceUpload{u}, testType: &mockStep{runImpl: record}},
+		"b-ready": {name: "b-ready", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			record(ctx, s)
+			close(u.done)
+			return nil
+		}}},
+	}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(order, []string{"b-ready", "a-uploading"}); diff != "" {
+		t.Errorf("steps not run in expected order: (-got +want)\n%s", diff)
+	}
+}
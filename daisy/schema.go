@@ -0,0 +1,163 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// WorkflowSchemaID is the JSON Schema draft WorkflowSchema conforms to.
+const WorkflowSchemaID = "http://json-schema.org/draft-07/schema#"
+
+// WorkflowSchema returns a JSON Schema of the workflow file format, for
+// editors and CI to check workflow files without running Daisy. It is
+// generated from the Workflow and step types, so it is always in sync with
+// them. Field names are matched case insensitively when Daisy reads a
+// workflow, so the schema doesn't reject unknown fields.
+func WorkflowSchema() ([]byte, error) {
+	g := &schemaGen{defs: map[string]interface{}{}}
+	s := map[string]interface{}{
+		"$schema":     WorkflowSchemaID,
+		"title":       "Daisy workflow",
+		"allOf":       []interface{}{g.schema(reflect.TypeOf(Workflow{}))},
+		"definitions": g.defs,
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// schemaGen generates the schemas of types, with named structs as
+// definitions so recursive types, like the Step of a ForEach, terminate.
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(vars{}):
+		// A var object, or its value as loaded by vars.UnmarshalJSON: a
+		// string, int, bool, list or map, as is the Value of var objects.
+		vs := g.structSchema(t)
+		props := vs["properties"].(map[string]interface{})
+		props["Value"] = map[string]interface{}{}
+		props["Type"] = map[string]interface{}{"type": "string", "enum": varTypes}
+		return map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"type": []string{"string", "number", "boolean", "array", "null"}},
+			vs,
+			map[string]interface{}{"type": "object"},
+		}}
+	case reflect.TypeOf(guestOsFeatures{}):
+		// A list of feature types or of GuestOsFeature objects.
+		return map[string]interface{}{"anyOf": []interface{}{
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			map[string]interface{}{"type": "array", "items": g.schema(t.Elem())},
+		}}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 encoded, as encoding/json does.
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaDefName(t)
+		if _, ok := g.defs[name]; !ok {
+			// Set before generating, for recursive types.
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	}
+	// Interfaces and anything else can be any value.
+	return map[string]interface{}{}
+}
+
+// schemaDefName returns the definition name of t, e.g. "CreateDisk", or
+// "compute.Disk" for types of other packages.
+func schemaDefName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(Workflow{}).PkgPath() {
+		return t.Name()
+	}
+	return t.String()
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.addProperties(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// addProperties adds the JSON fields of struct t to props, those of
+// embedded structs after its own, so that, as in encoding/json, they don't
+// override fields of t with the same name.
+func (g *schemaGen) addProperties(t reflect.Type, props map[string]interface{}) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := props[name]; ok {
+			continue
+		}
+		s := g.schema(f.Type)
+		if typ, ok := s["type"].(string); ok && strIn("string", opts[1:]) {
+			// Numbers and bools encoded as strings, e.g. int64 fields of
+			// compute types, are accepted as either.
+			s = map[string]interface{}{"type": []string{typ, "string"}}
+		}
+		props[name] = s
+	}
+	for _, et := range embedded {
+		g.addProperties(et, props)
+	}
+}
@@ -0,0 +1,96 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestWorkflowSchema(t *testing.T) {
+	b, err := WorkflowSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s struct {
+		Schema      string `json:"$schema"`
+		AllOf       []map[string]string
+		Definitions map[string]struct {
+			Type       string
+			Properties map[string]json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if s.Schema != WorkflowSchemaID || len(s.AllOf) != 1 || s.AllOf[0]["$ref"] != "#/definitions/Workflow" {
+		t.Errorf("unexpected schema root: %s", b[:200])
+	}
+
+	// Every step type is a property of Step.
+	step, ok := s.Definitions["Step"]
+	if !ok {
+		t.Fatal("no Step definition")
+	}
+	st := reflect.TypeOf(Step{})
+	for i := 0; i < st.NumField(); i++ {
+		if f := st.Field(i); f.PkgPath == "" {
+			if _, ok := step.Properties[f.Name]; !ok {
+				t.Errorf("Step field %q missing from schema", f.Name)
+			}
+		}
+	}
+
+	tests := []struct {
+		desc, def, prop, want string
+	}{
+		{"step type case", "Step", "CreateDisks", `{"items":{"$ref":"#/definitions/CreateDisk"},"type":"array"}`},
+		{"recursive case", "ForEach", "Step", `{"$ref":"#/definitions/Step"}`},
+		{"embedded field case", "CreateDisk", "sourceImage", `{"type":"string"}`},
+		{"embedded field override case", "CreateDisk", "sizeGb", `{"type":"string"}`},
+		{"string encoded int case", "compute.AttachedDiskInitializeParams", "diskSizeGb", `{"type":["integer","string"]}`},
+		{"map case", "Workflow", "Sources", `{"additionalProperties":{"type":"string"},"type":"object"}`},
+		{"vars case", "Workflow", "Vars", `{"additionalProperties":{"anyOf":[
+			{"type":["string","number","boolean","array","null"]},
+			{"properties":{"Description":{"type":"string"},"Required":{"type":"boolean"},"Type":{"enum":["string","int","bool","list","map"],"type":"string"},"Value":{},"ValueFromEnv":{"type":"string"},"ValueFromSecret":{"type":"string"}},"type":"object"},
+			{"type":"object"}
+		]},"type":"object"}`},
+		{"ignored field case", "Workflow", "ComputeClient", ""},
+		{"unexported field case", "CreateDisk", "daisyName", ""},
+	}
+	for _, tt := range tests {
+		def, ok := s.Definitions[tt.def]
+		if !ok {
+			t.Errorf("%s: no definition %q", tt.desc, tt.def)
+			continue
+		}
+		got, ok := def.Properties[tt.prop]
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: %s.%s should not be in the schema", tt.desc, tt.def, tt.prop)
+			}
+			continue
+		}
+		var gotV, wantV interface{}
+		json.Unmarshal(got, &gotV)
+		json.Unmarshal([]byte(tt.want), &wantV)
+		if diff := pretty.Compare(gotV, wantV); diff != "" {
+			t.Errorf("%s: %s.%s not as expected: (-got +want)\n%s", tt.desc, tt.def, tt.prop, diff)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// scratchTimeFormat is the format of the start time in scratch paths.
+	scratchTimeFormat = "20060102-15:04:05"
+	// runFile is written to the scratch path of a top level workflow to
+	// claim it for the run.
+	runFile = "run.json"
+)
+
+var scratchUserRgx = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// runIDs are the IDs given to the workflows run by this process, so no two
+// of them share an ID, and with it a scratch path.
+var runIDs = struct {
+	issued map[string]bool
+	mu     sync.Mutex
+}{issued: map[string]bool{}}
+
+// newRunID returns a random workflow ID no other workflow in the process
+// was given.
+func newRunID() string {
+	runIDs.mu.Lock()
+	defer runIDs.mu.Unlock()
+	for {
+		id := randString(5)
+		if !runIDs.issued[id] {
+			runIDs.issued[id] = true
+			return id
+		}
+	}
+}
+
+// newScratchPath returns the scratch path of w under p, the directory
+// "daisy-NAME-USERNAME-DATETIME-ID". A resumed run reuses the scratch path
+// of the run it resumes.
+func (w *Workflow) newScratchPath(p string, started time.Time) string {
+	if run := w.resumedRun(); run != nil {
+		if run.ScratchPath != "" {
+			return run.ScratchPath
+		}
+		if w.parent == nil && w.resumed.dir != "" {
+			return w.resumed.dir
+		}
+		// Checkpoints without scratch paths are of runs with the layout
+		// that didn't include the username.
+		return path.Join(p, fmt.Sprintf("daisy-%s-%s-%s", w.Name, started.Format(scratchTimeFormat), w.id))
+	}
+	user := scratchUserRgx.ReplaceAllString(w.username, "-")
+	if user == "" {
+		user = "unknown"
+	}
+	return path.Join(p, fmt.Sprintf("daisy-%s-%s-%s-%s", w.Name, user, started.Format(scratchTimeFormat), w.id))
+}
+
+// claimScratchPath writes runFile to the scratch path of a top level
+// workflow before it runs, failing if it exists, so a run never shares its
+// scratch path with a run of another process. Nested workflows use a path
+// under the scratch path of their parent, and resumed runs the scratch path
+// of the run they resume.
+func (w *Workflow) claimScratchPath(ctx context.Context) error {
+	if w.parent != nil || w.resumedRun() != nil {
+		return nil
+	}
+	obj := path.Join(w.scratchPath, runFile)
+	b, err := json.Marshal(struct {
+		ID, Workflow, Username string
+		Started                time.Time
+	}{w.id, w.Name, w.username, w.started})
+	if err != nil {
+		return err
+	}
+	wc := w.StorageClient.Bucket(w.bucket).Object(obj).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	wc.ContentType = "application/json"
+	if _, err := wc.Write(b); err != nil {
+		return fmt.Errorf("error claiming scratch path gs://%s/%s: %v", w.bucket, w.scratchPath, err)
+	}
+	if err := wc.Close(); err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusPreconditionFailed {
+			return fmt.Errorf("scratch path gs://%s/%s is in use by another run", w.bucket, w.scratchPath)
+		}
+		return fmt.Errorf("error claiming scratch path gs://%s/%s: %v", w.bucket, w.scratchPath, err)
+	}
+	return nil
+}
+
+// ID returns the ID of the run of w, set by Validate.
+func (w *Workflow) ID() string {
+	return w.id
+}
+
+// ScratchPath returns the GCS path of the scratch directory of the run of
+// w, set by Validate. It is "GCSPATH/daisy-NAME-USERNAME-DATETIME-ID" for
+// top level workflows, and under the scratch path of their parent for
+// subworkflows.
+func (w *Workflow) ScratchPath() string {
+	return w.gcsURL(w.scratchPath)
+}
+
+// SourcesPath returns the GCS path Sources are uploaded to, "sources" in
+// the scratch path.
+func (w *Workflow) SourcesPath() string {
+	return w.gcsURL(w.sourcesPath)
+}
+
+// LogsPath returns the GCS path of the logs of the run, "logs" in the
+// scratch path.
+func (w *Workflow) LogsPath() string {
+	return w.gcsURL(w.logsPath)
+}
+
+// OutsPath returns the GCS path of the outputs of the run, "outs" in the
+// scratch path.
+func (w *Workflow) OutsPath() string {
+	return w.gcsURL(w.outsPath)
+}
+
+// gcsURL returns the gs:// URL of object p of the bucket of w, or "" if w
+// has no bucket yet.
+func (w *Workflow) gcsURL(p string) string {
+	if w.bucket == "" {
+		return ""
+	}
+	return fmt.Sprintf("gs://%s/%s", w.bucket, p)
+}
@@ -0,0 +1,144 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestNewScratchPath(t *testing.T) {
+	started := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc    string
+		user    string
+		resumed *checkpoint
+		want    string
+	}{
+		{"normal case", "someone", nil, "p/daisy-test-wf-someone-20171001-12:00:00-abcdef"},
+		{"username case", `DOMAIN\some one`, nil, "p/daisy-test-wf-DOMAIN-some-one-20171001-12:00:00-abcdef"},
+		{"no username case", "", nil, "p/daisy-test-wf-unknown-20171001-12:00:00-abcdef"},
+		{"resumed case", "someone", &checkpoint{Runs: map[string]*checkpointRun{testWf: {ID: "abcdef", ScratchPath: "p/old"}}}, "p/old"},
+		{"resumed dir case", "someone", &checkpoint{Runs: map[string]*checkpointRun{testWf: {ID: "abcdef"}}, dir: "p/dir"}, "p/dir"},
+		{"resumed legacy case", "someone", &checkpoint{Runs: map[string]*checkpointRun{testWf: {ID: "abcdef"}}}, "p/daisy-test-wf-20171001-12:00:00-abcdef"},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.username = tt.user
+		w.resumed = tt.resumed
+		if got := w.newScratchPath("p", started); got != tt.want {
+			t.Errorf("%s: unexpected scratch path, got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestNewRunIDUnique(t *testing.T) {
+	var wg sync.WaitGroup
+	ids := make(chan string, 1000)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- newRunID()
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := map[string]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("ID %q was given twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestClaimScratchPath(t *testing.T) {
+	var mx sync.Mutex
+	claimed := map[string]bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Query().Get("ifGenerationMatch") != "0" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		// All claims are of the same object in this test.
+		if claimed[r.URL.Path] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		claimed[r.URL.Path] = true
+		w.Write([]byte(`{"bucket":"bucket","name":"run.json"}`))
+	}))
+	defer ts.Close()
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newWorkflow := func() *Workflow {
+		w := testWorkflow()
+		w.StorageClient = client
+		w.bucket = "bucket"
+		w.scratchPath = "daisy-test-wf-someone-20171001-12:00:00-abcdef"
+		return w
+	}
+	if err := newWorkflow().claimScratchPath(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = newWorkflow().claimScratchPath(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "is in use by another run") {
+		t.Errorf("claiming a claimed scratch path should have failed, got: %v", err)
+	}
+
+	// Nested workflows and resumed runs don't claim scratch paths.
+	sw := newWorkflow().NewSubWorkflow()
+	if err := sw.claimScratchPath(context.Background()); err != nil {
+		t.Errorf("unexpected error from subworkflow: %v", err)
+	}
+	rw := newWorkflow()
+	rw.resumed = &checkpoint{Runs: map[string]*checkpointRun{testWf: {ID: "abcdef"}}}
+	if err := rw.claimScratchPath(context.Background()); err != nil {
+		t.Errorf("unexpected error from resumed run: %v", err)
+	}
+}
+
+func TestScratchPathAccessors(t *testing.T) {
+	w := testWorkflow()
+	if got := w.ScratchPath(); got != "" {
+		t.Errorf("scratch path set before populate: %q", got)
+	}
+	w.bucket = "bucket"
+	w.scratchPath = "p/daisy-x"
+	w.sourcesPath = "p/daisy-x/sources"
+	w.logsPath = "p/daisy-x/logs"
+	w.outsPath = "p/daisy-x/outs"
+	got := []string{w.ID(), w.ScratchPath(), w.SourcesPath(), w.LogsPath(), w.OutsPath()}
+	want := []string{"abcdef", "gs://bucket/p/daisy-x", "gs://bucket/p/daisy-x/sources", "gs://bucket/p/daisy-x/logs", "gs://bucket/p/daisy-x/outs"}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("unexpected path, got: %q, want: %q", got[i], want[i])
+		}
+	}
+}
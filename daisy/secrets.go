@@ -0,0 +1,136 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/secretmanager/v1"
+	"google.golang.org/api/transport"
+)
+
+// redactedText replaces the values of secret vars in logs and Print.
+const redactedText = "[REDACTED]"
+
+var secretVersionRgx = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+/versions/[^/]+$`)
+
+func newSecretManagerClient(ctx context.Context, oauthPath string) (*secretmanager.Service, error) {
+	hc, _, err := transport.NewHTTPClient(ctx, option.WithScopes(secretmanager.CloudPlatformScope), option.WithCredentialsFile(oauthPath))
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	return secretmanager.New(hc)
+}
+
+// resolveSecretVars sets the Value of the Vars of w with a ValueFromSecret
+// to the secret, and redacts the secret from the logs of the workflow.
+func (w *Workflow) resolveSecretVars(ctx context.Context) error {
+	var names []string
+	for k, v := range w.Vars {
+		if v.ValueFromSecret != "" {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	if w.secretManagerClient == nil {
+		var err error
+		if w.secretManagerClient, err = newSecretManagerClient(ctx, w.OAuthPath); err != nil {
+			return err
+		}
+	}
+	for _, k := range names {
+		v := w.Vars[k]
+		if !secretVersionRgx.MatchString(v.ValueFromSecret) {
+			return fmt.Errorf("var %q: ValueFromSecret must be a secret version, \"projects/PROJECT/secrets/SECRET/versions/VERSION\", got %q", k, v.ValueFromSecret)
+		}
+		resp, err := w.secretManagerClient.Projects.Secrets.Versions.Access(v.ValueFromSecret).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error reading secret %q of var %q: %v", v.ValueFromSecret, k, err)
+		}
+		var data []byte
+		if resp.Payload != nil {
+			if data, err = base64.StdEncoding.DecodeString(resp.Payload.Data); err != nil {
+				return fmt.Errorf("error decoding secret %q of var %q: %v", v.ValueFromSecret, k, err)
+			}
+		}
+		v.Value = string(data)
+		w.Vars[k] = v
+		w.root().redactor.add(v.Value)
+	}
+	return nil
+}
+
+// redactor replaces secrets with redactedText.
+type redactor struct {
+	secrets  []string
+	replacer *strings.Replacer
+	mx       sync.Mutex
+}
+
+// add redacts s, as is and as escaped in JSON strings.
+func (r *redactor) add(s string) {
+	if s == "" {
+		return
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	forms := []string{s}
+	if b, err := json.Marshal(s); err == nil {
+		if esc := string(b[1 : len(b)-1]); esc != s {
+			forms = append(forms, esc)
+		}
+	}
+	var pairs []string
+	for _, f := range append(r.secrets, forms...) {
+		pairs = append(pairs, f, redactedText)
+	}
+	r.secrets = append(r.secrets, forms...)
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+func (r *redactor) redact(s string) string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactingWriter writes to out with the secrets of r redacted.
+type redactingWriter struct {
+	out io.Writer
+	r   *redactor
+}
+
+func (rw *redactingWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(rw.out, rw.r.redact(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,108 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/secretmanager/v1"
+)
+
+func TestResolveSecretVars(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/secrets/key/versions/latest:access" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"projects/p/secrets/key/versions/3","payload":{"data":%q}}`, base64.StdEncoding.EncodeToString([]byte(`s3cr"t`)))
+	}))
+	defer ts.Close()
+	client, err := secretmanager.New(http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BasePath = ts.URL + "/"
+
+	tests := []struct {
+		desc, secret string
+		shouldErr    bool
+	}{
+		{"normal case", "projects/p/secrets/key/versions/latest", false},
+		{"bad name case", "secrets/key", true},
+		{"missing secret case", "projects/p/secrets/dne/versions/latest", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.secretManagerClient = client
+		w.Vars = map[string]vars{"key": {ValueFromSecret: tt.secret}, "other": {Value: "plain"}}
+		err := w.resolveSecretVars(context.Background())
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if got := w.Vars["key"].Value; got != `s3cr"t` {
+			t.Errorf("%s: unexpected value, got: %q", tt.desc, got)
+		}
+		if got := w.Vars["other"].Value; got != "plain" {
+			t.Errorf("%s: var without secret changed, got: %q", tt.desc, got)
+		}
+		want := `[REDACTED] and "[REDACTED]" but plain`
+		if got := w.redactor.redact(`s3cr"t and "s3cr\"t" but plain`); got != want {
+			t.Errorf("%s: secret not redacted, got: %q, want: %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	r := &redactor{}
+	rw := &redactingWriter{out: &buf, r: r}
+	rw.Write([]byte("before hunter2\n"))
+	r.add("hunter2")
+	r.add("")
+	line := []byte("after hunter2\n")
+	if n, err := rw.Write(line); err != nil || n != len(line) {
+		t.Errorf("unexpected result of Write, got: %d, %v", n, err)
+	}
+	want := "before hunter2\nafter [REDACTED]\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output, got: %q, want: %q", got, want)
+	}
+}
+
+func TestAddVarReplacesSecret(t *testing.T) {
+	w := New()
+	w.Vars = map[string]vars{"key": {ValueFromSecret: "projects/p/secrets/key/versions/1"}}
+	w.AddVar("key", "value")
+	if v := w.Vars["key"]; v.Value != "value" || v.ValueFromSecret != "" {
+		t.Errorf("AddVar did not replace the secret: %+v", v)
+	}
+	if strings.Contains(w.String(), "secrets/key") {
+		t.Errorf("workflow still references the secret: %s", w)
+	}
+}
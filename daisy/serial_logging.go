@@ -0,0 +1,110 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/api/transport"
+)
+
+func newLoggingClient(ctx context.Context, oauthPath string) (*logging.Service, error) {
+	hc, _, err := transport.NewHTTPClient(ctx, option.WithScopes(logging.LoggingWriteScope), option.WithCredentialsFile(oauthPath))
+	if err != nil {
+		return nil, fmt.Errorf("dialing: %v", err)
+	}
+	return logging.New(hc)
+}
+
+// serialLogger writes the serial port output of an instance to Cloud
+// Logging, a line per entry, as the "daisy-serial-port<port>" log of the
+// gce_instance resource of the instance.
+type serialLogger struct {
+	w   *Workflow
+	req *logging.WriteLogEntriesRequest
+	// Output after the last newline, written with the next line.
+	partial string
+}
+
+// newSerialLogger returns a serialLogger for port of the instance with id
+// created by step, or nil if w doesn't have SerialCloudLogging or
+// CloudLogging set.
+func (w *Workflow) newSerialLogger(step, project, zone, name string, id uint64, port int64) *serialLogger {
+	if w.loggingClient == nil {
+		return nil
+	}
+	return &serialLogger{
+		w: w,
+		req: &logging.WriteLogEntriesRequest{
+			LogName: fmt.Sprintf("projects/%s/logs/daisy-serial-port%d", project, port),
+			Resource: &logging.MonitoredResource{
+				Type: "gce_instance",
+				Labels: map[string]string{
+					"project_id":  project,
+					"zone":        zone,
+					"instance_id": strconv.FormatUint(id, 10),
+				},
+			},
+			Labels: map[string]string{
+				"daisy_workflow": w.qualifiedName(),
+				"daisy_run_id":   w.id,
+				"daisy_step":     step,
+				"instance_name":  name,
+			},
+		},
+	}
+}
+
+// write writes the complete lines of output, keeping the rest for the next
+// write or flush.
+func (l *serialLogger) write(output string) error {
+	lines := strings.Split(l.partial+output, "\n")
+	l.partial = lines[len(lines)-1]
+	return l.writeLines(lines[:len(lines)-1])
+}
+
+// flush writes the output after the last newline.
+func (l *serialLogger) flush() error {
+	if l.partial == "" {
+		return nil
+	}
+	p := l.partial
+	l.partial = ""
+	return l.writeLines([]string{p})
+}
+
+func (l *serialLogger) writeLines(lines []string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	var entries []*logging.LogEntry
+	for _, line := range lines {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		entries = append(entries, &logging.LogEntry{TextPayload: line, Timestamp: now})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	req := *l.req
+	req.Entries = entries
+	_, err := l.w.loggingClient.Entries.Write(&req).Do()
+	return err
+}
@@ -0,0 +1,78 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/logging/v2"
+)
+
+func TestSerialLogger(t *testing.T) {
+	var got []*logging.WriteLogEntriesRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &logging.WriteLogEntriesRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, req)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	if sl := w.newSerialLogger("s", "p", "z", "i", 123, 1); sl != nil {
+		t.Error("newSerialLogger should return nil without a logging client")
+	}
+	w.loggingClient, _ = logging.New(http.DefaultClient)
+	w.loggingClient.BasePath = ts.URL + "/"
+	sl := w.newSerialLogger("s", "p", "z", "i", 123, 1)
+
+	for _, output := range []string{"foo\r\nb", "ar\n\n", "baz"} {
+		if err := sl.write(output); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sl.flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var lines []string
+	for _, req := range got {
+		for _, e := range req.Entries {
+			lines = append(lines, e.TextPayload)
+		}
+	}
+	if diff := pretty.Compare(lines, []string{"foo", "bar", "baz"}); diff != "" {
+		t.Errorf("logged lines not as expected: (-got +want)\n%s", diff)
+	}
+	if len(got) != 3 {
+		t.Fatalf("unexpected number of writes, got: %d, want: 3", len(got))
+	}
+	if got[0].LogName != "projects/p/logs/daisy-serial-port1" {
+		t.Errorf("unexpected log name: %q", got[0].LogName)
+	}
+	wantRes := &logging.MonitoredResource{Type: "gce_instance", Labels: map[string]string{"project_id": "p", "zone": "z", "instance_id": "123"}}
+	if diff := pretty.Compare(got[0].Resource, wantRes); diff != "" {
+		t.Errorf("resource not as expected: (-got +want)\n%s", diff)
+	}
+	if got[0].Labels["instance_name"] != "i" || got[0].Labels["daisy_run_id"] != w.id || got[0].Labels["daisy_step"] != "s" {
+		t.Errorf("unexpected labels: %v", got[0].Labels)
+	}
+}
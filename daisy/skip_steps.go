@@ -0,0 +1,67 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// skipsStep returns true if s, or the step of the top level workflow it
+// is part of, is in the top level workflow's SkipSteps.
+func (w *Workflow) skipsStep(s *Step) bool {
+	root := w.root()
+	if len(root.SkipSteps) == 0 {
+		return false
+	}
+	return strIn(strings.SplitN(w.nestedName(s.name), ".", 2)[0], root.SkipSteps)
+}
+
+// validateSkipSteps checks that SkipSteps are steps of w, and that the
+// steps that run don't use or delete resources skipped steps create.
+func (w *Workflow) validateSkipSteps() error {
+	if w.parent != nil || len(w.SkipSteps) == 0 {
+		return nil
+	}
+	for _, name := range w.SkipSteps {
+		if _, ok := w.Steps[name]; !ok {
+			return fmt.Errorf("SkipSteps references non existent step %q", name)
+		}
+	}
+
+	for _, rm := range w.resourceMaps() {
+		rm.mx.Lock()
+		var names []string
+		for name := range rm.m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r := rm.m[name]
+			if r.creator == nil || !r.creator.w.skipsStep(r.creator) {
+				continue
+			}
+			for _, u := range append(r.users, r.deleter) {
+				if u != nil && !u.w.skipsStep(u) {
+					rm.mx.Unlock()
+					return fmt.Errorf("step %q uses %s %q, which skipped step %q creates, skip it too", u.name, rm.typeName, name, r.creator.name)
+				}
+			}
+		}
+		rm.mx.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSkipSteps(t *testing.T) {
+	w := testWorkflow()
+	var ran []string
+	runImpl := func(ctx context.Context, s *Step) error {
+		ran = append(ran, s.name)
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+		"s2": {name: "s2", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}}
+	w.SkipSteps = []string{"s1"}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(ran, []string{"s0", "s2"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+	if got := w.Steps["s1"].State(); got != StepFinished {
+		t.Errorf("unexpected state of skipped step, got: %s, want: %s", got, StepFinished)
+	}
+}
+
+func TestValidateSkipSteps(t *testing.T) {
+	tests := []struct {
+		desc      string
+		skip      []string
+		shouldErr bool
+	}{
+		{"no skipped steps case", nil, false},
+		{"skip unrelated step case", []string{"s3"}, false},
+		{"skip creator and users case", []string{"s0", "s1", "s2"}, false},
+		{"skip user case", []string{"s1"}, false},
+		{"skip creator case", []string{"s0"}, true},
+		{"skip creator and user case", []string{"s0", "s1"}, true},
+		{"bad step case", []string{"dne"}, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Steps = map[string]*Step{}
+		for _, name := range []string{"s0", "s1", "s2", "s3"} {
+			w.Steps[name] = &Step{name: name, w: w}
+		}
+		// s0 creates the disk, s1 uses it and s2 deletes it.
+		disks[w].m = map[string]*resource{
+			"d": {creator: w.Steps["s0"], users: []*Step{w.Steps["s1"]}, deleter: w.Steps["s2"]},
+		}
+		w.SkipSteps = tt.skip
+
+		err := w.validateSkipSteps()
+		if err == nil && tt.shouldErr {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if err != nil && !tt.shouldErr {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	snapshots      = map[*Workflow]*snapshotMap{}
+	snapshotURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/snapshots/(?P<snapshot>%[1]s)$`, rfc1035))
+)
+
+type snapshotMap struct {
+	baseResourceMap
+}
+
+func initSnapshotMap(w *Workflow) {
+	sm := &snapshotMap{baseResourceMap: baseResourceMap{w: w, typeName: "snapshot", urlRgx: snapshotURLRgx}}
+	sm.baseResourceMap.deleteFn = sm.deleteFn
+	sm.init()
+	snapshots[w] = sm
+}
+
+func (sm *snapshotMap) deleteFn(r *resource) error {
+	m := namedSubexp(snapshotURLRgx, r.link)
+	return sm.w.ComputeClient.DeleteSnapshot(m["project"], m["snapshot"])
+}
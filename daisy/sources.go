@@ -21,6 +21,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 
 	"cloud.google.com/go/storage"
@@ -65,68 +67,212 @@ func (w *Workflow) uploadFile(ctx context.Context, src, obj string) error {
 	return gcs.Close()
 }
 
+// sourceUpload is the upload of a source to the scratch sources path.
+type sourceUpload struct {
+	dst  string
+	done chan struct{}
+	err  error
+}
+
+// uploadSources uploads Sources, and those of subworkflows, and waits for
+// the uploads to finish.
 func (w *Workflow) uploadSources(ctx context.Context) error {
+	w.startSourceUploads(ctx)
+	return w.waitSourceUploads()
+}
+
+// startSourceUploads starts uploading Sources, and those of subworkflows,
+// in the background, each source on its own. Each step waits for the
+// uploads of the sources it uses before it runs, see waitSources, so steps
+// using no sources start right away. A failed upload cancels the workflow.
+func (w *Workflow) startSourceUploads(ctx context.Context) {
+	w.sourceUploads = map[string]*sourceUpload{}
 	for dst, origPath := range w.Sources {
 		if origPath == "" {
 			continue
 		}
-		// GCS to GCS.
-		if bkt, objPath, err := splitGCSPath(origPath); err == nil {
-			if objPath == "" || strings.HasSuffix(objPath, "/") {
-				if err := w.recursiveGCS(ctx, bkt, objPath, dst); err != nil {
-					return fmt.Errorf("error copying from bucket %s: %v", origPath, err)
-				}
-				continue
-			}
-			src := w.StorageClient.Bucket(bkt).Object(objPath)
-			dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, dst))
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
-				return fmt.Errorf("error copying from file %s: %v", origPath, err)
+		u := &sourceUpload{dst: dst, done: make(chan struct{})}
+		w.sourceUploads[dst] = u
+		go func(origPath string) {
+			defer close(u.done)
+			if u.err = w.uploadSource(ctx, u.dst, origPath); u.err != nil {
+				w.logger.Errorf("Error uploading source %q: %v", u.dst, u.err)
+				w.root().CancelWithReason(fmt.Sprintf("error uploading source %q: %v", u.dst, u.err))
 			}
+		}(origPath)
+	}
+	w.assignUploads(w)
+	for _, step := range w.Steps {
+		if step.SubWorkflow != nil {
+			step.SubWorkflow.w.startSourceUploads(ctx)
+		}
+	}
+}
 
-			continue
+// assignUploads records the uploads of w each step of iw waits for. iw is
+// w, or a workflow w includes, whose Sources were merged into those of w.
+func (w *Workflow) assignUploads(iw *Workflow) {
+	for _, s := range iw.Steps {
+		s.uploads = nil
+		for _, dst := range w.sourcesUsed(s) {
+			s.uploads = append(s.uploads, w.sourceUploads[dst])
 		}
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil {
+			w.assignUploads(s.IncludeWorkflow.w)
+		}
+		if s.ForEach != nil && s.ForEach.w != nil {
+			w.assignUploads(s.ForEach.w)
+		}
+	}
+}
 
-		// Local to GCS.
-		if !filepath.IsAbs(origPath) {
-			origPath = filepath.Join(w.workflowDir, origPath)
+// sourcesUsed returns the uploaded Sources s uses: those its fields name,
+// or reference by their path in the sources path. Instances can read any
+// source through their daisy-sources-path metadata, so CreateInstances
+// steps use all of them.
+func (w *Workflow) sourcesUsed(s *Step) []string {
+	used := map[string]bool{}
+	if s.CreateInstances != nil {
+		for dst := range w.sourceUploads {
+			used[dst] = true
 		}
-		fi, err := os.Stat(origPath)
-		if err != nil {
-			return err
+	}
+	sourcesPath := path.Join(w.bucket, w.sourcesPath)
+	traverseData(reflect.ValueOf(s).Elem(), func(v reflect.Value) error {
+		str, ok := v.Interface().(string)
+		if !ok {
+			return nil
 		}
-		if fi.IsDir() {
-			var files []string
-			if err := filepath.Walk(origPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					return nil
-				}
-				files = append(files, path)
-				return nil
-			}); err != nil {
-				return err
-			}
-			for _, file := range files {
-				obj := path.Join(dst, strings.TrimPrefix(file, filepath.Clean(origPath)))
-				if err := w.uploadFile(ctx, file, obj); err != nil {
-					return err
-				}
+		if _, ok := w.sourceUploads[str]; ok {
+			used[str] = true
+		}
+		i := strings.Index(str, sourcesPath)
+		if i < 0 {
+			return nil
+		}
+		rel := str[i+len(sourcesPath):]
+		if rel != "" && !strings.HasPrefix(rel, "/") {
+			return nil
+		}
+		rel = strings.TrimPrefix(rel, "/")
+		for dst := range w.sourceUploads {
+			// The whole sources path, a source, a file in a source
+			// directory, or a directory of sources.
+			if rel == "" || rel == dst || strings.HasPrefix(rel, dst+"/") || strings.HasPrefix(dst, rel) {
+				used[dst] = true
 			}
-			continue
 		}
-		if err := w.uploadFile(ctx, origPath, dst); err != nil {
-			return err
+		return nil
+	})
+
+	var result []string
+	for dst := range used {
+		result = append(result, dst)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// waitSources waits for the uploads of the sources s uses. It returns false
+// if the workflow was canceled first, and a *StepError if an upload failed.
+func (s *Step) waitSources() (bool, error) {
+	for _, u := range s.uploads {
+		select {
+		case <-u.done:
+		case <-s.w.cancelChan():
+			return false, nil
+		}
+		if u.err != nil {
+			return true, s.wrapRunError(fmt.Errorf("error uploading source %q: %v", u.dst, u.err))
+		}
+	}
+	return true, nil
+}
+
+// sourcesUploaded reports whether the uploads of the sources s uses are done.
+func (s *Step) sourcesUploaded() bool {
+	for _, u := range s.uploads {
+		select {
+		case <-u.done:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// waitSourceUploads waits for all uploads of w and its subworkflows to
+// finish, and returns the error of a failed one.
+func (w *Workflow) waitSourceUploads() error {
+	var dsts []string
+	for dst := range w.sourceUploads {
+		dsts = append(dsts, dst)
+	}
+	sort.Strings(dsts)
+	var err error
+	for _, dst := range dsts {
+		u := w.sourceUploads[dst]
+		<-u.done
+		if u.err != nil && err == nil {
+			err = u.err
 		}
 	}
 	for _, step := range w.Steps {
 		if step.SubWorkflow != nil {
-			if err := step.SubWorkflow.w.uploadSources(ctx); err != nil {
-				return err
+			if subErr := step.SubWorkflow.w.waitSourceUploads(); subErr != nil && err == nil {
+				err = subErr
 			}
 		}
 	}
+	return err
+}
+
+func (w *Workflow) uploadSource(ctx context.Context, dst, origPath string) error {
+	// GCS to GCS.
+	if bkt, objPath, err := splitGCSPath(origPath); err == nil {
+		if objPath == "" || strings.HasSuffix(objPath, "/") {
+			if err := w.recursiveGCS(ctx, bkt, objPath, dst); err != nil {
+				return fmt.Errorf("error copying from bucket %s: %v", origPath, err)
+			}
+			return nil
+		}
+		src := w.StorageClient.Bucket(bkt).Object(objPath)
+		dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, dst))
+		if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			return fmt.Errorf("error copying from file %s: %v", origPath, err)
+		}
+		return nil
+	}
+
+	// Local to GCS.
+	if !filepath.IsAbs(origPath) {
+		origPath = filepath.Join(w.workflowDir, origPath)
+	}
+	fi, err := os.Stat(origPath)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return w.uploadFile(ctx, origPath, dst)
+	}
+	var files []string
+	if err := filepath.Walk(origPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, file := range files {
+		obj := path.Join(dst, strings.TrimPrefix(file, filepath.Clean(origPath)))
+		if err := w.uploadFile(ctx, file, obj); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -16,13 +16,19 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"log"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
 )
 
 func TestUploadSources(t *testing.T) {
@@ -42,7 +48,7 @@ func TestUploadSources(t *testing.T) {
 	w := testWorkflow()
 	sw := w.NewSubWorkflow()
 	sw.Name = "test-sw"
-	sw.logger = log.New(ioutil.Discard, "", 0)
+	sw.logger = newLogger(sw, &textLogger{log.New(ioutil.Discard, "", 0)})
 	w.Steps = map[string]*Step{
 		"sub": {w: w, SubWorkflow: &SubWorkflow{w: sw}},
 	}
@@ -109,3 +115,89 @@ func TestUploadSources(t *testing.T) {
 		}
 	}
 }
+
+func TestSourcesUsed(t *testing.T) {
+	w := testWorkflow()
+	w.bucket = "bucket"
+	w.sourcesPath = "scratch/sources"
+	w.sourceUploads = map[string]*sourceUpload{"a.sh": {}, "dir": {}, "dir2": {}, "b.tar.gz": {}}
+
+	tests := []struct {
+		desc string
+		s    *Step
+		want []string
+	}{
+		{"no sources case", &Step{CopyGCSObjects: &CopyGCSObjects{{Source: "gs://other/x", Destination: "gs://other/y"}}}, nil},
+		{"source name case", &Step{CreateImages: &CreateImages{{Image: compute.Image{RawDisk: &compute.ImageRawDisk{Source: "b.tar.gz"}}}}}, []string{"b.tar.gz"}},
+		{"source path case", &Step{CopyGCSObjects: &CopyGCSObjects{{Source: "gs://bucket/scratch/sources/a.sh", Destination: "gs://other/y"}}}, []string{"a.sh"}},
+		{"file in source directory case", &Step{CopyGCSObjects: &CopyGCSObjects{{Source: "gs://bucket/scratch/sources/dir/file", Destination: "gs://other/y"}}}, []string{"dir"}},
+		{"sources path case", &Step{CopyGCSObjects: &CopyGCSObjects{{Source: "gs://bucket/scratch/sources", Destination: "gs://other/y"}}}, []string{"a.sh", "b.tar.gz", "dir", "dir2"}},
+		{"instances case", &Step{CreateInstances: &CreateInstances{{}}}, []string{"a.sh", "b.tar.gz", "dir", "dir2"}},
+	}
+	for _, tt := range tests {
+		if diff := pretty.Compare(w.sourcesUsed(tt.s), tt.want); diff != "" {
+			t.Errorf("%s: sources used do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestWaitSources(t *testing.T) {
+	w := testWorkflow()
+	upload := &sourceUpload{dst: "src", done: make(chan struct{})}
+	var order []string
+	var mx sync.Mutex
+	runImpl := func(ctx context.Context, s *Step) error {
+		mx.Lock()
+		order = append(order, s.name)
+		mx.Unlock()
+		if s.name == "free" {
+			// The upload finishes only once the step using no sources ran.
+			close(upload.done)
+		}
+		return nil
+	}
+	w.Steps = map[string]*Step{
+		"uses": {name: "uses", w: w, timeout: time.Minute, uploads: []*sourceUpload{upload}, testType: &mockStep{runImpl: runImpl}},
+		"free": {name: "free", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+	}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(order, []string{"free", "uses"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	w = testWorkflow()
+	failed := &sourceUpload{dst: "src", done: make(chan struct{}), err: errors.New("fail")}
+	close(failed.done)
+	w.Steps = map[string]*Step{
+		"uses": {name: "uses", w: w, timeout: time.Minute, uploads: []*sourceUpload{failed}, testType: &mockStep{}},
+	}
+	want := `step "uses" run error: error uploading source "src": fail`
+	if err := w.run(context.Background()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+
+	// Steps stop waiting for uploads when the workflow is canceled, also
+	// when run by an Executor that doesn't use the Scheduler.
+	for _, e := range []Executor{nil, serialExecutor} {
+		w = testWorkflow()
+		w.Executor = e
+		pending := &sourceUpload{dst: "src", done: make(chan struct{})}
+		ran := false
+		w.Steps = map[string]*Step{
+			"uses": {name: "uses", w: w, timeout: time.Minute, uploads: []*sourceUpload{pending}, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+				ran = true
+				return nil
+			}}},
+		}
+		time.AfterFunc(10*time.Millisecond, func() { w.CancelWithReason("canceled") })
+		if err := w.run(context.Background()); err != nil {
+			t.Errorf("executor %T: unexpected error: %v", e, err)
+		}
+		if ran || w.Steps["uses"].State() != StepSkipped {
+			t.Errorf("executor %T: step waiting for uploads ran after cancellation, state: %s", e, w.Steps["uses"].State())
+		}
+	}
+}
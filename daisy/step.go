@@ -38,27 +38,72 @@ type stepImpl interface {
 type Step struct {
 	name string
 	w    *Workflow
+	// StepState, accessed atomically, see State.
+	state int32
 
 	// Time to wait for this step to complete (default 10m).
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	Timeout string
 	timeout time.Duration
+	// Maximum number of instances a CreateInstances step creates at once,
+	// 0 for no limit.
+	MaxParallelInstances int `json:",omitempty"`
+	// Run the step even if the workflow fails or is canceled before it
+	// would run, e.g. to collect logs or send notifications.
+	RunAlways bool `json:",omitempty"`
+	// Don't fail the workflow if the step fails, steps depending on it run
+	// as if it succeeded. The failure is listed in RunResult.FailedSteps.
+	ContinueOnError bool `json:",omitempty"`
+	// Steps this step depends on, merged into the workflow's Dependencies
+	// when it is populated.
+	DependsOn []string `json:",omitempty"`
+	// Project and Zone of the resources the step creates or uses, and of
+	// the workflows it includes or runs, override the workflow's.
+	// Entries of the step setting their own Project or Zone override these.
+	Project string `json:",omitempty"`
+	Zone    string `json:",omitempty"`
 	// Only one of the below fields should exist for each instance of Step.
+	CreateAddresses        *CreateAddresses        `json:",omitempty"`
+	CreateBuckets          *CreateBuckets          `json:",omitempty"`
 	CreateDisks            *CreateDisks            `json:",omitempty"`
 	CreateImages           *CreateImages           `json:",omitempty"`
 	CreateInstances        *CreateInstances        `json:",omitempty"`
+	CreateNetworks         *CreateNetworks         `json:",omitempty"`
+	CreateResourcePolicies *CreateResourcePolicies `json:",omitempty"`
+	CreateSnapshots        *CreateSnapshots        `json:",omitempty"`
 	CopyGCSObjects         *CopyGCSObjects         `json:",omitempty"`
 	DeleteResources        *DeleteResources        `json:",omitempty"`
+	ForEach                *ForEach                `json:",omitempty"`
+	GrantRoles             *GrantRoles             `json:",omitempty"`
 	IncludeWorkflow        *IncludeWorkflow        `json:",omitempty"`
+	PruneImages            *PruneImages            `json:",omitempty"`
+	PublishImages          *PublishImages          `json:",omitempty"`
 	SubWorkflow            *SubWorkflow            `json:",omitempty"`
+	VerifyContentHashes    *VerifyContentHashes    `json:",omitempty"`
+	WaitForGCSObject       *WaitForGCSObject       `json:",omitempty"`
 	WaitForInstancesSignal *WaitForInstancesSignal `json:",omitempty"`
+	WriteTemplatedFiles    *WriteTemplatedFiles    `json:",omitempty"`
 	// Used for unit tests.
 	testType stepImpl
+	// Uploads of the Sources the step uses, see waitSources.
+	uploads []*sourceUpload
+	// When the dependencies of the step were done, and whether it holds
+	// one of the MaxParallelSteps slots, see schedule.
+	readyAt time.Time
+	slot    bool
 }
 
 func (s *Step) stepImpl() (stepImpl, error) {
 	var result stepImpl
 	matchCount := 0
+	if s.CreateAddresses != nil {
+		matchCount++
+		result = s.CreateAddresses
+	}
+	if s.CreateBuckets != nil {
+		matchCount++
+		result = s.CreateBuckets
+	}
 	if s.CreateDisks != nil {
 		matchCount++
 		result = s.CreateDisks
@@ -71,6 +116,18 @@ func (s *Step) stepImpl() (stepImpl, error) {
 		matchCount++
 		result = s.CreateInstances
 	}
+	if s.CreateNetworks != nil {
+		matchCount++
+		result = s.CreateNetworks
+	}
+	if s.CreateResourcePolicies != nil {
+		matchCount++
+		result = s.CreateResourcePolicies
+	}
+	if s.CreateSnapshots != nil {
+		matchCount++
+		result = s.CreateSnapshots
+	}
 	if s.CopyGCSObjects != nil {
 		matchCount++
 		result = s.CopyGCSObjects
@@ -79,18 +136,46 @@ func (s *Step) stepImpl() (stepImpl, error) {
 		matchCount++
 		result = s.DeleteResources
 	}
+	if s.ForEach != nil {
+		matchCount++
+		result = s.ForEach
+	}
+	if s.GrantRoles != nil {
+		matchCount++
+		result = s.GrantRoles
+	}
 	if s.IncludeWorkflow != nil {
 		matchCount++
 		result = s.IncludeWorkflow
 	}
+	if s.PruneImages != nil {
+		matchCount++
+		result = s.PruneImages
+	}
+	if s.PublishImages != nil {
+		matchCount++
+		result = s.PublishImages
+	}
 	if s.SubWorkflow != nil {
 		matchCount++
 		result = s.SubWorkflow
 	}
+	if s.VerifyContentHashes != nil {
+		matchCount++
+		result = s.VerifyContentHashes
+	}
+	if s.WaitForGCSObject != nil {
+		matchCount++
+		result = s.WaitForGCSObject
+	}
 	if s.WaitForInstancesSignal != nil {
 		matchCount++
 		result = s.WaitForInstancesSignal
 	}
+	if s.WriteTemplatedFiles != nil {
+		matchCount++
+		result = s.WriteTemplatedFiles
+	}
 	if s.testType != nil {
 		matchCount++
 		result = s.testType
@@ -172,6 +257,9 @@ func (s *Step) getChain() []*Step {
 		if st.IncludeWorkflow != nil && st.IncludeWorkflow.w == s.w {
 			return append(st.getChain(), s)
 		}
+		if st.ForEach != nil && st.ForEach.w == s.w {
+			return append(st.getChain(), s)
+		}
 		if st.SubWorkflow != nil && st.SubWorkflow.w == s.w {
 			return append(st.getChain(), s)
 		}
@@ -180,23 +268,46 @@ func (s *Step) getChain() []*Step {
 	return nil
 }
 
+// typeName returns the name of the step type of s, e.g. "CreateDisks", or
+// "" if s has no valid step type.
+func (s *Step) typeName() string {
+	impl, err := s.stepImpl()
+	if err != nil {
+		return ""
+	}
+	if t := reflect.TypeOf(impl); t.Kind() == reflect.Ptr {
+		return t.Elem().Name()
+	}
+	return reflect.TypeOf(impl).Name()
+}
+
+// project returns the project of s, its Project or the workflow's.
+func (s *Step) project() string {
+	return strOr(s.Project, s.w.Project)
+}
+
+// zone returns the zone of s, its Zone or the workflow's.
+func (s *Step) zone() string {
+	return strOr(s.Zone, s.w.Zone)
+}
+
 func (s *Step) run(ctx context.Context) error {
 	impl, err := s.stepImpl()
 	if err != nil {
 		return s.wrapRunError(err)
 	}
-	var st string
-	if t := reflect.TypeOf(impl); t.Kind() == reflect.Ptr {
-		st = t.Elem().Name()
-	} else {
-		st = t.Name()
+	if err := s.substituteOutputs(); err != nil {
+		return s.wrapRunError(err)
 	}
+	st := s.typeName()
 	s.w.logger.Printf("Running step %q (%s)", s.name, st)
-	if err = impl.run(ctx, s); err != nil {
+	if err = s.runImpl(ctx, impl); err != nil {
+		s.w.logger.StepError(s, err)
+		s.w.reportStepError(s, errorCategory(err), err)
 		return s.wrapRunError(err)
 	}
 	select {
-	case <-s.w.Cancel:
+	case <-s.w.cancelChan():
 	default:
 		s.w.logger.Printf("Step %q (%s) successfully finished.", s.name, st)
 	}
@@ -212,20 +323,46 @@ func (s *Step) validate(ctx context.Context) error {
 	if err != nil {
 		return s.wrapValidateError(err)
 	}
+	if s.MaxParallelInstances < 0 {
+		return s.wrapValidateError(fmt.Errorf("MaxParallelInstances must not be negative, got: %d", s.MaxParallelInstances))
+	}
+	if s.MaxParallelInstances > 0 && s.CreateInstances == nil {
+		return s.wrapValidateError(errors.New("MaxParallelInstances is only supported by CreateInstances steps"))
+	}
+	if err := s.validateOutputRefs(); err != nil {
+		return s.wrapValidateError(err)
+	}
 	if err = impl.validate(ctx, s); err != nil {
+		s.w.reportStepError(s, errCategoryValidation, err)
 		return s.wrapValidateError(err)
 	}
 	return nil
 }
 
+// wrapError wraps e in a *StepError for phase. An error already wrapped for
+// s in the same phase is returned as is.
+func (s *Step) wrapError(phase string, e error) error {
+	if se, ok := e.(*StepError); ok && se.Step == s.name && se.Phase == phase {
+		return e
+	}
+	var chain []string
+	for _, st := range s.getChain() {
+		chain = append(chain, st.name)
+	}
+	if chain == nil {
+		chain = []string{s.name}
+	}
+	return &StepError{Step: s.name, Chain: chain, Phase: phase, Err: e}
+}
+
 func (s *Step) wrapPopulateError(e error) error {
-	return fmt.Errorf("step %q populate error: %s", s.name, e)
+	return s.wrapError(PhasePopulate, e)
 }
 
 func (s *Step) wrapRunError(e error) error {
-	return fmt.Errorf("step %q run error: %s", s.name, e)
+	return s.wrapError(PhaseRun, e)
 }
 
 func (s *Step) wrapValidateError(e error) error {
-	return fmt.Errorf("step %q validation error: %s", s.name, e)
+	return s.wrapError(PhaseValidate, e)
 }
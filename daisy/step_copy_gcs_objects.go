@@ -107,7 +107,7 @@ func (c *CopyGCSObjects) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-w.cancelChan():
 		return nil
 	}
 }
@@ -0,0 +1,161 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// CreateAddresses is a Daisy CreateAddresses workflow step.
+type CreateAddresses []*CreateAddress
+
+// CreateAddress reserves a GCE static IP address. Addresses can be
+// referenced by name in the NetworkInterfaces[].NetworkIP and
+// NetworkInterfaces[].AccessConfigs[].NatIP fields of CreateInstances steps.
+type CreateAddress struct {
+	compute.Address
+
+	// Region to reserve the address in, overrides the region of the
+	// workflow Zone.
+	Region string `json:",omitempty"`
+	// Project to reserve the address in, overrides workflow Project.
+	Project string `json:",omitempty"`
+	// Should this resource be cleaned up after the workflow?
+	NoCleanup bool
+	// Should we use the user-provided reference name as the actual
+	// resource name?
+	ExactName bool
+
+	// The name of the address as known internally to Daisy.
+	daisyName string
+}
+
+// MarshalJSON is a hacky workaround to prevent CreateAddress from using
+// compute.Address's implementation.
+func (c *CreateAddress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*c)
+}
+
+// populate preprocesses fields: Name, Project, Region, Description, Subnetwork, and daisyName.
+// - sets defaults
+// - extends short partial URLs to include "projects/<project>"
+func (c *CreateAddresses) populate(ctx context.Context, s *Step) error {
+	for _, ca := range *c {
+		ca.daisyName = ca.Name
+		if !ca.ExactName {
+			ca.Name = s.w.genName(ca.Name)
+		}
+		ca.Project = strOr(ca.Project, s.project())
+		ca.Region = strOr(ca.Region, getRegionFromZone(s.zone()))
+		ca.Description = strOr(ca.Description, fmt.Sprintf("Address created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		ca.AddressType = strOr(ca.AddressType, "EXTERNAL")
+		if ca.Subnetwork != "" {
+			ca.Subnetwork = normalizeURL(ca.Subnetwork, subnetworkURLRegex, ca.Project, "regions/"+ca.Region+"/subnetworks")
+		}
+	}
+	return nil
+}
+
+func (c *CreateAddresses) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, ca := range *c {
+		if !checkName(ca.Name) {
+			errs.add(Errorf("cannot create address %q: bad name", ca.Name))
+		}
+		if err := s.w.validateProject(ca.Project); err != nil {
+			errs.add(Errorf("cannot create address: bad project: %q, error: %v", ca.Project, err))
+		}
+		if !checkName(ca.Region) {
+			errs.add(Errorf("cannot create address %q: bad region: %q", ca.Name, ca.Region))
+		}
+		if ca.Address.Address != "" && net.ParseIP(ca.Address.Address) == nil {
+			errs.add(Errorf("cannot create address %q: bad IP address: %q", ca.Name, ca.Address.Address))
+		}
+		switch ca.AddressType {
+		case "EXTERNAL":
+			if ca.Subnetwork != "" {
+				errs.add(Errorf("cannot create address %q: Subnetwork can only be set for INTERNAL addresses", ca.Name))
+			}
+		case "INTERNAL":
+			errs.add(ca.validateSubnetwork(s)...)
+		default:
+			errs.add(Errorf("cannot create address %q: bad AddressType: %q, must be EXTERNAL or INTERNAL", ca.Name, ca.AddressType))
+		}
+
+		// Register creation.
+		link := fmt.Sprintf("projects/%s/regions/%s/addresses/%s", ca.Project, ca.Region, ca.Name)
+		r := &resource{real: ca.Name, link: link, noCleanup: ca.NoCleanup}
+		if err := addresses[s.w].registerCreation(ca.daisyName, r, s); err != nil {
+			errs.add(Errorf(err.Error()))
+		}
+	}
+
+	return errs.cast()
+}
+
+func (c *CreateAddress) validateSubnetwork(s *Step) (errs Errors) {
+	result := namedSubexp(subnetworkURLRegex, c.Subnetwork)
+	if result == nil {
+		errs.add(Errorf("cannot create address %q: INTERNAL addresses need a Subnetwork, got: %q", c.Name, c.Subnetwork))
+	} else if result["region"] != c.Region {
+		errs.add(Errorf("cannot create address in region %q with Subnetwork in region %q: %q", c.Region, result["region"], c.Subnetwork))
+	} else if _, err := subnetworks[s.w].registerUsage(c.Subnetwork, s); err != nil {
+		errs.add(Errorf("cannot create address: can't use Subnetwork %q: %v", c.Subnetwork, err))
+	}
+	return
+}
+
+func (c *CreateAddresses) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, ca := range *c {
+		wg.Add(1)
+		go func(ca *CreateAddress) {
+			defer wg.Done()
+
+			if err := w.checkPolicy(&PlannedResource{Type: "address", Name: ca.Name, Project: ca.Project, Region: ca.Region, Resource: &ca.Address}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating address %q.", ca.Name)
+			if err := w.ComputeClient.CreateAddress(ca.Project, ca.Region, &ca.Address); err != nil {
+				e <- err
+				return
+			}
+			addresses[w].markCreated(ca.daisyName)
+		}(ca)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so addresses being created now can be released.
+		wg.Wait()
+		return nil
+	}
+}
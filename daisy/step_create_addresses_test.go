@@ -0,0 +1,132 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCreateAddressesPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	genFoo := w.genName("foo")
+	tests := []struct {
+		desc        string
+		input, want *CreateAddress
+	}{
+		{
+			"defaults case",
+			&CreateAddress{Address: compute.Address{Name: "foo"}},
+			&CreateAddress{Address: compute.Address{Name: genFoo, AddressType: "EXTERNAL"}, daisyName: "foo", Project: w.Project, Region: "test"},
+		},
+		{
+			"internal case",
+			&CreateAddress{Address: compute.Address{Name: "foo", AddressType: "INTERNAL", Subnetwork: "sn"}, Project: "pfoo", Region: "rfoo", ExactName: true},
+			&CreateAddress{Address: compute.Address{Name: "foo", AddressType: "INTERNAL", Subnetwork: "projects/pfoo/regions/rfoo/subnetworks/sn"}, daisyName: "foo", Project: "pfoo", Region: "rfoo", ExactName: true},
+		},
+	}
+
+	for _, tt := range tests {
+		cas := &CreateAddresses{tt.input}
+		if err := cas.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// Short circuit the description field -- difficult to test, and unimportant.
+		tt.want.Description = tt.input.Description
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateAddress does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateAddressesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	sn := "projects/" + testProject + "/regions/r/subnetworks/sn"
+
+	tests := []struct {
+		desc      string
+		ca        *CreateAddress
+		shouldErr bool
+	}{
+		{"normal case", &CreateAddress{daisyName: "a1", Address: compute.Address{Name: "a1", AddressType: "EXTERNAL"}, Project: testProject, Region: "r"}, false},
+		{"internal case", &CreateAddress{daisyName: "a2", Address: compute.Address{Name: "a2", AddressType: "INTERNAL", Address: "10.0.0.2", Subnetwork: sn}, Project: testProject, Region: "r"}, false},
+		{"dupe case", &CreateAddress{daisyName: "a1", Address: compute.Address{Name: "a1", AddressType: "EXTERNAL"}, Project: testProject, Region: "r"}, true},
+		{"bad name case", &CreateAddress{daisyName: "a3", Address: compute.Address{Name: "a!", AddressType: "EXTERNAL"}, Project: testProject, Region: "r"}, true},
+		{"bad project case", &CreateAddress{daisyName: "a4", Address: compute.Address{Name: "a4", AddressType: "EXTERNAL"}, Project: "p!", Region: "r"}, true},
+		{"bad region case", &CreateAddress{daisyName: "a5", Address: compute.Address{Name: "a5", AddressType: "EXTERNAL"}, Project: testProject, Region: "r!"}, true},
+		{"bad IP case", &CreateAddress{daisyName: "a6", Address: compute.Address{Name: "a6", AddressType: "EXTERNAL", Address: "bad"}, Project: testProject, Region: "r"}, true},
+		{"bad type case", &CreateAddress{daisyName: "a7", Address: compute.Address{Name: "a7", AddressType: "bad"}, Project: testProject, Region: "r"}, true},
+		{"external with subnetwork case", &CreateAddress{daisyName: "a8", Address: compute.Address{Name: "a8", AddressType: "EXTERNAL", Subnetwork: sn}, Project: testProject, Region: "r"}, true},
+		{"internal without subnetwork case", &CreateAddress{daisyName: "a9", Address: compute.Address{Name: "a9", AddressType: "INTERNAL"}, Project: testProject, Region: "r"}, true},
+		{"subnetwork in other region case", &CreateAddress{daisyName: "a10", Address: compute.Address{Name: "a10", AddressType: "INTERNAL", Subnetwork: sn}, Project: testProject, Region: "r2"}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.CreateAddresses = &CreateAddresses{tt.ca}
+		if err := s.CreateAddresses.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	want := "projects/" + testProject + "/regions/r/addresses/a1"
+	if r, ok := addresses[w].get("a1"); !ok || r.link != want {
+		t.Errorf("address a1 not registered as expected, got: %+v, want link: %q", r, want)
+	}
+}
+
+func TestCreateAddressesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	e := errors.New("error")
+	tests := []struct {
+		desc      string
+		clientErr error
+		wantErr   error
+	}{
+		{"normal case", nil, nil},
+		{"client error case", e, e},
+	}
+	for _, tt := range tests {
+		addresses[w].m = map[string]*resource{"a": {real: "a-real", link: "projects/p/regions/r/addresses/a-real"}}
+		var gotProject, gotRegion string
+		fake := func(p, r string, _ *compute.Address) error { gotProject, gotRegion = p, r; return tt.clientErr }
+		w.ComputeClient = &daisyCompute.TestClient{CreateAddressFn: fake}
+		cas := &CreateAddresses{{Address: compute.Address{Name: "a-real"}, Project: "p", Region: "r", daisyName: "a"}}
+		if err := cas.run(ctx, s); err != tt.wantErr {
+			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
+		}
+		if gotProject != "p" || gotRegion != "r" {
+			t.Errorf("%s: address created in wrong project/region, got: %q/%q, want: %q/%q", tt.desc, gotProject, gotRegion, "p", "r")
+		}
+		if r, _ := addresses[w].get("a"); r.created != (tt.clientErr == nil) {
+			t.Errorf("%s: unexpected created state: %t", tt.desc, r.created)
+		}
+	}
+}
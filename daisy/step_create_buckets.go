@@ -0,0 +1,180 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// CreateBuckets is a Daisy CreateBuckets workflow step.
+type CreateBuckets []*CreateBucket
+
+// CreateBucket creates a GCS bucket, e.g. for guests to exchange artifacts
+// in instead of the workflow's scratch bucket. On cleanup, the bucket is
+// deleted with the objects in it.
+type CreateBucket struct {
+	// Name of the bucket.
+	Name string
+	// Project to create the bucket in, overrides workflow Project.
+	Project string `json:",omitempty"`
+	// Location of the bucket, e.g. "US" or "us-central1". Defaults to the
+	// region of the workflow Zone.
+	Location string `json:",omitempty"`
+	// StorageClass of the bucket, e.g. "REGIONAL" or "NEARLINE". Defaults
+	// to the GCS default.
+	StorageClass string `json:",omitempty"`
+	// Labels to set on the bucket, in addition to those Daisy sets.
+	Labels map[string]string `json:",omitempty"`
+	// LifecycleRules of the bucket, e.g. to delete objects after a day.
+	LifecycleRules []*BucketLifecycleRule `json:",omitempty"`
+	// Should this resource be cleaned up after the workflow?
+	NoCleanup bool
+	// Should we use the user-provided reference name as the actual
+	// resource name?
+	ExactName bool
+
+	// The name of the bucket as known internally to Daisy.
+	daisyName string
+}
+
+// BucketLifecycleRule is a GCS object lifecycle rule. The Action applies to
+// the objects matching all conditions set.
+type BucketLifecycleRule struct {
+	// Action is "Delete" or "SetStorageClass".
+	Action string
+	// StorageClass objects are moved to by a "SetStorageClass" Action.
+	StorageClass string `json:",omitempty"`
+	// Conditions.
+	AgeInDays             int64    `json:",omitempty"`
+	MatchesStorageClasses []string `json:",omitempty"`
+	NumNewerVersions      int64    `json:",omitempty"`
+}
+
+func (r *BucketLifecycleRule) lifecycleRule() storage.LifecycleRule {
+	return storage.LifecycleRule{
+		Action: storage.LifecycleAction{Type: r.Action, StorageClass: r.StorageClass},
+		Condition: storage.LifecycleCondition{
+			AgeInDays:             r.AgeInDays,
+			MatchesStorageClasses: r.MatchesStorageClasses,
+			NumNewerVersions:      r.NumNewerVersions,
+		},
+	}
+}
+
+func (r *BucketLifecycleRule) validate() error {
+	switch r.Action {
+	case storage.DeleteAction:
+	case storage.SetStorageClassAction:
+		if r.StorageClass == "" {
+			return fmt.Errorf("%s lifecycle rule needs a StorageClass", r.Action)
+		}
+	default:
+		return fmt.Errorf("unknown lifecycle rule Action %q, must be one of %q or %q", r.Action, storage.DeleteAction, storage.SetStorageClassAction)
+	}
+	if r.AgeInDays <= 0 && r.NumNewerVersions <= 0 && len(r.MatchesStorageClasses) == 0 {
+		return fmt.Errorf("%s lifecycle rule needs a condition", r.Action)
+	}
+	return nil
+}
+
+// bucketAttrs returns the attributes to create the bucket with.
+func (cb *CreateBucket) bucketAttrs() *storage.BucketAttrs {
+	attrs := &storage.BucketAttrs{Name: cb.Name, Location: cb.Location, StorageClass: cb.StorageClass, Labels: cb.Labels}
+	for _, r := range cb.LifecycleRules {
+		attrs.Lifecycle.Rules = append(attrs.Lifecycle.Rules, r.lifecycleRule())
+	}
+	return attrs
+}
+
+// populate preprocesses fields: Name, Project, Location, Labels, and daisyName.
+// - sets defaults
+func (c *CreateBuckets) populate(ctx context.Context, s *Step) error {
+	for _, cb := range *c {
+		cb.daisyName = cb.Name
+		cb.Name = resourceNameHelper(cb.Name, s.w, cb.ExactName)
+		cb.Project = strOr(cb.Project, s.project())
+		cb.Location = strOr(cb.Location, getRegionFromZone(s.zone()))
+		cb.Labels = s.w.addWorkflowLabels(cb.Labels, cb.NoCleanup)
+	}
+	return nil
+}
+
+func (c *CreateBuckets) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, cb := range *c {
+		if !bucketNameRgx.MatchString(cb.Name) || strings.HasPrefix(cb.Name, "goog") {
+			errs.add(Errorf("cannot create bucket %q: bad name", cb.Name))
+		}
+		if err := s.w.validateProject(cb.Project); err != nil {
+			errs.add(Errorf("cannot create bucket: bad project: %q, error: %v", cb.Project, err))
+		}
+		for _, r := range cb.LifecycleRules {
+			if err := r.validate(); err != nil {
+				errs.add(Errorf("cannot create bucket %q: %v", cb.Name, err))
+			}
+		}
+
+		// Register creation.
+		r := &resource{real: cb.Name, link: "gs://" + cb.Name, noCleanup: cb.NoCleanup}
+		if err := buckets[s.w].registerCreation(cb.daisyName, r, s); err != nil {
+			errs.add(Errorf(err.Error()))
+		}
+	}
+
+	return errs.cast()
+}
+
+func (c *CreateBuckets) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, cb := range *c {
+		wg.Add(1)
+		go func(cb *CreateBucket) {
+			defer wg.Done()
+
+			attrs := cb.bucketAttrs()
+			if err := w.checkPolicy(&PlannedResource{Type: "bucket", Name: cb.Name, Project: cb.Project, Region: cb.Location, Resource: attrs}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating bucket %q.", cb.Name)
+			if err := w.StorageClient.Bucket(cb.Name).Create(ctx, cb.Project, attrs); err != nil {
+				e <- fmt.Errorf("error creating bucket %q: %v", cb.Name, err)
+				return
+			}
+			buckets[w].markCreated(cb.daisyName)
+		}(cb)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so buckets being created now can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
@@ -0,0 +1,185 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/option"
+)
+
+func TestCreateBucketsPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	tests := []struct {
+		desc        string
+		input, want *CreateBucket
+	}{
+		{
+			"defaults case",
+			&CreateBucket{Name: "foo"},
+			&CreateBucket{Name: w.genName("foo"), Project: testProject, Location: "test", Labels: testLabels, daisyName: "foo"},
+		},
+		{
+			"nondefaults case",
+			&CreateBucket{Name: "foo", Project: "pfoo", Location: "US", Labels: map[string]string{"key": "value"}, NoCleanup: true, ExactName: true},
+			&CreateBucket{
+				Name: "foo", Project: "pfoo", Location: "US", NoCleanup: true, ExactName: true, daisyName: "foo",
+				Labels: map[string]string{"key": "value", "daisy-workflow-name": testWf, "daisy-workflow-id": "abcdef", "daisy-username": "", "daisy-no-cleanup": "true"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		cbs := &CreateBuckets{tt.input}
+		if err := cbs.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateBucket does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateBucketsValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	tests := []struct {
+		desc      string
+		cb        *CreateBucket
+		shouldErr bool
+	}{
+		{"normal case", &CreateBucket{daisyName: "b1", Name: "b1-real", Project: testProject}, false},
+		{"lifecycle rules case", &CreateBucket{daisyName: "b2", Name: "b2-real", Project: testProject, LifecycleRules: []*BucketLifecycleRule{
+			{Action: "Delete", AgeInDays: 1},
+			{Action: "SetStorageClass", StorageClass: "NEARLINE", MatchesStorageClasses: []string{"REGIONAL"}},
+		}}, false},
+		{"dupe case", &CreateBucket{daisyName: "b1", Name: "b1-real", Project: testProject}, true},
+		{"bad name case", &CreateBucket{daisyName: "b3", Name: "B3!", Project: testProject}, true},
+		{"goog prefix case", &CreateBucket{daisyName: "b4", Name: "google-b4", Project: testProject}, true},
+		{"bad project case", &CreateBucket{daisyName: "b5", Name: "b5", Project: "p!"}, true},
+		{"bad action case", &CreateBucket{daisyName: "b6", Name: "b6", Project: testProject, LifecycleRules: []*BucketLifecycleRule{{Action: "Archive", AgeInDays: 1}}}, true},
+		{"no storage class case", &CreateBucket{daisyName: "b7", Name: "b7", Project: testProject, LifecycleRules: []*BucketLifecycleRule{{Action: "SetStorageClass", AgeInDays: 1}}}, true},
+		{"no condition case", &CreateBucket{daisyName: "b8", Name: "b8", Project: testProject, LifecycleRules: []*BucketLifecycleRule{{Action: "Delete"}}}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.CreateBuckets = &CreateBuckets{tt.cb}
+		if err := s.CreateBuckets.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	if r, ok := buckets[w].get("b1"); !ok || r.link != "gs://b1-real" {
+		t.Errorf("bucket b1 not registered as expected, got: %+v, want link: %q", r, "gs://b1-real")
+	}
+}
+
+// newTestBucketServer returns a storage client for a GCS fake holding the
+// object "obj" in every bucket, and the requests made to it.
+func newTestBucketServer(t *testing.T, createCode int) (*storage.Client, func() []string) {
+	var mx sync.Mutex
+	var reqs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		reqs = append(reqs, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, body))
+		mx.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/b":
+			w.WriteHeader(createCode)
+			fmt.Fprint(w, `{}`)
+		case r.Method == "GET":
+			fmt.Fprint(w, `{"items": [{"name": "obj"}]}`)
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	c, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() []string {
+		mx.Lock()
+		defer mx.Unlock()
+		return append([]string{}, reqs...)
+	}
+}
+
+func TestCreateBucketsRun(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		desc       string
+		createCode int
+		shouldErr  bool
+	}{
+		{"normal case", http.StatusOK, false},
+		{"client error case", http.StatusConflict, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s := &Step{w: w}
+		var reqs func() []string
+		w.StorageClient, reqs = newTestBucketServer(t, tt.createCode)
+		buckets[w].m = map[string]*resource{"b": {real: "b-real", link: "gs://b-real"}}
+		cbs := &CreateBuckets{{Name: "b-real", Project: "p", Location: "US", LifecycleRules: []*BucketLifecycleRule{{Action: "Delete", AgeInDays: 1}}, daisyName: "b"}}
+		if err := cbs.run(ctx, s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error returned: %v", tt.desc, err)
+		}
+		want := []string{`POST /b {"name":"b-real","location":"US","lifecycle":{"rule":[{"action":{"type":"Delete"},"condition":{"age":1}}]}}`}
+		if diff := pretty.Compare(reqs(), want); diff != "" {
+			t.Errorf("%s: requests do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if r, _ := buckets[w].get("b"); r.created != !tt.shouldErr {
+			t.Errorf("%s: unexpected created state: %t", tt.desc, r.created)
+		}
+	}
+}
+
+func TestBucketDelete(t *testing.T) {
+	w := testWorkflow()
+	var reqs func() []string
+	w.StorageClient, reqs = newTestBucketServer(t, http.StatusOK)
+	buckets[w].m = map[string]*resource{"b": {real: "b-real", link: "gs://b-real", created: true}}
+
+	if err := buckets[w].delete("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"GET /b/b-real/o ", "DELETE /b/b-real/o/obj ", "DELETE /b/b-real "}
+	if diff := pretty.Compare(reqs(), want); diff != "" {
+		t.Errorf("requests do not match expectation: (-got +want)\n%s", diff)
+	}
+	if r, _ := buckets[w].get("b"); !r.deleted {
+		t.Error("bucket not marked deleted")
+	}
+}
@@ -46,6 +46,8 @@ type CreateDisk struct {
 
 	// The name of the disk as known internally to Daisy.
 	daisyName string
+	// The region of a regional disk, derived from ReplicaZones.
+	region string
 }
 
 // MarshalJSON is a hacky workaround to prevent CreateDisk from using
@@ -60,9 +62,10 @@ func (c *CreateDisks) populate(ctx context.Context, s *Step) error {
 		if !cd.ExactName {
 			cd.Name = s.w.genName(cd.daisyName)
 		}
-		cd.Project = strOr(cd.Project, s.w.Project)
-		cd.Zone = strOr(cd.Zone, s.w.Zone)
+		cd.Project = strOr(cd.Project, s.project())
+		cd.Zone = strOr(cd.Zone, s.zone())
 		cd.Description = strOr(cd.Description, fmt.Sprintf("Disk created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		cd.Labels = s.w.addWorkflowLabels(cd.Labels, cd.NoCleanup)
 		if cd.SizeGb != "" {
 			size, err := strconv.ParseInt(cd.SizeGb, 10, 64)
 			if err != nil {
@@ -70,16 +73,20 @@ func (c *CreateDisks) populate(ctx context.Context, s *Step) error {
 			}
 			cd.Disk.SizeGb = size
 		}
-		if imageURLRgx.MatchString(cd.SourceImage) {
-			cd.SourceImage = extendPartialURL(cd.SourceImage, cd.Project)
+		cd.SourceImage = normalizeURL(cd.SourceImage, imageURLRgx, cd.Project, "")
+		cd.SourceSnapshot = normalizeURL(cd.SourceSnapshot, snapshotURLRgx, cd.Project, "")
+		populateKMSKey(cd.DiskEncryptionKey, cd.Project)
+
+		// Disks with ReplicaZones are regional disks in the zones' region.
+		typeScope := "zones/" + cd.Zone + "/diskTypes"
+		for i, z := range cd.ReplicaZones {
+			cd.ReplicaZones[i] = normalizeURL(z, zoneURLRgx, cd.Project, "zones")
 		}
-		if cd.Type == "" {
-			cd.Type = fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", cd.Project, cd.Zone)
-		} else if diskTypeURLRgx.MatchString(cd.Type) {
-			cd.Type = extendPartialURL(cd.Type, cd.Project)
-		} else {
-			cd.Type = fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", cd.Project, cd.Zone, cd.Type)
+		if len(cd.ReplicaZones) > 0 {
+			cd.region = getRegionFromZone(namedSubexp(zoneURLRgx, cd.ReplicaZones[0])["zone"])
+			typeScope = "regions/" + cd.region + "/diskTypes"
 		}
+		cd.Type = normalizeURL(strOr(cd.Type, "pd-standard"), diskTypeURLRgx, cd.Project, typeScope)
 	}
 	return nil
 }
@@ -89,26 +96,48 @@ func (c *CreateDisks) validate(ctx context.Context, s *Step) error {
 		if !checkName(cd.Name) {
 			return fmt.Errorf("cannot create disk: bad name: %q", cd.Name)
 		}
-		if err := checkProject(s.w.ComputeClient, cd.Project); err != nil {
+		if err := s.w.validateProject(cd.Project); err != nil {
 			return fmt.Errorf("cannot create disk: bad project: %q, error: %v", cd.Project, err)
 		}
-		if err := checkZone(s.w.ComputeClient, cd.Project, cd.Zone); err != nil {
+		if err := s.w.validateZone(cd.Project, cd.Zone); err != nil {
 			return fmt.Errorf("cannot create disk: bad zone: %q, error: %v", cd.Zone, err)
 		}
 		if !diskTypeURLRgx.MatchString(cd.Type) {
 			return fmt.Errorf("cannot create disk: bad disk type: %q", cd.Type)
 		}
+		if err := s.w.validateDiskType(cd.Type); err != nil {
+			return fmt.Errorf("cannot create disk: bad disk type: %q, error: %v", cd.Type, err)
+		}
+		if err := cd.validateReplicaZones(s); err != nil {
+			return err
+		}
+		if err := checkKMSKey(cd.DiskEncryptionKey); err != nil {
+			return fmt.Errorf("cannot create disk: bad DiskEncryptionKey: %v", err)
+		}
 
+		if cd.SourceImage != "" && cd.SourceSnapshot != "" {
+			return errors.New("cannot create disk: SourceImage and SourceSnapshot are mutually exclusive")
+		}
 		if cd.SourceImage != "" {
 			if _, err := images[s.w].registerUsage(cd.SourceImage, s); err != nil {
 				return fmt.Errorf("cannot create disk: can't use image %q: %v", cd.SourceImage, err)
 			}
+			if err := s.w.validateImageFamily(cd.SourceImage); err != nil {
+				return fmt.Errorf("cannot create disk: bad image family %q: %v", cd.SourceImage, err)
+			}
+		} else if cd.SourceSnapshot != "" {
+			if _, err := snapshots[s.w].registerUsage(cd.SourceSnapshot, s); err != nil {
+				return fmt.Errorf("cannot create disk: can't use snapshot %q: %v", cd.SourceSnapshot, err)
+			}
 		} else if cd.Disk.SizeGb == 0 {
-			return errors.New("cannot create disk: SizeGb and SourceImage not set")
+			return errors.New("cannot create disk: SizeGb, SourceImage and SourceSnapshot not set")
 		}
 
 		// Register creation.
 		link := fmt.Sprintf("projects/%s/zones/%s/disks/%s", cd.Project, cd.Zone, cd.Name)
+		if cd.region != "" {
+			link = fmt.Sprintf("projects/%s/regions/%s/disks/%s", cd.Project, cd.region, cd.Name)
+		}
 		r := &resource{real: cd.Name, link: link, noCleanup: cd.NoCleanup}
 		if err := disks[s.w].registerCreation(cd.daisyName, r, s); err != nil {
 			return fmt.Errorf("error creating disk: %s", err)
@@ -117,6 +146,35 @@ func (c *CreateDisks) validate(ctx context.Context, s *Step) error {
 	return nil
 }
 
+// validateReplicaZones checks the ReplicaZones and Type of a regional disk.
+func (cd *CreateDisk) validateReplicaZones(s *Step) error {
+	if cd.region == "" {
+		return nil
+	}
+	if len(cd.ReplicaZones) != 2 {
+		return fmt.Errorf("cannot create regional disk: exactly 2 ReplicaZones are required, got %d", len(cd.ReplicaZones))
+	}
+	for _, z := range cd.ReplicaZones {
+		m := namedSubexp(zoneURLRgx, z)
+		if m == nil {
+			return fmt.Errorf("cannot create regional disk: bad replica zone: %q", z)
+		}
+		if m["project"] != cd.Project {
+			return fmt.Errorf("cannot create regional disk in project %q with replica zone in project %q", cd.Project, m["project"])
+		}
+		if getRegionFromZone(m["zone"]) != cd.region {
+			return fmt.Errorf("cannot create regional disk: replica zones %q are not in the same region", cd.ReplicaZones)
+		}
+		if err := s.w.validateZone(cd.Project, m["zone"]); err != nil {
+			return fmt.Errorf("cannot create regional disk: bad replica zone: %q, error: %v", z, err)
+		}
+	}
+	if r := namedSubexp(diskTypeURLRgx, cd.Type)["region"]; r != cd.region {
+		return fmt.Errorf("cannot create regional disk in region %q with disk type %q", cd.region, cd.Type)
+	}
+	return nil
+}
+
 func (c *CreateDisks) run(ctx context.Context, s *Step) error {
 	var wg sync.WaitGroup
 	w := s.w
@@ -131,12 +189,32 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) error {
 				image, _ := images[w].get(cd.SourceImage)
 				cd.SourceImage = image.link
 			}
+			// Get the source snapshot link if using a source snapshot.
+			if cd.SourceSnapshot != "" {
+				snapshot, _ := snapshots[w].get(cd.SourceSnapshot)
+				cd.SourceSnapshot = snapshot.link
+			}
 
-			w.logger.Printf("CreateDisks: creating disk %q.", cd.Name)
-			if err := w.ComputeClient.CreateDisk(cd.Project, cd.Zone, &cd.Disk); err != nil {
+			move := func(from, to string) bool { return cd.moveZone(w, from, to) }
+			create := func(zone string) error {
+				pr := &PlannedResource{Type: "disk", Name: cd.Name, Project: cd.Project, Zone: zone, Resource: &cd.Disk}
+				if cd.region != "" {
+					pr.Zone, pr.Region = "", cd.region
+				}
+				if err := w.checkPolicy(pr); err != nil {
+					return err
+				}
+				w.logger.StepInfo(s, "creating disk %q.", cd.Name)
+				if cd.region != "" {
+					return w.ComputeClient.CreateRegionDisk(cd.Project, cd.region, &cd.Disk)
+				}
+				return w.ComputeClient.CreateDisk(cd.Project, zone, &cd.Disk)
+			}
+			if err := w.createInZones(s, cd.Name, cd.Zone, move, create); err != nil {
 				e <- err
 				return
 			}
+			disks[w].markCreated(cd.daisyName)
 		}(cd)
 	}
 
@@ -148,7 +226,7 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-w.cancelChan():
 		// Wait so disks being created now can be deleted.
 		wg.Wait()
 		return nil
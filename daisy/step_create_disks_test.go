@@ -69,12 +69,30 @@ func TestCreateDisksPopulate(t *testing.T) {
 			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
 			false,
 		},
+		{
+			"full URL case",
+			&CreateDisk{Disk: compute.Disk{Name: "foo", SourceImage: "https://www.googleapis.com/compute/v1/projects/pbar/global/images/family/fbar", Type: "https://www.googleapis.com/compute/v1/projects/pfoo/zones/zfoo/diskTypes/pd-ssd"}},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, SourceImage: "projects/pbar/global/images/family/fbar", Type: "projects/pfoo/zones/zfoo/diskTypes/pd-ssd"}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
+			false,
+		},
+		{
+			"regional disk case",
+			&CreateDisk{Disk: compute.Disk{Name: "foo", ReplicaZones: []string{"r1-a", "projects/pfoo/zones/r1-b"}}, Project: "pfoo"},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: "projects/pfoo/regions/r1/diskTypes/pd-standard", ReplicaZones: []string{"projects/pfoo/zones/r1-a", "projects/pfoo/zones/r1-b"}}, daisyName: "foo", Project: "pfoo", Zone: w.Zone, region: "r1"},
+			false,
+		},
 		{
 			"SourceImage daisy name case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo", SourceImage: "ifoo"}},
 			&CreateDisk{Disk: compute.Disk{Name: genFoo, SourceImage: "ifoo", Type: defType}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
 			false,
 		},
+		{
+			"extend KMS key case",
+			&CreateDisk{Disk: compute.Disk{Name: "foo", DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: "locations/global/keyRings/r/cryptoKeys/k"}}},
+			&CreateDisk{Disk: compute.Disk{Name: genFoo, Type: defType, DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: fmt.Sprintf("projects/%s/locations/global/keyRings/r/cryptoKeys/k", w.Project)}}, daisyName: "foo", Project: w.Project, Zone: w.Zone},
+			false,
+		},
 		{
 			"bad SizeGb case",
 			&CreateDisk{Disk: compute.Disk{Name: "foo"}, SizeGb: "ten"},
@@ -89,6 +107,7 @@ func TestCreateDisksPopulate(t *testing.T) {
 		// Short circuit the description field -- difficult to test, and unimportant.
 		if tt.want != nil {
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = testLabels
 		}
 		if tt.wantErr {
 			if err == nil {
@@ -107,6 +126,7 @@ func TestCreateDisksRun(t *testing.T) {
 	w := testWorkflow()
 	s := &Step{w: w}
 	images[w].m = map[string]*resource{"i1": {real: "i1", link: "i1link"}}
+	snapshots[w].m = map[string]*resource{"s1": {real: "s1", link: "s1link"}}
 
 	e := errors.New("error")
 	tests := []struct {
@@ -118,6 +138,7 @@ func TestCreateDisksRun(t *testing.T) {
 	}{
 		{"blank case", compute.Disk{}, compute.Disk{}, nil, nil},
 		{"resolve source image case", compute.Disk{SourceImage: "i1"}, compute.Disk{SourceImage: "i1link"}, nil, nil},
+		{"resolve source snapshot case", compute.Disk{SourceSnapshot: "s1"}, compute.Disk{SourceSnapshot: "s1link"}, nil, nil},
 		{"client error case", compute.Disk{}, compute.Disk{}, e, e},
 	}
 	for _, tt := range tests {
@@ -132,6 +153,24 @@ func TestCreateDisksRun(t *testing.T) {
 			t.Errorf("%s: client got incorrect disk, got: %v, want: %v", tt.desc, gotD, tt.wantD)
 		}
 	}
+
+	// Regional disks are created through the region-scoped API.
+	var gotRegion string
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateDiskFn:       func(_, _ string, _ *compute.Disk) error { return errors.New("zonal API used for regional disk") },
+		CreateRegionDiskFn: func(_, r string, _ *compute.Disk) error { gotRegion = r; return nil },
+	}
+	disks[w].m = map[string]*resource{"rd": {real: "rd", link: "projects/p/regions/r1/disks/rd"}}
+	cds := &CreateDisks{{Disk: compute.Disk{Name: "rd"}, daisyName: "rd", region: "r1"}}
+	if err := cds.run(ctx, s); err != nil {
+		t.Errorf("regional case: unexpected error: %v", err)
+	}
+	if gotRegion != "r1" {
+		t.Errorf("regional case: disk created in wrong region, got: %q, want: %q", gotRegion, "r1")
+	}
+	if r, _ := disks[w].get("rd"); !r.created {
+		t.Error("regional case: disk not marked as created")
+	}
 }
 
 func TestCreateDisksValidate(t *testing.T) {
@@ -143,9 +182,18 @@ func TestCreateDisksValidate(t *testing.T) {
 	w.Steps["iCreator"] = iCreator
 	images[w].m = map[string]*resource{"i1": {creator: iCreator}}
 
+	w.ComputeClient.(*daisyCompute.TestClient).GetZoneFn = func(_, zone string) (*compute.Zone, error) {
+		if strIn(zone, []string{testZone, "r1-a", "r1-b", "r2-a"}) {
+			return nil, nil
+		}
+		return nil, errors.New("bad zone")
+	}
+
 	expType := func(p, z, t string) string { return fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", p, z, t) }
 	n := "n"
 	ty := expType(testProject, testZone, "pd-standard")
+	rTy := fmt.Sprintf("projects/%s/regions/r1/diskTypes/pd-standard", testProject)
+	rZone := func(z string) string { return fmt.Sprintf("projects/%s/zones/%s", testProject, z) }
 	tests := []struct {
 		desc      string
 		cd        *CreateDisk
@@ -166,6 +214,46 @@ func TestCreateDisksValidate(t *testing.T) {
 			&CreateDisk{daisyName: "d3", Disk: compute.Disk{Name: n, SourceImage: "dne", Type: ty}, Project: testProject, Zone: testZone},
 			true,
 		},
+		{
+			"source snapshot url case",
+			&CreateDisk{daisyName: "d5", Disk: compute.Disk{Name: n, SourceSnapshot: "projects/p/global/snapshots/s", Type: ty}, Project: testProject, Zone: testZone},
+			false,
+		},
+		{
+			"source snapshot dne case",
+			&CreateDisk{daisyName: "d6", Disk: compute.Disk{Name: n, SourceSnapshot: "dne", Type: ty}, Project: testProject, Zone: testZone},
+			true,
+		},
+		{
+			"source image and snapshot case",
+			&CreateDisk{daisyName: "d6", Disk: compute.Disk{Name: n, SourceImage: "projects/p/global/images/i", SourceSnapshot: "projects/p/global/snapshots/s", Type: ty}, Project: testProject, Zone: testZone},
+			true,
+		},
+		{
+			"regional disk case",
+			&CreateDisk{daisyName: "d7", Disk: compute.Disk{Name: n, SizeGb: 1, Type: rTy, ReplicaZones: []string{rZone("r1-a"), rZone("r1-b")}}, Project: testProject, Zone: testZone, region: "r1"},
+			false,
+		},
+		{
+			"regional disk one zone case",
+			&CreateDisk{daisyName: "d8", Disk: compute.Disk{Name: n, SizeGb: 1, Type: rTy, ReplicaZones: []string{rZone("r1-a")}}, Project: testProject, Zone: testZone, region: "r1"},
+			true,
+		},
+		{
+			"regional disk mixed regions case",
+			&CreateDisk{daisyName: "d8", Disk: compute.Disk{Name: n, SizeGb: 1, Type: rTy, ReplicaZones: []string{rZone("r1-a"), rZone("r2-a")}}, Project: testProject, Zone: testZone, region: "r1"},
+			true,
+		},
+		{
+			"regional disk bad replica zone case",
+			&CreateDisk{daisyName: "d8", Disk: compute.Disk{Name: n, SizeGb: 1, Type: rTy, ReplicaZones: []string{rZone("r1-a"), rZone("r1-x")}}, Project: testProject, Zone: testZone, region: "r1"},
+			true,
+		},
+		{
+			"regional disk zonal type case",
+			&CreateDisk{daisyName: "d8", Disk: compute.Disk{Name: n, SizeGb: 1, Type: ty, ReplicaZones: []string{rZone("r1-a"), rZone("r1-b")}}, Project: testProject, Zone: testZone, region: "r1"},
+			true,
+		},
 		{
 			"blank disk case",
 			&CreateDisk{daisyName: "d3", Disk: compute.Disk{Name: n, SizeGb: 1, Type: ty}, Project: testProject, Zone: testZone},
@@ -196,6 +284,11 @@ func TestCreateDisksValidate(t *testing.T) {
 			&CreateDisk{daisyName: "d4", Disk: compute.Disk{Name: n, SizeGb: 1, Type: ty}, Project: testProject, Zone: "z!"},
 			true,
 		},
+		{
+			"bad KMS key case",
+			&CreateDisk{daisyName: "d4", Disk: compute.Disk{Name: n, SizeGb: 1, Type: ty, DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: "k", RawKey: "foo"}}, Project: testProject, Zone: testZone},
+			true,
+		},
 		{
 			"bad type case",
 			&CreateDisk{daisyName: "d4", Disk: compute.Disk{Name: n, SizeGb: 1, Type: "t!"}, Project: testProject, Zone: testZone},
@@ -234,6 +327,9 @@ func TestCreateDisksValidate(t *testing.T) {
 				t.Errorf("%s: did not return an error as expected", tt.desc)
 			}
 			wantLink := fmt.Sprintf("projects/%s/zones/%s/disks/%s", tt.cd.Project, tt.cd.Zone, tt.cd.Name)
+			if tt.cd.region != "" {
+				wantLink = fmt.Sprintf("projects/%s/regions/%s/disks/%s", tt.cd.Project, tt.cd.region, tt.cd.Name)
+			}
 			wantDisks[tt.cd.daisyName] = &resource{real: tt.cd.Name, link: wantLink, noCleanup: tt.cd.NoCleanup, deleted: false, creator: s, deleter: nil}
 			if tt.cd.SourceImage != "" {
 				wantImages.registerUsage(tt.cd.SourceImage, s)
@@ -16,9 +16,13 @@ package daisy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
 
 	compute "google.golang.org/api/compute/v1"
@@ -28,7 +32,8 @@ import (
 type CreateImages []*CreateImage
 
 // CreateImage creates a GCE image in a project.
-// Supported sources are a GCE disk or a RAW image listed in Workflow.Sources.
+// Supported sources are a GCE disk or a RAW image tar.gz, either listed in
+// Workflow.Sources or in GCS.
 type CreateImage struct {
 	compute.Image
 
@@ -40,11 +45,38 @@ type CreateImage struct {
 	// Should we use the user-provided reference name as the actual
 	// resource name?
 	ExactName bool
+	// GuestOsFeatures to enable on the image, as feature types, e.g.
+	// "UEFI_COMPATIBLE", or as GuestOsFeature objects.
+	GuestOsFeatures guestOsFeatures `json:"guestOsFeatures,omitempty"`
+	// RawDiskSHA256 is the hex encoded SHA256 checksum of the RawDisk.Source
+	// tar.gz. If set, the file is verified before the image is created.
+	RawDiskSHA256 string `json:",omitempty"`
 
 	// The name of the disk as known internally to Daisy.
 	daisyName string
 }
 
+type guestOsFeatures []*compute.GuestOsFeature
+
+// UnmarshalJSON unmarshals GuestOsFeatures from either a list of feature
+// types or a list of GuestOsFeature objects.
+func (g *guestOsFeatures) UnmarshalJSON(b []byte) error {
+	var sl []string
+	if err := json.Unmarshal(b, &sl); err == nil {
+		for _, s := range sl {
+			*g = append(*g, &compute.GuestOsFeature{Type: s})
+		}
+		return nil
+	}
+
+	var gl []*compute.GuestOsFeature
+	if err := json.Unmarshal(b, &gl); err != nil {
+		return err
+	}
+	*g = gl
+	return nil
+}
+
 // MarshalJSON is a hacky workaround to prevent CreateImage from using
 // compute.Image's implementation.
 func (c *CreateImage) MarshalJSON() ([]byte, error) {
@@ -61,12 +93,18 @@ func (c *CreateImages) populate(ctx context.Context, s *Step) error {
 		if !ci.ExactName {
 			ci.Name = s.w.genName(ci.daisyName)
 		}
-		ci.Project = strOr(ci.Project, s.w.Project)
+		ci.Project = strOr(ci.Project, s.project())
 		ci.Description = strOr(ci.Description, fmt.Sprintf("Image created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		ci.Labels = s.w.addWorkflowLabels(ci.Labels, ci.NoCleanup)
 
-		if diskURLRgx.MatchString(ci.SourceDisk) {
-			ci.SourceDisk = extendPartialURL(ci.SourceDisk, ci.Project)
+		ci.SourceDisk = normalizeURL(ci.SourceDisk, diskURLRgx, ci.Project, "")
+		for i, l := range ci.Licenses {
+			ci.Licenses[i] = normalizeURL(l, licenseURLRgx, ci.Project, "global/licenses")
 		}
+		if ci.GuestOsFeatures != nil {
+			ci.Image.GuestOsFeatures = ci.GuestOsFeatures
+		}
+		populateKMSKey(ci.ImageEncryptionKey, ci.Project)
 
 		if ci.RawDisk != nil {
 			if s.w.sourceExists(ci.RawDisk.Source) {
@@ -91,15 +129,37 @@ func (c *CreateImages) validate(ctx context.Context, s *Step) error {
 		}
 
 		// Project checking.
-		if err := checkProject(s.w.ComputeClient, ci.Project); err != nil {
+		if err := s.w.validateProject(ci.Project); err != nil {
 			return fmt.Errorf("cannot create image: bad project: %q, error: %v", ci.Project, err)
 		}
+		if err := checkKMSKey(ci.ImageEncryptionKey); err != nil {
+			return fmt.Errorf("cannot create image: bad ImageEncryptionKey: %v", err)
+		}
+		for _, l := range ci.Licenses {
+			if !licenseURLRgx.MatchString(l) {
+				return fmt.Errorf("cannot create image: bad license: %q", l)
+			}
+		}
+		for _, f := range ci.Image.GuestOsFeatures {
+			if !strIn(f.Type, guestOSFeatures) {
+				return fmt.Errorf("cannot create image: bad GuestOsFeature: %q, must be one of %q", f.Type, guestOSFeatures)
+			}
+		}
 
 		// Source disk checking.
 		if !xor(ci.SourceDisk == "", ci.RawDisk == nil) {
 			return errors.New("must provide either SourceDisk or RawDisk, exclusively")
 		}
 
+		if ci.RawDiskSHA256 != "" {
+			if ci.RawDisk == nil {
+				return errors.New("cannot create image: RawDiskSHA256 set without RawDisk")
+			}
+			if !sha256Rgx.MatchString(ci.RawDiskSHA256) {
+				return fmt.Errorf("cannot create image: bad RawDiskSHA256: %q", ci.RawDiskSHA256)
+			}
+		}
+
 		if ci.SourceDisk != "" {
 			if ci.RawDisk != nil {
 				return errors.New("must provide either SourceDisk or RawDisk, exclusively")
@@ -136,12 +196,31 @@ func (c *CreateImages) run(ctx context.Context, s *Step) error {
 				ci.SourceDisk = d.link
 			}
 
-			w.logger.Printf("CreateImages: creating image %q.", ci.Name)
+			if ci.RawDiskSHA256 != "" {
+				w.logger.StepInfo(s, "verifying SHA256 of %q.", ci.RawDisk.Source)
+				if err := verifyGCSObjectSHA256(ctx, w, ci.RawDisk.Source, ci.RawDiskSHA256); err != nil {
+					e <- err
+					return
+				}
+			}
+
+			if h := w.root().manifestHash; h != "" {
+				if ci.Labels == nil {
+					ci.Labels = map[string]string{}
+				}
+				ci.Labels[labelManifestHash] = h
+			}
+			if err := w.checkPolicy(&PlannedResource{Type: "image", Name: ci.Name, Project: project, Resource: &ci.Image}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating image %q.", ci.Name)
 			err := w.ComputeClient.CreateImage(project, &ci.Image)
 			if err != nil {
 				e <- err
 				return
 			}
+			images[w].markCreated(ci.daisyName)
 		}(ci)
 	}
 
@@ -153,9 +232,38 @@ func (c *CreateImages) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-w.cancelChan():
 		// Wait so images being created now will complete.
 		wg.Wait()
 		return nil
 	}
 }
+
+// verifyGCSObjectSHA256 reads the GCS object at path and checks that it has
+// the hex encoded SHA256 checksum want.
+func verifyGCSObjectSHA256(ctx context.Context, w *Workflow, path, want string) error {
+	bkt, obj, err := splitGCSPath(path)
+	if err != nil {
+		return err
+	}
+	r, err := w.StorageClient.Bucket(bkt).Object(obj).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading %q: %v", path, err)
+	}
+	defer r.Close()
+	if err := checkSHA256(r, want); err != nil {
+		return fmt.Errorf("error verifying %q: %v", path, err)
+	}
+	return nil
+}
+
+func checkSHA256(r io.Reader, want string) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return fmt.Errorf("SHA256 mismatch, got: %s, want: %s", got, strings.ToLower(want))
+	}
+	return nil
+}
@@ -16,8 +16,10 @@ package daisy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
@@ -25,6 +27,67 @@ import (
 	compute "google.golang.org/api/compute/v1"
 )
 
+func TestCreateImagesPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	genFoo := w.genName("foo")
+	features := []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}}
+	tests := []struct {
+		desc        string
+		input, want *CreateImage
+	}{
+		{
+			"defaults case",
+			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "d"}},
+			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "d"}, daisyName: "foo", Project: w.Project},
+		},
+		{
+			"extend licenses case",
+			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "d", Licenses: []string{"l1", "global/licenses/l2", "https://www.googleapis.com/compute/v1/projects/p/global/licenses/l3"}}, Project: "pfoo"},
+			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "d", Licenses: []string{"projects/pfoo/global/licenses/l1", "projects/pfoo/global/licenses/l2", "projects/p/global/licenses/l3"}}, daisyName: "foo", Project: "pfoo"},
+		},
+		{
+			"guest OS features case",
+			&CreateImage{Image: compute.Image{Name: "foo", SourceDisk: "d"}, GuestOsFeatures: features},
+			&CreateImage{Image: compute.Image{Name: genFoo, SourceDisk: "d", GuestOsFeatures: features}, GuestOsFeatures: features, daisyName: "foo", Project: w.Project},
+		},
+	}
+
+	for _, tt := range tests {
+		cis := &CreateImages{tt.input}
+		if err := cis.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// Short circuit the description field -- difficult to test, and unimportant.
+		tt.want.Description = tt.input.Description
+		tt.want.Labels = testLabels
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateImage does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestGuestOsFeaturesUnmarshalJSON(t *testing.T) {
+	want := guestOsFeatures{{Type: "UEFI_COMPATIBLE"}, {Type: "WINDOWS"}}
+	for _, input := range []string{`["UEFI_COMPATIBLE", "WINDOWS"]`, `[{"type": "UEFI_COMPATIBLE"}, {"type": "WINDOWS"}]`} {
+		var got guestOsFeatures
+		if err := json.Unmarshal([]byte(input), &got); err != nil {
+			t.Errorf("%s: unexpected error: %v", input, err)
+		}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("%s: unmarshalled GuestOsFeatures do not match expectation: (-got +want)\n%s", input, diff)
+		}
+	}
+
+	var got guestOsFeatures
+	if err := json.Unmarshal([]byte(`"UEFI_COMPATIBLE"`), &got); err == nil {
+		t.Error("expected error unmarshalling a string")
+	}
+}
+
 func TestCreateImagesRun(t *testing.T) {
 	ctx := context.Background()
 	w := testWorkflow()
@@ -44,6 +107,7 @@ func TestCreateImagesRun(t *testing.T) {
 		{"source disk case", &CreateImage{Image: compute.Image{SourceDisk: "d"}, Project: p}, nil, false},
 		{"raw image case", &CreateImage{Image: compute.Image{RawDisk: &compute.ImageRawDisk{Source: "gs://bucket/object"}}, Project: p}, nil, false},
 		{"client err case", &CreateImage{Image: compute.Image{SourceDisk: "d"}, Project: p}, errors.New("error"), true},
+		{"raw image SHA256 err case", &CreateImage{Image: compute.Image{RawDisk: &compute.ImageRawDisk{Source: "gs://bucket/dne.tar.gz"}}, Project: p, RawDiskSHA256: strings.Repeat("a", 64)}, nil, true},
 	}
 
 	type call struct {
@@ -73,6 +137,24 @@ func TestCreateImagesRun(t *testing.T) {
 	}
 }
 
+func TestCheckSHA256(t *testing.T) {
+	// SHA256 of "foo".
+	sum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		desc, want string
+		shouldErr  bool
+	}{
+		{"match case", sum, false},
+		{"upper case match case", strings.ToUpper(sum), false},
+		{"mismatch case", strings.Repeat("a", 64), true},
+	}
+	for _, tt := range tests {
+		if err := checkSHA256(strings.NewReader("foo"), tt.want); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error result: %v", tt.desc, err)
+		}
+	}
+}
+
 func TestCreateImagesValidate(t *testing.T) {
 	ctx := context.Background()
 
@@ -103,12 +185,21 @@ func TestCreateImagesValidate(t *testing.T) {
 		{"good raw disk case 2", &CreateImage{Project: testProject, Image: compute.Image{Name: "i3", RawDisk: &compute.ImageRawDisk{Source: "gs://some/path"}}}, false},
 		{"good disk url case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i4", SourceDisk: "zones/z/disks/d"}}, false},
 		{"good disk url case 2", &CreateImage{Project: testProject, Image: compute.Image{Name: "i5", SourceDisk: fmt.Sprintf("projects/%s/zones/z/disks/d", testProject)}}, false},
+		{"good KMS key case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i7", SourceDisk: "d1", ImageEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}}}, false},
+		{"bad KMS key case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i8", SourceDisk: "d1", ImageEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: "keyRings/r/cryptoKeys/k"}}}, true},
+		{"good licenses case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i9", SourceDisk: "d1", Licenses: []string{"projects/windows-cloud/global/licenses/windows-server-2016-dc"}}}, false},
+		{"good guest OS features case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i10", SourceDisk: "d1", GuestOsFeatures: []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}, {Type: "WINDOWS"}}}}, false},
+		{"bad license case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i11", SourceDisk: "d1", Licenses: []string{"licenses/l"}}}, true},
+		{"bad guest OS feature case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i11", SourceDisk: "d1", GuestOsFeatures: []*compute.GuestOsFeature{{Type: "FOO"}}}}, true},
 		{"bad name case", &CreateImage{Project: testProject, Image: compute.Image{Name: "bad!", SourceDisk: "d1"}}, true},
 		{"bad project case", &CreateImage{Project: "bad!", Image: compute.Image{Name: "i6", SourceDisk: "d1"}}, true},
 		{"bad dupe name case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i1", SourceDisk: "d1"}}, true},
 		{"bad missing dep on disk creator case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i6", SourceDisk: "d3"}}, true},
 		{"bad disk deleted case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i6", SourceDisk: "d2"}}, true},
 		{"bad using disk and raw disk case", &CreateImage{Project: testProject, Image: compute.Image{Name: "i6", SourceDisk: "d1", RawDisk: &compute.ImageRawDisk{Source: "gs://some/path"}}}, true},
+		{"good raw disk SHA256 case", &CreateImage{Project: testProject, RawDiskSHA256: strings.Repeat("a", 64), Image: compute.Image{Name: "i12", RawDisk: &compute.ImageRawDisk{Source: "gs://some/path.tar.gz"}}}, false},
+		{"bad raw disk SHA256 case", &CreateImage{Project: testProject, RawDiskSHA256: "abc", Image: compute.Image{Name: "i13", RawDisk: &compute.ImageRawDisk{Source: "gs://some/path.tar.gz"}}}, true},
+		{"bad SHA256 without raw disk case", &CreateImage{Project: testProject, RawDiskSHA256: strings.Repeat("a", 64), Image: compute.Image{Name: "i13", SourceDisk: "d1"}}, true},
 	}
 
 	for _, tt := range tests {
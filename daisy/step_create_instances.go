@@ -19,11 +19,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"path"
 	"sync"
 	"time"
 
-	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -49,6 +49,9 @@ type CreateInstance struct {
 	Project string `json:",omitempty"`
 	// Zone to create the instance in, overrides workflow Zone.
 	Zone string `json:",omitempty"`
+	// Enable or disable OS Login on the instance, defaults to the
+	// workflow's OSLogin.
+	OSLogin *bool `json:",omitempty"`
 	// Should this resource be cleaned up after the workflow?
 	NoCleanup bool
 	// Should we use the user-provided reference name as the actual resource name?
@@ -64,9 +67,24 @@ func (c *CreateInstance) MarshalJSON() ([]byte, error) {
 	return json.Marshal(*c)
 }
 
-func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64, interval time.Duration) {
+// logSerialOutput streams the serial port output of an instance created by
+// s to the logs path, to the log of s if StepLogs is set, and to Cloud
+// Logging if sl is not nil.
+func logSerialOutput(ctx context.Context, s *Step, project, zone, name string, port int64, interval time.Duration, sl *serialLogger) {
+	w := s.w
 	logsObj := path.Join(w.logsPath, fmt.Sprintf("%s-serial-port%d.log", name, port))
-	w.logger.Printf("CreateInstances: streaming instance %q serial port %d output to gs://%s/%s", name, port, w.bucket, logsObj)
+	w.logger.StepInfo(s, "streaming instance %q serial port %d output to gs://%s/%s", name, port, w.bucket, logsObj)
+	if sl != nil {
+		defer func() {
+			if err := sl.flush(); err != nil {
+				w.logger.StepInfo(s, "instance %q: error writing serial port output to Cloud Logging: %v", name, err)
+			}
+		}()
+	}
+	ssl := newSerialStepLog(s, name, port)
+	if ssl != nil {
+		defer ssl.flush()
+	}
 	var start int64
 	var buf bytes.Buffer
 	var errs int
@@ -76,26 +94,34 @@ func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64,
 		case <-ctx.Done():
 			return
 		case <-tick:
-			resp, err := w.ComputeClient.GetSerialPortOutput(w.Project, w.Zone, name, port, start)
+			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, port, start)
 			if err != nil {
 				// Instance was deleted by this workflow.
 				if _, ok := instances[w].get(name); !ok {
 					return
 				}
 				// Instance is stopped.
-				stopped, sErr := w.ComputeClient.InstanceStopped(w.Project, w.Zone, name)
+				stopped, sErr := w.ComputeClient.InstanceStopped(project, zone, name)
 				if stopped && sErr == nil {
 					return
 				}
-				w.logger.Printf("CreateInstances: instance %q: error getting serial port: %v", name, err)
+				w.logger.StepInfo(s, "instance %q: error getting serial port: %v", name, err)
 				return
 			}
 			start = resp.Next
 			buf.WriteString(resp.Contents)
+			if ssl != nil {
+				ssl.write(resp.Contents)
+			}
+			if sl != nil {
+				if err := sl.write(resp.Contents); err != nil {
+					w.logger.StepInfo(s, "instance %q: error writing serial port output to Cloud Logging: %v", name, err)
+				}
+			}
 			wc := w.StorageClient.Bucket(w.bucket).Object(logsObj).NewWriter(ctx)
 			wc.ContentType = "text/plain"
 			if _, err := wc.Write(buf.Bytes()); err != nil {
-				w.logger.Printf("CreateInstances: instance %q: error writing log to GCS: %v", name, err)
+				w.logger.StepInfo(s, "instance %q: error writing log to GCS: %v", name, err)
 				return
 			}
 			if err := wc.Close(); err != nil {
@@ -103,7 +129,7 @@ func logSerialOutput(ctx context.Context, w *Workflow, name string, port int64,
 					errs++
 					continue
 				}
-				w.logger.Printf("CreateInstances: instance %q: error saving log to GCS: %v", name, err)
+				w.logger.StepInfo(s, "instance %q: error saving log to GCS: %v", name, err)
 				return
 			}
 			errs = 0
@@ -117,9 +143,7 @@ func (c *CreateInstance) populateDisks(w *Workflow) *Error {
 		d.Boot = i == 0 // TODO(crunkleton) should we do this?
 		d.Mode = strOr(d.Mode, defaultDiskMode)
 		p := d.InitializeParams
-		if diskURLRgx.MatchString(d.Source) {
-			d.Source = extendPartialURL(d.Source, c.Project)
-		}
+		d.Source = normalizeURL(d.Source, diskURLRgx, c.Project, "")
 		if p != nil {
 			// If name isn't set, set name to "instance-name", "instance-name-2", etc.
 			if p.DiskName == "" {
@@ -130,30 +154,19 @@ func (c *CreateInstance) populateDisks(w *Workflow) *Error {
 				autonameIdx++
 			}
 
-			// Extend SourceImage if short URL.
-			if imageURLRgx.MatchString(p.SourceImage) {
-				p.SourceImage = extendPartialURL(p.SourceImage, c.Project)
-			}
+			p.SourceImage = normalizeURL(p.SourceImage, imageURLRgx, c.Project, "")
+			p.Labels = w.addWorkflowLabels(p.Labels, c.NoCleanup)
 
-			// Extend DiskType if short URL, or create extended URL.
-			p.DiskType = strOr(p.DiskType, defaultDiskType)
-			if diskTypeURLRgx.MatchString(p.DiskType) {
-				p.DiskType = extendPartialURL(p.DiskType, c.Project)
-			} else {
-				p.DiskType = fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", c.Project, c.Zone, p.DiskType)
-			}
+			populateKMSKey(d.DiskEncryptionKey, c.Project)
+
+			p.DiskType = normalizeURL(strOr(p.DiskType, defaultDiskType), diskTypeURLRgx, c.Project, "zones/"+c.Zone+"/diskTypes")
 		}
 	}
 	return nil
 }
 
 func (c *CreateInstance) populateMachineType() *Error {
-	c.MachineType = strOr(c.MachineType, "n1-standard-1")
-	if machineTypeURLRegex.MatchString(c.MachineType) {
-		c.MachineType = extendPartialURL(c.MachineType, c.Project)
-	} else {
-		c.MachineType = fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", c.Project, c.Zone, c.MachineType)
-	}
+	c.MachineType = normalizeURL(strOr(c.MachineType, "n1-standard-1"), machineTypeURLRegex, c.Project, "zones/"+c.Zone+"/machineTypes")
 	return nil
 }
 
@@ -164,6 +177,12 @@ func (c *CreateInstance) populateMetadata(w *Workflow) *Error {
 	if c.Instance.Metadata == nil {
 		c.Instance.Metadata = &compute.Metadata{}
 	}
+	for k, v := range w.commonInstanceMetadata() {
+		if _, ok := c.Metadata[k]; ok || c.hasMetadataItem(k) {
+			continue
+		}
+		c.Metadata[k] = v
+	}
 	c.Metadata["daisy-sources-path"] = "gs://" + path.Join(w.bucket, w.sourcesPath)
 	c.Metadata["daisy-logs-path"] = "gs://" + path.Join(w.bucket, w.logsPath)
 	c.Metadata["daisy-outs-path"] = "gs://" + path.Join(w.bucket, w.outsPath)
@@ -175,6 +194,12 @@ func (c *CreateInstance) populateMetadata(w *Workflow) *Error {
 		c.Metadata["startup-script-url"] = c.StartupScript
 		c.Metadata["windows-startup-script-url"] = c.StartupScript
 	}
+	if c.OSLogin != nil {
+		c.Metadata["enable-oslogin"] = "FALSE"
+		if *c.OSLogin {
+			c.Metadata["enable-oslogin"] = "TRUE"
+		}
+	}
 	for k, v := range c.Metadata {
 		vCopy := v
 		c.Instance.Metadata.Items = append(c.Instance.Metadata.Items, &compute.MetadataItems{Key: k, Value: &vCopy})
@@ -182,7 +207,31 @@ func (c *CreateInstance) populateMetadata(w *Workflow) *Error {
 	return nil
 }
 
-func (c *CreateInstance) populateNetworks() *Error {
+// hasMetadataItem reports whether the instance's metadata items set key.
+func (c *CreateInstance) hasMetadataItem(key string) bool {
+	for _, item := range c.Instance.Metadata.Items {
+		if item != nil && item.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// commonInstanceMetadata returns the CommonInstanceMetadata of w and its
+// parents, that of w taking precedence.
+func (w *Workflow) commonInstanceMetadata() map[string]string {
+	md := map[string]string{}
+	for wf := w; wf != nil; wf = wf.parent {
+		for k, v := range wf.CommonInstanceMetadata {
+			if _, ok := md[k]; !ok {
+				md[k] = v
+			}
+		}
+	}
+	return md
+}
+
+func (c *CreateInstance) populateNetworks(w *Workflow) *Error {
 	defaultAcs := []*compute.AccessConfig{{Type: defaultAccessConfigType}}
 	defaultN := "default"
 
@@ -193,17 +242,46 @@ func (c *CreateInstance) populateNetworks() *Error {
 		if n.AccessConfigs == nil {
 			n.AccessConfigs = defaultAcs
 		}
-		n.Network = strOr(n.Network, defaultN)
-		if networkURLRegex.MatchString(n.Network) {
-			n.Network = extendPartialURL(n.Network, c.Project)
+		// GCE infers the network from the subnetwork.
+		if n.Network == "" && n.Subnetwork == "" {
+			n.Network = defaultN
+		}
+		// Networks created by this workflow are referenced by name, any
+		// other name is a network in the instance's project. Networks in
+		// other projects, such as a Shared VPC host project, need a URL.
+		if w.createsNetwork(n.Network) {
+			n.Network = normalizeURL(n.Network, networkURLRegex, c.Project, "")
 		} else {
-			n.Network = fmt.Sprintf("projects/%s/global/networks/%s", c.Project, n.Network)
+			n.Network = normalizeURL(n.Network, networkURLRegex, c.Project, "global/networks")
+		}
+		n.Subnetwork = normalizeURL(n.Subnetwork, subnetworkURLRegex, c.Project, "regions/"+getRegionFromZone(c.Zone)+"/subnetworks")
+		// Static addresses are referenced by name or URL in place of an IP.
+		n.NetworkIP = normalizeURL(n.NetworkIP, addressURLRegex, c.Project, "")
+		for _, ac := range n.AccessConfigs {
+			ac.NatIP = normalizeURL(ac.NatIP, addressURLRegex, c.Project, "")
 		}
 	}
 
 	return nil
 }
 
+// populateResourcePolicies extends partial resource policy URLs, names are
+// resolved during validation as they refer to policies created by the
+// workflow.
+func (c *CreateInstance) populateResourcePolicies() {
+	for i, rp := range c.ResourcePolicies {
+		c.ResourcePolicies[i] = normalizeURL(rp, resourcePolicyURLRegex, c.Project, "")
+	}
+}
+
+// populateReservationAffinity defaults the key of SPECIFIC_RESERVATION
+// affinities to the reservation name.
+func (c *CreateInstance) populateReservationAffinity() {
+	if ra := c.ReservationAffinity; ra != nil && ra.ConsumeReservationType == reservationSpecific {
+		ra.Key = strOr(ra.Key, reservationNameKey)
+	}
+}
+
 func (c *CreateInstance) populateScopes() *Error {
 	if len(c.Scopes) == 0 {
 		c.Scopes = append(c.Scopes, "https://www.googleapis.com/auth/devstorage.read_only")
@@ -225,15 +303,22 @@ func (c *CreateInstances) populate(ctx context.Context, s *Step) error {
 		if !ci.ExactName {
 			ci.Name = s.w.genName(ci.Name)
 		}
-		ci.Project = strOr(ci.Project, s.w.Project)
-		ci.Zone = strOr(ci.Zone, s.w.Zone)
+		ci.Project = strOr(ci.Project, s.project())
+		ci.Zone = strOr(ci.Zone, s.zone())
+		if ci.OSLogin == nil && s.w.OSLogin {
+			osLogin := true
+			ci.OSLogin = &osLogin
+		}
 		ci.Description = strOr(ci.Description, fmt.Sprintf("Instance created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		ci.Labels = s.w.addWorkflowLabels(ci.Labels, ci.NoCleanup)
 
 		errs.add(ci.populateDisks(s.w))
 		errs.add(ci.populateMachineType())
 		errs.add(ci.populateMetadata(s.w))
-		errs.add(ci.populateNetworks())
+		errs.add(ci.populateNetworks(s.w))
 		errs.add(ci.populateScopes())
+		ci.populateReservationAffinity()
+		ci.populateResourcePolicies()
 	}
 
 	return errs.cast()
@@ -247,6 +332,8 @@ func (c *CreateInstance) validateDisks(ctx context.Context, s *Step) (errs Error
 	for _, d := range c.Disks {
 		if !checkDiskMode(d.Mode) {
 			errs.add(Errorf("cannot create instance: bad disk mode: %q", d.Mode))
+		} else if d.Boot && path.Base(d.Mode) == diskModeRO {
+			errs.add(Errorf("cannot create instance: boot disk can't be attached %s, only other disks can be shared between instances", diskModeRO))
 		}
 		if d.Source != "" && d.InitializeParams != nil {
 			errs.add(Errorf("cannot create instance: disk.source and disk.initializeParams are mutually exclusive"))
@@ -266,13 +353,23 @@ func (c *CreateInstance) validateDiskSource(d *compute.AttachedDisk, s *Step) (e
 		errs.add(Errorf(err.Error()))
 		return
 	}
+	// Disks can't be deleted while attached, keep them along with the instance.
+	if c.NoCleanup {
+		disks[s.w].mx.Lock()
+		dr.noCleanup = true
+		disks[s.w].mx.Unlock()
+	}
 
 	// Ensure disk is in the same project and zone.
 	result := namedSubexp(diskURLRgx, dr.link)
 	if result["project"] != c.Project {
 		errs.add(Errorf("cannot create instance in project %q with disk in project %q: %q", c.Project, result["project"], d.Source))
 	}
-	if result["zone"] != c.Zone {
+	if result["region"] != "" {
+		if result["region"] != getRegionFromZone(c.Zone) {
+			errs.add(Errorf("cannot create instance in zone %q with disk in region %q: %q", c.Zone, result["region"], d.Source))
+		}
+	} else if result["zone"] != c.Zone {
 		errs.add(Errorf("cannot create instance in project %q with disk in zone %q: %q", c.Zone, result["zone"], d.Source))
 	}
 	return
@@ -285,6 +382,11 @@ func (c *CreateInstance) validateDiskInitializeParams(d *compute.AttachedDisk, s
 	}
 	if _, err := images[s.w].registerUsage(p.SourceImage, s); err != nil {
 		errs.add(Errorf("cannot create instance: can't use InitializeParams.SourceImage %q: %v", p.SourceImage, err))
+	} else if err := s.w.validateImageFamily(p.SourceImage); err != nil {
+		errs.add(Errorf("cannot create instance: bad InitializeParams.SourceImage family %q: %v", p.SourceImage, err))
+	}
+	if err := checkKMSKey(d.DiskEncryptionKey); err != nil {
+		errs.add(Errorf("cannot create instance: bad DiskEncryptionKey: %v", err))
 	}
 	parts := namedSubexp(diskTypeURLRgx, p.DiskType)
 	if parts["project"] != c.Project {
@@ -293,17 +395,22 @@ func (c *CreateInstance) validateDiskInitializeParams(d *compute.AttachedDisk, s
 	if parts["zone"] != c.Zone {
 		errs.add(Errorf("cannot create instance in zone %q with InitializeParams.DiskType in zone %q", c.Zone, parts["zone"]))
 	}
+	if parts != nil {
+		if err := s.w.validateDiskType(p.DiskType); err != nil {
+			errs.add(Errorf("cannot create instance: bad InitializeParams.DiskType: %q, error: %v", p.DiskType, err))
+		}
+	}
 
 	link := fmt.Sprintf("projects/%s/zones/%s/disks/%s", c.Project, c.Zone, p.DiskName)
-	// Set cleanup if not being autodeleted.
-	r := &resource{real: p.DiskName, link: link, noCleanup: d.AutoDelete}
+	// Set cleanup if not being autodeleted or kept along with the instance.
+	r := &resource{real: p.DiskName, link: link, noCleanup: d.AutoDelete || c.NoCleanup}
 	if err := disks[s.w].registerCreation(p.DiskName, r, s); err != nil {
 		errs.add(Errorf(err.Error()))
 	}
 	return
 }
 
-func (c *CreateInstance) validateMachineType(client daisyCompute.Client) (errs Errors) {
+func (c *CreateInstance) validateMachineType(w *Workflow) (errs Errors) {
 	if !machineTypeURLRegex.MatchString(c.MachineType) {
 		errs.add(Errorf("can't create instance: bad MachineType: %q", c.MachineType))
 		return
@@ -316,24 +423,143 @@ func (c *CreateInstance) validateMachineType(client daisyCompute.Client) (errs E
 	if result["zone"] != c.Zone {
 		errs.add(Errorf("cannot create instance in zone %q with MachineType in zone %q: %q", c.Zone, result["zone"], c.MachineType))
 	}
+	if w.skipsValidation(ValidationMachineTypes) {
+		return
+	}
 
-	if err := checkMachineType(client, result["project"], result["zone"], result["machinetype"]); err != nil {
+	if err := checkMachineType(w.ComputeClient, result["project"], result["zone"], result["machinetype"]); err != nil {
 		errs.add(Errorf("cannot create instance, bad machineType: %q, error: %v", result["machinetype"], err))
+		return
+	}
+
+	if c.MinCpuPlatform != "" {
+		if err := checkMinCPUPlatform(w.ComputeClient, result["project"], result["zone"], result["machinetype"], c.MinCpuPlatform); err != nil {
+			errs.add(Errorf("cannot create instance, bad MinCpuPlatform: %q, error: %v", c.MinCpuPlatform, err))
+		}
+	}
+	return
+}
+
+func (c *CreateInstance) validateReservationAffinity() (errs Errors) {
+	ra := c.ReservationAffinity
+	if ra == nil {
+		return
+	}
+	if !strIn(ra.ConsumeReservationType, validReservationTypes) {
+		errs.add(Errorf("cannot create instance %q: bad ReservationAffinity.ConsumeReservationType: %q, must be one of %q", c.Name, ra.ConsumeReservationType, validReservationTypes))
+		return
+	}
+	if ra.ConsumeReservationType != reservationSpecific {
+		if ra.Key != "" || len(ra.Values) > 0 {
+			errs.add(Errorf("cannot create instance %q: ReservationAffinity.Key and Values can only be set for %s", c.Name, reservationSpecific))
+		}
+		return
+	}
+	if len(ra.Values) == 0 {
+		errs.add(Errorf("cannot create instance %q: %s needs the reservations in ReservationAffinity.Values", c.Name, reservationSpecific))
+	}
+	if ra.Key != reservationNameKey {
+		return
+	}
+	for _, v := range ra.Values {
+		if !checkName(v) && !reservationURLRgx.MatchString(v) {
+			errs.add(Errorf("cannot create instance %q: bad reservation in ReservationAffinity.Values: %q", c.Name, v))
+		}
 	}
 	return
 }
 
-func (c *CreateInstance) validateNetworks() (errs Errors) {
+func (c *CreateInstance) validateNetworks(s *Step) (errs Errors) {
 	for _, n := range c.NetworkInterfaces {
-		match := networkURLRegex.FindStringSubmatch(n.Network)
-		if match == nil {
-			errs.add(Errorf("can't create instance: bad value for NetworkInterface.Network: %q", n.Network))
-		} else {
-			result := namedSubexp(networkURLRegex, n.Network)
-			if result["project"] != c.Project {
-				errs.add(Errorf("cannot create instance in project %q with Network in project %q: %q", c.Project, result["project"], n.Network))
+		if n.Network != "" {
+			errs.add(c.validateNetwork(n, s)...)
+		}
+		if n.Subnetwork != "" {
+			result := namedSubexp(subnetworkURLRegex, n.Subnetwork)
+			if result == nil {
+				errs.add(Errorf("can't create instance: bad value for NetworkInterface.Subnetwork: %q", n.Subnetwork))
+			} else if region := getRegionFromZone(c.Zone); result["region"] != region {
+				errs.add(Errorf("cannot create instance in region %q with Subnetwork in region %q: %q", region, result["region"], n.Subnetwork))
+			} else if _, err := subnetworks[s.w].registerUsage(n.Subnetwork, s); err != nil {
+				errs.add(Errorf("cannot create instance: can't use NetworkInterface.Subnetwork %q: %v", n.Subnetwork, err))
 			}
 		}
+		errs.add(c.validateAddress(n.NetworkIP, s)...)
+		for _, ac := range n.AccessConfigs {
+			errs.add(c.validateAddress(ac.NatIP, s)...)
+		}
+	}
+	return
+}
+
+// validateAddress checks a NetworkIP or NatIP, which is either an IP or a
+// static address in the instance's region.
+func (c *CreateInstance) validateAddress(ip string, s *Step) (errs Errors) {
+	if ip == "" || net.ParseIP(ip) != nil {
+		return
+	}
+	ar, err := addresses[s.w].registerUsage(ip, s)
+	if err != nil {
+		errs.add(Errorf("cannot create instance: can't use address %q: %v", ip, err))
+		return
+	}
+	if result, region := namedSubexp(addressURLRegex, ar.link), getRegionFromZone(c.Zone); result["region"] != region {
+		errs.add(Errorf("cannot create instance in region %q with address in region %q: %q", region, result["region"], ip))
+	}
+	return
+}
+
+// resolveAddresses replaces the static addresses referenced by the
+// instance's NetworkIPs and NatIPs with their IPs.
+func (c *CreateInstance) resolveAddresses(w *Workflow) error {
+	resolve := func(ip *string) error {
+		ar, ok := addresses[w].get(*ip)
+		if !ok {
+			return nil
+		}
+		m := namedSubexp(addressURLRegex, ar.link)
+		a, err := w.ComputeClient.GetAddress(m["project"], m["region"], m["address"])
+		if err != nil {
+			return err
+		}
+		*ip = a.Address
+		return nil
+	}
+	for _, n := range c.NetworkInterfaces {
+		if err := resolve(&n.NetworkIP); err != nil {
+			return err
+		}
+		for _, ac := range n.AccessConfigs {
+			if err := resolve(&ac.NatIP); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *CreateInstance) validateResourcePolicies(s *Step) (errs Errors) {
+	for _, rp := range c.ResourcePolicies {
+		r, err := resourcePolicies[s.w].registerUsage(rp, s)
+		if err != nil {
+			errs.add(Errorf("cannot create instance: can't use resource policy %q: %v", rp, err))
+			continue
+		}
+		if result, region := namedSubexp(resourcePolicyURLRegex, r.link), getRegionFromZone(c.Zone); result["region"] != region {
+			errs.add(Errorf("cannot create instance in region %q with resource policy in region %q: %q", region, result["region"], rp))
+		}
+	}
+	return
+}
+
+func (c *CreateInstance) validateNetwork(n *compute.NetworkInterface, s *Step) (errs Errors) {
+	// Names of networks other than the workflow's are extended on populate.
+	if _, ok := networks[s.w].get(n.Network); !ok && !networkURLRegex.MatchString(n.Network) {
+		errs.add(Errorf("can't create instance: bad value for NetworkInterface.Network: %q", n.Network))
+		return
+	}
+	if _, err := networks[s.w].registerUsage(n.Network, s); err != nil {
+		errs.add(Errorf("cannot create instance: can't use NetworkInterface.Network %q: %v", n.Network, err))
 	}
 	return
 }
@@ -344,16 +570,23 @@ func (c *CreateInstances) validate(ctx context.Context, s *Step) error {
 		if !checkName(ci.Name) {
 			errs.add(Errorf("cannot create instance %q: bad name", ci.Name))
 		}
-		if err := checkProject(s.w.ComputeClient, ci.Project); err != nil {
+		if err := s.w.validateProject(ci.Project); err != nil {
 			return fmt.Errorf("cannot create disk: bad project: %q, error: %v", ci.Project, err)
 		}
-		if err := checkZone(s.w.ComputeClient, ci.Project, ci.Zone); err != nil {
+		if err := s.w.validateZone(ci.Project, ci.Zone); err != nil {
 			return fmt.Errorf("cannot create instance: bad zone: %q, error: %v", ci.Zone, err)
 		}
+		if ci.OSLogin != nil && *ci.OSLogin {
+			if err := s.w.validateOSLogin(ci.Project); err != nil {
+				errs.add(Errorf("cannot create instance %q with OS Login in project %q: %v", ci.Name, ci.Project, err))
+			}
+		}
 
 		errs.add(ci.validateDisks(ctx, s)...)
-		errs.add(ci.validateMachineType(s.w.ComputeClient)...)
-		errs.add(ci.validateNetworks()...)
+		errs.add(ci.validateMachineType(s.w)...)
+		errs.add(ci.validateNetworks(s)...)
+		errs.add(ci.validateResourcePolicies(s)...)
+		errs.add(ci.validateReservationAffinity()...)
 
 		// Register creation.
 		link := fmt.Sprintf("projects/%s/zones/%s/instances/%s", ci.Project, ci.Zone, ci.Name)
@@ -370,23 +603,65 @@ func (c *CreateInstances) run(ctx context.Context, s *Step) error {
 	var wg sync.WaitGroup
 	w := s.w
 	eChan := make(chan error)
+	var sem chan struct{}
+	if s.MaxParallelInstances > 0 {
+		sem = make(chan struct{}, s.MaxParallelInstances)
+	}
 	for _, ci := range *c {
 		wg.Add(1)
 		go func(ci *CreateInstance) {
 			defer wg.Done()
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-w.cancelChan():
+					return
+				}
+			}
 
+			var initDisks []string
 			for _, d := range ci.Disks {
 				if diskRes, ok := disks[w].get(d.Source); ok {
 					d.Source = diskRes.link
 				}
+				if d.InitializeParams != nil {
+					initDisks = append(initDisks, d.InitializeParams.DiskName)
+				}
+			}
+			for _, n := range ci.NetworkInterfaces {
+				if networkRes, ok := networks[w].get(n.Network); ok {
+					n.Network = networkRes.link
+				}
+			}
+			for i, rp := range ci.ResourcePolicies {
+				if rpRes, ok := resourcePolicies[w].get(rp); ok {
+					ci.ResourcePolicies[i] = rpRes.link
+				}
+			}
+			if err := ci.resolveAddresses(w); err != nil {
+				eChan <- err
+				return
 			}
+			ci.followDisks(s)
 
-			w.logger.Printf("CreateInstances: creating instance %q.", ci.Name)
-			if err := w.ComputeClient.CreateInstance(ci.Project, ci.Zone, &ci.Instance); err != nil {
+			move := func(from, to string) bool { return ci.moveZone(w, from, to) }
+			create := func(zone string) error {
+				if err := w.checkPolicy(&PlannedResource{Type: "instance", Name: ci.Name, Project: ci.Project, Zone: zone, Resource: &ci.Instance}); err != nil {
+					return err
+				}
+				w.logger.StepInfo(s, "creating instance %q.", ci.Name)
+				return w.ComputeClient.CreateInstance(ci.Project, zone, &ci.Instance)
+			}
+			if err := w.createInZones(s, ci.Name, ci.Zone, move, create); err != nil {
 				eChan <- err
 				return
 			}
-			go logSerialOutput(ctx, w, ci.Name, 1, 3*time.Second)
+			instances[w].markCreated(ci.daisyName)
+			for _, d := range initDisks {
+				disks[w].markCreated(d)
+			}
+			go logSerialOutput(ctx, s, ci.Project, ci.Zone, ci.Name, 1, 3*time.Second, w.newSerialLogger(s.name, ci.Project, ci.Zone, ci.Name, ci.Id, 1))
 		}(ci)
 	}
 
@@ -398,7 +673,7 @@ func (c *CreateInstances) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-eChan:
 		return err
-	case <-w.Cancel:
+	case <-w.cancelChan():
 		// Wait so instances being created now can be deleted.
 		wg.Wait()
 		return nil
@@ -24,6 +24,7 @@ import (
 	"path"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -57,7 +58,7 @@ func TestLogSerialOutput(t *testing.T) {
 	w.bucket = "test-bucket"
 
 	var buf bytes.Buffer
-	w.logger = log.New(&buf, "", 0)
+	w.logger = newLogger(w, &textLogger{log.New(&buf, "", 0)})
 
 	tests := []struct {
 		test, want, name string
@@ -84,9 +85,10 @@ func TestLogSerialOutput(t *testing.T) {
 		},
 	}
 
+	s := &Step{name: "s", w: w, CreateInstances: &CreateInstances{}}
 	for _, tt := range tests {
 		buf.Reset()
-		logSerialOutput(ctx, w, tt.name, 0, 1*time.Microsecond)
+		logSerialOutput(ctx, s, w.Project, w.Zone, tt.name, 0, 1*time.Microsecond, nil)
 		if buf.String() != tt.want {
 			t.Errorf("%s: got: %q, want: %q", tt.test, buf.String(), tt.want)
 		}
@@ -117,7 +119,7 @@ func TestCreateInstancePopulate(t *testing.T) {
 		{
 			"defaults, non exact name case",
 			&CreateInstance{Instance: compute.Instance{Name: "foo", Description: desc, Disks: []*compute.AttachedDisk{{Source: "foo"}}}},
-			&CreateInstance{Instance: compute.Instance{Name: w.genName("foo"), Description: desc, Disks: defDs, MachineType: defMT, NetworkInterfaces: defNs, ServiceAccounts: defSAs}, Metadata: defMD, Scopes: defSs, Project: defP, Zone: defZ, daisyName: "foo"},
+			&CreateInstance{Instance: compute.Instance{Name: w.genName("foo"), Description: desc, Disks: defDs, MachineType: defMT, NetworkInterfaces: defNs, ServiceAccounts: defSAs, Labels: testLabels}, Metadata: defMD, Scopes: defSs, Project: defP, Zone: defZ, daisyName: "foo"},
 			false,
 		},
 		{
@@ -133,6 +135,7 @@ func TestCreateInstancePopulate(t *testing.T) {
 					MachineType:       "projects/pfoo/zones/zfoo/machineTypes/n1-standard-1",
 					NetworkInterfaces: []*compute.NetworkInterface{{Network: "projects/pfoo/global/networks/default", AccessConfigs: defAcs}},
 					ServiceAccounts:   defSAs,
+					Labels:            testLabels,
 				},
 				Metadata: defMD, Scopes: defSs, Project: "pfoo", Zone: "zfoo", daisyName: "foo", ExactName: true,
 			},
@@ -186,27 +189,27 @@ func TestCreateInstancePopulateDisks(t *testing.T) {
 		{
 			"init params daisy image (and other defaults)",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i"}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params image short url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "global/images/i"}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params image extended url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: fmt.Sprintf("projects/%s/global/images/i", testProject), DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params disk type short url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i", DiskType: fmt.Sprintf("zones/%s/diskTypes/dt", testZone)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone), Labels: testLabels}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params disk type extended url",
 			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}}},
-			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone)}, Mode: defaultDiskMode, Boot: true}},
+			[]*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: fmt.Sprintf("projects/%s/zones/%s/diskTypes/dt", testProject, testZone), Labels: testLabels}, Mode: defaultDiskMode, Boot: true}},
 		},
 		{
 			"init params name suffixes",
@@ -217,10 +220,10 @@ func TestCreateInstancePopulateDisks(t *testing.T) {
 				{InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "i"}},
 			},
 			[]*compute.AttachedDisk{
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, Boot: true},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, SourceImage: "i", DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode, Boot: true},
 				{Source: "d", Mode: defaultDiskMode},
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "foo", SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
-				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: fmt.Sprintf("%s-2", iName), SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "foo", SourceImage: "i", DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode},
+				{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: fmt.Sprintf("%s-2", iName), SourceImage: "i", DiskType: defDT, Labels: testLabels}, Mode: defaultDiskMode},
 			},
 		},
 	}
@@ -258,6 +261,49 @@ func TestCreateInstancePopulateMachineType(t *testing.T) {
 	}
 }
 
+func TestCreateInstancePopulateOSLogin(t *testing.T) {
+	ctx := context.Background()
+	tru := true
+	fls := false
+
+	tests := []struct {
+		desc       string
+		wfOSLogin  bool
+		osLogin    *bool
+		want       *bool
+		wantMdItem string
+	}{
+		{"unset case", false, nil, nil, ""},
+		{"inherit workflow case", true, nil, &tru, "TRUE"},
+		{"instance enabled case", false, &tru, &tru, "TRUE"},
+		{"instance disabled case", true, &fls, &fls, "FALSE"},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.OSLogin = tt.wfOSLogin
+		s, _ := w.NewStep(tt.desc)
+		ci := &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Source: "foo"}}}, OSLogin: tt.osLogin}
+		s.CreateInstances = &CreateInstances{ci}
+		if err := s.CreateInstances.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diff := pretty.Compare(ci.OSLogin, tt.want); diff != "" {
+			t.Errorf("%s: OSLogin not populated as expected: (-got +want)\n%s", tt.desc, diff)
+		}
+		var got string
+		for _, item := range ci.Instance.Metadata.Items {
+			if item.Key == "enable-oslogin" {
+				got = *item.Value
+			}
+		}
+		if got != tt.wantMdItem {
+			t.Errorf("%s: enable-oslogin metadata = %q, want %q", tt.desc, got, tt.wantMdItem)
+		}
+	}
+}
+
 func TestCreateInstancePopulateMetadata(t *testing.T) {
 	w := testWorkflow()
 	w.populate(context.Background())
@@ -280,21 +326,26 @@ func TestCreateInstancePopulateMetadata(t *testing.T) {
 		}
 		return result
 	}
+	tru := true
+	fls := false
 
 	tests := []struct {
 		desc          string
 		md            map[string]string
 		startupScript string
+		osLogin       *bool
 		wantMd        *compute.Metadata
 		shouldErr     bool
 	}{
-		{"defaults case", nil, "", getWantMd(map[string]string{}), false},
-		{"startup script case", nil, "file", getWantMd(map[string]string{"startup-script-url": filePath, "windows-startup-script-url": filePath}), false},
-		{"bad startup script case", nil, "foo", nil, true},
+		{"defaults case", nil, "", nil, getWantMd(map[string]string{}), false},
+		{"startup script case", nil, "file", nil, getWantMd(map[string]string{"startup-script-url": filePath, "windows-startup-script-url": filePath}), false},
+		{"OS Login case", nil, "", &tru, getWantMd(map[string]string{"enable-oslogin": "TRUE"}), false},
+		{"OS Login disabled case", nil, "", &fls, getWantMd(map[string]string{"enable-oslogin": "FALSE"}), false},
+		{"bad startup script case", nil, "foo", nil, nil, true},
 	}
 
 	for _, tt := range tests {
-		ci := CreateInstance{Metadata: tt.md, StartupScript: tt.startupScript}
+		ci := CreateInstance{Metadata: tt.md, StartupScript: tt.startupScript, OSLogin: tt.osLogin}
 		err := ci.populateMetadata(w)
 		if err == nil {
 			if tt.shouldErr {
@@ -315,7 +366,44 @@ func TestCreateInstancePopulateMetadata(t *testing.T) {
 	}
 }
 
+func TestCreateInstancePopulateCommonMetadata(t *testing.T) {
+	w := testWorkflow()
+	w.populate(context.Background())
+	w.CommonInstanceMetadata = map[string]string{"proxy": "parent", "build-id": "parent", "items-key": "parent", "daisy-logs-path": "parent"}
+	sw := w.NewSubWorkflow()
+	sw.parent = w
+	sw.CommonInstanceMetadata = map[string]string{"build-id": "sub"}
+
+	v := "instance"
+	ci := CreateInstance{Metadata: map[string]string{"proxy": "instance"}}
+	ci.Instance.Metadata = &compute.Metadata{Items: []*compute.MetadataItems{{Key: "items-key", Value: &v}}}
+	if err := ci.populateMetadata(sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, item := range ci.Instance.Metadata.Items {
+		if _, ok := got[item.Key]; ok {
+			t.Errorf("duplicate metadata key %q", item.Key)
+		}
+		got[item.Key] = *item.Value
+	}
+	want := map[string]string{
+		"proxy":              "instance",
+		"build-id":           "sub",
+		"items-key":          "instance",
+		"daisy-sources-path": "gs://" + path.Join(sw.bucket, sw.sourcesPath),
+		"daisy-logs-path":    "gs://" + path.Join(sw.bucket, sw.logsPath),
+		"daisy-outs-path":    "gs://" + path.Join(sw.bucket, sw.outsPath),
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("Metadata not modified as expected: (-got +want)\n%s", diff)
+	}
+}
+
 func TestCreateInstancePopulateNetworks(t *testing.T) {
+	w := testWorkflow()
+	w.Steps["cn"] = &Step{CreateNetworks: &CreateNetworks{{Network: compute.Network{Name: "created"}}}}
 	defaultAcs := []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
 	tests := []struct {
 		desc        string
@@ -323,12 +411,28 @@ func TestCreateInstancePopulateNetworks(t *testing.T) {
 	}{
 		{"default case", nil, []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/default", testProject), AccessConfigs: defaultAcs}}},
 		{"default AccessConfig case", []*compute.NetworkInterface{{Network: "global/networks/foo"}}, []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/foo", testProject), AccessConfigs: defaultAcs}}},
-		{"network URL resolution case", []*compute.NetworkInterface{{Network: "foo", AccessConfigs: []*compute.AccessConfig{}}}, []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/foo", testProject), AccessConfigs: []*compute.AccessConfig{}}}},
+		{"network name case", []*compute.NetworkInterface{{Network: "foo", AccessConfigs: []*compute.AccessConfig{}}}, []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/foo", testProject), AccessConfigs: []*compute.AccessConfig{}}}},
+		{"created network case", []*compute.NetworkInterface{{Network: "created"}}, []*compute.NetworkInterface{{Network: "created", AccessConfigs: defaultAcs}}},
+		{"subnetwork name case", []*compute.NetworkInterface{{Subnetwork: "foo"}}, []*compute.NetworkInterface{{Subnetwork: fmt.Sprintf("projects/%s/regions/test-region/subnetworks/foo", testProject), AccessConfigs: defaultAcs}}},
+		{"subnetwork URL case", []*compute.NetworkInterface{{Subnetwork: "regions/r/subnetworks/foo"}}, []*compute.NetworkInterface{{Subnetwork: fmt.Sprintf("projects/%s/regions/r/subnetworks/foo", testProject), AccessConfigs: defaultAcs}}},
+		{
+			"address case",
+			[]*compute.NetworkInterface{{Network: "created", NetworkIP: "regions/r/addresses/foo", AccessConfigs: []*compute.AccessConfig{{NatIP: "bar"}, {NatIP: "1.2.3.4"}}}},
+			[]*compute.NetworkInterface{{Network: "created", NetworkIP: fmt.Sprintf("projects/%s/regions/r/addresses/foo", testProject), AccessConfigs: []*compute.AccessConfig{{NatIP: "bar"}, {NatIP: "1.2.3.4"}}}},
+		},
+		{
+			"multiple NICs case",
+			[]*compute.NetworkInterface{{Network: "created"}, {Network: "projects/host/global/networks/bar", Subnetwork: "projects/host/regions/test-region/subnetworks/bar"}},
+			[]*compute.NetworkInterface{
+				{Network: "created", AccessConfigs: defaultAcs},
+				{Network: "projects/host/global/networks/bar", Subnetwork: "projects/host/regions/test-region/subnetworks/bar", AccessConfigs: defaultAcs},
+			},
+		},
 	}
 
 	for _, tt := range tests {
-		ci := &CreateInstance{Instance: compute.Instance{NetworkInterfaces: tt.input}, Project: testProject}
-		err := ci.populateNetworks()
+		ci := &CreateInstance{Instance: compute.Instance{NetworkInterfaces: tt.input}, Project: testProject, Zone: "test-region-a"}
+		err := ci.populateNetworks(w)
 		if err != nil {
 			t.Errorf("%s: should have returned an error", tt.desc)
 		} else if diff := pretty.Compare(ci.NetworkInterfaces, tt.want); diff != "" {
@@ -365,6 +469,26 @@ func TestCreateInstancePopulateScopes(t *testing.T) {
 	}
 }
 
+func TestCreateInstancePopulateReservationAffinity(t *testing.T) {
+	tests := []struct {
+		desc        string
+		input, want *compute.ReservationAffinity
+	}{
+		{"none case", nil, nil},
+		{"any case", &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION"}, &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION"}},
+		{"specific case", &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Values: []string{"r"}}, &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: "compute.googleapis.com/reservation-name", Values: []string{"r"}}},
+		{"specific key case", &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: "k", Values: []string{"v"}}, &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: "k", Values: []string{"v"}}},
+	}
+
+	for _, tt := range tests {
+		ci := &CreateInstance{Instance: compute.Instance{ReservationAffinity: tt.input}}
+		ci.populateReservationAffinity()
+		if diff := pretty.Compare(ci.ReservationAffinity, tt.want); diff != "" {
+			t.Errorf("%s: ReservationAffinity not modified as expected: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
 func TestCreateInstancesRun(t *testing.T) {
 	ctx := context.Background()
 	var createErr error
@@ -393,6 +517,35 @@ func TestCreateInstancesRun(t *testing.T) {
 		t.Errorf("instance disk link did not resolve properly: want: %q, got: %q", "other", i1.Disks[0].Source)
 	}
 
+	// Good case: check static address references get resolved to IPs.
+	addresses[w].m = map[string]*resource{"a": {real: "a-real", link: "projects/p/regions/r/addresses/a-real"}}
+	w.ComputeClient.(*daisyCompute.TestClient).GetAddressFn = func(p, r, n string) (*compute.Address, error) {
+		if p != "p" || r != "r" || n != "a-real" {
+			return nil, fmt.Errorf("unexpected address %s/%s/%s", p, r, n)
+		}
+		return &compute.Address{Name: n, Address: "1.2.3.4"}, nil
+	}
+	i2 := &CreateInstance{daisyName: "i2", Instance: compute.Instance{Name: "realI2", MachineType: "foo-type", NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "10.0.0.1", AccessConfigs: []*compute.AccessConfig{{NatIP: "a"}}}}}}
+	if err := (&CreateInstances{i2}).run(ctx, s); err != nil {
+		t.Errorf("unexpected error running CreateInstances.run(): %v", err)
+	}
+	if got := i2.NetworkInterfaces[0].AccessConfigs[0].NatIP; got != "1.2.3.4" {
+		t.Errorf("instance NatIP did not resolve properly: want: %q, got: %q", "1.2.3.4", got)
+	}
+	if got := i2.NetworkInterfaces[0].NetworkIP; got != "10.0.0.1" {
+		t.Errorf("instance NetworkIP should not have changed: want: %q, got: %q", "10.0.0.1", got)
+	}
+
+	// Good case: check resource policy references get resolved to links.
+	resourcePolicies[w].m = map[string]*resource{"rp": {real: "rp-real", link: "projects/p/regions/r/resourcePolicies/rp-real"}}
+	i3 := &CreateInstance{daisyName: "i3", Instance: compute.Instance{Name: "realI3", MachineType: "foo-type", ResourcePolicies: []string{"rp", "projects/p/regions/r/resourcePolicies/other"}}}
+	if err := (&CreateInstances{i3}).run(ctx, s); err != nil {
+		t.Errorf("unexpected error running CreateInstances.run(): %v", err)
+	}
+	if want := []string{"projects/p/regions/r/resourcePolicies/rp-real", "projects/p/regions/r/resourcePolicies/other"}; !reflect.DeepEqual(i3.ResourcePolicies, want) {
+		t.Errorf("instance resource policies did not resolve properly: want: %q, got: %q", want, i3.ResourcePolicies)
+	}
+
 	// Bad case: compute client CreateInstance error. Check instance ref map doesn't update.
 	instances[w].m = map[string]*resource{}
 	createErr = errors.New("client error")
@@ -404,6 +557,37 @@ func TestCreateInstancesRun(t *testing.T) {
 	}
 }
 
+func TestCreateInstancesRunMaxParallel(t *testing.T) {
+	w := testWorkflow()
+	var mx sync.Mutex
+	var running, maxRunning int
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, _ *compute.Instance) error {
+		mx.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mx.Lock()
+		running--
+		mx.Unlock()
+		return nil
+	}
+	s := &Step{w: w, MaxParallelInstances: 2}
+
+	var ci CreateInstances
+	for i := 0; i < 5; i++ {
+		ci = append(ci, &CreateInstance{daisyName: fmt.Sprintf("i%d", i), Instance: compute.Instance{Name: fmt.Sprintf("realI%d", i), MachineType: "foo-type"}})
+	}
+	if err := ci.run(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error running CreateInstances.run(): %v", err)
+	}
+	if maxRunning != 2 {
+		t.Errorf("unexpected maximum of instances created at once, got: %d, want: 2", maxRunning)
+	}
+}
+
 func TestCreateInstanceValidateDisks(t *testing.T) {
 	// Test:
 	// - good case
@@ -413,6 +597,7 @@ func TestCreateInstanceValidateDisks(t *testing.T) {
 	w := testWorkflow()
 	disks[w].m = map[string]*resource{"d": {link: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)}}
 	m := defaultDiskMode
+	dt := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
 
 	tests := []struct {
 		desc      string
@@ -423,6 +608,9 @@ func TestCreateInstanceValidateDisks(t *testing.T) {
 		{"good case 2", &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone), Mode: m}}}, Project: testProject, Zone: testZone}, false},
 		{"bad no disks case", &CreateInstance{Instance: compute.Instance{Name: "foo"}}, true},
 		{"bad disk mode case", &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Source: "d", Mode: "bad mode!"}}}, Project: testProject, Zone: testZone}, true},
+		{"read-only disk case", &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Source: "d", Mode: diskModeRO}}}, Project: testProject, Zone: testZone}, false},
+		{"bad read-only boot disk case", &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Boot: true, Source: "d", Mode: diskModeRO}}}, Project: testProject, Zone: testZone}, true},
+		{"bad KMS key case", &CreateInstance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "kms", SourceImage: "projects/p/global/images/i", DiskType: dt}, DiskEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: "bad"}, Mode: m}}}, Project: testProject, Zone: testZone}, true},
 	}
 
 	for _, tt := range tests {
@@ -469,6 +657,17 @@ func TestCreateInstanceValidateDiskSource(t *testing.T) {
 			t.Errorf("%s: unexpected error: %v", tt.desc, err)
 		}
 	}
+
+	// Disks attached to a NoCleanup instance are kept.
+	s, _ := w.NewStep("no cleanup case")
+	ci := &CreateInstance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: "d", Mode: m}}}, Project: p, Zone: z, NoCleanup: true}
+	s.CreateInstances = &CreateInstances{ci}
+	if err := ci.validateDiskSource(ci.Disks[0], s); err != nil {
+		t.Errorf("no cleanup case: unexpected error: %v", err)
+	}
+	if !disks[w].m["d"].noCleanup {
+		t.Error("disk attached to NoCleanup instance should not be cleaned up")
+	}
 }
 
 func TestCreateInstanceValidateDiskInitializeParams(t *testing.T) {
@@ -517,6 +716,17 @@ func TestCreateInstanceValidateDiskInitializeParams(t *testing.T) {
 		t.Errorf("foo resource not added as expected: got: %+v, want: %+v", gotFoo, wantFoo)
 	}
 
+	// Disks of NoCleanup instances are kept.
+	s, _ := w.NewStep("no cleanup case")
+	ci := &CreateInstance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "baz", SourceImage: "i", DiskType: dt}}}}, Project: testProject, Zone: testZone, NoCleanup: true}
+	s.CreateInstances = &CreateInstances{ci}
+	if err := ci.validateDiskInitializeParams(ci.Disks[0], s); err != nil {
+		t.Errorf("no cleanup case: unexpected error: %v", err)
+	}
+	if r, ok := disks[w].m["baz"]; !ok || !r.noCleanup {
+		t.Error("disk of NoCleanup instance should not be cleaned up")
+	}
+
 	// Check proper image user registrations.
 	wantU := w.Steps["good case"]
 	found := false
@@ -535,6 +745,8 @@ func TestCreateInstanceValidateMachineType(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error creating test client: %v", err)
 	}
+	w := testWorkflow()
+	w.ComputeClient = c
 
 	tests := []struct {
 		desc      string
@@ -550,7 +762,51 @@ func TestCreateInstanceValidateMachineType(t *testing.T) {
 
 	for _, tt := range tests {
 		ci := &CreateInstance{Instance: compute.Instance{MachineType: tt.mt}, Project: testProject, Zone: testZone}
-		if err := ci.validateMachineType(c); tt.shouldErr && err == nil {
+		if err := ci.validateMachineType(w); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestCreateInstanceValidateMinCPUPlatform(t *testing.T) {
+	c, err := newTestGCEClient()
+	if err != nil {
+		t.Fatalf("error creating test client: %v", err)
+	}
+	c.GetMachineTypeFn = func(_, _, mt string) (*compute.MachineType, error) {
+		switch mt {
+		case testMachineType:
+			return &compute.MachineType{}, nil
+		case "shared-mt":
+			return &compute.MachineType{IsSharedCpu: true}, nil
+		}
+		return nil, errors.New("bad machinetype")
+	}
+	c.GetZoneFn = func(_, _ string) (*compute.Zone, error) {
+		return &compute.Zone{AvailableCpuPlatforms: []string{"Intel Broadwell", "Intel Skylake"}}, nil
+	}
+	w := testWorkflow()
+	w.ComputeClient = c
+
+	mt := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType)
+	tests := []struct {
+		desc      string
+		mt        string
+		platform  string
+		shouldErr bool
+	}{
+		{"no platform case", mt, "", false},
+		{"good case", mt, "Intel Skylake", false},
+		{"automatic case", mt, "Automatic", false},
+		{"unavailable platform case", mt, "Intel Sandy Bridge", true},
+		{"shared-core case", fmt.Sprintf("projects/%s/zones/%s/machineTypes/shared-mt", testProject, testZone), "Intel Skylake", true},
+	}
+
+	for _, tt := range tests {
+		ci := &CreateInstance{Instance: compute.Instance{MachineType: tt.mt, MinCpuPlatform: tt.platform}, Project: testProject, Zone: testZone}
+		if err := ci.validateMachineType(w); tt.shouldErr && err == nil {
 			t.Errorf("%s: should have returned an error", tt.desc)
 		} else if !tt.shouldErr && err != nil {
 			t.Errorf("%s: unexpected error: %v", tt.desc, err)
@@ -559,22 +815,115 @@ func TestCreateInstanceValidateMachineType(t *testing.T) {
 }
 
 func TestCreateInstanceValidateNetworks(t *testing.T) {
+	w := testWorkflow()
 	acs := []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
+	nCreator := &Step{name: "nCreator", w: w}
+	w.Steps["nCreator"] = nCreator
+	networks[w].registerCreation("created", &resource{link: "projects/p/global/networks/created-real"}, nCreator)
+	addresses[w].registerCreation("address", &resource{link: "projects/p/regions/z/addresses/address-real"}, nCreator)
+
+	tests := []struct {
+		desc        string
+		nis         []*compute.NetworkInterface
+		wantNetwork string
+		shouldErr   bool
+	}{
+		{"good case", []*compute.NetworkInterface{{Network: "projects/p/global/networks/n", AccessConfigs: acs}}, "projects/p/global/networks/n", false},
+		{"network name case", []*compute.NetworkInterface{{Network: "n", AccessConfigs: acs}}, "", true},
+		{"created network case", []*compute.NetworkInterface{{Network: "created", AccessConfigs: acs}}, "created", false},
+		{"shared VPC case", []*compute.NetworkInterface{{Network: "projects/host/global/networks/n", Subnetwork: "projects/host/regions/z/subnetworks/s", AccessConfigs: acs}}, "projects/host/global/networks/n", false},
+		{"subnetwork only case", []*compute.NetworkInterface{{Subnetwork: "projects/p/regions/z/subnetworks/s", AccessConfigs: acs}}, "", false},
+		{"bad name case", []*compute.NetworkInterface{{Network: "projects/p/global/networks/bad!", AccessConfigs: acs}}, "", true},
+		{"bad subnetwork case", []*compute.NetworkInterface{{Subnetwork: "bad!", AccessConfigs: acs}}, "", true},
+		{"bad subnetwork region case", []*compute.NetworkInterface{{Subnetwork: "projects/p/regions/bad-region/subnetworks/s", AccessConfigs: acs}}, "", true},
+		{"IP case", []*compute.NetworkInterface{{Network: "created", NetworkIP: "10.0.0.1", AccessConfigs: []*compute.AccessConfig{{NatIP: "1.2.3.4"}}}}, "created", false},
+		{"created address case", []*compute.NetworkInterface{{Network: "created", AccessConfigs: []*compute.AccessConfig{{NatIP: "address"}}}}, "created", false},
+		{"address URL case", []*compute.NetworkInterface{{Network: "created", NetworkIP: "projects/p/regions/z/addresses/a", AccessConfigs: acs}}, "created", false},
+		{"bad address region case", []*compute.NetworkInterface{{Network: "created", AccessConfigs: []*compute.AccessConfig{{NatIP: "projects/p/regions/bad-region/addresses/a"}}}}, "", true},
+		{"address dne case", []*compute.NetworkInterface{{Network: "created", AccessConfigs: []*compute.AccessConfig{{NatIP: "dne"}}}}, "", true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(tt.desc, "nCreator")
+		ci := &CreateInstance{Instance: compute.Instance{NetworkInterfaces: tt.nis}, Project: "p", Zone: "z-a"}
+		if err := ci.validateNetworks(s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if !tt.shouldErr && ci.NetworkInterfaces[0].Network != tt.wantNetwork {
+			t.Errorf("%s: unexpected Network, got: %q, want: %q", tt.desc, ci.NetworkInterfaces[0].Network, tt.wantNetwork)
+		}
+	}
+
+	// Using a created network requires depending on its creator.
+	s, _ := w.NewStep("no-dependency")
+	ci := &CreateInstance{Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Network: "created"}}}, Project: "p", Zone: "z-a"}
+	if err := ci.validateNetworks(s); err == nil {
+		t.Error("no dependency case: should have returned an error")
+	}
+}
+
+func TestCreateInstanceValidateResourcePolicies(t *testing.T) {
+	w := testWorkflow()
+	rpCreator := &Step{name: "rpCreator", w: w}
+	w.Steps["rpCreator"] = rpCreator
+	resourcePolicies[w].registerCreation("created", &resource{link: "projects/p/regions/z/resourcePolicies/created-real"}, rpCreator)
 
 	tests := []struct {
 		desc      string
-		nis       []*compute.NetworkInterface
+		rps       []string
 		shouldErr bool
 	}{
-		{"good case", []*compute.NetworkInterface{{Network: "projects/p/global/networks/n", AccessConfigs: acs}}, false},
-		{"good case 2", []*compute.NetworkInterface{{Network: "projects/p/global/networks/n", AccessConfigs: acs}}, false},
-		{"bad name case", []*compute.NetworkInterface{{Network: "projects/p/global/networks/bad!", AccessConfigs: acs}}, true},
-		{"bad project case", []*compute.NetworkInterface{{Network: "projects/bad-project/global/networks/n", AccessConfigs: acs}}, true},
+		{"none case", nil, false},
+		{"created policy case", []string{"created"}, false},
+		{"policy URL case", []string{"projects/p/regions/z/resourcePolicies/rp"}, false},
+		{"bad region case", []string{"projects/p/regions/bad-region/resourcePolicies/rp"}, true},
+		{"policy dne case", []string{"dne"}, true},
 	}
 
 	for _, tt := range tests {
-		ci := &CreateInstance{Instance: compute.Instance{NetworkInterfaces: tt.nis}, Project: "p"}
-		if err := ci.validateNetworks(); tt.shouldErr && err == nil {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(tt.desc, "rpCreator")
+		ci := &CreateInstance{Instance: compute.Instance{ResourcePolicies: tt.rps}, Project: "p", Zone: "z-a"}
+		if err := ci.validateResourcePolicies(s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	// Using a created policy requires depending on its creator.
+	s, _ := w.NewStep("no-dependency")
+	ci := &CreateInstance{Instance: compute.Instance{ResourcePolicies: []string{"created"}}, Project: "p", Zone: "z-a"}
+	if err := ci.validateResourcePolicies(s); err == nil {
+		t.Error("no dependency case: should have returned an error")
+	}
+}
+
+func TestCreateInstanceValidateReservationAffinity(t *testing.T) {
+	specific := func(key string, values ...string) *compute.ReservationAffinity {
+		return &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: key, Values: values}
+	}
+	tests := []struct {
+		desc      string
+		ra        *compute.ReservationAffinity
+		shouldErr bool
+	}{
+		{"none case", nil, false},
+		{"any case", &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION"}, false},
+		{"no reservation case", &compute.ReservationAffinity{ConsumeReservationType: "NO_RESERVATION"}, false},
+		{"specific case", specific("compute.googleapis.com/reservation-name", "r1", "projects/other/reservations/r2"), false},
+		{"specific custom key case", specific("k", "Some Value"), false},
+		{"bad type case", &compute.ReservationAffinity{ConsumeReservationType: "bad"}, true},
+		{"values without specific case", &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION", Values: []string{"r"}}, true},
+		{"specific without values case", specific("compute.googleapis.com/reservation-name"), true},
+		{"bad reservation case", specific("compute.googleapis.com/reservation-name", "bad!"), true},
+	}
+
+	for _, tt := range tests {
+		ci := &CreateInstance{Instance: compute.Instance{Name: "foo", ReservationAffinity: tt.ra}}
+		if err := ci.validateReservationAffinity(); tt.shouldErr && err == nil {
 			t.Errorf("%s: should have returned an error", tt.desc)
 		} else if !tt.shouldErr && err != nil {
 			t.Errorf("%s: unexpected error: %v", tt.desc, err)
@@ -593,6 +942,8 @@ func TestCreateInstancesValidate(t *testing.T) {
 			fmt.Fprintln(w, `{}`)
 		} else if r.Method == "GET" && r.URL.String() == "/p?alt=json" {
 			fmt.Fprintln(w, `{}`)
+		} else if r.Method == "POST" && r.URL.String() == "/v1/projects/p:testIamPermissions?alt=json" {
+			fmt.Fprintln(w, `{}`)
 		} else {
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(w, "bad request: %+v", r)
@@ -610,6 +961,7 @@ func TestCreateInstancesValidate(t *testing.T) {
 	dCreator := &Step{name: "dCreator", w: w}
 	w.Steps["dCreator"] = dCreator
 	disks[w].registerCreation("d", &resource{link: fmt.Sprintf("projects/%s/zones/%s/disks/d", p, z)}, dCreator)
+	tru := true
 
 	tests := []struct {
 		desc      string
@@ -622,6 +974,7 @@ func TestCreateInstancesValidate(t *testing.T) {
 		{"bad project case", &CreateInstance{Instance: compute.Instance{Name: "bar", Disks: ad, MachineType: mt}, Project: "bad!", Zone: z}, true},
 		{"bad zone case", &CreateInstance{Instance: compute.Instance{Name: "baz", Disks: ad, MachineType: mt}, Project: p, Zone: "bad!"}, true},
 		{"machine type validation fails case", &CreateInstance{Instance: compute.Instance{Name: "gaz", Disks: ad, MachineType: "bad machine type!"}, Project: p, Zone: z, daisyName: "gaz"}, true},
+		{"OS Login permission missing case", &CreateInstance{Instance: compute.Instance{Name: "oslogin", Disks: ad, MachineType: mt}, Project: p, Zone: z, OSLogin: &tru, daisyName: "oslogin"}, true},
 	}
 
 	for _, tt := range tests {
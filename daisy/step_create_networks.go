@@ -0,0 +1,134 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// CreateNetworks is a Daisy CreateNetworks workflow step.
+type CreateNetworks []*CreateNetwork
+
+// CreateNetwork creates a GCE network. Networks can be referenced by name
+// in the NetworkInterfaces of CreateInstances steps.
+type CreateNetwork struct {
+	compute.Network
+
+	// AutoCreateSubnetworks creates the network in "auto" mode when true and
+	// in "custom" mode when false. Defaults to true.
+	AutoCreateSubnetworks *bool `json:"autoCreateSubnetworks,omitempty"`
+	// Project to create the network in, overrides workflow Project.
+	Project string `json:",omitempty"`
+	// Should this resource be cleaned up after the workflow?
+	NoCleanup bool
+	// Should we use the user-provided reference name as the actual
+	// resource name?
+	ExactName bool
+
+	// The name of the network as known internally to Daisy.
+	daisyName string
+}
+
+// MarshalJSON is a hacky workaround to prevent CreateNetwork from using
+// compute.Network's implementation.
+func (c *CreateNetwork) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*c)
+}
+
+// populate preprocesses fields: Name, Project, Description, AutoCreateSubnetworks, and daisyName.
+// - sets defaults
+func (c *CreateNetworks) populate(ctx context.Context, s *Step) error {
+	for _, cn := range *c {
+		cn.daisyName = cn.Name
+		if !cn.ExactName {
+			cn.Name = s.w.genName(cn.Name)
+		}
+		cn.Project = strOr(cn.Project, s.project())
+		cn.Description = strOr(cn.Description, fmt.Sprintf("Network created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+
+		if cn.AutoCreateSubnetworks == nil {
+			auto := true
+			cn.AutoCreateSubnetworks = &auto
+		}
+		cn.Network.AutoCreateSubnetworks = *cn.AutoCreateSubnetworks
+		if !cn.Network.AutoCreateSubnetworks {
+			cn.ForceSendFields = append(cn.ForceSendFields, "AutoCreateSubnetworks")
+		}
+	}
+	return nil
+}
+
+func (c *CreateNetworks) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, cn := range *c {
+		if !checkName(cn.Name) {
+			errs.add(Errorf("cannot create network %q: bad name", cn.Name))
+		}
+		if err := s.w.validateProject(cn.Project); err != nil {
+			errs.add(Errorf("cannot create network: bad project: %q, error: %v", cn.Project, err))
+		}
+
+		// Register creation.
+		link := fmt.Sprintf("projects/%s/global/networks/%s", cn.Project, cn.Name)
+		r := &resource{real: cn.Name, link: link, noCleanup: cn.NoCleanup}
+		if err := networks[s.w].registerCreation(cn.daisyName, r, s); err != nil {
+			errs.add(Errorf(err.Error()))
+		}
+	}
+
+	return errs.cast()
+}
+
+func (c *CreateNetworks) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, cn := range *c {
+		wg.Add(1)
+		go func(cn *CreateNetwork) {
+			defer wg.Done()
+
+			if err := w.checkPolicy(&PlannedResource{Type: "network", Name: cn.Name, Project: cn.Project, Resource: &cn.Network}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating network %q.", cn.Name)
+			if err := w.ComputeClient.CreateNetwork(cn.Project, &cn.Network); err != nil {
+				e <- err
+				return
+			}
+			networks[w].markCreated(cn.daisyName)
+		}(cn)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so networks being created now can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
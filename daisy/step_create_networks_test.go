@@ -0,0 +1,126 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCreateNetworksPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	genFoo := w.genName("foo")
+	tru := true
+	fls := false
+	tests := []struct {
+		desc        string
+		input, want *CreateNetwork
+	}{
+		{
+			"defaults case",
+			&CreateNetwork{Network: compute.Network{Name: "foo"}},
+			&CreateNetwork{Network: compute.Network{Name: genFoo, AutoCreateSubnetworks: true}, AutoCreateSubnetworks: &tru, daisyName: "foo", Project: w.Project},
+		},
+		{
+			"nondefaults case",
+			&CreateNetwork{Network: compute.Network{Name: "foo"}, AutoCreateSubnetworks: &fls, Project: "pfoo", ExactName: true},
+			&CreateNetwork{Network: compute.Network{Name: "foo", ForceSendFields: []string{"AutoCreateSubnetworks"}}, AutoCreateSubnetworks: &fls, daisyName: "foo", Project: "pfoo", ExactName: true},
+		},
+	}
+
+	for _, tt := range tests {
+		cns := &CreateNetworks{tt.input}
+		if err := cns.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// Short circuit the description field -- difficult to test, and unimportant.
+		tt.want.Description = tt.input.Description
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateNetwork does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateNetworksValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	tests := []struct {
+		desc      string
+		cn        *CreateNetwork
+		shouldErr bool
+	}{
+		{"normal case", &CreateNetwork{daisyName: "n1", Network: compute.Network{Name: "n1"}, Project: testProject}, false},
+		{"dupe case", &CreateNetwork{daisyName: "n1", Network: compute.Network{Name: "n1"}, Project: testProject}, true},
+		{"bad name case", &CreateNetwork{daisyName: "n2", Network: compute.Network{Name: "n!"}, Project: testProject}, true},
+		{"bad project case", &CreateNetwork{daisyName: "n3", Network: compute.Network{Name: "n3"}, Project: "p!"}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.CreateNetworks = &CreateNetworks{tt.cn}
+		if err := s.CreateNetworks.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	want := "projects/" + testProject + "/global/networks/n1"
+	if r, ok := networks[w].get("n1"); !ok || r.link != want {
+		t.Errorf("network n1 not registered as expected, got: %+v, want link: %q", r, want)
+	}
+}
+
+func TestCreateNetworksRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	e := errors.New("error")
+	tests := []struct {
+		desc      string
+		clientErr error
+		wantErr   error
+	}{
+		{"normal case", nil, nil},
+		{"client error case", e, e},
+	}
+	for _, tt := range tests {
+		networks[w].m = map[string]*resource{"n": {real: "n-real", link: "projects/p/global/networks/n-real"}}
+		var gotProject string
+		fake := func(p string, _ *compute.Network) error { gotProject = p; return tt.clientErr }
+		w.ComputeClient = &daisyCompute.TestClient{CreateNetworkFn: fake}
+		cns := &CreateNetworks{{Network: compute.Network{Name: "n-real"}, Project: "p", daisyName: "n"}}
+		if err := cns.run(ctx, s); err != tt.wantErr {
+			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
+		}
+		if gotProject != "p" {
+			t.Errorf("%s: network created in wrong project, got: %q, want: %q", tt.desc, gotProject, "p")
+		}
+		if r, _ := networks[w].get("n"); r.created != (tt.clientErr == nil) {
+			t.Errorf("%s: unexpected created state: %t", tt.desc, r.created)
+		}
+	}
+}
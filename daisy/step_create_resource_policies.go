@@ -0,0 +1,159 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+const collocated = "COLLOCATED"
+
+// CreateResourcePolicies is a Daisy CreateResourcePolicies workflow step.
+type CreateResourcePolicies []*CreateResourcePolicy
+
+// CreateResourcePolicy creates a GCE resource policy. Only group placement
+// policies are supported: a compact policy (GroupPlacementPolicy.Collocation
+// set to "COLLOCATED") places instances close together for low network
+// latency, a spread policy (GroupPlacementPolicy.AvailabilityDomainCount)
+// places them on distinct hardware. Policies are referenced by name in the
+// ResourcePolicies field of CreateInstances steps.
+type CreateResourcePolicy struct {
+	compute.ResourcePolicy
+
+	// Region to create the policy in, overrides the region of the
+	// workflow Zone.
+	Region string `json:",omitempty"`
+	// Project to create the policy in, overrides workflow Project.
+	Project string `json:",omitempty"`
+	// Should this resource be cleaned up after the workflow?
+	NoCleanup bool
+	// Should we use the user-provided reference name as the actual
+	// resource name?
+	ExactName bool
+
+	// The name of the resource policy as known internally to Daisy.
+	daisyName string
+}
+
+// MarshalJSON is a hacky workaround to prevent CreateResourcePolicy from
+// using compute.ResourcePolicy's implementation.
+func (c *CreateResourcePolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*c)
+}
+
+// populate preprocesses fields: Name, Project, Region, Description, and daisyName.
+// - sets defaults
+func (c *CreateResourcePolicies) populate(ctx context.Context, s *Step) error {
+	for _, crp := range *c {
+		crp.daisyName = crp.Name
+		if !crp.ExactName {
+			crp.Name = s.w.genName(crp.Name)
+		}
+		crp.Project = strOr(crp.Project, s.project())
+		crp.Region = strOr(crp.Region, getRegionFromZone(s.zone()))
+		crp.Description = strOr(crp.Description, fmt.Sprintf("Resource policy created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+	}
+	return nil
+}
+
+func (c *CreateResourcePolicies) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, crp := range *c {
+		if !checkName(crp.Name) {
+			errs.add(Errorf("cannot create resource policy %q: bad name", crp.Name))
+		}
+		if err := s.w.validateProject(crp.Project); err != nil {
+			errs.add(Errorf("cannot create resource policy: bad project: %q, error: %v", crp.Project, err))
+		}
+		if !checkName(crp.Region) {
+			errs.add(Errorf("cannot create resource policy %q: bad region: %q", crp.Name, crp.Region))
+		}
+		errs.add(crp.validatePlacement()...)
+
+		// Register creation.
+		link := fmt.Sprintf("projects/%s/regions/%s/resourcePolicies/%s", crp.Project, crp.Region, crp.Name)
+		r := &resource{real: crp.Name, link: link, noCleanup: crp.NoCleanup}
+		if err := resourcePolicies[s.w].registerCreation(crp.daisyName, r, s); err != nil {
+			errs.add(Errorf(err.Error()))
+		}
+	}
+
+	return errs.cast()
+}
+
+func (c *CreateResourcePolicy) validatePlacement() (errs Errors) {
+	p := c.GroupPlacementPolicy
+	if p == nil {
+		errs.add(Errorf("cannot create resource policy %q: GroupPlacementPolicy is required", c.Name))
+		return
+	}
+	if p.VmCount < 0 {
+		errs.add(Errorf("cannot create resource policy %q: bad GroupPlacementPolicy.VmCount: %d", c.Name, p.VmCount))
+	}
+	if p.AvailabilityDomainCount < 0 {
+		errs.add(Errorf("cannot create resource policy %q: bad GroupPlacementPolicy.AvailabilityDomainCount: %d", c.Name, p.AvailabilityDomainCount))
+	}
+	switch {
+	case p.Collocation != "" && p.Collocation != collocated:
+		errs.add(Errorf("cannot create resource policy %q: bad GroupPlacementPolicy.Collocation: %q, must be %s", c.Name, p.Collocation, collocated))
+	case p.Collocation == collocated && p.AvailabilityDomainCount != 0:
+		errs.add(Errorf("cannot create resource policy %q: a %s policy can't set GroupPlacementPolicy.AvailabilityDomainCount", c.Name, collocated))
+	case p.Collocation == "" && p.AvailabilityDomainCount == 0:
+		errs.add(Errorf("cannot create resource policy %q: GroupPlacementPolicy needs Collocation %s or an AvailabilityDomainCount", c.Name, collocated))
+	}
+	return
+}
+
+func (c *CreateResourcePolicies) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, crp := range *c {
+		wg.Add(1)
+		go func(crp *CreateResourcePolicy) {
+			defer wg.Done()
+
+			if err := w.checkPolicy(&PlannedResource{Type: "resource policy", Name: crp.Name, Project: crp.Project, Region: crp.Region, Resource: &crp.ResourcePolicy}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating resource policy %q.", crp.Name)
+			if err := w.ComputeClient.CreateResourcePolicy(crp.Project, crp.Region, &crp.ResourcePolicy); err != nil {
+				e <- err
+				return
+			}
+			resourcePolicies[w].markCreated(crp.daisyName)
+		}(crp)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so resource policies being created now can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
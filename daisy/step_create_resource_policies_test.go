@@ -0,0 +1,137 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCreateResourcePoliciesPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	genFoo := w.genName("foo")
+	compact := &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "COLLOCATED", VmCount: 2}
+	tests := []struct {
+		desc        string
+		input, want *CreateResourcePolicy
+	}{
+		{
+			"defaults case",
+			&CreateResourcePolicy{ResourcePolicy: compute.ResourcePolicy{Name: "foo", GroupPlacementPolicy: compact}},
+			&CreateResourcePolicy{ResourcePolicy: compute.ResourcePolicy{Name: genFoo, GroupPlacementPolicy: compact}, daisyName: "foo", Project: w.Project, Region: "test"},
+		},
+		{
+			"non defaults case",
+			&CreateResourcePolicy{ResourcePolicy: compute.ResourcePolicy{Name: "foo", GroupPlacementPolicy: compact}, Project: "pfoo", Region: "rfoo", ExactName: true},
+			&CreateResourcePolicy{ResourcePolicy: compute.ResourcePolicy{Name: "foo", GroupPlacementPolicy: compact}, daisyName: "foo", Project: "pfoo", Region: "rfoo", ExactName: true},
+		},
+	}
+
+	for _, tt := range tests {
+		crps := &CreateResourcePolicies{tt.input}
+		if err := crps.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// Short circuit the description field -- difficult to test, and unimportant.
+		tt.want.Description = tt.input.Description
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateResourcePolicy does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateResourcePoliciesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	crp := func(name, project, region string, p *compute.ResourcePolicyGroupPlacementPolicy) *CreateResourcePolicy {
+		return &CreateResourcePolicy{daisyName: name, ResourcePolicy: compute.ResourcePolicy{Name: name, GroupPlacementPolicy: p}, Project: project, Region: region}
+	}
+	compact := &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "COLLOCATED", VmCount: 2}
+	spread := &compute.ResourcePolicyGroupPlacementPolicy{AvailabilityDomainCount: 2}
+	tests := []struct {
+		desc      string
+		crp       *CreateResourcePolicy
+		shouldErr bool
+	}{
+		{"compact case", crp("rp1", testProject, "r", compact), false},
+		{"spread case", crp("rp2", testProject, "r", spread), false},
+		{"dupe case", crp("rp1", testProject, "r", compact), true},
+		{"bad name case", crp("rp!", testProject, "r", compact), true},
+		{"bad project case", crp("rp3", "p!", "r", compact), true},
+		{"bad region case", crp("rp4", testProject, "r!", compact), true},
+		{"no placement case", crp("rp5", testProject, "r", nil), true},
+		{"empty placement case", crp("rp6", testProject, "r", &compute.ResourcePolicyGroupPlacementPolicy{}), true},
+		{"bad collocation case", crp("rp7", testProject, "r", &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "bad"}), true},
+		{"compact and spread case", crp("rp8", testProject, "r", &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "COLLOCATED", AvailabilityDomainCount: 2}), true},
+		{"bad VM count case", crp("rp9", testProject, "r", &compute.ResourcePolicyGroupPlacementPolicy{Collocation: "COLLOCATED", VmCount: -1}), true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.CreateResourcePolicies = &CreateResourcePolicies{tt.crp}
+		if err := s.CreateResourcePolicies.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	want := "projects/" + testProject + "/regions/r/resourcePolicies/rp1"
+	if r, ok := resourcePolicies[w].get("rp1"); !ok || r.link != want {
+		t.Errorf("resource policy rp1 not registered as expected, got: %+v, want link: %q", r, want)
+	}
+}
+
+func TestCreateResourcePoliciesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	e := errors.New("error")
+	tests := []struct {
+		desc      string
+		clientErr error
+		wantErr   error
+	}{
+		{"normal case", nil, nil},
+		{"client error case", e, e},
+	}
+	for _, tt := range tests {
+		resourcePolicies[w].m = map[string]*resource{"rp": {real: "rp-real", link: "projects/p/regions/r/resourcePolicies/rp-real"}}
+		var gotProject, gotRegion string
+		fake := func(p, r string, _ *compute.ResourcePolicy) error { gotProject, gotRegion = p, r; return tt.clientErr }
+		w.ComputeClient = &daisyCompute.TestClient{CreateResourcePolicyFn: fake}
+		crps := &CreateResourcePolicies{{ResourcePolicy: compute.ResourcePolicy{Name: "rp-real"}, Project: "p", Region: "r", daisyName: "rp"}}
+		if err := crps.run(ctx, s); err != tt.wantErr {
+			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
+		}
+		if gotProject != "p" || gotRegion != "r" {
+			t.Errorf("%s: resource policy created in wrong project/region, got: %q/%q, want: %q/%q", tt.desc, gotProject, gotRegion, "p", "r")
+		}
+		if r, _ := resourcePolicies[w].get("rp"); r.created != (tt.clientErr == nil) {
+			t.Errorf("%s: unexpected created state: %t", tt.desc, r.created)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// CreateSnapshots is a Daisy CreateSnapshots workflow step.
+type CreateSnapshots []*CreateSnapshot
+
+// CreateSnapshot creates a GCE snapshot of a disk. The snapshot is created
+// in the project of its source disk. Snapshots can be referenced by name in
+// the SourceSnapshot of CreateDisks steps.
+type CreateSnapshot struct {
+	compute.Snapshot
+
+	// Should this resource be cleaned up after the workflow?
+	NoCleanup bool
+	// Should we use the user-provided reference name as the actual
+	// resource name?
+	ExactName bool
+
+	// The name of the snapshot as known internally to Daisy.
+	daisyName string
+	// The source disk's project, zone and name, set during validation.
+	project, zone, disk string
+}
+
+// MarshalJSON is a hacky workaround to prevent CreateSnapshot from using
+// compute.Snapshot's implementation.
+func (c *CreateSnapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*c)
+}
+
+// populate preprocesses fields: Name, Description, SourceDisk, and daisyName.
+// - sets defaults
+// - extends short partial URLs to include "projects/<project>"
+func (c *CreateSnapshots) populate(ctx context.Context, s *Step) error {
+	for _, cs := range *c {
+		cs.daisyName = cs.Name
+		if !cs.ExactName {
+			cs.Name = s.w.genName(cs.Name)
+		}
+		cs.Description = strOr(cs.Description, fmt.Sprintf("Snapshot created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+		cs.Labels = s.w.addWorkflowLabels(cs.Labels, cs.NoCleanup)
+		cs.SourceDisk = normalizeURL(cs.SourceDisk, diskURLRgx, s.project(), "")
+	}
+	return nil
+}
+
+func (c *CreateSnapshots) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, cs := range *c {
+		if !checkName(cs.Name) {
+			errs.add(Errorf("cannot create snapshot %q: bad name", cs.Name))
+		}
+
+		d, err := disks[s.w].registerUsage(cs.SourceDisk, s)
+		if err != nil {
+			errs.add(Errorf("cannot create snapshot %q: can't use disk %q: %v", cs.Name, cs.SourceDisk, err))
+			continue
+		}
+		m := namedSubexp(diskURLRgx, d.link)
+		if m["region"] != "" {
+			errs.add(Errorf("cannot create snapshot %q: regional disk %q is not supported", cs.Name, cs.SourceDisk))
+			continue
+		}
+		cs.project, cs.zone, cs.disk = m["project"], m["zone"], m["disk"]
+
+		// Register creation.
+		link := fmt.Sprintf("projects/%s/global/snapshots/%s", cs.project, cs.Name)
+		r := &resource{real: cs.Name, link: link, noCleanup: cs.NoCleanup}
+		if err := snapshots[s.w].registerCreation(cs.daisyName, r, s); err != nil {
+			errs.add(Errorf(err.Error()))
+		}
+	}
+
+	return errs.cast()
+}
+
+func (c *CreateSnapshots) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, cs := range *c {
+		wg.Add(1)
+		go func(cs *CreateSnapshot) {
+			defer wg.Done()
+
+			// SourceDisk is output only, the disk is given in the request path.
+			cs.SourceDisk = ""
+			if err := w.checkPolicy(&PlannedResource{Type: "snapshot", Name: cs.Name, Project: cs.project, Zone: cs.zone, Resource: &cs.Snapshot}); err != nil {
+				e <- err
+				return
+			}
+			w.logger.StepInfo(s, "creating snapshot %q.", cs.Name)
+			if err := w.ComputeClient.CreateSnapshot(cs.project, cs.zone, cs.disk, &cs.Snapshot); err != nil {
+				e <- err
+				return
+			}
+			snapshots[w].markCreated(cs.daisyName)
+		}(cs)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so snapshots being created now can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
@@ -0,0 +1,138 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestCreateSnapshotsPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	genFoo := w.genName("foo")
+	tests := []struct {
+		desc        string
+		input, want *CreateSnapshot
+	}{
+		{
+			"defaults case",
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: "foo", SourceDisk: "d"}},
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: genFoo, SourceDisk: "d"}, daisyName: "foo"},
+		},
+		{
+			"ExactName case",
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: "foo", SourceDisk: "d"}, ExactName: true},
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: "foo", SourceDisk: "d"}, daisyName: "foo", ExactName: true},
+		},
+		{
+			"extend SourceDisk URL case",
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: "foo", SourceDisk: "zones/z/disks/d"}},
+			&CreateSnapshot{Snapshot: compute.Snapshot{Name: genFoo, SourceDisk: fmt.Sprintf("projects/%s/zones/z/disks/d", w.Project)}, daisyName: "foo"},
+		},
+	}
+
+	for _, tt := range tests {
+		css := &CreateSnapshots{tt.input}
+		if err := css.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// Short circuit the description field -- difficult to test, and unimportant.
+		tt.want.Description = tt.input.Description
+		tt.want.Labels = testLabels
+		if diff := pretty.Compare(tt.input, tt.want); diff != "" {
+			t.Errorf("%s: populated CreateSnapshot does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestCreateSnapshotsValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	dCreator := &Step{name: "dCreator", w: w}
+	w.Steps["dCreator"] = dCreator
+	disks[w].m = map[string]*resource{"d": {creator: dCreator, link: "projects/p/zones/z/disks/d-real"}}
+
+	tests := []struct {
+		desc      string
+		cs        *CreateSnapshot
+		shouldErr bool
+	}{
+		{"normal case", &CreateSnapshot{daisyName: "s1", Snapshot: compute.Snapshot{Name: "s1", SourceDisk: "d"}}, false},
+		{"disk url case", &CreateSnapshot{daisyName: "s2", Snapshot: compute.Snapshot{Name: "s2", SourceDisk: "projects/p2/zones/z2/disks/d2"}}, false},
+		{"dupe case", &CreateSnapshot{daisyName: "s1", Snapshot: compute.Snapshot{Name: "s1", SourceDisk: "d"}}, true},
+		{"bad name case", &CreateSnapshot{daisyName: "s3", Snapshot: compute.Snapshot{Name: "s!", SourceDisk: "d"}}, true},
+		{"disk dne case", &CreateSnapshot{daisyName: "s4", Snapshot: compute.Snapshot{Name: "s4", SourceDisk: "dne"}}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(tt.desc, "dCreator")
+		s.CreateSnapshots = &CreateSnapshots{tt.cs}
+		if err := s.CreateSnapshots.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	for name, want := range map[string]string{"s1": "projects/p/global/snapshots/s1", "s2": "projects/p2/global/snapshots/s2"} {
+		if r, ok := snapshots[w].get(name); !ok || r.link != want {
+			t.Errorf("snapshot %s not registered as expected, got: %+v, want link: %q", name, r, want)
+		}
+	}
+}
+
+func TestCreateSnapshotsRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	e := errors.New("error")
+	tests := []struct {
+		desc      string
+		clientErr error
+		wantErr   error
+	}{
+		{"normal case", nil, nil},
+		{"client error case", e, e},
+	}
+	for _, tt := range tests {
+		snapshots[w].m = map[string]*resource{"s": {real: "s-real", link: "projects/p/global/snapshots/s-real"}}
+		var got []string
+		fake := func(p, z, d string, _ *compute.Snapshot) error { got = []string{p, z, d}; return tt.clientErr }
+		w.ComputeClient = &daisyCompute.TestClient{CreateSnapshotFn: fake}
+		css := &CreateSnapshots{{Snapshot: compute.Snapshot{Name: "s-real", SourceDisk: "d"}, daisyName: "s", project: "p", zone: "z", disk: "d-real"}}
+		if err := css.run(ctx, s); err != tt.wantErr {
+			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
+		}
+		if diff := pretty.Compare(got, []string{"p", "z", "d-real"}); diff != "" {
+			t.Errorf("%s: snapshot created from wrong disk: (-got +want)\n%s", tt.desc, diff)
+		}
+		if r, _ := snapshots[w].get("s"); r.created != (tt.clientErr == nil) {
+			t.Errorf("%s: unexpected created state: %t", tt.desc, r.created)
+		}
+	}
+}
@@ -16,19 +16,60 @@ package daisy
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 
+	"cloud.google.com/go/storage"
 	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/iterator"
 )
 
-// DeleteResources deletes GCE resources.
+// DeleteResources deletes GCE resources and GCS objects.
 type DeleteResources struct {
-	Disks     []string `json:",omitempty"`
-	Images    []string `json:",omitempty"`
-	Instances []string `json:",omitempty"`
+	Addresses []string `json:",omitempty"`
+	// Buckets created by the workflow to delete, with the objects in them.
+	Buckets       []string `json:",omitempty"`
+	Disks         []string `json:",omitempty"`
+	FirewallRules []string `json:",omitempty"`
+	// GCS objects, or prefixes if the path ends with a "/", to delete.
+	GCSPaths         []string `json:",omitempty"`
+	Images           []string `json:",omitempty"`
+	Instances        []string `json:",omitempty"`
+	Networks         []string `json:",omitempty"`
+	ResourcePolicies []string `json:",omitempty"`
+	Snapshots        []string `json:",omitempty"`
+	Subnetworks      []string `json:",omitempty"`
 }
 
 func (d *DeleteResources) populate(ctx context.Context, s *Step) error {
+	for i, address := range d.Addresses {
+		d.Addresses[i] = normalizeURL(address, addressURLRegex, s.project(), "")
+	}
+	for i, disk := range d.Disks {
+		d.Disks[i] = normalizeURL(disk, diskURLRgx, s.project(), "")
+	}
+	for i, image := range d.Images {
+		d.Images[i] = normalizeURL(image, imageURLRgx, s.project(), "")
+	}
+	for i, instance := range d.Instances {
+		d.Instances[i] = normalizeURL(instance, instanceURLRgx, s.project(), "")
+	}
+	for i, fw := range d.FirewallRules {
+		d.FirewallRules[i] = normalizeURL(fw, firewallRuleURLRegex, s.project(), "")
+	}
+	for i, network := range d.Networks {
+		d.Networks[i] = normalizeURL(network, networkURLRegex, s.project(), "")
+	}
+	for i, rp := range d.ResourcePolicies {
+		d.ResourcePolicies[i] = normalizeURL(rp, resourcePolicyURLRegex, s.project(), "")
+	}
+	for i, snapshot := range d.Snapshots {
+		d.Snapshots[i] = normalizeURL(snapshot, snapshotURLRgx, s.project(), "")
+	}
+	for i, subnetwork := range d.Subnetworks {
+		d.Subnetworks[i] = normalizeURL(subnetwork, subnetworkURLRegex, s.project(), "")
+	}
 	return nil
 }
 
@@ -79,61 +120,134 @@ func (d *DeleteResources) validate(ctx context.Context, s *Step) error {
 		}
 	}
 
-	return nil
-}
+	// Snapshot checking.
+	for _, snapshot := range d.Snapshots {
+		if err := snapshots[s.w].registerDeletion(snapshot, s); err != nil {
+			return err
+		}
+	}
 
-func (d *DeleteResources) run(ctx context.Context, s *Step) error {
-	var wg sync.WaitGroup
-	w := s.w
-	e := make(chan error)
+	// Address checking.
+	for _, address := range d.Addresses {
+		if err := addresses[s.w].registerDeletion(address, s); err != nil {
+			return err
+		}
+	}
 
-	for _, i := range d.Instances {
-		wg.Add(1)
-		go func(i string) {
-			defer wg.Done()
-			w.logger.Printf("DeleteResources: deleting instance %q.", i)
-			if err := instances[w].delete(i); err != nil {
-				e <- err
-			}
-		}(i)
+	// Bucket checking.
+	for _, b := range d.Buckets {
+		if err := buckets[s.w].registerDeletion(b, s); err != nil {
+			return err
+		}
 	}
 
-	for _, i := range d.Images {
-		wg.Add(1)
-		go func(i string) {
-			defer wg.Done()
-			w.logger.Printf("DeleteResources: deleting image %q.", i)
-			if err := images[w].delete(i); err != nil {
-				e <- err
-			}
-		}(i)
+	// Resource policy checking.
+	for _, rp := range d.ResourcePolicies {
+		if err := resourcePolicies[s.w].registerDeletion(rp, s); err != nil {
+			return err
+		}
 	}
 
-	go func() {
-		wg.Wait()
-		e <- nil
-	}()
+	// Network checking.
+	for _, fw := range d.FirewallRules {
+		if err := firewallRules[s.w].registerDeletion(fw, s); err != nil {
+			return err
+		}
+	}
+	for _, subnetwork := range d.Subnetworks {
+		if err := subnetworks[s.w].registerDeletion(subnetwork, s); err != nil {
+			return err
+		}
+	}
+	for _, network := range d.Networks {
+		if err := networks[s.w].registerDeletion(network, s); err != nil {
+			return err
+		}
+	}
 
-	select {
-	case err := <-e:
+	// GCS path checking.
+	for _, p := range d.GCSPaths {
+		_, obj, err := splitGCSPath(p)
 		if err != nil {
 			return err
 		}
-	case <-w.Cancel:
-		return nil
+		if obj == "" {
+			return fmt.Errorf("cannot delete GCS path %q: deleting whole buckets is not supported", p)
+		}
 	}
 
-	// Delete disks only after instances have been deleted.
-	e = make(chan error)
-	for _, d := range d.Disks {
-		wg.Add(1)
-		go func(d string) {
-			defer wg.Done()
-			w.logger.Printf("DeleteResources: deleting disk %q.", d)
-			if err := disks[w].delete(d); err != nil {
-				e <- err
-			}
-		}(d)
+	return nil
+}
+
+func (d *DeleteResources) run(ctx context.Context, s *Step) error {
+	w := s.w
+	// Resources are deleted in phases, each phase only starts once the
+	// previous one is done:
+	// - disks, firewall rules, addresses and resource policies after the
+	//   instances using them, buckets after the instances writing to them,
+	// - subnetworks after the instances and addresses using them,
+	// - networks after the instances, firewall rules and subnetworks using them.
+	gcsDelete := func(p string) error { return deleteGCSPath(ctx, w, p) }
+	phases := [][]deletion{
+		{
+			resourceDeletion(&instances[w].baseResourceMap, d.Instances),
+			resourceDeletion(&images[w].baseResourceMap, d.Images),
+			resourceDeletion(&snapshots[w].baseResourceMap, d.Snapshots),
+			{"GCS path", d.GCSPaths, gcsDelete},
+		},
+		{
+			resourceDeletion(&disks[w].baseResourceMap, d.Disks),
+			resourceDeletion(&firewallRules[w].baseResourceMap, d.FirewallRules),
+			resourceDeletion(&addresses[w].baseResourceMap, d.Addresses),
+			resourceDeletion(&resourcePolicies[w].baseResourceMap, d.ResourcePolicies),
+			resourceDeletion(&buckets[w].baseResourceMap, d.Buckets),
+		},
+		{
+			resourceDeletion(&subnetworks[w].baseResourceMap, d.Subnetworks),
+		},
+		{
+			resourceDeletion(&networks[w].baseResourceMap, d.Networks),
+		},
+	}
+	for _, phase := range phases {
+		if err := deletePhase(s, phase); err != nil {
+			return err
+		}
+		select {
+		case <-w.cancelChan():
+			return nil
+		default:
+		}
+	}
+	return nil
+}
+
+type deletion struct {
+	typeName string
+	names    []string
+	delete   func(name string) error
+}
+
+func resourceDeletion(rm *baseResourceMap, names []string) deletion {
+	return deletion{rm.typeName, names, rm.delete}
+}
+
+// deletePhase deletes the resources of all deletions in parallel.
+func deletePhase(s *Step, phase []deletion) error {
+	w := s.w
+	var wg sync.WaitGroup
+	e := make(chan error)
+	for _, del := range phase {
+		for _, name := range del.names {
+			wg.Add(1)
+			go func(del deletion, name string) {
+				defer wg.Done()
+				w.logger.StepInfo(s, "deleting %s %q.", del.typeName, name)
+				if err := del.delete(name); err != nil {
+					e <- err
+				}
+			}(del, name)
+		}
 	}
 
 	go func() {
@@ -144,7 +258,33 @@ func (d *DeleteResources) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-e:
 		return err
-	case <-w.Cancel:
+	case <-w.cancelChan():
+		return nil
+	}
+}
+
+// deleteGCSPath deletes the GCS object at p or, if p ends with a "/", all
+// objects under the prefix p.
+func deleteGCSPath(ctx context.Context, w *Workflow, p string) error {
+	bkt, obj, err := splitGCSPath(p)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(obj, "/") {
+		if err := w.StorageClient.Bucket(bkt).Object(obj).Delete(ctx); err != nil {
+			return fmt.Errorf("error deleting %s: %v", p, err)
+		}
 		return nil
 	}
+
+	it := w.StorageClient.Bucket(bkt).Objects(ctx, &storage.Query{Prefix: obj})
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return fmt.Errorf("error listing %s: %v", p, err)
+		}
+		if err := w.StorageClient.Bucket(bkt).Object(objAttr.Name).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return fmt.Errorf("error deleting gs://%s/%s: %v", bkt, objAttr.Name, err)
+		}
+	}
+	return nil
 }
@@ -16,15 +16,41 @@ package daisy
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"github.com/kylelemons/godebug/pretty"
 	compute "google.golang.org/api/compute/v1"
 )
 
 func TestDeleteResourcesPopulate(t *testing.T) {
-	if err := (&DeleteResources{}).populate(context.Background(), &Step{}); err != nil {
-		t.Error("not implemented, err should be nil")
+	w := testWorkflow()
+	got := &DeleteResources{
+		Disks:         []string{"d", "zones/z/disks/d"},
+		Images:        []string{"https://www.googleapis.com/compute/v1/projects/p/global/images/i"},
+		Instances:     []string{"i", "https://www.googleapis.com/compute/beta/projects/p/zones/z/instances/i"},
+		FirewallRules: []string{"global/firewalls/f"},
+		Networks:      []string{"n", "global/networks/n"},
+		Snapshots:     []string{"s", "https://www.googleapis.com/compute/v1/projects/p/global/snapshots/s"},
+		Subnetworks:   []string{"regions/r/subnetworks/sn"},
+	}
+	if err := got.populate(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("error running populate: %v", err)
+	}
+
+	want := &DeleteResources{
+		Disks:         []string{"d", fmt.Sprintf("projects/%s/zones/z/disks/d", w.Project)},
+		Images:        []string{"projects/p/global/images/i"},
+		Instances:     []string{"i", "projects/p/zones/z/instances/i"},
+		FirewallRules: []string{fmt.Sprintf("projects/%s/global/firewalls/f", w.Project)},
+		Networks:      []string{"n", fmt.Sprintf("projects/%s/global/networks/n", w.Project)},
+		Snapshots:     []string{"s", "projects/p/global/snapshots/s"},
+		Subnetworks:   []string{fmt.Sprintf("projects/%s/regions/r/subnetworks/sn", w.Project)},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("populated DeleteResources does not match expectation: (-got +want)\n%s", diff)
 	}
 }
 
@@ -67,6 +93,95 @@ func TestDeleteResourcesRun(t *testing.T) {
 	}
 }
 
+func TestDeleteResourcesRunGCSPaths(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	tests := []struct {
+		desc      string
+		paths     []string
+		want      []string
+		shouldErr bool
+	}{
+		{"object case", []string{"gs://bucket/object"}, []string{"object"}, false},
+		{"prefix case", []string{"gs://bucket/folder/"}, []string{"folder/object", "folder/folder/object"}, false},
+		{"object DNE case", []string{"gs://bucket/dne"}, nil, true},
+	}
+	for _, tt := range tests {
+		testGCSDeleted = nil
+		dr := &DeleteResources{GCSPaths: tt.paths}
+		if err := dr.run(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(testGCSDeleted, tt.want); diff != "" {
+			t.Errorf("%s: deleted GCS objects do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestDeleteResourcesRunOrder(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	instances[w].m = map[string]*resource{"in": {link: "projects/p/zones/z/instances/in"}}
+	images[w].m = map[string]*resource{"im": {link: "projects/p/global/images/im"}}
+	snapshots[w].m = map[string]*resource{"s": {link: "projects/p/global/snapshots/s"}}
+	disks[w].m = map[string]*resource{"d": {link: "projects/p/zones/z/disks/d"}}
+	firewallRules[w].m = map[string]*resource{"f": {link: "projects/p/global/firewalls/f"}}
+	subnetworks[w].m = map[string]*resource{"sn": {link: "projects/p/regions/r/subnetworks/sn"}}
+	networks[w].m = map[string]*resource{"n": {link: "projects/p/global/networks/n"}}
+	addresses[w].m = map[string]*resource{"a": {link: "projects/p/regions/r/addresses/a"}}
+	resourcePolicies[w].m = map[string]*resource{"rp": {link: "projects/p/regions/r/resourcePolicies/rp"}}
+
+	var mx sync.Mutex
+	var order []string
+	del := func(name string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		order = append(order, name)
+		return nil
+	}
+	w.ComputeClient = &daisyCompute.TestClient{
+		DeleteInstanceFn:       func(_, _, n string) error { return del(n) },
+		DeleteImageFn:          func(_, n string) error { return del(n) },
+		DeleteSnapshotFn:       func(_, n string) error { return del(n) },
+		DeleteDiskFn:           func(_, _, n string) error { return del(n) },
+		DeleteFirewallRuleFn:   func(_, n string) error { return del(n) },
+		DeleteSubnetworkFn:     func(_, _, n string) error { return del(n) },
+		DeleteNetworkFn:        func(_, n string) error { return del(n) },
+		DeleteAddressFn:        func(_, _, n string) error { return del(n) },
+		DeleteResourcePolicyFn: func(_, _, n string) error { return del(n) },
+	}
+
+	dr := &DeleteResources{
+		Addresses:        []string{"a"},
+		Disks:            []string{"d"},
+		FirewallRules:    []string{"f"},
+		Images:           []string{"im"},
+		Instances:        []string{"in"},
+		Networks:         []string{"n"},
+		ResourcePolicies: []string{"rp"},
+		Snapshots:        []string{"s"},
+		Subnetworks:      []string{"sn"},
+	}
+	if err := dr.run(ctx, s); err != nil {
+		t.Fatalf("error running DeleteResources.run(): %v", err)
+	}
+
+	wantPhases := map[string]int{"in": 0, "im": 0, "s": 0, "d": 1, "f": 1, "a": 1, "rp": 1, "sn": 2, "n": 3}
+	if len(order) != len(wantPhases) {
+		t.Fatalf("unexpected deletions: %q", order)
+	}
+	for i := 1; i < len(order); i++ {
+		if wantPhases[order[i]] < wantPhases[order[i-1]] {
+			t.Errorf("%q deleted after %q: %q", order[i], order[i-1], order)
+		}
+	}
+}
+
 func TestDeleteResourcesValidate(t *testing.T) {
 	// Test:
 	// - delete d0, im0, and in0 explicitly.
@@ -136,4 +251,36 @@ func TestDeleteResourcesValidate(t *testing.T) {
 	want[3].deleter = otherDeleter
 	want[5].deleter = otherDeleter
 	CompareResources(got, want)
+
+	// Networks, firewall rules and subnetworks. Test:
+	// - deleting a network before the steps using it fails
+	// - deleting existing resources by URL
+	nU, _ := w.NewStep("networkUser")
+	networks[w].m = map[string]*resource{"n": {real: "n", link: "projects/p/global/networks/n", users: []*Step{nU}}}
+	if err := (&DeleteResources{Networks: []string{"n"}}).validate(ctx, s); err == nil {
+		t.Error("DeleteResources should have returned an error when deleting a network before the steps using it")
+	}
+	w.AddDependency("s", "networkUser")
+	dr = DeleteResources{
+		Networks:      []string{"n"},
+		FirewallRules: []string{"projects/p/global/firewalls/f"},
+		Snapshots:     []string{"projects/p/global/snapshots/s"},
+		Subnetworks:   []string{"projects/p/regions/r/subnetworks/sn"},
+	}
+	if err := dr.validate(ctx, s); err != nil {
+		t.Errorf("validation should not have failed: %v", err)
+	}
+	if err := (&DeleteResources{FirewallRules: []string{"f"}}).validate(ctx, s); err == nil {
+		t.Error("DeleteResources should have returned an error when deleting a firewall rule that DNE")
+	}
+
+	// GCS paths.
+	if err := (&DeleteResources{GCSPaths: []string{"gs://bucket/object", "gs://bucket/prefix/"}}).validate(ctx, s); err != nil {
+		t.Errorf("validation should not have failed: %v", err)
+	}
+	for _, p := range []string{"gs://bucket", "gs://bucket/", "bucket/object"} {
+		if err := (&DeleteResources{GCSPaths: []string{p}}).validate(ctx, s); err == nil {
+			t.Errorf("DeleteResources should have returned an error when deleting GCS path %q", p)
+		}
+	}
 }
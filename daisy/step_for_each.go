@@ -0,0 +1,113 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ForEach runs a copy of Step for each of Items, in parallel. The copies
+// run in a workflow included into the parent, as with IncludeWorkflow, so
+// they share the parent's resources.
+type ForEach struct {
+	// Items to run Step for. Items with commas, e.g. a var holding a comma
+	// separated list, are split into several items.
+	Items []string
+	// Step to copy for each item, with "${ITEM}" replaced by the item.
+	// Use the Vars of a SubWorkflow or IncludeWorkflow Step to pass the
+	// item on.
+	Step *Step `json:",omitempty"`
+	w    *Workflow
+}
+
+// items returns the items of f, with comma separated lists split.
+func (f *ForEach) items() []string {
+	var items []string
+	for _, i := range f.Items {
+		for _, item := range strings.Split(i, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// newStep returns a copy of the Step of f for item, named name, in iw.
+func (f *ForEach) newStep(iw *Workflow, name, item string) (*Step, error) {
+	b, err := json.Marshal(f.Step)
+	if err != nil {
+		return nil, err
+	}
+	st := &Step{name: name, w: iw, testType: f.Step.testType}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	substitute(reflect.ValueOf(st).Elem(), substitutionReplacer("${ITEM}", item))
+
+	if st.SubWorkflow != nil {
+		if st.SubWorkflow.w, err = iw.NewSubWorkflowFromFile(st.SubWorkflow.Path); err != nil {
+			return nil, err
+		}
+	}
+	if st.IncludeWorkflow != nil {
+		if st.IncludeWorkflow.w, err = iw.NewIncludedWorkflowFromFile(st.IncludeWorkflow.Path); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func (f *ForEach) populate(ctx context.Context, s *Step) error {
+	if f.Step == nil {
+		// Reported by validate.
+		return nil
+	}
+	if len(f.Step.DependsOn) > 0 {
+		return errors.New("ForEach: DependsOn must be set on the ForEach step, not its Step")
+	}
+	iw := s.w.NewIncludedWorkflow()
+	iw.workflowDir = s.w.workflowDir
+	iw.Steps = map[string]*Step{}
+	for i, item := range f.items() {
+		name := fmt.Sprintf("%s-%d", s.name, i)
+		st, err := f.newStep(iw, name, item)
+		if err != nil {
+			return fmt.Errorf("ForEach: error copying step for item %q: %v", item, err)
+		}
+		iw.Steps[name] = st
+	}
+	f.w = iw
+	return (&IncludeWorkflow{w: iw}).populate(ctx, s)
+}
+
+func (f *ForEach) validate(ctx context.Context, s *Step) error {
+	if f.w == nil {
+		return errors.New("ForEach: no Step given")
+	}
+	if len(f.w.Steps) == 0 {
+		return errors.New("ForEach: no Items given")
+	}
+	return f.w.validate(ctx)
+}
+
+func (f *ForEach) run(ctx context.Context, s *Step) error {
+	return f.w.run(ctx)
+}
@@ -0,0 +1,161 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestForEachPopulate(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "loop", w: w, ForEach: &ForEach{
+		Items: []string{"a", "b, c", ""},
+		Step: &Step{
+			Timeout:        "5m",
+			CopyGCSObjects: &CopyGCSObjects{{Source: "gs://bkt/${ITEM}", Destination: "gs://bkt/out/${ITEM}"}},
+		},
+	}}
+	w.Steps = map[string]*Step{"loop": s}
+
+	if err := s.ForEach.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.ForEach.Step.CopyGCSObjects == nil || (*s.ForEach.Step.CopyGCSObjects)[0].Source != "gs://bkt/${ITEM}" {
+		t.Errorf("template step was modified: %+v", s.ForEach.Step)
+	}
+	if err := w.validateVarsSubbed(); err != nil {
+		t.Errorf("unexpected error checking for unsubstituted vars: %v", err)
+	}
+
+	got := map[string]*CopyGCSObjects{}
+	for name, st := range s.ForEach.w.Steps {
+		if st.timeout != 5*time.Minute {
+			t.Errorf("step %q: unexpected timeout, got: %s, want: 5m", name, st.timeout)
+		}
+		got[name] = st.CopyGCSObjects
+	}
+	want := map[string]*CopyGCSObjects{
+		"loop-0": {{Source: "gs://bkt/a", Destination: "gs://bkt/out/a"}},
+		"loop-1": {{Source: "gs://bkt/b", Destination: "gs://bkt/out/b"}},
+		"loop-2": {{Source: "gs://bkt/c", Destination: "gs://bkt/out/c"}},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("expanded steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	if s.ForEach.w.parent != w || s.ForEach.w.Name != "loop" {
+		t.Errorf("copies are not in a workflow included by the step, parent: %p, name: %q", s.ForEach.w.parent, s.ForEach.w.Name)
+	}
+}
+
+func TestForEachPopulateSubWorkflow(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	sub := `{"Vars": {"image": {"Required": true}}, "Steps": {"copy": {"CopyGCSObjects": [{"Source": "gs://bkt/${image}", "Destination": "gs://bkt/out/${image}"}]}}}`
+	if err := ioutil.WriteFile(filepath.Join(td, "sub.wf.json"), []byte(sub), 0600); err != nil {
+		t.Fatalf("error creating json file: %v", err)
+	}
+
+	w := testWorkflow()
+	w.populate(context.Background())
+	w.workflowDir = td
+	s := &Step{name: "loop", w: w, ForEach: &ForEach{
+		Items: []string{"debian-9"},
+		Step:  &Step{SubWorkflow: &SubWorkflow{Path: "sub.wf.json", Vars: map[string]string{"image": "${ITEM}"}}},
+	}}
+	w.Steps = map[string]*Step{"loop": s}
+
+	if err := s.ForEach.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sw := s.ForEach.w.Steps["loop-0"].SubWorkflow
+	if sw.w == nil {
+		t.Fatal("subworkflow was not read")
+	}
+	want := &CopyGCSObjects{{Source: "gs://bkt/debian-9", Destination: "gs://bkt/out/debian-9"}}
+	if diff := pretty.Compare(sw.w.Steps["copy"].CopyGCSObjects, want); diff != "" {
+		t.Errorf("subworkflow step does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestForEachValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		f         *ForEach
+		shouldErr bool
+	}{
+		{"normal case", &ForEach{Items: []string{"a"}, Step: &Step{testType: &mockStep{}}}, false},
+		{"no step case", &ForEach{Items: []string{"a"}}, true},
+		{"no items case", &ForEach{Items: []string{","}, Step: &Step{testType: &mockStep{}}}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s := &Step{name: "loop", w: w, ForEach: tt.f}
+		w.Steps = map[string]*Step{"loop": s}
+		if err := tt.f.populate(context.Background(), s); err != nil {
+			t.Errorf("%s: unexpected populate error: %v", tt.desc, err)
+			continue
+		}
+		err := tt.f.validate(context.Background(), s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestForEachRun(t *testing.T) {
+	w := testWorkflow()
+	var mx sync.Mutex
+	var got []string
+	s := &Step{name: "loop", w: w, timeout: time.Minute, ForEach: &ForEach{
+		Items: []string{"a,b"},
+		Step: &Step{testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			mx.Lock()
+			defer mx.Unlock()
+			got = append(got, s.name)
+			return nil
+		}}},
+	}}
+	w.Steps = map[string]*Step{"loop": s}
+	if err := s.ForEach.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected populate error: %v", err)
+	}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(got)
+	if diff := pretty.Compare(got, []string{"loop-0", "loop-1"}); diff != "" {
+		t.Errorf("run steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	completed := w.Result().CompletedSteps
+	sort.Strings(completed)
+	if diff := pretty.Compare(completed, []string{"loop", "loop.loop-0", "loop.loop-1"}); diff != "" {
+		t.Errorf("completed steps do not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,220 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/compute/metadata"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// GrantRoles is a Daisy GrantRoles workflow step.
+type GrantRoles []*GrantRole
+
+// GrantRole grants an IAM role on a project for the rest of the run, e.g.
+// to let a publish step use a role the credentials don't hold otherwise.
+// The role is revoked when the workflow is cleaned up, unless the member
+// already had it.
+type GrantRole struct {
+	// Role to grant, e.g. "roles/compute.imageUser".
+	Role string
+	// Member to grant Role to, e.g. "serviceAccount:build@p.iam.gserviceaccount.com".
+	// Defaults to the service account of the workflow credentials.
+	Member string `json:",omitempty"`
+	// Project to grant Role on, overrides workflow Project.
+	Project string `json:",omitempty"`
+
+	// Set if the member had the role before the step ran.
+	existed bool
+}
+
+var (
+	iamMemberRgx = regexp.MustCompile(`^(user|serviceAccount|group|domain):.+$`)
+	iamRoleRgx   = regexp.MustCompile(`^(roles/[\w.]+|(projects|organizations)/[^/]+/roles/[\w.]+)$`)
+	// iamPolicyMx serializes the policy changes of GrantRoles steps, so
+	// concurrent steps don't conflict on the policy Etag.
+	iamPolicyMx sync.Mutex
+)
+
+// credentialsServiceAccount returns the email of the service account of
+// the credentials in oauthPath, or of the default credentials if it is
+// empty.
+func credentialsServiceAccount(oauthPath string) (string, error) {
+	p := strOr(oauthPath, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	if p != "" {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		var creds struct {
+			Type        string `json:"type"`
+			ClientEmail string `json:"client_email"`
+		}
+		if err := json.Unmarshal(b, &creds); err != nil {
+			return "", fmt.Errorf("error reading credentials %q: %v", p, err)
+		}
+		if creds.Type != "service_account" || creds.ClientEmail == "" {
+			return "", fmt.Errorf("credentials %q are not of a service account", p)
+		}
+		return creds.ClientEmail, nil
+	}
+	if metadata.OnGCE() {
+		return metadata.Email("")
+	}
+	return "", errors.New("no service account credentials")
+}
+
+// populate preprocesses fields: Member and Project.
+// - sets defaults
+func (g *GrantRoles) populate(ctx context.Context, s *Step) error {
+	for _, gr := range *g {
+		gr.Project = strOr(gr.Project, s.project())
+		if gr.Member != "" {
+			continue
+		}
+		sa, err := credentialsServiceAccount(s.w.OAuthPath)
+		if err != nil {
+			return fmt.Errorf("cannot grant role %q: Member not set and can't tell the service account of the workflow credentials: %v", gr.Role, err)
+		}
+		gr.Member = "serviceAccount:" + sa
+	}
+	return nil
+}
+
+func (g *GrantRoles) validate(ctx context.Context, s *Step) error {
+	for _, gr := range *g {
+		if !iamRoleRgx.MatchString(gr.Role) {
+			return fmt.Errorf("cannot grant role: bad role: %q", gr.Role)
+		}
+		if !iamMemberRgx.MatchString(gr.Member) {
+			return fmt.Errorf("cannot grant role %q: bad member: %q", gr.Role, gr.Member)
+		}
+		if err := s.w.validateProject(gr.Project); err != nil {
+			return fmt.Errorf("cannot grant role %q: bad project: %q, error: %v", gr.Role, gr.Project, err)
+		}
+	}
+	return nil
+}
+
+func (g *GrantRoles) run(ctx context.Context, s *Step) error {
+	w := s.w
+	for _, gr := range *g {
+		w.logger.StepInfo(s, "granting role %q to %q on project %q.", gr.Role, gr.Member, gr.Project)
+		err := updateIamPolicy(w.ComputeClient, gr.Project, func(p *cloudresourcemanager.Policy) bool {
+			gr.existed = !addIamBinding(p, gr.Role, gr.Member)
+			return !gr.existed
+		})
+		if err != nil {
+			return fmt.Errorf("error granting role %q to %q on project %q: %v", gr.Role, gr.Member, gr.Project, err)
+		}
+		if gr.existed {
+			w.logger.StepInfo(s, "%q already has role %q on project %q, it won't be revoked.", gr.Member, gr.Role, gr.Project)
+			continue
+		}
+		w.root().addCleanupHook(gr.revokeHook(s))
+	}
+	return nil
+}
+
+// revokeHook returns a cleanup hook revoking the role of gr.
+func (gr *GrantRole) revokeHook(s *Step) func() error {
+	w := s.w
+	return func() error {
+		w.logger.StepInfo(s, "revoking role %q of %q on project %q.", gr.Role, gr.Member, gr.Project)
+		err := updateIamPolicy(w.ComputeClient, gr.Project, func(p *cloudresourcemanager.Policy) bool {
+			return removeIamBinding(p, gr.Role, gr.Member)
+		})
+		if err != nil {
+			return fmt.Errorf("error revoking role %q of %q on project %q: %v", gr.Role, gr.Member, gr.Project, err)
+		}
+		return nil
+	}
+}
+
+// updateIamPolicy reads the IAM policy of project, changes it with f, and
+// writes it back if f returns true. It retries if the policy changed in the
+// meantime.
+func updateIamPolicy(client compute.Client, project string, f func(*cloudresourcemanager.Policy) bool) error {
+	iamPolicyMx.Lock()
+	defer iamPolicyMx.Unlock()
+	var err error
+	for i := 0; i < 5; i++ {
+		var p *cloudresourcemanager.Policy
+		if p, err = client.GetProjectIamPolicy(project); err != nil {
+			return err
+		}
+		if !f(p) {
+			return nil
+		}
+		err = client.SetProjectIamPolicy(project, p)
+		if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 409 {
+			return err
+		}
+	}
+	return err
+}
+
+// addIamBinding adds member to the unconditional binding of role in p,
+// returns false if it was already there. Conditional bindings of role are
+// left alone, adding member there would only grant the role conditionally.
+func addIamBinding(p *cloudresourcemanager.Policy, role, member string) bool {
+	for _, b := range p.Bindings {
+		if b.Role != role || b.Condition != nil {
+			continue
+		}
+		for _, m := range b.Members {
+			if strings.EqualFold(m, member) {
+				return false
+			}
+		}
+		b.Members = append(b.Members, member)
+		return true
+	}
+	p.Bindings = append(p.Bindings, &cloudresourcemanager.Binding{Role: role, Members: []string{member}})
+	return true
+}
+
+// removeIamBinding removes member from the unconditional binding of role in
+// p, dropping the binding if it has no members left. Returns false if member
+// wasn't in the binding.
+func removeIamBinding(p *cloudresourcemanager.Policy, role, member string) bool {
+	for i, b := range p.Bindings {
+		if b.Role != role || b.Condition != nil {
+			continue
+		}
+		for j, m := range b.Members {
+			if !strings.EqualFold(m, member) {
+				continue
+			}
+			b.Members = append(b.Members[:j], b.Members[j+1:]...)
+			if len(b.Members) == 0 {
+				p.Bindings = append(p.Bindings[:i], p.Bindings[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
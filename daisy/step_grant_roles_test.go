@@ -0,0 +1,145 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGrantRolesPopulate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daisy-grant-roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	creds := filepath.Join(dir, "creds.json")
+	if err := ioutil.WriteFile(creds, []byte(`{"type": "service_account", "client_email": "sa@p.iam.gserviceaccount.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := testWorkflow()
+	w.OAuthPath = creds
+	g := &GrantRoles{{Role: "roles/a"}, {Role: "roles/b", Member: "user:u@example.com", Project: "other"}}
+	if err := g.populate(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &GrantRoles{
+		{Role: "roles/a", Member: "serviceAccount:sa@p.iam.gserviceaccount.com", Project: testProject},
+		{Role: "roles/b", Member: "user:u@example.com", Project: "other"},
+	}
+	if diff := pretty.Compare(g, want); diff != "" {
+		t.Errorf("populated GrantRoles do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	if err := ioutil.WriteFile(creds, []byte(`{"type": "authorized_user"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	g = &GrantRoles{{Role: "roles/a"}}
+	if err := g.populate(context.Background(), &Step{w: w}); err == nil {
+		t.Error("populate should have failed without a Member or service account credentials")
+	}
+}
+
+func TestGrantRolesValidate(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc      string
+		gr        *GrantRole
+		shouldErr bool
+	}{
+		{"normal case", &GrantRole{Role: "roles/compute.imageUser", Member: "serviceAccount:sa@p.iam.gserviceaccount.com", Project: testProject}, false},
+		{"custom role case", &GrantRole{Role: "projects/p/roles/publisher", Member: "group:g@example.com", Project: testProject}, false},
+		{"bad role case", &GrantRole{Role: "compute.imageUser", Member: "user:u@example.com", Project: testProject}, true},
+		{"bad member case", &GrantRole{Role: "roles/compute.imageUser", Member: "u@example.com", Project: testProject}, true},
+		{"bad project case", &GrantRole{Role: "roles/compute.imageUser", Member: "user:u@example.com", Project: "bad"}, true},
+	}
+
+	for _, tt := range tests {
+		g := &GrantRoles{tt.gr}
+		if err := g.validate(context.Background(), &Step{w: w}); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestGrantRolesRun(t *testing.T) {
+	w := testWorkflow()
+	cond := &cloudresourcemanager.Expr{Title: "expires", Expression: `request.time < timestamp("2020-01-01T00:00:00Z")`}
+	policy := &cloudresourcemanager.Policy{Version: 3, Bindings: []*cloudresourcemanager.Binding{
+		{Role: "roles/viewer", Members: []string{"user:u@example.com"}},
+		{Role: "roles/compute.imageUser", Members: []string{"serviceAccount:sa@p.iam.gserviceaccount.com"}, Condition: cond},
+	}}
+	conflicts := 1
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetProjectIamPolicyFn = func(_ string) (*cloudresourcemanager.Policy, error) {
+		p := cloudresourcemanager.Policy{Version: policy.Version}
+		for _, b := range policy.Bindings {
+			p.Bindings = append(p.Bindings, &cloudresourcemanager.Binding{Role: b.Role, Members: append([]string(nil), b.Members...), Condition: b.Condition})
+		}
+		return &p, nil
+	}
+	tc.SetProjectIamPolicyFn = func(_ string, p *cloudresourcemanager.Policy) error {
+		if conflicts > 0 {
+			conflicts--
+			return &googleapi.Error{Code: 409}
+		}
+		policy = p
+		return nil
+	}
+
+	g := &GrantRoles{
+		{Role: "roles/compute.imageUser", Member: "serviceAccount:sa@p.iam.gserviceaccount.com", Project: testProject},
+		{Role: "roles/viewer", Member: "user:u@example.com", Project: testProject},
+	}
+	hooks := len(w.cleanupHooks)
+	if err := g.run(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The conditional binding is left alone, the role is granted
+	// unconditionally next to it.
+	want := []*cloudresourcemanager.Binding{
+		{Role: "roles/viewer", Members: []string{"user:u@example.com"}},
+		{Role: "roles/compute.imageUser", Members: []string{"serviceAccount:sa@p.iam.gserviceaccount.com"}, Condition: cond},
+		{Role: "roles/compute.imageUser", Members: []string{"serviceAccount:sa@p.iam.gserviceaccount.com"}},
+	}
+	if diff := pretty.Compare(policy.Bindings, want); diff != "" {
+		t.Errorf("bindings after run do not match expectation: (-got +want)\n%s", diff)
+	}
+	if policy.Version != 3 {
+		t.Errorf("policy version not preserved, got: %d, want: 3", policy.Version)
+	}
+
+	// Only the new binding is revoked, the member already had roles/viewer.
+	if len(w.cleanupHooks) != hooks+1 {
+		t.Fatalf("unexpected number of cleanup hooks added, got: %d, want: 1", len(w.cleanupHooks)-hooks)
+	}
+	if err := w.cleanupHooks[hooks](); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(policy.Bindings, want[:2]); diff != "" {
+		t.Errorf("bindings after cleanup do not match expectation: (-got +want)\n%s", diff)
+	}
+}
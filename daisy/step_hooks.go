@@ -0,0 +1,84 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+)
+
+// StepEvent is the point of a step's run a step hook is called at.
+type StepEvent int
+
+const (
+	// BeforeStep hooks are called before a step runs. An error fails the
+	// step without running it, e.g. to withhold an approval.
+	BeforeStep StepEvent = iota
+	// AfterStep hooks are called once a step whose BeforeStep hooks were
+	// called is done, with its State set. Errors are logged, they don't
+	// change the step's outcome.
+	AfterStep
+)
+
+func (e StepEvent) String() string {
+	switch e {
+	case BeforeStep:
+		return "BeforeStep"
+	case AfterStep:
+		return "AfterStep"
+	}
+	return fmt.Sprintf("StepEvent(%d)", int(e))
+}
+
+// AddStepHook adds a hook called before and after each step of w and its
+// nested workflows runs, e.g. to record metrics or send notifications.
+// Hooks of parent workflows are called before those of w, in the order
+// they were added. Steps skipped by Resume don't run hooks.
+func (w *Workflow) AddStepHook(hook func(ctx context.Context, s *Step, e StepEvent) error) {
+	w.stepHooksMx.Lock()
+	defer w.stepHooksMx.Unlock()
+	w.stepHooks = append(w.stepHooks, hook)
+}
+
+// runStepHooks calls the step hooks of w and its parents for event e of s.
+// It returns the error of the first failing BeforeStep hook, wrapped in a
+// *StepError.
+func (w *Workflow) runStepHooks(ctx context.Context, s *Step, e StepEvent) error {
+	var hooks []func(context.Context, *Step, StepEvent) error
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.stepHooksMx.Lock()
+		hooks = append(append([]func(context.Context, *Step, StepEvent) error{}, wf.stepHooks...), hooks...)
+		wf.stepHooksMx.Unlock()
+	}
+	for _, hook := range hooks {
+		if err := hook(ctx, s, e); err != nil {
+			if e == BeforeStep {
+				return s.wrapRunError(fmt.Errorf("%s hook: %v", e, err))
+			}
+			w.logger.StepInfo(s, "%s hook: %v", e, err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of s in its workflow.
+func (s *Step) Name() string {
+	return s.name
+}
+
+// Type returns the name of the step type of s, e.g. "CreateDisks".
+func (s *Step) Type() string {
+	return s.typeName()
+}
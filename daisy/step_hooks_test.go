@@ -0,0 +1,95 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestStepHooks(t *testing.T) {
+	w := testWorkflow()
+	var calls []string
+	var mx sync.Mutex
+	record := func(prefix string) func(context.Context, *Step, StepEvent) error {
+		return func(ctx context.Context, s *Step, e StepEvent) error {
+			mx.Lock()
+			defer mx.Unlock()
+			calls = append(calls, fmt.Sprintf("%s %s %s %s", prefix, e, s.Name(), s.State()))
+			if s.Name() == "denied" && e == BeforeStep {
+				return errors.New("not approved")
+			}
+			return nil
+		}
+	}
+	w.AddStepHook(record("parent"))
+	iw := w.NewIncludedWorkflow()
+	iw.parent = w
+	iw.logger = w.logger
+	iw.AddStepHook(record("included"))
+	iw.Steps = map[string]*Step{"inner": {name: "inner", w: iw, timeout: time.Minute, testType: &mockStep{}}}
+	w.Steps = map[string]*Step{
+		"s0":      {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"include": {name: "include", w: w, timeout: time.Minute, IncludeWorkflow: &IncludeWorkflow{w: iw}},
+		"denied":  {name: "denied", w: w, timeout: time.Minute, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"include": {"s0"}, "denied": {"include"}}
+
+	err := w.run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `step "denied" run error: BeforeStep hook: not approved`) {
+		t.Errorf("did not get expected error, got: %v", err)
+	}
+	if se, ok := err.(*StepError); !ok || se.Step != "denied" || se.Phase != PhaseRun {
+		t.Errorf("hook error is not a run StepError of the step: %#v", err)
+	}
+
+	want := []string{
+		"parent BeforeStep s0 running",
+		"parent AfterStep s0 finished",
+		"parent BeforeStep include running",
+		"parent BeforeStep inner running",
+		"included BeforeStep inner running",
+		"parent AfterStep inner finished",
+		"included AfterStep inner finished",
+		"parent AfterStep include finished",
+		"parent BeforeStep denied running",
+		"parent AfterStep denied failed",
+	}
+	if diff := pretty.Compare(calls, want); diff != "" {
+		t.Errorf("hook calls do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	// Steps failing before they start, e.g. as the upload of a source they
+	// use failed, call no hooks.
+	calls = nil
+	w = testWorkflow()
+	w.AddStepHook(record("parent"))
+	failed := &sourceUpload{dst: "src", done: make(chan struct{}), err: errors.New("fail")}
+	close(failed.done)
+	w.Steps = map[string]*Step{"uses": {name: "uses", w: w, timeout: time.Minute, uploads: []*sourceUpload{failed}, testType: &mockStep{}}}
+	if err := w.run(context.Background()); err == nil {
+		t.Error("should have returned an error")
+	}
+	if len(calls) != 0 {
+		t.Errorf("unexpected hook calls: %q", calls)
+	}
+}
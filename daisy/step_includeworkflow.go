@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"path/filepath"
 	"reflect"
-	"strings"
 )
 
 // IncludeWorkflow defines a Daisy workflow injection step. This step will
@@ -38,10 +37,17 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) error {
 	i.w.username = s.w.username
 	i.w.ComputeClient = s.w.ComputeClient
 	i.w.StorageClient = s.w.StorageClient
+	i.w.ErrorReporting = s.w.ErrorReporting
+	i.w.errorReportingClient = s.w.errorReportingClient
+	i.w.ClearDeletionProtection = s.w.ClearDeletionProtection
+	i.w.SerialCloudLogging = s.w.SerialCloudLogging
+	i.w.loggingClient = s.w.loggingClient
+	i.w.secretManagerClient = s.w.secretManagerClient
 	i.w.GCSPath = s.w.GCSPath
 	i.w.Name = s.name
-	i.w.Project = s.w.Project
-	i.w.Zone = s.w.Zone
+	i.w.Project = s.project()
+	i.w.Zone = s.zone()
+	i.w.OSLogin = s.w.OSLogin
 	i.w.autovars = s.w.autovars
 	i.w.bucket = s.w.bucket
 	i.w.scratchPath = s.w.scratchPath
@@ -49,12 +55,18 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) error {
 	i.w.logsPath = s.w.logsPath
 	i.w.outsPath = s.w.outsPath
 	i.w.gcsLogWriter = s.w.gcsLogWriter
+	i.w.cloudLogWriter = s.w.cloudLogWriter
+	i.w.stepLogs = s.w.stepLogs
 	i.w.gcsLogging = s.w.gcsLogging
 
 	for k, v := range i.Vars {
 		i.w.AddVar(k, v)
 	}
 
+	if err := i.w.populateProjectNumber(); err != nil {
+		return err
+	}
+
 	var replacements []string
 	for k, v := range i.w.autovars {
 		if k == "NAME" {
@@ -65,12 +77,26 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) error {
 		}
 		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
 	}
-	for k, v := range i.w.Vars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v.Value)
+	if err := i.w.resolveEnvVars(); err != nil {
+		return err
+	}
+	if err := i.w.resolveSecretVars(ctx); err != nil {
+		return err
+	}
+	vr, err := varReplacements(i.w.Vars)
+	if err != nil {
+		return err
+	}
+	replacements = append(replacements, vr...)
+	substitute(reflect.ValueOf(i.w).Elem(), substitutionReplacer(replacements...))
+	if err := substituteExprs(reflect.ValueOf(i.w).Elem(), replacements); err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(i.w).Elem(), strings.NewReplacer(replacements...))
 
-	i.w.populateLogger(ctx)
+	if err := i.w.populateLogger(ctx); err != nil {
+		return err
+	}
+	i.w.logSubstitutions()
 
 	for name, st := range i.w.Steps {
 		st.name = name
@@ -0,0 +1,193 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stepLogs writes the logs of each step to its own object in the logs
+// path of the top level workflow, see Workflow.StepLogs. Writes are
+// buffered and flushed as the GCS log is.
+type stepLogs struct {
+	w        *Workflow
+	ctx      context.Context
+	interval time.Duration
+	size     int
+
+	mx      sync.Mutex
+	writers map[string]*syncedWriter
+	loggers map[string]Logger
+}
+
+func newStepLogs(ctx context.Context, w *Workflow, interval time.Duration, size int) *stepLogs {
+	return &stepLogs{w: w, ctx: ctx, interval: interval, size: size, writers: map[string]*syncedWriter{}, loggers: map[string]Logger{}}
+}
+
+// writer returns the writer of the log of step of w.
+func (sl *stepLogs) writer(w *Workflow, step string) io.Writer {
+	name := w.nestedName(step)
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+	if sw, ok := sl.writers[name]; ok {
+		return sw
+	}
+	gl := &gcsLogger{client: sl.w.StorageClient, bucket: sl.w.bucket, object: path.Join(sl.w.logsPath, "steps", name+".log"), ctx: sl.ctx}
+	sw := &syncedWriter{out: gl, size: sl.size}
+	sw.flushEvery(sl.interval)
+	sl.writers[name] = sw
+	return sw
+}
+
+// logger returns the Logger writing to the log of step of w, in the
+// LogFormat of the workflow.
+func (sl *stepLogs) logger(w *Workflow, step string) Logger {
+	name := w.nestedName(step)
+	sl.mx.Lock()
+	l, ok := sl.loggers[name]
+	sl.mx.Unlock()
+	if ok {
+		return l
+	}
+	out := &redactingWriter{out: sl.writer(w, step), r: &sl.w.redactor}
+	if sl.w.LogFormat == LogFormatJSON {
+		l = &jsonLogger{out: out}
+	} else {
+		l = &textLogger{log.New(out, fmt.Sprintf("[%s]: ", w.qualifiedName()), log.Ldate|log.Ltime)}
+	}
+	sl.mx.Lock()
+	defer sl.mx.Unlock()
+	if prev, ok := sl.loggers[name]; ok {
+		return prev
+	}
+	sl.loggers[name] = l
+	return l
+}
+
+// each calls f with each writer, in the order of their names, returning
+// the first error.
+func (sl *stepLogs) each(f func(*syncedWriter) error) error {
+	sl.mx.Lock()
+	var names []string
+	for name := range sl.writers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ws []*syncedWriter
+	for _, name := range names {
+		ws = append(ws, sl.writers[name])
+	}
+	sl.mx.Unlock()
+	var firstErr error
+	for _, sw := range ws {
+		if err := f(sw); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Flush writes the buffered logs of every step.
+func (sl *stepLogs) Flush() error {
+	return sl.each((*syncedWriter).Flush)
+}
+
+// Close stops the periodic flushes of the step logs and flushes them a
+// final time.
+func (sl *stepLogs) Close() error {
+	return sl.each((*syncedWriter).Close)
+}
+
+// stepLogger is the Logger writing the logs about a step to its log.
+// Other logs are only in the workflow log.
+type stepLogger struct {
+	logs *stepLogs
+}
+
+func (s stepLogger) WorkflowDebug(*Workflow, string) {}
+
+func (s stepLogger) WorkflowInfo(*Workflow, string) {}
+
+func (s stepLogger) WorkflowError(*Workflow, string) {}
+
+func (s stepLogger) StepDebug(w *Workflow, step, stepType, msg string) {
+	s.logs.logger(w, step).StepDebug(w, step, stepType, msg)
+}
+
+func (s stepLogger) StepInfo(w *Workflow, step, stepType, msg string) {
+	s.logs.logger(w, step).StepInfo(w, step, stepType, msg)
+}
+
+func (s stepLogger) StepError(w *Workflow, step, stepType string, err error) {
+	s.logs.logger(w, step).StepError(w, step, stepType, err)
+}
+
+// serialStepLog writes the serial port output of an instance to the log
+// of the step that created it, a line at a time, prefixed by the instance
+// and port.
+type serialStepLog struct {
+	out    io.Writer
+	prefix string
+	// Output after the last newline, written with the next line.
+	partial string
+}
+
+// newSerialStepLog returns a serialStepLog for port of instance name
+// created by s, or nil if the workflow doesn't have StepLogs set.
+func newSerialStepLog(s *Step, name string, port int64) *serialStepLog {
+	if s.w.stepLogs == nil {
+		return nil
+	}
+	return &serialStepLog{
+		out:    &redactingWriter{out: s.w.stepLogs.writer(s.w, s.name), r: &s.w.root().redactor},
+		prefix: fmt.Sprintf("[%s serial port %d]: ", name, port),
+	}
+}
+
+// write writes the complete lines of output, keeping the rest for the next
+// write or flush.
+func (l *serialStepLog) write(output string) {
+	lines := strings.Split(l.partial+output, "\n")
+	l.partial = lines[len(lines)-1]
+	l.writeLines(lines[:len(lines)-1])
+}
+
+// flush writes the output after the last newline.
+func (l *serialStepLog) flush() {
+	if l.partial == "" {
+		return
+	}
+	p := l.partial
+	l.partial = ""
+	l.writeLines([]string{p})
+}
+
+func (l *serialStepLog) writeLines(lines []string) {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(l.prefix + strings.TrimRight(line, "\r") + "\n")
+	}
+	if b.Len() > 0 {
+		io.WriteString(l.out, b.String())
+	}
+}
@@ -0,0 +1,88 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestStepLogs(t *testing.T) {
+	w := testWorkflow()
+	w.logger = nil
+	w.StepLogs = true
+	w.gcsLogging = true
+	w.gcsLogWriter = &syncedWriter{out: ioutil.Discard, size: defaultLogBufferSize}
+	w.bucket = "bucket"
+	w.logsPath = "logs"
+	if err := w.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.closeLogs()
+	sw := w.NewSubWorkflow()
+	sw.Name = "sub"
+	sw.gcsLogWriter = w.gcsLogWriter
+	sw.stepLogs = w.stepLogs
+	if err := sw.populateLogger(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b1, b2 bytes.Buffer
+	w.stepLogs.writers["s"] = &syncedWriter{out: &b1, size: defaultLogBufferSize}
+	w.stepLogs.writers["sub.s"] = &syncedWriter{out: &b2, size: defaultLogBufferSize}
+	s1 := &Step{name: "s", w: w, CreateInstances: &CreateInstances{}}
+	s2 := &Step{name: "s", w: sw, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+
+	w.logger.Print("workflow log")
+	w.logger.StepInfo(s1, "creating instance %q.", "i")
+	w.AddStepHook(func(context.Context, *Step, StepEvent) error { return errors.New("hook failed") })
+	w.runStepHooks(context.Background(), s1, AfterStep)
+	sw.logger.StepError(s2, errors.New("failed"))
+	ssl := newSerialStepLog(s1, "i", 1)
+	ssl.write("boot\r\nlo")
+	ssl.write("gin")
+	ssl.flush()
+	if err := w.FlushLogs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{`[test-wf]: `, `CreateInstances: creating instance "i".`, `CreateInstances: AfterStep hook: hook failed`, "[i serial port 1]: boot\n[i serial port 1]: login\n"} {
+		if !strings.Contains(b1.String(), want) {
+			t.Errorf("log of step s %q doesn't contain %q", b1.String(), want)
+		}
+	}
+	if strings.Contains(b1.String(), "workflow log") {
+		t.Errorf("log of step s %q contains a workflow log", b1.String())
+	}
+	for _, want := range []string{`[test-wf.sub]: `, `Step "s" (WaitForInstancesSignal) failed: failed`} {
+		if !strings.Contains(b2.String(), want) {
+			t.Errorf("log of step sub.s %q doesn't contain %q", b2.String(), want)
+		}
+	}
+
+	// Logs of other steps are written to GCS.
+	w.logger.StepInfo(&Step{name: "t", w: w, CreateInstances: &CreateInstances{}}, "creating instance %q.", "i")
+	if err := w.FlushLogs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testGCSObjsMx.Lock()
+	defer testGCSObjsMx.Unlock()
+	if !strIn("logs/steps/t.log", testGCSObjs) {
+		t.Errorf("logs/steps/t.log not written to GCS, objects: %q", testGCSObjs)
+	}
+}
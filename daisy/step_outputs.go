@@ -0,0 +1,113 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// stepOutputRgx matches references to the outputs of steps,
+// ${OUTPUTS.STEP.KEY}.
+var stepOutputRgx = regexp.MustCompile(`\$\{OUTPUTS\.([^.}]+)\.([^}]+)\}`)
+
+// setOutput publishes the output k of s, for the steps depending on s to
+// refer to as ${OUTPUTS.STEP.k}.
+func (s *Step) setOutput(k, v string) {
+	s.w.stepOutputsMx.Lock()
+	defer s.w.stepOutputsMx.Unlock()
+	if s.w.stepOutputs == nil {
+		s.w.stepOutputs = map[string]map[string]string{}
+	}
+	if s.w.stepOutputs[s.name] == nil {
+		s.w.stepOutputs[s.name] = map[string]string{}
+	}
+	s.w.stepOutputs[s.name][k] = v
+}
+
+// Outputs returns the outputs s published while it ran: the partial URL of
+// each resource it created, and the matched serial output of each instance
+// a WaitForInstancesSignal step waited for, by name.
+func (s *Step) Outputs() map[string]string {
+	outs := map[string]string{}
+	if s.w == nil {
+		return outs
+	}
+	s.w.stepOutputsMx.Lock()
+	defer s.w.stepOutputsMx.Unlock()
+	for k, v := range s.w.stepOutputs[s.name] {
+		outs[k] = v
+	}
+	return outs
+}
+
+// outputStep returns the step name refers to in the output references of
+// s, in s's workflow or the closest parent that has one by that name.
+func (s *Step) outputStep(name string) *Step {
+	for wf := s.w; wf != nil; wf = wf.parent {
+		wf.stepsMx.Lock()
+		st, ok := wf.Steps[name]
+		wf.stepsMx.Unlock()
+		if ok {
+			return st
+		}
+	}
+	return nil
+}
+
+// validateOutputRefs checks that the steps whose outputs s refers to exist
+// and run before s.
+func (s *Step) validateOutputRefs() error {
+	return traverseData(reflect.ValueOf(s).Elem(), func(val reflect.Value) error {
+		str, ok := val.Interface().(string)
+		if !ok {
+			return nil
+		}
+		for _, m := range stepOutputRgx.FindAllStringSubmatch(str, -1) {
+			st := s.outputStep(m[1])
+			if st == nil {
+				return fmt.Errorf("%s refers to step %q, which does not exist", m[0], m[1])
+			}
+			if !s.nestedDepends(st) {
+				return fmt.Errorf("%s refers to step %q, which the step does not depend on", m[0], m[1])
+			}
+		}
+		return nil
+	})
+}
+
+// substituteOutputs replaces the references to the outputs of steps in s
+// with their values, once the steps ran.
+func (s *Step) substituteOutputs() error {
+	return traverseData(reflect.ValueOf(s).Elem(), func(val reflect.Value) error {
+		str, ok := val.Interface().(string)
+		if !ok || !stepOutputRgx.MatchString(str) {
+			return nil
+		}
+		var err error
+		val.SetString(stepOutputRgx.ReplaceAllStringFunc(str, func(m string) string {
+			sm := stepOutputRgx.FindStringSubmatch(m)
+			if st := s.outputStep(sm[1]); st != nil {
+				if v, ok := st.Outputs()[sm[2]]; ok {
+					return v
+				}
+			}
+			err = fmt.Errorf("%s: step %q has no output %q", m, sm[1], sm[2])
+			return m
+		}))
+		return err
+	})
+}
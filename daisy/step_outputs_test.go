@@ -0,0 +1,104 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestStepOutputs(t *testing.T) {
+	w := testWorkflow()
+	create, _ := w.NewStep("create")
+	w.NewStep("other")
+	use, _ := w.NewStep("use")
+	use.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: "${OUTPUTS.create.disk}"}}
+	w.AddDependency("use", "create")
+
+	// The registry publishes the links of created resources.
+	if err := disks[w].registerCreation("disk", &resource{link: "projects/p/zones/z/disks/disk-abcdef"}, create); err != nil {
+		t.Fatal(err)
+	}
+	disks[w].markCreated("disk")
+	if diff := pretty.Compare(create.Outputs(), map[string]string{"disk": "projects/p/zones/z/disks/disk-abcdef"}); diff != "" {
+		t.Errorf("outputs do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	if err := use.validateOutputRefs(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if err := use.substituteOutputs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := (*use.WaitForInstancesSignal)[0].Name; got != "projects/p/zones/z/disks/disk-abcdef" {
+		t.Errorf("output not substituted, got: %q", got)
+	}
+
+	tests := []struct {
+		desc, ref string
+	}{
+		{"unknown step case", "${OUTPUTS.nope.disk}"},
+		{"not a dependency case", "${OUTPUTS.other.disk}"},
+	}
+	for _, tt := range tests {
+		use.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: tt.ref}}
+		if err := use.validateOutputRefs(); err == nil {
+			t.Errorf("%s: should have returned a validation error", tt.desc)
+		}
+	}
+
+	// Outputs missing at run time.
+	use.WaitForInstancesSignal = &WaitForInstancesSignal{{Name: "${OUTPUTS.create.image}"}}
+	if err := use.substituteOutputs(); err == nil {
+		t.Error("should have returned an error for a missing output")
+	}
+}
+
+func TestStepOutputsRun(t *testing.T) {
+	w := testWorkflow()
+	c, _ := newTestGCEClient()
+	descs := map[string]string{}
+	c.CreateDiskFn = func(_, _ string, d *compute.Disk) error {
+		descs[d.Name] = d.Description
+		return nil
+	}
+	w.ComputeClient = c
+	w.NewCreateDisksStep("create", &CreateDisk{Disk: compute.Disk{Name: "d1"}, SizeGb: "10"})
+	w.NewCreateDisksStep("use", &CreateDisk{Disk: compute.Disk{Name: "d2", Description: "copy of ${OUTPUTS.create.d1}"}, SizeGb: "10"}).DependsOn("create")
+
+	// References to outputs pass validation and are substituted when the
+	// step runs.
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := fmt.Sprintf("copy of projects/%s/zones/%s/disks/%s", testProject, testZone, w.genName("d1"))
+	if got := descs[w.genName("d2")]; got != want {
+		t.Errorf("unexpected description of disk d2, got: %q, want: %q", got, want)
+	}
+}
+
+func TestMatchedLine(t *testing.T) {
+	s := "boot\nBuildSuccess: image=foo \nshutdown"
+	if got, want := matchedLine(s, 5), "BuildSuccess: image=foo"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := matchedLine("a Success", 2), "a Success"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// PruneImages is a Daisy PruneImages workflow step.
+type PruneImages []*PruneImage
+
+// PruneImage deletes the oldest images of an image family, or with a name
+// prefix, keeping the newest Keep images.
+type PruneImage struct {
+	// Project to prune images in, defaults to the workflow Project.
+	Project string `json:",omitempty"`
+	// Family of the images to prune.
+	Family string `json:",omitempty"`
+	// Prefix of the names of the images to prune. If Family is also set,
+	// only images in Family with the prefix are pruned.
+	Prefix string `json:",omitempty"`
+	// Keep is the number of images to keep, at least 1.
+	Keep int
+	// DryRun logs the images that would be deleted without deleting them.
+	DryRun bool `json:",omitempty"`
+}
+
+func (p *PruneImages) populate(ctx context.Context, s *Step) error {
+	for _, pi := range *p {
+		pi.Project = strOr(pi.Project, s.project())
+	}
+	return nil
+}
+
+func (p *PruneImages) validate(ctx context.Context, s *Step) error {
+	for _, pi := range *p {
+		if pi.Family == "" && pi.Prefix == "" {
+			return fmt.Errorf("cannot prune images: one of Family or Prefix must be given")
+		}
+		if pi.Keep < 1 {
+			return fmt.Errorf("cannot prune images: Keep must be at least 1, got: %d", pi.Keep)
+		}
+		if err := s.w.validateProject(pi.Project); err != nil {
+			return fmt.Errorf("cannot prune images: bad project: %q, error: %v", pi.Project, err)
+		}
+	}
+	return nil
+}
+
+// imagesInUse returns the names of the images in project that the workflow
+// of w uses: those in the image resource maps of the root workflow and its
+// nested workflows, and those that their steps which didn't finish yet
+// refer to.
+func (w *Workflow) imagesInUse(project string) map[string]bool {
+	names := map[string]bool{}
+	add := func(str, defaultProject string) {
+		if !imageURLRgx.MatchString(str) {
+			return
+		}
+		m := namedSubexp(imageURLRgx, str)
+		if m["image"] != "" && strOr(m["project"], defaultProject) == project {
+			names[m["image"]] = true
+		}
+	}
+	var walk func(wf *Workflow)
+	walk = func(wf *Workflow) {
+		im := images[wf]
+		im.mx.Lock()
+		for _, r := range im.m {
+			if !r.deleted {
+				add(r.link, wf.Project)
+			}
+		}
+		im.mx.Unlock()
+		for _, s := range wf.Steps {
+			if st := s.State(); st != StepWaiting && st != StepRunning {
+				continue
+			}
+			traverseStepData(s, func(v reflect.Value) error {
+				if str, ok := v.Interface().(string); ok {
+					add(str, s.project())
+				}
+				return nil
+			})
+		}
+		for _, cw := range wf.childWorkflows() {
+			walk(cw)
+		}
+	}
+	walk(w.root())
+	return names
+}
+
+// prune returns the images of pi that are to be deleted, oldest first.
+// Images the workflow uses are never deleted.
+func (pi *PruneImage) prune(w *Workflow) ([]*compute.Image, error) {
+	var filter string
+	if pi.Family != "" {
+		filter = fmt.Sprintf("family = %q", pi.Family)
+	}
+	is, err := w.ComputeClient.ListImages(pi.Project, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := w.imagesInUse(pi.Project)
+	type created struct {
+		i *compute.Image
+		t time.Time
+	}
+	var cs []created
+	for _, i := range is {
+		if !strings.HasPrefix(i.Name, pi.Prefix) || (pi.Family != "" && i.Family != pi.Family) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, i.CreationTimestamp)
+		if err != nil {
+			return nil, fmt.Errorf("bad creation timestamp %q of image %q: %v", i.CreationTimestamp, i.Name, err)
+		}
+		cs = append(cs, created{i, t})
+	}
+	if len(cs) <= pi.Keep {
+		return nil, nil
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if !cs[i].t.Equal(cs[j].t) {
+			return cs[i].t.Before(cs[j].t)
+		}
+		return cs[i].i.Name < cs[j].i.Name
+	})
+
+	var result []*compute.Image
+	for _, c := range cs[:len(cs)-pi.Keep] {
+		if inUse[c.i.Name] {
+			continue
+		}
+		result = append(result, c.i)
+	}
+	return result, nil
+}
+
+func (p *PruneImages) run(ctx context.Context, s *Step) error {
+	w := s.w
+	var wg sync.WaitGroup
+	e := make(chan error)
+	for _, pi := range *p {
+		wg.Add(1)
+		go func(pi *PruneImage) {
+			defer wg.Done()
+			what := "family " + pi.Family
+			if pi.Family == "" {
+				what = "prefix " + pi.Prefix
+			}
+			is, err := pi.prune(w)
+			if err != nil {
+				e <- fmt.Errorf("PruneImages: error listing images of %s in project %q: %v", what, pi.Project, err)
+				return
+			}
+			if len(is) == 0 {
+				w.logger.StepInfo(s, "no images of %s in project %q to delete, keeping %d.", what, pi.Project, pi.Keep)
+				return
+			}
+			for _, i := range is {
+				if pi.DryRun {
+					w.logger.StepInfo(s, "dry run, not deleting image %q of %s in project %q.", i.Name, what, pi.Project)
+					continue
+				}
+				w.logger.StepInfo(s, "deleting image %q of %s in project %q.", i.Name, what, pi.Project)
+				if err := w.ComputeClient.DeleteImage(pi.Project, i.Name); err != nil {
+					e <- fmt.Errorf("PruneImages: error deleting image %q: %v", i.Name, err)
+					return
+				}
+			}
+		}(pi)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		return nil
+	}
+}
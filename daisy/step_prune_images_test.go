@@ -0,0 +1,165 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestPruneImagesPopulate(t *testing.T) {
+	w := testWorkflow()
+	p := &PruneImages{{Family: "f", Keep: 1}, {Project: "other", Prefix: "i-", Keep: 1}}
+	if err := p.populate(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &PruneImages{{Project: testProject, Family: "f", Keep: 1}, {Project: "other", Prefix: "i-", Keep: 1}}
+	if diff := pretty.Compare(p, want); diff != "" {
+		t.Errorf("populated PruneImages do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestPruneImagesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	tests := []struct {
+		desc      string
+		pi        *PruneImage
+		shouldErr bool
+	}{
+		{"family case", &PruneImage{Project: testProject, Family: "f", Keep: 3}, false},
+		{"prefix case", &PruneImage{Project: testProject, Prefix: "i-", Keep: 1}, false},
+		{"no family or prefix case", &PruneImage{Project: testProject, Keep: 3}, true},
+		{"no keep case", &PruneImage{Project: testProject, Family: "f"}, true},
+		{"bad project case", &PruneImage{Project: "bad-project", Family: "f", Keep: 3}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.PruneImages = &PruneImages{tt.pi}
+		if err := s.PruneImages.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestPruneImagesRun(t *testing.T) {
+	ctx := context.Background()
+	existing := []*compute.Image{
+		{Name: "i-3", Family: "f", CreationTimestamp: "2017-10-03T00:00:00.000-07:00"},
+		{Name: "i-1", Family: "f", CreationTimestamp: "2017-10-01T00:00:00.000-07:00"},
+		{Name: "i-4", Family: "f", CreationTimestamp: "2017-10-04T00:00:00.000-07:00"},
+		{Name: "i-2", Family: "f", CreationTimestamp: "2017-10-02T00:00:00.000-07:00"},
+		{Name: "j-1", Family: "f", CreationTimestamp: "2017-09-01T00:00:00.000-07:00"},
+		{Name: "i-0", Family: "g", CreationTimestamp: "2017-09-01T00:00:00.000-07:00"},
+	}
+
+	tests := []struct {
+		desc       string
+		pi         *PruneImage
+		listErr    error
+		wantFilter string
+		want       []string
+		shouldErr  bool
+	}{
+		{"family case", &PruneImage{Project: testProject, Family: "f", Keep: 2}, nil, `family = "f"`, []string{"j-1", "i-1", "i-2"}, false},
+		{"prefix case", &PruneImage{Project: testProject, Prefix: "i-", Keep: 2}, nil, "", []string{"i-0", "i-1", "i-2"}, false},
+		{"family and prefix case", &PruneImage{Project: testProject, Family: "f", Prefix: "i-", Keep: 3}, nil, `family = "f"`, []string{"i-1"}, false},
+		{"keep all case", &PruneImage{Project: testProject, Family: "f", Keep: 10}, nil, `family = "f"`, nil, false},
+		{"dry run case", &PruneImage{Project: testProject, Family: "f", Keep: 1, DryRun: true}, nil, `family = "f"`, nil, false},
+		{"list error case", &PruneImage{Project: testProject, Family: "f", Keep: 1}, errors.New("error"), `family = "f"`, nil, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		var mx sync.Mutex
+		var got []string
+		w.ComputeClient = &daisyCompute.TestClient{
+			ListImagesFn: func(project, filter string) ([]*compute.Image, error) {
+				if project != testProject || filter != tt.wantFilter {
+					t.Errorf("%s: unexpected ListImages call, project: %q, filter: %q", tt.desc, project, filter)
+				}
+				if tt.listErr != nil {
+					return nil, tt.listErr
+				}
+				var is []*compute.Image
+				for _, i := range existing {
+					if filter == "" || i.Family == "f" {
+						is = append(is, i)
+					}
+				}
+				return is, nil
+			},
+			DeleteImageFn: func(_, name string) error {
+				mx.Lock()
+				defer mx.Unlock()
+				got = append(got, name)
+				return nil
+			},
+		}
+		s := &Step{w: w, PruneImages: &PruneImages{tt.pi}}
+
+		if err := s.PruneImages.run(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: deleted images do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestPruneImagePruneInUse(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient = &daisyCompute.TestClient{
+		ListImagesFn: func(_, _ string) ([]*compute.Image, error) {
+			return []*compute.Image{
+				{Name: "i-1", Family: "f", CreationTimestamp: "2017-10-01T00:00:00.000-07:00"},
+				{Name: "i-2", Family: "f", CreationTimestamp: "2017-10-02T00:00:00.000-07:00"},
+				{Name: "i-3", Family: "f", CreationTimestamp: "2017-10-03T00:00:00.000-07:00"},
+				{Name: "i-4", Family: "f", CreationTimestamp: "2017-10-04T00:00:00.000-07:00"},
+				{Name: "i-5", Family: "f", CreationTimestamp: "2017-10-05T00:00:00.000-07:00"},
+			}, nil
+		},
+	}
+	images[w].m = map[string]*resource{"built": {link: "projects/" + testProject + "/global/images/i-1", created: true}}
+	pending, _ := w.NewStep("pending")
+	pending.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", SourceImage: "projects/" + testProject + "/global/images/i-2"}}}
+	done, _ := w.NewStep("done")
+	done.CreateDisks = &CreateDisks{{Disk: compute.Disk{Name: "d", SourceImage: "projects/" + testProject + "/global/images/i-3"}}}
+	done.setState(StepFinished)
+
+	is, err := (&PruneImage{Project: testProject, Family: "f", Keep: 2}).prune(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, i := range is {
+		got = append(got, i.Name)
+	}
+	if diff := pretty.Compare(got, []string{"i-3"}); diff != "" {
+		t.Errorf("pruned images do not match expectation: (-got +want)\n%s", diff)
+	}
+}
@@ -0,0 +1,161 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var deprecationStates = []string{"DEPRECATED", "OBSOLETE", "DELETED"}
+
+// PublishImages is a Daisy PublishImages workflow step.
+type PublishImages []*PublishImage
+
+// PublishImage publishes a GCE image to an image family: it creates the
+// image, deprecates the image previously at the head of the family, and
+// writes release notes to GCS. Published images are never cleaned up.
+type PublishImage struct {
+	CreateImage
+
+	// DeprecationState to set on the previous image in Family, one of
+	// "DEPRECATED" (default), "OBSOLETE" or "DELETED".
+	DeprecationState string `json:",omitempty"`
+	// ReleaseNotes to write to ReleaseNotesPath.
+	ReleaseNotes string `json:",omitempty"`
+	// GCS path to write ReleaseNotes to, defaults to
+	// "${OUTSPATH}/<image name>-release-notes.txt".
+	ReleaseNotesPath string `json:",omitempty"`
+}
+
+// MarshalJSON is a hacky workaround to prevent PublishImage from using
+// compute.Image's implementation.
+func (p *PublishImage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*p)
+}
+
+func (p *PublishImages) createImages() *CreateImages {
+	var c CreateImages
+	for _, pi := range *p {
+		c = append(c, &pi.CreateImage)
+	}
+	return &c
+}
+
+func (p *PublishImages) populate(ctx context.Context, s *Step) error {
+	for _, pi := range *p {
+		pi.NoCleanup = true
+		pi.DeprecationState = strOr(pi.DeprecationState, "DEPRECATED")
+		if pi.ReleaseNotes != "" && pi.ReleaseNotesPath == "" {
+			pi.ReleaseNotesPath = fmt.Sprintf("gs://%s/%s-release-notes.txt", path.Join(s.w.bucket, s.w.outsPath), pi.Name)
+		}
+	}
+	return p.createImages().populate(ctx, s)
+}
+
+func (p *PublishImages) validate(ctx context.Context, s *Step) error {
+	for _, pi := range *p {
+		if pi.Family == "" {
+			return fmt.Errorf("cannot publish image %q: no Family given", pi.Name)
+		}
+		if !strIn(pi.DeprecationState, deprecationStates) {
+			return fmt.Errorf("cannot publish image %q: bad DeprecationState: %q, must be one of %q", pi.Name, pi.DeprecationState, deprecationStates)
+		}
+		if pi.ReleaseNotesPath != "" {
+			if pi.ReleaseNotes == "" {
+				return fmt.Errorf("cannot publish image %q: ReleaseNotesPath set without ReleaseNotes", pi.Name)
+			}
+			if _, _, err := splitGCSPath(pi.ReleaseNotesPath); err != nil {
+				return fmt.Errorf("cannot publish image %q: bad ReleaseNotesPath: %v", pi.Name, err)
+			}
+		}
+	}
+	return p.createImages().validate(ctx, s)
+}
+
+func (p *PublishImages) run(ctx context.Context, s *Step) error {
+	w := s.w
+
+	// Look up the images at the head of the families before the new
+	// images replace them.
+	prev := make([]*compute.Image, len(*p))
+	for i, pi := range *p {
+		img, err := w.ComputeClient.GetImageFromFamily(pi.Project, pi.Family)
+		if err != nil {
+			if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
+				return fmt.Errorf("PublishImages: error getting image family %q: %v", pi.Family, err)
+			}
+			continue
+		}
+		prev[i] = img
+	}
+
+	if err := p.createImages().run(ctx, s); err != nil {
+		return err
+	}
+	select {
+	case <-w.cancelChan():
+		return nil
+	default:
+	}
+
+	var wg sync.WaitGroup
+	e := make(chan error, len(*p))
+	for i, pi := range *p {
+		wg.Add(1)
+		go func(pi *PublishImage, prev *compute.Image) {
+			defer wg.Done()
+			if prev != nil && prev.Name != pi.Name {
+				w.logger.StepInfo(s, "deprecating image %q in family %q.", prev.Name, pi.Family)
+				ds := &compute.DeprecationStatus{
+					State:       pi.DeprecationState,
+					Replacement: fmt.Sprintf("projects/%s/global/images/%s", pi.Project, pi.Name),
+				}
+				if err := w.ComputeClient.DeprecateImage(pi.Project, prev.Name, ds); err != nil {
+					e <- err
+					return
+				}
+			}
+			if pi.ReleaseNotes != "" {
+				w.logger.StepInfo(s, "writing release notes for image %q to %q.", pi.Name, pi.ReleaseNotesPath)
+				if err := w.writeGCSObject(ctx, pi.ReleaseNotesPath, pi.ReleaseNotes); err != nil {
+					e <- fmt.Errorf("PublishImages: error writing release notes: %v", err)
+				}
+			}
+		}(pi, prev[i])
+	}
+	wg.Wait()
+	close(e)
+	return <-e
+}
+
+// writeGCSObject writes content to the GCS object at p.
+func (w *Workflow) writeGCSObject(ctx context.Context, p, content string) error {
+	bkt, obj, err := splitGCSPath(p)
+	if err != nil {
+		return err
+	}
+	wc := w.StorageClient.Bucket(bkt).Object(obj).NewWriter(ctx)
+	if _, err := wc.Write([]byte(content)); err != nil {
+		return err
+	}
+	return wc.Close()
+}
@@ -0,0 +1,150 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestPublishImagesPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	p := &PublishImages{
+		{CreateImage: CreateImage{Image: compute.Image{Name: "i1", Family: "f", SourceDisk: "d"}}, ReleaseNotes: "notes"},
+		{CreateImage: CreateImage{Image: compute.Image{Name: "i2", Family: "f", SourceDisk: "d"}}, DeprecationState: "OBSOLETE", ReleaseNotesPath: "gs://bkt/notes"},
+	}
+	if err := p.populate(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	type fields struct {
+		Name, DeprecationState, ReleaseNotesPath string
+		NoCleanup                                bool
+	}
+	var got []fields
+	for _, pi := range *p {
+		got = append(got, fields{pi.Name, pi.DeprecationState, pi.ReleaseNotesPath, pi.NoCleanup})
+	}
+	want := []fields{
+		{w.genName("i1"), "DEPRECATED", fmt.Sprintf("gs://%s/i1-release-notes.txt", path.Join(w.bucket, w.outsPath)), true},
+		{w.genName("i2"), "OBSOLETE", "gs://bkt/notes", true},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("populated PublishImages do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestPublishImagesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	tests := []struct {
+		desc      string
+		pi        *PublishImage
+		shouldErr bool
+	}{
+		{"normal case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i1", Family: "f", RawDisk: &compute.ImageRawDisk{Source: "gs://bkt/i.tar.gz"}}}, DeprecationState: "DEPRECATED", ReleaseNotes: "notes", ReleaseNotesPath: "gs://bkt/notes"}, false},
+		{"no family case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i2", RawDisk: &compute.ImageRawDisk{Source: "gs://bkt/i.tar.gz"}}}, DeprecationState: "DEPRECATED"}, true},
+		{"bad deprecation state case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i3", Family: "f", RawDisk: &compute.ImageRawDisk{Source: "gs://bkt/i.tar.gz"}}}, DeprecationState: "ACTIVE"}, true},
+		{"bad release notes path case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i4", Family: "f", RawDisk: &compute.ImageRawDisk{Source: "gs://bkt/i.tar.gz"}}}, DeprecationState: "DEPRECATED", ReleaseNotes: "notes", ReleaseNotesPath: "notes"}, true},
+		{"release notes path without notes case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i5", Family: "f", RawDisk: &compute.ImageRawDisk{Source: "gs://bkt/i.tar.gz"}}}, DeprecationState: "DEPRECATED", ReleaseNotesPath: "gs://bkt/notes"}, true},
+		{"bad image case", &PublishImage{CreateImage: CreateImage{Project: testProject, Image: compute.Image{Name: "i6", Family: "f"}}, DeprecationState: "DEPRECATED"}, true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		s.PublishImages = &PublishImages{tt.pi}
+		if err := s.PublishImages.validate(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestPublishImagesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	tests := []struct {
+		desc          string
+		prev          *compute.Image
+		familyErr     error
+		deprecateErr  error
+		wantDeprecate []string
+		shouldErr     bool
+	}{
+		{"normal case", &compute.Image{Name: "prev"}, nil, nil, []string{"prev", "DEPRECATED", "projects/p/global/images/i-real"}, false},
+		{"empty family case", nil, &googleapi.Error{Code: 404}, nil, nil, false},
+		{"family error case", nil, errors.New("error"), nil, nil, true},
+		{"deprecate error case", &compute.Image{Name: "prev"}, nil, errors.New("error"), []string{"prev", "DEPRECATED", "projects/p/global/images/i-real"}, true},
+	}
+
+	for _, tt := range tests {
+		images[w].m = map[string]*resource{"i": {real: "i-real", link: "projects/p/global/images/i-real"}}
+		var gotDeprecate []string
+		w.ComputeClient = &daisyCompute.TestClient{
+			GetImageFromFamilyFn: func(_, _ string) (*compute.Image, error) { return tt.prev, tt.familyErr },
+			CreateImageFn:        func(_ string, _ *compute.Image) error { return nil },
+			DeprecateImageFn: func(_, name string, ds *compute.DeprecationStatus) error {
+				gotDeprecate = []string{name, ds.State, ds.Replacement}
+				return tt.deprecateErr
+			},
+		}
+		p := &PublishImages{{
+			CreateImage:      CreateImage{Project: "p", Image: compute.Image{Name: "i-real", Family: "f", SourceDisk: "d"}, daisyName: "i"},
+			DeprecationState: "DEPRECATED",
+		}}
+		if err := p.run(ctx, s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(gotDeprecate, tt.wantDeprecate); diff != "" {
+			t.Errorf("%s: image not deprecated as expected: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+
+	// Release notes.
+	testGCSObjs = nil
+	w.ComputeClient = &daisyCompute.TestClient{
+		GetImageFromFamilyFn: func(_, _ string) (*compute.Image, error) { return nil, &googleapi.Error{Code: 404} },
+		CreateImageFn:        func(_ string, _ *compute.Image) error { return nil },
+	}
+	p := &PublishImages{{
+		CreateImage:      CreateImage{Project: "p", Image: compute.Image{Name: "i-real", Family: "f", SourceDisk: "d"}, daisyName: "i"},
+		DeprecationState: "DEPRECATED",
+		ReleaseNotes:     "notes",
+		ReleaseNotesPath: "gs://bkt/i-release-notes.txt",
+	}}
+	if err := p.run(ctx, s); err != nil {
+		t.Errorf("unexpected error writing release notes: %v", err)
+	}
+	if diff := pretty.Compare(testGCSObjs, []string{"i-release-notes.txt"}); diff != "" {
+		t.Errorf("release notes not written as expected: (-got +want)\n%s", diff)
+	}
+}
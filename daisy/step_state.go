@@ -0,0 +1,116 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// StepState is the state of a step in a workflow run.
+type StepState int32
+
+// The states of a step. Steps start out StepWaiting and end in one of the
+// other states, except StepRunning.
+const (
+	// StepWaiting steps wait for their dependencies, or to be scheduled.
+	StepWaiting StepState = iota
+	// StepRunning steps are running.
+	StepRunning
+	// StepFinished steps finished successfully.
+	StepFinished
+	// StepFailed steps returned an error.
+	StepFailed
+	// StepCanceled steps were running when the workflow was canceled.
+	StepCanceled
+	// StepSkipped steps never ran, as the workflow was canceled or another
+	// step failed first.
+	StepSkipped
+)
+
+var stepStateNames = []string{"waiting", "running", "finished", "failed", "canceled", "skipped"}
+
+// MarshalText encodes st as its name, e.g. "finished".
+func (st StepState) MarshalText() ([]byte, error) {
+	return []byte(st.String()), nil
+}
+
+func (st StepState) String() string {
+	if st < 0 || int(st) >= len(stepStateNames) {
+		return fmt.Sprintf("StepState(%d)", int32(st))
+	}
+	return stepStateNames[st]
+}
+
+// State returns the state of s. It is safe to call while the workflow runs.
+func (s *Step) State() StepState {
+	return StepState(atomic.LoadInt32(&s.state))
+}
+
+func (s *Step) setState(st StepState) {
+	atomic.StoreInt32(&s.state, int32(st))
+	s.w.reportProgress(s, st, nil)
+}
+
+// fail moves s to StepFailed with err.
+func (s *Step) fail(err error) {
+	atomic.StoreInt32(&s.state, int32(StepFailed))
+	s.w.reportProgress(s, StepFailed, err)
+}
+
+// start moves s from StepWaiting to StepRunning. It returns false if s was
+// skipped in the meantime.
+func (s *Step) start() bool {
+	if !atomic.CompareAndSwapInt32(&s.state, int32(StepWaiting), int32(StepRunning)) {
+		return false
+	}
+	s.w.reportProgress(s, StepRunning, nil)
+	return true
+}
+
+// skipWaiting marks the steps of w that never started as skipped.
+func (w *Workflow) skipWaiting() {
+	for _, s := range w.Steps {
+		if atomic.CompareAndSwapInt32(&s.state, int32(StepWaiting), int32(StepSkipped)) {
+			w.reportProgress(s, StepSkipped, nil)
+		}
+	}
+}
+
+// StepStates returns the state of each step of w and its nested workflows.
+// Steps of IncludeWorkflow, ForEach and SubWorkflow steps are prefixed with
+// the name of the step that runs them, e.g. "sub.step", as in
+// RunResult.CompletedSteps. Steps of nested workflows that never ran stay
+// StepWaiting. It is safe to call while the workflow runs.
+func (w *Workflow) StepStates() map[string]StepState {
+	states := map[string]StepState{}
+	w.stepStates("", states)
+	return states
+}
+
+func (w *Workflow) stepStates(prefix string, states map[string]StepState) {
+	for name, s := range w.Steps {
+		states[prefix+name] = s.State()
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.w != nil {
+			s.IncludeWorkflow.w.stepStates(prefix+name+".", states)
+		}
+		if s.ForEach != nil && s.ForEach.w != nil {
+			s.ForEach.w.stepStates(prefix+name+".", states)
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.w != nil {
+			s.SubWorkflow.w.stepStates(prefix+name+".", states)
+		}
+	}
+}
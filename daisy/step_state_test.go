@@ -0,0 +1,123 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestStepStates(t *testing.T) {
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	iw.parent = w
+	iw.logger = w.logger
+	iw.Steps = map[string]*Step{"inner": {name: "inner", w: iw, timeout: time.Minute, testType: &mockStep{}}}
+
+	var running StepState
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			running = s.State()
+			return nil
+		}}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"s2":      {name: "s2", w: w, timeout: time.Minute, testType: &mockStep{}},
+		"include": {name: "include", w: w, timeout: time.Minute, IncludeWorkflow: &IncludeWorkflow{w: iw}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}, "include": {"s1"}}
+
+	want := map[string]StepState{"s0": StepWaiting, "s1": StepWaiting, "s2": StepWaiting, "include": StepWaiting, "include.inner": StepWaiting}
+	if diff := pretty.Compare(w.StepStates(), want); diff != "" {
+		t.Errorf("step states before run do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("expected error running workflow")
+	}
+	if running != StepRunning {
+		t.Errorf("unexpected state of running step, got: %s, want: %s", running, StepRunning)
+	}
+	want = map[string]StepState{"s0": StepFinished, "s1": StepFailed, "s2": StepSkipped, "include": StepSkipped, "include.inner": StepWaiting}
+	if diff := pretty.Compare(w.StepStates(), want); diff != "" {
+		t.Errorf("step states after run do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestStepStateCanceled(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			s.w.CancelWithReason("canceled")
+			return nil
+		}}},
+		"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}}
+
+	if err := w.run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]StepState{"s0": StepCanceled, "s1": StepSkipped}
+	if diff := pretty.Compare(w.StepStates(), want); diff != "" {
+		t.Errorf("step states do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestStepStateSiblingFailure(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"fail": {name: "fail", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"wait": {name: "wait", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			<-s.w.Cancel
+			return nil
+		}}},
+		"after": {name: "after", w: w, timeout: time.Minute, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"after": {"wait"}}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("expected error running workflow")
+	}
+	// The sibling started with the failing step, so it was canceled rather
+	// than skipped.
+	want := map[string]StepState{"fail": StepFailed, "wait": StepCanceled, "after": StepSkipped}
+	if diff := pretty.Compare(w.StepStates(), want); diff != "" {
+		t.Errorf("step states after run do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestStepStateString(t *testing.T) {
+	tests := []struct {
+		st   StepState
+		want string
+	}{
+		{StepWaiting, "waiting"},
+		{StepSkipped, "skipped"},
+		{StepState(42), "StepState(42)"},
+	}
+	for _, tt := range tests {
+		if got := tt.st.String(); got != tt.want {
+			t.Errorf("unexpected string, got: %q, want: %q", got, tt.want)
+		}
+	}
+}
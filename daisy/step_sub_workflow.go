@@ -23,27 +23,49 @@ import (
 type SubWorkflow struct {
 	Path string
 	Vars map[string]string `json:",omitempty"`
-	w    *Workflow
+	// Sandbox, if set, runs the subworkflow in a sandbox project.
+	Sandbox *Sandbox `json:",omitempty"`
+	w       *Workflow
 }
 
 func (s *SubWorkflow) populate(ctx context.Context, st *Step) error {
 	s.w.parent = st.w
 	s.w.GCSPath = fmt.Sprintf("gs://%s/%s", s.w.parent.bucket, s.w.parent.scratchPath)
 	s.w.Name = st.name
-	s.w.Project = s.w.parent.Project
-	s.w.Zone = s.w.parent.Zone
+	s.w.Project = st.project()
+	if s.Sandbox != nil {
+		if err := s.Sandbox.populate(st, s.w); err != nil {
+			return fmt.Errorf("error populating sandbox for subworkflow %q: %v", st.name, err)
+		}
+	}
+	s.w.Zone = st.zone()
 	s.w.OAuthPath = s.w.parent.OAuthPath
+	s.w.OSLogin = s.w.OSLogin || s.w.parent.OSLogin
 	s.w.ComputeClient = s.w.parent.ComputeClient
 	s.w.StorageClient = s.w.parent.StorageClient
+	s.w.ErrorReporting = s.w.ErrorReporting || s.w.parent.ErrorReporting
+	s.w.errorReportingClient = s.w.parent.errorReportingClient
+	s.w.ClearDeletionProtection = s.w.ClearDeletionProtection || s.w.parent.ClearDeletionProtection
+	s.w.SerialCloudLogging = s.w.SerialCloudLogging || s.w.parent.SerialCloudLogging
+	s.w.loggingClient = s.w.parent.loggingClient
+	s.w.secretManagerClient = s.w.parent.secretManagerClient
 	s.w.gcsLogWriter = s.w.parent.gcsLogWriter
+	s.w.cloudLogWriter = s.w.parent.cloudLogWriter
+	s.w.stepLogs = s.w.parent.stepLogs
 	for k, v := range s.Vars {
-		s.w.Vars[k] = vars{Value: v}
+		s.w.AddVar(k, v)
 	}
 	return s.w.populate(ctx)
 }
 
 func (s *SubWorkflow) validate(ctx context.Context, st *Step) error {
-	return s.w.validate(ctx)
+	if err := s.w.validate(ctx); err != nil {
+		return err
+	}
+	if s.Sandbox != nil {
+		return s.Sandbox.validate(st, s.w)
+	}
+	return nil
 }
 
 func (s *SubWorkflow) run(ctx context.Context, st *Step) error {
@@ -57,11 +79,22 @@ func (s *SubWorkflow) run(ctx context.Context, st *Step) error {
 		return nil
 	})
 
-	st.w.logger.Printf("Running subworkflow %q", s.w.Name)
+	st.w.logger.StepInfo(st, "running subworkflow %q.", s.w.Name)
 	if err := s.w.run(ctx); err != nil {
-		s.w.logger.Printf("Error running subworkflow %q: %v", s.w.Name, err)
-		close(st.w.Cancel)
+		s.w.logger.Errorf("Error running subworkflow %q: %v", s.w.Name, err)
+		if !st.ContinueOnError {
+			st.w.CancelWithReason(fmt.Sprintf("subworkflow %q failed", s.w.Name))
+		}
 		return err
 	}
+	select {
+	case <-s.w.cancelChan():
+		return nil
+	default:
+	}
+	if s.Sandbox != nil {
+		// Copy before the deferred cleanup deletes the sandbox images.
+		return s.Sandbox.copyImages(st, s.w)
+	}
 	return nil
 }
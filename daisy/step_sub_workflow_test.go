@@ -58,6 +58,26 @@ func TestSubWorkflowPopulate(t *testing.T) {
 	}
 }
 
+func TestSubWorkflowPopulateSandbox(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.populate(ctx)
+	w.SandboxProjects = []string{"sandbox-project"}
+	sw := &Workflow{parent: w}
+	s := &Step{name: "sw-step", w: w, SubWorkflow: &SubWorkflow{Sandbox: &Sandbox{}, w: sw}}
+	if err := s.SubWorkflow.populate(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if sw.Project != "sandbox-project" {
+		t.Errorf("unexpected subworkflow Project: %q != %q", sw.Project, "sandbox-project")
+	}
+
+	s = &Step{name: "sw-step2", w: w, SubWorkflow: &SubWorkflow{Sandbox: &Sandbox{}, w: &Workflow{parent: w}}}
+	if err := s.SubWorkflow.populate(ctx, s); err == nil {
+		t.Error("expected error with no free sandbox project")
+	}
+}
+
 func TestSubWorkflowRun(t *testing.T) {}
 
 func TestSubWorkflowValidate(t *testing.T) {}
@@ -15,8 +15,13 @@
 package daisy
 
 import (
+	"context"
 	"reflect"
 	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 func TestDepends(t *testing.T) {
@@ -98,6 +103,43 @@ func TestGetChain(t *testing.T) {
 	}
 }
 
+func TestStepWrapError(t *testing.T) {
+	a := &Workflow{}
+	b := &Workflow{parent: a}
+	a1 := &Step{name: "a1", w: a, IncludeWorkflow: &IncludeWorkflow{w: b}}
+	b1 := &Step{name: "b1", w: b}
+	a.Steps = map[string]*Step{"a1": a1}
+	b.Steps = map[string]*Step{"b1": b1}
+
+	apiErr := &googleapi.Error{Code: 404, Message: "not found"}
+	inner := b1.wrapRunError(apiErr)
+	want := &StepError{Step: "b1", Chain: []string{"a1", "b1"}, Phase: PhaseRun, Err: apiErr}
+	if diff := pretty.Compare(inner, want); diff != "" {
+		t.Errorf("inner error does not match expectation: (-got +want)\n%s", diff)
+	}
+	if got := b1.wrapRunError(inner); got != inner {
+		t.Errorf("error wrapped twice for the same step and phase: %v", got)
+	}
+
+	outer := a1.wrapRunError(inner)
+	wantMsg := `step "a1" run error: step "b1" run error: googleapi: Error 404: not found`
+	if outer.Error() != wantMsg {
+		t.Errorf("unexpected error message, got: %q, want: %q", outer, wantMsg)
+	}
+	if got := outer.(*StepError).Cause(); got != apiErr {
+		t.Errorf("Cause() = %v, want %v", got, apiErr)
+	}
+	if got := outer.(*StepError).Unwrap(); got != inner {
+		t.Errorf("Unwrap() = %v, want %v", got, inner)
+	}
+
+	// Orphan steps have a chain of just themselves.
+	orphan := &Step{name: "orphan"}
+	if got := orphan.wrapValidateError(apiErr).(*StepError).Chain; !reflect.DeepEqual(got, []string{"orphan"}) {
+		t.Errorf("unexpected orphan chain: %v", got)
+	}
+}
+
 func TestNestedDepends(t *testing.T) {
 	// root -- a1 (some step)
 	//     |
@@ -221,3 +263,55 @@ func TestStepImpl(t *testing.T) {
 		t.Fatal("malformed step should have thrown an error")
 	}
 }
+
+func TestStepValidateMaxParallelInstances(t *testing.T) {
+	tests := []struct {
+		desc      string
+		s         *Step
+		shouldErr bool
+	}{
+		{"create instances case", &Step{MaxParallelInstances: 2, CreateInstances: &CreateInstances{}}, false},
+		{"no limit case", &Step{testType: &mockStep{}}, false},
+		{"negative case", &Step{MaxParallelInstances: -1, CreateInstances: &CreateInstances{}}, true},
+		{"other step type case", &Step{MaxParallelInstances: 2, testType: &mockStep{}}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		tt.s.name = "step"
+		tt.s.w = w
+		if err := tt.s.validate(context.Background()); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestStepProjectZone(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w, Project: "step-project", Zone: "step-zone"}
+	cds := &CreateDisks{
+		{Disk: compute.Disk{Name: "d1"}},
+		{Disk: compute.Disk{Name: "d2"}, Project: "disk-project", Zone: "disk-zone"},
+	}
+	if err := cds.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		desc, gotProject, gotZone, wantProject, wantZone string
+	}{
+		{"step case", (*cds)[0].Project, (*cds)[0].Zone, "step-project", "step-zone"},
+		{"entry override case", (*cds)[1].Project, (*cds)[1].Zone, "disk-project", "disk-zone"},
+	}
+	for _, tt := range tests {
+		if tt.gotProject != tt.wantProject || tt.gotZone != tt.wantZone {
+			t.Errorf("%s: got project %q and zone %q, want %q and %q", tt.desc, tt.gotProject, tt.gotZone, tt.wantProject, tt.wantZone)
+		}
+	}
+
+	s = &Step{name: "s", w: w}
+	if s.project() != w.Project || s.zone() != w.Zone {
+		t.Errorf("step without Project and Zone: got %q and %q, want the workflow's %q and %q", s.project(), s.zone(), w.Project, w.Zone)
+	}
+}
@@ -0,0 +1,309 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+const (
+	contentHashImage      = "projects/debian-cloud/global/images/family/debian-12"
+	contentHashDeviceName = "content"
+	contentHashScript     = `#!/bin/bash
+if hash=$(sha256sum /dev/disk/by-id/google-` + contentHashDeviceName + ` | cut -d' ' -f1); then
+  echo "DaisyContentHash: ${hash}" > /dev/ttyS0
+else
+  echo "DaisyContentHashFailed" > /dev/ttyS0
+fi
+`
+)
+
+var contentHashRgx = regexp.MustCompile(`DaisyContentHash: ([0-9a-f]{64})`)
+
+// VerifyContentHashes is a Daisy VerifyContentHashes workflow step.
+type VerifyContentHashes []*ContentHash
+
+// ContentHash computes the SHA256 hash of the content of a disk or an image
+// by reading it on a temporary verification instance. The first ContentHash
+// with a given Name records the hash, later ones with the same Name fail if
+// the content does not have the recorded hash anymore.
+type ContentHash struct {
+	// Name the hash is recorded under.
+	Name string
+	// Disk to hash, attached read-only to the verification instance.
+	// Either a workflow disk name or a disk partial URL.
+	Disk string `json:",omitempty"`
+	// Image to hash. Either a workflow image name or an image partial URL.
+	Image string `json:",omitempty"`
+	// SHA256 is the expected hex encoded hash of the content, if known.
+	SHA256 string `json:",omitempty"`
+	// Project to run the verification instance in, overrides workflow
+	// Project. Disks are always verified in their own project.
+	Project string `json:",omitempty"`
+	// Zone to run the verification instance in, overrides workflow Zone.
+	// Zonal disks are always verified in their own zone.
+	Zone string `json:",omitempty"`
+	// Interval to check for the hash (default is 10s).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+	interval time.Duration
+}
+
+type contentHashRecord struct {
+	recorder *Step
+	hash     string
+}
+
+func (v *VerifyContentHashes) populate(ctx context.Context, s *Step) error {
+	for _, ch := range *v {
+		ch.Project = strOr(ch.Project, s.project())
+		ch.Zone = strOr(ch.Zone, s.zone())
+		ch.Disk = normalizeURL(ch.Disk, diskURLRgx, ch.Project, "")
+		ch.Image = normalizeURL(ch.Image, imageURLRgx, ch.Project, "")
+		ch.Interval = strOr(ch.Interval, defaultInterval)
+		var err error
+		if ch.interval, err = time.ParseDuration(ch.Interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *VerifyContentHashes) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	for _, ch := range *v {
+		if ch.Name == "" {
+			errs.add(Errorf("cannot verify content hash: no Name given"))
+			continue
+		}
+		if !xor(ch.Disk == "", ch.Image == "") {
+			errs.add(Errorf("cannot verify content hash %q: must provide either Disk or Image, exclusively", ch.Name))
+			continue
+		}
+		if ch.SHA256 != "" && !sha256Rgx.MatchString(ch.SHA256) {
+			errs.add(Errorf("cannot verify content hash %q: bad SHA256: %q", ch.Name, ch.SHA256))
+		}
+		if err := s.w.validateProject(ch.Project); err != nil {
+			errs.add(Errorf("cannot verify content hash %q: bad project: %q, error: %v", ch.Name, ch.Project, err))
+		}
+		if err := s.w.validateZone(ch.Project, ch.Zone); err != nil {
+			errs.add(Errorf("cannot verify content hash %q: bad zone: %q, error: %v", ch.Name, ch.Zone, err))
+		}
+		if ch.Disk != "" {
+			if _, err := disks[s.w].registerUsage(ch.Disk, s); err != nil {
+				errs.add(Errorf("cannot verify content hash %q: can't use disk %q: %v", ch.Name, ch.Disk, err))
+			}
+		} else if _, err := images[s.w].registerUsage(ch.Image, s); err != nil {
+			errs.add(Errorf("cannot verify content hash %q: can't use image %q: %v", ch.Name, ch.Image, err))
+		}
+		if err := s.w.registerContentHash(ch.Name, s); err != nil {
+			errs.add(err)
+		}
+	}
+	return errs.cast()
+}
+
+// registerContentHash makes s the recorder of the content hash name, or,
+// if name is already recorded, checks that s runs after the recorder.
+// Hashes are recorded in the root workflow, so included and sub workflows
+// share them.
+func (w *Workflow) registerContentHash(name string, s *Step) *Error {
+	w = w.root()
+	w.contentHashesMx.Lock()
+	defer w.contentHashesMx.Unlock()
+	if w.contentHashes == nil {
+		w.contentHashes = map[string]*contentHashRecord{}
+	}
+	r, ok := w.contentHashes[name]
+	if !ok {
+		w.contentHashes[name] = &contentHashRecord{recorder: s}
+		return nil
+	}
+	if r.recorder == s {
+		return Errorf("cannot verify content hash %q: recorded twice by step %q", name, s.name)
+	}
+	if !s.nestedDepends(r.recorder) {
+		return Errorf("verifying content hash %q MUST transitively depend on step %q which records it", name, r.recorder.name)
+	}
+	return nil
+}
+
+func (v *VerifyContentHashes) run(ctx context.Context, s *Step) error {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan error)
+	for _, ch := range *v {
+		wg.Add(1)
+		go func(ch *ContentHash) {
+			defer wg.Done()
+			hash, err := ch.compute(w, s)
+			if err != nil {
+				e <- err
+				return
+			}
+			if hash == "" {
+				// Canceled.
+				return
+			}
+			if err := ch.check(w, s, hash); err != nil {
+				e <- err
+			}
+		}(ch)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.cancelChan():
+		// Wait so verification instances can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
+
+// check compares hash with the expected and recorded hashes of ch, and
+// records it if s is the recorder.
+func (ch *ContentHash) check(w *Workflow, s *Step, hash string) error {
+	if ch.SHA256 != "" && !strings.EqualFold(hash, ch.SHA256) {
+		return fmt.Errorf("VerifyContentHashes: content hash %q mismatch, got: %s, want: %s", ch.Name, hash, strings.ToLower(ch.SHA256))
+	}
+	root := w.root()
+	root.contentHashesMx.Lock()
+	defer root.contentHashesMx.Unlock()
+	r, ok := root.contentHashes[ch.Name]
+	if !ok {
+		return fmt.Errorf("VerifyContentHashes: content hash %q was not registered", ch.Name)
+	}
+	if r.recorder == s {
+		r.hash = hash
+		w.logger.StepInfo(s, "recorded content hash %q: %s.", ch.Name, hash)
+		return nil
+	}
+	if r.hash != hash {
+		return fmt.Errorf("VerifyContentHashes: content hash %q mismatch, got: %s, recorded by step %q: %s", ch.Name, hash, r.recorder.name, r.hash)
+	}
+	w.logger.StepInfo(s, "verified content hash %q.", ch.Name)
+	return nil
+}
+
+// compute hashes the content of ch on a verification instance. It returns
+// an empty hash if the workflow is canceled. The instance is deleted once
+// done, or by cleanup if that fails.
+func (ch *ContentHash) compute(w *Workflow, s *Step) (string, error) {
+	project, zone := ch.Project, ch.Zone
+	content := &compute.AttachedDisk{DeviceName: contentHashDeviceName, AutoDelete: true}
+	if ch.Disk != "" {
+		d, _ := disks[w].get(ch.Disk)
+		m := namedSubexp(diskURLRgx, d.link)
+		project = m["project"]
+		if m["zone"] != "" {
+			zone = m["zone"]
+		}
+		content = &compute.AttachedDisk{DeviceName: contentHashDeviceName, Source: d.link, Mode: diskModeRO}
+	} else {
+		i, _ := images[w].get(ch.Image)
+		content.InitializeParams = &compute.AttachedDiskInitializeParams{SourceImage: i.link}
+	}
+
+	name := w.genName("hash-" + ch.Name)
+	inst := &compute.Instance{
+		Name:        name,
+		MachineType: fmt.Sprintf("projects/%s/zones/%s/machineTypes/n1-standard-1", project, zone),
+		Labels:      w.addWorkflowLabels(nil, false),
+		Disks: []*compute.AttachedDisk{
+			{
+				Boot:             true,
+				AutoDelete:       true,
+				InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: contentHashImage},
+			},
+			content,
+		},
+		Metadata: &compute.Metadata{
+			Items: []*compute.MetadataItems{{Key: "startup-script", Value: strLitPtr(contentHashScript)}},
+		},
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network:       fmt.Sprintf("projects/%s/global/networks/default", project),
+				AccessConfigs: []*compute.AccessConfig{{Type: defaultAccessConfigType}},
+			},
+		},
+	}
+
+	for _, d := range inst.Disks {
+		if d.InitializeParams != nil {
+			d.InitializeParams.Labels = w.addWorkflowLabels(nil, false)
+		}
+	}
+
+	if err := w.checkPolicy(&PlannedResource{Type: "instance", Name: name, Project: project, Zone: zone, Resource: inst}); err != nil {
+		return "", err
+	}
+	w.logger.StepInfo(s, "creating verification instance %q.", name)
+	if err := w.ComputeClient.CreateInstance(project, zone, inst); err != nil {
+		return "", err
+	}
+	link := fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name)
+	if err := instances[w].addCreated(name, &resource{real: name, link: link}, s); err != nil {
+		return "", err
+	}
+	defer func() {
+		w.logger.StepInfo(s, "deleting verification instance %q.", name)
+		if err := instances[w].delete(name); err != nil {
+			w.logger.StepInfo(s, "error deleting verification instance %q: %v", name, err)
+		}
+	}()
+
+	var start int64
+	var buf string
+	tick := time.NewTicker(ch.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.cancelChan():
+			w.logger.StepInfo(s, "stopped waiting for content hash %q, %s.", ch.Name, w.cancelCause())
+			return "", nil
+		case <-tick.C:
+			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, 1, start)
+			if err != nil {
+				return "", fmt.Errorf("VerifyContentHashes: instance %q: error getting serial port: %v", name, err)
+			}
+			start = resp.Next
+			// Keep the end of the previous output in case the hash
+			// line is split between reads.
+			buf = buf + resp.Contents
+			if m := contentHashRgx.FindStringSubmatch(buf); m != nil {
+				return m[1], nil
+			}
+			if strings.Contains(buf, "DaisyContentHashFailed") {
+				return "", fmt.Errorf("VerifyContentHashes: instance %q failed to hash content %q", name, ch.Name)
+			}
+			if len(buf) > 256 {
+				buf = buf[len(buf)-256:]
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestVerifyContentHashesPopulate(t *testing.T) {
+	w := testWorkflow()
+	got := &VerifyContentHashes{
+		{Name: "h1", Disk: "d"},
+		{Name: "h2", Image: "global/images/i", Project: "p", Zone: "z", Interval: "1s"},
+	}
+	if err := got.populate(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("error running populate: %v", err)
+	}
+
+	want := &VerifyContentHashes{
+		{Name: "h1", Disk: "d", Project: w.Project, Zone: w.Zone, Interval: "10s", interval: 10 * time.Second},
+		{Name: "h2", Image: "projects/p/global/images/i", Project: "p", Zone: "z", Interval: "1s", interval: time.Second},
+	}
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("populated VerifyContentHashes does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestVerifyContentHashesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+
+	dCreator := &Step{name: "dCreator", w: w}
+	w.Steps["dCreator"] = dCreator
+	disks[w].m = map[string]*resource{"d": {creator: dCreator, link: "projects/p/zones/z/disks/d-real"}}
+	record, _ := w.NewStep("record")
+	w.AddDependency("record", "dCreator")
+	verify, _ := w.NewStep("verify")
+	w.AddDependency("verify", "record")
+	parallel, _ := w.NewStep("parallel")
+	w.AddDependency("parallel", "dCreator")
+
+	sha := strings.Repeat("a", 64)
+	tests := []struct {
+		desc      string
+		s         *Step
+		ch        *ContentHash
+		shouldErr bool
+	}{
+		{"record case", record, &ContentHash{Name: "h", Disk: "d"}, false},
+		{"record image case", record, &ContentHash{Name: "h2", Image: "projects/p/global/images/i", SHA256: sha}, false},
+		{"verify case", verify, &ContentHash{Name: "h", Disk: "d"}, false},
+		{"no dependency on recorder case", parallel, &ContentHash{Name: "h", Disk: "d"}, true},
+		{"recorded twice case", record, &ContentHash{Name: "h", Disk: "d"}, true},
+		{"no name case", verify, &ContentHash{Disk: "d"}, true},
+		{"disk and image case", verify, &ContentHash{Name: "h", Disk: "d", Image: "projects/p/global/images/i"}, true},
+		{"no disk or image case", verify, &ContentHash{Name: "h"}, true},
+		{"bad SHA256 case", verify, &ContentHash{Name: "h", Disk: "d", SHA256: "abc"}, true},
+		{"disk dne case", verify, &ContentHash{Name: "h", Disk: "dne"}, true},
+	}
+
+	for _, tt := range tests {
+		tt.ch.Project = strOr(tt.ch.Project, testProject)
+		tt.ch.Zone = strOr(tt.ch.Zone, testZone)
+		v := &VerifyContentHashes{tt.ch}
+		if err := v.validate(ctx, tt.s); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestVerifyContentHashesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	record := &Step{name: "record", w: w}
+	verify := &Step{name: "verify", w: w}
+	disks[w].m = map[string]*resource{"d": {link: "projects/p/zones/z/disks/d-real"}}
+	w.contentHashes = map[string]*contentHashRecord{"h": {recorder: record}}
+
+	hash := strings.Repeat("a", 64)
+	var created *compute.Instance
+	var createdIn, deleted []string
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateInstanceFn: func(p, z string, i *compute.Instance) error {
+			created, createdIn = i, []string{p, z}
+			return nil
+		},
+		GetSerialPortOutputFn: func(_, _, _ string, _, start int64) (*compute.SerialPortOutput, error) {
+			// Split the hash line between two reads.
+			if start == 0 {
+				return &compute.SerialPortOutput{Contents: "booting\nDaisyContentHash: " + hash[:10], Next: 1}, nil
+			}
+			return &compute.SerialPortOutput{Contents: hash[10:] + "\n", Next: 2}, nil
+		},
+		DeleteInstanceFn: func(p, z, n string) error {
+			deleted = []string{p, z, n}
+			return nil
+		},
+	}
+
+	v := &VerifyContentHashes{{Name: "h", Disk: "d", Project: testProject, Zone: testZone, interval: time.Microsecond}}
+	if err := v.run(ctx, record); err != nil {
+		t.Fatalf("error recording content hash: %v", err)
+	}
+	if got := w.contentHashes["h"].hash; got != hash {
+		t.Errorf("unexpected recorded hash, got: %q, want: %q", got, hash)
+	}
+	name := w.genName("hash-h")
+	if diff := pretty.Compare(createdIn, []string{"p", "z"}); diff != "" {
+		t.Errorf("verification instance not created in the disk's project and zone: (-got +want)\n%s", diff)
+	}
+	if got := created.Disks[1]; got.Source != "projects/p/zones/z/disks/d-real" || got.Mode != diskModeRO {
+		t.Errorf("disk not attached read-only, got: %+v", got)
+	}
+	if diff := pretty.Compare(deleted, []string{"p", "z", name}); diff != "" {
+		t.Errorf("verification instance not deleted: (-got +want)\n%s", diff)
+	}
+	if r, ok := instances[w].get(name); !ok || !r.created || !r.deleted || r.creator != record {
+		t.Errorf("verification instance not registered as created and deleted by the step: %+v", r)
+	}
+
+	if err := v.run(ctx, verify); err != nil {
+		t.Errorf("error verifying content hash: %v", err)
+	}
+
+	w.contentHashes["h"].hash = strings.Repeat("b", 64)
+	if err := v.run(ctx, verify); err == nil {
+		t.Error("expected error on recorded hash mismatch")
+	}
+
+	w.contentHashes["h"].hash = hash
+	v = &VerifyContentHashes{{Name: "h", Disk: "d", SHA256: strings.Repeat("c", 64), interval: time.Microsecond}}
+	if err := v.run(ctx, verify); err == nil {
+		t.Error("expected error on SHA256 mismatch")
+	}
+
+	images[w].m = map[string]*resource{"i": {link: "projects/p/global/images/i-real"}}
+	v = &VerifyContentHashes{{Name: "h", Image: "i", Project: testProject, Zone: testZone, SHA256: strings.ToUpper(hash), interval: time.Microsecond}}
+	if err := v.run(ctx, verify); err != nil {
+		t.Errorf("error verifying image content hash: %v", err)
+	}
+	want := &compute.AttachedDisk{
+		DeviceName:       contentHashDeviceName,
+		AutoDelete:       true,
+		InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: "projects/p/global/images/i-real", Labels: testLabels},
+	}
+	if diff := pretty.Compare(created.Disks[1], want); diff != "" {
+		t.Errorf("image disk not attached as expected: (-got +want)\n%s", diff)
+	}
+	if diff := pretty.Compare(createdIn, []string{testProject, testZone}); diff != "" {
+		t.Errorf("verification instance not created in the step's project and zone: (-got +want)\n%s", diff)
+	}
+
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Contents: "DaisyContentHashFailed"}, nil
+	}
+	if err := v.run(ctx, verify); err == nil {
+		t.Error("expected error when hashing fails")
+	}
+
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return nil, fmt.Errorf("error")
+	}
+	if err := v.run(ctx, verify); err == nil {
+		t.Error("expected error from GetSerialPortOutput")
+	}
+}
+
+func TestVerifyContentHashesCleanup(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "record", w: w}
+	disks[w].m = map[string]*resource{"d": {link: "projects/p/zones/z/disks/d-real"}}
+	w.contentHashes = map[string]*contentHashRecord{"h": {recorder: s}}
+
+	var deletes int
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateInstanceFn: func(_, _ string, _ *compute.Instance) error { return nil },
+		GetSerialPortOutputFn: func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+			return &compute.SerialPortOutput{Contents: "DaisyContentHash: " + strings.Repeat("a", 64)}, nil
+		},
+		DeleteInstanceFn: func(_, _, _ string) error {
+			deletes++
+			if deletes == 1 {
+				return errors.New("error")
+			}
+			return nil
+		},
+	}
+
+	v := &VerifyContentHashes{{Name: "h", Disk: "d", interval: time.Microsecond}}
+	if err := v.run(context.Background(), s); err != nil {
+		t.Fatalf("error running VerifyContentHashes: %v", err)
+	}
+	// The step failed to delete the instance, cleanup deletes it.
+	instances[w].cleanup()
+	if r, _ := instances[w].get(w.genName("hash-h")); deletes != 2 || !r.deleted {
+		t.Errorf("verification instance not deleted by cleanup, deletes: %d", deletes)
+	}
+}
@@ -0,0 +1,126 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WaitForGCSObject is a Daisy WaitForGCSObject workflow step. It waits for a
+// GCS object to exist, e.g. an artifact dropped by another workflow or an
+// upstream build, up to the step's Timeout.
+type WaitForGCSObject struct {
+	// GCS path of the object to wait for.
+	Path string
+	// Also wait for the content of the object to contain ContentMatch, e.g.
+	// a marker object being updated to "DONE". The object is read whole on
+	// each check, so this is meant for small marker objects.
+	ContentMatch string `json:",omitempty"`
+	// Interval to check for the object (default is 10s).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+	interval time.Duration
+}
+
+func (o *WaitForGCSObject) populate(ctx context.Context, s *Step) error {
+	o.Interval = strOr(o.Interval, defaultInterval)
+	var err error
+	o.interval, err = time.ParseDuration(o.Interval)
+	return err
+}
+
+func (o *WaitForGCSObject) validate(ctx context.Context, s *Step) error {
+	_, obj, err := splitGCSPath(o.Path)
+	if err != nil {
+		return err
+	}
+	if obj == "" || strings.HasSuffix(obj, "/") {
+		return fmt.Errorf("cannot wait for GCS path %q: not an object", o.Path)
+	}
+	if o.interval <= 0 {
+		return fmt.Errorf("cannot wait for GCS object %q: Interval must be positive, got: %q", o.Path, o.Interval)
+	}
+	return nil
+}
+
+func (o *WaitForGCSObject) run(ctx context.Context, s *Step) error {
+	w := s.w
+	bkt, obj, err := splitGCSPath(o.Path)
+	if err != nil {
+		return err
+	}
+	if o.ContentMatch != "" {
+		w.logger.StepInfo(s, "waiting for %s to contain %q.", o.Path, o.ContentMatch)
+	} else {
+		w.logger.StepInfo(s, "waiting for %s to exist.", o.Path)
+	}
+	oh := w.StorageClient.Bucket(bkt).Object(obj)
+	var errs int
+	tick := time.NewTicker(o.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.cancelChan():
+			w.logger.StepInfo(s, "stopped waiting for %s, %s.", o.Path, w.cancelCause())
+			return nil
+		case <-tick.C:
+			found, err := o.check(ctx, oh)
+			if err != nil {
+				// Retry up to 3 times in a row on any error.
+				if errs < 3 {
+					errs++
+					continue
+				}
+				return fmt.Errorf("WaitForGCSObject: error checking %s: %v", o.Path, err)
+			}
+			errs = 0
+			if found {
+				w.logger.StepInfo(s, "found %s.", o.Path)
+				return nil
+			}
+		}
+	}
+}
+
+// check returns whether the object exists, with its content matching
+// ContentMatch if set.
+func (o *WaitForGCSObject) check(ctx context.Context, oh *storage.ObjectHandle) (bool, error) {
+	if o.ContentMatch == "" {
+		_, err := oh.Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	r, err := oh.NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(b), o.ContentMatch), nil
+}
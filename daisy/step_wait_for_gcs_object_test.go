@@ -0,0 +1,123 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestWaitForGCSObjectPopulate(t *testing.T) {
+	o := &WaitForGCSObject{Path: "gs://bucket/object"}
+	if err := o.populate(context.Background(), &Step{w: testWorkflow()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Interval != defaultInterval || o.interval != 10*time.Second {
+		t.Errorf("unexpected interval, got: %q (%s), want: %q", o.Interval, o.interval, defaultInterval)
+	}
+
+	o = &WaitForGCSObject{Path: "gs://bucket/object", Interval: "foo"}
+	if err := o.populate(context.Background(), &Step{w: testWorkflow()}); err == nil {
+		t.Error("expected error for bad interval")
+	}
+}
+
+func TestWaitForGCSObjectValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		o         *WaitForGCSObject
+		shouldErr bool
+	}{
+		{"normal case", &WaitForGCSObject{Path: "gs://bucket/object", interval: time.Second}, false},
+		{"bad path case", &WaitForGCSObject{Path: "bucket/object", interval: time.Second}, true},
+		{"bucket case", &WaitForGCSObject{Path: "gs://bucket", interval: time.Second}, true},
+		{"prefix case", &WaitForGCSObject{Path: "gs://bucket/prefix/", interval: time.Second}, true},
+		{"no interval case", &WaitForGCSObject{Path: "gs://bucket/object"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.o.validate(context.Background(), &Step{w: testWorkflow()}); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+// newTestMarkerServer returns a storage client for a GCS fake that doesn't
+// have the object for the first misses requests, then responds with code
+// and content.
+func newTestMarkerServer(misses, code int, content string) *storage.Client {
+	var mx sync.Mutex
+	var n int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		n++
+		miss := n <= misses
+		mx.Unlock()
+		if miss {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(code)
+		fmt.Fprint(w, content)
+	}))
+	c, _ := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	return c
+}
+
+func TestWaitForGCSObjectRun(t *testing.T) {
+	tests := []struct {
+		desc         string
+		contentMatch string
+		misses, code int
+		content      string
+		shouldErr    bool
+	}{
+		{"exists case", "", 2, http.StatusOK, "{}", false},
+		{"content match case", "DONE", 2, http.StatusOK, "status: DONE", false},
+		{"error case", "", 0, http.StatusInternalServerError, "", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.StorageClient = newTestMarkerServer(tt.misses, tt.code, tt.content)
+		o := &WaitForGCSObject{Path: "gs://bucket/object", ContentMatch: tt.contentMatch, interval: time.Millisecond}
+		if err := o.run(context.Background(), &Step{w: w}); tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestWaitForGCSObjectRunContentMismatch(t *testing.T) {
+	w := testWorkflow()
+	w.StorageClient = newTestMarkerServer(0, http.StatusOK, "status: RUNNING")
+	o := &WaitForGCSObject{Path: "gs://bucket/object", ContentMatch: "DONE", interval: time.Millisecond}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		w.CancelWithReason("test")
+	}()
+	if err := o.run(context.Background(), &Step{w: w}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
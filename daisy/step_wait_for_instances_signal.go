@@ -38,6 +38,20 @@ type SerialOutput struct {
 	FailureMatch string
 }
 
+// Reboots describes guest reboots to wait for, e.g. of Windows patching or
+// sysprep phases. Each match of BootMatch in the serial output after the
+// first, that of the initial boot, is a reboot. The instance status can't
+// tell, it stays RUNNING while the guest reboots.
+type Reboots struct {
+	// Number of reboots to wait for.
+	Count int
+	// Serial port to watch for BootMatch (default is 1).
+	Port int64 `json:",omitempty"`
+	// String written to the serial port on each boot, e.g. "Booting from
+	// Hard Disk".
+	BootMatch string
+}
+
 // InstanceSignal waits for a signal from an instance.
 type InstanceSignal struct {
 	// Instance name to wait for.
@@ -50,51 +64,59 @@ type InstanceSignal struct {
 	Stopped bool
 	// Wait for a string match in the serial output.
 	SerialOutput *SerialOutput
+	// Wait for the guest to reboot a number of times.
+	Reboots *Reboots `json:",omitempty"`
 }
 
-func waitForInstanceStopped(w *Workflow, project, zone, name string, interval time.Duration) error {
-	w.logger.Printf("WaitForInstancesSignal: waiting for instance %q to stop.", name)
-	tick := time.Tick(interval)
+func waitForInstanceStopped(s *Step, project, zone, name string, interval time.Duration) error {
+	w := s.w
+	w.logger.StepInfo(s, "waiting for instance %q to stop.", name)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
 	for {
 		select {
-		case <-w.Cancel:
+		case <-w.cancelChan():
+			w.logger.StepInfo(s, "stopped waiting for instance %q to stop, %s.", name, w.cancelCause())
 			return nil
-		case <-tick:
+		case <-tick.C:
 			stopped, err := w.ComputeClient.InstanceStopped(project, zone, name)
 			if err != nil {
 				return err
 			}
 			if stopped {
-				w.logger.Printf("WaitForInstancesSignal: instance %q stopped.", name)
+				w.logger.StepInfo(s, "instance %q stopped.", name)
 				return nil
 			}
 		}
 	}
 }
 
-func waitForSerialOutput(w *Workflow, project, zone, name string, port int64, success, failure string, interval time.Duration) error {
-	msg := fmt.Sprintf("WaitForInstancesSignal: watching serial port %d", port)
+func waitForSerialOutput(s *Step, project, zone, name string, port int64, success, failure string, interval time.Duration) (string, error) {
+	w := s.w
+	msg := fmt.Sprintf("watching serial port %d", port)
 	if success != "" {
 		msg += fmt.Sprintf(", SuccessMatch: %q", success)
 	}
 	if failure != "" {
 		msg += fmt.Sprintf(", FailureMatch: %q", failure)
 	}
-	w.logger.Print(msg + ".")
+	w.logger.StepInfo(s, "%s.", msg)
 	var start int64
 	var errs int
-	tick := time.Tick(interval)
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
 	for {
 		select {
-		case <-w.Cancel:
-			return nil
-		case <-tick:
+		case <-w.cancelChan():
+			w.logger.StepInfo(s, "stopped watching instance %q serial port %d, %s.", name, port, w.cancelCause())
+			return "", nil
+		case <-tick.C:
 			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, port, start)
 			if err != nil {
 				status, sErr := w.ComputeClient.InstanceStatus(project, zone, name)
 				if sErr == nil && (status == "TERMINATED" || status == "STOPPING" || status == "STOPPED") {
-					w.logger.Printf("WaitForInstancesSignal: instance %q stopped, not waiting for serial output.", name)
-					return nil
+					w.logger.StepInfo(s, "instance %q stopped, not waiting for serial output.", name)
+					return "", nil
 				}
 				// Retry up to 3 times in a row on any error if we successfully got InstanceStatus.
 				if sErr == nil && errs < 3 {
@@ -106,23 +128,82 @@ func waitForSerialOutput(w *Workflow, project, zone, name string, port int64, su
 				} else {
 					err = fmt.Errorf("%v, InstanceStatus: %q", err, status)
 				}
-				return fmt.Errorf("WaitForInstancesSignal: instance %q: error getting serial port: %v", name, err)
+				return "", fmt.Errorf("WaitForInstancesSignal: instance %q: error getting serial port: %v", name, err)
 			}
 			start = resp.Next
 			if failure != "" && strings.Contains(resp.Contents, failure) {
-				return fmt.Errorf("WaitForInstancesSignal: FailureMatch found for instance %q", name)
+				return "", fmt.Errorf("WaitForInstancesSignal: FailureMatch found for instance %q", name)
 			}
-			if success != "" && strings.Contains(resp.Contents, success) {
-				w.logger.Printf("WaitForInstancesSignal: SuccessMatch found for instance %q", name)
-				return nil
+			if i := strings.Index(resp.Contents, success); success != "" && i != -1 {
+				w.logger.StepInfo(s, "SuccessMatch found for instance %q", name)
+				return matchedLine(resp.Contents, i), nil
+			}
+			errs = 0
+		}
+	}
+}
+
+// matchedLine returns the line of s containing index i.
+func matchedLine(s string, i int) string {
+	start := strings.LastIndex(s[:i], "\n") + 1
+	if end := strings.Index(s[i:], "\n"); end != -1 {
+		return strings.TrimSpace(s[start : i+end])
+	}
+	return strings.TrimSpace(s[start:])
+}
+
+func waitForReboots(s *Step, project, zone, name string, r *Reboots, interval time.Duration) error {
+	w := s.w
+	w.logger.StepInfo(s, "waiting for instance %q to reboot %d times, watching serial port %d for %q.", name, r.Count, r.Port, r.BootMatch)
+	var boots, reboots, errs int
+	var start int64
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.cancelChan():
+			w.logger.StepInfo(s, "stopped waiting for instance %q to reboot, %s.", name, w.cancelCause())
+			return nil
+		case <-tick.C:
+			status, err := w.ComputeClient.InstanceStatus(project, zone, name)
+			if err != nil {
+				// Retry up to 3 times in a row, the instance may be restarting.
+				if errs < 3 {
+					errs++
+					continue
+				}
+				return fmt.Errorf("WaitForInstancesSignal: instance %q: error getting InstanceStatus: %v", name, err)
 			}
 			errs = 0
+			if status != "RUNNING" {
+				continue
+			}
+			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, r.Port, start)
+			if err != nil {
+				// The serial port may not be available while the guest boots.
+				continue
+			}
+			start = resp.Next
+			n := strings.Count(resp.Contents, r.BootMatch)
+			if n == 0 {
+				continue
+			}
+			boots += n
+			if boots < 2 {
+				continue
+			}
+			reboots = boots - 1
+			w.logger.StepInfo(s, "instance %q rebooted (%d of %d).", name, minInt(reboots, r.Count), r.Count)
+			if reboots >= r.Count {
+				return nil
+			}
 		}
 	}
 }
 
 func (w *WaitForInstancesSignal) populate(ctx context.Context, s *Step) error {
 	for _, ws := range *w {
+		ws.Name = normalizeURL(ws.Name, instanceURLRgx, s.project(), "")
 		if ws.Interval == "" {
 			ws.Interval = defaultInterval
 		}
@@ -131,6 +212,9 @@ func (w *WaitForInstancesSignal) populate(ctx context.Context, s *Step) error {
 		if err != nil {
 			return err
 		}
+		if ws.Reboots != nil && ws.Reboots.Port == 0 {
+			ws.Reboots.Port = 1
+		}
 	}
 	return nil
 }
@@ -151,9 +235,10 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) error {
 			m := namedSubexp(instanceURLRgx, i.link)
 			serialSig := make(chan struct{})
 			stoppedSig := make(chan struct{})
+			rebootsSig := make(chan struct{})
 			if is.Stopped {
 				go func() {
-					if err := waitForInstanceStopped(s.w, m["project"], m["zone"], m["instance"], is.interval); err != nil {
+					if err := waitForInstanceStopped(s, m["project"], m["zone"], m["instance"], is.interval); err != nil {
 						e <- err
 					}
 					close(stoppedSig)
@@ -161,17 +246,32 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) error {
 			}
 			if is.SerialOutput != nil {
 				go func() {
-					if err := waitForSerialOutput(s.w, m["project"], m["zone"], m["instance"], is.SerialOutput.Port, is.SerialOutput.SuccessMatch, is.SerialOutput.FailureMatch, is.interval); err != nil {
+					line, err := waitForSerialOutput(s, m["project"], m["zone"], m["instance"], is.SerialOutput.Port, is.SerialOutput.SuccessMatch, is.SerialOutput.FailureMatch, is.interval)
+					if err != nil {
 						e <- err
+					} else if line != "" {
+						s.setOutput(is.Name, line)
 					}
 					close(serialSig)
 				}()
 			}
+			if is.Reboots != nil {
+				go func() {
+					if err := waitForReboots(s, m["project"], m["zone"], m["instance"], is.Reboots, is.interval); err != nil {
+						e <- err
+					}
+					close(rebootsSig)
+				}()
+			}
 			select {
 			case <-serialSig:
 				return
 			case <-stoppedSig:
 				return
+			case <-rebootsSig:
+				return
+			case <-s.w.cancelChan():
+				return
 			}
 		}(is)
 	}
@@ -184,7 +284,10 @@ func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) error {
 	select {
 	case err := <-e:
 		return err
-	case <-s.w.Cancel:
+	case <-s.w.cancelChan():
+		// The waits stop right away, don't leave them running after
+		// the step returned.
+		wg.Wait()
 		return nil
 	}
 }
@@ -206,6 +309,12 @@ func (w *WaitForInstancesSignal) validate(ctx context.Context, s *Step) error {
 				return fmt.Errorf("%q: cannot wait for instance signal via SerialOutput, no SuccessMatch or FailureMatch given", i.Name)
 			}
 		}
+		if i.Reboots != nil && i.Reboots.Count < 1 {
+			return fmt.Errorf("%q: cannot wait for instance reboots, Count must be at least 1, got: %d", i.Name, i.Reboots.Count)
+		}
+		if i.Reboots != nil && i.Reboots.BootMatch == "" {
+			return fmt.Errorf("%q: cannot wait for instance reboots, no BootMatch given", i.Name)
+		}
 	}
 	return nil
 }
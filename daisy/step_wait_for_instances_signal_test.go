@@ -15,11 +15,14 @@
 package daisy
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,18 +48,28 @@ func TestWaitForInstanceStopped(t *testing.T) {
 	defer svr.Close()
 
 	w.ComputeClient = c
-	if err := waitForInstanceStopped(w, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
+	s := &Step{name: "wait", w: w, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+	if err := waitForInstanceStopped(s, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
 		t.Fatalf("error running waitForInstanceStopped: %v", err)
 	}
 }
 
 func TestWaitForInstancesSignalPopulate(t *testing.T) {
-	got := &WaitForInstancesSignal{&InstanceSignal{Name: "test"}}
-	if err := got.populate(context.Background(), &Step{}); err != nil {
+	w := testWorkflow()
+	got := &WaitForInstancesSignal{
+		&InstanceSignal{Name: "test"},
+		&InstanceSignal{Name: "https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/i"},
+		&InstanceSignal{Name: "zones/z/instances/i"},
+	}
+	if err := got.populate(context.Background(), &Step{w: w}); err != nil {
 		t.Fatalf("error running populate: %v", err)
 	}
 
-	want := &WaitForInstancesSignal{&InstanceSignal{Name: "test", Interval: "10s", interval: 10 * time.Second}}
+	want := &WaitForInstancesSignal{
+		&InstanceSignal{Name: "test", Interval: "10s", interval: 10 * time.Second},
+		&InstanceSignal{Name: "projects/p/zones/z/instances/i", Interval: "10s", interval: 10 * time.Second},
+		&InstanceSignal{Name: fmt.Sprintf("projects/%s/zones/z/instances/i", w.Project), Interval: "10s", interval: 10 * time.Second},
+	}
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("got != want:\ngot:  %+v\nwant: %+v", got, want)
 	}
@@ -182,6 +195,9 @@ func TestWaitForInstancesSignalValidate(t *testing.T) {
 		{"SerialOutput no SuccessMatch or FailureMatch", WaitForInstancesSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1}, interval: 1 * time.Second}}, true},
 		{"instance DNE error check", WaitForInstancesSignal{{Name: "instance1", Stopped: true, interval: 1 * time.Second}, {Name: "instance2", Stopped: true, interval: 1 * time.Second}}, true},
 		{"no interval", WaitForInstancesSignal{{Name: "instance1", Stopped: true, Interval: "0s"}}, true},
+		{"normal Reboots", WaitForInstancesSignal{{Name: "instance1", Reboots: &Reboots{Count: 2, BootMatch: "boot"}, interval: 1 * time.Second}}, false},
+		{"Reboots no Count", WaitForInstancesSignal{{Name: "instance1", Reboots: &Reboots{BootMatch: "boot"}, interval: 1 * time.Second}}, true},
+		{"Reboots no BootMatch", WaitForInstancesSignal{{Name: "instance1", Reboots: &Reboots{Count: 2}, interval: 1 * time.Second}}, true},
 	}
 
 	for _, tt := range tests {
@@ -190,3 +206,103 @@ func TestWaitForInstancesSignalValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestWaitForInstancesSignalSiblingFailure(t *testing.T) {
+	w := testWorkflow()
+	instances[w].m = map[string]*resource{"i": {link: fmt.Sprintf("projects/%s/zones/%s/instances/i", testProject, testZone)}}
+
+	waited := make(chan struct{})
+	ws := &WaitForInstancesSignal{{Name: "i", Stopped: true, interval: time.Hour}}
+	w.Steps = map[string]*Step{
+		"fail": {name: "fail", w: w, timeout: time.Hour, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			return errors.New("fail")
+		}}},
+		"wait": {name: "wait", w: w, timeout: time.Hour, testType: &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+			defer close(waited)
+			return ws.run(ctx, s)
+		}}},
+	}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("should have returned an error")
+	}
+	select {
+	case <-waited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("WaitForInstancesSignal did not stop on sibling failure")
+	}
+	if want := `canceled due to failure of step "fail"`; w.cancelCause() != want {
+		t.Errorf("unexpected cancel cause, got: %q, want: %q", w.cancelCause(), want)
+	}
+}
+
+func TestWaitForInstanceStoppedCanceled(t *testing.T) {
+	w := testWorkflow()
+	var buf bytes.Buffer
+	w.logger = newLogger(w, &textLogger{log.New(&buf, "", 0)})
+	s, _ := w.NewStep("fail")
+	w.stepFailed(s, errors.New("fail"))
+
+	s = &Step{name: "wait", w: w, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+	if err := waitForInstanceStopped(s, testProject, testZone, "foo", time.Hour); err != nil {
+		t.Fatalf("error running waitForInstanceStopped: %v", err)
+	}
+	if want := `WaitForInstancesSignal: stopped waiting for instance "foo" to stop, canceled due to failure of step "fail".`; !strings.Contains(buf.String(), want) {
+		t.Errorf("log does not contain %q:\n%s", want, buf.String())
+	}
+}
+
+func TestWaitForReboots(t *testing.T) {
+	tests := []struct {
+		desc      string
+		reboots   *Reboots
+		statuses  []string
+		serial    []string
+		shouldErr bool
+	}{
+		{"not running case", &Reboots{Count: 1, Port: 1, BootMatch: "boot"}, []string{"STAGING", "RUNNING"}, []string{"boot", "boot"}, false},
+		{"serial case", &Reboots{Count: 2, Port: 1, BootMatch: "boot"}, []string{"RUNNING"}, []string{"boot", "", "x boot y", "boot"}, false},
+		{"serial many reboots at once case", &Reboots{Count: 2, Port: 1, BootMatch: "boot"}, []string{"RUNNING"}, []string{"boot boot boot"}, false},
+		{"status error case", &Reboots{Count: 1, Port: 1, BootMatch: "boot"}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		var statusCalls, serialCalls int
+		w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+			if len(tt.statuses) == 0 {
+				return "", errors.New("fail")
+			}
+			st := tt.statuses[minInt(statusCalls, len(tt.statuses)-1)]
+			statusCalls++
+			return st, nil
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, port, start int64) (*compute.SerialPortOutput, error) {
+			if port != 1 {
+				return nil, fmt.Errorf("unexpected port %d", port)
+			}
+			if serialCalls >= len(tt.serial) {
+				return &compute.SerialPortOutput{Next: start}, nil
+			}
+			c := tt.serial[serialCalls]
+			serialCalls++
+			return &compute.SerialPortOutput{Contents: c, Next: start + int64(len(c))}, nil
+		}
+
+		done := make(chan error)
+		s := &Step{name: "wait", w: w, WaitForInstancesSignal: &WaitForInstancesSignal{}}
+		go func() { done <- waitForReboots(s, testProject, testZone, "foo", tt.reboots, time.Microsecond) }()
+		select {
+		case err := <-done:
+			if tt.shouldErr && err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			} else if !tt.shouldErr && err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+		case <-time.After(5 * time.Second):
+			w.CancelWithReason("test timed out")
+			<-done
+			t.Errorf("%s: did not see the reboots", tt.desc)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// WriteTemplatedFiles is a Daisy WriteTemplatedFiles workflow step.
+type WriteTemplatedFiles []*TemplatedFile
+
+// TemplatedFile renders Template, a Go text/template, into the object
+// Destination in the workflow's sources path when the step runs, so
+// instances created later can read configuration derived from earlier
+// steps. The disk, image, instance, network, snapshot and subnetwork
+// template functions return the partial URL of the workflow resource of
+// that name, address returns the IP of the workflow static address of that
+// name, contentHash returns a hash recorded by a VerifyContentHashes step. Vars are substituted in Template before the
+// workflow runs, as in any other field.
+type TemplatedFile struct {
+	// Destination path, relative to the sources path.
+	Destination string
+	Template    string
+	tmpl        *template.Template
+}
+
+func (t *WriteTemplatedFiles) populate(ctx context.Context, s *Step) error {
+	for _, tf := range *t {
+		tf.Destination = path.Clean(strings.TrimPrefix(tf.Destination, "/"))
+	}
+	return nil
+}
+
+func (t *WriteTemplatedFiles) validate(ctx context.Context, s *Step) error {
+	var errs Errors
+	dsts := map[string]bool{}
+	for _, tf := range *t {
+		if tf.Destination == "." || tf.Destination == ".." || strings.HasPrefix(tf.Destination, "../") {
+			errs.add(Errorf("cannot write templated file: bad Destination %q, must be a path in the sources path", tf.Destination))
+			continue
+		}
+		if dsts[tf.Destination] {
+			errs.add(Errorf("cannot write templated file %q: written twice by step %q", tf.Destination, s.name))
+			continue
+		}
+		dsts[tf.Destination] = true
+		var err error
+		if tf.tmpl, err = template.New(tf.Destination).Funcs(templateFuncs(s.w)).Parse(tf.Template); err != nil {
+			errs.add(Errorf("cannot write templated file %q: bad Template: %v", tf.Destination, err))
+		}
+	}
+	return errs.cast()
+}
+
+func (t *WriteTemplatedFiles) run(ctx context.Context, s *Step) error {
+	w := s.w
+	for _, tf := range *t {
+		select {
+		case <-w.cancelChan():
+			return nil
+		default:
+		}
+		b, err := tf.render()
+		if err != nil {
+			return fmt.Errorf("WriteTemplatedFiles: error rendering %q: %v", tf.Destination, err)
+		}
+		gcs := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, tf.Destination)).NewWriter(ctx)
+		if _, err := gcs.Write(b); err != nil {
+			gcs.Close()
+			return fmt.Errorf("WriteTemplatedFiles: error writing %q: %v", tf.Destination, err)
+		}
+		if err := gcs.Close(); err != nil {
+			return fmt.Errorf("WriteTemplatedFiles: error writing %q: %v", tf.Destination, err)
+		}
+		w.logger.StepInfo(s, "wrote %q to the sources path.", tf.Destination)
+	}
+	return nil
+}
+
+func (tf *TemplatedFile) render() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tf.tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// templateFuncs returns the functions TemplatedFile templates of w can use.
+func templateFuncs(w *Workflow) template.FuncMap {
+	link := func(rm *baseResourceMap) func(string) (string, error) {
+		return func(name string) (string, error) {
+			r, ok := rm.get(name)
+			if !ok || r.deleted {
+				return "", fmt.Errorf("no %s %q", rm.typeName, name)
+			}
+			return r.link, nil
+		}
+	}
+	return template.FuncMap{
+		"disk":       link(&disks[w].baseResourceMap),
+		"image":      link(&images[w].baseResourceMap),
+		"instance":   link(&instances[w].baseResourceMap),
+		"network":    link(&networks[w].baseResourceMap),
+		"snapshot":   link(&snapshots[w].baseResourceMap),
+		"subnetwork": link(&subnetworks[w].baseResourceMap),
+		"address": func(name string) (string, error) {
+			r, ok := addresses[w].get(name)
+			if !ok || r.deleted {
+				return "", fmt.Errorf("no address %q", name)
+			}
+			m := namedSubexp(addressURLRegex, r.link)
+			a, err := w.ComputeClient.GetAddress(m["project"], m["region"], m["address"])
+			if err != nil {
+				return "", err
+			}
+			return a.Address, nil
+		},
+		"contentHash": func(name string) (string, error) {
+			root := w.root()
+			root.contentHashesMx.Lock()
+			defer root.contentHashesMx.Unlock()
+			r, ok := root.contentHashes[name]
+			if !ok || r.hash == "" {
+				return "", fmt.Errorf("no content hash %q recorded", name)
+			}
+			return r.hash, nil
+		},
+	}
+}
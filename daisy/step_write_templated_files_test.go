@@ -0,0 +1,148 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestWriteTemplatedFilesPopulate(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{w: w}
+	wt := &WriteTemplatedFiles{{Destination: "/a/./b.cfg"}, {Destination: "c.cfg"}}
+	if err := wt.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &WriteTemplatedFiles{{Destination: "a/b.cfg"}, {Destination: "c.cfg"}}
+	if diff := pretty.Compare(wt, want); diff != "" {
+		t.Errorf("populated WriteTemplatedFiles does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestWriteTemplatedFilesValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		files     []*TemplatedFile
+		shouldErr bool
+	}{
+		{"normal case", []*TemplatedFile{{Destination: "a.cfg", Template: `{{image "i"}}`}, {Destination: "b.cfg", Template: "b"}}, false},
+		{"empty template case", []*TemplatedFile{{Destination: "a.cfg"}}, false},
+		{"no destination case", []*TemplatedFile{{Destination: ".", Template: "a"}}, true},
+		{"destination outside sources case", []*TemplatedFile{{Destination: "../a.cfg", Template: "a"}}, true},
+		{"dupe destination case", []*TemplatedFile{{Destination: "a.cfg", Template: "a"}, {Destination: "a.cfg", Template: "b"}}, true},
+		{"bad template case", []*TemplatedFile{{Destination: "a.cfg", Template: "{{image"}}, true},
+		{"unknown function case", []*TemplatedFile{{Destination: "a.cfg", Template: `{{bucket "b"}}`}}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s := &Step{name: "s", w: w}
+		wt := WriteTemplatedFiles(tt.files)
+		err := wt.validate(context.Background(), s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestTemplatedFileRender(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w}
+	images[w].m = map[string]*resource{
+		"i":       {link: "projects/p/global/images/i-real", created: true},
+		"deleted": {link: "projects/p/global/images/deleted", created: true, deleted: true},
+	}
+	addresses[w].m = map[string]*resource{"a": {link: "projects/p/regions/r/addresses/a-real", created: true}}
+	w.ComputeClient = &daisyCompute.TestClient{GetAddressFn: func(p, r, n string) (*compute.Address, error) {
+		if p != "p" || r != "r" || n != "a-real" {
+			return nil, fmt.Errorf("unexpected address %s/%s/%s", p, r, n)
+		}
+		return &compute.Address{Address: "10.0.0.2"}, nil
+	}}
+	w.contentHashes = map[string]*contentHashRecord{
+		"h":       {hash: "abc"},
+		"pending": {},
+	}
+
+	tests := []struct {
+		desc, tmpl, want string
+		shouldErr        bool
+	}{
+		{"plain case", "a=b\n", "a=b\n", false},
+		{"image case", `image={{image "i"}}`, "image=projects/p/global/images/i-real", false},
+		{"content hash case", `sha256={{contentHash "h"}}`, "sha256=abc", false},
+		{"address case", `ip={{address "a"}}`, "ip=10.0.0.2", false},
+		{"unknown address case", `{{address "dne"}}`, "", true},
+		{"unknown image case", `{{image "dne"}}`, "", true},
+		{"deleted image case", `{{image "deleted"}}`, "", true},
+		{"wrong resource type case", `{{disk "i"}}`, "", true},
+		{"unrecorded hash case", `{{contentHash "pending"}}`, "", true},
+	}
+	for _, tt := range tests {
+		wt := &WriteTemplatedFiles{{Destination: "a.cfg", Template: tt.tmpl}}
+		if err := wt.validate(context.Background(), s); err != nil {
+			t.Errorf("%s: unexpected validation error: %v", tt.desc, err)
+			continue
+		}
+		got, err := (*wt)[0].render()
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if string(got) != tt.want {
+			t.Errorf("%s: unexpected rendering, got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTemplatedFilesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.bucket = "bucket"
+	w.sourcesPath = "scratch/sources"
+	s := &Step{name: "s", w: w}
+	images[w].m = map[string]*resource{"i": {link: "projects/p/global/images/i", created: true}}
+
+	wt := &WriteTemplatedFiles{{Destination: "a/test.cfg", Template: `{{image "i"}}`}}
+	if err := wt.validate(ctx, s); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	testGCSObjs = nil
+	if err := wt.run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(testGCSObjs, []string{"scratch/sources/a/test.cfg"}); diff != "" {
+		t.Errorf("written objects do not match expectation: (-got +want)\n%s", diff)
+	}
+
+	wt = &WriteTemplatedFiles{{Destination: "b.cfg", Template: `{{image "dne"}}`}}
+	if err := wt.validate(ctx, s); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if err := wt.run(ctx, s); err == nil {
+		t.Error("should have returned an error")
+	}
+}
@@ -0,0 +1,33 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+var subnetworks = map[*Workflow]*subnetworkMap{}
+
+type subnetworkMap struct {
+	baseResourceMap
+}
+
+func initSubnetworkMap(w *Workflow) {
+	sm := &subnetworkMap{baseResourceMap: baseResourceMap{w: w, typeName: "subnetwork", urlRgx: subnetworkURLRegex}}
+	sm.baseResourceMap.deleteFn = sm.deleteFn
+	sm.init()
+	subnetworks[w] = sm
+}
+
+func (sm *subnetworkMap) deleteFn(r *resource) error {
+	m := namedSubexp(subnetworkURLRegex, r.link)
+	return sm.w.ComputeClient.DeleteSubnetwork(m["project"], m["region"], m["subnetwork"])
+}
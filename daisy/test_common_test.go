@@ -67,7 +67,10 @@ var (
 	testMachineType = "test-machine-type"
 	testGCSPath     = "gs://test-bucket"
 	testGCSObjs     []string
+	testGCSDeleted  []string
 	testGCSObjsMx   = sync.Mutex{}
+	// testLabels are the labels added to resources created by testWorkflow().
+	testLabels = map[string]string{"daisy-workflow-name": testWf, "daisy-workflow-id": "abcdef", "daisy-username": ""}
 )
 
 func testWorkflow() *Workflow {
@@ -80,7 +83,7 @@ func testWorkflow() *Workflow {
 	w.ComputeClient, _ = newTestGCEClient()
 	w.StorageClient, _ = newTestGCSClient()
 	w.Cancel = make(chan struct{})
-	w.logger = log.New(ioutil.Discard, "", 0)
+	w.logger = newLogger(w, &textLogger{log.New(ioutil.Discard, "", 0)})
 	return w
 }
 
@@ -90,6 +93,12 @@ func addGCSObj(o string) {
 	testGCSObjs = append(testGCSObjs, o)
 }
 
+func deleteGCSObj(o string) {
+	testGCSObjsMx.Lock()
+	defer testGCSObjsMx.Unlock()
+	testGCSDeleted = append(testGCSDeleted, o)
+}
+
 func newTestGCEClient() (*daisyCompute.TestClient, error) {
 	_, c, err := daisyCompute.NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && strings.Contains(r.URL.String(), "serialPort?alt=json&port=1") {
@@ -132,6 +141,7 @@ func newTestGCSClient() (*storage.Client, error) {
 	rewriteRgx := regexp.MustCompile(`/b/([^/]+)/o/([^/]+)/rewriteTo/b/([^/]+)/o/([^?]+)`)
 	uploadRgx := regexp.MustCompile(`/b/([^/]+)/o?.*uploadType=multipart.*`)
 	getObjRgx := regexp.MustCompile(`/b/.+/o/.+alt=json&projection=full`)
+	deleteObjRgx := regexp.MustCompile(`/b/[^/]+/o/([^?]+)`)
 	listObjsRgx := regexp.MustCompile(`/b/.+/o\?alt=json&delimiter=&pageToken=&prefix=.+&projection=full&versions=false`)
 	listObjsNoPrefixRgx := regexp.MustCompile(`/b/.+/o\?alt=json&delimiter=&pageToken=&prefix=&projection=full&versions=false`)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -159,6 +169,15 @@ func newTestGCSClient() (*storage.Client, error) {
 			addGCSObj(path)
 			o := fmt.Sprintf(`{"bucket":"%s","name":"%s"}`, match[3], match[4])
 			fmt.Fprintf(w, `{"kind": "storage#rewriteResponse", "done": true, "objectSize": "1", "totalBytesRewritten": "1", "resource": %s}`, o)
+		} else if match := deleteObjRgx.FindStringSubmatch(u); m == "DELETE" && match != nil {
+			// Return StatusNotFound for objects that do not exist.
+			if strings.Contains(match[1], "dne") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			o, _ := url.PathUnescape(match[1])
+			deleteGCSObj(o)
+			w.WriteHeader(http.StatusNoContent)
 		} else if match := getObjRgx.FindStringSubmatch(u); m == "GET" && match != nil {
 			// Return StatusNotFound for objects that do not exist.
 			if strings.Contains(match[0], "dne") {
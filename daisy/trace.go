@@ -0,0 +1,81 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// traceEvent is a Chrome trace event, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU.
+type traceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat,omitempty"`
+	// Phase, "X" for complete events, "M" for metadata.
+	Ph string `json:"ph"`
+	// Timestamp and duration, in microseconds.
+	Ts   int64             `json:"ts"`
+	Dur  int64             `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes the Steps of r as a timeline in the Chrome trace event
+// format, to see where a run spent its time in chrome://tracing, Perfetto
+// or speedscope. Steps that ran at the same time are on separate rows.
+// name names the timeline, e.g. the workflow name.
+func (r *RunResult) WriteTrace(out io.Writer, name string) error {
+	steps := append([]*StepResult{}, r.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].Start.Before(steps[j].Start) })
+
+	events := []*traceEvent{{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]string{"name": name}}}
+	var start time.Time
+	if len(steps) > 0 {
+		start = steps[0].Start
+	}
+	// End of the last step on each row.
+	var rows []time.Time
+	for _, sr := range steps {
+		end := sr.Start.Add(sr.Duration)
+		row := 0
+		for row < len(rows) && rows[row].After(sr.Start) {
+			row++
+		}
+		if row == len(rows) {
+			rows = append(rows, end)
+		} else {
+			rows[row] = end
+		}
+		args := map[string]string{"state": sr.State.String()}
+		if sr.Error != "" {
+			args["error"] = sr.Error
+		}
+		events = append(events, &traceEvent{
+			Name: sr.Step,
+			Cat:  sr.Type,
+			Ph:   "X",
+			Ts:   int64(sr.Start.Sub(start) / time.Microsecond),
+			Dur:  int64(sr.Duration / time.Microsecond),
+			Pid:  1,
+			Tid:  row + 1,
+			Args: args,
+		})
+	}
+	return json.NewEncoder(out).Encode(map[string]interface{}{"traceEvents": events, "displayTimeUnit": "ms"})
+}
@@ -0,0 +1,55 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestWriteTrace(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := &RunResult{Steps: []*StepResult{
+		{Step: "c", Type: "CreateImages", State: StepFailed, Start: start.Add(3 * time.Second), Duration: time.Second, Error: "fail"},
+		{Step: "a", Type: "CreateDisks", State: StepFinished, Start: start, Duration: 2 * time.Second},
+		{Step: "b", Type: "CreateDisks", State: StepFinished, Start: start.Add(time.Second), Duration: 2 * time.Second},
+	}}
+
+	var buf bytes.Buffer
+	if err := r.WriteTrace(&buf, "wf"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got struct {
+		TraceEvents []*traceEvent
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("trace is not valid JSON: %v", err)
+	}
+
+	// b overlaps a so it is on a second row, c runs after a on the first.
+	want := []*traceEvent{
+		{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]string{"name": "wf"}},
+		{Name: "a", Cat: "CreateDisks", Ph: "X", Ts: 0, Dur: 2e6, Pid: 1, Tid: 1, Args: map[string]string{"state": "finished"}},
+		{Name: "b", Cat: "CreateDisks", Ph: "X", Ts: 1e6, Dur: 2e6, Pid: 1, Tid: 2, Args: map[string]string{"state": "finished"}},
+		{Name: "c", Cat: "CreateImages", Ph: "X", Ts: 3e6, Dur: 1e6, Pid: 1, Tid: 1, Args: map[string]string{"state": "failed", "error": "fail"}},
+	}
+	if diff := pretty.Compare(got.TraceEvents, want); diff != "" {
+		t.Errorf("trace events not as expected: (-got +want)\n%s", diff)
+	}
+}
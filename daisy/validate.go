@@ -60,13 +60,30 @@ func (w *Workflow) validate(ctx context.Context) error {
 	if err := w.validateRequiredFields(); err != nil {
 		return err
 	}
+	if err := w.validateSkipValidations(); err != nil {
+		return err
+	}
+	if w.MaxParallelSteps < 0 {
+		return fmt.Errorf("workflow field 'MaxParallelSteps' must not be negative, got: %d", w.MaxParallelSteps)
+	}
+	for _, z := range w.FallbackZones {
+		if err := w.validateZone(w.Project, z); err != nil {
+			return fmt.Errorf("bad zone %q in workflow field 'FallbackZones': %v", z, err)
+		}
+	}
 
 	// Check for unsubstituted vars.
 	if err := w.validateVarsSubbed(); err != nil {
 		return err
 	}
 
-	return w.validateDAG(ctx)
+	if err := w.validateDAG(ctx); err != nil {
+		return err
+	}
+	if err := w.validateSkipSteps(); err != nil {
+		return err
+	}
+	return w.parseOutputs()
 }
 
 // Step through the step DAG, calling each step's validate().
@@ -102,15 +119,128 @@ func (w *Workflow) validateDAG(ctx context.Context) error {
 	return w.traverseDAG(func(s *Step) error { return s.validate(ctx) })
 }
 
+// validateVarsSubbed checks that no substitutions are left in w, except
+// escaped ones, "$${...}", see unescapeSubstitutions, and references to the
+// outputs of steps, which are substituted when the step runs.
 func (w *Workflow) validateVarsSubbed() error {
 	unsubbedVarRgx := regexp.MustCompile(`\$\{([^}]+)}`)
-	return traverseData(reflect.ValueOf(w).Elem(), func(v reflect.Value) error {
+	check := func(v reflect.Value) error {
 		switch v.Interface().(type) {
 		case string:
-			if match := unsubbedVarRgx.FindStringSubmatch(v.String()); match != nil {
+			s := stepOutputRgx.ReplaceAllString(strings.Replace(v.String(), "$${", "", -1), "")
+			if match := unsubbedVarRgx.FindStringSubmatch(s); match != nil {
 				return fmt.Errorf("Unresolved var %q found in %q", match[0], v.String())
 			}
 		}
 		return nil
-	})
+	}
+	v := reflect.ValueOf(w).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Name == "Steps" {
+			continue
+		}
+		if err := traverseData(v.Field(i), check); err != nil {
+			return err
+		}
+	}
+	for name, s := range w.Steps {
+		if err := check(reflect.ValueOf(name)); err != nil {
+			return err
+		}
+		if err := traverseStepData(s, check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validation checks SkipValidations can skip. They look up resources with
+// the API, which may not be possible, e.g. in offline CI or against an
+// emulator.
+const (
+	// ValidationProjects checks that projects exist.
+	ValidationProjects = "projects"
+	// ValidationZones checks that zones exist.
+	ValidationZones = "zones"
+	// ValidationMachineTypes checks that machine types exist, and support
+	// the minimum CPU platform of instances.
+	ValidationMachineTypes = "machinetypes"
+	// ValidationDiskTypes checks that disk types exist.
+	ValidationDiskTypes = "disktypes"
+	// ValidationImageFamilies checks that the image families of source
+	// images have an image.
+	ValidationImageFamilies = "imagefamilies"
+	// ValidationOSLogin checks that the credentials can log in to
+	// instances with OS Login.
+	ValidationOSLogin = "oslogin"
+)
+
+var validationChecks = []string{ValidationProjects, ValidationZones, ValidationMachineTypes, ValidationDiskTypes, ValidationImageFamilies, ValidationOSLogin}
+
+func (w *Workflow) validateSkipValidations() error {
+	for _, check := range w.SkipValidations {
+		if !strIn(check, validationChecks) {
+			return fmt.Errorf("unknown validation check %q in 'SkipValidations', known checks: %q", check, validationChecks)
+		}
+	}
+	return nil
+}
+
+// skipsValidation returns true if w or a parent of w skips check. Skipped
+// checks are recorded on the root workflow for its RunResult.
+func (w *Workflow) skipsValidation(check string) bool {
+	for wf := w; wf != nil; wf = wf.parent {
+		if !strIn(check, wf.SkipValidations) {
+			continue
+		}
+		root := w.root()
+		root.skippedValidationsMx.Lock()
+		defer root.skippedValidationsMx.Unlock()
+		if !strIn(check, root.skippedValidations) {
+			w.logger.Printf("Skipping %q validation checks, as set in SkipValidations", check)
+			root.skippedValidations = append(root.skippedValidations, check)
+		}
+		return true
+	}
+	return false
+}
+
+func (w *Workflow) validateProject(project string) error {
+	if w.skipsValidation(ValidationProjects) {
+		return nil
+	}
+	return checkProject(w.ComputeClient, project)
+}
+
+func (w *Workflow) validateZone(project, zone string) error {
+	if w.skipsValidation(ValidationZones) {
+		return nil
+	}
+	return checkZone(w.ComputeClient, project, zone)
+}
+
+// validateDiskType checks the disk type of url, a partial URL matching
+// diskTypeURLRgx.
+func (w *Workflow) validateDiskType(url string) error {
+	if w.skipsValidation(ValidationDiskTypes) {
+		return nil
+	}
+	return checkDiskType(w.ComputeClient, url)
+}
+
+// validateImageFamily checks the image family of image, if it is the partial
+// URL of an image family.
+func (w *Workflow) validateImageFamily(image string) error {
+	m := namedSubexp(imageURLRgx, image)
+	if m == nil || m["project"] == "" || m["family"] == "" || w.skipsValidation(ValidationImageFamilies) {
+		return nil
+	}
+	return checkImageFamily(w.ComputeClient, m["project"], m["family"])
+}
+
+func (w *Workflow) validateOSLogin(project string) error {
+	if w.skipsValidation(ValidationOSLogin) {
+		return nil
+	}
+	return checkOSLogin(w.ComputeClient, project)
 }
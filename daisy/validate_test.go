@@ -80,7 +80,7 @@ func TestValidateWorkflow(t *testing.T) {
 		t.Errorf("unexpected error: %s", err)
 	}
 
-	logger := log.New(ioutil.Discard, "", 0)
+	logger := newLogger(nil, &textLogger{log.New(ioutil.Discard, "", 0)})
 	// Bad test cases.
 	tests := []struct {
 		desc string
@@ -185,3 +185,29 @@ func TestValidateDAG(t *testing.T) {
 		t.Error("validation should have failed due to dependency cycle")
 	}
 }
+
+func TestSkipValidations(t *testing.T) {
+	w := testWorkflow()
+	w.SkipValidations = []string{ValidationZones, ValidationOSLogin}
+	sw := w.NewSubWorkflow()
+	sw.ComputeClient = w.ComputeClient
+	sw.logger = w.logger
+
+	if err := sw.validateZone(testProject, "bad-zone"); err != nil {
+		t.Errorf("skipped zone check returned an error: %v", err)
+	}
+	if err := sw.validateProject("bad-project"); err == nil {
+		t.Error("project check should have returned an error")
+	}
+	if got, want := w.Result().SkippedValidations, []string{ValidationZones}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected SkippedValidations, got: %q, want: %q", got, want)
+	}
+
+	if err := w.validateSkipValidations(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	w.SkipValidations = append(w.SkipValidations, "quota")
+	if err := w.validateSkipValidations(); err == nil {
+		t.Error("unknown validation check should have returned an error")
+	}
+}
@@ -0,0 +1,296 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of vars. Value holds the JSON of list and map vars.
+const (
+	varTypeString = "string"
+	varTypeInt    = "int"
+	varTypeBool   = "bool"
+	varTypeList   = "list"
+	varTypeMap    = "map"
+)
+
+var varTypes = []string{varTypeString, varTypeInt, varTypeBool, varTypeList, varTypeMap}
+
+// varFields are the fields of the object form of a var, JSON objects with
+// other keys are map values.
+var varFields = []string{"value", "required", "description", "type", "valuefromsecret", "valuefromenv"}
+
+func (v *vars) UnmarshalJSON(b []byte) error {
+	var sv string
+	if err := json.Unmarshal(b, &sv); err == nil {
+		v.Value = sv
+		return nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil || !isVarObject(obj) {
+		return v.setJSONValue(b)
+	}
+
+	// We can't unmarshal into vars directly as it would create an infinite loop.
+	type aVars vars
+	av := struct {
+		*aVars
+		Value json.RawMessage
+	}{aVars: (*aVars)(v)}
+	if err := json.Unmarshal(b, &av); err != nil {
+		return err
+	}
+	if av.Value == nil {
+		return nil
+	}
+	return v.setJSONValue(av.Value)
+}
+
+func isVarObject(obj map[string]json.RawMessage) bool {
+	for k := range obj {
+		if !strIn(strings.ToLower(k), varFields) {
+			return false
+		}
+	}
+	return true
+}
+
+// setJSONValue sets Value from the JSON value b. Type, if not set, is
+// inferred from b.
+func (v *vars) setJSONValue(b []byte) error {
+	var x interface{}
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	var typ string
+	switch x := x.(type) {
+	case nil:
+		v.Value = ""
+		return nil
+	case string:
+		v.Value = x
+		return nil
+	case float64:
+		typ = varTypeInt
+		v.Value = strings.TrimSpace(string(b))
+	case bool:
+		typ = varTypeBool
+		v.Value = strconv.FormatBool(x)
+	default:
+		typ = varTypeList
+		if _, ok := x.(map[string]interface{}); ok {
+			typ = varTypeMap
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, b); err != nil {
+			return err
+		}
+		v.Value = buf.String()
+	}
+	if v.Type == "" {
+		v.Type = typ
+	}
+	return nil
+}
+
+// replacements returns the old, new string pairs substituting var k: "${k}"
+// and, for list and map vars, "${k[i]}" and "${k.key}" for their elements.
+// Lists substitute "${k}" with their elements separated by commas, maps
+// with their "key=value" pairs separated by commas and sorted by key.
+func (v vars) replacements(k string) ([]string, error) {
+	name := fmt.Sprintf("${%s}", k)
+	switch v.Type {
+	case "", varTypeString:
+		return []string{name, v.Value}, nil
+	case varTypeInt:
+		if _, err := strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return nil, fmt.Errorf("var %q is not an int: %q", k, v.Value)
+		}
+		return []string{name, v.Value}, nil
+	case varTypeBool:
+		if _, err := strconv.ParseBool(v.Value); err != nil {
+			return nil, fmt.Errorf("var %q is not a bool: %q", k, v.Value)
+		}
+		return []string{name, v.Value}, nil
+	case varTypeList:
+		var l []interface{}
+		if err := json.Unmarshal([]byte(v.Value), &l); err != nil {
+			return nil, fmt.Errorf("var %q is not a JSON list: %q", k, v.Value)
+		}
+		var elems []string
+		var r []string
+		for i, e := range l {
+			s, err := varElem(e)
+			if err != nil {
+				return nil, fmt.Errorf("var %q: element %d %v", k, i, err)
+			}
+			elems = append(elems, s)
+			r = append(r, fmt.Sprintf("${%s[%d]}", k, i), s)
+		}
+		return append(r, name, strings.Join(elems, ",")), nil
+	case varTypeMap:
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(v.Value), &m); err != nil {
+			return nil, fmt.Errorf("var %q is not a JSON object: %q", k, v.Value)
+		}
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var pairs []string
+		var r []string
+		for _, key := range keys {
+			s, err := varElem(m[key])
+			if err != nil {
+				return nil, fmt.Errorf("var %q: key %q %v", k, key, err)
+			}
+			pairs = append(pairs, key+"="+s)
+			r = append(r, fmt.Sprintf("${%s.%s}", k, key), s)
+		}
+		return append(r, name, strings.Join(pairs, ",")), nil
+	}
+	return nil, fmt.Errorf("var %q has unknown type %q, must be one of %q", k, v.Type, varTypes)
+}
+
+// varElem returns the string form of an element of a list or map var.
+func varElem(e interface{}) (string, error) {
+	switch e := e.(type) {
+	case string:
+		return e, nil
+	case float64:
+		return strconv.FormatFloat(e, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(e), nil
+	}
+	return "", fmt.Errorf("must be a string, number or bool, got %v", e)
+}
+
+// varReplacements returns the old, new string pairs substituting vs.
+func varReplacements(vs map[string]vars) ([]string, error) {
+	var r []string
+	for k, v := range vs {
+		vr, err := v.replacements(k)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, vr...)
+	}
+	return r, nil
+}
+
+// resolveEnvVars sets the Value of the Vars of w with a ValueFromEnv to the
+// environment variable, if it is set.
+func (w *Workflow) resolveEnvVars() error {
+	for k, v := range w.Vars {
+		if v.ValueFromEnv == "" {
+			continue
+		}
+		if v.ValueFromSecret != "" {
+			return fmt.Errorf("var %q can't have both a ValueFromEnv and a ValueFromSecret", k)
+		}
+		if ev, ok := os.LookupEnv(v.ValueFromEnv); ok {
+			v.Value = ev
+			w.Vars[k] = v
+		}
+	}
+	return nil
+}
+
+// AddVarsFromFile sets vars from file. A ".yaml" or ".yml" file holds
+// "key: value" lines, any other file is a JSON object of vars, as in the
+// Vars of a workflow. Like AddVar, it keeps the declarations of the vars
+// of w.
+func (w *Workflow) AddVarsFromFile(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var vs map[string]vars
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		vs, err = parseYAMLVars(b)
+	default:
+		err = json.Unmarshal(b, &vs)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading var file %q: %v", file, err)
+	}
+	if w.Vars == nil {
+		w.Vars = map[string]vars{}
+	}
+	for k, v := range vs {
+		vr := w.Vars[k]
+		vr.Value = v.Value
+		vr.ValueFromSecret = v.ValueFromSecret
+		vr.ValueFromEnv = v.ValueFromEnv
+		if vr.Type == "" {
+			vr.Type = v.Type
+		}
+		w.Vars[k] = vr
+	}
+	return nil
+}
+
+// parseYAMLVars parses the flat "key: value" YAML of a var file. Values
+// may be quoted, lists and maps are only supported in JSON var files.
+func parseYAMLVars(b []byte) (map[string]vars, error) {
+	vs := map[string]vars{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 1 || line[0] == ' ' || line[0] == '\t' || line[0] == '-' {
+			return nil, fmt.Errorf("line %d: want \"key: value\", got %q", n, line)
+		}
+		k := strings.TrimSpace(line[:i])
+		v := strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.HasPrefix(v, `"`):
+			var err error
+			if v, err = strconv.Unquote(v); err != nil {
+				return nil, fmt.Errorf("line %d: bad quoted value %q: %v", n, line[i+1:], err)
+			}
+		case strings.HasPrefix(v, "'"):
+			if len(v) < 2 || !strings.HasSuffix(v, "'") {
+				return nil, fmt.Errorf("line %d: bad quoted value %q", n, line[i+1:])
+			}
+			v = strings.Replace(v[1:len(v)-1], "''", "'", -1)
+		case strings.HasPrefix(v, "[") || strings.HasPrefix(v, "{") || strings.HasPrefix(v, "|") || strings.HasPrefix(v, ">"):
+			return nil, fmt.Errorf("line %d: only strings are supported in YAML var files, use a JSON var file for lists and maps", n)
+		default:
+			if j := strings.Index(v, " #"); j != -1 {
+				v = strings.TrimSpace(v[:j])
+			}
+		}
+		vs[k] = vars{Value: v}
+	}
+	return vs, scanner.Err()
+}
@@ -0,0 +1,209 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestVarsUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		desc, input string
+		want        vars
+	}{
+		{"string case", `"foo"`, vars{Value: "foo"}},
+		{"int case", `8`, vars{Value: "8", Type: "int"}},
+		{"bool case", `true`, vars{Value: "true", Type: "bool"}},
+		{"list case", `["a", "b"]`, vars{Value: `["a","b"]`, Type: "list"}},
+		{"map case", `{"a": "b", "c": 1}`, vars{Value: `{"a":"b","c":1}`, Type: "map"}},
+		{"object case", `{"Value": "foo", "Required": true, "Description": "d"}`, vars{Value: "foo", Required: true, Description: "d"}},
+		{"object list case", `{"Value": ["a"], "Description": "d"}`, vars{Value: `["a"]`, Description: "d", Type: "list"}},
+		{"object type case", `{"Value": "8", "Type": "int"}`, vars{Value: "8", Type: "int"}},
+		{"object no value case", `{"Required": true, "Type": "list"}`, vars{Required: true, Type: "list"}},
+		{"secret case", `{"ValueFromSecret": "projects/p/secrets/s/versions/1"}`, vars{ValueFromSecret: "projects/p/secrets/s/versions/1"}},
+	}
+	for _, tt := range tests {
+		var got vars
+		if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: vars do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestVarReplacements(t *testing.T) {
+	tests := []struct {
+		desc      string
+		v         vars
+		in, want  string
+		shouldErr bool
+	}{
+		{"string case", vars{Value: "foo"}, "${v}", "foo", false},
+		{"int case", vars{Value: "8", Type: "int"}, "${v}", "8", false},
+		{"bool case", vars{Value: "false", Type: "bool"}, "${v}", "false", false},
+		{"list case", vars{Value: `["a","b",3]`, Type: "list"}, "${v} ${v[0]} ${v[2]} ${v[3]}", "a,b,3 a 3 ${v[3]}", false},
+		{"map case", vars{Value: `{"z":"1","a":true}`, Type: "map"}, "${v} ${v.z} ${v.a}", "a=true,z=1 1 true", false},
+		{"bad int case", vars{Value: "eight", Type: "int"}, "", "", true},
+		{"bad bool case", vars{Value: "maybe", Type: "bool"}, "", "", true},
+		{"bad list case", vars{Value: "a,b", Type: "list"}, "", "", true},
+		{"nested list case", vars{Value: `[["a"]]`, Type: "list"}, "", "", true},
+		{"bad map case", vars{Value: `["a"]`, Type: "map"}, "", "", true},
+		{"unknown type case", vars{Value: "a", Type: "float"}, "", "", true},
+	}
+	for _, tt := range tests {
+		r, err := tt.v.replacements("v")
+		if err != nil {
+			if !tt.shouldErr {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if tt.shouldErr {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+		if got := strings.NewReplacer(r...).Replace(tt.in); got != tt.want {
+			t.Errorf("%s: unexpected substitution, got: %q, want: %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestAddVarKeepsType(t *testing.T) {
+	w := New()
+	w.Vars = map[string]vars{"zones": {Value: `["a"]`, Type: "list", Description: "d"}}
+	w.AddVar("zones", `["b","c"]`)
+	want := vars{Value: `["b","c"]`, Type: "list", Description: "d"}
+	if diff := pretty.Compare(w.Vars["zones"], want); diff != "" {
+		t.Errorf("var does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestResolveEnvVars(t *testing.T) {
+	os.Setenv("DAISY_TEST_ENV_VAR", "from-env")
+	defer os.Unsetenv("DAISY_TEST_ENV_VAR")
+	w := New()
+	w.Vars = map[string]vars{
+		"set":   {Value: "default", ValueFromEnv: "DAISY_TEST_ENV_VAR"},
+		"unset": {Value: "default", ValueFromEnv: "DAISY_TEST_ENV_VAR_DNE"},
+	}
+	if err := w.resolveEnvVars(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := w.Vars["set"].Value; got != "from-env" {
+		t.Errorf("var not read from environment, got: %q", got)
+	}
+	if got := w.Vars["unset"].Value; got != "default" {
+		t.Errorf("var with unset environment variable changed, got: %q", got)
+	}
+
+	w.Vars = map[string]vars{"both": {ValueFromEnv: "DAISY_TEST_ENV_VAR", ValueFromSecret: "projects/p/secrets/s/versions/1"}}
+	if err := w.resolveEnvVars(); err == nil {
+		t.Error("var with both an environment variable and a secret should have returned an error")
+	}
+}
+
+func TestParseYAMLVars(t *testing.T) {
+	tests := []struct {
+		desc, input string
+		want        map[string]vars
+		shouldErr   bool
+	}{
+		{"normal case", "---\n# comment\na: b\nc: 'it''s'\nd: \"x: y\"\ne: f # note\ng:\n", map[string]vars{"a": {Value: "b"}, "c": {Value: "it's"}, "d": {Value: "x: y"}, "e": {Value: "f"}, "g": {Value: ""}}, false},
+		{"nested case", "a:\n  b: c\n", nil, true},
+		{"list case", "a: [b, c]\n", nil, true},
+		{"no key case", "just text\n", nil, true},
+		{"bad quote case", "a: 'b\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseYAMLVars([]byte(tt.input))
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("%s: vars do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+	}
+}
+
+func TestAddVarsFromFile(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	files := map[string]string{
+		"vars.json": `{"zones": ["a", "b"], "size": 10, "name": {"ValueFromEnv": "NAME"}}`,
+		"vars.yaml": "zones: '[\"c\"]'\nsize: 20\n",
+		"bad.json":  `["a"]`,
+	}
+	for f, c := range files {
+		if err := ioutil.WriteFile(filepath.Join(td, f), []byte(c), 0600); err != nil {
+			t.Fatalf("error writing %s: %v", f, err)
+		}
+	}
+
+	tests := []struct {
+		file      string
+		want      map[string]vars
+		shouldErr bool
+	}{
+		{"vars.json", map[string]vars{
+			"zones": {Value: `["a","b"]`, Type: "list", Description: "d"},
+			"size":  {Value: "10", Type: "int"},
+			"name":  {ValueFromEnv: "NAME"},
+		}, false},
+		{"vars.yaml", map[string]vars{
+			"zones": {Value: `["c"]`, Type: "list", Description: "d"},
+			"size":  {Value: "20"},
+		}, false},
+		{"bad.json", nil, true},
+		{"dne.json", nil, true},
+	}
+	for _, tt := range tests {
+		w := New()
+		w.Vars = map[string]vars{"zones": {Type: "list", Description: "d"}}
+		err := w.AddVarsFromFile(filepath.Join(td, tt.file))
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.file, err)
+			continue
+		}
+		if diff := pretty.Compare(w.Vars, tt.want); diff != "" {
+			t.Errorf("%s: vars do not match expectation: (-got +want)\n%s", tt.file, diff)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// verifyCleanup lists the buckets, disks, images, instances and snapshots
+// labeled with the run's ID that are still there after cleanup, and records
+// them in the Leaked resources of the cleanup report. They are deleted again if
+// RetryCleanup is set. Only the top level workflow verifies cleanup, all
+// resources of the run carry its ID.
+func (w *Workflow) verifyCleanup() {
+	if w.parent != nil || !(w.VerifyCleanup || w.RetryCleanup) || w.cleanupDryRun() || w.keepForResume() {
+		return
+	}
+
+	var leaked []*labeledResource
+	for _, p := range w.cleanupProjects() {
+		rs, err := listLabeledResources(context.Background(), w.ComputeClient, w.StorageClient, p)
+		if err != nil {
+			w.logger.Printf("Cleanup: error listing the resources of project %q: %v", p, err)
+			continue
+		}
+		for _, r := range rs {
+			if r.WorkflowID == labelValue(w.id) && r.labels[labelNoCleanup] == "" {
+				leaked = append(leaked, r)
+			}
+		}
+	}
+	instanceLeaks, otherLeaks := splitOrphans(leaked)
+	if w.RetryCleanup {
+		ctx := context.Background()
+		for _, err := range append(deleteOrphans(ctx, w.ComputeClient, w.StorageClient, instanceLeaks), deleteOrphans(ctx, w.ComputeClient, w.StorageClient, otherLeaks)...) {
+			w.logger.Printf("Cleanup: error deleting leaked resource: %v", err)
+		}
+	}
+	rs := sortOrphans(append(instanceLeaks, otherLeaks...))
+	for _, r := range rs {
+		if r.Deleted {
+			w.logger.Printf("Cleanup: %s %q was left behind, deleted it.", r.Type, r.Link)
+		} else {
+			w.logger.Printf("Cleanup: %s %q was left behind.", r.Type, r.Link)
+		}
+	}
+
+	w.cleanupReportMx.Lock()
+	defer w.cleanupReportMx.Unlock()
+	if w.cleanupReport == nil {
+		w.cleanupReport = &CleanupReport{}
+	}
+	w.cleanupReport.Leaked = rs
+}
+
+// cleanupProjects returns the projects w and its child workflows created
+// resources in, and their Projects.
+func (w *Workflow) cleanupProjects() []string {
+	seen := map[string]bool{}
+	w.walkWorkflows(func(cw *Workflow) { seen[cw.Project] = true })
+	for _, rm := range w.resourceMaps() {
+		for _, r := range rm.createdResources() {
+			if parts := strings.SplitN(r.Link, "/", 3); len(parts) == 3 && parts[0] == "projects" {
+				seen[parts[1]] = true
+			}
+		}
+	}
+	var projects []string
+	for p := range seen {
+		if p != "" {
+			projects = append(projects, p)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
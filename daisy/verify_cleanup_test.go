@@ -0,0 +1,159 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+func TestVerifyCleanup(t *testing.T) {
+	createdTime := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	created := createdTime.Format(time.RFC3339)
+	labels := func(id string, kv ...string) map[string]string {
+		l := map[string]string{labelWorkflowName: testWf, labelWorkflowID: id}
+		for i := 0; i < len(kv); i += 2 {
+			l[kv[i]] = kv[i+1]
+		}
+		return l
+	}
+	url := func(link string) string { return "https://www.googleapis.com/compute/v1/" + link }
+
+	var mx sync.Mutex
+	var bucketDeleted bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/b" && r.URL.Query().Get("project") == testProject:
+			fmt.Fprintf(w, `{"items": [{"name": "b1", "timeCreated": %q, "labels": {"daisy-workflow-name": %q, "daisy-workflow-id": "abcdef"}}]}`, created, testWf)
+		case r.Method == "GET":
+			fmt.Fprint(w, `{}`)
+		case r.Method == "DELETE" && r.URL.Path == "/b/b1":
+			mx.Lock()
+			bucketDeleted = true
+			mx.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+	sc, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc        string
+		verify      bool
+		retry       bool
+		wantLeaked  []*OrphanedResource
+		wantDeleted []string
+	}{
+		{"not verified case", false, false, nil, nil},
+		{"verify case", true, false, []*OrphanedResource{
+			{Type: "bucket", Link: "gs://b1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime},
+			{Type: "disk", Link: "projects/p2/zones/z/disks/d1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime},
+			{Type: "instance", Link: "projects/test-project/zones/z/instances/i1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime},
+		}, nil},
+		{"retry case", false, true, []*OrphanedResource{
+			{Type: "bucket", Link: "gs://b1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime, Deleted: true},
+			{Type: "disk", Link: "projects/p2/zones/z/disks/d1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime, Deleted: true},
+			{Type: "instance", Link: "projects/test-project/zones/z/instances/i1", WorkflowName: testWf, WorkflowID: "abcdef", Created: createdTime, Deleted: true},
+		}, []string{"projects/test-project/zones/z/instances/i1", "projects/p2/zones/z/disks/d1"}},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.VerifyCleanup = tt.verify
+		w.RetryCleanup = tt.retry
+		w.StorageClient = sc
+		bucketDeleted = false
+		// A resource created in another project.
+		disks[w].m = map[string]*resource{"d1": {link: "projects/p2/zones/z/disks/d1", created: true, deleted: true}}
+
+		var deleted []string
+		c := w.ComputeClient.(*daisyCompute.TestClient)
+		c.AggregatedListInstancesFn = func(p, _ string) ([]*compute.Instance, error) {
+			if p != testProject {
+				return nil, nil
+			}
+			return []*compute.Instance{
+				{SelfLink: url("projects/test-project/zones/z/instances/i1"), CreationTimestamp: created, Labels: labels("abcdef")},
+				{SelfLink: url("projects/test-project/zones/z/instances/kept"), CreationTimestamp: created, Labels: labels("abcdef", labelNoCleanup, "true")},
+				{SelfLink: url("projects/test-project/zones/z/instances/other"), CreationTimestamp: created, Labels: labels("other")},
+			}, nil
+		}
+		c.AggregatedListDisksFn = func(p, _ string) ([]*compute.Disk, error) {
+			if p != "p2" {
+				return nil, nil
+			}
+			return []*compute.Disk{{SelfLink: url("projects/p2/zones/z/disks/d1"), CreationTimestamp: created, Labels: labels("abcdef")}}, nil
+		}
+		c.ListImagesFn = func(_, _ string) ([]*compute.Image, error) { return nil, nil }
+		c.ListSnapshotsFn = func(_, _ string) ([]*compute.Snapshot, error) { return nil, nil }
+		c.DeleteInstanceFn = func(p, z, n string) error {
+			deleted = append(deleted, "projects/"+p+"/zones/"+z+"/instances/"+n)
+			return nil
+		}
+		c.DeleteDiskFn = func(p, z, n string) error {
+			deleted = append(deleted, "projects/"+p+"/zones/"+z+"/disks/"+n)
+			return nil
+		}
+
+		w.verifyCleanup()
+
+		var leaked []*OrphanedResource
+		if w.cleanupReport != nil {
+			leaked = w.cleanupReport.Leaked
+		}
+		if diff := pretty.Compare(leaked, tt.wantLeaked); diff != "" {
+			t.Errorf("%s: leaked resources do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if diff := pretty.Compare(deleted, tt.wantDeleted); diff != "" {
+			t.Errorf("%s: deleted resources do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if bucketDeleted != tt.retry {
+			t.Errorf("%s: bucket deleted: %t, want: %t", tt.desc, bucketDeleted, tt.retry)
+		}
+	}
+}
+
+func TestCleanupProjects(t *testing.T) {
+	w := testWorkflow()
+	disks[w].m = map[string]*resource{"d1": {link: "projects/p1/zones/z/disks/d1", created: true}}
+	// A subworkflow, in its own project, of an included workflow.
+	iw := w.NewIncludedWorkflow()
+	sw := iw.NewSubWorkflow()
+	sw.Project = "p3"
+	disks[sw].m = map[string]*resource{"d2": {link: "projects/p2/zones/z/disks/d2", created: true}}
+	iw.NewSubWorkflowStep("sub", sw, nil)
+	w.NewIncludeWorkflowStep("include", iw, nil)
+
+	want := []string{"p1", "p2", "p3", testProject}
+	if diff := pretty.Compare(w.cleanupProjects(), want); diff != "" {
+		t.Errorf("projects do not match expectation: (-got +want)\n%s", diff)
+	}
+}
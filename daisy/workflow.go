@@ -16,10 +16,10 @@
 package daisy
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -28,18 +28,33 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/clouderrorreporting/v1beta1"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/logging/v2"
 	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+	"google.golang.org/api/secretmanager/v1"
 )
 
-const defaultTimeout = "10m"
+const (
+	defaultTimeout = "10m"
+	// defaultLogFlushInterval is how often logs are flushed to GCS unless
+	// LogFlushInterval is set.
+	defaultLogFlushInterval = 5 * time.Second
+	// defaultLogBufferSize is how many bytes of logs are buffered before
+	// they are flushed early unless LogBufferSize is set.
+	defaultLogBufferSize = 4096
+)
 
 type gcsLogger struct {
 	client         *storage.Client
@@ -65,21 +80,114 @@ func (l *gcsLogger) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// syncedWriter buffers logs for out, writing them to it on Flush. Writes
+// only buffer, so a slow or failing out, e.g. during a GCS outage, doesn't
+// hold up the workflow.
 type syncedWriter struct {
-	buf *bufio.Writer
-	mx  sync.Mutex
+	out io.Writer
+	// Flush early once size bytes are buffered.
+	size int
+	buf  bytes.Buffer
+	mx   sync.Mutex
+	// flushMx serializes writes to out.
+	flushMx sync.Mutex
+	// err of the last flush, returned by Write until a flush succeeds.
+	err error
+	// kick asks the goroutine of flushEvery for an early flush. kick and
+	// done are set under mx.
+	kick      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// flushEvery flushes l every interval, and when size bytes are buffered,
+// until l is closed.
+func (l *syncedWriter) flushEvery(interval time.Duration) {
+	kick := make(chan struct{}, 1)
+	done := make(chan struct{})
+	l.mx.Lock()
+	l.kick = kick
+	l.done = done
+	l.mx.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Flush()
+			case <-kick:
+				l.Flush()
+			case <-done:
+				return
+			}
+		}
+	}()
 }
 
 func (l *syncedWriter) Write(b []byte) (int, error) {
 	l.mx.Lock()
-	defer l.mx.Unlock()
-	return l.buf.Write(b)
+	l.buf.Write(b)
+	full := l.buf.Len() >= l.size
+	err := l.err
+	kick := l.kick
+	l.mx.Unlock()
+	if full {
+		if kick == nil {
+			return len(b), l.Flush()
+		}
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(b), err
+}
+
+// start sets the out of l, created without one, and flushes l every
+// interval if it's positive. Until then, logs are only buffered.
+func (l *syncedWriter) start(out io.Writer, interval time.Duration) {
+	l.flushMx.Lock()
+	l.out = out
+	l.flushMx.Unlock()
+	if interval > 0 {
+		l.flushEvery(interval)
+	}
 }
 
+// Flush writes the buffered logs to out, it keeps them buffered if l has
+// no out yet.
 func (l *syncedWriter) Flush() error {
+	l.flushMx.Lock()
+	defer l.flushMx.Unlock()
+	if l.out == nil {
+		return nil
+	}
+	l.mx.Lock()
+	b := append([]byte(nil), l.buf.Bytes()...)
+	l.buf.Reset()
+	l.mx.Unlock()
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := l.out.Write(b)
 	l.mx.Lock()
-	defer l.mx.Unlock()
-	return l.buf.Flush()
+	l.err = err
+	l.mx.Unlock()
+	return err
+}
+
+// Close stops the periodic flushes of l and flushes it a final time.
+func (l *syncedWriter) Close() error {
+	l.closeOnce.Do(func() {
+		l.mx.Lock()
+		done := l.done
+		l.mx.Unlock()
+		if done != nil {
+			close(done)
+		}
+	})
+	return l.Flush()
 }
 
 func daisyBkt(ctx context.Context, client *storage.Client, project string) (string, error) {
@@ -104,18 +212,17 @@ type vars struct {
 	Value       string
 	Required    bool
 	Description string
-}
-
-func (v *vars) UnmarshalJSON(b []byte) error {
-	var sv string
-	if err := json.Unmarshal(b, &sv); err == nil {
-		v.Value = sv
-		return nil
-	}
-
-	// We can't unmarshal into vars directly as it would create an infinite loop.
-	type aVars vars
-	return json.Unmarshal(b, &struct{ *aVars }{aVars: (*aVars)(v)})
+	// Type is "string", the default, "int", "bool", "list" or "map". It is
+	// inferred from JSON values that aren't strings.
+	Type string `json:",omitempty"`
+	// ValueFromSecret is a Secret Manager secret version,
+	// "projects/PROJECT/secrets/SECRET/versions/VERSION", Value is read
+	// from when the workflow is populated. The secret is redacted from
+	// logs and Print.
+	ValueFromSecret string `json:",omitempty"`
+	// ValueFromEnv is an environment variable Value is read from, if it
+	// is set, when the workflow is populated.
+	ValueFromEnv string `json:",omitempty"`
 }
 
 // Workflow is a single Daisy workflow workflow.
@@ -130,43 +237,446 @@ type Workflow struct {
 	Project string
 	// Zone to run in.
 	Zone string
+	// Zones of the same region to create instances and disks in, in order,
+	// when their zone is out of capacity.
+	FallbackZones []string `json:",omitempty"`
 	// GCS Path to use for scratch data and write logs/results to.
 	GCSPath string
 	// Path to OAuth credentials file.
 	OAuthPath string `json:",omitempty"`
+	// Enable OS Login on all instances created by this workflow.
+	OSLogin bool `json:",omitempty"`
+	// Metadata set on all instances created by this workflow and the
+	// workflows it includes or runs, e.g. proxy settings. Metadata of the
+	// instances, and of included or sub workflows, takes precedence.
+	CommonInstanceMetadata map[string]string `json:",omitempty"`
+	// Report step failures to Cloud Error Reporting in Project.
+	ErrorReporting bool `json:",omitempty"`
+	// Clear deletion protection of the instances the workflow deletes,
+	// e.g. adopted ones, instead of failing to delete them.
+	ClearDeletionProtection bool `json:",omitempty"`
+	// Also write the serial port output of instances to Cloud Logging, as
+	// logs of their gce_instance resource.
+	SerialCloudLogging bool `json:",omitempty"`
+	// Also write the logs of the workflow to Cloud Logging in Project, as
+	// its "daisy" log, and the serial port output of instances as
+	// SerialCloudLogging does. Included workflows and subworkflows log there
+	// too. Only used on the top level workflow.
+	CloudLogging bool `json:",omitempty"`
+	// Also write the logs of each step to its own object,
+	// ${LOGSPATH}/steps/<step>.log, along with the serial port output of the
+	// instances it creates. Only used on the top level workflow.
+	StepLogs bool `json:",omitempty"`
+	// Write the logs of the workflow to Cloud Logging instead of
+	// ${LOGSPATH}/daisy.log, implies CloudLogging. Only used on the top
+	// level workflow.
+	CloudLoggingOnly bool `json:",omitempty"`
+	// Validation checks to skip, e.g. "zones", see ValidationProjects and
+	// the other Validation constants. Subworkflows skip them too.
+	SkipValidations []string `json:",omitempty"`
+	// Steps not to run, treated as if they succeeded, e.g. to bypass an
+	// expensive test phase during development. Steps that run must not
+	// use resources skipped steps create. Only used on the top level
+	// workflow.
+	SkipSteps []string `json:",omitempty"`
+	// Only log the resources cleanup would delete, don't delete them.
+	CleanupDryRun bool `json:",omitempty"`
+	// After cleanup, list the buckets, disks, images, instances and snapshots
+	// labeled with the run's ID that are still there, and report them in
+	// RunResult.Cleanup.Leaked. RetryCleanup also deletes them again.
+	VerifyCleanup bool `json:",omitempty"`
+	RetryCleanup  bool `json:",omitempty"`
+	// Timeout of the whole run, e.g. "2h". The workflow is canceled and
+	// cleaned up once it is exceeded, the error tells which steps were
+	// still running. Only used on the top level workflow.
+	Timeout string `json:",omitempty"`
+	// Hash the inputs of the workflow, its steps, vars, sources and the
+	// existing images it uses, and label the images it creates with the
+	// hash, see RunResult.ManifestHash. Only used on the top level workflow.
+	HashManifest bool `json:",omitempty"`
+	// Write the progress of the run to the scratch path, so a failed run
+	// can be resumed with Resume. Cleanup of a failed run keeps the
+	// resources it created for the resumed run. Only used on the top level
+	// workflow.
+	Checkpoint bool `json:",omitempty"`
+	// BigQuery table to stream step results to, "[project.]dataset.table".
+	// Only used on the top level workflow.
+	BigQueryTable string `json:",omitempty"`
+	// Projects SubWorkflow steps with a Sandbox can run in. Each sandboxed
+	// SubWorkflow leases a project no other sandbox in the workflow uses.
+	SandboxProjects []string `json:",omitempty"`
+	// Folder, "folders/FOLDER_ID", whose active projects are used as the
+	// SandboxProjects if none are given.
+	SandboxFolder string `json:",omitempty"`
 	// Sources used by this workflow, map of destination to source.
 	Sources map[string]string `json:",omitempty"`
 	// Vars defines workflow variables, substitution is done at Workflow run time.
-	Vars  map[string]vars `json:",omitempty"`
+	Vars map[string]vars `json:",omitempty"`
+	// Outputs of a successful run, map of name to a Go text/template using
+	// the functions of TemplatedFile templates, e.g. `{{image "image"}}`.
+	// They are written to outputs.json in the outs path and returned in
+	// RunResult.Outputs. Outputs of nested workflows are ignored.
+	Outputs map[string]string `json:",omitempty"`
+	// Steps by name. Use NewStep to add steps from concurrent goroutines,
+	// e.g. from the populate of a step, as it synchronizes access to Steps.
 	Steps map[string]*Step
-	// Map of steps to their dependencies.
+	// Map of steps to their dependencies. Use AddDependency to add
+	// dependencies from concurrent goroutines.
 	Dependencies map[string][]string
+	// stepsMx synchronizes NewStep, AddDependency and populate's reading
+	// of Steps.
+	stepsMx sync.Mutex
+	// First error of a StepBuilder, returned by Validate.
+	buildErr error
+	// Named subsets of Steps, e.g. "build" or "publish", map of entrypoint
+	// name to the steps it runs. The steps these depend on run too.
+	Entrypoints map[string][]string `json:",omitempty"`
+	// Entrypoint selects the entrypoint to run, all steps run if it is not
+	// set.
+	Entrypoint string `json:",omitempty"`
+	// Steps to run, with the steps they depend on, set by RunTargets.
+	targets []string
+	// How often logs are flushed to GCS, "5s" by default. Logs are also
+	// flushed when the buffer fills up, and before Run returns. Only used on
+	// the top level workflow.
+	LogFlushInterval string `json:",omitempty"`
+	// Logs are flushed to GCS early once this many bytes are buffered, 4096
+	// by default. Only used on the top level workflow.
+	LogBufferSize int `json:",omitempty"`
+	// What to do if the GCS log can't be written when the workflow starts,
+	// GCSLoggingFallback (the default) or GCSLoggingFail. Only used on the
+	// top level workflow.
+	GCSLoggingPolicy string `json:",omitempty"`
+	// Format of the logs written to stdout, GCS and the added log writers,
+	// LogFormatText (the default) or LogFormatJSON. Only used on the top
+	// level workflow.
+	LogFormat string `json:",omitempty"`
+	// Pub/Sub topic to publish an Event to on each state change of the run
+	// and its steps, and for each resource created, as JSON. Either a topic
+	// name in Project or "projects/PROJECT/topics/TOPIC". Only used on the
+	// top level workflow.
+	PubSubTopic string `json:",omitempty"`
+	// Which logs are written, VerbosityInfo (the default), VerbosityDebug
+	// to also log the API calls and the result of substituting variables,
+	// or VerbosityError to only log errors. Only used on the top level
+	// workflow.
+	Verbosity string `json:",omitempty"`
+	// Scheduler, if set, decides which ready steps start. Subworkflows and
+	// included workflows use their parent's Scheduler.
+	Scheduler Scheduler `json:"-"`
+	// Executor, if set, runs the steps in place of daisy's default, which
+	// runs each step in a goroutine as the Scheduler allows. Subworkflows
+	// and included workflows use their parent's Executor.
+	Executor Executor `json:"-"`
+	// Metrics, if set, collects metrics of the runs of the workflow, e.g.
+	// to serve them to Prometheus. Subworkflows and included workflows use
+	// their parent's Metrics.
+	Metrics *Metrics `json:"-"`
+	// Maximum number of steps to run at once, in the workflow and its
+	// subworkflows, 0 for no limit. Ready steps beyond the limit wait for
+	// running steps to finish. Only used on the top level workflow.
+	MaxParallelSteps int `json:",omitempty"`
+	// Policy, if set, vets each GCE resource before it is created.
+	// Subworkflows and included workflows use their parent's Policy.
+	Policy Policy `json:"-"`
 
 	// Working fields.
 	autovars       map[string]string
-	workflowDir    string
-	parent         *Workflow
-	bucket         string
-	scratchPath    string
-	sourcesPath    string
-	logsPath       string
-	outsPath       string
-	username       string
-	gcsLogging     bool
-	gcsLogWriter   *syncedWriter
+	customAutovars map[string]func(*Workflow) (string, error)
+	// Outputs published by the steps, by step name, see Step.setOutput.
+	stepOutputs   map[string]map[string]string
+	stepOutputsMx sync.Mutex
+	workflowDir   string
+	parent        *Workflow
+	bucket        string
+	scratchPath   string
+	sourcesPath   string
+	logsPath      string
+	outsPath      string
+	username      string
+	gcsLogging    bool
+	gcsLogWriter  *syncedWriter
+	// The GCS log gcsLogWriter writes to once the workflow runs, see
+	// startGCSLogs, and how often.
+	gcsLog         *gcsLogger
+	gcsLogInterval time.Duration
+	// Set once the run claimed scratchPath, nothing is written to its logs
+	// path before.
+	scratchClaimed bool
+	cloudLogWriter *syncedWriter
+	stepLogs       *stepLogs
+	// The logs of the step a worker runs, see ServeWorker.
+	remoteLogs     *remoteLogs
 	ComputeClient  compute.Client  `json:"-"`
 	StorageClient  *storage.Client `json:"-"`
 	id             string
-	logger         *log.Logger
+	started        time.Time
+	logger         *logger
 	cleanupHooks   []func() error
 	cleanupHooksMx sync.Mutex
+	cancelReason   string
+	cancelMx       sync.Mutex
+	failedStep     string
+	// Steps stop on alwaysCancel instead of Cancel while RunAlways steps
+	// run after the workflow stopped, see cancelChan.
+	alwaysCancel chan struct{}
+	completed    []string
+	completedMx  sync.Mutex
+	// Content hashes recorded by VerifyContentHashes steps, by Name.
+	contentHashes   map[string]*contentHashRecord
+	contentHashesMx sync.Mutex
+	// Sandbox projects leased by SubWorkflow steps.
+	sandboxLeases   map[string]bool
+	sandboxLeasesMx sync.Mutex
+	// Compute and storage API calls made by the workflow's clients.
+	apiCalls apiMetrics
+	// Mutating compute and storage API calls made by the workflow's
+	// clients, see writeAudit.
+	audit auditLog
+	// What cleanup of the workflow and its subworkflows deleted and kept.
+	cleanupReport   *CleanupReport
+	cleanupReportMx sync.Mutex
+	// Hooks called around each step, see AddStepHook.
+	stepHooks   []func(context.Context, *Step, StepEvent) error
+	stepHooksMx sync.Mutex
+	// Functions called on step state transitions, see OnProgress. Calls
+	// are serialized by the root workflow's progressMx.
+	progressFns   []func(StepProgress)
+	progressFnsMx sync.Mutex
+	progressMx    sync.Mutex
+	// Failures of ContinueOnError steps, recorded on the root workflow.
+	stepFailures   []*StepFailure
+	stepFailuresMx sync.Mutex
+	// Parsed Outputs, and the rendered Outputs of a successful run.
+	outputTmpls map[string]*template.Template
+	outputs     map[string]string
+	outputsMx   sync.Mutex
+	// Writers added with AddLogWriter.
+	logWriters   []io.Writer
+	logWritersMx sync.Mutex
+	// Set by SetLogger.
+	customLogger Logger
+	// Parsed Timeout.
+	timeout time.Duration
+	// The manifest taken before substitution if HashManifest is set, and
+	// its hash once the workflow is validated.
+	manifest     *manifest
+	manifestHash string
+	// Why logs aren't written to GCS, set if GCSLoggingPolicy fell back.
+	logsFallback   string
+	logsFallbackMx sync.Mutex
+	// Results of the steps that ran, recorded on the root workflow.
+	stepResults   []*StepResult
+	stepResultsMx sync.Mutex
+	// Validation checks skipped, recorded on the root workflow.
+	skippedValidations   []string
+	skippedValidationsMx sync.Mutex
+
+	errorReportingClient *clouderrorreporting.Service
+	// Publishes the events of the run, see PubSubTopic.
+	pubsubClient *pubsub.Service
+	pubsubTopic  string
+	events       *eventPublisher
+	// Writes serial port output to Cloud Logging, see SerialCloudLogging.
+	loggingClient *logging.Service
+	// Reads the secrets of vars with a ValueFromSecret.
+	secretManagerClient *secretmanager.Service
+	// Secrets of vars, redacted from logs, used on the root workflow.
+	redactor redactor
+	// Step results are written to bigQueryTable, see recordStepResult.
+	bigQueryClient *bigquery.Service
+	bigQueryTable  *bigquery.TableReference
+	bigQueryWG     sync.WaitGroup
+	// Semaphore of MaxParallelSteps, see acquireStepSlot.
+	stepSlotsSem  chan struct{}
+	stepSlotsOnce sync.Once
+	// Uploads of Sources, by destination, see startSourceUploads.
+	sourceUploads map[string]*sourceUpload
+	// The run being resumed, see Resume.
+	resumeID     string
+	resumed      *checkpoint
+	checkpointMx sync.Mutex
+}
+
+// qualifiedName returns the name of w prefixed by the names of its parents,
+// e.g. "parent.sub".
+func (w *Workflow) qualifiedName() string {
+	name := w.Name
+	for parent := w.parent; parent != nil; parent = parent.parent {
+		name = parent.Name + "." + name
+	}
+	return name
+}
+
+// root returns the top level workflow w is part of.
+func (w *Workflow) root() *Workflow {
+	for w.parent != nil {
+		w = w.parent
+	}
+	return w
 }
 
+// AddVar sets the value of var k, keeping the Type of a declared var. The
+// value of list and map vars is JSON. It replaces the ValueFromSecret and
+// ValueFromEnv of the var.
 func (w *Workflow) AddVar(k, v string) {
 	if w.Vars == nil {
 		w.Vars = map[string]vars{}
 	}
-	w.Vars[k] = vars{Value: v}
+	vr := w.Vars[k]
+	vr.Value = v
+	vr.ValueFromSecret = ""
+	vr.ValueFromEnv = ""
+	w.Vars[k] = vr
+}
+
+// CancelWithReason cancels the workflow, recording reason as the cause.
+// It is safe to call multiple times, only the first reason is kept.
+// Nested workflows share the Cancel channel of the top level workflow, the
+// reason is recorded there. The workflows of steps with ContinueOnError
+// have a Cancel channel of their own, see cancelRoot.
+func (w *Workflow) CancelWithReason(reason string) {
+	root := w.cancelRoot()
+	root.cancelMx.Lock()
+	defer root.cancelMx.Unlock()
+	select {
+	case <-w.Cancel:
+		return
+	default:
+	}
+	root.cancelReason = reason
+	close(w.Cancel)
+}
+
+// cancelOnDone cancels w, with the cause of ctx's cancellation as reason,
+// once ctx is done, until the returned stop func is called. stop returns the
+// cause if ctx canceled w.
+func (w *Workflow) cancelOnDone(ctx context.Context) (stop func() error) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	var cause error
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			cause = context.Cause(ctx)
+			w.logger.Printf("Workflow canceled: %v", cause)
+			w.CancelWithReason(cause.Error())
+		case <-w.Cancel:
+		case <-done:
+		}
+	}()
+	return func() error {
+		close(done)
+		<-stopped
+		return cause
+	}
+}
+
+// timeoutError is the error of a run of w that exceeded its Timeout.
+func (w *Workflow) timeoutError() error {
+	var running []string
+	for name, st := range w.StepStates() {
+		if st == StepRunning {
+			running = append(running, name)
+		}
+	}
+	if len(running) == 0 {
+		return fmt.Errorf("workflow timed out after %s", w.timeout)
+	}
+	sort.Strings(running)
+	return fmt.Errorf("workflow timed out after %s, steps still running: %s", w.timeout, strings.Join(running, ", "))
+}
+
+// getCancelReason returns the reason the workflow, or one of its
+// parents, was canceled with.
+func (w *Workflow) getCancelReason() string {
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.cancelMx.Lock()
+		r := wf.cancelReason
+		wf.cancelMx.Unlock()
+		if r != "" {
+			return r
+		}
+	}
+	return ""
+}
+
+// cancelRoot returns the outermost workflow sharing the Cancel channel of w:
+// the root workflow, or the workflow of the closest parent step with
+// ContinueOnError, so that its failures don't cancel the steps around it.
+func (w *Workflow) cancelRoot() *Workflow {
+	for w.parent != nil && w.parent.Cancel == w.Cancel {
+		w = w.parent
+	}
+	return w
+}
+
+// scopeCancel gives cw, the workflow of a step of w with ContinueOnError,
+// and the workflows nested in it, a Cancel channel of their own, which is
+// closed when w is canceled, until the returned stop func is called.
+func (w *Workflow) scopeCancel(cw *Workflow) (stop func()) {
+	cw.replaceCancel(cw.Cancel, make(chan struct{}))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-w.cancelChan():
+			cw.CancelWithReason(w.getCancelReason())
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// replaceCancel sets the Cancel channel of w, and of the workflows nested in
+// it which share old, to c.
+func (w *Workflow) replaceCancel(old, c chan struct{}) {
+	if w.Cancel != old {
+		return
+	}
+	w.Cancel = c
+	for _, cw := range w.childWorkflows() {
+		cw.replaceCancel(old, c)
+	}
+}
+
+// cancelChan returns the channel the steps of w stop on: Cancel, or while
+// w or a parent runs its RunAlways steps after the workflow stopped, a
+// channel of their own, so that they run to the end.
+func (w *Workflow) cancelChan() <-chan struct{} {
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.cancelMx.Lock()
+		c := wf.alwaysCancel
+		wf.cancelMx.Unlock()
+		if c != nil {
+			return c
+		}
+	}
+	return w.Cancel
+}
+
+// stepFailed cancels the workflow right away on the failure of s, so that
+// running steps stop waiting without needing the DAG traversal to return.
+// Only the first failure is recorded, on the cancelRoot of w.
+func (w *Workflow) stepFailed(s *Step, err error) {
+	root := w.cancelRoot()
+	root.cancelMx.Lock()
+	if root.failedStep == "" {
+		root.failedStep = s.name
+	}
+	root.cancelMx.Unlock()
+	root.CancelWithReason(err.Error())
+}
+
+// cancelCause describes why w was canceled, for steps that stop waiting.
+func (w *Workflow) cancelCause() string {
+	root := w.cancelRoot()
+	root.cancelMx.Lock()
+	defer root.cancelMx.Unlock()
+	if root.failedStep != "" {
+		return fmt.Sprintf("canceled due to failure of step %q", root.failedStep)
+	}
+	return "workflow canceled"
 }
 
 func (w *Workflow) addCleanupHook(hook func() error) {
@@ -177,49 +687,159 @@ func (w *Workflow) addCleanupHook(hook func() error) {
 
 // Validate runs validation on the workflow.
 func (w *Workflow) Validate(ctx context.Context) error {
+	if err := w.buildError(); err != nil {
+		w.CancelWithReason("")
+		return fmt.Errorf("error building workflow: %v", err)
+	}
+
 	if err := w.validateRequiredFields(); err != nil {
-		close(w.Cancel)
+		w.CancelWithReason("")
 		return fmt.Errorf("error validating workflow: %v", err)
 	}
 
 	if err := w.populate(ctx); err != nil {
-		close(w.Cancel)
+		w.CancelWithReason("")
 		return fmt.Errorf("error populating workflow: %v", err)
 	}
 
 	w.logger.Print("Validating workflow")
 	if err := w.validate(ctx); err != nil {
-		w.logger.Printf("Error validating workflow: %v", err)
-		close(w.Cancel)
+		w.logger.Errorf("Error validating workflow: %v", err)
+		w.CancelWithReason("")
 		return err
 	}
+	// Escaped substitutions are left as they are until validation found
+	// no unresolved ones.
+	w.unescapeSubstitutions()
+	if w.manifest != nil {
+		if err := w.populateManifestHash(ctx); err != nil {
+			w.CancelWithReason("")
+			return fmt.Errorf("error hashing workflow manifest: %v", err)
+		}
+		w.logger.Printf("Workflow manifest hash: %s", w.manifestHash)
+	}
 	w.logger.Print("Validation Complete")
 	return nil
 }
 
-// Run runs a workflow.
+// Run runs a workflow. Canceling ctx, or reaching its deadline, cancels
+// the workflow as closing Cancel does: running steps stop, the workflow's
+// resources are cleaned up and Run returns the cause of ctx's cancellation.
 func (w *Workflow) Run(ctx context.Context) error {
 	w.gcsLogging = true
+	defer w.closeLogs()
 	if err := w.Validate(ctx); err != nil {
 		return err
 	}
+	return w.runValidated(ctx)
+}
+
+// Outcomes of a run, see runOutcome.
+const (
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+	runCanceled  = "canceled"
+)
+
+// runOutcome returns the outcome of a run of w that returned err. cause is
+// the cause of the cancellation of the context of the run, if it was.
+func (w *Workflow) runOutcome(err, cause error) string {
+	switch {
+	case cause != nil:
+		return runCanceled
+	case err != nil:
+		return runFailed
+	}
+	select {
+	case <-w.Cancel:
+		return runCanceled
+	default:
+		return runSucceeded
+	}
+}
+
+// runValidated runs w after Validate succeeded.
+func (w *Workflow) runValidated(ctx context.Context) (err error) {
+	var cause error
+	finishEvents := w.startEvents()
+	defer func() {
+		outcome := w.runOutcome(err, cause)
+		finishEvents(outcome, err)
+		w.metrics().countRun(outcome)
+	}()
+	if w.timeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		t := time.AfterFunc(w.timeout, func() { cancel(w.timeoutError()) })
+		defer func() {
+			t.Stop()
+			cancel(nil)
+		}()
+	}
+	stop := w.cancelOnDone(ctx)
+	defer func() {
+		// The cause is returned even if a step failed, e.g. as the deadline
+		// interrupted its API calls.
+		if cause = stop(); cause != nil {
+			err = fmt.Errorf("workflow canceled: %v", cause)
+		}
+	}()
 	defer w.cleanup()
+	// Sources still uploading, e.g. as the workflow failed before the steps
+	// using them ran, or as ctx was canceled, are stopped before cleanup.
+	uploadCtx, cancelUploads := context.WithCancel(ctx)
+	defer func() {
+		cancelUploads()
+		w.waitSourceUploads()
+	}()
 	w.logger.Println("Using the GCS path", "gs://"+path.Join(w.bucket, w.scratchPath))
-
-	w.logger.Print("Uploading sources")
-	if err := w.uploadSources(ctx); err != nil {
-		w.logger.Printf("Error uploading sources: %v", err)
-		close(w.Cancel)
+	if err := w.claimScratchPath(ctx); err != nil {
+		w.logger.Print(err)
+		w.CancelWithReason(err.Error())
 		return err
 	}
+	w.scratchClaimed = true
+	// The logs are only written once the scratch path is claimed, its logs
+	// could be those of another run otherwise.
+	if err := w.startGCSLogs(); err != nil {
+		w.logger.Print(err)
+		w.CancelWithReason(err.Error())
+		return err
+	}
+	if w.resumed != nil {
+		w.logger.Printf("Resuming run %q", w.id)
+		w.adoptResources()
+	}
+
+	if w.bigQueryClient != nil {
+		if err := ensureStepResultsTable(w.bigQueryClient, w.bigQueryTable); err != nil {
+			w.logger.Errorf("Error setting up step results table: %v", err)
+			w.CancelWithReason(err.Error())
+			return err
+		}
+	}
+
+	w.logger.Print("Uploading sources")
+	w.startSourceUploads(uploadCtx)
 	w.logger.Print("Running workflow")
 	if err := w.run(ctx); err != nil {
-		w.logger.Printf("Error running workflow: %v", err)
-		select {
-		case <-w.Cancel:
-		default:
-			close(w.Cancel)
-		}
+		w.logger.Errorf("Error running workflow: %v", err)
+		w.CancelWithReason(err.Error())
+		return err
+	}
+	if err := w.waitSourceUploads(); err != nil {
+		w.logger.Errorf("Error uploading sources: %v", err)
+		w.CancelWithReason(err.Error())
+		return err
+	}
+	select {
+	case <-w.Cancel:
+		return nil
+	default:
+	}
+	if err := w.writeOutputs(ctx); err != nil {
+		w.logger.Errorf("Error writing outputs: %v", err)
+		w.CancelWithReason(err.Error())
 		return err
 	}
 	return nil
@@ -232,14 +852,27 @@ func (w *Workflow) String() string {
 
 func (w *Workflow) cleanup() {
 	w.logger.Printf("Workflow %q cleaning up (this may take up to 2 minutes.", w.Name)
+	w.reportCleanup()
 	for _, hook := range w.cleanupHooks {
 		if err := hook(); err != nil {
-			w.logger.Printf("Error returned from cleanup hook: %s", err)
+			w.logger.Errorf("Error returned from cleanup hook: %s", err)
 		}
 	}
+	w.verifyCleanup()
+	if w.parent == nil {
+		w.logRunSummary()
+		w.writeAudit()
+	}
+	w.waitStepResults()
 	if w.gcsLogWriter != nil {
 		w.gcsLogWriter.Flush()
 	}
+	if w.cloudLogWriter != nil {
+		w.cloudLogWriter.Flush()
+	}
+	if w.stepLogs != nil {
+		w.stepLogs.Flush()
+	}
 }
 
 func (w *Workflow) genName(n string) string {
@@ -268,28 +901,56 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) error {
 	}
 	timeout, err := time.ParseDuration(s.Timeout)
 	if err != nil {
-		return err
+		return s.wrapPopulateError(err)
 	}
 	s.timeout = timeout
 
 	var step stepImpl
 	if step, err = s.stepImpl(); err != nil {
-		return err
+		return s.wrapPopulateError(err)
+	}
+	if err := step.populate(ctx, s); err != nil {
+		return s.wrapPopulateError(err)
 	}
-	return step.populate(ctx, s)
+	return nil
 }
 
 func (w *Workflow) populate(ctx context.Context) error {
 	var err error
 	if w.ComputeClient == nil {
-		w.ComputeClient, err = compute.NewClient(ctx, option.WithCredentialsFile(w.OAuthPath))
+		opts, err := w.APIClientOptions(ctx, option.WithCredentialsFile(w.OAuthPath))
+		if err != nil {
+			return err
+		}
+		w.ComputeClient, err = compute.NewClient(ctx, opts...)
 		if err != nil {
 			return err
 		}
 	}
 
 	if w.StorageClient == nil {
-		w.StorageClient, err = storage.NewClient(ctx, option.WithCredentialsFile(w.OAuthPath))
+		opts, err := w.APIClientOptions(ctx, option.WithCredentialsFile(w.OAuthPath))
+		if err != nil {
+			return err
+		}
+		w.StorageClient, err = storage.NewClient(ctx, opts...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.ErrorReporting && w.errorReportingClient == nil {
+		w.errorReportingClient, err = newErrorReportingClient(ctx, w.OAuthPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.CloudLoggingOnly && w.parent == nil {
+		w.CloudLogging = true
+	}
+	if (w.SerialCloudLogging || w.CloudLogging) && w.loggingClient == nil {
+		w.loggingClient, err = newLoggingClient(ctx, w.OAuthPath)
 		if err != nil {
 			return err
 		}
@@ -303,30 +964,58 @@ func (w *Workflow) populate(ctx context.Context) error {
 		w.GCSPath = "gs://" + dBkt
 	}
 
-	w.id = randString(5)
+	if w.resumeID != "" && w.resumed == nil {
+		if w.resumed, err = w.loadCheckpoint(ctx); err != nil {
+			return err
+		}
+	}
+
+	w.id = newRunID()
 	now := time.Now().UTC()
 	w.username = getUser()
+	if run := w.resumedRun(); run != nil {
+		w.id = run.ID
+		now = run.Started
+		if run.Username != "" {
+			w.username = run.Username
+		}
+	}
+	w.started = now
 
 	cwd, _ := os.Getwd()
 
 	w.autovars = map[string]string{
-		"ID":        w.id,
-		"DATE":      now.Format("20060102"),
-		"DATETIME":  now.Format("20060102150405"),
-		"TIMESTAMP": strconv.FormatInt(now.Unix(), 10),
-		"USERNAME":  w.username,
-		"WFDIR":     w.workflowDir,
-		"CWD":       cwd,
+		"ID":                w.id,
+		"DATE":              now.Format("20060102"),
+		"DATETIME":          now.Format("20060102150405"),
+		"TIMESTAMP":         strconv.FormatInt(now.Unix(), 10),
+		"TIMESTAMP_RFC3339": now.Format(time.RFC3339),
+		"USERNAME":          w.username,
+		"WFDIR":             w.workflowDir,
+		"CWD":               cwd,
 	}
 
 	var replacements []string
 	for k, v := range w.autovars {
 		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
 	}
-	for k, v := range w.Vars {
-		replacements = append(replacements, fmt.Sprintf("${%s}", k), v.Value)
+	if err := w.resolveEnvVars(); err != nil {
+		return err
+	}
+	if err := w.resolveSecretVars(ctx); err != nil {
+		return err
+	}
+	vr, err := varReplacements(w.Vars)
+	if err != nil {
+		return err
 	}
-	substitute(reflect.ValueOf(w).Elem(), strings.NewReplacer(replacements...))
+	replacements = append(replacements, vr...)
+	if w.HashManifest && w.parent == nil {
+		if w.manifest, err = w.newManifest(); err != nil {
+			return fmt.Errorf("error taking workflow manifest: %v", err)
+		}
+	}
+	substitute(reflect.ValueOf(w).Elem(), substitutionReplacer(replacements...))
 
 	// Set up GCS paths.
 	bkt, p, err := splitGCSPath(w.GCSPath)
@@ -334,7 +1023,7 @@ func (w *Workflow) populate(ctx context.Context) error {
 		return err
 	}
 	w.bucket = bkt
-	w.scratchPath = path.Join(p, fmt.Sprintf("daisy-%s-%s-%s", w.Name, now.Format("20060102-15:04:05"), w.id))
+	w.scratchPath = w.newScratchPath(p, now)
 	w.sourcesPath = path.Join(w.scratchPath, "sources")
 	w.logsPath = path.Join(w.scratchPath, "logs")
 	w.outsPath = path.Join(w.scratchPath, "outs")
@@ -344,61 +1033,267 @@ func (w *Workflow) populate(ctx context.Context) error {
 	// value for those fields.
 	w.autovars["NAME"] = w.Name
 	w.autovars["ZONE"] = w.Zone
+	w.autovars["REGION"] = getRegionFromZone(w.Zone)
 	w.autovars["PROJECT"] = w.Project
 	w.autovars["GCSPATH"] = w.GCSPath
 	w.autovars["SCRATCHPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.scratchPath)
 	w.autovars["SOURCESPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.sourcesPath)
 	w.autovars["LOGSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.logsPath)
 	w.autovars["OUTSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.outsPath)
+	if err := w.populateCustomAutovars(); err != nil {
+		return err
+	}
+	if err := w.populateProjectNumber(); err != nil {
+		return err
+	}
 
 	replacements = []string{}
 	for k, v := range w.autovars {
 		replacements = append(replacements, fmt.Sprintf("${%s}", k), v)
 	}
-	substitute(reflect.ValueOf(w).Elem(), strings.NewReplacer(replacements...))
+	substitute(reflect.ValueOf(w).Elem(), substitutionReplacer(replacements...))
+	if err := substituteExprs(reflect.ValueOf(w).Elem(), append(vr, replacements...)); err != nil {
+		return err
+	}
 
-	w.populateLogger(ctx)
+	if w.Timeout != "" && w.parent == nil {
+		if w.timeout, err = time.ParseDuration(w.Timeout); err != nil {
+			return fmt.Errorf("error parsing workflow Timeout %q: %v", w.Timeout, err)
+		}
+	}
 
-	for name, s := range w.Steps {
-		s.name = name
-		s.w = w
-		if err := w.populateStep(ctx, s); err != nil {
+	if w.BigQueryTable != "" {
+		if w.bigQueryTable, err = parseBigQueryTable(w.BigQueryTable, w.Project); err != nil {
 			return err
 		}
+		if w.bigQueryClient == nil {
+			if w.bigQueryClient, err = newBigQueryClient(ctx, w.OAuthPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	if w.PubSubTopic != "" && w.parent == nil {
+		if err := w.populatePubSubTopic(); err != nil {
+			return err
+		}
+		if w.pubsubClient == nil {
+			if w.pubsubClient, err = newPubSubClient(ctx, w.OAuthPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.stepsMx.Lock()
+	w.mergeAllDependsOn()
+	w.stepsMx.Unlock()
+
+	if err := w.selectEntrypoint(); err != nil {
+		return err
+	}
+	if err := w.selectTargets(); err != nil {
+		return err
+	}
+
+	if err := w.populateLogger(ctx); err != nil {
+		return err
+	}
+	w.logSubstitutions()
+
+	// Steps may add steps while they populate, those are populated too.
+	populated := map[string]bool{}
+	for {
+		var steps []*Step
+		w.stepsMx.Lock()
+		for name, s := range w.Steps {
+			if !populated[name] {
+				populated[name] = true
+				s.name = name
+				s.w = w
+				w.mergeDependsOn(name, s)
+				steps = append(steps, s)
+			}
+		}
+		w.stepsMx.Unlock()
+		if len(steps) == 0 {
+			break
+		}
+		for _, s := range steps {
+			if err := w.populateStep(ctx, s); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (w *Workflow) populateLogger(ctx context.Context) {
-	if w.logger != nil {
+// mergeDependsOn adds the DependsOn of the step name to Dependencies.
+// stepsMx must be held.
+func (w *Workflow) mergeDependsOn(name string, s *Step) {
+	if s == nil || len(s.DependsOn) == 0 {
 		return
 	}
-	name := w.Name
-	for parent := w.parent; parent != nil; parent = parent.parent {
-		name = parent.Name + "." + name
+	if w.Dependencies == nil {
+		w.Dependencies = map[string][]string{}
+	}
+	for _, dep := range s.DependsOn {
+		if !strIn(dep, w.Dependencies[name]) {
+			w.Dependencies[name] = append(w.Dependencies[name], dep)
+		}
 	}
-	prefix := fmt.Sprintf("[%s]: ", name)
+}
+
+// mergeAllDependsOn merges the DependsOn of all steps into Dependencies.
+// stepsMx must be held.
+func (w *Workflow) mergeAllDependsOn() {
+	for name, s := range w.Steps {
+		w.mergeDependsOn(name, s)
+	}
+}
+
+func (w *Workflow) populateLogger(ctx context.Context) error {
+	interval := defaultLogFlushInterval
+	if w.LogFlushInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(w.LogFlushInterval); err != nil {
+			return fmt.Errorf("invalid LogFlushInterval: %v", err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("LogFlushInterval must be positive, got %q", w.LogFlushInterval)
+		}
+	}
+	if w.LogBufferSize < 0 {
+		return fmt.Errorf("LogBufferSize can't be negative, got %d", w.LogBufferSize)
+	}
+	if w.GCSLoggingPolicy != "" && !strIn(w.GCSLoggingPolicy, gcsLoggingPolicies) {
+		return fmt.Errorf("unknown GCSLoggingPolicy %q, must be one of %q", w.GCSLoggingPolicy, gcsLoggingPolicies)
+	}
+	if w.LogFormat != "" && !strIn(w.LogFormat, logFormats) {
+		return fmt.Errorf("unknown LogFormat %q, must be one of %q", w.LogFormat, logFormats)
+	}
+	if w.Verbosity != "" && !strIn(w.Verbosity, verbosities) {
+		return fmt.Errorf("unknown Verbosity %q, must be one of %q", w.Verbosity, verbosities)
+	}
+	if w.logger != nil {
+		return nil
+	}
+	prefix := fmt.Sprintf("[%s]: ", w.qualifiedName())
 	flags := log.Ldate | log.Ltime
-	writers := []io.Writer{os.Stdout}
+	size := w.LogBufferSize
+	if size == 0 {
+		size = defaultLogBufferSize
+	}
 	if w.gcsLogWriter == nil {
+		if !w.gcsLogging || w.CloudLoggingOnly {
+			w.gcsLogWriter = &syncedWriter{out: ioutil.Discard, size: size}
+		} else {
+			// The logs are still written once ctx is canceled. They are
+			// buffered until the workflow runs, Validate doesn't write to
+			// the bucket.
+			w.gcsLog = &gcsLogger{client: w.StorageClient, bucket: w.bucket, object: path.Join(w.logsPath, "daisy.log"), ctx: logWritesContext(ctx)}
+			w.gcsLogInterval = interval
+			w.gcsLogWriter = &syncedWriter{size: size}
+		}
+	}
+	if w.CloudLogging && w.parent == nil && w.cloudLogWriter == nil {
 		if !w.gcsLogging {
-			w.gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(ioutil.Discard)}
+			w.cloudLogWriter = &syncedWriter{out: ioutil.Discard, size: size}
+		} else {
+			w.cloudLogWriter = &syncedWriter{out: &cloudLogWriter{client: w.loggingClient, project: w.Project, runID: w.id}, size: size}
+			w.cloudLogWriter.flushEvery(interval)
 		}
-		w.gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(&gcsLogger{client: w.StorageClient, bucket: w.bucket, object: path.Join(w.logsPath, "daisy.log"), ctx: ctx})}
-		go func() {
-			for {
-				time.Sleep(5 * time.Second)
-				w.gcsLogWriter.Flush()
-			}
-		}()
-		writers = append(writers, w.gcsLogWriter)
 	}
-	w.logger = log.New(io.MultiWriter(writers...), prefix, flags)
+	if w.StepLogs && w.gcsLogging && w.parent == nil && w.stepLogs == nil {
+		w.stepLogs = newStepLogs(logWritesContext(ctx), w, interval, size)
+	}
+	custom := w.customLoggerOf()
+	lw := &logWriters{}
+	if custom == nil {
+		lw.add("stdout", os.Stdout)
+	}
+	lw.add("GCS", w.gcsLogWriter)
+	// Writers added to parents come first.
+	var added []io.Writer
+	for wf := w; wf != nil; wf = wf.parent {
+		wf.logWritersMx.Lock()
+		added = append(append([]io.Writer(nil), wf.logWriters...), added...)
+		wf.logWritersMx.Unlock()
+	}
+	for i, out := range added {
+		lw.add(fmt.Sprintf("log writer %d", i), out)
+	}
+	rw := &redactingWriter{out: lw, r: &w.root().redactor}
+	var out Logger = &textLogger{log.New(rw, prefix, flags)}
+	if w.root().LogFormat == LogFormatJSON {
+		out = &jsonLogger{out: rw}
+	}
+	if w.stepLogs != nil {
+		out = multiLogger{out, stepLogger{w.stepLogs}}
+	}
+	if r := w.root().remoteLogs; r != nil {
+		out = multiLogger{out, r}
+	}
+	if w.cloudLogWriter != nil {
+		out = multiLogger{out, &jsonLogger{out: &redactingWriter{out: w.cloudLogWriter, r: &w.root().redactor}}}
+	}
+	if custom != nil {
+		out = multiLogger{out, custom}
+	}
+	w.logger = newLogger(w, out)
+	return nil
+}
+
+// FlushLogs writes the buffered logs of w to GCS, and to Cloud Logging if
+// CloudLogging is set. Run flushes them before it returns, FlushLogs is for
+// callers that need them written sooner.
+func (w *Workflow) FlushLogs() error {
+	if w.stepLogs != nil {
+		if err := w.stepLogs.Flush(); err != nil {
+			return err
+		}
+	}
+	if w.cloudLogWriter != nil {
+		if err := w.cloudLogWriter.Flush(); err != nil {
+			return err
+		}
+	}
+	if w.gcsLogWriter == nil {
+		return nil
+	}
+	return w.gcsLogWriter.Flush()
+}
+
+// closeLogs stops the periodic log flushes of w and flushes its logs a
+// final time. Subworkflows and included workflows share the logs of their
+// parent, only the top level workflow closes them.
+func (w *Workflow) closeLogs() {
+	if w.parent != nil {
+		return
+	}
+	if w.stepLogs != nil {
+		if err := w.stepLogs.Close(); err != nil {
+			w.logger.Errorf("Error writing step logs to GCS: %v", err)
+		}
+	}
+	if w.cloudLogWriter != nil {
+		if err := w.cloudLogWriter.Close(); err != nil {
+			w.logger.Errorf("Error writing logs to Cloud Logging: %v", err)
+		}
+	}
+	if w.gcsLogWriter == nil {
+		return
+	}
+	if err := w.gcsLogWriter.Close(); err != nil {
+		// The GCS log is closed, this only reaches stdout.
+		w.logger.Errorf("Error writing logs to GCS: %v", err)
+	}
 }
 
 // AddDependency creates a dependency of dependent on each dependency. Returns an
 // error if dependent or dependency are not steps in this workflow.
 func (w *Workflow) AddDependency(dependent string, dependencies ...string) error {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
 	if _, ok := w.Steps[dependent]; !ok {
 		return fmt.Errorf("can't create dependency: step %q does not exist", dependent)
 	}
@@ -416,6 +1311,115 @@ func (w *Workflow) AddDependency(dependent string, dependencies ...string) error
 	return nil
 }
 
+// RemoveStep removes the step name and its dependencies. If other steps
+// depend on it, RemoveStep fails unless rewire is set, in which case they
+// depend on the dependencies of name instead. The step is also removed from
+// Entrypoints and SkipSteps.
+func (w *Workflow) RemoveStep(name string, rewire bool) error {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	if _, ok := w.Steps[name]; !ok {
+		return fmt.Errorf("can't remove step %q: step does not exist", name)
+	}
+	w.mergeAllDependsOn()
+	var dependents []string
+	for dependent, deps := range w.Dependencies {
+		if strIn(name, deps) {
+			dependents = append(dependents, dependent)
+		}
+	}
+	if len(dependents) > 0 && !rewire {
+		sort.Strings(dependents)
+		return fmt.Errorf("can't remove step %q: steps %q depend on it", name, dependents)
+	}
+	for _, dependent := range dependents {
+		var deps []string
+		for _, dep := range append(filter(w.Dependencies[dependent], name), w.Dependencies[name]...) {
+			if !strIn(dep, deps) {
+				deps = append(deps, dep)
+			}
+		}
+		w.Dependencies[dependent] = deps
+		if s := w.Steps[dependent]; s != nil && strIn(name, s.DependsOn) {
+			s.DependsOn = filter(s.DependsOn, name)
+		}
+	}
+	delete(w.Steps, name)
+	delete(w.Dependencies, name)
+	for e, steps := range w.Entrypoints {
+		w.Entrypoints[e] = filter(steps, name)
+	}
+	if strIn(name, w.SkipSteps) {
+		w.SkipSteps = filter(w.SkipSteps, name)
+	}
+	return nil
+}
+
+// ReplaceStep replaces the step name by s, keeping its dependencies, those
+// of its DependsOn too, and dependents. s must not be a step of another
+// workflow, create it with &Step{} and set its type, e.g. CreateDisks.
+func (w *Workflow) ReplaceStep(name string, s *Step) error {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	old, ok := w.Steps[name]
+	if !ok {
+		return fmt.Errorf("can't replace step %q: step does not exist", name)
+	}
+	if s.w != nil && s.w != w {
+		return fmt.Errorf("can't replace step %q: replacement is a step of another workflow", name)
+	}
+	w.mergeDependsOn(name, old)
+	s.name = name
+	s.w = w
+	w.Steps[name] = s
+	return nil
+}
+
+// RenameStep renames the step oldName to newName, in Dependencies, the
+// DependsOn of steps, Entrypoints and SkipSteps too. References to its
+// outputs, "${OUTPUTS.oldName.key}", are not renamed.
+func (w *Workflow) RenameStep(oldName, newName string) error {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
+	s, ok := w.Steps[oldName]
+	if !ok {
+		return fmt.Errorf("can't rename step %q: step does not exist", oldName)
+	}
+	if _, ok := w.Steps[newName]; ok {
+		return fmt.Errorf("can't rename step %q to %q: a step already exists with that name", oldName, newName)
+	}
+	if newName == "" {
+		return fmt.Errorf("can't rename step %q: new name is empty", oldName)
+	}
+	s.name = newName
+	delete(w.Steps, oldName)
+	w.Steps[newName] = s
+	if deps, ok := w.Dependencies[oldName]; ok {
+		delete(w.Dependencies, oldName)
+		w.Dependencies[newName] = deps
+	}
+	rename := func(names []string) {
+		for i, n := range names {
+			if n == oldName {
+				names[i] = newName
+			}
+		}
+	}
+	for _, deps := range w.Dependencies {
+		rename(deps)
+	}
+	for _, s := range w.Steps {
+		if s != nil {
+			rename(s.DependsOn)
+		}
+	}
+	for _, steps := range w.Entrypoints {
+		rename(steps)
+	}
+	rename(w.SkipSteps)
+	return nil
+}
+
 // NewIncludedWorkflow instantiates a new workflow with the same resources as the parent.
 func (w *Workflow) NewIncludedWorkflow() *Workflow {
 	iw := New()
@@ -439,7 +1443,11 @@ func (w *Workflow) NewIncludedWorkflowFromFile(file string) (*Workflow, error) {
 
 // NewStep instantiates a new, typeless step for this workflow.
 // The step type must be specified before running this workflow.
+// NewStep and AddDependency are safe to call from concurrent goroutines,
+// e.g. from the populate of steps, until the workflow is validated.
 func (w *Workflow) NewStep(name string) (*Step, error) {
+	w.stepsMx.Lock()
+	defer w.stepsMx.Unlock()
 	if _, ok := w.Steps[name]; ok {
 		return nil, fmt.Errorf("can't create step %q: a step already exists with that name", name)
 	}
@@ -477,21 +1485,160 @@ func (w *Workflow) Print(ctx context.Context) {
 	if err := w.populate(ctx); err != nil {
 		fmt.Println("Error running populate:", err)
 	}
+	w.unescapeSubstitutions()
 
 	b, err := json.MarshalIndent(w, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshalling workflow for printing:", err)
 	}
-	fmt.Println(string(b))
+	fmt.Println(w.root().redactor.redact(string(b)))
 }
 
 func (w *Workflow) run(ctx context.Context) error {
-	return w.traverseDAG(func(s *Step) error {
-		return w.runStep(ctx, s)
+	w.reportWaiting()
+	// Steps still waiting when traversal ends will never run.
+	defer w.skipWaiting()
+	err := w.traverseDAG(func(s *Step) error {
+		queued := s.readyAt
+		if queued.IsZero() {
+			queued = time.Now()
+		}
+		defer w.releaseStepSlot(s)
+		uploaded, srcErr := s.waitSources()
+		if !uploaded || !w.acquireStepSlot(s) {
+			return nil
+		}
+		if !s.start() {
+			return nil
+		}
+		if w.completedBefore(s) {
+			w.logger.Printf("Step %q completed in run %q, skipping.", s.name, w.root().id)
+			s.setState(StepFinished)
+			w.completedMx.Lock()
+			w.completed = append(w.completed, s.name)
+			w.completedMx.Unlock()
+			return nil
+		}
+		if w.skipsStep(s) {
+			w.logger.Printf("Step %q is in SkipSteps, skipping.", s.name)
+			s.setState(StepFinished)
+			w.completedMx.Lock()
+			w.completed = append(w.completed, s.name)
+			w.completedMx.Unlock()
+			return nil
+		}
+		defer w.saveCheckpoint()
+		err := srcErr
+		start := time.Now()
+		if err == nil {
+			// The step starts with its BeforeStep hooks, AfterStep hooks
+			// are only called for steps that started.
+			defer w.runStepHooks(ctx, s, AfterStep)
+			err = w.runStepHooks(ctx, s, BeforeStep)
+		}
+		if err == nil {
+			err = w.runStep(ctx, s)
+		}
+		if err != nil && s.ContinueOnError {
+			s.fail(err)
+			w.recordStepResult(s, queued, start, err)
+			w.logger.Printf("Step %q failed, continuing as ContinueOnError is set: %v", s.name, err)
+			w.continuedFailure(s, err)
+			return nil
+		}
+		if err != nil {
+			s.fail(err)
+			w.stepFailed(s, err)
+		}
+		w.recordStepResult(s, queued, start, err)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-w.cancelChan():
+			// A step returning after cancellation may not have finished its work.
+			s.setState(StepCanceled)
+		default:
+			s.setState(StepFinished)
+			w.completedMx.Lock()
+			w.completed = append(w.completed, s.name)
+			w.completedMx.Unlock()
+		}
+		return nil
 	})
+	// RunAlways steps still run once ctx is canceled.
+	w.runAlways(context.WithoutCancel(ctx))
+	return err
+}
+
+// runAlways runs the RunAlways steps that didn't run as the workflow failed
+// or was canceled first, and those of the workflows of steps that didn't
+// run. They run one at a time, in an order their dependencies on each other
+// allow, whether their dependencies succeeded or not. They stop on a
+// channel of their own, see cancelChan, rather than the closed Cancel. Their
+// errors are logged, the workflow already failed.
+func (w *Workflow) runAlways(ctx context.Context) {
+	w.cancelMx.Lock()
+	w.alwaysCancel = make(chan struct{})
+	w.cancelMx.Unlock()
+	defer func() {
+		w.cancelMx.Lock()
+		w.alwaysCancel = nil
+		w.cancelMx.Unlock()
+	}()
+
+	for _, name := range w.stepOrder() {
+		s := w.Steps[name]
+		if s.State() != StepWaiting {
+			continue
+		}
+		if !s.RunAlways {
+			if cw := s.expandedWorkflow(); cw != nil {
+				cw.runAlways(ctx)
+				if s.SubWorkflow != nil {
+					// SubWorkflow.run, which cleans up, never ran.
+					cw.cleanup()
+				}
+			}
+			continue
+		}
+		queued := time.Now()
+		uploaded, srcErr := s.waitSources()
+		if !uploaded || !w.acquireStepSlot(s) || !s.start() {
+			w.releaseStepSlot(s)
+			continue
+		}
+		w.logger.Printf("Running RunAlways step %q after the workflow stopped", name)
+		start := time.Now()
+		err := srcErr
+		started := err == nil
+		if started {
+			err = w.runStepHooks(ctx, s, BeforeStep)
+		}
+		if err == nil {
+			err = w.runStep(ctx, s)
+		}
+		w.releaseStepSlot(s)
+		w.recordStepResult(s, queued, start, err)
+		if err != nil {
+			s.fail(err)
+			w.logger.Errorf("Error running RunAlways step %q: %v", name, err)
+		} else {
+			s.setState(StepFinished)
+			w.completedMx.Lock()
+			w.completed = append(w.completed, s.name)
+			w.completedMx.Unlock()
+		}
+		if started {
+			w.runStepHooks(ctx, s, AfterStep)
+		}
+	}
 }
 
 func (w *Workflow) runStep(ctx context.Context, s *Step) error {
+	if cw := s.expandedWorkflow(); cw != nil && s.ContinueOnError {
+		defer w.scopeCancel(cw)()
+	}
 	timeout := make(chan struct{})
 	go func() {
 		time.Sleep(s.timeout)
@@ -507,81 +1654,23 @@ func (w *Workflow) runStep(ctx context.Context, s *Step) error {
 	case err := <-e:
 		return err
 	case <-timeout:
-		return fmt.Errorf("step %q did not stop in specified timeout of %s", s.name, s.timeout)
+		select {
+		case <-w.cancelChan():
+			// Don't blame the step for not stopping in time if it was
+			// stopped short by the cancellation.
+			return s.wrapRunError(errors.New(w.cancelCause()))
+		default:
+		}
+		err := fmt.Errorf("did not stop in specified timeout of %s", s.timeout)
+		w.reportStepError(s, errCategoryTimeout, err)
+		return s.wrapRunError(err)
 	}
 }
 
 // Concurrently traverse the DAG, running func f on each step.
 // Return an error if f returns an error on any step.
 func (w *Workflow) traverseDAG(f func(*Step) error) error {
-	// waiting = steps and the dependencies they are waiting for.
-	// running = the currently running steps.
-	// start = map of steps' start channels/semaphores.
-	// done = map of steps' done channels for signaling step completion.
-	waiting := map[string][]string{}
-	var running []string
-	start := map[string]chan error{}
-	done := map[string]chan error{}
-
-	// Setup: channels, copy dependencies.
-	for name := range w.Steps {
-		waiting[name] = w.Dependencies[name]
-		start[name] = make(chan error)
-		done[name] = make(chan error)
-	}
-	// Setup: goroutine for each step. Each waits to be notified to start.
-	for name, s := range w.Steps {
-		go func(name string, s *Step) {
-			// Wait for signal, then run the function. Return any errs.
-			if err := <-start[name]; err != nil {
-				done[name] <- err
-			} else if err := f(s); err != nil {
-				done[name] <- err
-			}
-			close(done[name])
-		}(name, s)
-	}
-
-	// Main signaling logic.
-	for len(waiting) != 0 || len(running) != 0 {
-		// If we got a Cancel signal, kill all waiting steps.
-		// Let running steps finish.
-		select {
-		case <-w.Cancel:
-			waiting = map[string][]string{}
-		default:
-		}
-
-		// Kick off all steps that aren't waiting for anything.
-		for name, deps := range waiting {
-			if len(deps) == 0 {
-				delete(waiting, name)
-				running = append(running, name)
-				close(start[name])
-			}
-		}
-
-		// Sanity check. There should be at least one running step,
-		// but loop back through if there isn't.
-		if len(running) == 0 {
-			continue
-		}
-
-		// Get next finished step. Return the step error if it erred.
-		finished, err := stepsListen(running, done)
-		if err != nil {
-			return err
-		}
-
-		// Remove finished step from other steps' waiting lists.
-		for name, deps := range waiting {
-			waiting[name] = filter(deps, finished)
-		}
-
-		// Remove finished from currently running list.
-		running = filter(running, finished)
-	}
-	return nil
+	return w.executor().Execute(w, f)
 }
 
 // New instantiates a new workflow.
@@ -608,13 +1697,35 @@ func NewFromFile(file string) (*Workflow, error) {
 	return w, nil
 }
 
+// NewFromReader reads and unmarshals a workflow from r. Relative paths in
+// the workflow, such as Sources and the Paths of IncludeWorkflow and
+// SubWorkflow steps, are resolved against workflowDir.
+// Recursively reads subworkflow steps as well.
+func NewFromReader(r io.Reader, workflowDir string) (*Workflow, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	w := New()
+	if err := decodeWorkflow(data, "", workflowDir, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
 func readWorkflow(file string, w *Workflow) error {
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
+	return decodeWorkflow(data, file, filepath.Dir(file), w)
+}
 
-	w.workflowDir, err = filepath.Abs(filepath.Dir(file))
+// decodeWorkflow unmarshals data into w. file names the source of data in
+// syntax errors, if known.
+func decodeWorkflow(data []byte, file, workflowDir string, w *Workflow) error {
+	var err error
+	w.workflowDir, err = filepath.Abs(workflowDir)
 	if err != nil {
 		return err
 	}
@@ -642,7 +1753,11 @@ func readWorkflow(file string, w *Workflow) error {
 			pos = pos - 1
 		}
 
-		return fmt.Errorf("%s: JSON syntax error in line %d: %s \n%s\n%s^", file, line, err, data[start:end], strings.Repeat(" ", pos))
+		sErrMsg := fmt.Sprintf("JSON syntax error in line %d: %s \n%s\n%s^", line, err, data[start:end], strings.Repeat(" ", pos))
+		if file == "" {
+			return errors.New(sErrMsg)
+		}
+		return fmt.Errorf("%s: %s", file, sErrMsg)
 	}
 
 	if w.OAuthPath != "" && !filepath.IsAbs(w.OAuthPath) {
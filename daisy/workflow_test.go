@@ -28,6 +28,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -452,6 +453,46 @@ func TestNewFromFile(t *testing.T) {
 	}
 }
 
+func TestNewFromReader(t *testing.T) {
+	f, err := os.Open("./test_data/test.wf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	got, err := NewFromReader(f, "test_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewFromFile("./test_data/test.wf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.workflowDir != want.workflowDir {
+		t.Errorf("unexpected workflowDir, got: %q, want: %q", got.workflowDir, want.workflowDir)
+	}
+	if got.OAuthPath != want.OAuthPath {
+		t.Errorf("unexpected OAuthPath, got: %q, want: %q", got.OAuthPath, want.OAuthPath)
+	}
+	if len(got.Steps) != len(want.Steps) {
+		t.Errorf("unexpected number of steps, got: %d, want: %d", len(got.Steps), len(want.Steps))
+	}
+	if sw := got.Steps["sub-workflow"].SubWorkflow.w; sw == nil || sw.parent != got || len(sw.Steps) == 0 {
+		t.Error("subworkflow was not read relative to workflowDir")
+	}
+	if iw := got.Steps["include-workflow"].IncludeWorkflow.w; iw == nil || len(iw.Steps) == 0 {
+		t.Error("included workflow was not read relative to workflowDir")
+	}
+
+	wantErr := "JSON syntax error in line 1: invalid character 'v' looking for beginning of value \n{\"test\": value}\n         ^"
+	if _, err := NewFromReader(strings.NewReader(`{"test": value}`), ""); err == nil {
+		t.Error("expected error, got nil")
+	} else if err.Error() != wantErr {
+		t.Errorf("did not get expected error from NewFromReader():\ngot: %q\nwant: %q", err.Error(), wantErr)
+	}
+}
+
 func TestNewStep(t *testing.T) {
 	w := &Workflow{}
 
@@ -473,6 +514,82 @@ func TestNewStep(t *testing.T) {
 	}
 }
 
+func TestNewStepConcurrent(t *testing.T) {
+	w := &Workflow{}
+	if _, err := w.NewStep("root"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("s%d", i)
+			if _, err := w.NewStep(name); err != nil {
+				t.Errorf("unexpected error creating step %q: %v", name, err)
+				return
+			}
+			if err := w.AddDependency(name, "root"); err != nil {
+				t.Errorf("unexpected error adding dependency of step %q: %v", name, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(w.Steps) != 21 || len(w.Dependencies) != 20 {
+		t.Errorf("unexpected number of steps and dependencies, got: %d and %d, want: 21 and 20", len(w.Steps), len(w.Dependencies))
+	}
+}
+
+func TestPopulateAddedSteps(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	var populated []string
+	var mx sync.Mutex
+	record := func(ctx context.Context, s *Step) error {
+		mx.Lock()
+		defer mx.Unlock()
+		populated = append(populated, s.name)
+		return nil
+	}
+	// The step adds steps from concurrent goroutines while it populates.
+	w.Steps = map[string]*Step{"gen": {testType: &mockStep{populateImpl: func(ctx context.Context, s *Step) error {
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ns, err := s.w.NewStep(fmt.Sprintf("gen-%d", i))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ns.testType = &mockStep{populateImpl: record}
+				if err := s.w.AddDependency(ns.name, "gen"); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		return nil
+	}}}}
+
+	if err := w.populate(ctx); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+
+	sort.Strings(populated)
+	if diff := pretty.Compare(populated, []string{"gen-0", "gen-1", "gen-2"}); diff != "" {
+		t.Errorf("populated steps do not match expectation: (-got +want)\n%s", diff)
+	}
+	for name, s := range w.Steps {
+		if s.timeout != 10*time.Minute {
+			t.Errorf("step %q not populated, timeout: %s", name, s.timeout)
+		}
+	}
+}
+
 func TestPopulate(t *testing.T) {
 	ctx := context.Background()
 	client, err := newTestGCSClient()
@@ -501,7 +618,7 @@ func TestPopulate(t *testing.T) {
 	got.Zone = "wf-zone"
 	got.Project = "bar-project"
 	got.OAuthPath = tf
-	got.logger = log.New(ioutil.Discard, "", 0)
+	got.logger = newLogger(got, &textLogger{log.New(ioutil.Discard, "", 0)})
 	got.Vars = map[string]vars{
 		"bucket":    {Value: "wf-bucket", Required: true},
 		"step_name": {Value: "step1"},
@@ -525,6 +642,9 @@ func TestPopulate(t *testing.T) {
 	if err := got.populate(ctx); err != nil {
 		t.Fatalf("error populating workflow: %v", err)
 	}
+	if got.autovars["REGION"] != "wf" {
+		t.Errorf("unexpected REGION autovar: %q", got.autovars["REGION"])
+	}
 
 	want := &Workflow{
 		Name:       "wf-name",
@@ -533,6 +653,7 @@ func TestPopulate(t *testing.T) {
 		Project:    "bar-project",
 		OAuthPath:  tf,
 		id:         got.id,
+		started:    got.started,
 		gcsLogging: true,
 		Cancel:     got.Cancel,
 		Vars: map[string]vars{
@@ -584,7 +705,7 @@ func TestPopulate(t *testing.T) {
 	}
 
 	stepPopErr = errors.New("error")
-	if err := got.populate(ctx); err != stepPopErr {
+	if err, ok := got.populate(ctx).(*StepError); !ok || err.Phase != PhasePopulate || err.Err != stepPopErr {
 		t.Errorf("did not get proper step populate error: %v != %v", err, stepPopErr)
 	}
 }
@@ -858,6 +979,55 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestSyncedWriterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	l := &syncedWriter{out: &lockedWriter{out: &buf}, size: defaultLogBufferSize}
+	logged := func() string {
+		l.flushMx.Lock()
+		defer l.flushMx.Unlock()
+		return buf.String()
+	}
+	l.flushEvery(time.Millisecond)
+	l.Write([]byte("periodic\n"))
+	for i := 0; i < 100 && !strings.Contains(logged(), "periodic"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := logged(); got != "periodic\n" {
+		t.Errorf("periodic flush did not write logs, got: %q", got)
+	}
+
+	l.Write([]byte("final\n"))
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := logged(); got != "periodic\nfinal\n" {
+		t.Errorf("Close did not flush logs, got: %q", got)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("unexpected error closing twice: %v", err)
+	}
+}
+
+func TestPopulateLoggerErrors(t *testing.T) {
+	tests := []struct {
+		desc     string
+		interval string
+		size     int
+	}{
+		{"bad interval case", "soon", 0},
+		{"zero interval case", "0s", 0},
+		{"negative size case", "", -1},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.LogFlushInterval = tt.interval
+		w.LogBufferSize = tt.size
+		if err := w.populateLogger(context.Background()); err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+	}
+}
+
 func TestRunStepTimeout(t *testing.T) {
 	w := testWorkflow()
 	s, _ := w.NewStep("test")
@@ -866,8 +1036,354 @@ func TestRunStepTimeout(t *testing.T) {
 		time.Sleep(1 * time.Second)
 		return nil
 	}}
-	want := `step "test" did not stop in specified timeout of 1ns`
+	want := `step "test" run error: did not stop in specified timeout of 1ns`
 	if err := w.runStep(context.Background(), s); err == nil || err.Error() != want {
 		t.Errorf("did not get expected error, got: %q, want: %q", err.Error(), want)
 	}
 }
+
+func TestRunStepTimeoutAfterFailure(t *testing.T) {
+	w := testWorkflow()
+	failed, _ := w.NewStep("failed")
+	w.stepFailed(failed, errors.New("fail"))
+	s, _ := w.NewStep("test")
+	s.timeout = 1 * time.Nanosecond
+	s.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) error {
+		time.Sleep(1 * time.Second)
+		return nil
+	}}
+	want := `step "test" run error: canceled due to failure of step "failed"`
+	if err := w.runStep(context.Background(), s); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+	if got := w.getCancelReason(); got != "fail" {
+		t.Errorf("unexpected cancel reason, got: %q, want: %q", got, "fail")
+	}
+}
+
+func TestRunAlways(t *testing.T) {
+	tests := []struct {
+		desc      string
+		fail      bool
+		wantRan   []string
+		shouldErr bool
+	}{
+		{"normal case", false, []string{"s0", "s1", "s2", "s3"}, false},
+		{"failure case", true, []string{"s0", "s2", "s3"}, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		var ran []string
+		var mx sync.Mutex
+		runImpl := func(ctx context.Context, s *Step) error {
+			mx.Lock()
+			ran = append(ran, s.name)
+			mx.Unlock()
+			if s.name == "s0" && tt.fail {
+				return errors.New("fail")
+			}
+			return nil
+		}
+		w.Steps = map[string]*Step{
+			"s0": {name: "s0", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+			"s1": {name: "s1", w: w, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+			"s2": {name: "s2", w: w, timeout: time.Minute, RunAlways: true, testType: &mockStep{runImpl: runImpl}},
+			"s3": {name: "s3", w: w, timeout: time.Minute, RunAlways: true, testType: &mockStep{runImpl: runImpl}},
+		}
+		w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s1"}, "s3": {"s2"}}
+
+		err := w.run(context.Background())
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(ran, tt.wantRan); diff != "" {
+			t.Errorf("%s: steps run do not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if got := w.Steps["s3"].State(); got != StepFinished {
+			t.Errorf("%s: unexpected state of RunAlways step, got: %s, want: %s", tt.desc, got, StepFinished)
+		}
+	}
+}
+
+func TestRunAlwaysNotCanceled(t *testing.T) {
+	w := testWorkflow()
+	var stoppedEarly bool
+	w.Steps = map[string]*Step{
+		"fail": {name: "fail", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"always": {name: "always", w: w, timeout: time.Minute, RunAlways: true, testType: &mockStep{runImpl: func(_ context.Context, s *Step) error {
+			select {
+			case <-s.w.cancelChan():
+				stoppedEarly = true
+			case <-time.After(10 * time.Millisecond):
+			}
+			return nil
+		}}},
+	}
+	w.Dependencies = map[string][]string{"always": {"fail"}}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("should have returned an error")
+	}
+	if stoppedEarly {
+		t.Error("RunAlways step ran with the workflow's closed Cancel")
+	}
+	if got := w.Steps["always"].State(); got != StepFinished {
+		t.Errorf("unexpected state of RunAlways step, got: %s, want: %s", got, StepFinished)
+	}
+}
+
+func TestRunAlwaysIncludedWorkflow(t *testing.T) {
+	w := testWorkflow()
+	iw := w.NewIncludedWorkflow()
+	iw.parent = w
+	iw.logger = w.logger
+	var ran []string
+	var mx sync.Mutex
+	runImpl := func(_ context.Context, s *Step) error {
+		mx.Lock()
+		defer mx.Unlock()
+		ran = append(ran, s.name)
+		return nil
+	}
+	iw.Steps = map[string]*Step{
+		"inner":        {name: "inner", w: iw, timeout: time.Minute, testType: &mockStep{runImpl: runImpl}},
+		"inner-always": {name: "inner-always", w: iw, timeout: time.Minute, RunAlways: true, testType: &mockStep{runImpl: runImpl}},
+	}
+	w.Steps = map[string]*Step{
+		"fail": {name: "fail", w: w, timeout: time.Minute, testType: &mockStep{runImpl: func(context.Context, *Step) error {
+			return errors.New("fail")
+		}}},
+		"include": {name: "include", w: w, timeout: time.Minute, IncludeWorkflow: &IncludeWorkflow{w: iw}},
+	}
+	w.Dependencies = map[string][]string{"include": {"fail"}}
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("should have returned an error")
+	}
+	if diff := pretty.Compare(ran, []string{"inner-always"}); diff != "" {
+		t.Errorf("steps run do not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestRemoveStep(t *testing.T) {
+	newWorkflow := func() *Workflow {
+		return &Workflow{
+			Steps:        map[string]*Step{"a": {name: "a"}, "b": {name: "b"}, "c": {name: "c"}, "d": {name: "d", DependsOn: []string{"b"}}},
+			Dependencies: map[string][]string{"b": {"a"}, "c": {"b"}, "d": {"a"}},
+			Entrypoints:  map[string][]string{"e": {"b", "c"}},
+			SkipSteps:    []string{"b"},
+		}
+	}
+
+	w := newWorkflow()
+	if err := w.RemoveStep("b", false); err == nil {
+		t.Error("removing a step with dependents should have erred without rewire")
+	}
+	if err := w.RemoveStep("x", true); err == nil {
+		t.Error("removing a nonexistent step should have erred")
+	}
+	if err := w.RemoveStep("c", false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := w.Steps["c"]; ok {
+		t.Error("step c was not removed")
+	}
+	if err := w.RemoveStep("b", false); err == nil {
+		t.Error("removing a step with DependsOn dependents should have erred without rewire")
+	}
+
+	w = newWorkflow()
+	if err := w.RemoveStep("b", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantDeps := map[string][]string{"c": {"a"}, "d": {"a"}}
+	if diff := pretty.Compare(w.Dependencies, wantDeps); diff != "" {
+		t.Errorf("incorrect dependencies: (-got,+want)\n%s", diff)
+	}
+	wantEntrypoints := map[string][]string{"e": {"c"}}
+	if diff := pretty.Compare(w.Entrypoints, wantEntrypoints); diff != "" {
+		t.Errorf("incorrect entrypoints: (-got,+want)\n%s", diff)
+	}
+	if len(w.Steps["d"].DependsOn) != 0 {
+		t.Errorf("removed step still in DependsOn: %q", w.Steps["d"].DependsOn)
+	}
+	if len(w.SkipSteps) != 0 {
+		t.Errorf("removed step still in SkipSteps: %q", w.SkipSteps)
+	}
+}
+
+func TestReplaceStep(t *testing.T) {
+	w := &Workflow{Steps: map[string]*Step{"a": {name: "a", DependsOn: []string{"c"}}}, Dependencies: map[string][]string{"a": {"b"}}}
+
+	s := &Step{CreateDisks: &CreateDisks{}}
+	if err := w.ReplaceStep("a", s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Steps["a"] != s || s.name != "a" || s.w != w {
+		t.Errorf("step was not replaced: %+v", w.Steps["a"])
+	}
+	if diff := pretty.Compare(w.Dependencies, map[string][]string{"a": {"b", "c"}}); diff != "" {
+		t.Errorf("dependencies, with the DependsOn of the replaced step, not kept: (-got,+want)\n%s", diff)
+	}
+
+	if err := w.ReplaceStep("x", &Step{}); err == nil {
+		t.Error("replacing a nonexistent step should have erred")
+	}
+	if err := w.ReplaceStep("a", &Step{w: &Workflow{}}); err == nil {
+		t.Error("replacing with a step of another workflow should have erred")
+	}
+}
+
+func TestRenameStep(t *testing.T) {
+	w := &Workflow{
+		Steps:        map[string]*Step{"a": {name: "a"}, "b": {name: "b"}, "c": {name: "c", DependsOn: []string{"b"}}},
+		Dependencies: map[string][]string{"b": {"a"}, "c": {"a", "b"}},
+		Entrypoints:  map[string][]string{"e": {"b"}},
+		SkipSteps:    []string{"b"},
+	}
+
+	if err := w.RenameStep("b", "c"); err == nil {
+		t.Error("renaming to an existing step should have erred")
+	}
+	if err := w.RenameStep("x", "y"); err == nil {
+		t.Error("renaming a nonexistent step should have erred")
+	}
+	if err := w.RenameStep("b", "bb"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, ok := w.Steps["bb"]; !ok || s.name != "bb" {
+		t.Errorf("step was not renamed: %v", w.Steps)
+	}
+	if _, ok := w.Steps["b"]; ok {
+		t.Error("old step name still in Steps")
+	}
+	wantDeps := map[string][]string{"bb": {"a"}, "c": {"a", "bb"}}
+	if diff := pretty.Compare(w.Dependencies, wantDeps); diff != "" {
+		t.Errorf("incorrect dependencies: (-got,+want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.Entrypoints, map[string][]string{"e": {"bb"}}); diff != "" {
+		t.Errorf("incorrect entrypoints: (-got,+want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.Steps["c"].DependsOn, []string{"bb"}); diff != "" {
+		t.Errorf("incorrect DependsOn: (-got,+want)\n%s", diff)
+	}
+	if diff := pretty.Compare(w.SkipSteps, []string{"bb"}); diff != "" {
+		t.Errorf("incorrect SkipSteps: (-got,+want)\n%s", diff)
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"a": {WaitForInstancesSignal: &WaitForInstancesSignal{}},
+		"b": {WaitForInstancesSignal: &WaitForInstancesSignal{}, DependsOn: []string{"a"}},
+		"c": {WaitForInstancesSignal: &WaitForInstancesSignal{}, DependsOn: []string{"a", "b"}},
+	}
+	w.Dependencies = map[string][]string{"c": {"a"}}
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+
+	want := map[string][]string{"b": {"a"}, "c": {"a", "b"}}
+	if diff := pretty.Compare(w.Dependencies, want); diff != "" {
+		t.Errorf("incorrect dependencies: (-got,+want)\n%s", diff)
+	}
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	var ran []int
+	var mx sync.Mutex
+	mockRun := func(i int) func(context.Context, *Step) error {
+		return func(_ context.Context, s *Step) error {
+			mx.Lock()
+			ran = append(ran, i)
+			mx.Unlock()
+			if i == 0 {
+				cancel(errors.New("operator abort"))
+				<-s.w.Cancel
+			}
+			return nil
+		}
+	}
+	w := testTraverseWorkflow(mockRun)
+	delete(w.Steps, "s4")
+
+	want := "workflow canceled: operator abort"
+	if err := w.Run(ctx); err == nil || err.Error() != want {
+		t.Errorf("unexpected error, got: %v, want: %q", err, want)
+	}
+	if diff := pretty.Compare(ran, []int{0}); diff != "" {
+		t.Errorf("steps ran after ctx was canceled: (-got +want)\n%s", diff)
+	}
+	if got := w.getCancelReason(); got != "operator abort" {
+		t.Errorf("unexpected cancel reason, got: %q, want: %q", got, "operator abort")
+	}
+
+	// Steps see ctx canceled, the cause is returned rather than their error.
+	ctx, cancel = context.WithCancelCause(context.Background())
+	w = testTraverseWorkflow(func(i int) func(context.Context, *Step) error {
+		return func(stepCtx context.Context, _ *Step) error {
+			if i != 0 {
+				return nil
+			}
+			cancel(errors.New("operator abort"))
+			<-stepCtx.Done()
+			return stepCtx.Err()
+		}
+	})
+	delete(w.Steps, "s4")
+	if err := w.Run(ctx); err == nil || err.Error() != want {
+		t.Errorf("unexpected error, got: %v, want: %q", err, want)
+	}
+
+	// Runs that finish before ctx is canceled succeed.
+	ctx, cancel = context.WithCancelCause(context.Background())
+	w = testTraverseWorkflow(func(int) func(context.Context, *Step) error {
+		return func(context.Context, *Step) error { return nil }
+	})
+	if err := w.Run(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cancel(nil)
+}
+
+func TestRunWorkflowTimeout(t *testing.T) {
+	w := testTraverseWorkflow(func(i int) func(context.Context, *Step) error {
+		return func(_ context.Context, s *Step) error {
+			if i == 0 {
+				<-s.w.Cancel
+			}
+			return nil
+		}
+	})
+	w.Timeout = "10ms"
+
+	want := "workflow canceled: workflow timed out after 10ms, steps still running: s0"
+	if err := w.Run(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("unexpected error, got: %v, want: %q", err, want)
+	}
+	if got, want := w.StepStates()["s1"], StepSkipped; got != want {
+		t.Errorf("unexpected state of s1, got: %s, want: %s", got, want)
+	}
+
+	w = testTraverseWorkflow(func(int) func(context.Context, *Step) error {
+		return func(context.Context, *Step) error { return nil }
+	})
+	w.Timeout = "1h"
+	if err := w.Run(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	w = testWorkflow()
+	w.Steps = map[string]*Step{"s": {testType: &mockStep{}}}
+	w.Timeout = "bad"
+	if err := w.Run(context.Background()); err == nil {
+		t.Error("expected error for bad Timeout")
+	}
+}
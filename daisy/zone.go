@@ -16,26 +16,29 @@ package daisy
 
 import (
 	"fmt"
-	"sync"
+	"regexp"
+	"strings"
 
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
-var zones struct {
-	valid []string
-	mu    sync.Mutex
-}
+var zoneURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[1]s)$`, rfc1035))
+
+var zones = &catalogCache{}
 
 func checkZone(client compute.Client, project, zone string) error {
-	zones.mu.Lock()
-	defer zones.mu.Unlock()
 	url := fmt.Sprintf("/project/%s/zone/%s", project, zone)
-	if strIn(url, zones.valid) {
-		return nil
-	}
-	if _, err := client.GetZone(project, zone); err != nil {
+	return zones.check(client, url, func() error {
+		_, err := client.GetZone(project, zone)
 		return err
+	})
+}
+
+// getRegionFromZone returns the region a zone belongs to, e.g. "us-central1"
+// for "us-central1-a".
+func getRegionFromZone(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i != -1 {
+		return zone[:i]
 	}
-	zones.valid = append(zones.valid, url)
-	return nil
+	return zone
 }
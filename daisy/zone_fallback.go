@@ -0,0 +1,162 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// zoneExhaustedCode prefixes the error codes GCE returns when a zone has no
+// capacity left for a resource, e.g. ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS.
+const zoneExhaustedCode = "ZONE_RESOURCE_POOL_EXHAUSTED"
+
+// isZoneExhausted reports whether err is a zone capacity error, returned by
+// the insert call or by the failed operation.
+func isZoneExhausted(err error) bool {
+	if err == nil {
+		return false
+	}
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		for _, e := range apiErr.Errors {
+			if strings.HasPrefix(e.Reason, zoneExhaustedCode) {
+				return true
+			}
+		}
+	}
+	return strings.Contains(err.Error(), zoneExhaustedCode)
+}
+
+// fallbackZones returns the FallbackZones of the nearest workflow setting
+// them, to try in order when zone is exhausted. The zone itself and zones
+// outside its region, where regional resources such as subnetworks and
+// addresses aren't available, are left out.
+func (w *Workflow) fallbackZones(zone string) []string {
+	var fzs []string
+	for wf := w; wf != nil; wf = wf.parent {
+		if wf.FallbackZones != nil {
+			fzs = wf.FallbackZones
+			break
+		}
+	}
+	var zs []string
+	for _, z := range fzs {
+		if z != zone && getRegionFromZone(z) == getRegionFromZone(zone) {
+			zs = append(zs, z)
+		}
+	}
+	return zs
+}
+
+// rezone rewrites a zonal resource URL in zone from to the same resource in
+// zone to. Other URLs are returned unchanged.
+func rezone(url, from, to string) string {
+	return strings.Replace(url, "zones/"+from+"/", "zones/"+to+"/", 1)
+}
+
+// createInZones calls create with zone and, while it fails because the zone
+// is exhausted, with the fallback zones of zone. move is called before each
+// fallback to rewrite the zone-dependent fields of the resource, falling
+// back stops if it returns false.
+func (w *Workflow) createInZones(s *Step, name, zone string, move func(from, to string) bool, create func(zone string) error) error {
+	err := create(zone)
+	for _, z := range w.fallbackZones(zone) {
+		if !isZoneExhausted(err) || !move(zone, z) {
+			break
+		}
+		w.logger.StepInfo(s, "zone %q is exhausted, creating %q in fallback zone %q.", zone, name, z)
+		zone = z
+		err = create(zone)
+	}
+	return err
+}
+
+// relink updates the link of the resource known by name, e.g. when it is
+// created in a fallback zone.
+func (rm *baseResourceMap) relink(name, link string) {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+	if r, ok := rm.m[name]; ok {
+		r.link = link
+	}
+}
+
+// moveZone moves the instance from zone from to zone to, see setZone.
+// Instances attaching existing disks in zone from can't move.
+func (c *CreateInstance) moveZone(w *Workflow, from, to string) bool {
+	for _, d := range c.Disks {
+		if d.InitializeParams == nil && namedSubexp(diskURLRgx, d.Source)["zone"] == from {
+			return false
+		}
+	}
+	c.setZone(w, to)
+	return true
+}
+
+// setZone rewrites the zone-dependent fields of the instance, and the links
+// of it and of the disks it creates, to zone.
+func (c *CreateInstance) setZone(w *Workflow, zone string) {
+	from := c.Zone
+	c.Zone = zone
+	c.MachineType = rezone(c.MachineType, from, zone)
+	for _, a := range c.GuestAccelerators {
+		a.AcceleratorType = rezone(a.AcceleratorType, from, zone)
+	}
+	for _, d := range c.Disks {
+		if p := d.InitializeParams; p != nil {
+			p.DiskType = rezone(p.DiskType, from, zone)
+			if r, ok := disks[w].get(p.DiskName); ok {
+				disks[w].relink(p.DiskName, rezone(r.link, from, zone))
+			}
+		}
+	}
+	if r, ok := instances[w].get(c.daisyName); ok {
+		instances[w].relink(c.daisyName, rezone(r.link, from, zone))
+	}
+}
+
+// followDisks moves the instance of step s to the fallback zone its existing disks
+// were created in, if they were created in one.
+func (c *CreateInstance) followDisks(s *Step) {
+	w := s.w
+	for _, d := range c.Disks {
+		z := namedSubexp(diskURLRgx, d.Source)["zone"]
+		if d.InitializeParams != nil || z == "" || z == c.Zone {
+			continue
+		}
+		for _, fz := range w.fallbackZones(c.Zone) {
+			if fz == z {
+				w.logger.StepInfo(s, "creating instance %q in zone %q along with its disk %q.", c.Name, z, d.Source)
+				c.setZone(w, z)
+				return
+			}
+		}
+	}
+}
+
+// moveZone rewrites the zone-dependent fields of the disk, and its link,
+// from zone from to zone to. Regional disks don't move.
+func (cd *CreateDisk) moveZone(w *Workflow, from, to string) bool {
+	if cd.region != "" {
+		return false
+	}
+	cd.Zone = to
+	cd.Type = rezone(cd.Type, from, to)
+	if r, ok := disks[w].get(cd.daisyName); ok {
+		disks[w].relink(cd.daisyName, rezone(r.link, from, to))
+	}
+	return true
+}
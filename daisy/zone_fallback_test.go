@@ -0,0 +1,170 @@
+//  Copyright 2017 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/kylelemons/godebug/pretty"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsZoneExhausted(t *testing.T) {
+	tests := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{"nil case", nil, false},
+		{"other error case", errors.New("operation failed: Code: QUOTA_EXCEEDED"), false},
+		{"operation error case", errors.New("operation failed: Code: ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS, Message: no capacity"), true},
+		{"API error case", &googleapi.Error{Code: 503, Errors: []googleapi.ErrorItem{{Reason: "ZONE_RESOURCE_POOL_EXHAUSTED"}}}, true},
+	}
+	for _, tt := range tests {
+		if got := isZoneExhausted(tt.err); got != tt.want {
+			t.Errorf("%s: isZoneExhausted() = %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackZones(t *testing.T) {
+	w := testWorkflow()
+	child := testWorkflow()
+	child.parent = w
+	w.FallbackZones = []string{"us-central1-a", "us-central1-b", "us-east1-b"}
+
+	if diff := pretty.Compare(child.fallbackZones("us-central1-a"), []string{"us-central1-b"}); diff != "" {
+		t.Errorf("inherited fallback zones don't match expectation: (-got +want)\n%s", diff)
+	}
+	child.FallbackZones = []string{}
+	if got := child.fallbackZones("us-central1-a"); len(got) != 0 {
+		t.Errorf("child should have no fallback zones, got: %q", got)
+	}
+}
+
+func TestCreateInstancesZoneFallback(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.FallbackZones = []string{"test-zone2", "test-zone3"}
+	s := &Step{w: w}
+	var gotZones []string
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, z string, _ *compute.Instance) error {
+		gotZones = append(gotZones, z)
+		if z != "test-zone3" {
+			return fmt.Errorf("operation failed: Code: %s", zoneExhaustedCode)
+		}
+		return nil
+	}
+	link := func(zone, kind, name string) string {
+		return fmt.Sprintf("projects/%s/zones/%s/%s/%s", testProject, zone, kind, name)
+	}
+	instances[w].m = map[string]*resource{"i": {real: "i", link: link(testZone, "instances", "i")}}
+	disks[w].m = map[string]*resource{"i": {real: "i", link: link(testZone, "disks", "i")}}
+
+	ci := &CreateInstance{
+		daisyName: "i",
+		Project:   testProject,
+		Zone:      testZone,
+		Instance: compute.Instance{
+			Name:        "i",
+			MachineType: link(testZone, "machineTypes", "n1-standard-1"),
+			Disks:       []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "i", DiskType: link(testZone, "diskTypes", "pd-ssd")}}},
+		},
+	}
+	if err := (&CreateInstances{ci}).run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(gotZones, []string{testZone, "test-zone2", "test-zone3"}); diff != "" {
+		t.Errorf("instance not created in the fallback zones in order: (-got +want)\n%s", diff)
+	}
+	if want := link("test-zone3", "machineTypes", "n1-standard-1"); ci.MachineType != want {
+		t.Errorf("MachineType not rewritten, got: %q, want: %q", ci.MachineType, want)
+	}
+	if want := link("test-zone3", "diskTypes", "pd-ssd"); ci.Disks[0].InitializeParams.DiskType != want {
+		t.Errorf("DiskType not rewritten, got: %q, want: %q", ci.Disks[0].InitializeParams.DiskType, want)
+	}
+	if r, _ := instances[w].get("i"); r.link != link("test-zone3", "instances", "i") || !r.created {
+		t.Errorf("instance link not rewritten or not created: %+v", r)
+	}
+	if r, _ := disks[w].get("i"); r.link != link("test-zone3", "disks", "i") {
+		t.Errorf("disk link not rewritten, got: %q", r.link)
+	}
+
+	// Instances attaching existing disks don't move.
+	gotZones = nil
+	disks[w].m["d"] = &resource{real: "d", link: link(testZone, "disks", "d")}
+	ci = &CreateInstance{daisyName: "i2", Project: testProject, Zone: testZone, Instance: compute.Instance{Name: "i2", Disks: []*compute.AttachedDisk{{Source: "d"}}}}
+	if err := (&CreateInstances{ci}).run(ctx, s); !isZoneExhausted(err) {
+		t.Errorf("expected zone exhausted error, got: %v", err)
+	}
+	if diff := pretty.Compare(gotZones, []string{testZone}); diff != "" {
+		t.Errorf("instance attaching an existing disk fell back: (-got +want)\n%s", diff)
+	}
+
+	// Instances follow their disks to fallback zones.
+	gotZones = nil
+	disks[w].m["d"].link = link("test-zone3", "disks", "d")
+	ci = &CreateInstance{daisyName: "i3", Project: testProject, Zone: testZone, Instance: compute.Instance{Name: "i3", Disks: []*compute.AttachedDisk{{Source: "d"}}}}
+	if err := (&CreateInstances{ci}).run(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(gotZones, []string{"test-zone3"}); diff != "" {
+		t.Errorf("instance didn't follow its disk: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCreateDisksZoneFallback(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	var gotZones []string
+	w.ComputeClient = &daisyCompute.TestClient{CreateDiskFn: func(_, z string, _ *compute.Disk) error {
+		gotZones = append(gotZones, z)
+		if z == testZone {
+			return fmt.Errorf("operation failed: Code: %s", zoneExhaustedCode)
+		}
+		return nil
+	}}
+	disks[w].m = map[string]*resource{"d": {real: "d", link: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)}}
+	newCD := func() *CreateDisk {
+		return &CreateDisk{daisyName: "d", Project: testProject, Zone: testZone, Disk: compute.Disk{Name: "d", Type: fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)}}
+	}
+
+	// No fallback zones.
+	if err := (&CreateDisks{newCD()}).run(ctx, s); !isZoneExhausted(err) {
+		t.Errorf("expected zone exhausted error, got: %v", err)
+	}
+
+	gotZones = nil
+	w.FallbackZones = []string{"other-zone", "test-zone2"}
+	cd := newCD()
+	if err := (&CreateDisks{cd}).run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := pretty.Compare(gotZones, []string{testZone, "test-zone2"}); diff != "" {
+		t.Errorf("disk not created in the fallback zone: (-got +want)\n%s", diff)
+	}
+	if want := fmt.Sprintf("projects/%s/zones/test-zone2/diskTypes/pd-ssd", testProject); cd.Type != want {
+		t.Errorf("Type not rewritten, got: %q, want: %q", cd.Type, want)
+	}
+	if r, _ := disks[w].get("d"); r.link != fmt.Sprintf("projects/%s/zones/test-zone2/disks/d", testProject) {
+		t.Errorf("disk link not rewritten, got: %q", r.link)
+	}
+}